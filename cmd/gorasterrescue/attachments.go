@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// attachTableSuffix names the table holding the attachments of a table, as
+// ArcGIS creates it when attachments are enabled.
+const attachTableSuffix = "__ATTACH"
+
+// rowValue returns the value of the field called name, ignoring case, which
+// attachment tables do not keep consistent.
+func rowValue(row *gdb.Row, name string) interface{} {
+	for i := range row.Fields {
+		if strings.EqualFold(row.Fields[i].Name, name) {
+			return row.Values[i]
+		}
+	}
+	return nil
+}
+
+// globalIDs maps the GlobalID of every readable row of a table to its object
+// id.
+func globalIDs(ctx context.Context, gdbFilePath string, tableName string) (map[gdb.GUID]int, error) {
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+
+	ids := make(map[gdb.GUID]int)
+	field := -1
+	for i := range bt.Fields {
+		if bt.Fields[i].Type == 11 {
+			field = i
+		}
+	}
+	if field < 0 {
+		return ids, nil
+	}
+
+	rows := bt.Rows()
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		if g, ok := row.Values[field].(gdb.GUID); ok {
+			ids[g] = row.FID
+		}
+	}
+	return ids, nil
+}
+
+// attachmentName makes the file name of an attachment: ATT_NAME without any
+// directories, given an extension from the content type if it has none, and
+// prefixed with the attachment id if the directory already has one by that
+// name.
+func attachmentName(name string, contentType string, id int, used map[string]bool) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		name = "attachment"
+	}
+	if filepath.Ext(name) == "" {
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	if used[strings.ToLower(name)] {
+		name = strconv.Itoa(id) + "_" + name
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// exportAttachments writes the attachments of the table called name, if it
+// has an attachment table, into dir/<object id>/<file>, matching them to
+// their parents through REL_GLOBALID. Attachments whose parent is gone go
+// under the GlobalID instead, and attachment rows that cannot be read are
+// reported on stderr. It returns the number of files written.
+func exportAttachments(ctx context.Context, gdbFilePath string, mt *gdb.MasterTable, name string, dir string) (int, error) {
+	attachID := mt.TableID(name + attachTableSuffix)
+	if attachID == 0 {
+		return 0, nil
+	}
+	// Without the parents' GlobalIDs every attachment goes under its
+	// REL_GLOBALID, which is still better than losing it.
+	parents, err := globalIDs(ctx, gdbFilePath, gdb.TableFileName(mt.TableID(name)))
+	if err != nil {
+		check(ctx.Err())
+		slog.Warn("cannot match attachments to features", "table", name, "err", err)
+	}
+
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, gdb.TableFileName(attachID))
+	if err != nil {
+		return 0, err
+	}
+	defer bt.Close()
+
+	used := make(map[string]map[string]bool)
+	n := 0
+	rows := bt.Rows()
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			check(ctx.Err())
+			lost(ctx)
+			slog.Warn("skipping attachment", "err", err)
+			continue
+		}
+
+		data, _ := rowValue(row, "DATA").([]byte)
+		if data == nil {
+			continue
+		}
+		parent := "unknown"
+		if g, ok := rowValue(row, "REL_GLOBALID").(gdb.GUID); ok {
+			parent = g.String()
+			if fid, ok := parents[g]; ok {
+				parent = strconv.Itoa(fid)
+			}
+		}
+		attName, _ := rowValue(row, "ATT_NAME").(string)
+		contentType, _ := rowValue(row, "CONTENT_TYPE").(string)
+
+		if used[parent] == nil {
+			used[parent] = make(map[string]bool)
+		}
+		featureDir := filepath.Join(dir, parent)
+		check(os.MkdirAll(featureDir, 0755))
+		path := filepath.Join(featureDir, attachmentName(attName, contentType, row.FID, used[parent]))
+		check(os.WriteFile(path, data, 0644))
+		n++
+	}
+	return n, nil
+}
+
+// printAttachments exports the attachments of name next to its export in
+// out and reports where they went.
+func printAttachments(ctx context.Context, gdbFilePath string, mt *gdb.MasterTable, name string, out string) {
+	dir := filepath.Join(out, name+"_attachments")
+	n, err := exportAttachments(ctx, gdbFilePath, mt, name, dir)
+	if err != nil {
+		check(ctx.Err())
+		lost(ctx)
+		slog.Warn("skipping attachments", "table", name, "err", err)
+	}
+	if n > 0 {
+		fmt.Printf("%s (%d attachments)\n", dir, n)
+	}
+}
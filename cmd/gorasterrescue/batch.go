@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// BatchResult is what batch made of one geodatabase.
+type BatchResult struct {
+	GDB      string
+	Output   string // the directory its datasets were written to
+	Rasters  int
+	Features int    // feature classes
+	Written  int    // datasets written, whole or not
+	Losses   int64  // rows that could not be read, bands written with blocks missing and datasets skipped
+	Error    string // why it could not be read at all
+	Status   string // ok, partial or failed
+
+	err error
+}
+
+// batchPaths expands the glob patterns among args, which the shell leaves to
+// the program on Windows or when they are quoted. URLs, and patterns that
+// match nothing, are kept as they are, to fail when opened.
+func batchPaths(args []string) []string {
+	paths := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.Contains(arg, "://") || !strings.ContainsAny(arg, "*?[") {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil || len(matches) == 0 {
+			paths = append(paths, arg)
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// batchDirs names the output directory of each geodatabase under out after
+// it, numbering those whose names clash.
+func batchDirs(out string, paths []string) []string {
+	dirs := make([]string, len(paths))
+	seen := make(map[string]int)
+	for i, path := range paths {
+		name := datasetName(path)
+		seen[name]++
+		if seen[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, seen[name])
+		}
+		dirs[i] = filepath.Join(out, name)
+	}
+	return dirs
+}
+
+// rescueGDB writes every raster, as GeoTIFF, and every feature class, as
+// GeoJSON, of the geodatabase at path to dir, as a job doctor wrote for it
+// would, counting what is lost on the way.
+func rescueGDB(ctx context.Context, path string, dir string, opts raster.ReadOptions, resume bool) (res BatchResult) {
+	res = BatchResult{GDB: path, Output: dir}
+	var losses atomic.Int64
+	ctx = context.WithValue(ctx, lossKey{}, &losses)
+	ctx = gdb.WithRowReport(ctx, func(e *gdb.RowError) {
+		losses.Add(1)
+		badRow(e)
+	})
+	defer func() {
+		res.Losses = losses.Load()
+		switch {
+		case res.err != nil:
+			res.Error, res.Status = res.err.Error(), "failed"
+		case res.Losses > 0:
+			res.Status = "partial"
+		default:
+			res.Status = "ok"
+		}
+	}()
+
+	db, err := gdb.OpenContext(ctx, path)
+	if err != nil {
+		check(ctx.Err())
+		partial.Store(true)
+		res.err = err
+		return res
+	}
+	job := Job{GDB: db.Path}
+	mt := db.MasterTable()
+	for _, r := range mt.Rasters {
+		job.Rasters = append(job.Rasters, JobRaster{Name: r.Name, Output: filepath.Join(dir, r.Name+".tif"), Format: "gtiff"})
+	}
+	for _, fc := range featureClasses(ctx, db.Path, mt) {
+		job.Features = append(job.Features, JobFeature{Name: fc.Name, Output: filepath.Join(dir, fc.Name+".geojson"), Format: "geojson"})
+	}
+	db.Close()
+	res.Rasters, res.Features = len(job.Rasters), len(job.Features)
+
+	res.Written, res.err = runJob(ctx, job, "", opts, resume)
+	check(ctx.Err())
+	return res
+}
+
+// GeodatabaseInfo is what batch -list tells of a geodatabase: the datasets
+// it holds, or why it cannot be opened.
+type GeodatabaseInfo struct {
+	GDB      string
+	Rasters  int
+	Features int // feature classes
+	Tables   int // attribute tables
+	Error    string
+}
+
+// listGeodatabases opens each geodatabase of paths for what it holds.
+func listGeodatabases(ctx context.Context, paths []string) []GeodatabaseInfo {
+	infos := make([]GeodatabaseInfo, 0, len(paths))
+	for _, path := range paths {
+		info := GeodatabaseInfo{GDB: path}
+		db, err := gdb.OpenContext(ctx, path)
+		check(ctx.Err())
+		if err != nil {
+			info.Error = err.Error()
+			infos = append(infos, info)
+			continue
+		}
+		mt := db.MasterTable()
+		info.Rasters = len(mt.Rasters)
+		info.Features = len(featureClasses(ctx, db.Path, mt))
+		info.Tables = len(attributeTables(db.Path, mt))
+		db.Close()
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func printGeodatabases(w io.Writer, infos []GeodatabaseInfo) {
+	if jsonOutput {
+		check(json.NewEncoder(w).Encode(infos))
+		return
+	}
+
+	t := newTable("gdb", "rasters", "feature classes", "tables", "status")
+	for _, info := range infos {
+		if info.Error != "" {
+			t.add(healthBad, info.GDB, "", "", "", "error")
+			continue
+		}
+		t.add(healthOK, info.GDB, info.Rasters, info.Features, info.Tables, "ok")
+	}
+	t.render(w)
+	for _, info := range infos {
+		if info.Error != "" {
+			fmt.Fprintf(w, "%s: %s\n", info.GDB, info.Error)
+		}
+	}
+}
+
+func printBatch(w io.Writer, results []BatchResult) {
+	if jsonOutput {
+		check(json.NewEncoder(w).Encode(results))
+		return
+	}
+
+	t := newTable("gdb", "output", "rasters", "feature classes", "written", "losses", "status")
+	for _, r := range results {
+		h := map[string]health{"ok": healthOK, "partial": healthWarn, "failed": healthBad}[r.Status]
+		t.add(h, r.GDB, r.Output, r.Rasters, r.Features, r.Written, r.Losses, r.Status)
+	}
+	t.render(w)
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(w, "%s: %s\n", r.GDB, r.Error)
+		}
+	}
+}
+
+func runBatch(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	out := fs.String("o", cmp.Or(outputDir, "rescued"), "output directory, holding one directory per geodatabase")
+	parallel := fs.Int("parallel", 1, "geodatabases rescued at once")
+	workers := fs.Int("workers", threads, "blocks decoded at once for each raster (default one per CPU)")
+	resume := fs.Bool("resume", false, "carry on from the checkpoints of an interrupted run, skipping the GeoTIFFs it finished")
+	scan := fs.String("scan", "", "also rescue the file geodatabases found under this directory, whatever they are called")
+	list := fs.Bool("list", false, "list the geodatabases and the datasets they hold instead of rescuing them")
+	fs.Parse(args)
+
+	paths := batchPaths(fs.Args())
+	if *scan != "" {
+		found, err := gdb.FindGeodatabases(ctx, *scan)
+		check(err)
+		slog.Info("scanned", "dir", *scan, "geodatabases", len(found))
+		if len(found) == 0 {
+			fmt.Fprintf(os.Stderr, "no file geodatabase under %s\n", *scan)
+			exit(exitNotGeodatabase)
+		}
+		paths = append(paths, found...)
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "batch takes the paths, URLs or glob patterns of the geodatabases to rescue, or -scan")
+		exit(exitUsage)
+	}
+	if *list {
+		printGeodatabases(os.Stdout, listGeodatabases(ctx, paths))
+		return
+	}
+	// The progress bars of rasters read at once would draw over each other.
+	if *parallel > 1 && progressMode == "auto" {
+		progressMode = "none"
+	}
+
+	opts := raster.ReadOptions{Workers: *workers}
+	dirs := batchDirs(*out, paths)
+	results := make([]BatchResult, len(paths))
+	sem := make(chan struct{}, max(*parallel, 1))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			slog.Info("rescuing geodatabase", "gdb", path, "output", dirs[i])
+			results[i] = rescueGDB(ctx, path, dirs[i], opts, *resume)
+		}()
+	}
+	wg.Wait()
+
+	printBatch(os.Stdout, results)
+	// Some geodatabases rescued is a partial recovery, none a failure of
+	// the kind of the first.
+	for _, r := range results {
+		if r.err == nil {
+			return
+		}
+	}
+	exit(exitCode(results[0].err))
+}
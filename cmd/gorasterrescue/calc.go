@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// calcExpr is set by the global --calc flag: band math extract evaluates
+// over the bands of each raster, nil for none.
+var calcExpr *raster.Expr
+
+// calcPath returns where the result of --calc on a raster extracted to out
+// goes: beside it, _calc before the extension.
+func calcPath(out string) string {
+	return strings.TrimSuffix(out, filepath.Ext(out)) + "_calc.tif"
+}
+
+// bandCalc gathers the bands --calc uses as extract reads them, unpacked,
+// NaN where they have no data, so that the blocks are decoded once for both
+// the GeoTIFFs of the bands and the band math.
+type bandCalc struct {
+	w, h  int
+	bands map[int][]float64
+}
+
+// newBandCalc returns a bandCalc for raster r, nil if --calc is not given or
+// asks for a band r does not have, which is warned of.
+func newBandCalc(r *raster.Raster) *bandCalc {
+	if calcExpr == nil {
+		return nil
+	}
+	for _, seq := range calcExpr.Bands() {
+		if !slices.ContainsFunc(r.Bands, func(b raster.RasterBand) bool { return int(b.SequenceNbr) == seq }) {
+			slog.Warn("no calc", "raster", r.Name, "band", seq, "bands", len(r.Bands))
+			return nil
+		}
+	}
+	return &bandCalc{bands: make(map[int][]float64)}
+}
+
+// capture returns block, handed the blocks of band seq, rb, as Read hands
+// them out, wrapped to keep their pixels too if --calc uses the band.
+func (c *bandCalc) capture(seq int, rb *raster.RasterBase, block func(raster.Block) error) func(raster.Block) error {
+	if c == nil || !slices.Contains(calcExpr.Bands(), seq) {
+		return block
+	}
+	c.w, c.h = int(rb.BandWidth), int(rb.BandHeight)
+	pixels := make([]float64, c.w*c.h)
+	for i := range pixels {
+		pixels[i] = math.NaN()
+	}
+	c.bands[seq] = pixels
+	return func(b raster.Block) error {
+		for y := 0; y < b.Height && b.Y+y < c.h; y++ {
+			for x := 0; x < b.Width && b.X+x < c.w; x++ {
+				if i := y*b.Width + x; b.Valid[i] && b.X+x >= 0 && b.Y+y >= 0 {
+					pixels[(b.Y+y)*c.w+b.X+x] = rb.Unpack(b.Pixels.Float64(i))
+				}
+			}
+		}
+		if block == nil {
+			return nil
+		}
+		return block(b)
+	}
+}
+
+// write writes the result of --calc on raster r, extracted to out with
+// opts, as a GeoTIFF of float32: nodata where a band it uses has none, or
+// where it has no finite value, as for a division by zero. Bands extract
+// did not read, having written them on an earlier run, are read here.
+func (c *bandCalc) write(ctx context.Context, r *raster.Raster, out string, opts raster.ReadOptions) error {
+	if c == nil {
+		return nil
+	}
+	var rb raster.RasterBase
+	for _, seq := range calcExpr.Bands() {
+		bopts := opts
+		bopts.Band, bopts.Start, bopts.Progress = seq, 0, nil
+		band, err := r.Band(seq)
+		if err == nil && opts.Window != nil {
+			band, err = band.Crop(opts.Window)
+		}
+		if err != nil {
+			return err
+		}
+		if rb.BandWidth == 0 {
+			rb = band
+		}
+		if _, ok := c.bands[seq]; ok {
+			continue
+		}
+		bopts.Block = c.capture(seq, &band, nil)
+		if _, err := r.Read(bopts); err != nil {
+			return err
+		}
+	}
+
+	rb.DataType, rb.Name, rb.Description = "float32", "", calcExpr.String()
+	rb.Scale, rb.Offset, rb.Unit, rb.ColorInterp, rb.ColorMap = 0, 0, "", "", nil
+	noData, err := opts.NoDataFor(rb.DataType)
+	if err != nil {
+		return err
+	}
+	pixels := make(raster.Buffer[float32], c.w*c.h)
+	for i := range pixels {
+		v := calcExpr.Eval(func(seq int) float64 { return c.bands[seq][i] })
+		if math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) > math.MaxFloat32 {
+			v = noData
+		}
+		pixels[i] = float32(v)
+	}
+
+	path := calcPath(out)
+	slog.Info("writing calc", "file", path, "expr", calcExpr.String())
+	if err := writer.WriteGeoTIFF(ctx, path, &raster.RasterData{GeoData: pixels, RasBase: rb, NoData: noData}, r.WKT); err != nil {
+		return err
+	}
+	return writer.RewriteGeoTIFF(ctx, path, &rb, noData, r.WKT, geoTIFFOptions)
+}
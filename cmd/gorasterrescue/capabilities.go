@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// What this binary can read and write. Files behind build tags add to these
+// lists from their init functions.
+var (
+	bandDataTypes     = []string{"1bit", "4bit", "int8", "uint8", "int16", "uint16", "int32", "uint32", "float32", "64bit"}
+	blockCompressions = map[string]bool{"uncompressed": true, "lz77": true, "jpeg": false, "jpeg2000": false}
+	inputBackends     = []string{"directory", "zip", "http", "s3", "gs"}
+	tableVersions     = []string{"9.x", "10.x", "64-bit object IDs"}
+	rasterFormats     = []string{"gtiff"}
+	gtiffCompressions = []string{"none", "lzw", "deflate", "zstd"}
+)
+
+// Capabilities describes the build for automation deciding whether it can
+// handle a geodatabase.
+type Capabilities struct {
+	Version       string              `json:"version"`
+	Revision      string              `json:"revision,omitempty"`
+	GoVersion     string              `json:"go_version"`
+	BandDataTypes []string            `json:"band_data_types"`
+	Compressions  map[string]bool     `json:"compressions"`
+	InputBackends []string            `json:"input_backends"`
+	TableVersions []string            `json:"table_versions"`
+	OutputFormats map[string][]string `json:"output_formats"`
+	GTiffCompress []string            `json:"gtiff_compressions"`
+}
+
+func capabilities() Capabilities {
+	c := Capabilities{
+		Version:       version,
+		GoVersion:     runtime.Version(),
+		BandDataTypes: bandDataTypes,
+		Compressions:  blockCompressions,
+		InputBackends: inputBackends,
+		TableVersions: tableVersions,
+		OutputFormats: map[string][]string{"raster": rasterFormats},
+		GTiffCompress: gtiffCompressions,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				c.Revision = s.Value
+			}
+		}
+	}
+
+	vector := make([]string, 0, len(featureFormats))
+	for f := range featureFormats {
+		vector = append(vector, f)
+	}
+	sort.Strings(vector)
+	c.OutputFormats["vector"] = vector
+
+	table := make([]string, 0, len(tableFormats))
+	for f := range tableFormats {
+		table = append(table, f)
+	}
+	sort.Strings(table)
+	c.OutputFormats["table"] = table
+	return c
+}
+
+func runCapabilities(args []string) {
+	fs := flag.NewFlagSet("capabilities", flag.ExitOnError)
+	fs.Parse(args)
+
+	c := capabilities()
+	if jsonOutput {
+		check(json.NewEncoder(os.Stdout).Encode(c))
+		return
+	}
+
+	t := newTable("category", "name", "supported")
+	t.add(healthNone, "version", c.Version, "")
+	if c.Revision != "" {
+		t.add(healthNone, "revision", c.Revision, "")
+	}
+	t.add(healthNone, "go", c.GoVersion, "")
+	for _, dt := range c.BandDataTypes {
+		t.add(healthOK, "band data type", dt, "yes")
+	}
+	compressions := make([]string, 0, len(c.Compressions))
+	for name := range c.Compressions {
+		compressions = append(compressions, name)
+	}
+	sort.Strings(compressions)
+	for _, name := range compressions {
+		if c.Compressions[name] {
+			t.add(healthOK, "compression", name, "yes")
+		} else {
+			t.add(healthBad, "compression", name, "no")
+		}
+	}
+	for _, b := range c.InputBackends {
+		t.add(healthOK, "input", b, "yes")
+	}
+	for _, v := range c.TableVersions {
+		t.add(healthOK, "table version", v, "yes")
+	}
+	for _, kind := range []string{"raster", "vector", "table"} {
+		for _, f := range c.OutputFormats[kind] {
+			t.add(healthOK, kind+" output", f, "yes")
+		}
+	}
+	for _, c := range c.GTiffCompress {
+		t.add(healthOK, "gtiff compression", c, "yes")
+	}
+	t.render(os.Stdout)
+}
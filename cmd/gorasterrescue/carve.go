@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// runCarve writes the bands of a raster whose block table is too damaged to
+// read as GeoTIFFs, carving the blocks out of the bytes of the table, or of
+// the file given with -from, such as an image of the disk the geodatabase
+// was on. The band table must still be readable. It lists how many blocks
+// each band got; the places of the others are left as nodata.
+func runCarve(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("carve", flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	from := fs.String("from", "", "file to carve the blocks from, such as a disk image (default the .gdbtable of the block table)")
+	out := fs.String("o", "", "output GeoTIFF (default <raster>_carved.tif)")
+	fs.Parse(args)
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+
+	name := fs.Arg(0)
+	if name == "" {
+		fmt.Fprintln(os.Stderr, "usage: goRasterRescue carve [-gdb path] [-from file] [-o file.tif] <raster>")
+		exit(2)
+	}
+	r, err := raster.Open(db, name)
+	check(err)
+	if *out == "" {
+		*out = outputPath(name + "_carved.tif")
+	}
+	check(os.MkdirAll(filepath.Dir(*out), 0755))
+
+	var src io.ReaderAt
+	var size int64
+	if *from != "" {
+		f, err := os.Open(*from)
+		check(err)
+		defer f.Close()
+		fi, err := f.Stat()
+		check(err)
+		src, size = f, fi.Size()
+	}
+
+	t := newTable("file", "band", "placed", "duplicates", "unplaced", "unsupported")
+	stack := make([]writer.VRTBand, 0, len(r.Bands))
+	for _, band := range r.Bands {
+		path := *out
+		if len(r.Bands) > 1 {
+			path = fmt.Sprintf("%s_b%d.tif", strings.TrimSuffix(*out, ".tif"), band.SequenceNbr)
+		}
+		rb, err := r.Band(int(band.SequenceNbr))
+		check(err)
+		noData, err := noDataOf(rb.DataType)
+		check(err)
+		written, writtenNoData, _ := geoTIFFOptions.Written(&rb, noData)
+		check(geoTIFFOptions.Check(written.DataType))
+		checkUnits(path, &rb)
+		stack = append(stack, writer.VRTBand{Path: path, RB: written, NoData: writtenNoData})
+
+		// The blocks go straight into the GeoTIFF, in the order they are
+		// found.
+		tif, err := writer.CreateGeoTIFF(ctx, path, &rb, noData, r.WKT)
+		check(err)
+		_, rep, err := r.Carve(src, size, raster.ReadOptions{Band: int(band.SequenceNbr), Block: tif.WriteBlock})
+		if err == nil {
+			err = tif.Close()
+		} else {
+			tif.Close()
+		}
+		if err != nil {
+			os.Remove(path)
+			check(err)
+		}
+		check(writer.RewriteGeoTIFF(ctx, path, &rb, noData, r.WKT, geoTIFFOptions))
+		check(writeSTACItem(path, &rb, noData, r.WKT))
+		check(writePAM(path, r, &rb, band, true))
+
+		h := healthOK
+		switch {
+		case rep.Placed == 0:
+			h = healthBad
+		case rep.Unplaced > 0 || rep.Unsupported > 0:
+			h = healthWarn
+		}
+		t.add(h, path, band.SequenceNbr, rep.Placed, rep.Duplicates, rep.Unplaced, rep.Unsupported)
+	}
+	check(writeVRT(*out, stack, r.WKT))
+	t.render(os.Stdout)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// A checkpoint records how far extracting a band into a GeoTIFF has got, so
+// that extract -resume can carry on after an interruption instead of reading
+// the whole block table again. It sits next to the GeoTIFF as
+// <file>.checkpoint, one JSON object per line: a checkpointHeader naming the
+// band, then a checkpointEntry each time the GeoTIFF is synced to disk. It is
+// removed once the band is complete, so a GeoTIFF without one is finished.
+type checkpoint struct {
+	path    string
+	f       *os.File
+	tif     *writer.GeoTIFFWriter
+	blocks  int                   // rows of the block table already written
+	suspect []raster.SuspectBlock // suspect blocks of the earlier runs
+	saved   time.Time
+	last    raster.Progress // as of the last call to progress
+	noted   int             // suspect blocks of this run already saved
+}
+
+// checkpointHeader names the band a checkpoint is for; resuming checks it
+// against the band being extracted.
+type checkpointHeader struct {
+	Raster   string    `json:"raster"`
+	Band     int       `json:"band"`
+	Width    int32     `json:"width"`
+	Height   int32     `json:"height"`
+	DataType string    `json:"data_type"`
+	Window   []float64 `json:"window,omitempty"`
+	Cutline  string    `json:"cutline,omitempty"`
+	Mask     string    `json:"mask,omitempty"`
+	NoData   *float64  `json:"nodata,omitempty"`
+}
+
+// checkpointEntry is the state saved when the GeoTIFF was last synced.
+type checkpointEntry struct {
+	Blocks  int                   `json:"blocks"`            // rows of the block table written
+	Suspect []raster.SuspectBlock `json:"suspect,omitempty"` // found since the previous entry
+}
+
+// checkpointInterval is how often the GeoTIFF is synced and the checkpoint
+// saved while reading.
+const checkpointInterval = 5 * time.Second
+
+// openCheckpoint prepares the GeoTIFF at path for band rb of raster name,
+// rb cropped to the window of opts if there is one, its pixels without data
+// set to the nodata of opts. With resume it picks up from the checkpoint of
+// an earlier run, or returns nil if the GeoTIFF is there without one, being
+// finished; otherwise it writes the GeoTIFF afresh.
+func openCheckpoint(ctx context.Context, path string, name string, rb *raster.RasterBase, opts raster.ReadOptions, wkt string, resume bool) (*checkpoint, error) {
+	noData, err := opts.NoDataFor(rb.DataType)
+	if err != nil {
+		return nil, err
+	}
+	h := checkpointHeader{name, rb.BandID, rb.BandWidth, rb.BandHeight, rb.DataType, opts.Window, opts.Cutline.String(), opts.Mask.String(), opts.NoData}
+	c := &checkpoint{path: path + ".checkpoint", saved: time.Now()}
+	if resume {
+		ok, err := c.load(h)
+		if errors.Is(err, fs.ErrNotExist) {
+			if _, err := os.Stat(path); err == nil {
+				return nil, nil
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		if ok {
+			c.tif, err = writer.OpenGeoTIFF(path, rb)
+			if err != nil {
+				return nil, err
+			}
+			c.f, err = os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				c.tif.Close()
+				return nil, err
+			}
+			return c, nil
+		}
+	}
+
+	// The header goes first and the first entry only once the GeoTIFF is
+	// complete, so that a run cut short while writing it starts over.
+	c.f, err = os.Create(c.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(c.f).Encode(h); err != nil {
+		c.f.Close()
+		return nil, err
+	}
+	c.tif, err = writer.CreateGeoTIFF(ctx, path, rb, noData, wkt)
+	if err != nil {
+		c.f.Close()
+		os.Remove(c.path)
+		return nil, err
+	}
+	if err := c.save(); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the checkpoint of an earlier run, which must be for the band h
+// describes. It returns false if that run never got to write its GeoTIFF.
+// A last line cut short by the interruption is ignored.
+func (c *checkpoint) load(h checkpointHeader) (bool, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	var saved checkpointHeader
+	if !sc.Scan() || json.Unmarshal(sc.Bytes(), &saved) != nil || !reflect.DeepEqual(saved, h) {
+		return false, fmt.Errorf("%s is not for band %d of %s as read now; remove it to start over", c.path, h.Band, h.Raster)
+	}
+	found := false
+	for sc.Scan() {
+		var e checkpointEntry
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			break
+		}
+		c.blocks = e.Blocks
+		c.suspect = append(c.suspect, e.Suspect...)
+		found = true
+	}
+	return found, nil
+}
+
+// progress notes how far the read has got, saving the checkpoint every
+// checkpointInterval.
+func (c *checkpoint) progress(pr raster.Progress) {
+	c.last = pr
+	if pr.Total == 0 || time.Since(c.saved) < checkpointInterval {
+		return
+	}
+	if err := c.save(); err != nil {
+		// The read can go on; a later save, or the end, may still work.
+		slog.Warn("cannot save the checkpoint", "file", c.path, "err", err)
+	}
+}
+
+// save syncs the GeoTIFF and then records the blocks written in it.
+func (c *checkpoint) save() error {
+	if err := c.tif.Sync(); err != nil {
+		return err
+	}
+	e := checkpointEntry{Blocks: c.blocks}
+	if c.last.Total > 0 {
+		e.Blocks = c.last.Blocks
+		e.Suspect = c.last.Suspect[c.noted:]
+	}
+	if err := json.NewEncoder(c.f).Encode(e); err != nil {
+		return err
+	}
+	if err := c.f.Sync(); err != nil {
+		return err
+	}
+	c.noted += len(e.Suspect)
+	c.saved = time.Now()
+	return nil
+}
+
+// interrupt saves how far a read that failed got and closes the files,
+// keeping them for -resume.
+func (c *checkpoint) interrupt() {
+	if err := c.save(); err != nil {
+		slog.Warn("cannot save the checkpoint", "file", c.path, "err", err)
+	}
+	c.close()
+}
+
+// finish closes the finished GeoTIFF and removes the checkpoint. It returns
+// the suspect blocks of every run, given those of the last one.
+func (c *checkpoint) finish(suspect []raster.SuspectBlock) ([]raster.SuspectBlock, error) {
+	if err := c.tif.Close(); err != nil {
+		c.f.Close()
+		return nil, err
+	}
+	c.f.Close()
+	if err := os.Remove(c.path); err != nil {
+		return nil, err
+	}
+	return append(c.suspect, suspect...), nil
+}
+
+func (c *checkpoint) close() {
+	c.tif.Close()
+	c.f.Close()
+}
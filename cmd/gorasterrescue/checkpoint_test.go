@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// checkpointBand is the band of 6 by 4 uint8 pixels the checkpoint tests
+// extract.
+func checkpointBand() raster.RasterBase {
+	return raster.RasterBase{BandID: 1, DataType: "uint8", BandWidth: 6, BandHeight: 4, GeoTransform: [6]float64{0, 1, 0, 4, 0, -1}}
+}
+
+// writeTestBlock writes a block of 3 by 2 pixels, first, first+1 and so on,
+// at x, y.
+func writeTestBlock(t *testing.T, cp *checkpoint, x, y int, first uint8) {
+	t.Helper()
+	pixels := raster.Buffer[uint8]{first, first + 1, first + 2, first + 3, first + 4, first + 5}
+	b := raster.Block{X: x, Y: y, Width: 3, Height: 2, Pixels: pixels, Valid: []bool{true, true, true, true, true, true}}
+	if err := cp.tif.WriteBlock(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// interruptedRun starts extracting rb into path, writes a block and stops
+// after 2 rows of the block table, with a suspect block and a last line of
+// the checkpoint cut short.
+func interruptedRun(t *testing.T, path string, rb raster.RasterBase) []raster.SuspectBlock {
+	t.Helper()
+	cp, err := openCheckpoint(context.Background(), path, "r", &rb, raster.ReadOptions{}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestBlock(t, cp, 0, 0, 1)
+	suspect := []raster.SuspectBlock{{RowNbr: 1, ColNbr: 0, Reason: "bad", Skipped: true}}
+	cp.progress(raster.Progress{Blocks: 2, Total: 5, Suspect: suspect})
+	cp.interrupt()
+	f, err := os.OpenFile(path+".checkpoint", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(`{"blocks":4,"susp`); err != nil {
+		t.Fatal(err)
+	}
+	return suspect
+}
+
+// TestCheckpointResume interrupts an extraction and resumes it: the second
+// run starts from the rows the first read and writes into the same file,
+// and the suspect blocks of both come out once it finishes.
+func TestCheckpointResume(t *testing.T) {
+	ctx := context.Background()
+	rb := checkpointBand()
+	path := filepath.Join(t.TempDir(), "band.tif")
+	first := interruptedRun(t, path, rb)
+
+	cp, err := openCheckpoint(ctx, path, "r", &rb, raster.ReadOptions{}, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.blocks != 2 || !reflect.DeepEqual(cp.suspect, first) {
+		t.Fatalf("resumed from %d blocks, suspect %v; want 2, %v", cp.blocks, cp.suspect, first)
+	}
+	writeTestBlock(t, cp, 3, 2, 11)
+	second := []raster.SuspectBlock{{RowNbr: 4, ColNbr: 1, Reason: "worse", Skipped: true}}
+	cp.progress(raster.Progress{Blocks: 5, Total: 5, Suspect: second})
+	suspect, err := cp.finish(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(first, second...); !reflect.DeepEqual(suspect, want) {
+		t.Errorf("suspect blocks %v, want %v", suspect, want)
+	}
+	if _, err := os.Stat(path + ".checkpoint"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("checkpoint left after finishing: %v", err)
+	}
+
+	tif, err := raster.ReadTIFF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := raster.NoDataValue("uint8")
+	want := []float64{
+		1, 2, 3, nd, nd, nd,
+		4, 5, 6, nd, nd, nd,
+		nd, nd, nd, 11, 12, 13,
+		nd, nd, nd, 14, 15, 16,
+	}
+	for i, v := range want {
+		if got := tif.Bands[0].Float64(i); got != v {
+			t.Errorf("pixel %d is %v, want %v", i, got, v)
+		}
+	}
+
+	// Finished, it is left alone.
+	cp, err = openCheckpoint(ctx, path, "r", &rb, raster.ReadOptions{}, "", true)
+	if cp != nil || err != nil {
+		t.Errorf("finished band resumed: %v", err)
+	}
+}
+
+// TestCheckpointStartOver checks that a run cut short before its GeoTIFF
+// was whole, its checkpoint a header alone, is started over.
+func TestCheckpointStartOver(t *testing.T) {
+	rb := checkpointBand()
+	path := filepath.Join(t.TempDir(), "band.tif")
+	interruptedRun(t, path, rb)
+	b, err := os.ReadFile(path + ".checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, _ := strings.Cut(string(b), "\n")
+	if err := os.WriteFile(path+".checkpoint", []byte(header+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := openCheckpoint(context.Background(), path, "r", &rb, raster.ReadOptions{}, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.close()
+	if cp.blocks != 0 || len(cp.suspect) != 0 {
+		t.Errorf("started from %d blocks, suspect %v", cp.blocks, cp.suspect)
+	}
+}
+
+// TestCheckpointMismatch checks that resuming fails, rather than writing
+// into the file, with a checkpoint of another band or read, or a GeoTIFF
+// that is not the one it was saved with.
+func TestCheckpointMismatch(t *testing.T) {
+	ctx := context.Background()
+	zero := 0.0
+	other := checkpointBand()
+	other.BandHeight = 5
+	for _, tc := range []struct {
+		name    string
+		raster  string
+		opts    raster.ReadOptions
+		replace func(path string) error
+		want    string
+	}{
+		{name: "raster", raster: "s", want: "is not for band 1 of s"},
+		{name: "nodata", raster: "r", opts: raster.ReadOptions{NoData: &zero}, want: "is not for band 1 of r"},
+		{name: "window", raster: "r", opts: raster.ReadOptions{Window: []float64{0, 0, 2, 2}}, want: "is not for band 1 of r"},
+		{name: "garbage", raster: "r", replace: func(path string) error {
+			return os.WriteFile(path+".checkpoint", []byte("not json\n"), 0o644)
+		}, want: "is not for band 1 of r"},
+		{name: "other GeoTIFF", raster: "r", replace: func(path string) error {
+			tif, err := writer.CreateGeoTIFF(ctx, path, &other, 0, "")
+			if err != nil {
+				return err
+			}
+			return tif.Close()
+		}, want: "is not a GeoTIFF of this band"},
+		{name: "no GeoTIFF", raster: "r", replace: os.Remove, want: "no such file"},
+	} {
+		rb := checkpointBand()
+		path := filepath.Join(t.TempDir(), "band.tif")
+		interruptedRun(t, path, rb)
+		if tc.replace != nil {
+			if err := tc.replace(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cp, err := openCheckpoint(ctx, path, tc.raster, &rb, tc.opts, "", true)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error %v, want %q", tc.name, err, tc.want)
+		}
+		if cp != nil {
+			cp.close()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// checksums is set by the global --checksums flag: every file written gets
+// a <file>.sha256 beside it, in the format of sha256sum -c.
+var checksums = false
+
+// manifestPath is set by the global --manifest flag: the file listing the
+// SHA-256 of every file written, in the format of sha256sum -c, by their
+// paths relative to its directory, in the order of those paths whatever
+// order the files were written in.
+var manifestPath = ""
+
+// writeChecksums writes the sidecars and the manifest asked for of the
+// outputs of datasets, once the report has checksummed them. Outputs that
+// could not be read, such as stdout, are left out.
+func writeChecksums(datasets []*reportDataset) error {
+	manifest := make([]reportOutput, 0)
+	seen := make(map[string]bool)
+	for _, d := range datasets {
+		for _, o := range d.Outputs {
+			// The layers of a GeoPackage, or tables of a SQLite database,
+			// share their file.
+			if o.SHA256 == "" || seen[o.Path] {
+				continue
+			}
+			seen[o.Path] = true
+			if checksums {
+				line := fmt.Sprintf("%s  %s\n", o.SHA256, filepath.Base(o.Path))
+				if err := os.WriteFile(o.Path+".sha256", []byte(line), 0644); err != nil {
+					return err
+				}
+			}
+			if manifestPath != "" {
+				manifest = append(manifest, reportOutput{Path: manifestEntry(o.Path), SHA256: o.SHA256})
+			}
+		}
+	}
+	if manifestPath == "" {
+		return nil
+	}
+	slices.SortFunc(manifest, func(a, b reportOutput) int { return strings.Compare(a.Path, b.Path) })
+	var b strings.Builder
+	for _, o := range manifest {
+		fmt.Fprintf(&b, "%s  %s\n", o.SHA256, o.Path)
+	}
+	return os.WriteFile(manifestPath, []byte(b.String()), 0644)
+}
+
+// manifestEntry returns path relative to the directory of the manifest, for
+// sha256sum -c run there, or absolute if it cannot be.
+func manifestEntry(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	dir, err := filepath.Abs(filepath.Dir(manifestPath))
+	if err != nil {
+		return abs
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return abs
+	}
+	return rel
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// BandComparison is what compare found comparing a band of two GeoTIFFs.
+type BandComparison struct {
+	Band       int
+	Pixels     int     // pixels with data in both
+	Mismatched int     // pixels differing by more than the tolerance, or with data in one only
+	MaxDiff    float64 // the largest absolute difference between pixels with data in both
+	MeanDiff   float64 // the mean absolute difference between them
+}
+
+// compareBands compares the pixels of bands a and b, pixels equal to their
+// nodata, or NaN, having no data.
+func compareBands(band int, a, b raster.Pixels, noDataA, noDataB *float64, tolerance float64) BandComparison {
+	isNoData := func(v float64, noData *float64) bool {
+		return math.IsNaN(v) || noData != nil && v == *noData
+	}
+	c := BandComparison{Band: band}
+	sum := 0.0
+	for i := 0; i < a.Len(); i++ {
+		va, vb := a.Float64(i), b.Float64(i)
+		na, nb := isNoData(va, noDataA), isNoData(vb, noDataB)
+		if na || nb {
+			if na != nb {
+				c.Mismatched++
+			}
+			continue
+		}
+		d := math.Abs(va - vb)
+		c.Pixels++
+		sum += d
+		c.MaxDiff = max(c.MaxDiff, d)
+		if d > tolerance {
+			c.Mismatched++
+		}
+	}
+	if c.Pixels > 0 {
+		c.MeanDiff = sum / float64(c.Pixels)
+	}
+	return c
+}
+
+func printComparison(w io.Writer, comparisons []BandComparison) {
+	if jsonOutput {
+		check(json.NewEncoder(w).Encode(comparisons))
+		return
+	}
+
+	t := newTable("band", "pixels", "mismatched", "max diff", "mean diff", "status")
+	for _, c := range comparisons {
+		if c.Mismatched > 0 {
+			t.add(healthBad, c.Band, c.Pixels, c.Mismatched, c.MaxDiff, strconv.FormatFloat(c.MeanDiff, 'g', 6, 64), "differs")
+			continue
+		}
+		t.add(healthOK, c.Band, c.Pixels, c.Mismatched, c.MaxDiff, strconv.FormatFloat(c.MeanDiff, 'g', 6, 64), "same")
+	}
+	t.render(w)
+}
+
+func runCompare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	tolerance := fs.Float64("tolerance", 0, "largest absolute difference between two pixels still counted as matching")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: goRasterRescue compare [-tolerance d] a.tif b.tif")
+		exit(exitUsage)
+	}
+
+	a, err := raster.ReadTIFF(fs.Arg(0))
+	check(err)
+	b, err := raster.ReadTIFF(fs.Arg(1))
+	check(err)
+	if a.Width != b.Width || a.Height != b.Height || len(a.Bands) != len(b.Bands) {
+		check(fmt.Errorf("%s is %dx%d pixels of %d band(s), %s %dx%d pixels of %d band(s)",
+			fs.Arg(0), a.Width, a.Height, len(a.Bands), fs.Arg(1), b.Width, b.Height, len(b.Bands)))
+	}
+	if a.DataType != b.DataType {
+		slog.Warn("data types differ", "a", a.DataType, "b", b.DataType)
+	}
+	if !slices.Equal(a.PixelScale, b.PixelScale) || !slices.Equal(a.Tiepoint, b.Tiepoint) {
+		slog.Warn("georeferencing differs", "a_scale", a.PixelScale, "a_tiepoint", a.Tiepoint, "b_scale", b.PixelScale, "b_tiepoint", b.Tiepoint)
+	}
+
+	comparisons := make([]BandComparison, len(a.Bands))
+	differ := false
+	for i := range a.Bands {
+		comparisons[i] = compareBands(i+1, a.Bands[i], b.Bands[i], a.NoData, b.NoData, *tolerance)
+		differ = differ || comparisons[i].Mismatched > 0
+	}
+	printComparison(os.Stdout, comparisons)
+	// Like cmp, for scripts checking an extraction against a reference.
+	if differ {
+		exit(exitFailure)
+	}
+}
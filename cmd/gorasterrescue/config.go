@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// configPath is set by the global --config flag: the file the settings of
+// the run are read from before the command line, which wins over it.
+var configPath = ""
+
+// config is the configuration read from configPath, or nil.
+var config *Config
+
+// Config is what a configuration file sets, in the subset of YAML jobs are
+// written in:
+//
+//	# global flags, by their names
+//	on-error: fill
+//	report: rescue.html
+//	# flags of a command, for every run of it
+//	extract:
+//	  gdb: /data/gSSURGO_DC.gdb
+//	  workers: 4
+//	table export:
+//	  format: parquet
+//	# settings of rasters, as in a job
+//	rasters:
+//	  - name: MapunitRaster_10m
+//	    nodata: 0
+type Config struct {
+	Global   []string             // global flags, as -name=value
+	Commands map[string][]string  // flags of each command, as -name=value
+	Rasters  map[string]JobRaster // by name
+}
+
+// configGlobals lists the global flags a configuration sets, true for those
+// taking a value rather than true or false.
+var configGlobals = map[string]bool{
+	"no-color": false, "json": false, "quiet": false, "rebuild-index": false, "no-tablx": false, "undelete": false, "checksums": false, "deterministic": false, "external-overviews": false, "cog": false, "stac": false, "aux-xml": false, "unscale": false,
+	"verbose": true, "progress": true, "on-error": true, "error-log": true, "report": true, "manifest": true, "co": true, "build-overviews": true, "overview-resampling": true, "units": true, "reclass": true, "composite": true, "calc": true, "mask": true, "nodata": true, "ot": true, "ot-rounding": true, "ot-overflow": true, "scale": true, "cpuprofile": true, "memprofile": true,
+}
+
+// configCommands lists the commands a configuration sets flags of, and
+// configSubcommands those among them whose flags follow a subcommand.
+var (
+	configCommands    = []string{"batch", "capabilities", "carve", "compare", "doctor", "extract", "features", "locate", "metadata", "mosaic", "table", "validate"}
+	configSubcommands = []string{"features", "mosaic", "table"}
+)
+
+// parseConfig reads a configuration: global flags as top-level keys, and
+// mappings of the flags of commands and a list of rasters under them.
+func parseConfig(r io.Reader) (*Config, error) {
+	c := &Config{Commands: make(map[string][]string), Rasters: make(map[string]JobRaster)}
+	var section string
+	var item map[string]string
+	var itemLine int
+	endItem := func() error {
+		if item == nil {
+			return nil
+		}
+		jr, err := parseJobRaster(item)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", itemLine, err)
+		}
+		c.Rasters[jr.Name] = jr
+		item = nil
+		return nil
+	}
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(stripComment(sc.Text()), " \t")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.Contains(line, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", n)
+		}
+		text := strings.TrimLeft(line, " ")
+		indent := len(line) - len(text)
+
+		if indent == 0 {
+			if err := endItem(); err != nil {
+				return nil, err
+			}
+			key, val, err := splitKeyValue(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			section = ""
+			switch takesValue, global := configGlobals[key]; {
+			case key == "rasters":
+				if val != "" && val != "[]" {
+					return nil, fmt.Errorf("line %d: rasters must be a list of entries", n)
+				}
+				section = key
+			case global:
+				flag, err := configFlag(key, val, takesValue)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				if flag != "" {
+					c.Global = append(c.Global, flag)
+				}
+			case slices.Contains(configCommands, strings.Fields(key + " ")[0]):
+				if val != "" {
+					return nil, fmt.Errorf("line %d: %s must be a mapping of its flags", n, key)
+				}
+				section = strings.Join(strings.Fields(key), " ")
+			default:
+				return nil, fmt.Errorf("line %d: unknown key %q", n, key)
+			}
+			continue
+		}
+
+		switch {
+		case section == "":
+			return nil, fmt.Errorf("line %d: unexpected indentation", n)
+		case section == "rasters":
+			if text == "-" || strings.HasPrefix(text, "- ") {
+				if err := endItem(); err != nil {
+					return nil, err
+				}
+				item, itemLine = map[string]string{}, n
+				text = strings.TrimSpace(text[1:])
+				if text == "" {
+					continue
+				}
+			} else if item == nil {
+				return nil, fmt.Errorf("line %d: expected a list entry starting with -", n)
+			}
+			key, val, err := splitKeyValue(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			item[key] = val
+		default:
+			key, val, err := splitKeyValue(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			c.Commands[section] = append(c.Commands[section], "-"+key+"="+val)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := endItem(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// configFlag turns global key set to val into the flag setupOutput reads,
+// or "" for a switch turned off.
+func configFlag(key string, val string, takesValue bool) (string, error) {
+	switch {
+	case key == "verbose":
+		switch val {
+		case "false", "0":
+			return "", nil
+		case "true", "1":
+			return "-v", nil
+		case "2":
+			return "-vv", nil
+		}
+		return "", fmt.Errorf("verbose must be true, false, 1 or 2")
+	case takesValue:
+		return "--" + key + "=" + val, nil
+	case val == "true":
+		return "--" + key, nil
+	case val == "false":
+		return "", nil
+	}
+	return "", fmt.Errorf("%s must be true or false", key)
+}
+
+// readConfig parses the configuration file at path.
+func readConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// withFlags puts the flags c sets for the command of args first, ahead of
+// those given on the command line, which so win. The flags of features,
+// mosaic and table follow their subcommand, with those set for all of its
+// subcommands, under "table", ahead of those for one, under "table export".
+func (c *Config) withFlags(args []string) []string {
+	if c == nil || len(args) == 0 {
+		return args
+	}
+	at := 1
+	flags := c.Commands[args[0]]
+	if slices.Contains(configSubcommands, args[0]) && len(args) > 1 {
+		at = 2
+		flags = slices.Concat(flags, c.Commands[args[0]+" "+args[1]])
+	}
+	return slices.Concat(args[:at], flags, args[at:])
+}
+
+// raster fills in what r, a raster of a job or the one extract was asked
+// for, leaves unset with the settings c has for it: its output, window,
+// cutline and nodata, and verification if c asks for it.
+func (c *Config) raster(r JobRaster) JobRaster {
+	if c == nil {
+		return r
+	}
+	s, ok := c.Rasters[r.Name]
+	if !ok {
+		return r
+	}
+	if r.Output == "" {
+		r.Output = s.Output
+	}
+	if r.Window == nil {
+		r.Window = s.Window
+	}
+	if r.Cutline == "" {
+		r.Cutline = s.Cutline
+	}
+	if r.NoData == nil {
+		r.NoData = s.NoData
+	}
+	r.Verify = r.Verify || s.Verify
+	return r
+}
+
+// loadConfig strips --config from the global flags of args, those ahead of
+// the command, and reads the file it names into config, returning args with
+// the global flags it sets put first, for those of the command line to win.
+// A configuration that cannot be read ends the program.
+func loadConfig(args []string) []string {
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if !strings.HasPrefix(a, "-") {
+			// The command: what follows it, a --config included, is its own.
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if name, val, ok := strings.Cut(a, "="); ok && (name == "--config" || name == "-config") {
+			configPath = val
+			continue
+		}
+		if a == "--config" || a == "-config" {
+			if i+1 == len(args) {
+				fmt.Fprintf(os.Stderr, "%s takes a file name\n", a)
+				exit(exitUsage)
+			}
+			i++
+			configPath = args[i]
+			continue
+		}
+		rest = append(rest, a)
+		// The value of a global flag, as in --report rescue.html, is no command.
+		if name := strings.TrimLeft(a, "-"); configGlobals[name] && name != "verbose" && i+1 < len(args) {
+			i++
+			rest = append(rest, args[i])
+		}
+	}
+	if configPath == "" {
+		return rest
+	}
+	c, err := readConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "goRasterRescue:", err)
+		exit(exitUsage)
+	}
+	config = c
+	return slices.Concat(c.Global, rest)
+}
+
+// Defaults read from the environment, for containers run without a shell
+// around the command to pass it flags; flags and --config win over them.
+var (
+	outputDir = "" // GORASTERRESCUE_OUTPUT_DIR: where outputs go
+	threads   = 0  // GORASTERRESCUE_THREADS: blocks decoded at once
+)
+
+// loadEnv reads the GORASTERRESCUE_* variables: OUTPUT_DIR, THREADS,
+// LOG_LEVEL (debug, info, warn or error) and CONFIG, the file --config
+// names. A value that does not parse ends the program.
+func loadEnv() {
+	if v := os.Getenv("GORASTERRESCUE_LOG_LEVEL"); v != "" {
+		levels := map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError}
+		level, ok := levels[strings.ToLower(v)]
+		if !ok {
+			fmt.Fprintln(os.Stderr, "GORASTERRESCUE_LOG_LEVEL takes one of debug, info, warn, error")
+			exit(exitUsage)
+		}
+		logLevel = level
+	}
+	if v := os.Getenv("GORASTERRESCUE_THREADS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			fmt.Fprintln(os.Stderr, "GORASTERRESCUE_THREADS takes a number of threads")
+			exit(exitUsage)
+		}
+		threads = n
+	}
+	outputDir = os.Getenv("GORASTERRESCUE_OUTPUT_DIR")
+	configPath = os.Getenv("GORASTERRESCUE_CONFIG")
+}
+
+// outputPath puts the default output file name in outputDir.
+func outputPath(name string) string {
+	if outputDir == "" {
+		return name
+	}
+	return filepath.Join(outputDir, name)
+}
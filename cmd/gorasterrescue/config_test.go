@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestParseConfig reads configurations of global flags, command sections
+// and rasters lists.
+func TestParseConfig(t *testing.T) {
+	nodata := func(v float64) *float64 { return &v }
+	for _, tc := range []struct {
+		name     string
+		text     string
+		global   []string
+		commands map[string][]string
+		rasters  map[string]JobRaster
+	}{
+		{
+			name:   "globals",
+			text:   "# a run\non-error: fill  # keep going\nreport: \"rescue report.html\"\nverbose: 2\nquiet: false\ncog: true\n",
+			global: []string{"--on-error=fill", "--report=rescue report.html", "-vv", "--cog"},
+		},
+		{
+			name: "commands",
+			text: "extract:\n  gdb: /data/gSSURGO_DC.gdb\n  workers: 4\n\ntable  export:\n    format: parquet\ntable:\n  gdb: 'it''s.gdb'\n",
+			commands: map[string][]string{
+				"extract":      {"-gdb=/data/gSSURGO_DC.gdb", "-workers=4"},
+				"table export": {"-format=parquet"},
+				"table":        {"-gdb=it's.gdb"},
+			},
+		},
+		{
+			name: "rasters",
+			text: "rasters:\n  - name: MapunitRaster_10m\n    nodata: 0\n    window: [1, 2, 3, 4]\n  -\n    name: other\n    verify: true\n    output: other.tif\nrasters: []\n",
+			rasters: map[string]JobRaster{
+				"MapunitRaster_10m": {Name: "MapunitRaster_10m", Format: "gtiff", NoData: nodata(0), Window: []float64{1, 2, 3, 4}},
+				"other":             {Name: "other", Output: "other.tif", Format: "gtiff", Verify: true},
+			},
+		},
+		{
+			name:     "mixed",
+			text:     "rasters:\n - name: a\n   cutline: dc.geojson\nmosaic:\n  resampling: bilinear\njson: true\n",
+			global:   []string{"--json"},
+			commands: map[string][]string{"mosaic": {"-resampling=bilinear"}},
+			rasters:  map[string]JobRaster{"a": {Name: "a", Format: "gtiff", Cutline: "dc.geojson"}},
+		},
+	} {
+		c, err := parseConfig(strings.NewReader(tc.text))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !slices.Equal(c.Global, tc.global) {
+			t.Errorf("%s: global flags %q, want %q", tc.name, c.Global, tc.global)
+		}
+		if fmt.Sprint(c.Commands) != fmt.Sprint(tc.commands) {
+			t.Errorf("%s: command flags %q, want %q", tc.name, c.Commands, tc.commands)
+		}
+		if len(c.Rasters) != len(tc.rasters) {
+			t.Errorf("%s: %d rasters, want %d", tc.name, len(c.Rasters), len(tc.rasters))
+		}
+		for name, want := range tc.rasters {
+			got := c.Rasters[name]
+			if (got.NoData == nil) != (want.NoData == nil) || got.NoData != nil && *got.NoData != *want.NoData {
+				t.Errorf("%s: raster %s has nodata %v, want %v", tc.name, name, got.NoData, want.NoData)
+			}
+			got.NoData, want.NoData = nil, nil
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%s: raster %s is %+v, want %+v", tc.name, name, got, want)
+			}
+		}
+	}
+}
+
+// TestParseConfigErrors checks that a configuration fails on the line in
+// error, bad indentation among them.
+func TestParseConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want string
+	}{
+		{"report: r.html\n  json: true\n", "line 2: unexpected indentation"},
+		{"extract:\n\tworkers: 4\n", "line 2: tabs are not allowed"},
+		{"extract:\n  workers 4\n", "line 2: expected key: value"},
+		{"extract: fast\n", "line 1: extract must be a mapping"},
+		{"rasters: a\n", "line 1: rasters must be a list"},
+		{"rasters:\n  name: a\n", "line 2: expected a list entry"},
+		{"rasters:\n  - name: a\n    nodata: none\n", "line 2: bad nodata value"},
+		{"rasters:\n  - output: a.tif\n", "line 2: raster entry without a name"},
+		{"rasters:\n  - name: a\n    size: 3\n", "line 2: unknown raster key"},
+		{"color: true\n", `line 1: unknown key "color"`},
+		{"serve:\n  port: 80\n", `line 1: unknown key "serve"`},
+		{"json: yes\n", "line 1: json must be true or false"},
+		{"verbose: 3\n", "line 1: verbose must be"},
+		{"# json\n\nreport: \"r.html\n", "line 3: bad quoted value"},
+	} {
+		_, err := parseConfig(strings.NewReader(tc.text))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: error %v, want %q", tc.text, err, tc.want)
+		}
+	}
+}
+
+// TestWithFlags checks that the flags of a command, and of a subcommand
+// after those of all of them, go ahead of the command line's.
+func TestWithFlags(t *testing.T) {
+	c := &Config{Commands: map[string][]string{
+		"extract":      {"-workers=4"},
+		"table":        {"-gdb=a.gdb"},
+		"table export": {"-format=parquet"},
+	}}
+	for _, tc := range [][2][]string{
+		{{"extract", "-workers=2", "r"}, {"extract", "-workers=4", "-workers=2", "r"}},
+		{{"table", "export", "t"}, {"table", "export", "-gdb=a.gdb", "-format=parquet", "t"}},
+		{{"table"}, {"table", "-gdb=a.gdb"}},
+		{{"locate", "x"}, {"locate", "x"}},
+	} {
+		if got := c.withFlags(tc[0]); !slices.Equal(got, tc[1]) {
+			t.Errorf("%q: %q, want %q", tc[0], got, tc[1])
+		}
+	}
+}
+
+// TestLoadConfig checks that --config is read from the global flags alone:
+// one after the command, or after the value of a global flag, is left in.
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rescue.yaml")
+	if err := os.WriteFile(path, []byte("json: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { configPath, config = "", nil }()
+	for _, tc := range []struct {
+		args, want []string
+		path       string
+	}{
+		{[]string{"--config", path, "extract", "r"}, []string{"--json", "extract", "r"}, path},
+		{[]string{"-v", "--report", "r.html", "-config=" + path, "table", "export"}, []string{"--json", "-v", "--report", "r.html", "table", "export"}, path},
+		{[]string{"extract", "--config", path}, []string{"extract", "--config", path}, ""},
+		{[]string{"--report", "--config", "extract"}, []string{"--report", "--config", "extract"}, ""},
+		{[]string{"-q", "batch", "-config=" + path}, []string{"-q", "batch", "-config=" + path}, ""},
+	} {
+		configPath, config = "", nil
+		got := loadConfig(tc.args)
+		if !slices.Equal(got, tc.want) || configPath != tc.path || (config != nil) != (tc.path != "") {
+			t.Errorf("%q: %q, config %q, want %q, config %q", tc.args, got, configPath, tc.want, tc.path)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// tracedFormats maps the extensions of the files contour and polygonize
+// write onto the formats they write them in.
+var tracedFormats = map[string]string{
+	".geojson": "geojson",
+	".json":    "geojson",
+	".gpkg":    "gpkg",
+	".shp":     "shp",
+}
+
+// tracedFormat returns the format of out, one of tracedFormats, or ends the
+// program if it is none of them.
+func tracedFormat(out string) string {
+	format, ok := tracedFormats[strings.ToLower(filepath.Ext(out))]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "-o: %q is not a .geojson, .gpkg or .shp file\n", out)
+		exit(2)
+	}
+	return format
+}
+
+// writeTraced writes features traced from a raster, of layer geometry type
+// layerGeomType, with fields, to out in its format. A GeoPackage names the
+// layer after the file.
+func writeTraced(ctx context.Context, out string, layerGeomType uint8, fields []gdb.Field, features []writer.Feature, wkt string) error {
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(out, filepath.Ext(out))
+	switch tracedFormat(out) {
+	case "gpkg":
+		layer := writer.GpkgLayer{
+			Name:          filepath.Base(base),
+			IDColumn:      "fid",
+			GeomColumn:    "geom",
+			LayerGeomType: layerGeomType,
+			WKT:           wkt,
+			Fields:        fields,
+			Features:      features,
+		}
+		return writer.WriteGeoPackage(ctx, out, []writer.GpkgLayer{layer})
+	case "shp":
+		return writer.WriteShapefile(base, layerGeomType, fields, features, wkt)
+	}
+	return writer.WriteGeoJSON(out, features)
+}
+
+// runContour writes the contour lines of a band of a raster, a DEM say, as
+// GeoJSON, a GeoPackage or a Shapefile, traced from the band as it is
+// decoded rather than from a GeoTIFF written first. --units converts the
+// levels, and the interval is in the units converted to.
+func runContour(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("contour", flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	out := fs.String("o", "", "output .geojson, .gpkg or .shp (default <raster>_contours.geojson)")
+	interval := fs.Float64("interval", 0, "difference in value between lines, such as 10 (required)")
+	base := fs.Float64("base", 0, "value of a line the others are whole intervals from")
+	band := fs.Int("band", 0, "sequence number of the band (default the first)")
+	fs.Parse(args)
+
+	name := fs.Arg(0)
+	if name == "" || *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: goRasterRescue contour [-gdb path] -interval n [-base n] [-band n] [-o file.geojson|file.gpkg|file.shp] <raster>")
+		exit(2)
+	}
+	if *out == "" {
+		*out = outputPath(name + "_contours.geojson")
+	}
+	tracedFormat(*out)
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+	r, err := raster.Open(db, name)
+	check(err)
+	rd, err := r.Read(raster.ReadOptions{Band: *band, Workers: threads, NoData: noDataOverride})
+	check(err)
+	checkUnits(*out, &rd.RasBase)
+
+	slog.Info("tracing contours", "raster", name, "band", *band, "interval", *interval)
+	fields, features, err := writer.Contours(ctx, rd, writer.ContourOptions{Interval: *interval, Base: *base, Units: geoTIFFOptions.Units})
+	check(err)
+	check(writeTraced(ctx, *out, 3, fields, features, r.WKT))
+	fmt.Println(*out)
+}
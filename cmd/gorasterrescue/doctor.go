@@ -0,0 +1,328 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// RasterHealth is what doctor found out about one raster.
+type RasterHealth struct {
+	Name     string
+	Bands    int
+	DataType string
+	Width    int32
+	Height   int32
+	Blocks   int // full resolution blocks checked, pyramid levels not
+	Bad      []string
+	Extent   []float64 // outer edges of the raster
+	Valid    []float64 // edges of the pixels holding data, nil if none or unknown
+	Error    string
+}
+
+// FeatureHealth is what doctor found out about one feature class.
+type FeatureHealth struct {
+	Name     string
+	GeomType string
+	Rows     int
+	Bad      []string
+	Error    string
+}
+
+// checkBlock inflates and decodes one block the way extraction does.
+func checkBlock(data []byte, rb *raster.RasterBase) error {
+	raw, err := raster.InflateBlock(data, rb.CompressionType)
+	if err != nil {
+		return err
+	}
+	_, _, err = raster.DecodeBlock(raw, rb.DataType, int(rb.BlockWidth*rb.BlockHeight))
+	return err
+}
+
+// rasterBands reads the bands band table bndID describes, in its order.
+func rasterBands(gdbFilePath string, bndID int) ([]*raster.RasterBase, error) {
+	bands, err := raster.Bands(gdbFilePath, gdb.TableFileName(bndID))
+	if err != nil {
+		return nil, err
+	}
+	bases := make([]*raster.RasterBase, 0, len(bands))
+	for _, band := range bands {
+		rb, err := raster.NewRasterBase(gdbFilePath, gdb.TableFileName(bndID), band.ID)
+		if err != nil {
+			return nil, err
+		}
+		rb.BaseTab.Close()
+		bases = append(bases, &rb)
+	}
+	return bases, nil
+}
+
+func bandsByID(bands []*raster.RasterBase) map[int]*raster.RasterBase {
+	byID := make(map[int]*raster.RasterBase)
+	for _, rb := range bands {
+		byID[rb.BandID] = rb
+	}
+	return byID
+}
+
+// diagnoseRaster decodes every full resolution block of raster name. When all
+// of them decode it also reads the bands to find where the data actually is.
+func diagnoseRaster(ctx context.Context, gdbFilePath string, mt *gdb.MasterTable, name string) (h RasterHealth) {
+	h.Name = name
+
+	bndID, blkID := raster.TableIDs(mt, name)
+	if bndID == 0 {
+		h.Error = "band or block table missing"
+		return h
+	}
+
+	bands, err := rasterBands(gdbFilePath, bndID)
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	h.Bands = len(bands)
+	if h.Bands == 0 {
+		h.Error = "no bands"
+		return h
+	}
+	minX, minY, maxX, maxY := bands[0].Extent()
+	h.Extent = []float64{minX, minY, maxX, maxY}
+	h.DataType, h.Width, h.Height = bands[0].DataType, bands[0].BandWidth, bands[0].BandHeight
+	bases := bandsByID(bands)
+
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, gdb.TableFileName(blkID))
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	for fid := 0; fid < int(bt.NFeaturesX); fid++ {
+		blk, ok, err := raster.ReadBlockRow(&bt, fid)
+		if err != nil {
+			h.Blocks++
+			h.Bad = append(h.Bad, err.Error())
+			continue
+		}
+		rb := bases[int(blk.BandID)]
+		if !ok || rb == nil || blk.RRDFactor != 0 || blk.Data == nil {
+			continue
+		}
+		h.Blocks++
+		if err := checkBlock(blk.Data, rb); err != nil {
+			h.Bad = append(h.Bad, fmt.Sprintf("band %d row %d col %d: %v", blk.BandID, blk.RowNbr, blk.ColNbr, err))
+		}
+	}
+	bt.Close()
+	if len(h.Bad) > 0 {
+		return h
+	}
+
+	// Only the extent of the blocks is wanted, so their pixels are dropped
+	// rather than kept in memory.
+	discard := raster.ReadOptions{Block: func(raster.Block) error { return nil }}
+	for _, rb := range bands {
+		rd, err := raster.NewRasterDataContext(ctx, gdbFilePath, gdb.TableFileName(blkID), *rb, discard)
+		if err != nil {
+			h.Error = err.Error()
+			return h
+		}
+		rd.BaseTab.Close()
+		if rd.MaxPx < 0 {
+			continue
+		}
+		gt := rb.GeoTransform
+		valid := []float64{
+			gt[0] + float64(rd.MinPx)*gt[1],
+			gt[3] + float64(rd.MaxPy+1)*gt[5],
+			gt[0] + float64(rd.MaxPx+1)*gt[1],
+			gt[3] + float64(rd.MinPy)*gt[5],
+		}
+		if h.Valid == nil {
+			h.Valid = valid
+			continue
+		}
+		h.Valid = []float64{min(h.Valid[0], valid[0]), min(h.Valid[1], valid[1]), max(h.Valid[2], valid[2]), max(h.Valid[3], valid[3])}
+	}
+	return h
+}
+
+// diagnoseFeatures decodes every row of feature class fc.
+func diagnoseFeatures(ctx context.Context, gdbFilePath string, fc gdb.TableInfo) (h FeatureHealth) {
+	h.Name = fc.Name
+
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, gdb.TableFileName(fc.ID))
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	defer bt.Close()
+	h.GeomType = gdb.GeometryTypeName(bt.LayerGeomType)
+	rows := bt.Rows()
+	for {
+		_, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		h.Rows++
+		if err != nil {
+			h.Bad = append(h.Bad, err.Error())
+		}
+	}
+	return h
+}
+
+// rescueJob turns the findings of doctor into a job writing into dir, with
+// one note per dataset explaining the choices.
+func rescueJob(gdbFilePath string, dir string, rasters []RasterHealth, features []FeatureHealth) (Job, map[string]string) {
+	job := Job{GDB: gdbFilePath}
+	notes := make(map[string]string)
+
+	for _, h := range rasters {
+		if h.Error != "" {
+			notes[h.Name] = "unreadable: " + h.Error
+			continue
+		}
+		r := JobRaster{Name: h.Name, Output: filepath.Join(dir, h.Name+".tif"), Format: "gtiff"}
+		switch {
+		case len(h.Bad) > 0:
+			r.Verify = true
+			notes[h.Name] = fmt.Sprintf("%d of %d blocks failed to decode; they will be skipped", len(h.Bad), h.Blocks)
+		case h.Valid == nil:
+			notes[h.Name] = "no valid pixels found"
+		case !sameWindow(h.Valid, h.Extent):
+			r.Window = h.Valid
+			notes[h.Name] = "window trimmed to the valid pixels; full extent is " + yamlFloats(h.Extent)
+		}
+		job.Rasters = append(job.Rasters, r)
+	}
+
+	for _, h := range features {
+		if h.Error != "" {
+			notes[h.Name] = "unreadable: " + h.Error
+			continue
+		}
+		if len(h.Bad) > 0 {
+			notes[h.Name] = fmt.Sprintf("%d of %d rows failed to decode; they will be skipped", len(h.Bad), h.Rows)
+		}
+		job.Features = append(job.Features, JobFeature{Name: h.Name, Output: filepath.Join(dir, h.Name+".geojson"), Format: "geojson"})
+	}
+	return job, notes
+}
+
+func sameWindow(a []float64, b []float64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func printDiagnosis(w io.Writer, rasters []RasterHealth, features []FeatureHealth) {
+	if jsonOutput {
+		check(json.NewEncoder(w).Encode(map[string]interface{}{"rasters": rasters, "features": features}))
+		return
+	}
+
+	t := newTable("name", "kind", "detail", "units", "status")
+	for _, h := range rasters {
+		switch {
+		case h.Error != "":
+			t.add(healthBad, h.Name, "raster", "", "", "ERROR "+h.Error)
+		case len(h.Bad) > 0:
+			t.add(healthBad, h.Name, "raster", fmt.Sprintf("%d band(s) %s %dx%d", h.Bands, h.DataType, h.Width, h.Height), fmt.Sprintf("%d base blocks", h.Blocks), fmt.Sprintf("%d BAD", len(h.Bad)))
+		case h.Valid == nil:
+			t.add(healthWarn, h.Name, "raster", fmt.Sprintf("%d band(s) %s %dx%d", h.Bands, h.DataType, h.Width, h.Height), fmt.Sprintf("%d base blocks", h.Blocks), "empty")
+		default:
+			t.add(healthOK, h.Name, "raster", fmt.Sprintf("%d band(s) %s %dx%d", h.Bands, h.DataType, h.Width, h.Height), fmt.Sprintf("%d base blocks", h.Blocks), "ok")
+		}
+	}
+	for _, h := range features {
+		switch {
+		case h.Error != "":
+			t.add(healthBad, h.Name, "feature class", "", "", "ERROR "+h.Error)
+		case len(h.Bad) > 0:
+			t.add(healthBad, h.Name, "feature class", h.GeomType, fmt.Sprintf("%d rows", h.Rows), fmt.Sprintf("%d BAD", len(h.Bad)))
+		default:
+			t.add(healthOK, h.Name, "feature class", h.GeomType, fmt.Sprintf("%d rows", h.Rows), "ok")
+		}
+	}
+	t.render(w)
+
+	for _, h := range rasters {
+		for _, b := range h.Bad {
+			fmt.Fprintf(w, "%s: %s\n", h.Name, b)
+		}
+	}
+	for _, h := range features {
+		for _, b := range h.Bad {
+			fmt.Fprintf(w, "%s: %s\n", h.Name, b)
+		}
+	}
+}
+
+func runDoctor(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	jobPath := fs.String("job", "", "also write a rescue job for extract -job to this file (- for stdout)")
+	dir := fs.String("o", cmp.Or(outputDir, "rescued"), "output directory used in the job")
+	fs.Parse(args)
+
+	// The diagnosis reads past every bad block to count them, whatever
+	// --on-error says.
+	ctx = gdb.WithOnError(ctx, gdb.SkipBad)
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+	mt := db.MasterTable()
+
+	// A diagnosis cut short by an interrupt would report healthy data as
+	// bad, so it is dropped rather than printed.
+	rasters := make([]RasterHealth, 0)
+	for _, r := range mt.Rasters {
+		slog.Info("checking raster", "name", r.Name)
+		rasters = append(rasters, diagnoseRaster(ctx, db.Path, mt, r.Name))
+		check(ctx.Err())
+	}
+	features := make([]FeatureHealth, 0)
+	for _, fc := range featureClasses(ctx, db.Path, mt) {
+		slog.Info("checking feature class", "name", fc.Name)
+		features = append(features, diagnoseFeatures(ctx, db.Path, fc))
+		check(ctx.Err())
+	}
+
+	if *jobPath != "-" {
+		printDiagnosis(os.Stdout, rasters, features)
+	}
+	if *jobPath == "" {
+		return
+	}
+
+	job, notes := rescueJob(*gdbDir, *dir, rasters, features)
+	w := os.Stdout
+	runWith := "<file>"
+	if *jobPath != "-" {
+		f, err := os.Create(*jobPath)
+		check(err)
+		defer f.Close()
+		w = f
+		runWith = *jobPath
+	}
+	writeJob(w, job, []string{
+		"goRasterRescue job written by doctor for " + *gdbDir,
+		"Edit it as needed (drop entries, change windows, outputs or formats), then run",
+		"  goRasterRescue extract -job " + runWith,
+	}, notes)
+	if *jobPath != "-" && logLevel <= slog.LevelWarn {
+		fmt.Fprintf(os.Stderr, "job written to %s\n", *jobPath)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// onError is set by the global --on-error flag: what is done with the rows
+// and blocks that cannot be read or decoded. errorLogPath is set by
+// --error-log, the file listing them.
+var (
+	onError      = gdb.SkipBad
+	errorLogPath = ""
+)
+
+// errLog is the --error-log of the run, or nil.
+var errLog *errorLog
+
+// errorLog lists what was lost to damage, one JSON object a line, for the
+// owners of the data: every row of a table that could not be read, by table
+// and object id, and every block of a raster left as nodata, by raster, band
+// and place in the block grid. A raster cannot leave a block out, so the
+// blocks skipped are filled with nodata either way.
+type errorLog struct {
+	mu    sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	ctx   context.Context
+	names map[string]map[int]string // table names by id, by geodatabase
+}
+
+// rowRecord is a line of the error log for a row.
+type rowRecord struct {
+	Kind   string `json:"kind"`  // "row"
+	Table  string `json:"table"` // name, if the master table lists it
+	File   string `json:"file"`  // the .gdbtable
+	OID    int    `json:"oid"`
+	Action string `json:"action"` // skip, fill or abort
+	Reason string `json:"reason"`
+}
+
+// blockRecord is a line of the error log for a block. Blocks whose row could
+// not be read have row and column -1.
+type blockRecord struct {
+	Kind   string `json:"kind"` // "block"
+	Raster string `json:"raster"`
+	Band   int32  `json:"band"`
+	Output string `json:"output"`
+	Row    int32  `json:"block_row"`
+	Col    int32  `json:"block_col"`
+	Action string `json:"action"` // fill or abort
+	Reason string `json:"reason"`
+}
+
+// openErrorLog creates the error log at path. Tables are named by opening
+// their geodatabase again under ctx.
+func openErrorLog(ctx context.Context, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	errLog = &errorLog{f: f, enc: json.NewEncoder(f), ctx: ctx, names: make(map[string]map[int]string)}
+	return nil
+}
+
+// setOnError sets onError from the value of --on-error, or ends the program
+// if it is not one.
+func setOnError(s string) {
+	p, err := gdb.ParseOnError(s)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--on-error:", err)
+		exit(2)
+	}
+	onError = p
+}
+
+// row logs row e, reported by gdb.WithRowReport.
+func (l *errorLog) row(e *gdb.RowError) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(rowRecord{"row", l.tableName(e.Table), e.Table, e.FID, onError.String(), e.Err.Error()})
+}
+
+// blocks logs the blocks skipped among suspect, read from band of raster name
+// into output.
+func (l *errorLog) blocks(name string, band int32, output string, suspect []raster.SuspectBlock) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range suspect {
+		if s.Skipped {
+			l.write(blockRecord{"block", name, band, output, s.RowNbr, s.ColNbr, "fill", strings.TrimPrefix(s.Reason, "skipped: ")})
+		}
+	}
+}
+
+// aborted logs the block that ended the read of band of raster name under
+// --on-error abort, if err is one.
+func (l *errorLog) aborted(name string, band int32, output string, err error) {
+	var s raster.SuspectBlock
+	if l == nil || !errors.As(err, &s) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(blockRecord{"block", name, band, output, s.RowNbr, s.ColNbr, "abort", strings.TrimPrefix(s.Reason, "skipped: ")})
+}
+
+func (l *errorLog) write(rec interface{}) {
+	if err := l.enc.Encode(rec); err != nil {
+		check(fmt.Errorf("writing the error log: %w", err))
+	}
+}
+
+// tableName returns the name the master table gives the .gdbtable file, or
+// "" if there is none.
+func (l *errorLog) tableName(file string) string {
+	base := gdb.TableFileName(0) + ".gdbtable"
+	if len(file) < len(base) {
+		return ""
+	}
+	dir := file[:len(file)-len(base)]
+	var id int
+	if _, err := fmt.Sscanf(file[len(dir):], "a%08x.gdbtable", &id); err != nil {
+		return ""
+	}
+	names, ok := l.names[dir]
+	if !ok {
+		names = make(map[int]string)
+		if db, err := gdb.OpenContext(l.ctx, dir); err == nil {
+			for _, t := range db.MasterTable().Tables {
+				names[t.ID] = t.Name
+			}
+			db.Close()
+		}
+		l.names[dir] = names
+	}
+	return names[id]
+}
+
+func (l *errorLog) close() {
+	if l != nil {
+		check(l.f.Close())
+	}
+}
+
+// checkAbort ends the program with err if it is a row or block that ended a
+// read under --on-error abort, which stops the command, not just the dataset
+// being read.
+func checkAbort(err error) {
+	var re *gdb.RowError
+	var s raster.SuspectBlock
+	if onError == gdb.AbortOnBad && (errors.As(err, &re) || errors.As(err, &s)) {
+		check(err)
+	}
+}
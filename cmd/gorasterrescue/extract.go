@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// geoTIFFOptions are the creation options, set with the global --co flag,
+// of the GeoTIFFs extract, carve and mosaic overviews write.
+var geoTIFFOptions writer.GeoTIFFOptions
+
+// stacItems is set by the global --stac flag: every GeoTIFF extract, carve
+// and mosaic overviews write gets a STAC Item beside it.
+var stacItems = false
+
+// auxXML is set by the global --aux-xml flag: every GeoTIFF extract and
+// carve write gets the statistics and attribute table of its raster in a
+// .aux.xml beside it.
+var auxXML = false
+
+// noDataOverride is set by the global --nodata flag: the value the pixels
+// without data of every raster read are written as, in place of the default
+// of its data type, nil for that. The nodata a job or config sets for a
+// raster wins over it.
+var noDataOverride *float64
+
+// noDataOf returns the value of the pixels without data of a band of
+// dataType: that of --nodata, failing if it does not fit the type, or the
+// default of the type.
+func noDataOf(dataType string) (float64, error) {
+	return raster.ReadOptions{NoData: noDataOverride}.NoDataFor(dataType)
+}
+
+// validityMask is set by the global --mask flag: the mask extract leaves
+// the pixels of every raster as nodata by, nil for none.
+var validityMask *raster.Mask
+
+// composite is set by the global --composite flag: the sequence numbers of
+// the bands extract stretches into the red, green and blue of an 8-bit
+// composite of each raster, nil for none.
+var composite []int
+
+// parseComposite reads the bands of --composite, three sequence numbers
+// separated by commas, such as 4,3,2 for a false-color composite.
+func parseComposite(s string) ([]int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%q is not three bands, such as 4,3,2", s)
+	}
+	bands := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%q is not a band number", p)
+		}
+		bands[i] = n
+	}
+	return bands, nil
+}
+
+// writeComposite writes the composite --composite asks for of raster r,
+// extracted to out, beside it, reading the bands it is made of again as
+// opts reads them. A raster without one of them gets none, with a warning.
+func writeComposite(ctx context.Context, r *raster.Raster, out string, opts raster.ReadOptions) error {
+	if composite == nil {
+		return nil
+	}
+	var rgb [3][]uint8
+	var rb raster.RasterBase
+	for i, seq := range composite {
+		if !slices.ContainsFunc(r.Bands, func(b raster.RasterBand) bool { return int(b.SequenceNbr) == seq }) {
+			slog.Warn("no composite", "raster", r.Name, "band", seq, "bands", len(r.Bands))
+			return nil
+		}
+		bopts := opts
+		bopts.Band, bopts.Start, bopts.Block, bopts.Progress = seq, 0, nil, nil
+		rd, err := r.Read(bopts)
+		if err != nil {
+			return err
+		}
+		rgb[i], rb = writer.Stretch(rd.GeoData, rd.NoData), rd.RasBase
+	}
+	path := writer.CompositePath(out)
+	slog.Info("writing composite", "file", path, "bands", composite)
+	return writer.WriteComposite(ctx, path, &rb, rgb[0], rgb[1], rgb[2], r.WKT)
+}
+
+// scale is set by the global --scale flag: how extract stretches every band
+// into an 8-bit rendition beside its GeoTIFF, nil for none.
+var scale *writer.Scale
+
+// bandPath returns where extract writes band of raster r, extracted to out.
+func bandPath(out string, r *raster.Raster, band raster.RasterBand) string {
+	if len(r.Bands) > 1 {
+		return fmt.Sprintf("%s_b%d.tif", strings.TrimSuffix(out, ".tif"), band.SequenceNbr)
+	}
+	return out
+}
+
+// writeScaled writes the 8-bit renditions --scale asks for of the bands of
+// raster r, extracted to out, beside their GeoTIFFs, reading the bands again
+// as opts reads them. They are compressed with DEFLATE unless --co asks for
+// another compression, laid out as it asks, and hold the stretched values
+// as they are.
+func writeScaled(ctx context.Context, r *raster.Raster, out string, opts raster.ReadOptions) error {
+	if scale == nil {
+		return nil
+	}
+	wopts := geoTIFFOptions
+	wopts.Unscale, wopts.Units, wopts.Reclass, wopts.OutputType = false, "", nil, ""
+	if wopts.Compress == "NONE" {
+		wopts.Compress = "DEFLATE"
+	}
+	if wopts.Predictor == 3 {
+		wopts.Predictor = 2
+	}
+	for _, band := range r.Bands {
+		bopts := opts
+		bopts.Band, bopts.Start, bopts.Block, bopts.Progress = int(band.SequenceNbr), 0, nil, nil
+		rd, err := r.Read(bopts)
+		if err != nil {
+			return err
+		}
+		rb := rd.RasBase
+		rb.DataType, rb.Scale, rb.Offset, rb.Unit = "uint8", 0, 0, ""
+		rb.ColorInterp, rb.ColorMap = "Gray", nil
+		path := writer.ScaledPath(bandPath(out, r, band))
+		slog.Info("writing 8-bit rendition", "file", path, "band", band.SequenceNbr)
+		scaled := &raster.RasterData{GeoData: raster.Buffer[uint8](scale.Stretch(rd)), RasBase: rb}
+		if err := writer.WriteGeoTIFF(ctx, path, scaled, r.WKT); err != nil {
+			return err
+		}
+		if err := writer.RewriteGeoTIFF(ctx, path, &rb, 0, r.WKT, wopts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePAM writes the .aux.xml of the GeoTIFF at path, of band of r, rb, if
+// --aux-xml asks for one. A window of the band, whole false, leaves out the
+// statistics, which are of all of it; a band whose pixels --unscale or
+// --units rewrite gets none, its statistics and table being of the pixels
+// as they were.
+func writePAM(path string, r *raster.Raster, rb *raster.RasterBase, band raster.RasterBand, whole bool) error {
+	if _, _, rewritten := geoTIFFOptions.Written(rb, 0); !auxXML || rewritten {
+		return nil
+	}
+	var stats *raster.Statistics
+	if whole {
+		var err error
+		if stats, err = r.Statistics(band); err != nil {
+			return err
+		}
+	}
+	rat, err := r.AttributeTable()
+	if err != nil {
+		return err
+	}
+	return writer.WritePAM(path, stats, rat)
+}
+
+// writeSTACItem writes the STAC Item of the GeoTIFF at path, of band rb as
+// --unscale and --units leave it, if --stac asks for one, warning if it is left without
+// a footprint.
+func writeSTACItem(path string, rb *raster.RasterBase, noData float64, wkt string) error {
+	if !stacItems {
+		return nil
+	}
+	rb, noData, _ = geoTIFFOptions.Written(rb, noData)
+	err := writer.WriteSTACItem(path, rb, noData, wkt, geoTIFFOptions.COG)
+	if errors.Is(err, writer.ErrNoFootprint) {
+		slog.Warn("STAC item written without a footprint", "file", writer.STACItemPath(path), "err", err)
+		return nil
+	}
+	return err
+}
+
+// writeVRT writes the virtual raster stacking the GeoTIFFs of the bands of a
+// raster written to out, for a raster of several bands, so that an RGB image
+// opens as one.
+func writeVRT(out string, bands []writer.VRTBand, wkt string) error {
+	if len(bands) < 2 {
+		return nil
+	}
+	path := writer.VRTPath(out)
+	slog.Info("stacking bands", "file", path, "bands", len(bands))
+	return writer.WriteVRT(path, bands, wkt)
+}
+
+// checkUnits warns that band rb, going to path, keeps its units if --units
+// asks for others it cannot be converted to, not being of a length.
+func checkUnits(path string, rb *raster.RasterBase) {
+	units := geoTIFFOptions.Units
+	if units == "" || raster.NormalizeUnit(rb.Unit) == raster.NormalizeUnit(units) {
+		return
+	}
+	if _, ok := raster.UnitFactor(rb.Unit, units); !ok {
+		slog.Warn("cannot convert units", "file", path, "unit", rb.Unit, "to", units)
+	}
+}
+
+// extractRaster writes every band of raster name as a GeoTIFF. A single band
+// goes to out; several bands get a _b<n> suffix before the extension, and
+// a virtual raster stacking them goes beside them, out with .vrt, as do
+// the composite --composite and the band math --calc ask for, and the 8-bit
+// renditions of --scale beside the bands. With a
+// Cutline in opts the bands are cut to its bounds within the Window of opts,
+// and pixels outside it left as nodata, as are those --mask does not keep.
+// Blocks go into the GeoTIFF as they
+// are read, with a checkpoint beside it; with resume, bands already written
+// are left alone and bands cut short carry on from their checkpoint. Blocks
+// that cannot be read or decoded are left as nodata and logged, with the
+// share of the band still usable. It stops at the first band that cannot be
+// read at all, returning the paths written so far.
+func extractRaster(db *gdb.Geodatabase, name string, out string, opts raster.ReadOptions, resume bool) ([]string, error) {
+	r, err := raster.Open(db, name)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Cutline != nil {
+		opts.Window = opts.Cutline.Window(opts.Window)
+	}
+	if opts.Mask == nil {
+		opts.Mask = validityMask
+	}
+	if opts.NoData == nil {
+		opts.NoData = noDataOverride
+	}
+	paths := make([]string, 0)
+	stack := make([]writer.VRTBand, 0, len(r.Bands))
+	calc := newBandCalc(r)
+	for _, band := range r.Bands {
+		path := bandPath(out, r, band)
+
+		bopts := opts
+		bopts.Band = int(band.SequenceNbr)
+		rb, err := r.Band(bopts.Band)
+		if err == nil && opts.Window != nil {
+			rb, err = rb.Crop(opts.Window)
+		}
+		if err == nil {
+			out, _, _ := geoTIFFOptions.Written(&rb, 0)
+			err = geoTIFFOptions.Check(out.DataType)
+		}
+		if err != nil {
+			return paths, err
+		}
+		checkUnits(path, &rb)
+		noData, err := opts.NoDataFor(rb.DataType)
+		if err != nil {
+			return paths, err
+		}
+		written, writtenNoData, _ := geoTIFFOptions.Written(&rb, noData)
+		stack = append(stack, writer.VRTBand{Path: path, RB: written, NoData: writtenNoData})
+		cp, err := openCheckpoint(db.Context(), path, name, &rb, opts, r.WKT, resume)
+		if err != nil {
+			return paths, err
+		}
+		if cp == nil {
+			slog.Info("already extracted", "file", path)
+			runReport.band(db.Path, name, path, nil, raster.Damage{})
+			paths = append(paths, path)
+			continue
+		}
+
+		slog.Info("reading raster", "name", name, "band", band.SequenceNbr, "from_block", cp.blocks)
+		bopts.Start = cp.blocks
+		bopts.Block = cp.tif.WriteBlock
+		if cp.blocks == 0 {
+			bopts.Block = calc.capture(bopts.Band, &rb, bopts.Block)
+		}
+		p := newProgress(name, band.SequenceNbr)
+		bopts.Progress = func(pr raster.Progress) {
+			cp.progress(pr)
+			if p != nil {
+				p.update(pr)
+			}
+		}
+		rd, err := r.Read(bopts)
+		p.clear()
+		if err != nil {
+			cp.interrupt()
+			errLog.aborted(name, band.SequenceNbr, path, err)
+			fmt.Fprintf(os.Stderr, "%s is incomplete; run again with -resume to carry on\n", path)
+			return paths, err
+		}
+		suspect, err := cp.finish(rd.Suspect)
+		if err != nil {
+			return paths, err
+		}
+		if err := writer.RewriteGeoTIFF(db.Context(), path, &rb, noData, r.WKT, geoTIFFOptions); err != nil {
+			return paths, err
+		}
+		if err := writeSTACItem(path, &rb, noData, r.WKT); err != nil {
+			return paths, err
+		}
+		if err := writePAM(path, r, &rb, band, opts.Window == nil); err != nil {
+			return paths, err
+		}
+		for _, s := range suspect {
+			slog.Warn("suspect block", "file", path, "row", s.RowNbr, "col", s.ColNbr, "reason", s.Reason)
+		}
+		errLog.blocks(name, band.SequenceNbr, path, suspect)
+		d := rd.RasBase.Damage(suspect)
+		runReport.band(db.Path, name, path, &rd.RasBase, d)
+		if d.Blocks > 0 || d.Unplaced > 0 {
+			// Rows that could not be read may have held blocks of the band,
+			// so with any of them the share usable is only a bound.
+			usable := fmt.Sprintf("%.1f%%", 100*(1-d.Fraction))
+			if d.Unplaced > 0 {
+				usable = "at most " + usable
+			}
+			lost(db.Context())
+			slog.Warn("partial extraction", "file", path, "skipped_blocks", d.Blocks, "unplaced_rows", d.Unplaced,
+				"nodata_pixels", d.Pixels, "usable", usable)
+		}
+		paths = append(paths, path)
+	}
+	if err := writeComposite(db.Context(), r, out, opts); err != nil {
+		return paths, err
+	}
+	if err := writeScaled(db.Context(), r, out, opts); err != nil {
+		return paths, err
+	}
+	if err := calc.write(db.Context(), r, out, opts); err != nil {
+		return paths, err
+	}
+	return paths, writeVRT(out, stack, r.WKT)
+}
+
+// rasterNames lists the rasters of the master table.
+func rasterNames(mt *gdb.MasterTable) []string {
+	names := make([]string, 0, len(mt.Rasters))
+	for _, r := range mt.Rasters {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// parseSize reads a number of bytes, with an optional K, M, G or T suffix
+// for powers of 1024 and an optional B or iB after it.
+func parseSize(s string) (int64, error) {
+	units := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	num := strings.TrimRight(strings.ToUpper(s), "IB")
+	unit := ""
+	if n := len(num); n > 0 && units[num[n-1:]] > 1 {
+		num, unit = num[:n-1], num[n-1:]
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("%q is not a size such as 512M or 2G", s)
+	}
+	return int64(v * float64(units[unit])), nil
+}
+
+func runExtract(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	out := fs.String("o", "", "output GeoTIFF, - for stdout (default <raster>.tif)")
+	verify := fs.Bool("verify", false, "decode every block twice and report blocks whose decodes differ")
+	verifyCodec := fs.Bool("verify-codec", false, "with -verify, use an independent zlib implementation for the second decode")
+	jobPath := fs.String("job", "", "run a job file written by doctor instead of extracting one raster")
+	workers := fs.Int("workers", threads, "blocks decoded at once (default one per CPU)")
+	resume := fs.Bool("resume", false, "carry on from the checkpoints of an interrupted run, skipping the GeoTIFFs it finished")
+	blockCache := fs.Int("block-cache", 0, "decoded blocks kept for the overlapping windows of a job (default none)")
+	maxMemory := fs.String("max-memory", "", "memory to keep within, such as 512M or 2G, decoding fewer blocks at once and caching fewer to fit (default no limit)")
+	cutline := fs.String("cutline", "", "GeoJSON file of polygons, in the coordinates of the raster, to cut the raster to, leaving pixels outside them as nodata")
+	fs.Parse(args)
+
+	opts := raster.ReadOptions{Verify: *verify || *verifyCodec, VerifyCodec: *verifyCodec, Workers: *workers}
+	if *blockCache > 0 {
+		opts.Cache = raster.NewBlockCache(*blockCache)
+	}
+	if *maxMemory != "" {
+		limit, err := parseSize(*maxMemory)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-max-memory:", err)
+			exit(2)
+		}
+		// The decoders get half, the cache a quarter, and the rest is left
+		// to the tables read, the GeoTIFF written and the garbage collector,
+		// which the limit makes collect sooner as it is approached.
+		debug.SetMemoryLimit(limit)
+		opts.MaxMemory = limit / 2
+		if opts.Cache != nil {
+			opts.Cache.SetMaxBytes(limit / 4)
+		}
+	}
+	if *cutline != "" {
+		cut, err := raster.ReadCutline(*cutline)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-cutline:", err)
+			exit(2)
+		}
+		opts.Cutline = cut
+	}
+	if *jobPath != "" {
+		job, err := readJob(*jobPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		_, err = runJob(ctx, job, *gdbDir, opts, *resume)
+		check(err)
+		return
+	}
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+
+	if fs.NArg() == 0 {
+		t := newTable("raster")
+		for _, r := range db.Rasters() {
+			t.add(healthNone, r.Name)
+		}
+		t.render(os.Stdout)
+		return
+	}
+
+	runReport.found(db.Path, "raster", rasterNames(db.MasterTable()))
+	name := fs.Arg(0)
+	if !db.MasterTable().IsRaster(name) {
+		fmt.Fprintf(os.Stderr, "no raster called %q\n", name)
+		exit(1)
+	}
+	r := config.raster(JobRaster{Name: name, Output: *out})
+	if r.Output == "" {
+		r.Output = outputPath(name + ".tif")
+	}
+	// A GeoTIFF is not written front to back, so one for stdout goes to a
+	// temporary file first and is copied once finished.
+	toStdout := r.Output == "-"
+	if toStdout {
+		tmp, err := os.MkdirTemp("", "goRasterRescue")
+		check(err)
+		r.Output = filepath.Join(tmp, name+".tif")
+	}
+	check(os.MkdirAll(filepath.Dir(r.Output), 0755))
+	opts.Verify = opts.Verify || r.Verify
+	opts.Window, opts.NoData = r.Window, r.NoData
+	if opts.Cutline == nil && r.Cutline != "" {
+		opts.Cutline, err = raster.ReadCutline(r.Cutline)
+		check(err)
+	}
+
+	paths, err := extractRaster(db, name, r.Output, opts, *resume)
+	if toStdout {
+		if err == nil && len(paths) != 1 {
+			err = fmt.Errorf("%s has %d bands, which cannot all go to stdout", name, len(paths))
+		}
+		if err == nil {
+			err = copyToStdout(paths[0])
+		}
+		os.RemoveAll(filepath.Dir(r.Output))
+	} else {
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+	}
+	if err != nil {
+		runReport.failed(db.Path, "raster", name, err)
+	}
+	check(err)
+}
+
+// copyToStdout copies the file at path to stdout.
+func copyToStdout(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// featureClasses lists the tables of the master table that carry a shape
+// field, leaving out the system tables and the raster internals. Tables that
+// cannot be opened are reported on stderr.
+func featureClasses(ctx context.Context, gdbFilePath string, mt *gdb.MasterTable) []gdb.TableInfo {
+	fcs := make([]gdb.TableInfo, 0)
+	for _, t := range mt.Tables {
+		if strings.HasPrefix(t.Name, "fras_") || strings.HasPrefix(t.Name, "GDB_") || mt.IsRaster(t.Name) {
+			continue
+		}
+		if !gdb.TableExists(gdbFilePath, t.ID) {
+			continue
+		}
+		bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, gdb.TableFileName(t.ID))
+		if err != nil {
+			check(ctx.Err())
+			slog.Warn("skipping table", "name", t.Name, "err", err)
+			continue
+		}
+		if _, ok := bt.HasShape(); ok {
+			fcs = append(fcs, t)
+		}
+		bt.Close()
+	}
+	return fcs
+}
+
+// bboxContext returns ctx selecting the features -bbox s names, if any, or
+// ends the program if s is not a box.
+func bboxContext(ctx context.Context, s string) context.Context {
+	if s == "" {
+		return ctx
+	}
+	b, err := gdb.ParseBBox(s)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-bbox:", err)
+		exit(2)
+	}
+	return gdb.WithBBox(ctx, b)
+}
+
+// featureFormats maps the feature export formats onto their file extensions.
+var featureFormats = map[string]string{
+	"geojson": ".geojson",
+	"shp":     ".shp",
+	"gpkg":    ".gpkg",
+}
+
+// exportFeatureClass writes feature class fc to path in one of the
+// featureFormats. A GeoPackage written this way holds fc alone; see
+// WriteGeoPackage for several feature classes in one file.
+func exportFeatureClass(ctx context.Context, gdbFilePath string, fc gdb.TableInfo, format string, path string) error {
+	switch format {
+	case "geojson":
+		_, features, err := writer.ReadFeatures(ctx, gdbFilePath, gdb.TableFileName(fc.ID))
+		if err != nil {
+			return err
+		}
+		check(writer.WriteGeoJSON(path, features))
+	case "shp":
+		bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, gdb.TableFileName(fc.ID))
+		if err != nil {
+			return err
+		}
+		shp, _ := bt.HasShape()
+		layerGeomType, wkt := bt.LayerGeomType, shp.Shp.WKT
+		bt.Close()
+		fields, features, err := writer.ReadFeatures(ctx, gdbFilePath, gdb.TableFileName(fc.ID))
+		if err != nil {
+			return err
+		}
+		check(writer.WriteShapefile(strings.TrimSuffix(path, ".shp"), layerGeomType, fields, features, wkt))
+	case "gpkg":
+		l, err := writer.NewGpkgLayer(ctx, gdbFilePath, fc)
+		if err != nil {
+			return err
+		}
+		check(writer.WriteGeoPackage(ctx, path, []writer.GpkgLayer{l}))
+	default:
+		return fmt.Errorf("unknown feature format %q", format)
+	}
+	return nil
+}
+
+func runFeatures(ctx context.Context, args []string) {
+	// The command is checked before its flags, which hold no meaning for
+	// another, and before the geodatabase is opened.
+	if len(args) < 1 || args[0] != "list" && args[0] != "export" {
+		fmt.Fprintln(os.Stderr, "usage: goRasterRescue features list|export [flags] [name...]")
+		exit(2)
+	}
+
+	fs := flag.NewFlagSet("features "+args[0], flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	out := fs.String("o", cmp.Or(outputDir, "."), "output directory for export")
+	format := fs.String("format", "geojson", "export format: geojson, shp, or gpkg (one file for all feature classes)")
+	where := fs.String("where", "", "export only the features whose FIELD=value, looked up in the attribute index of the field if there is one")
+	bbox := fs.String("bbox", "", "export only the features whose envelope meets minx,miny,maxx,maxy, looked up in the spatial index if there is one")
+	fs.Parse(args[1:])
+	wctx := bboxContext(whereContext(ctx, *where), *bbox)
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+	mt := db.MasterTable()
+	fcs := featureClasses(ctx, db.Path, mt)
+
+	switch args[0] {
+	case "list":
+		t := newTable("name", "geometry", "rows", "indexes")
+		for _, fc := range fcs {
+			bt, err := gdb.NewBaseTableContext(ctx, db.Path, gdb.TableFileName(fc.ID))
+			if err != nil {
+				check(ctx.Err())
+				t.add(healthBad, fc.Name, "error", err.Error(), tableIndexes(ctx, db.Path, fc))
+				continue
+			}
+			t.add(healthNone, fc.Name, gdb.GeometryTypeName(bt.LayerGeomType), bt.NFeaturesX, tableIndexes(ctx, db.Path, fc))
+			bt.Close()
+		}
+		t.render(os.Stdout)
+
+	case "export":
+		if _, ok := featureFormats[*format]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+			exit(2)
+		}
+		wanted := make(map[string]bool)
+		for _, name := range fs.Args() {
+			wanted[name] = true
+		}
+		check(os.MkdirAll(*out, 0755))
+		runReport.found(db.Path, "feature class", tableNames(fcs))
+
+		exported := make(map[string]bool)
+		layers := make([]writer.GpkgLayer, 0)
+		layerFCs := make([]gdb.TableInfo, 0)
+		for _, fc := range fcs {
+			if len(wanted) > 0 && !wanted[fc.Name] {
+				continue
+			}
+			exported[fc.Name] = true
+			slog.Info("exporting feature class", "name", fc.Name, "format", *format)
+
+			// All feature classes go into one GeoPackage named after the
+			// geodatabase.
+			if *format == "gpkg" {
+				l, err := writer.NewGpkgLayer(wctx, db.Path, fc)
+				if err != nil {
+					check(ctx.Err())
+					runReport.failed(db.Path, "feature class", fc.Name, err)
+					checkAbort(err)
+					lost(ctx)
+					slog.Warn("skipping feature class", "name", fc.Name, "err", err)
+				} else {
+					layers = append(layers, l)
+					layerFCs = append(layerFCs, fc)
+				}
+			} else {
+				path := filepath.Join(*out, fc.Name+featureFormats[*format])
+				if err := exportFeatureClass(wctx, db.Path, fc, *format, path); err != nil {
+					check(ctx.Err())
+					runReport.failed(db.Path, "feature class", fc.Name, err)
+					checkAbort(err)
+					lost(ctx)
+					slog.Warn("skipping feature class", "name", fc.Name, "err", err)
+				} else {
+					runReport.wrote("feature class", fc.Name, db.Path, fc.ID, path)
+					fmt.Println(path)
+				}
+			}
+			printAttachments(ctx, db.Path, mt, fc.Name, *out)
+		}
+		if len(layers) > 0 {
+			path := filepath.Join(*out, datasetName(db.Path)+".gpkg")
+			check(writer.WriteGeoPackage(ctx, path, layers))
+			for _, fc := range layerFCs {
+				runReport.wrote("feature class", fc.Name, db.Path, fc.ID, path)
+			}
+			fmt.Println(path)
+		}
+
+		for name := range wanted {
+			if !exported[name] {
+				fmt.Fprintf(os.Stderr, "no feature class called %q\n", name)
+			}
+		}
+
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// Job is a list of datasets to rescue from one geodatabase. Jobs are written
+// by doctor as a small subset of YAML, edited by hand and run by extract -job.
+type Job struct {
+	GDB      string
+	Rasters  []JobRaster
+	Features []JobFeature
+}
+
+// JobRaster is one raster to extract. Window is minx, miny, maxx, maxy in the
+// raster's coordinate system, or nil for the whole raster. Cutline is a
+// GeoJSON file of polygons, in the same coordinates, outside which pixels
+// are left as nodata, or "" for none. NoData is the value of the pixels
+// without data, or nil for the default of the data type.
+type JobRaster struct {
+	Name    string
+	Output  string
+	Format  string
+	Window  []float64
+	Cutline string
+	Verify  bool
+	NoData  *float64
+}
+
+// JobFeature is one feature class to export.
+type JobFeature struct {
+	Name   string
+	Output string
+	Format string
+}
+
+// yamlString quotes s if it would not read back as the same plain scalar.
+func yamlString(s string) string {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s, ":#[]{},&*!|>'\"%@`") || strings.HasPrefix(s, "-") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func yamlFloats(vals []float64) string {
+	s := make([]string, len(vals))
+	for i, v := range vals {
+		s[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
+
+// writeJob writes job as YAML. Each line of comment goes above the job, and
+// notes[name] above the entry of dataset name.
+func writeJob(w io.Writer, job Job, comment []string, notes map[string]string) {
+	for _, c := range comment {
+		fmt.Fprintf(w, "# %s\n", c)
+	}
+	fmt.Fprintf(w, "gdb: %s\n", yamlString(job.GDB))
+
+	fmt.Fprintln(w, "rasters:")
+	for _, r := range job.Rasters {
+		if note, ok := notes[r.Name]; ok {
+			fmt.Fprintf(w, "  # %s\n", note)
+		}
+		fmt.Fprintf(w, "  - name: %s\n", yamlString(r.Name))
+		fmt.Fprintf(w, "    output: %s\n", yamlString(r.Output))
+		fmt.Fprintf(w, "    format: %s\n", r.Format)
+		if r.Window != nil {
+			fmt.Fprintf(w, "    window: %s\n", yamlFloats(r.Window))
+		}
+		if r.Cutline != "" {
+			fmt.Fprintf(w, "    cutline: %s\n", yamlString(r.Cutline))
+		}
+		fmt.Fprintf(w, "    verify: %t\n", r.Verify)
+		if r.NoData != nil {
+			fmt.Fprintf(w, "    nodata: %s\n", strconv.FormatFloat(*r.NoData, 'g', -1, 64))
+		}
+	}
+
+	fmt.Fprintln(w, "features:")
+	for _, f := range job.Features {
+		if note, ok := notes[f.Name]; ok {
+			fmt.Fprintf(w, "  # %s\n", note)
+		}
+		fmt.Fprintf(w, "  - name: %s\n", yamlString(f.Name))
+		fmt.Fprintf(w, "    output: %s\n", yamlString(f.Output))
+		fmt.Fprintf(w, "    format: %s\n", f.Format)
+	}
+}
+
+// stripComment removes a # comment that is not inside a quoted scalar.
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitKeyValue splits "key: value", unquoting the value.
+func splitKeyValue(text string) (string, string, error) {
+	key, val, ok := strings.Cut(text, ":")
+	if !ok {
+		return "", "", fmt.Errorf("expected key: value, got %q", text)
+	}
+	key = strings.TrimSpace(key)
+	val = strings.TrimSpace(val)
+	switch {
+	case strings.HasPrefix(val, `"`):
+		s, err := strconv.Unquote(val)
+		if err != nil {
+			return "", "", fmt.Errorf("bad quoted value %s", val)
+		}
+		val = s
+	case strings.HasPrefix(val, "'") && strings.HasSuffix(val, "'") && len(val) > 1:
+		val = strings.ReplaceAll(val[1:len(val)-1], "''", "'")
+	}
+	return key, val, nil
+}
+
+// parseJob reads a job written by writeJob, or by hand in the same shape: a
+// gdb key and two lists of mappings, with # comments.
+func parseJob(r io.Reader) (Job, error) {
+	var job Job
+	sections := map[string][]map[string]string{}
+	var section string
+	var item map[string]string
+	itemLines := map[string][]int{}
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(stripComment(sc.Text()), " \t")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.Contains(line, "\t") {
+			return job, fmt.Errorf("line %d: tabs are not allowed in indentation", n)
+		}
+		text := strings.TrimLeft(line, " ")
+		indent := len(line) - len(text)
+
+		if indent == 0 {
+			key, val, err := splitKeyValue(text)
+			if err != nil {
+				return job, fmt.Errorf("line %d: %v", n, err)
+			}
+			switch key {
+			case "gdb":
+				job.GDB = val
+				section = ""
+			case "rasters", "features":
+				if val != "" && val != "[]" {
+					return job, fmt.Errorf("line %d: %s must be a list of entries", n, key)
+				}
+				section, item = key, nil
+			default:
+				return job, fmt.Errorf("line %d: unknown key %q", n, key)
+			}
+			continue
+		}
+
+		if section == "" {
+			return job, fmt.Errorf("line %d: unexpected indentation", n)
+		}
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			item = map[string]string{}
+			sections[section] = append(sections[section], item)
+			itemLines[section] = append(itemLines[section], n)
+			text = strings.TrimSpace(text[1:])
+			if text == "" {
+				continue
+			}
+		} else if item == nil {
+			return job, fmt.Errorf("line %d: expected a list entry starting with -", n)
+		}
+		key, val, err := splitKeyValue(text)
+		if err != nil {
+			return job, fmt.Errorf("line %d: %v", n, err)
+		}
+		item[key] = val
+	}
+	if err := sc.Err(); err != nil {
+		return job, err
+	}
+
+	for i, m := range sections["rasters"] {
+		r, err := parseJobRaster(m)
+		if err != nil {
+			return job, fmt.Errorf("line %d: %v", itemLines["rasters"][i], err)
+		}
+		if r.Output == "" {
+			r.Output = r.Name + ".tif"
+		}
+		job.Rasters = append(job.Rasters, r)
+	}
+
+	for i, m := range sections["features"] {
+		f := JobFeature{Name: m["name"], Output: m["output"], Format: m["format"]}
+		for key := range m {
+			if key != "name" && key != "output" && key != "format" {
+				return job, fmt.Errorf("line %d: unknown feature key %q", itemLines["features"][i], key)
+			}
+		}
+		if f.Name == "" {
+			return job, fmt.Errorf("line %d: feature entry without a name", itemLines["features"][i])
+		}
+		if f.Format == "" {
+			f.Format = "geojson"
+		}
+		ext, ok := featureFormats[f.Format]
+		if !ok {
+			return job, fmt.Errorf("line %d: unknown feature format %q", itemLines["features"][i], f.Format)
+		}
+		if f.Output == "" {
+			f.Output = f.Name + ext
+		}
+		job.Features = append(job.Features, f)
+	}
+
+	return job, nil
+}
+
+// parseJobRaster reads the raster entry m of a job, or of a config.
+func parseJobRaster(m map[string]string) (JobRaster, error) {
+	r := JobRaster{Name: m["name"], Output: m["output"], Format: m["format"]}
+	for key, val := range m {
+		switch key {
+		case "name", "output", "format":
+		case "window":
+			w, err := parseWindow(val)
+			if err != nil {
+				return r, err
+			}
+			r.Window = w
+		case "cutline":
+			r.Cutline = val
+		case "verify":
+			v, err := strconv.ParseBool(val)
+			if err != nil {
+				return r, fmt.Errorf("verify must be true or false")
+			}
+			r.Verify = v
+		case "nodata":
+			v, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return r, fmt.Errorf("bad nodata value %q", val)
+			}
+			r.NoData = &v
+		default:
+			return r, fmt.Errorf("unknown raster key %q", key)
+		}
+	}
+	if r.Name == "" {
+		return r, fmt.Errorf("raster entry without a name")
+	}
+	if r.Format == "" {
+		r.Format = "gtiff"
+	}
+	if !slices.Contains(rasterFormats, r.Format) {
+		return r, fmt.Errorf("unknown raster format %q", r.Format)
+	}
+	return r, nil
+}
+
+// parseWindow reads a [minx, miny, maxx, maxy] flow sequence.
+func parseWindow(val string) ([]float64, error) {
+	if !strings.HasPrefix(val, "[") || !strings.HasSuffix(val, "]") {
+		return nil, fmt.Errorf("window must be [minx, miny, maxx, maxy]")
+	}
+	parts := strings.Split(val[1:len(val)-1], ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("window must have 4 values, got %d", len(parts))
+	}
+	w := make([]float64, 4)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad window value %q", strings.TrimSpace(p))
+		}
+		w[i] = v
+	}
+	if w[0] >= w[2] || w[1] >= w[3] {
+		return nil, fmt.Errorf("window %v is empty", w)
+	}
+	return w, nil
+}
+
+// readJob parses the job file at path.
+func readJob(path string) (Job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Job{}, err
+	}
+	defer f.Close()
+	job, err := parseJob(f)
+	if err != nil {
+		return job, fmt.Errorf("%s: %v", path, err)
+	}
+	return job, nil
+}
+
+// runJob extracts every dataset of job, falling back to gdbFilePath when the
+// job does not name a geodatabase, and returns how many it wrote. resume
+// applies to the rasters, as in extractRaster. A geodatabase that cannot be
+// opened is returned as an error; the datasets that cannot be read are
+// skipped with a warning.
+func runJob(ctx context.Context, job Job, gdbFilePath string, opts raster.ReadOptions, resume bool) (int, error) {
+	if job.GDB != "" {
+		gdbFilePath = job.GDB
+	}
+	db, err := gdb.OpenContext(ctx, gdbFilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	gdbFilePath = db.Path
+	mt := db.MasterTable()
+	runReport.found(gdbFilePath, "raster", rasterNames(mt))
+
+	written := 0
+	for _, r := range job.Rasters {
+		r = config.raster(r)
+		if !mt.IsRaster(r.Name) {
+			fmt.Fprintf(os.Stderr, "no raster called %q\n", r.Name)
+			runReport.failed(gdbFilePath, "raster", r.Name, fmt.Errorf("no raster called %q", r.Name))
+			lost(ctx)
+			continue
+		}
+		check(os.MkdirAll(filepath.Dir(r.Output), 0755))
+		ropts := opts
+		ropts.Verify = ropts.Verify || r.Verify
+		ropts.Window = r.Window
+		ropts.NoData = r.NoData
+		if r.Cutline != "" {
+			cut, err := raster.ReadCutline(r.Cutline)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				runReport.failed(gdbFilePath, "raster", r.Name, err)
+				lost(ctx)
+				continue
+			}
+			ropts.Cutline = cut
+		}
+		paths, err := extractRaster(db, r.Name, r.Output, ropts, resume)
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		if err != nil {
+			check(ctx.Err())
+			runReport.failed(gdbFilePath, "raster", r.Name, err)
+			checkAbort(err)
+			lost(ctx)
+			slog.Warn("skipping raster", "name", r.Name, "err", err)
+			continue
+		}
+		written++
+	}
+
+	// Feature classes sharing a GeoPackage are written to it together.
+	fcs := featureClasses(ctx, gdbFilePath, mt)
+	runReport.found(gdbFilePath, "feature class", tableNames(fcs))
+	gpkgs := make(map[string][]writer.GpkgLayer)
+	gpkgFCs := make(map[string][]gdb.TableInfo)
+	gpkgOrder := make([]string, 0)
+	for _, f := range job.Features {
+		found := false
+		for _, fc := range fcs {
+			if fc.Name != f.Name {
+				continue
+			}
+			found = true
+			check(os.MkdirAll(filepath.Dir(f.Output), 0755))
+			if f.Format == "gpkg" {
+				if _, ok := gpkgs[f.Output]; !ok {
+					gpkgOrder = append(gpkgOrder, f.Output)
+				}
+				l, err := writer.NewGpkgLayer(ctx, gdbFilePath, fc)
+				if err != nil {
+					check(ctx.Err())
+					runReport.failed(gdbFilePath, "feature class", f.Name, err)
+					checkAbort(err)
+					lost(ctx)
+					slog.Warn("skipping feature class", "name", f.Name, "err", err)
+					continue
+				}
+				gpkgs[f.Output] = append(gpkgs[f.Output], l)
+				gpkgFCs[f.Output] = append(gpkgFCs[f.Output], fc)
+				continue
+			}
+			if err := exportFeatureClass(ctx, gdbFilePath, fc, f.Format, f.Output); err != nil {
+				check(ctx.Err())
+				runReport.failed(gdbFilePath, "feature class", f.Name, err)
+				checkAbort(err)
+				lost(ctx)
+				slog.Warn("skipping feature class", "name", f.Name, "err", err)
+				continue
+			}
+			runReport.wrote("feature class", fc.Name, gdbFilePath, fc.ID, f.Output)
+			written++
+			fmt.Println(f.Output)
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "no feature class called %q\n", f.Name)
+			runReport.failed(gdbFilePath, "feature class", f.Name, fmt.Errorf("no feature class called %q", f.Name))
+			lost(ctx)
+		}
+	}
+	for _, path := range gpkgOrder {
+		check(writer.WriteGeoPackage(ctx, path, gpkgs[path]))
+		for _, fc := range gpkgFCs[path] {
+			runReport.wrote("feature class", fc.Name, gdbFilePath, fc.ID, path)
+		}
+		written += len(gpkgFCs[path])
+		fmt.Println(path)
+	}
+	return written, nil
+}
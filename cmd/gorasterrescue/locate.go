@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// DatasetExtent is the bounding box of one raster or feature class in its own
+// coordinate system.
+type DatasetExtent struct {
+	Name string
+	Kind string
+	MinX float64
+	MinY float64
+	MaxX float64
+	MaxY float64
+}
+
+func (de *DatasetExtent) intersects(minX, minY, maxX, maxY float64) bool {
+	return de.MinX <= maxX && minX <= de.MaxX && de.MinY <= maxY && minY <= de.MaxY
+}
+
+// datasetExtents collects the extents of every raster and feature class
+// listed in the master table. Datasets that cannot be read are reported on
+// stderr.
+func datasetExtents(ctx context.Context, gdbFilePath string, mt *gdb.MasterTable) []DatasetExtent {
+	extents := make([]DatasetExtent, 0)
+
+	for _, r := range mt.Rasters {
+		check(ctx.Err())
+		id := mt.TableID(raster.BndTablePrefix + r.Name)
+		if id == 0 {
+			continue
+		}
+		bands, err := raster.Bands(gdbFilePath, gdb.TableFileName(id))
+		if err != nil || len(bands) == 0 {
+			if err != nil {
+				slog.Warn("skipping raster", "name", r.Name, "err", err)
+			}
+			continue
+		}
+		rb, err := raster.NewRasterBase(gdbFilePath, gdb.TableFileName(id), bands[0].ID)
+		if err != nil {
+			slog.Warn("skipping raster", "name", r.Name, "err", err)
+			continue
+		}
+		minX, minY, maxX, maxY := rb.Extent()
+		rb.BaseTab.Close()
+		extents = append(extents, DatasetExtent{r.Name, "raster", minX, minY, maxX, maxY})
+	}
+
+	for _, fc := range featureClasses(ctx, gdbFilePath, mt) {
+		bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, gdb.TableFileName(fc.ID))
+		if err != nil {
+			check(ctx.Err())
+			slog.Warn("skipping feature class", "name", fc.Name, "err", err)
+			continue
+		}
+		shp, _ := bt.HasShape()
+		extents = append(extents, DatasetExtent{fc.Name, "feature class", shp.Shp.XMin, shp.Shp.YMin, shp.Shp.XMax, shp.Shp.YMax})
+		bt.Close()
+	}
+
+	return extents
+}
+
+// splitLocateArgs pulls the --coord and --bbox values out of args by hand so
+// that negative coordinates are not mistaken for flags.
+func splitLocateArgs(args []string) ([]float64, []string, error) {
+	rest := make([]string, 0)
+	var query []float64
+	for i := 0; i < len(args); i++ {
+		n := 0
+		switch strings.TrimLeft(args[i], "-") {
+		case "coord":
+			n = 2
+		case "bbox":
+			n = 4
+		default:
+			rest = append(rest, args[i])
+			continue
+		}
+
+		if query != nil {
+			return nil, nil, fmt.Errorf("only one of --coord or --bbox may be given")
+		}
+		if i+n >= len(args) {
+			return nil, nil, fmt.Errorf("%s needs %d values", args[i], n)
+		}
+		for _, a := range args[i+1 : i+1+n] {
+			v, err := strconv.ParseFloat(a, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("bad value %q for %s", a, args[i])
+			}
+			query = append(query, v)
+		}
+		i += n
+	}
+
+	if query == nil {
+		return nil, nil, fmt.Errorf("one of --coord x y or --bbox minx miny maxx maxy is required")
+	}
+	if len(query) == 2 {
+		query = append(query, query[0], query[1])
+	}
+	return query, rest, nil
+}
+
+func runLocate(ctx context.Context, args []string) {
+	query, rest, err := splitLocateArgs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "locate:", err)
+		exit(2)
+	}
+
+	fs := flag.NewFlagSet("locate", flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	fs.Parse(rest)
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+	mt := db.MasterTable()
+
+	t := newTable("kind", "name", "minx", "miny", "maxx", "maxy")
+	for _, de := range datasetExtents(ctx, db.Path, mt) {
+		if de.intersects(query[0], query[1], query[2], query[3]) {
+			t.add(healthNone, de.Kind, de.Name, de.MinX, de.MinY, de.MaxX, de.MaxY)
+		}
+	}
+
+	if len(t.rows) == 0 && !jsonOutput {
+		fmt.Fprintln(os.Stderr, "no dataset covers the requested location")
+		return
+	}
+	t.render(os.Stdout)
+}
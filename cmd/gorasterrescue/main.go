@@ -0,0 +1,343 @@
+// Command gorasterrescue recovers rasters, feature classes and tables from
+// file geodatabases, including damaged ones.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+const gdbPath string = "gSSURGO_DC.gdb/"
+
+// rebuildIndex is set by the global --rebuild-index flag: every table is read
+// through an index of its rows rebuilt from its .gdbtable, not its .gdbtablx.
+// noTablx is set by --no-tablx: every row the .gdbtable holds is read, deleted
+// ones included, the .gdbtablx never opened. undelete is set by --undelete:
+// the deleted rows listed in the .freelist of a table are read after its rows.
+var rebuildIndex, noTablx, undelete = false, false, false
+
+// deterministic is set by --deterministic: the files written are the same
+// byte for byte from one run to the next, dated SOURCE_DATE_EPOCH as builds
+// made to be reproducible are, or else 1970-01-01.
+var deterministic = false
+
+// fixedTime returns the time the files written are dated under
+// --deterministic.
+func fixedTime() (time.Time, error) {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH %q is not a number of seconds", v)
+	}
+	return time.Unix(secs, 0).UTC(), nil
+}
+
+// datasetName names the outputs holding a whole geodatabase after it: the
+// base name of its directory or archive without .gdb and .zip.
+func datasetName(gdbFilePath string) string {
+	name := filepath.Base(gdbFilePath)
+	if strings.EqualFold(filepath.Ext(name), ".zip") {
+		name = name[:len(name)-4]
+	}
+	return strings.TrimSuffix(name, ".gdb")
+}
+
+// Exit statuses, for scripts to tell the kind of failure apart: exitFailure
+// for anything not listed, such as an output that cannot be written, and
+// exitUsage for a bad command line.
+const (
+	exitFailure        = 1
+	exitUsage          = 2
+	exitNotGeodatabase = 3   // gdb.ErrNotAGeodatabase
+	exitUnsupported    = 4   // gdb.ErrUnsupportedVersion
+	exitCorrupt        = 5   // gdb.ErrCorruptHeader, gdb.ErrNotTable
+	exitPartial        = 6   // gdb.ErrPartialRecovery
+	exitInterrupted    = 130 // as a shell reports SIGINT
+)
+
+// partial is set once a command leaves out, or fills in, a row, a block or
+// a dataset it was to recover. A command that otherwise succeeds then exits
+// with exitPartial.
+var partial atomic.Bool
+
+// lossKey is the key of the context value counting, for batch, the losses of
+// the geodatabase being rescued under it.
+type lossKey struct{}
+
+// lost records that the command left out, or filled in, a block or dataset,
+// in the counter of ctx as well if it has one.
+func lost(ctx context.Context) {
+	partial.Store(true)
+	if n, ok := ctx.Value(lossKey{}).(*atomic.Int64); ok {
+		n.Add(1)
+	}
+}
+
+// badRow is told of every row that cannot be read, skipped or filled.
+func badRow(e *gdb.RowError) {
+	partial.Store(true)
+	errLog.row(e)
+	runReport.badRow(e)
+}
+
+// exitCode returns the exit status err ends the program with.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, gdb.ErrNotAGeodatabase):
+		return exitNotGeodatabase
+	case errors.Is(err, gdb.ErrUnsupportedVersion):
+		return exitUnsupported
+	case errors.Is(err, gdb.ErrCorruptHeader), errors.Is(err, gdb.ErrNotTable):
+		return exitCorrupt
+	case errors.Is(err, gdb.ErrPartialRecovery):
+		return exitPartial
+	}
+	return exitFailure
+}
+
+// check ends the program with err, if there is one, with the exit status
+// exitCode gives it. Commands use it for errors they cannot carry on past,
+// such as failing to write their output; damage in the geodatabase comes
+// back from the readers as errors instead.
+func check(e error) {
+	if errors.Is(e, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "goRasterRescue: interrupted")
+		exit(exitInterrupted)
+	}
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "goRasterRescue:", e)
+		exit(exitCode(e))
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: goRasterRescue [--no-color] [--json] [-v|-vv|--quiet] [--progress auto|json|none]")
+	fmt.Fprintln(os.Stderr, "                      [--rebuild-index|--no-tablx] [--undelete]")
+	fmt.Fprintln(os.Stderr, "                      [--on-error skip|fill|abort] [--error-log file] [--report file]")
+	fmt.Fprintln(os.Stderr, "                      [--checksums] [--manifest file] [--deterministic] [--co NAME=VALUE]")
+	fmt.Fprintln(os.Stderr, "                      [--build-overviews 2,4,8,16] [--overview-resampling nearest|average]")
+	fmt.Fprintln(os.Stderr, "                      [--external-overviews] [--cog] [--stac] [--aux-xml] [--unscale]")
+	fmt.Fprintln(os.Stderr, "                      [--units unit] [--reclass file.csv] [--composite 4,3,2] [--calc expr]")
+	fmt.Fprintln(os.Stderr, "                      [--mask mask.tif] [--nodata value] [--ot type]")
+	fmt.Fprintln(os.Stderr, "                      [--ot-rounding nearest|floor|ceil|trunc] [--ot-overflow clip|nodata]")
+	fmt.Fprintln(os.Stderr, "                      [--scale minmax|p2,98|min,max]")
+	fmt.Fprintln(os.Stderr, "                      [--cpuprofile file] [--memprofile file] [--config file]")
+	fmt.Fprintln(os.Stderr, "                      <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  batch         rescue every raster and feature class of several geodatabases")
+	fmt.Fprintln(os.Stderr, "  capabilities  report the data types, compressions and formats this build supports")
+	fmt.Fprintln(os.Stderr, "  carve         rebuild a raster from blocks carved out of a damaged block table or disk image")
+	fmt.Fprintln(os.Stderr, "  compare       compare the pixels of two GeoTIFFs, such as an extraction and a GDAL reference")
+	fmt.Fprintln(os.Stderr, "  contour       trace the contour lines of a DEM as GeoJSON, GeoPackage or Shapefile")
+	fmt.Fprintln(os.Stderr, "  doctor        check every dataset decodes and write a rescue job")
+	fmt.Fprintln(os.Stderr, "  extract       list the rasters, write one out as GeoTIFF, or run a rescue job")
+	fmt.Fprintln(os.Stderr, "  locate        find the datasets covering a coordinate or bounding box")
+	fmt.Fprintln(os.Stderr, "  metadata      list the datasets with ArcGIS metadata or export it as XML, or ISO 19139")
+	fmt.Fprintln(os.Stderr, "  mosaic        list mosaic datasets, dump their footprints or extract their overviews")
+	fmt.Fprintln(os.Stderr, "  features      list feature classes or export them as GeoJSON, Shapefile or GeoPackage")
+	fmt.Fprintln(os.Stderr, "  polygonize    turn the regions of equal value of a raster into polygons")
+	fmt.Fprintln(os.Stderr, "  serve         list and extract the rasters of geodatabases, or view them as map tiles, over HTTP")
+	fmt.Fprintln(os.Stderr, "  table         list tables or export their rows as CSV, Parquet or SQLite")
+	fmt.Fprintln(os.Stderr, "  validate      check every table and raster reads, with the offsets of what does not")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Listings are colored on a terminal unless --no-color or NO_COLOR is set;")
+	fmt.Fprintln(os.Stderr, "--json prints them as JSON instead.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Warnings, such as rows skipped as unreadable, go to stderr; --quiet leaves")
+	fmt.Fprintln(os.Stderr, "them out, -v adds progress and -vv the reading of every table and field.")
+	fmt.Fprintln(os.Stderr, "stdout only carries data: listings, the paths written and, with -o -, the")
+	fmt.Fprintln(os.Stderr, "GeoTIFF extract writes or the CSV of a table export writes, for pipelines.")
+	fmt.Fprintln(os.Stderr, "Reading a raster draws a progress bar on a terminal; --progress json prints")
+	fmt.Fprintln(os.Stderr, "a JSON object every 100 blocks instead, --progress none nothing.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "A table whose .gdbtablx is missing or damaged is read through an index of its")
+	fmt.Fprintln(os.Stderr, "rows rebuilt by scanning its .gdbtable; --rebuild-index does so for every table,")
+	fmt.Fprintln(os.Stderr, "for .gdbtablx files that read fine but point at the wrong rows. --no-tablx reads")
+	fmt.Fprintln(os.Stderr, "every row found walking the .gdbtable instead, rows deleted or rewritten included.")
+	fmt.Fprintln(os.Stderr, "--undelete reads back the deleted rows the .freelist of a table still lists after")
+	fmt.Fprintln(os.Stderr, "its rows; deleted raster blocks only fill in where no live block is.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Rows that cannot be read are skipped by default; --on-error fill keeps them with")
+	fmt.Fprintln(os.Stderr, "null values and --on-error abort stops at the first. Raster blocks that cannot be")
+	fmt.Fprintln(os.Stderr, "read are left as nodata, or stop the command with abort. --error-log writes every")
+	fmt.Fprintln(os.Stderr, "one of them to a file, one JSON object a line, to list what was lost.")
+	fmt.Fprintln(os.Stderr, "--report writes what extract, batch and the exports found and wrote once they end:")
+	fmt.Fprintln(os.Stderr, "the rows and blocks written and lost, checksums of the files and the warnings, as")
+	fmt.Fprintln(os.Stderr, "JSON, or as an HTML page for the owners of the data if the file ends in .html.")
+	fmt.Fprintln(os.Stderr, "--checksums writes a <file>.sha256 beside each of those files, --manifest one file")
+	fmt.Fprintln(os.Stderr, "listing them all, both for sha256sum -c to prove them unchanged. --deterministic")
+	fmt.Fprintln(os.Stderr, "dates the files written SOURCE_DATE_EPOCH, or 1970-01-01, rather than now, for")
+	fmt.Fprintln(os.Stderr, "runs to write them the same byte for byte.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "GeoTIFFs are written uncompressed unless --co COMPRESS=LZW, DEFLATE or ZSTD asks")
+	fmt.Fprintln(os.Stderr, "otherwise; --co PREDICTOR=2, or 3 for floating point bands, or YES for whichever")
+	fmt.Fprintln(os.Stderr, "suits each band, and ZLEVEL=1 to 9 for DEFLATE, are as gdal_translate -co takes")
+	fmt.Fprintln(os.Stderr, "them. So are TILED=YES, for tiles of BLOCKXSIZE by BLOCKYSIZE pixels (256 by")
+	fmt.Fprintln(os.Stderr, "default, multiples of 16), and BLOCKYSIZE alone, for strips of that many rows.")
+	fmt.Fprintln(os.Stderr, "Bands are rewritten so once complete. --build-overviews adds overviews shrinking")
+	fmt.Fprintln(os.Stderr, "them by the factors listed, as gdaladdo does, made in that rewrite; each pixel is")
+	fmt.Fprintln(os.Stderr, "the one at the middle of those it covers, for categorical bands, or with")
+	fmt.Fprintln(os.Stderr, "--overview-resampling average their mean. They go into the GeoTIFF, or with")
+	fmt.Fprintln(os.Stderr, "--external-overviews into a <file>.ovr beside it. --cog writes cloud optimized")
+	fmt.Fprintln(os.Stderr, "GeoTIFFs, in tiles of 512 pixels unless BLOCKXSIZE and BLOCKYSIZE say otherwise,")
+	fmt.Fprintln(os.Stderr, "with overviews halving the band until it fits in one unless --build-overviews")
+	fmt.Fprintln(os.Stderr, "lists them, and checks each as validate_cloud_optimized_geotiff.py would,")
+	fmt.Fprintln(os.Stderr, "failing for any that falls short. --stac writes a STAC Item beside each, as")
+	fmt.Fprintln(os.Stderr, "<file>.json: its footprint in longitude and latitude, its grid and coordinate")
+	fmt.Fprintln(os.Stderr, "system, nodata and data type, for it to go into a STAC catalog as it is.")
+	fmt.Fprintln(os.Stderr, "--aux-xml writes the statistics, histogram and value attribute table the")
+	fmt.Fprintln(os.Stderr, "geodatabase kept for a raster into a <file>.aux.xml beside each GeoTIFF extract")
+	fmt.Fprintln(os.Stderr, "and carve write, as GDAL keeps them, with the values named by the first text")
+	fmt.Fprintln(os.Stderr, "field of the table; windows get the table but not the statistics of the whole.")
+	fmt.Fprintln(os.Stderr, "Bands whose pixels ArcGIS keeps packed, with a scale and offset, carry them into")
+	fmt.Fprintln(os.Stderr, "the GeoTIFF as GDAL's SCALE and OFFSET; --unscale writes them unpacked instead,")
+	fmt.Fprintln(os.Stderr, "each pixel value*scale+offset in floating point, as gdal_translate -unscale")
+	fmt.Fprintln(os.Stderr, "does, with no .aux.xml, whose statistics and table are of the packed values.")
+	fmt.Fprintln(os.Stderr, "The unit of the values of a band, from its properties, its ArcGIS metadata or")
+	fmt.Fprintln(os.Stderr, "the vertical coordinate system of a raster of heights, goes into the GeoTIFF as")
+	fmt.Fprintln(os.Stderr, "GDAL's UNITTYPE (and into the STAC Item); --units m, or km, cm, mm, ft, us-ft,")
+	fmt.Fprintln(os.Stderr, "in, yd or mi, converts bands of lengths in another unit into floating point")
+	fmt.Fprintln(os.Stderr, "values in that one, as --unscale writes them, warning of bands it cannot.")
+	fmt.Fprintln(os.Stderr, "--reclass file.csv remaps the values of the bands written, after --unscale and")
+	fmt.Fprintln(os.Stderr, "--units: each row a value, or the least and greatest of a range, and what it")
+	fmt.Fprintln(os.Stderr, "becomes, the first to match winning, * for any value and nodata for none. Bands")
+	fmt.Fprintln(os.Stderr, "keep their type if it holds the new values, with no palette or .aux.xml.")
+	fmt.Fprintln(os.Stderr, "The three or four bands of bytes or 16-bit integers of an image are tagged red,")
+	fmt.Fprintln(os.Stderr, "green, blue and alpha, and the GeoTIFFs of a raster of several bands are stacked")
+	fmt.Fprintln(os.Stderr, "in a <file>.vrt beside them, for it to open as the image it was; a band whose")
+	fmt.Fprintln(os.Stderr, "value attribute table has Red, Green and Blue fields gets them as its palette.")
+	fmt.Fprintln(os.Stderr, "--composite 4,3,2 writes <file>_composite.tif beside the bands extract writes,")
+	fmt.Fprintln(os.Stderr, "an RGB GeoTIFF of bytes of those bands, red, green and blue, each stretched from")
+	fmt.Fprintln(os.Stderr, "its 2nd to its 98th percentile, for a look at a multispectral raster at once.")
+	fmt.Fprintln(os.Stderr, "--calc \"(B4 - B3) / (B4 + B3)\" writes <file>_calc.tif too, a float32 GeoTIFF of")
+	fmt.Fprintln(os.Stderr, "band math on the unpacked values of the bands, B and their numbers, with + - * /")
+	fmt.Fprintln(os.Stderr, "^ and parentheses, worked out from the blocks as extract reads them. A pixel is")
+	fmt.Fprintln(os.Stderr, "nodata if a band it uses is, or the result is not finite.")
+	fmt.Fprintln(os.Stderr, "--scale writes <file>_8bit.tif beside each band too, a compressed GeoTIFF of bytes")
+	fmt.Fprintln(os.Stderr, "for sharing, the unpacked values stretched over 1 to 255, nodata 0: from the least")
+	fmt.Fprintln(os.Stderr, "to the greatest with minmax, between two percentiles with p2,98, or between two")
+	fmt.Fprintln(os.Stderr, "values with 0,3000. The GeoTIFF of the band is written as ever.")
+	fmt.Fprintln(os.Stderr, "--mask mask.tif leaves the pixels extract reads as nodata wherever the first")
+	fmt.Fprintln(os.Stderr, "band of the GeoTIFF mask.tif, in the coordinates of the raster, is zero or")
+	fmt.Fprintln(os.Stderr, "nodata, or does not reach; blocks it leaves nothing of are not decoded.")
+	fmt.Fprintln(os.Stderr, "Pixels without data are written as the least value of their type if signed, or")
+	fmt.Fprintln(os.Stderr, "the greatest if not; --nodata -9999 writes them, and tags the GeoTIFFs, with that")
+	fmt.Fprintln(os.Stderr, "value instead, for a raster whose valid pixels take the default, or whose nodata")
+	fmt.Fprintln(os.Stderr, "other software expects as another. A nodata set for a raster in a job wins.")
+	fmt.Fprintln(os.Stderr, "--ot byte, int8, uint16, int16, uint32, int32, float32 or float64 casts the bands")
+	fmt.Fprintln(os.Stderr, "written to that type, after --unscale, --units and --reclass, as gdal_translate")
+	fmt.Fprintln(os.Stderr, "-ot does: values are rounded to the nearest integer, or as --ot-rounding says,")
+	fmt.Fprintln(os.Stderr, "and those out of the range of the type clipped to it, short of nodata, or made")
+	fmt.Fprintln(os.Stderr, "nodata with --ot-overflow nodata. Nodata stays nodata, as the default of the")
+	fmt.Fprintln(os.Stderr, "type if the type cannot hold the nodata value of the band.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "--cpuprofile and --memprofile write CPU and heap profiles of the run for")
+	fmt.Fprintln(os.Stderr, "go tool pprof.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "--config reads these flags, the flags of commands and settings of rasters,")
+	fmt.Fprintln(os.Stderr, "such as their nodata, from a YAML file; those on the command line win.")
+	fmt.Fprintln(os.Stderr, "GORASTERRESCUE_OUTPUT_DIR, GORASTERRESCUE_THREADS (-workers), GORASTERRESCUE_LOG_LEVEL")
+	fmt.Fprintln(os.Stderr, "(debug, info, warn or error) and GORASTERRESCUE_CONFIG set defaults both win over.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "exit status: 0 done, 1 failed, 2 bad command line, 3 not a file geodatabase,")
+	fmt.Fprintln(os.Stderr, "4 unsupported table version, 5 corrupt table header,")
+	fmt.Fprintln(os.Stderr, "6 done, but rows, blocks or datasets were lost, 130 interrupted.")
+}
+
+func main() {
+	writer.CreatedBy = "goRasterRescue " + version
+	args := config.withFlags(setupOutput(os.Args[1:]))
+	if len(args) < 1 {
+		usage()
+		exit(exitUsage)
+	}
+	if deterministic {
+		t, err := fixedTime()
+		check(err)
+		writer.Now = func() time.Time { return t }
+	}
+
+	// Ctrl-C or SIGTERM cancels ctx: the readers stop, the output being
+	// written is removed and check exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	switch {
+	case noTablx:
+		ctx = gdb.WithoutTablx(ctx)
+	case rebuildIndex:
+		ctx = gdb.WithRebuiltIndex(ctx)
+	}
+	if undelete {
+		ctx = gdb.WithDeletedRows(ctx)
+	}
+	if errorLogPath != "" {
+		check(openErrorLog(ctx, errorLogPath))
+		defer errLog.close()
+	}
+	if reportPath != "" || checksums || manifestPath != "" {
+		startReport(args[0])
+		defer runReport.finish(0)
+		ctx = gdb.WithRowCount(ctx, runReport.row)
+	}
+	ctx = gdb.WithRowReport(ctx, badRow)
+	ctx = gdb.WithOnError(ctx, onError)
+	check(startProfiling())
+	defer stopProfiling()
+
+	switch args[0] {
+	case "batch":
+		runBatch(ctx, args[1:])
+	case "capabilities":
+		runCapabilities(args[1:])
+	case "carve":
+		runCarve(ctx, args[1:])
+	case "compare":
+		runCompare(args[1:])
+	case "contour":
+		runContour(ctx, args[1:])
+	case "doctor":
+		runDoctor(ctx, args[1:])
+	case "extract":
+		runExtract(ctx, args[1:])
+	case "locate":
+		runLocate(ctx, args[1:])
+	case "metadata":
+		runMetadata(ctx, args[1:])
+	case "mosaic":
+		runMosaic(ctx, args[1:])
+	case "features":
+		runFeatures(ctx, args[1:])
+	case "polygonize":
+		runPolygonize(ctx, args[1:])
+	case "serve":
+		runServe(ctx, args[1:])
+	case "table":
+		runTable(ctx, args[1:])
+	case "validate":
+		runValidate(ctx, args[1:])
+	default:
+		usage()
+		exit(exitUsage)
+	}
+	if partial.Load() {
+		check(fmt.Errorf("%w: rows, blocks or datasets could not be read, see the warnings", gdb.ErrPartialRecovery))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// documented lists the items of the geodatabase with ArcGIS metadata, or
+// those of them called names. The workspace, which has no name, goes by the
+// name of the geodatabase.
+func documented(db *gdb.Geodatabase, names []string) []gdb.Item {
+	items, err := db.Items()
+	if err != nil {
+		check(db.Context().Err())
+		lost(db.Context())
+		slog.Warn("cannot read every item", "table", "GDB_Items", "err", err)
+	}
+	docs := make([]gdb.Item, 0)
+	for _, it := range items {
+		if it.Type == "Workspace" {
+			it.Name = datasetName(db.Path)
+		}
+		if it.Documentation == "" || it.Name == "" {
+			continue
+		}
+		if len(names) > 0 && !slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, it.Name) }) {
+			continue
+		}
+		docs = append(docs, it)
+	}
+	return docs
+}
+
+func runMetadata(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: goRasterRescue metadata list|export [flags] [name...]")
+		exit(2)
+	}
+
+	fs := flag.NewFlagSet("metadata "+args[0], flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	out := fs.String("o", cmp.Or(outputDir, "."), "output directory for export")
+	format := fs.String("format", "esri", "export format: esri, the ArcGIS metadata as it is kept, or iso19139")
+	fs.Parse(args[1:])
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+	docs := documented(db, fs.Args())
+	for _, name := range fs.Args() {
+		if !slices.ContainsFunc(docs, func(it gdb.Item) bool { return strings.EqualFold(it.Name, name) }) {
+			fmt.Fprintf(os.Stderr, "no metadata for %q\n", name)
+		}
+	}
+
+	switch args[0] {
+	case "list":
+		t := newTable("name", "type", "path", "bytes")
+		for _, it := range docs {
+			t.add(healthNone, it.Name, it.Type, it.Path, len(it.Documentation))
+		}
+		t.render(os.Stdout)
+
+	case "export":
+		if !slices.Contains(writer.MetadataFormats, *format) {
+			fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+			exit(2)
+		}
+		check(os.MkdirAll(*out, 0755))
+		for _, it := range docs {
+			path := filepath.Join(*out, it.Name+".xml")
+			slog.Info("exporting metadata", "name", it.Name, "format", *format)
+			if err := writer.WriteMetadata(path, it.Documentation, *format); err != nil {
+				lost(ctx)
+				slog.Warn("skipping metadata", "name", it.Name, "err", err)
+				continue
+			}
+			fmt.Println(path)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown metadata command %q\n", args[0])
+		exit(2)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// Mosaic datasets are stored as a group of AMD_<name>_* tables: CAT holds one
+// row per item with its footprint, BND the overall boundary and OVR the
+// overviews, whose pixels live in the fras_*_AMD_<name>_OVR raster tables.
+const mosaicTablePrefix string = "AMD_"
+
+// MosaicItem is one row of a mosaic catalog table.
+type MosaicItem struct {
+	ID        int
+	Name      string
+	MinPS     float64
+	MaxPS     float64
+	Raster    string
+	Footprint gdb.Geometry
+}
+
+// mosaicNames lists the mosaic datasets of the master table.
+func mosaicNames(mt *gdb.MasterTable) []string {
+	names := make([]string, 0)
+	for _, t := range mt.Tables {
+		if strings.HasPrefix(t.Name, mosaicTablePrefix) && strings.HasSuffix(t.Name, "_CAT") {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(t.Name, mosaicTablePrefix), "_CAT"))
+		}
+	}
+	return names
+}
+
+// readMosaicItems reads the rows of a footprint bearing mosaic table (the
+// catalog or the boundary). Rows that cannot be read are reported on stderr
+// and left out.
+func readMosaicItems(ctx context.Context, gdbFilePath string, tableName string) ([]MosaicItem, error) {
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+
+	items := make([]MosaicItem, 0)
+	rows := bt.Rows()
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			slog.Warn("skipping mosaic item", "err", err)
+			continue
+		}
+
+		item := MosaicItem{ID: row.FID}
+		for i, fld := range row.Fields {
+			switch v := row.Values[i].(type) {
+			case string:
+				if fld.Type == 4 && fld.Name == "Name" {
+					item.Name = v
+				} else if fld.Type == 9 {
+					item.Raster = v
+				}
+			case float64:
+				switch fld.Name {
+				case "MinPS":
+					item.MinPS = v
+				case "MaxPS":
+					item.MaxPS = v
+				}
+			case gdb.Geometry:
+				item.Footprint = v
+			case int32:
+				if fld.Type == 9 {
+					item.Raster = fmt.Sprintf("raster_id %d", v)
+				}
+			}
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func runMosaic(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: goRasterRescue mosaic list|footprints|overviews [flags] [name]")
+		exit(2)
+	}
+
+	fs := flag.NewFlagSet("mosaic "+args[0], flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	out := fs.String("o", "", "output file (footprints) or directory (overviews)")
+	bbox := fs.String("bbox", "", "footprints: only those whose envelope meets minx,miny,maxx,maxy, looked up in the spatial index if there is one")
+	fs.Parse(args[1:])
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+	mt := db.MasterTable()
+
+	switch args[0] {
+	case "list":
+		t := newTable("mosaic", "id", "name", "minps", "maxps", "raster")
+		for _, name := range mosaicNames(mt) {
+			items, err := readMosaicItems(ctx, db.Path, gdb.TableFileName(mt.TableID(mosaicTablePrefix+name+"_CAT")))
+			if err != nil {
+				check(ctx.Err())
+				slog.Warn("skipping mosaic", "name", name, "err", err)
+			}
+			for _, item := range items {
+				t.add(healthNone, name, item.ID, item.Name, item.MinPS, item.MaxPS, item.Raster)
+			}
+		}
+		t.render(os.Stdout)
+
+	case "footprints":
+		name := mosaicArg(fs, mt)
+		bctx := bboxContext(ctx, *bbox)
+		features := make([]map[string]interface{}, 0)
+		for _, layer := range []string{"CAT", "BND"} {
+			id := mt.TableID(mosaicTablePrefix + name + "_" + layer)
+			if id == 0 {
+				continue
+			}
+			items, err := readMosaicItems(bctx, db.Path, gdb.TableFileName(id))
+			check(err)
+			for _, item := range items {
+				features = append(features, writer.GeoJSONFeature(item.Footprint, map[string]interface{}{
+					"layer": map[string]string{"CAT": "footprint", "BND": "boundary"}[layer],
+					"id":    item.ID,
+					"name":  item.Name,
+					"minps": item.MinPS,
+					"maxps": item.MaxPS,
+				}))
+			}
+		}
+
+		w := os.Stdout
+		if *out != "" {
+			f, err := os.Create(*out)
+			check(err)
+			defer f.Close()
+			w = f
+		}
+		check(json.NewEncoder(w).Encode(writer.GeoJSONFeatureCollection(features)))
+
+	case "overviews":
+		name := mosaicArg(fs, mt)
+		ovr := mosaicTablePrefix + name + "_OVR"
+		bndID, blkID := raster.TableIDs(mt, ovr)
+		if bndID == 0 {
+			fmt.Fprintf(os.Stderr, "mosaic %s has no internally stored overviews\n", name)
+			exit(1)
+		}
+
+		dir := cmp.Or(*out, outputDir, ".")
+		check(os.MkdirAll(dir, 0755))
+		wkt := db.WKT(ovr)
+
+		bands, err := raster.Bands(db.Path, gdb.TableFileName(bndID))
+		check(err)
+		for _, band := range bands {
+			rb, err := raster.NewRasterBase(db.Path, gdb.TableFileName(bndID), band.ID)
+			if err != nil {
+				lost(ctx)
+				slog.Warn("skipping overview", "err", err)
+				continue
+			}
+			noData, err := noDataOf(rb.DataType)
+			if err == nil {
+				err = geoTIFFOptions.Check(rb.DataType)
+			}
+			if err != nil {
+				rb.BaseTab.Close()
+				check(err)
+			}
+			// The blocks go straight into the GeoTIFF, so that an overview
+			// of a large raster need not fit in memory.
+			path := filepath.Join(dir, fmt.Sprintf("%s_ovr_%d_b%d.tif", name, band.RasterID, band.SequenceNbr))
+			tif, err := writer.CreateGeoTIFF(ctx, path, &rb, noData, wkt)
+			if err != nil {
+				rb.BaseTab.Close()
+				check(err)
+			}
+			opts := raster.ReadOptions{Block: tif.WriteBlock, NoData: noDataOverride}
+			p := newProgress(fmt.Sprintf("%s overview %d", name, band.RasterID), band.SequenceNbr)
+			if p != nil {
+				opts.Progress = p.update
+			}
+			rd, err := raster.NewRasterDataContext(ctx, db.Path, gdb.TableFileName(blkID), rb, opts)
+			p.clear()
+			rb.BaseTab.Close()
+			if err == nil {
+				rd.BaseTab.Close()
+				errLog.blocks(name, band.SequenceNbr, path, rd.Suspect)
+				if d := rd.RasBase.Damage(rd.Suspect); d.Blocks > 0 || d.Unplaced > 0 {
+					lost(ctx)
+				}
+				err = tif.Close()
+			} else {
+				errLog.aborted(name, band.SequenceNbr, path, err)
+				tif.Close()
+			}
+			if err != nil {
+				os.Remove(path)
+				check(ctx.Err())
+				checkAbort(err)
+				lost(ctx)
+				slog.Warn("skipping overview", "raster_id", band.RasterID, "band", band.SequenceNbr, "err", err)
+				continue
+			}
+			check(writer.RewriteGeoTIFF(ctx, path, &rb, noData, wkt, geoTIFFOptions))
+			check(writeSTACItem(path, &rb, noData, wkt))
+			fmt.Println(path)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown mosaic command %q\n", args[0])
+		exit(2)
+	}
+}
+
+// mosaicArg returns the mosaic name given on the command line, checking it
+// exists.
+func mosaicArg(fs *flag.FlagSet, mt *gdb.MasterTable) string {
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "a mosaic dataset name is required")
+		exit(2)
+	}
+	name := fs.Arg(0)
+	for _, n := range mosaicNames(mt) {
+		if n == name {
+			return name
+		}
+	}
+	fmt.Fprintf(os.Stderr, "no mosaic dataset called %q\n", name)
+	exit(1)
+	return ""
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// Output settings shared by every command, set from the global flags.
+var (
+	useColor   = false
+	jsonOutput = false
+	logLevel   = slog.LevelWarn
+)
+
+// setupOutput strips the global --no-color, --json, -v, -vv, --quiet,
+// --progress, --rebuild-index, --no-tablx, --undelete, --on-error,
+// --error-log, --report, --checksums, --manifest, --deterministic, --co,
+// --build-overviews, --overview-resampling, --external-overviews, --cog,
+// --stac, --aux-xml, --unscale, --units, --reclass, --composite, --calc,
+// --mask, --nodata, --ot, --ot-rounding, --ot-overflow, --scale,
+// --cpuprofile and --memprofile flags, and those --config reads
+// from a file, from args and decides whether to color: only on a terminal, and
+// never with NO_COLOR set. It also sets up logging on stderr, as text or, with --json,
+// as JSON: warnings such as skipped rows by default, errors only with
+// --quiet, progress with -v and the reading of every table and field with
+// -vv.
+func setupOutput(args []string) []string {
+	loadEnv()
+	args = loadConfig(args)
+	noColor := os.Getenv("NO_COLOR") != ""
+	var co []string
+	overviews, resampling, externalOverviews, cog, unscale, units, reclass, bands, calc, mask, noData := "", "nearest", false, false, false, "", "", "", "", "", ""
+	outputType, rounding, overflow, stretch := "", "nearest", "clip", ""
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if name, val, ok := strings.Cut(a, "="); ok {
+			switch name {
+			case "--progress", "-progress":
+				setProgressMode(val)
+				continue
+			case "--cpuprofile", "-cpuprofile":
+				cpuProfile = val
+				continue
+			case "--memprofile", "-memprofile":
+				memProfile = val
+				continue
+			case "--on-error", "-on-error":
+				setOnError(val)
+				continue
+			case "--error-log", "-error-log":
+				errorLogPath = val
+				continue
+			case "--report", "-report":
+				reportPath = val
+				continue
+			case "--manifest", "-manifest":
+				manifestPath = val
+				continue
+			case "--co", "-co":
+				co = append(co, val)
+				continue
+			case "--build-overviews", "-build-overviews":
+				overviews = val
+				continue
+			case "--overview-resampling", "-overview-resampling":
+				resampling = val
+				continue
+			case "--units", "-units":
+				units = val
+				continue
+			case "--reclass", "-reclass":
+				reclass = val
+				continue
+			case "--composite", "-composite":
+				bands = val
+				continue
+			case "--calc", "-calc":
+				calc = val
+				continue
+			case "--mask", "-mask":
+				mask = val
+				continue
+			case "--nodata", "-nodata":
+				noData = val
+				continue
+			case "--ot", "-ot":
+				outputType = val
+				continue
+			case "--ot-rounding", "-ot-rounding":
+				rounding = val
+				continue
+			case "--ot-overflow", "-ot-overflow":
+				overflow = val
+				continue
+			case "--scale", "-scale":
+				stretch = val
+				continue
+			}
+		}
+		switch a {
+		case "--no-color", "-no-color":
+			noColor = true
+		case "--json", "-json":
+			jsonOutput = true
+		case "-v", "--verbose":
+			logLevel = slog.LevelInfo
+		case "-vv":
+			logLevel = slog.LevelDebug
+		case "-q", "--quiet", "-quiet":
+			logLevel = slog.LevelError
+		case "--rebuild-index", "-rebuild-index":
+			rebuildIndex = true
+		case "--no-tablx", "-no-tablx":
+			noTablx = true
+		case "--undelete", "-undelete":
+			undelete = true
+		case "--checksums", "-checksums":
+			checksums = true
+		case "--deterministic", "-deterministic":
+			deterministic = true
+		case "--external-overviews", "-external-overviews":
+			externalOverviews = true
+		case "--cog", "-cog":
+			cog = true
+		case "--stac", "-stac":
+			stacItems = true
+		case "--aux-xml", "-aux-xml":
+			auxXML = true
+		case "--unscale", "-unscale":
+			unscale = true
+		case "--progress", "-progress":
+			if i+1 == len(args) {
+				setProgressMode("")
+			}
+			i++
+			setProgressMode(args[i])
+		case "--on-error", "-on-error":
+			if i+1 == len(args) {
+				setOnError("")
+			}
+			i++
+			setOnError(args[i])
+		case "--co", "-co":
+			if i+1 == len(args) {
+				fmt.Fprintf(os.Stderr, "%s takes a creation option, NAME=VALUE\n", a)
+				exit(2)
+			}
+			i++
+			co = append(co, args[i])
+		case "--build-overviews", "-build-overviews", "--overview-resampling", "-overview-resampling", "--units", "-units", "--reclass", "-reclass", "--composite", "-composite", "--calc", "-calc", "--mask", "-mask", "--nodata", "-nodata",
+			"--ot", "-ot", "--ot-rounding", "-ot-rounding", "--ot-overflow", "-ot-overflow", "--scale", "-scale":
+			if i+1 == len(args) {
+				fmt.Fprintf(os.Stderr, "%s takes a value\n", a)
+				exit(2)
+			}
+			i++
+			switch {
+			case strings.HasSuffix(a, "resampling"):
+				resampling = args[i]
+			case strings.HasSuffix(a, "units"):
+				units = args[i]
+			case strings.HasSuffix(a, "reclass"):
+				reclass = args[i]
+			case strings.HasSuffix(a, "composite"):
+				bands = args[i]
+			case strings.HasSuffix(a, "calc"):
+				calc = args[i]
+			case strings.HasSuffix(a, "mask"):
+				mask = args[i]
+			case strings.HasSuffix(a, "nodata"):
+				noData = args[i]
+			case strings.HasSuffix(a, "-ot"):
+				outputType = args[i]
+			case strings.HasSuffix(a, "rounding"):
+				rounding = args[i]
+			case strings.HasSuffix(a, "overflow"):
+				overflow = args[i]
+			case strings.HasSuffix(a, "scale"):
+				stretch = args[i]
+			default:
+				overviews = args[i]
+			}
+		case "--cpuprofile", "-cpuprofile", "--memprofile", "-memprofile", "--error-log", "-error-log", "--report", "-report", "--manifest", "-manifest":
+			if i+1 == len(args) {
+				fmt.Fprintf(os.Stderr, "%s takes a file name\n", a)
+				exit(2)
+			}
+			i++
+			switch {
+			case strings.HasSuffix(a, "cpuprofile"):
+				cpuProfile = args[i]
+			case strings.HasSuffix(a, "memprofile"):
+				memProfile = args[i]
+			case strings.HasSuffix(a, "report"):
+				reportPath = args[i]
+			case strings.HasSuffix(a, "manifest"):
+				manifestPath = args[i]
+			default:
+				errorLogPath = args[i]
+			}
+		default:
+			rest = append(rest, a)
+		}
+	}
+	useColor = !noColor && !jsonOutput && isTerminal(os.Stdout)
+	var err error
+	if cog {
+		co = append([]string{"TILED=YES"}, co...)
+	}
+	geoTIFFOptions, err = writer.ParseGeoTIFFOptions(co)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--co:", err)
+		exit(2)
+	}
+	if overviews != "" {
+		if geoTIFFOptions.Overviews, err = writer.ParseOverviews(overviews); err != nil {
+			fmt.Fprintln(os.Stderr, "--build-overviews:", err)
+			exit(2)
+		}
+	}
+	if geoTIFFOptions.Resampling, err = writer.ParseResampling(resampling); err != nil {
+		fmt.Fprintln(os.Stderr, "--overview-resampling:", err)
+		exit(2)
+	}
+	geoTIFFOptions.ExternalOverviews = externalOverviews
+	geoTIFFOptions.Unscale = unscale
+	if units != "" {
+		if _, ok := raster.UnitFactor(units, units); !ok {
+			fmt.Fprintf(os.Stderr, "--units: %q is not a unit of length: m, km, cm, mm, ft, us-ft, in, yd or mi\n", units)
+			exit(2)
+		}
+		geoTIFFOptions.Units = raster.NormalizeUnit(units)
+	}
+	if reclass != "" {
+		if geoTIFFOptions.Reclass, err = raster.ReadReclass(reclass); err != nil {
+			fmt.Fprintln(os.Stderr, "--reclass:", err)
+			exit(2)
+		}
+	}
+	if bands != "" {
+		if composite, err = parseComposite(bands); err != nil {
+			fmt.Fprintln(os.Stderr, "--composite:", err)
+			exit(2)
+		}
+	}
+	if calc != "" {
+		if calcExpr, err = raster.ParseExpr(calc); err != nil {
+			fmt.Fprintln(os.Stderr, "--calc:", err)
+			exit(2)
+		}
+	}
+	if mask != "" {
+		if validityMask, err = raster.ReadMask(mask); err != nil {
+			fmt.Fprintln(os.Stderr, "--mask:", err)
+			exit(2)
+		}
+	}
+	if outputType != "" {
+		if geoTIFFOptions.OutputType, err = writer.ParseOutputType(outputType); err != nil {
+			fmt.Fprintln(os.Stderr, "--ot:", err)
+			exit(2)
+		}
+	}
+	if geoTIFFOptions.Rounding, err = writer.ParseRounding(rounding); err != nil {
+		fmt.Fprintln(os.Stderr, "--ot-rounding:", err)
+		exit(2)
+	}
+	if geoTIFFOptions.Overflow, err = writer.ParseOverflow(overflow); err != nil {
+		fmt.Fprintln(os.Stderr, "--ot-overflow:", err)
+		exit(2)
+	}
+	if stretch != "" {
+		sc, err := writer.ParseScale(stretch)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "--scale:", err)
+			exit(2)
+		}
+		scale = &sc
+	}
+	if noData != "" {
+		v, err := strconv.ParseFloat(noData, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--nodata: %q is not a number\n", noData)
+			exit(2)
+		}
+		noDataOverride = &v
+	}
+	if cog {
+		if geoTIFFOptions, err = geoTIFFOptions.COGOptions(); err != nil {
+			fmt.Fprintln(os.Stderr, "--cog:", err)
+			exit(2)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: dropTime}
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if jsonOutput {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+	return rest
+}
+
+// setProgressMode sets progressMode to mode, or ends the program if mode is
+// not one of progressModes.
+func setProgressMode(mode string) {
+	if !slices.Contains(progressModes, mode) {
+		fmt.Fprintf(os.Stderr, "--progress takes one of %s\n", strings.Join(progressModes, ", "))
+		exit(2)
+	}
+	progressMode = mode
+}
+
+// dropTime leaves the time out of log records, which only clutters the
+// output of a command run by hand.
+func dropTime(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+	return a
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// health says how a table row is colored.
+type health int
+
+const (
+	healthNone health = iota
+	healthOK
+	healthWarn
+	healthBad
+)
+
+var healthColors = map[health]string{
+	healthOK:   "\x1b[32m",
+	healthWarn: "\x1b[33m",
+	healthBad:  "\x1b[31m",
+}
+
+func colorize(s string, code string) string {
+	if !useColor || code == "" {
+		return s
+	}
+	return code + s + "\x1b[0m"
+}
+
+// table collects rows of results and renders them as aligned columns, or as a
+// JSON array of objects keyed by column name with --json.
+type table struct {
+	columns []string
+	rows    [][]string
+	values  [][]interface{}
+	health  []health
+}
+
+func newTable(columns ...string) *table {
+	return &table{columns: columns}
+}
+
+// add appends a row. Cells are formatted with %v, floats without exponents.
+func (t *table) add(h health, cells ...interface{}) {
+	row := make([]string, len(cells))
+	for i, c := range cells {
+		switch v := c.(type) {
+		case float64:
+			row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			row[i] = fmt.Sprint(v)
+		}
+	}
+	t.rows = append(t.rows, row)
+	t.values = append(t.values, cells)
+	t.health = append(t.health, h)
+}
+
+// numericColumn reports whether every cell of column c is a number, which is
+// then right aligned.
+func (t *table) numericColumn(c int) bool {
+	for _, row := range t.rows {
+		if _, err := strconv.ParseFloat(row[c], 64); err != nil {
+			return false
+		}
+	}
+	return len(t.rows) > 0
+}
+
+func (t *table) render(w io.Writer) {
+	if jsonOutput {
+		objs := make([]map[string]interface{}, len(t.values))
+		for i, vals := range t.values {
+			objs[i] = make(map[string]interface{})
+			for c, v := range vals {
+				objs[i][t.columns[c]] = v
+			}
+		}
+		check(json.NewEncoder(w).Encode(objs))
+		return
+	}
+
+	widths := make([]int, len(t.columns))
+	right := make([]bool, len(t.columns))
+	for c, name := range t.columns {
+		widths[c] = utf8.RuneCountInString(name)
+		for _, row := range t.rows {
+			widths[c] = max(widths[c], utf8.RuneCountInString(row[c]))
+		}
+		right[c] = t.numericColumn(c)
+	}
+
+	line := func(cells []string) string {
+		padded := make([]string, len(cells))
+		for c, s := range cells {
+			pad := strings.Repeat(" ", widths[c]-utf8.RuneCountInString(s))
+			if right[c] {
+				padded[c] = pad + s
+			} else if c < len(cells)-1 {
+				padded[c] = s + pad
+			} else {
+				padded[c] = s
+			}
+		}
+		return strings.Join(padded, "  ")
+	}
+
+	header := make([]string, len(t.columns))
+	for c, name := range t.columns {
+		header[c] = strings.ToUpper(name)
+	}
+	fmt.Fprintln(w, colorize(line(header), "\x1b[1m"))
+	for i, row := range t.rows {
+		fmt.Fprintln(w, colorize(line(row), healthColors[t.health[i]]))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// runPolygonize writes the regions of equal value of a band of a raster, a
+// land cover raster say, as polygons with their value and the fields of its
+// row of the value attribute table, in GeoJSON, a GeoPackage or a
+// Shapefile.
+func runPolygonize(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("polygonize", flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	out := fs.String("o", "", "output .geojson, .gpkg or .shp (default <raster>_polygons.geojson)")
+	band := fs.Int("band", 0, "sequence number of the band (default the first)")
+	fs.Parse(args)
+
+	name := fs.Arg(0)
+	if name == "" {
+		fmt.Fprintln(os.Stderr, "usage: goRasterRescue polygonize [-gdb path] [-band n] [-o file.geojson|file.gpkg|file.shp] <raster>")
+		exit(2)
+	}
+	if *out == "" {
+		*out = outputPath(name + "_polygons.geojson")
+	}
+	tracedFormat(*out)
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+	r, err := raster.Open(db, name)
+	check(err)
+	rd, err := r.Read(raster.ReadOptions{Band: *band, Workers: threads, NoData: noDataOverride})
+	check(err)
+	rat, err := r.AttributeTable()
+	if err != nil {
+		check(ctx.Err())
+		lost(ctx)
+		slog.Warn("cannot read the value attribute table", "raster", name, "err", err)
+	}
+
+	slog.Info("polygonizing", "raster", name, "band", *band)
+	fields, features, err := writer.Polygonize(ctx, rd, rat)
+	check(err)
+	check(writeTraced(ctx, *out, 4, fields, features, r.WKT))
+	fmt.Println(*out)
+}
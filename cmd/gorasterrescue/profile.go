@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// Profiles written for go tool pprof, set by the global --cpuprofile and
+// --memprofile flags.
+var (
+	cpuProfile string
+	memProfile string
+)
+
+// cpuProfileFile is the CPU profile being written, nil if none.
+var cpuProfileFile *os.File
+
+// startProfiling starts the CPU profile, if one was asked for.
+func startProfiling() error {
+	if cpuProfile == "" {
+		return nil
+	}
+	f, err := os.Create(cpuProfile)
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	cpuProfileFile = f
+	return nil
+}
+
+// stopProfiling finishes the CPU profile and writes the heap profile, if
+// they were asked for. The heap profile counts every allocation made since
+// the start, as go tool pprof -sample_index=alloc_space shows, beside what is
+// still in use.
+func stopProfiling() {
+	if cpuProfileFile != nil {
+		pprof.StopCPUProfile()
+		cpuProfileFile.Close()
+		cpuProfileFile = nil
+	}
+	if memProfile == "" {
+		return
+	}
+	f, err := os.Create(memProfile)
+	if err == nil {
+		runtime.GC()
+		err = pprof.WriteHeapProfile(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "goRasterRescue:", err)
+	}
+	memProfile = ""
+}
+
+// exit ends the program with code once the profiles and the report are
+// written. Commands call it rather than os.Exit, which would lose them.
+func exit(code int) {
+	stopProfiling()
+	runReport.finish(code)
+	os.Exit(code)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// progressMode is how reading a raster reports its progress on stderr, set
+// by the global --progress flag: "auto" for a bar on a terminal, "json" for
+// progress events, "none" for nothing.
+var progressMode = "auto"
+
+// progressModes lists the values --progress takes.
+var progressModes = []string{"auto", "json", "none"}
+
+// progress reports how far reading one band has got, as a bar redrawn on one
+// line of stderr or, for wrappers that cannot scrape a terminal, as one
+// progressEvent per line.
+type progress struct {
+	w      io.Writer
+	raster string
+	band   int32
+	json   bool
+	start  time.Time
+	drawn  time.Time
+	next   int // blocks read at which the next event is due
+}
+
+// progressWidth is the number of cells of the bar, progressEvery how often
+// it is redrawn at most, and progressEventBlocks how many blocks apart the
+// JSON events are.
+const (
+	progressWidth       = 30
+	progressEvery       = 100 * time.Millisecond
+	progressEventBlocks = 100
+)
+
+// progressEvent is one line of --progress json.
+type progressEvent struct {
+	Raster  string  `json:"raster"`
+	Band    int32   `json:"band"`
+	Blocks  int     `json:"blocks"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"`
+	Bytes   int64   `json:"bytes"`
+	Elapsed float64 `json:"elapsed"`
+}
+
+// newProgress returns the progress of reading band of raster name, or nil
+// when there is nothing to report to: with --progress none, and in auto mode
+// when stderr is not a terminal or the output is JSON or quiet, where a
+// redrawn line would only be noise.
+func newProgress(name string, band int32) *progress {
+	p := &progress{w: os.Stderr, raster: name, band: band, start: time.Now()}
+	switch progressMode {
+	case "json":
+		p.json = true
+		return p
+	case "auto":
+		if jsonOutput || logLevel > slog.LevelWarn || !isTerminal(os.Stderr) {
+			return nil
+		}
+		return p
+	}
+	return nil
+}
+
+// update reports pr: every progressEventBlocks blocks and at the end as an
+// event, or by redrawing the bar unless it was drawn less than progressEvery
+// ago.
+func (p *progress) update(pr raster.Progress) {
+	if pr.Total == 0 {
+		return
+	}
+	now := time.Now()
+	if p.json {
+		if pr.Blocks < p.next && pr.Blocks < pr.Total {
+			return
+		}
+		p.next = pr.Blocks + progressEventBlocks
+		json.NewEncoder(p.w).Encode(progressEvent{
+			Raster:  p.raster,
+			Band:    p.band,
+			Blocks:  pr.Blocks,
+			Total:   pr.Total,
+			Percent: float64(int(1000*pr.Blocks/pr.Total)) / 10,
+			Bytes:   pr.Bytes,
+			Elapsed: now.Sub(p.start).Round(time.Millisecond).Seconds(),
+		})
+		return
+	}
+
+	if now.Sub(p.drawn) < progressEvery {
+		return
+	}
+	p.drawn = now
+
+	filled := progressWidth * pr.Blocks / pr.Total
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", progressWidth-filled)
+	line := fmt.Sprintf("%s band %d [%s] %3d%% %d/%d blocks", p.raster, p.band, bar, 100*pr.Blocks/pr.Total, pr.Blocks, pr.Total)
+
+	elapsed := now.Sub(p.start).Seconds()
+	if pr.Blocks > 0 && elapsed > 0 {
+		rate := float64(pr.Blocks) / elapsed
+		eta := time.Duration(float64(pr.Total-pr.Blocks) / rate * float64(time.Second))
+		line += fmt.Sprintf(" %.0f blocks/s ETA %s", rate, eta.Round(time.Second))
+	}
+	fmt.Fprint(p.w, "\r\x1b[K"+line)
+}
+
+// clear erases the bar, if there is one, for whatever is printed next.
+func (p *progress) clear() {
+	if p != nil && !p.json {
+		fmt.Fprint(p.w, "\r\x1b[K")
+	}
+}
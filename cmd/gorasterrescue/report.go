@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// reportPath is set by the global --report flag: the file the report of the
+// run is written to when it ends, as HTML if it ends in .html or .htm and as
+// JSON otherwise.
+var reportPath = ""
+
+// runReport is the report of the run, or nil. It is kept for --checksums
+// and --manifest too, which take the checksums of the files it lists.
+var runReport *rescueReport
+
+// rescueReport is what a run found and wrote, for whoever takes over the
+// data: the datasets of each geodatabase read, those extracted with the rows
+// or blocks written and lost, checksums of the files written and the warnings
+// logged. Its methods do nothing on a nil report.
+type rescueReport struct {
+	mu   sync.Mutex
+	done bool
+
+	Command  string           `json:"command"`
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"`
+	ExitCode int              `json:"exit_code"`
+	Found    []*reportFound   `json:"found"`
+	Datasets []*reportDataset `json:"datasets"`
+	Warnings []string         `json:"warnings"`
+
+	rows map[string]int // rows yielded, by .gdbtable
+	lost map[string]int // rows that could not be read, by .gdbtable
+}
+
+// reportFound lists the datasets of a geodatabase the run looked through.
+type reportFound struct {
+	GDB            string   `json:"gdb"`
+	Rasters        []string `json:"rasters,omitempty"`
+	FeatureClasses []string `json:"feature_classes,omitempty"`
+	Tables         []string `json:"tables,omitempty"`
+}
+
+// reportDataset is one raster, feature class or table the run extracted or
+// failed to. Rows counts the rows written, those filled with nulls included;
+// RowsLost those that could not be read, skipped or filled.
+type reportDataset struct {
+	GDB        string         `json:"gdb"`
+	Name       string         `json:"name"`
+	Kind       string         `json:"kind"`   // raster, feature class or table
+	Status     string         `json:"status"` // extracted, partial or failed
+	Outputs    []reportOutput `json:"outputs"`
+	Blocks     int            `json:"blocks,omitempty"`
+	BlocksLost int            `json:"blocks_lost,omitempty"`
+	Unplaced   int            `json:"unplaced_rows,omitempty"` // block rows lost whose block is unknown
+	Rows       int            `json:"rows,omitempty"`
+	RowsLost   int            `json:"rows_lost,omitempty"`
+	Error      string         `json:"error,omitempty"`
+
+	table string // the .gdbtable read, for the rows counted
+}
+
+// reportOutput is a file written, as it was when the run ended.
+type reportOutput struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// startReport starts the report of command, passing the warnings logged
+// from now on to it as well.
+func startReport(command string) {
+	runReport = &rescueReport{
+		Command:  command,
+		Started:  time.Now(),
+		Found:    make([]*reportFound, 0),
+		Datasets: make([]*reportDataset, 0),
+		Warnings: make([]string, 0),
+		rows:     make(map[string]int),
+		lost:     make(map[string]int),
+	}
+	slog.SetDefault(slog.New(warningRecorder{slog.Default().Handler(), runReport}))
+}
+
+// warningRecorder hands the records of the run on to its handler and keeps
+// the warnings and errors for the report, even under --quiet.
+type warningRecorder struct {
+	slog.Handler
+	r *rescueReport
+}
+
+func (h warningRecorder) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn || h.Handler.Enabled(ctx, level)
+}
+
+func (h warningRecorder) Handle(ctx context.Context, rec slog.Record) error {
+	if rec.Level >= slog.LevelWarn {
+		var b strings.Builder
+		b.WriteString(rec.Message)
+		rec.Attrs(func(a slog.Attr) bool {
+			fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+			return true
+		})
+		h.r.mu.Lock()
+		h.r.Warnings = append(h.r.Warnings, b.String())
+		h.r.mu.Unlock()
+	}
+	if !h.Handler.Enabled(ctx, rec.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h warningRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return warningRecorder{h.Handler.WithAttrs(attrs), h.r}
+}
+
+func (h warningRecorder) WithGroup(name string) slog.Handler {
+	return warningRecorder{h.Handler.WithGroup(name), h.r}
+}
+
+// found records the datasets of kind the geodatabase at gdbFilePath holds.
+func (r *rescueReport) found(gdbFilePath string, kind string, names []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var f *reportFound
+	for _, g := range r.Found {
+		if g.GDB == gdbFilePath {
+			f = g
+		}
+	}
+	if f == nil {
+		f = &reportFound{GDB: gdbFilePath}
+		r.Found = append(r.Found, f)
+	}
+	switch kind {
+	case "raster":
+		f.Rasters = names
+	case "feature class":
+		f.FeatureClasses = names
+	default:
+		f.Tables = names
+	}
+}
+
+// tableNames lists the names of tables.
+func tableNames(tables []gdb.TableInfo) []string {
+	names := make([]string, 0, len(tables))
+	for _, t := range tables {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// dataset returns the entry of dataset name of kind of the geodatabase at
+// gdbFilePath, adding it if needed.
+func (r *rescueReport) dataset(gdbFilePath string, kind string, name string) *reportDataset {
+	for _, d := range r.Datasets {
+		if d.GDB == gdbFilePath && d.Kind == kind && d.Name == name {
+			return d
+		}
+	}
+	d := &reportDataset{GDB: gdbFilePath, Name: name, Kind: kind, Outputs: make([]reportOutput, 0)}
+	r.Datasets = append(r.Datasets, d)
+	return d
+}
+
+// band records band of raster name of the geodatabase at gdbFilePath written
+// to path, with the damage done to it, or nil for a band an earlier run had
+// written.
+func (r *rescueReport) band(gdbFilePath string, name string, path string, rb *raster.RasterBase, d raster.Damage) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ds := r.dataset(gdbFilePath, "raster", name)
+	ds.Outputs = append(ds.Outputs, reportOutput{Path: path})
+	if rb != nil {
+		ds.Blocks += rb.Blocks()
+		ds.BlocksLost += d.Blocks
+		ds.Unplaced += d.Unplaced
+	}
+}
+
+// wrote records that dataset name of kind, read from table id of the
+// geodatabase at gdbFilePath, was written to paths.
+func (r *rescueReport) wrote(kind string, name string, gdbFilePath string, id int, paths ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ds := r.dataset(gdbFilePath, kind, name)
+	ds.table = gdb.FilePath(gdbFilePath, gdb.TableFileName(id)+".gdbtable")
+	for _, path := range paths {
+		ds.Outputs = append(ds.Outputs, reportOutput{Path: path})
+		// A Shapefile is its .shp and the files beside it.
+		if strings.HasSuffix(path, ".shp") {
+			for _, ext := range []string{".shx", ".dbf", ".prj", ".cpg"} {
+				sibling := strings.TrimSuffix(path, ".shp") + ext
+				if _, err := os.Stat(sibling); err == nil {
+					ds.Outputs = append(ds.Outputs, reportOutput{Path: sibling})
+				}
+			}
+		}
+	}
+}
+
+// failed records that dataset name of kind of the geodatabase at gdbFilePath
+// could not be extracted.
+func (r *rescueReport) failed(gdbFilePath string, kind string, name string, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dataset(gdbFilePath, kind, name).Error = err.Error()
+}
+
+// row counts a row yielded, for gdb.WithRowCount.
+func (r *rescueReport) row(table string) {
+	r.mu.Lock()
+	r.rows[table]++
+	r.mu.Unlock()
+}
+
+// badRow counts a row that could not be read, for gdb.WithRowReport.
+func (r *rescueReport) badRow(e *gdb.RowError) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.lost[e.Table]++
+	r.mu.Unlock()
+}
+
+// finish completes the report of a run ending with code, checksumming the
+// files written, writes the checksums asked for and writes the report to
+// reportPath if there is one. Only the first call does.
+func (r *rescueReport) finish(code int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	r.Finished = time.Now()
+	r.ExitCode = code
+	for _, d := range r.Datasets {
+		if d.table != "" {
+			d.Rows, d.RowsLost = r.rows[d.table], r.lost[d.table]
+		}
+		for i := range d.Outputs {
+			d.Outputs[i].Bytes, d.Outputs[i].SHA256 = checksum(d.Outputs[i].Path)
+		}
+		switch {
+		case d.Error != "":
+			d.Status = "failed"
+		case d.BlocksLost > 0 || d.Unplaced > 0 || d.RowsLost > 0:
+			d.Status = "partial"
+		default:
+			d.Status = "extracted"
+		}
+	}
+	if err := writeChecksums(r.Datasets); err != nil {
+		fmt.Fprintln(os.Stderr, "goRasterRescue: writing checksums:", err)
+	}
+	if reportPath == "" {
+		return
+	}
+
+	f, err := os.Create(reportPath)
+	if err == nil {
+		ext := strings.ToLower(filepath.Ext(reportPath))
+		if ext == ".html" || ext == ".htm" {
+			err = reportHTML.Execute(f, r)
+		} else {
+			enc := json.NewEncoder(f)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(r)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "goRasterRescue: writing the report:", err)
+	}
+}
+
+// checksum returns the size and SHA-256 of the file at path, or zeros if it
+// cannot be read.
+func checksum(path string) (int64, string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, ""
+	}
+	return n, hex.EncodeToString(h.Sum(nil))
+}
+
+// NotExtracted lists the datasets found that the run did not extract, with
+// their geodatabase when the run read more than one.
+func (r *rescueReport) NotExtracted() []string {
+	done := make(map[[3]string]bool)
+	for _, d := range r.Datasets {
+		done[[3]string{d.GDB, d.Kind, d.Name}] = true
+	}
+	missed := make([]string, 0)
+	for _, f := range r.Found {
+		for _, kn := range []struct {
+			kind  string
+			names []string
+		}{{"raster", f.Rasters}, {"feature class", f.FeatureClasses}, {"table", f.Tables}} {
+			for _, name := range kn.names {
+				if done[[3]string{f.GDB, kn.kind, name}] {
+					continue
+				}
+				if len(r.Found) > 1 {
+					missed = append(missed, name+" ("+kn.kind+", "+f.GDB+")")
+				} else {
+					missed = append(missed, name+" ("+kn.kind+")")
+				}
+			}
+		}
+	}
+	return missed
+}
+
+// reportHTML is the report as a page to hand to the owners of the data.
+var reportHTML = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Rescue report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+td.n { text-align: right; }
+.extracted { color: #2a7a2a; } .partial { color: #a86a00; } .failed { color: #b22; }
+code { font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>Rescue report</h1>
+<p>Geodatabase{{if gt (len .Found) 1}}s{{end}} {{range $i, $f := .Found}}{{if $i}}, {{end}}<code>{{$f.GDB}}</code>{{end}}, command <code>{{.Command}}</code>, run from
+{{.Started.Format "2006-01-02 15:04:05"}} to {{.Finished.Format "2006-01-02 15:04:05"}},
+exit status {{.ExitCode}}.</p>
+<h2>Datasets extracted</h2>
+<table>
+<tr>{{if gt (len .Found) 1}}<th>Geodatabase</th>{{end}}<th>Name</th><th>Kind</th><th>Status</th><th>Rows written</th><th>Rows lost</th><th>Blocks</th><th>Blocks lost</th><th>Files</th></tr>
+{{$batch := gt (len .Found) 1}}{{range .Datasets}}<tr>
+{{if $batch}}<td><code>{{.GDB}}</code></td>{{end}}<td>{{.Name}}</td><td>{{.Kind}}</td><td class="{{.Status}}">{{.Status}}{{if .Error}}: {{.Error}}{{end}}</td>
+<td class="n">{{if ne .Kind "raster"}}{{.Rows}}{{end}}</td><td class="n">{{if ne .Kind "raster"}}{{.RowsLost}}{{end}}</td>
+<td class="n">{{if eq .Kind "raster"}}{{.Blocks}}{{end}}</td><td class="n">{{if eq .Kind "raster"}}{{.BlocksLost}}{{if .Unplaced}} (and {{.Unplaced}} unplaced){{end}}{{end}}</td>
+<td>{{range .Outputs}}<code>{{.Path}}</code> {{.Bytes}} bytes<br><code>sha256 {{.SHA256}}</code><br>{{end}}</td>
+</tr>
+{{end}}</table>
+{{with .NotExtracted}}<h2>Datasets found but not extracted</h2>
+<ul>{{range .}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{with .Warnings}}<h2>Warnings</h2>
+<ul>{{range .}}<li><code>{{.}}</code></li>{{end}}</ul>
+{{end}}</body>
+</html>
+`))
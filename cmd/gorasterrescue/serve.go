@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// ServedGDB is a geodatabase serve reads from, and the id requests name it
+// by.
+type ServedGDB struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+}
+
+// ServedRaster is what serve tells of a raster: its bands, or why it cannot
+// be opened.
+type ServedRaster struct {
+	Name  string       `json:"name"`
+	WKT   string       `json:"wkt,omitempty"`
+	Bands []ServedBand `json:"bands,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// ServedBand is what serve tells of a band of a raster.
+type ServedBand struct {
+	Band        int        `json:"band"`
+	Name        string     `json:"name,omitempty"`
+	DataType    string     `json:"data_type"`
+	Width       int32      `json:"width"`
+	Height      int32      `json:"height"`
+	BlockWidth  int32      `json:"block_width"`
+	BlockHeight int32      `json:"block_height"`
+	Compression string     `json:"compression"`
+	Extent      [4]float64 `json:"extent"` // minx, miny, maxx, maxy
+	Error       string     `json:"error,omitempty"`
+}
+
+// ExtractRequest is the body of a POST /extract: the raster to extract, by
+// the id of its geodatabase and its name, and how. Without an Output the
+// GeoTIFF of its single band is sent back.
+type ExtractRequest struct {
+	GDB     string          `json:"gdb"`
+	Raster  string          `json:"raster"`
+	Output  string          `json:"output,omitempty"`  // under the storage of serve -out
+	Window  []float64       `json:"window,omitempty"`  // minx, miny, maxx, maxy
+	Cutline json.RawMessage `json:"cutline,omitempty"` // GeoJSON polygons
+	NoData  *float64        `json:"nodata,omitempty"`
+	Verify  bool            `json:"verify,omitempty"`
+	Resume  bool            `json:"resume,omitempty"`
+}
+
+// ExtractResult is the answer to a POST /extract with an Output: the files
+// written and the blocks lost writing them.
+type ExtractResult struct {
+	Paths  []string `json:"paths"`
+	Losses int64    `json:"losses"`
+	Status string   `json:"status"` // ok or partial
+}
+
+// server answers the requests of serve.
+type server struct {
+	ctx     context.Context // of the command, with the settings of the global flags
+	gdbs    []ServedGDB
+	storage string // where extractions with an output go, "" for none
+	workers int
+	sem     chan struct{}      // one slot for each extraction run at once
+	cache   *raster.BlockCache // of the blocks decoded for tiles
+
+	rasterGDB map[string]string // by raster name, the id of the first geodatabase holding it
+
+	tileMu      sync.Mutex                      // guards tileDBs, tileRasters and tileBands
+	tileDBs     map[string]*gdb.Geodatabase     // by id, open for tiles until serve stops
+	tileRasters map[[2]string]*raster.Raster    // by geodatabase id and raster name
+	tileBands   map[tileBand]*raster.RasterData // opened by OpenBand, their block tables open until serve stops
+}
+
+// tileBand is a band of a raster of a geodatabase served, by their ids,
+// name and sequence number.
+type tileBand struct {
+	id, name string
+	band     int
+}
+
+func runServe(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	storage := fs.String("out", outputDir, "directory extractions asked to be kept are written under (default none, sending every GeoTIFF back)")
+	workers := fs.Int("workers", threads, "blocks decoded at once for each extraction (default one per CPU)")
+	parallel := fs.Int("parallel", 1, "extractions run at once, others waiting their turn")
+	blockCache := fs.Int("block-cache", 1024, "decoded blocks kept for the tiles of web maps")
+	fs.Parse(args)
+
+	paths := batchPaths(fs.Args())
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "serve takes the paths, URLs or glob patterns of the geodatabases to serve")
+		exit(exitUsage)
+	}
+	// Nobody watches the progress bars of a server.
+	if progressMode == "auto" {
+		progressMode = "none"
+	}
+
+	s := &server{ctx: ctx, storage: *storage, workers: *workers, sem: make(chan struct{}, max(*parallel, 1)),
+		cache: raster.NewBlockCache(*blockCache)}
+	for i, dir := range batchDirs("", paths) {
+		s.gdbs = append(s.gdbs, ServedGDB{ID: dir, Path: paths[i]})
+	}
+	s.indexRasters()
+	defer s.closeTiles()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /gdbs", s.listGDBs)
+	mux.HandleFunc("GET /gdbs/{id}/rasters", s.listRasters)
+	mux.HandleFunc("GET /gdbs/{id}/rasters/{name}", s.rasterInfo)
+	mux.HandleFunc("POST /extract", s.extract)
+	mux.HandleFunc("GET /tiles/{raster}/{z}/{x}/{y}", s.tile)
+
+	ln, err := net.Listen("tcp", *addr)
+	check(err)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("serving", "addr", ln.Addr().String(), "geodatabases", len(s.gdbs), "storage", s.storage)
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Extractions under way see ctx canceled too, and stop.
+		slog.Info("shutting down", "addr", ln.Addr().String())
+		shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err = srv.Shutdown(shutdown)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		check(err)
+	}
+}
+
+// gdb returns the path of the geodatabase id names, writing a 404 if none
+// does.
+func (s *server) gdb(w http.ResponseWriter, id string) (string, bool) {
+	path, ok := s.gdbPath(id)
+	if !ok {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no geodatabase %q", id))
+	}
+	return path, ok
+}
+
+// gdbPath returns the path of the geodatabase id names, if one does.
+func (s *server) gdbPath(id string) (string, bool) {
+	for _, g := range s.gdbs {
+		if g.ID == id {
+			return g.Path, true
+		}
+	}
+	return "", false
+}
+
+// indexRasters maps the name of each raster of the geodatabases served to
+// the first of them holding one of that name, for the tiles that do not
+// name their geodatabase. A geodatabase that cannot be opened is left out,
+// with a warning.
+func (s *server) indexRasters() {
+	s.rasterGDB = make(map[string]string)
+	for _, g := range s.gdbs {
+		db, err := gdb.OpenContext(s.ctx, g.Path)
+		if err != nil {
+			slog.Warn("geodatabase left out of tiles without gdb", "gdb", g.Path, "err", err)
+			continue
+		}
+		for _, r := range db.Rasters() {
+			if _, ok := s.rasterGDB[r.Name]; !ok {
+				s.rasterGDB[r.Name] = g.ID
+			}
+		}
+		db.Close()
+	}
+}
+
+// tileRaster returns raster name of geodatabase id, opened for the first
+// tile asked of it and kept open, so that its block table is walked once for
+// where its blocks are and each tile after reads only those it overlaps. It
+// fails with the status to answer with.
+func (s *server) tileRaster(id, name string) (*raster.Raster, int, error) {
+	s.tileMu.Lock()
+	defer s.tileMu.Unlock()
+	if r, ok := s.tileRasters[[2]string{id, name}]; ok {
+		return r, 0, nil
+	}
+	db, ok := s.tileDBs[id]
+	if !ok {
+		path, ok := s.gdbPath(id)
+		if !ok {
+			return nil, http.StatusNotFound, fmt.Errorf("no geodatabase %q", id)
+		}
+		var err error
+		if db, err = gdb.OpenContext(s.ctx, path); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if s.tileDBs == nil {
+			s.tileDBs = make(map[string]*gdb.Geodatabase)
+			s.tileRasters = make(map[[2]string]*raster.Raster)
+		}
+		s.tileDBs[id] = db
+	}
+	if !db.MasterTable().IsRaster(name) {
+		return nil, http.StatusNotFound, fmt.Errorf("no raster called %q", name)
+	}
+	r, err := raster.Open(db, name)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	s.tileRasters[[2]string{id, name}] = r
+	return r, 0, nil
+}
+
+// openTileBand returns band band of raster r, name of geodatabase id,
+// opened by OpenBand for the first tile asked of it and kept open with its
+// block table, which the tiles after read their blocks from, each through a
+// reader of its own.
+func (s *server) openTileBand(id, name string, r *raster.Raster, band int) (*raster.RasterData, error) {
+	s.tileMu.Lock()
+	defer s.tileMu.Unlock()
+	key := tileBand{id, name, band}
+	if lb, ok := s.tileBands[key]; ok {
+		return lb, nil
+	}
+	lb, err := r.OpenBand(raster.ReadOptions{Band: band, Cache: s.cache, NoData: noDataOverride})
+	if err != nil {
+		return nil, err
+	}
+	if s.tileBands == nil {
+		s.tileBands = make(map[tileBand]*raster.RasterData)
+	}
+	s.tileBands[key] = lb
+	return lb, nil
+}
+
+// closeTiles closes the bands and geodatabases opened for tiles.
+func (s *server) closeTiles() {
+	s.tileMu.Lock()
+	defer s.tileMu.Unlock()
+	for _, lb := range s.tileBands {
+		lb.BaseTab.Close()
+	}
+	for _, db := range s.tileDBs {
+		db.Close()
+	}
+}
+
+// open opens the geodatabase id names with ctx, from requestContext,
+// writing the error if it cannot be. The caller closes it.
+func (s *server) open(ctx context.Context, w http.ResponseWriter, id string) (*gdb.Geodatabase, bool) {
+	path, ok := s.gdb(w, id)
+	if !ok {
+		return nil, false
+	}
+	db, err := gdb.OpenContext(ctx, path)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return db, true
+}
+
+// requestContext returns the context of the command, carrying the settings
+// of the global flags, canceled too once the client of req goes away.
+func (s *server) requestContext(req *http.Request) context.Context {
+	ctx, cancel := context.WithCancel(s.ctx)
+	context.AfterFunc(req.Context(), cancel)
+	return ctx
+}
+
+func (s *server) listGDBs(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, s.gdbs)
+}
+
+func (s *server) listRasters(w http.ResponseWriter, req *http.Request) {
+	db, ok := s.open(s.requestContext(req), w, req.PathValue("id"))
+	if !ok {
+		return
+	}
+	defer db.Close()
+	rasters := make([]ServedRaster, 0)
+	for _, r := range db.Rasters() {
+		rasters = append(rasters, servedRaster(db, r.Name))
+	}
+	writeJSON(w, http.StatusOK, rasters)
+}
+
+func (s *server) rasterInfo(w http.ResponseWriter, req *http.Request) {
+	db, ok := s.open(s.requestContext(req), w, req.PathValue("id"))
+	if !ok {
+		return
+	}
+	defer db.Close()
+	name := req.PathValue("name")
+	if !db.MasterTable().IsRaster(name) {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no raster called %q", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, servedRaster(db, name))
+}
+
+// servedRaster describes raster name of db, and each of its bands.
+func servedRaster(db *gdb.Geodatabase, name string) ServedRaster {
+	info := ServedRaster{Name: name}
+	r, err := raster.Open(db, name)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.WKT = r.WKT
+	for _, band := range r.Bands {
+		b := ServedBand{Band: int(band.SequenceNbr)}
+		rb, err := r.Band(b.Band)
+		if err != nil {
+			b.Error = err.Error()
+		} else {
+			b.Name, b.DataType, b.Compression = rb.Name, rb.DataType, rb.CompressionType
+			b.Width, b.Height, b.BlockWidth, b.BlockHeight = rb.BandWidth, rb.BandHeight, rb.BlockWidth, rb.BlockHeight
+			b.Extent = [4]float64{rb.EMinX, rb.EMinY, rb.EMaxX, rb.EMaxY}
+		}
+		info.Bands = append(info.Bands, b)
+	}
+	return info
+}
+
+// extract extracts a raster as extract does, with the global flags, to the
+// output the request asks for under the storage, answering with the files
+// written, or to a temporary file sent back as it is once complete.
+func (s *server) extract(w http.ResponseWriter, req *http.Request) {
+	var er ExtractRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, 64<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&er); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts := raster.ReadOptions{Verify: er.Verify, Workers: s.workers, NoData: er.NoData}
+	if er.Window != nil {
+		if len(er.Window) != 4 || er.Window[0] >= er.Window[2] || er.Window[1] >= er.Window[3] {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("window %v is not minx, miny, maxx, maxy", er.Window))
+			return
+		}
+		opts.Window = er.Window
+	}
+	if er.Cutline != nil {
+		// Named after what it holds, for a checkpoint to be resumed only
+		// with the same cutline.
+		src := fmt.Sprintf("cutline:%x", sha256.Sum256(er.Cutline))
+		cut, err := raster.ParseCutline(src[:24], er.Cutline)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		opts.Cutline = cut
+	}
+	toStorage := er.Output != ""
+	if toStorage && s.storage == "" {
+		httpError(w, http.StatusBadRequest, errors.New("an output needs serve -out, where outputs are kept"))
+		return
+	}
+	if toStorage && !filepath.IsLocal(er.Output) {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("output %q is not a path within the storage", er.Output))
+		return
+	}
+	// Losses are counted for the request, as batch counts them for each
+	// geodatabase.
+	var losses atomic.Int64
+	ctx := context.WithValue(s.requestContext(req), lossKey{}, &losses)
+	ctx = gdb.WithRowReport(ctx, func(e *gdb.RowError) {
+		losses.Add(1)
+		badRow(e)
+	})
+	db, ok := s.open(ctx, w, er.GDB)
+	if !ok {
+		return
+	}
+	defer db.Close()
+	if !db.MasterTable().IsRaster(er.Raster) {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no raster called %q", er.Raster))
+		return
+	}
+
+	out := filepath.Join(s.storage, er.Output)
+	if !toStorage {
+		tmp, err := os.MkdirTemp("", "goRasterRescue")
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer os.RemoveAll(tmp)
+		out = filepath.Join(tmp, er.Raster+".tif")
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-req.Context().Done():
+		return
+	}
+	slog.Info("extracting", "gdb", er.GDB, "raster", er.Raster, "output", out)
+	paths, err := extractRaster(db, er.Raster, out, opts, er.Resume)
+	if err == nil && !toStorage && len(paths) != 1 {
+		err = fmt.Errorf("%s has %d bands, which cannot be sent back as one GeoTIFF; ask for an output", er.Raster, len(paths))
+	}
+	if err != nil {
+		runReport.failed(db.Path, "raster", er.Raster, err)
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res := ExtractResult{Paths: paths, Losses: losses.Load(), Status: "ok"}
+	if res.Losses > 0 {
+		res.Status = "partial"
+	}
+	if toStorage {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	f, err := os.Open(paths[0])
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "image/tiff")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", er.Raster+".tif"))
+	w.Header().Set("X-Losses", fmt.Sprint(res.Losses))
+	http.ServeContent(w, req, "", time.Time{}, f)
+}
+
+// tile renders tile z/x/y.png of a raster for a web map, as writer.Tiler
+// places it, from only the blocks it overlaps, found through the block index
+// of the raster, kept open from its first tile, decoded as they are asked
+// for and kept in the block cache for the tiles around it. Query parameters
+// pick the geodatabase, gdb, for a raster more than one of them holds,
+// otherwise the first holding it when serve started, the band, 1 by default,
+// and the scale its values are stretched by, as --scale takes it: p2,98 of
+// each tile by default, or two values for tiles that match.
+func (s *server) tile(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("raster")
+	z, errZ := strconv.Atoi(req.PathValue("z"))
+	x, errX := strconv.Atoi(req.PathValue("x"))
+	ys, png := strings.CutSuffix(req.PathValue("y"), ".png")
+	y, errY := strconv.Atoi(ys)
+	if errZ != nil || errX != nil || errY != nil || !png {
+		http.NotFound(w, req)
+		return
+	}
+	q := req.URL.Query()
+	band := 1
+	if b := q.Get("band"); b != "" {
+		var err error
+		if band, err = strconv.Atoi(b); err != nil || band < 1 {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("band %q is not a band number", b))
+			return
+		}
+	}
+	sc := writer.Scale{Min: 2, Max: 98, Percentiles: true}
+	if v := q.Get("scale"); v != "" {
+		var err error
+		if sc, err = writer.ParseScale(v); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	id := q.Get("gdb")
+	if id == "" {
+		// The first geodatabase holding a raster of that name.
+		var ok bool
+		if id, ok = s.rasterGDB[name]; !ok {
+			httpError(w, http.StatusNotFound, fmt.Errorf("no raster called %q", name))
+			return
+		}
+	}
+	r, status, err := s.tileRaster(id, name)
+	if err != nil {
+		httpError(w, status, err)
+		return
+	}
+	if !slices.ContainsFunc(r.Bands, func(b raster.RasterBand) bool { return int(b.SequenceNbr) == band }) {
+		httpError(w, http.StatusNotFound, fmt.Errorf("%s has no band %d", name, band))
+		return
+	}
+	// The band is read a window at a time, through the block cache.
+	lb, err := s.openTileBand(id, name, r, band)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	tiler, err := writer.NewTiler(&lb.RasBase, r.WKT)
+	if err != nil {
+		httpError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	tl, err := tiler.Tile(z, x, y)
+	if err != nil {
+		httpError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var rd *raster.RasterData
+	if win := tl.Window(); win != nil {
+		rd, err = lb.Window(raster.Window{MinX: win[0], MinY: win[1], MaxX: win[2], MaxY: win[3]})
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "image/png")
+	if err := tl.WritePNG(w, rd, sc); err != nil {
+		slog.Warn("tile not sent", "raster", name, "tile", fmt.Sprintf("%d/%d/%d", z, x, y), "err", err)
+	}
+}
+
+// writeJSON writes v as the JSON body of a response of status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("response not sent", "err", err)
+	}
+}
+
+// httpError writes err as the JSON body of a response of status.
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// tableFormats maps the table export formats onto their file extensions.
+var tableFormats = map[string]string{
+	"csv":     ".csv",
+	"parquet": ".parquet",
+	"sqlite":  ".sqlite",
+}
+
+// attributeTables lists the tables of the master table that exist on disk,
+// leaving out the system tables, the raster internals and the attachment
+// tables, which can still be exported by name.
+func attributeTables(gdbFilePath string, mt *gdb.MasterTable) []gdb.TableInfo {
+	tables := make([]gdb.TableInfo, 0)
+	for _, t := range mt.Tables {
+		if strings.HasPrefix(t.Name, "fras_") || strings.HasPrefix(t.Name, "GDB_") || mt.IsRaster(t.Name) || strings.HasSuffix(t.Name, attachTableSuffix) {
+			continue
+		}
+		if !gdb.TableExists(gdbFilePath, t.ID) {
+			continue
+		}
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+// indexList is the indexes of a table as a cell of a listing: each as its
+// name and columns in text, and whole in JSON.
+type indexList []gdb.Index
+
+func (l indexList) String() string {
+	s := make([]string, len(l))
+	for i, idx := range l {
+		s[i] = idx.Name + "(" + strings.Join(idx.Columns, ",") + ")"
+	}
+	return strings.Join(s, " ")
+}
+
+// tableIndexes reads the indexes of table info for a listing, warning about
+// those it cannot read.
+func tableIndexes(ctx context.Context, gdbFilePath string, info gdb.TableInfo) indexList {
+	indexes, err := gdb.ReadIndexes(ctx, gdbFilePath, gdb.TableFileName(info.ID))
+	if err != nil {
+		slog.Warn("cannot read all indexes", "table", info.Name, "err", err)
+	}
+	if indexes == nil {
+		return indexList{}
+	}
+	return indexList(indexes)
+}
+
+// whereContext returns ctx selecting the rows -where s names, if any, or
+// ends the program if s is not a selection.
+func whereContext(ctx context.Context, s string) context.Context {
+	if s == "" {
+		return ctx
+	}
+	w, err := gdb.ParseWhere(s)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-where:", err)
+		exit(2)
+	}
+	return gdb.WithWhere(ctx, w)
+}
+
+func runTable(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: goRasterRescue table list|export [flags] [name...]")
+		exit(2)
+	}
+
+	fs := flag.NewFlagSet("table "+args[0], flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	out := fs.String("o", cmp.Or(outputDir, "."), "output directory for export, - for stdout (one table, as CSV)")
+	format := fs.String("format", "csv", "export format: csv, parquet, or sqlite (one file for all tables)")
+	where := fs.String("where", "", "export only the rows whose FIELD=value, looked up in the attribute index of the field if there is one")
+	fs.Parse(args[1:])
+	wctx := whereContext(ctx, *where)
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+	mt := db.MasterTable()
+
+	switch args[0] {
+	case "list":
+		t := newTable("name", "file", "fields", "rows", "indexes")
+		for _, info := range attributeTables(db.Path, mt) {
+			bt, err := gdb.NewBaseTableContext(ctx, db.Path, gdb.TableFileName(info.ID))
+			if err != nil {
+				check(ctx.Err())
+				t.add(healthBad, info.Name, gdb.TableFileName(info.ID), "error", err.Error(), tableIndexes(ctx, db.Path, info))
+				continue
+			}
+			t.add(healthNone, info.Name, gdb.TableFileName(info.ID), len(bt.Fields), bt.NFeaturesX, tableIndexes(ctx, db.Path, info))
+			bt.Close()
+		}
+		t.render(os.Stdout)
+
+	case "export":
+		if _, ok := tableFormats[*format]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+			exit(2)
+		}
+		tables := attributeTables(db.Path, mt)
+		if fs.NArg() > 0 {
+			tables = make([]gdb.TableInfo, 0, fs.NArg())
+			for _, name := range fs.Args() {
+				id := mt.TableID(name)
+				if id == 0 {
+					fmt.Fprintf(os.Stderr, "no table called %q\n", name)
+					continue
+				}
+				tables = append(tables, gdb.TableInfo{Name: name, ID: id})
+			}
+		}
+		if *out == "-" {
+			if *format != "csv" || len(tables) != 1 {
+				fmt.Fprintln(os.Stderr, "-o - writes one table, named, as CSV to stdout")
+				exit(2)
+			}
+			info := tables[0]
+			runReport.found(db.Path, "table", tableNames(attributeTables(db.Path, mt)))
+			if err := writer.WriteCSV(wctx, os.Stdout, db.Path, gdb.TableFileName(info.ID)); err != nil {
+				runReport.failed(db.Path, "table", info.Name, err)
+				check(err)
+			}
+			runReport.wrote("table", info.Name, db.Path, info.ID)
+			return
+		}
+		check(os.MkdirAll(*out, 0755))
+		runReport.found(db.Path, "table", tableNames(attributeTables(db.Path, mt)))
+
+		for _, info := range tables {
+			printAttachments(ctx, db.Path, mt, info.Name, *out)
+		}
+
+		// All tables go into one database named after the geodatabase.
+		if *format == "sqlite" {
+			sqliteTables := make([]writer.SQLiteTable, 0, len(tables))
+			written := make([]gdb.TableInfo, 0, len(tables))
+			for _, info := range tables {
+				slog.Info("exporting table", "name", info.Name, "format", *format)
+				st, err := writer.NewSQLiteTable(wctx, db.Path, gdb.TableFileName(info.ID), info.Name)
+				if err != nil {
+					check(ctx.Err())
+					runReport.failed(db.Path, "table", info.Name, err)
+					checkAbort(err)
+					lost(ctx)
+					slog.Warn("skipping table", "name", info.Name, "err", err)
+					continue
+				}
+				sqliteTables = append(sqliteTables, st)
+				written = append(written, info)
+			}
+			path := filepath.Join(*out, datasetName(db.Path)+tableFormats[*format])
+			check(writer.WriteSQLite(ctx, path, sqliteTables, 0, 0))
+			for _, info := range written {
+				runReport.wrote("table", info.Name, db.Path, info.ID, path)
+			}
+			fmt.Println(path)
+			return
+		}
+		for _, info := range tables {
+			path := filepath.Join(*out, info.Name+tableFormats[*format])
+			slog.Info("exporting table", "name", info.Name, "format", *format)
+			var err error
+			if *format == "parquet" {
+				err = writer.WriteTableParquet(wctx, db.Path, gdb.TableFileName(info.ID), path)
+			} else {
+				err = writer.WriteTableCSV(wctx, db.Path, gdb.TableFileName(info.ID), path)
+			}
+			if err != nil {
+				check(ctx.Err())
+				runReport.failed(db.Path, "table", info.Name, err)
+				checkAbort(err)
+				lost(ctx)
+				slog.Warn("skipping table", "name", info.Name, "err", err)
+				continue
+			}
+			runReport.wrote("table", info.Name, db.Path, info.ID, path)
+			fmt.Println(path)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown table command %q\n", args[0])
+		exit(2)
+	}
+}
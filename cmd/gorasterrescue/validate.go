@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// Failure is one row of a table, or block of a raster, that cannot be read,
+// and where it is.
+type Failure struct {
+	Row    int    // 1-based object id in the file of the dataset
+	Offset int64  // of the row in that file, 0 if its index does not locate it
+	Block  string // band, level, row and column of a block that does not decode
+	Error  string
+}
+
+// Validation is what validate found out about one table or raster.
+type Validation struct {
+	Name     string
+	Kind     string // table or raster
+	File     string // the .gdbtable read, the block table of a raster
+	Index    string // what is wrong with its .gdbtablx, if anything
+	Units    int    // rows read, or rows of the block table of a raster, pyramid levels included
+	Failures []Failure
+	Error    string // why it cannot be read at all
+	Status   string // ok, partial or unreadable
+}
+
+// settle sets the status of v: unreadable if none of it can be read,
+// partial if some rows or blocks, or the index, fail, and ok otherwise.
+func (v *Validation) settle() {
+	switch {
+	case v.Error != "" || v.Units > 0 && len(v.Failures) == v.Units:
+		v.Status = "unreadable"
+	case v.Index != "" || len(v.Failures) > 0:
+		v.Status = "partial"
+	default:
+		v.Status = "ok"
+	}
+}
+
+// fail records the failure of row fid (0-based) at offset.
+func (v *Validation) fail(fid int, offset int64, block string, err error) {
+	var re *gdb.RowError
+	if errors.As(err, &re) {
+		err = re.Err
+	}
+	v.Failures = append(v.Failures, Failure{fid + 1, offset, block, err.Error()})
+}
+
+// checkIndex records what is wrong with the .gdbtablx of table tableName.
+func (v *Validation) checkIndex(ctx context.Context, gdbFilePath string, tableName string) {
+	if err := gdb.CheckTablx(ctx, gdbFilePath, tableName); err != nil {
+		v.Index = err.Error()
+	}
+}
+
+// validateTable checks the index and every row of table t.
+func validateTable(ctx context.Context, gdbFilePath string, t gdb.TableInfo) (v Validation) {
+	v = Validation{Name: t.Name, Kind: "table", File: gdb.TableFileName(t.ID) + ".gdbtable"}
+	defer v.settle()
+
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, gdb.TableFileName(t.ID))
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	defer bt.Close()
+	v.checkIndex(ctx, gdbFilePath, gdb.TableFileName(t.ID))
+	for fid := 0; fid < int(bt.NFeaturesX); fid++ {
+		_, offset, err := bt.CheckRow(fid)
+		if offset == 0 && err == nil {
+			continue
+		}
+		v.Units++
+		if err != nil {
+			v.fail(fid, offset, "", err)
+		}
+	}
+	return v
+}
+
+// validateRaster checks the index and every row of the block table of
+// raster name, and that every block decodes.
+func validateRaster(ctx context.Context, gdbFilePath string, mt *gdb.MasterTable, name string) (v Validation) {
+	v = Validation{Name: name, Kind: "raster"}
+	defer v.settle()
+
+	bndID, blkID := raster.TableIDs(mt, name)
+	if bndID == 0 {
+		v.Error = "band or block table missing"
+		return v
+	}
+	v.File = gdb.TableFileName(blkID) + ".gdbtable"
+	bands, err := rasterBands(gdbFilePath, bndID)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	bases := bandsByID(bands)
+
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, gdb.TableFileName(blkID))
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	defer bt.Close()
+	v.checkIndex(ctx, gdbFilePath, gdb.TableFileName(blkID))
+	for fid := 0; fid < int(bt.NFeaturesX); fid++ {
+		row, offset, err := bt.CheckRow(fid)
+		if offset == 0 && err == nil {
+			continue
+		}
+		v.Units++
+		if err != nil {
+			v.fail(fid, offset, "", err)
+			continue
+		}
+		blk := raster.BlockRowOf(&bt, row)
+		rb := bases[int(blk.BandID)]
+		if rb == nil || blk.Data == nil {
+			continue
+		}
+		if err := checkBlock(blk.Data, rb); err != nil {
+			where := fmt.Sprintf("band %d level %d row %d col %d", blk.BandID, blk.RRDFactor, blk.RowNbr, blk.ColNbr)
+			v.fail(fid, offset, where, err)
+		}
+	}
+	return v
+}
+
+func printValidation(w io.Writer, validations []Validation) {
+	if jsonOutput {
+		check(json.NewEncoder(w).Encode(validations))
+		return
+	}
+
+	t := newTable("name", "kind", "file", "units", "failed", "status")
+	for _, v := range validations {
+		units := fmt.Sprintf("%d rows", v.Units)
+		if v.Kind == "raster" {
+			units = fmt.Sprintf("%d block rows, incl. overviews", v.Units)
+		}
+		h := map[string]health{"ok": healthOK, "partial": healthWarn, "unreadable": healthBad}[v.Status]
+		t.add(h, v.Name, v.Kind, v.File, units, len(v.Failures), v.Status)
+	}
+	t.render(w)
+
+	for _, v := range validations {
+		if v.Error != "" {
+			fmt.Fprintf(w, "%s: %s\n", v.Name, v.Error)
+		}
+		if v.Index != "" {
+			fmt.Fprintf(w, "%s: index: %s\n", v.Name, v.Index)
+		}
+		for _, f := range v.Failures {
+			at := fmt.Sprintf("row %d", f.Row)
+			if f.Offset != 0 {
+				at += fmt.Sprintf(" at offset %d", f.Offset)
+			}
+			if f.Block != "" {
+				at += " (" + f.Block + ")"
+			}
+			fmt.Fprintf(w, "%s: %s: %s\n", v.Name, at, f.Error)
+		}
+	}
+}
+
+func runValidate(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	gdbDir := fs.String("gdb", gdbPath, "path or http(s)://, s3:// or gs:// URL of the .gdb directory, or of a zip archive of it")
+	fs.Parse(args)
+
+	db, err := gdb.OpenContext(ctx, *gdbDir)
+	check(err)
+	defer db.Close()
+	mt := db.MasterTable()
+
+	// The block tables of rasters are checked with their rasters, and the
+	// rest of the tables, system tables included, on their own. Like a
+	// diagnosis, a validation cut short is dropped rather than printed.
+	blockTables := make(map[int]bool)
+	validations := make([]Validation, 0)
+	for _, r := range mt.Rasters {
+		slog.Info("validating raster", "name", r.Name)
+		_, blkID := raster.TableIDs(mt, r.Name)
+		blockTables[blkID] = true
+		validations = append(validations, validateRaster(ctx, db.Path, mt, r.Name))
+		check(ctx.Err())
+	}
+	for _, t := range mt.Tables {
+		if blockTables[t.ID] || !gdb.TableExists(db.Path, t.ID) {
+			continue
+		}
+		slog.Info("validating table", "name", t.Name)
+		validations = append(validations, validateTable(ctx, db.Path, t))
+		check(ctx.Err())
+	}
+
+	printValidation(os.Stdout, validations)
+	for _, v := range validations {
+		if v.Status != "ok" {
+			exit(exitPartial)
+		}
+	}
+}
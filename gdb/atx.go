@@ -0,0 +1,268 @@
+package gdb
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"slices"
+	"strings"
+)
+
+// An .atx is a B-tree of pages of atxPage bytes, numbered from 1, followed
+// by a trailer of atxTrailer bytes: the size of the keys in a byte, a byte of
+// flags, a uint32 1, the depth of the tree as a uint32, then counts. Page 1
+// is the root. A page that is not a leaf has a uint32 count n of keys at 4,
+// the n+1 pages below it as uint32s from 8, and its keys, each the largest of
+// the page below it, from the offset where the keys of every page start. A
+// leaf has the next leaf at 0, its count at 4, the 1-based object ids of its
+// rows as uint32s from 12, and their keys. String keys are UTF-16LE padded
+// with spaces to the width of the field, or cut to atxMaxChars.
+const (
+	atxPage     = 4096
+	atxTrailer  = 22
+	atxMaxChars = 80
+)
+
+// attributeIndex is an open .atx over one field of a table.
+type attributeIndex struct {
+	f       *gdbFile
+	fld     *Field
+	lower   bool // the keys are lowercased, as in LOWER(Name)
+	keySize int
+	depth   int
+	pages   int64
+	perPage int   // the most keys a page holds
+	keysOff int64 // where the keys of a page start
+}
+
+// openAttributeIndex opens the .atx of index idx of table tableName, whose
+// keys are values of fld, and checks its trailer.
+func openAttributeIndex(fsys fs.FS, gdbFilePath string, tableName string, idx Index, fld *Field) (*attributeIndex, error) {
+	ix, err := openIndexFile(fsys, gdbFilePath, tableName+"."+idx.Name+".atx", fld)
+	if err != nil {
+		return nil, err
+	}
+	ix.lower = strings.HasPrefix(strings.ToUpper(idx.Expression), "LOWER(")
+	return ix, nil
+}
+
+// openIndexFile opens file name, a B-tree laid out as an .atx whose keys
+// are values of fld, and checks its trailer.
+func openIndexFile(fsys fs.FS, gdbFilePath string, name string, fld *Field) (*attributeIndex, error) {
+	f, err := openGDBFile(fsys, gdbFilePath, name)
+	if err != nil {
+		return nil, err
+	}
+	ix := &attributeIndex{f: f, fld: fld}
+	if err := ix.readTrailer(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (ix *attributeIndex) readTrailer() error {
+	f := ix.f
+	if f.size < atxPage+atxTrailer || (f.size-atxTrailer)%atxPage != 0 {
+		return fmt.Errorf("%s: %w: %d bytes, not whole pages and a trailer", f.Name(), ErrCorruptHeader, f.size)
+	}
+	f.Seek(f.size-atxTrailer, 0)
+	b := f.next(atxTrailer)
+	if err := f.Err(); err != nil {
+		return err
+	}
+	ix.keySize = int(b[0])
+	ix.depth = int(binary.LittleEndian.Uint32(b[6:]))
+	ix.pages = (f.size - atxTrailer) / atxPage
+	switch {
+	case binary.LittleEndian.Uint32(b[2:]) != 1:
+		return fmt.Errorf("%s: %w: magic number %d", f.Name(), ErrCorruptHeader, binary.LittleEndian.Uint32(b[2:]))
+	case ix.depth < 1 || ix.depth > 4:
+		return fmt.Errorf("%s: %w: depth %d", f.Name(), ErrCorruptHeader, ix.depth)
+	case ix.keySize != keySize(ix.fld):
+		return fmt.Errorf("%s: keys of %d bytes do not fit field %s of type %d", f.Name(), ix.keySize, ix.fld.Name, ix.fld.Type)
+	}
+	ix.perPage = (atxPage - 12) / (4 + ix.keySize)
+	ix.keysOff = 12 + int64(ix.perPage)*4
+	return nil
+}
+
+// keySize returns the size of the keys of fld in an .atx, or 0 if its
+// values are not looked up.
+func keySize(fld *Field) int {
+	switch fld.Type {
+	case 0:
+		return 2
+	case 1, 2:
+		return 4
+	case 3, 13:
+		return 8
+	case 4:
+		return 2 * min(int(fld.Width), atxMaxChars)
+	}
+	return 0
+}
+
+// lookup returns the 0-based fids of the rows whose key is want, a value
+// of the field as readValue decodes it, in fid order.
+func (ix *attributeIndex) lookup(want interface{}) ([]int, error) {
+	if s, ok := want.(string); ok {
+		if ix.lower {
+			s = strings.ToLower(s)
+		}
+		// Keys are cut to the width of the field and lose trailing spaces.
+		if r := []rune(s); len(r) > ix.keySize/2 {
+			s = string(r[:ix.keySize/2])
+		}
+		want = strings.TrimRight(s, " ")
+	}
+	fids, err := ix.between(want, want)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(fids)
+	return fids, nil
+}
+
+// between returns the 0-based fids of the rows whose key is from lo to hi,
+// in key order.
+func (ix *attributeIndex) between(lo, hi interface{}) ([]int, error) {
+	// Down to the first leaf that can hold lo: below the first key that is
+	// not smaller, or the last page if none.
+	page := int64(1)
+	for level := 1; level < ix.depth; level++ {
+		n, err := ix.count(page)
+		if err != nil {
+			return nil, err
+		}
+		i := 0
+		for i < n && compareKey(ix.key(page, i), lo) < 0 {
+			i++
+		}
+		page = ix.child(page, i)
+	}
+
+	// Along the leaves for as long as their keys are not larger than hi.
+	fids := make([]int, 0)
+leaves:
+	for visited := int64(0); page != 0; visited++ {
+		if visited == ix.pages {
+			return nil, fmt.Errorf("%s: the leaves loop", ix.f.Name())
+		}
+		n, err := ix.count(page)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < n; i++ {
+			k := ix.key(page, i)
+			switch {
+			case compareKey(k, hi) > 0:
+				break leaves
+			case compareKey(k, lo) >= 0:
+				ix.f.Seek((page-1)*atxPage+12+int64(i)*4, 0)
+				fids = append(fids, int(readU32(ix.f))-1)
+			}
+		}
+		ix.f.Seek((page-1)*atxPage, 0)
+		page = int64(readU32(ix.f))
+	}
+	if err := ix.f.Err(); err != nil {
+		return nil, err
+	}
+	return fids, nil
+}
+
+// edge returns the smallest key of the index, the first of its first leaf,
+// or the largest if last, the last of its last leaf; nil if it is empty.
+func (ix *attributeIndex) edge(last bool) (interface{}, error) {
+	page := int64(1)
+	for level := 1; level < ix.depth; level++ {
+		n, err := ix.count(page)
+		if err != nil {
+			return nil, err
+		}
+		if !last {
+			n = 0
+		}
+		page = ix.child(page, n)
+	}
+	n, err := ix.count(page)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	if !last {
+		n = 1
+	}
+	k := ix.key(page, n-1)
+	return k, ix.f.Err()
+}
+
+// child returns page i below page, which is not a leaf.
+func (ix *attributeIndex) child(page int64, i int) int64 {
+	ix.f.Seek((page-1)*atxPage+8+int64(i)*4, 0)
+	return int64(readU32(ix.f))
+}
+
+// count returns the number of keys of page, checking that the page exists.
+func (ix *attributeIndex) count(page int64) (int, error) {
+	if page < 1 || page > ix.pages {
+		return 0, fmt.Errorf("%s: page %d of %d", ix.f.Name(), page, ix.pages)
+	}
+	ix.f.Seek((page-1)*atxPage+4, 0)
+	n := int(readU32(ix.f))
+	if err := ix.f.Err(); err != nil {
+		return 0, err
+	}
+	if n > ix.perPage {
+		return 0, fmt.Errorf("%s: page %d has %d keys, more than %d fit", ix.f.Name(), page, n, ix.perPage)
+	}
+	return n, nil
+}
+
+// key decodes key i of page.
+func (ix *attributeIndex) key(page int64, i int) interface{} {
+	ix.f.Seek((page-1)*atxPage+ix.keysOff+int64(i*ix.keySize), 0)
+	b := ix.f.next(ix.keySize)
+	if ix.f.Err() != nil {
+		return nil
+	}
+	switch ix.fld.Type {
+	case 0:
+		return int16(binary.LittleEndian.Uint16(b))
+	case 1:
+		return int32(binary.LittleEndian.Uint32(b))
+	case 2:
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	case 3:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case 13:
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return strings.TrimRight(utf16String(b), " ")
+}
+
+// compareKey orders two keys of the same type. A key that could not be read
+// is taken as larger than any other, which ends a search.
+func compareKey(a, b interface{}) int {
+	switch a := a.(type) {
+	case int16:
+		return cmp.Compare(a, b.(int16))
+	case int32:
+		return cmp.Compare(a, b.(int32))
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case float32:
+		return cmp.Compare(a, b.(float32))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case string:
+		return cmp.Compare(a, b.(string))
+	}
+	return 1
+}
+
+func (ix *attributeIndex) Close() {
+	ix.f.Close()
+}
@@ -0,0 +1,86 @@
+package gdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BBox selects the features of a feature class whose envelope meets the
+// rectangle, in the coordinates of the feature class.
+type BBox struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// ParseBBox reads a BBox written as minx,miny,maxx,maxy.
+func ParseBBox(s string) (BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BBox{}, fmt.Errorf("%q is not minx,miny,maxx,maxy", s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(f) {
+			return BBox{}, fmt.Errorf("bad value %q in %q", p, s)
+		}
+		v[i] = f
+	}
+	b := BBox{v[0], v[1], v[2], v[3]}
+	if b.MinX > b.MaxX || b.MinY > b.MaxY {
+		return BBox{}, fmt.Errorf("%q has its minimum above its maximum", s)
+	}
+	return b, nil
+}
+
+func (b BBox) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.MinX, b.MinY, b.MaxX, b.MaxY)
+}
+
+// bboxKey is the key of the context value holding the BBox.
+type bboxKey struct{}
+
+// WithBBox returns a copy of ctx under which the rows of the tables read
+// through RowIterator are only the features b selects. A table without a
+// shape field yields no rows, Err saying why. The rows are looked up in the
+// .spx spatial index of the table when it has one and is read through its
+// .gdbtablx, and found by reading every row otherwise.
+func WithBBox(ctx context.Context, b BBox) context.Context {
+	return context.WithValue(ctx, bboxKey{}, b)
+}
+
+func bboxOf(ctx context.Context) (BBox, bool) {
+	b, ok := ctx.Value(bboxKey{}).(BBox)
+	return b, ok
+}
+
+// bboxFilter is a BBox bound to a table: the index of its shape field.
+type bboxFilter struct {
+	field int
+	box   BBox
+}
+
+// match reports whether the envelope of the shape of row meets the box. A
+// null or empty shape meets nothing.
+func (f *bboxFilter) match(row *Row) bool {
+	g, ok := row.Values[f.field].(Geometry)
+	if !ok {
+		return false
+	}
+	minX, minY, maxX, maxY := g.Bounds()
+	return minX <= f.box.MaxX && f.box.MinX <= maxX && minY <= f.box.MaxY && f.box.MinY <= maxY
+}
+
+// bbox binds b to the table. Like where, it returns the rows to read when
+// the spatial index tells them, and nil otherwise; they still go through the
+// filter, the index listing features by the cells of a grid.
+func (bt *BaseTable) bbox(b BBox) (rowFilter, []int, error) {
+	for i := range bt.Fields {
+		if bt.Fields[i].Type == 7 {
+			return &bboxFilter{i, b}, bt.spatialLookup(&bt.Fields[i], b), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("bbox %s: %s has no shape field", b, bt.GdbTablePath)
+}
@@ -0,0 +1,34 @@
+package gdb_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/internal/gdbfixture"
+)
+
+// BenchmarkHeaderParse opens a table of 200 fields, parsing its header and
+// field descriptions, as opening each table of a geodatabase does.
+func BenchmarkHeaderParse(b *testing.B) {
+	table := gdbfixture.Table{Name: "wide"}
+	for i := range 200 {
+		table.Fields = append(table.Fields, gdbfixture.Field{
+			Name: fmt.Sprint("field_", i), Alias: fmt.Sprint("Field ", i), Type: []uint8{gdbfixture.TypeInt32, gdbfixture.TypeString, gdbfixture.TypeFloat64}[i%3], Nullable: true,
+		})
+	}
+	fsys, err := gdbfixture.Geodatabase{Tables: []gdbfixture.Table{table}}.Files()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	b.ReportAllocs()
+	for range b.N {
+		bt, err := gdb.NewBaseTableFS(ctx, fsys, "bench.gdb", gdb.TableFileName(2))
+		if err != nil {
+			b.Fatal(err)
+		}
+		bt.Close()
+	}
+}
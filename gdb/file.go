@@ -0,0 +1,341 @@
+package gdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// gdbFile is an open .gdbtable or .gdbtablx file. Reads never fail loudly:
+// the first one that runs past the end of the file records an error naming
+// the file and offset, and every read after it returns zeros, so a reader can
+// decode a whole structure and check Err once at the end.
+//
+// A gdbFile reads through io.ReaderAt and keeps its own offset, so files held
+// in memory or behind a network connection read the same way as files on
+// disk, and two gdbFiles over one reader never move each other's offset.
+//
+// Parsing a header or a row takes many reads of a few bytes each. Unless the
+// file is already in memory, they are served from a window of the file read
+// ahead in one go, rather than each going to the file.
+type gdbFile struct {
+	r    io.ReaderAt
+	c    io.Closer // nil when there is nothing to close
+	name string
+	size int64
+	off  int64
+	err  error
+
+	buf    []byte // the window read ahead, nil if the file is in memory
+	bufOff int64  // offset of buf in the file
+	bufLen int    // bytes of buf read
+}
+
+// gdbFileWindow is the size of the window read ahead, and gdbFileDirect the
+// size from which reads bypass it, a block of raster data being read once.
+const (
+	gdbFileWindow = 64 << 10
+	gdbFileDirect = gdbFileWindow / 4
+)
+
+// FilePath returns the path of file name of the geodatabase at gdbFilePath
+// as the readers give it in errors and BaseTable.GdbTablePath: joined with
+// the separator of the system for a directory or archive, with a slash for a
+// URL, whether gdbFilePath ends in one or not.
+func FilePath(gdbFilePath string, name string) string {
+	if isRemote(gdbFilePath) {
+		return strings.TrimSuffix(gdbFilePath, "/") + "/" + name
+	}
+	return filepath.Join(gdbFilePath, name)
+}
+
+// openGDBFile opens file name of fsys. dir is joined to name in errors.
+// Local files are mapped into memory where the system allows it. Files that
+// cannot be read at an offset, such as the compressed members of a zip
+// archive, are read into memory.
+func openGDBFile(fsys fs.FS, dir string, name string) (*gdbFile, error) {
+	path := FilePath(dir, name)
+	f, err := fsys.Open(name)
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) && pe.Path == name {
+			pe.Path = path
+		}
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if osf, ok := f.(*os.File); ok {
+		if m, ok := mapFile(osf, fi.Size()); ok {
+			return &gdbFile{r: m, c: m, name: path, size: fi.Size()}, nil
+		}
+	}
+	if r, ok := f.(io.ReaderAt); ok {
+		return &gdbFile{r: r, c: f, name: path, size: fi.Size(), buf: make([]byte, gdbFileWindow)}, nil
+	}
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &gdbFile{r: bytes.NewReader(b), name: path, size: int64(len(b))}, nil
+}
+
+// sourceFS returns the files of the directory gdbFilePath, which is given
+// with its trailing separator, so "" is the current directory. A gdbFilePath
+// ending in .zip is an archive holding the directory, and one starting with
+// http://, https://, s3:// or gs:// is read over the network, with ctx.
+func sourceFS(ctx context.Context, gdbFilePath string) fs.FS {
+	if isZip(gdbFilePath) {
+		return zipFS{gdbFilePath, ctx}
+	}
+	if isRemote(gdbFilePath) {
+		return remoteFS{gdbFilePath, ctx}
+	}
+	if gdbFilePath == "" {
+		gdbFilePath = "."
+	}
+	return os.DirFS(gdbFilePath)
+}
+
+// Name returns the path of the file, as used in errors.
+func (f *gdbFile) Name() string {
+	return f.name
+}
+
+// Seek sets the offset of the next read, relative to the start of the file
+// for whence 0, to the current offset for 1 and to the end for 2. Seeking
+// never fails; reads past the end do.
+func (f *gdbFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	f.off = offset
+	return f.off, nil
+}
+
+// reader returns a gdbFile over the same file for another goroutine: it has
+// an offset, an error and a window of its own, and closing it leaves the
+// file open. The readers under a gdbFile all read at an offset and are safe
+// for concurrent use.
+func (f *gdbFile) reader() *gdbFile {
+	r := &gdbFile{r: f.r, name: f.name, size: f.size}
+	if f.buf != nil {
+		r.buf = make([]byte, len(f.buf))
+	}
+	return r
+}
+
+// Close closes the underlying file.
+func (f *gdbFile) Close() error {
+	if f.c == nil {
+		return nil
+	}
+	return f.c.Close()
+}
+
+func (f *gdbFile) offset() int64 {
+	return f.off
+}
+
+// fail records err at the current offset, unless an error is already
+// recorded.
+func (f *gdbFile) fail(err error) {
+	if f.err == nil {
+		f.err = fmt.Errorf("%s at offset %d: %w", f.Name(), f.offset(), err)
+	}
+}
+
+// Err returns the recorded error, if any.
+func (f *gdbFile) Err() error {
+	return f.err
+}
+
+// clearErr forgets the recorded error, to carry on with the next row.
+func (f *gdbFile) clearErr() {
+	f.err = nil
+}
+
+// read returns the next size bytes, or zeros once reading has failed. Sizes
+// larger than what is left of the file fail without allocating them; the
+// zeros returned then are capped at 64 KiB, more than any fixed size field.
+func (f *gdbFile) read(size int) []byte {
+	if f.err == nil && (size < 0 || f.off < 0 || int64(size) > f.size-f.off) {
+		f.fail(fmt.Errorf("cannot read %d bytes, the file is %d bytes long", size, f.size))
+	}
+	if f.err != nil {
+		return make([]byte, min(max(size, 0), 1<<16))
+	}
+	b := make([]byte, size)
+	if f.buffered(size) {
+		copy(b, f.buf[f.off-f.bufOff:])
+		f.off += int64(size)
+		return b
+	}
+	n, err := f.r.ReadAt(b, f.off)
+	if n < size {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		f.fail(err)
+	}
+	f.off += int64(n)
+	return b
+}
+
+// skip moves past the next n bytes, n being a length read from the file. It
+// fails as read does when fewer are left, rather than seeking to wherever a
+// length too large for an int64 wraps to, which may be back into the bytes
+// just read.
+func (f *gdbFile) skip(n uint64) {
+	if f.err == nil && (f.off < 0 || n > uint64(max(f.size-f.off, 0))) {
+		f.fail(fmt.Errorf("cannot skip %d bytes, the file is %d bytes long", n, f.size))
+	}
+	if f.err == nil {
+		f.off += int64(n)
+	}
+}
+
+// buffered reports whether the size bytes at the offset are in the window,
+// reading the window from there if they are not yet and size is small
+// enough. The read is left to the caller, and so are its errors when the
+// window cannot be read.
+func (f *gdbFile) buffered(size int) bool {
+	if f.buf == nil || size >= gdbFileDirect {
+		return false
+	}
+	if f.off >= f.bufOff && f.off+int64(size) <= f.bufOff+int64(f.bufLen) {
+		return true
+	}
+	n, _ := f.r.ReadAt(f.buf[:min(int64(len(f.buf)), f.size-f.off)], f.off)
+	f.bufOff, f.bufLen = f.off, n
+	return n >= size
+}
+
+// next returns the next size bytes like read, without copying them out of
+// the window: they are only valid until the next read. For the fixed size
+// fields.
+func (f *gdbFile) next(size int) []byte {
+	if f.err == nil && f.off >= 0 && int64(size) <= f.size-f.off && f.buffered(size) {
+		b := f.buf[f.off-f.bufOff : f.off-f.bufOff+int64(size)]
+		f.off += int64(size)
+		return b
+	}
+	return f.read(size)
+}
+
+func readU32(f *gdbFile) uint32 {
+	return binary.LittleEndian.Uint32(f.next(4))
+}
+
+func readByte(f *gdbFile) uint8 {
+	return f.next(1)[0]
+}
+
+func readBytes(f *gdbFile, size int) []byte {
+	return f.read(size)
+}
+
+func readInt16(f *gdbFile) int16 {
+	return int16(binary.LittleEndian.Uint16(f.next(2)))
+}
+
+func readInt32(f *gdbFile) int32 {
+	return int32(binary.LittleEndian.Uint32(f.next(4)))
+}
+
+func readInt64(f *gdbFile) int64 {
+	return int64(binary.LittleEndian.Uint64(f.next(8)))
+}
+
+func readFloat32(f *gdbFile) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(f.next(4)))
+}
+
+func readFloat64(f *gdbFile) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(f.next(8)))
+}
+
+// readVarUint reads an unsigned varint of at most 64 bits.
+func readVarUint(f *gdbFile) uint64 {
+	shift := uint64(0)
+	ret := uint64(0)
+	for {
+		b := readByte(f)
+		ret |= ((uint64(b) & 0x7F) << shift)
+		if (b & 0x80) == 0 {
+			break
+		}
+		shift += 7
+		if shift >= 64 {
+			f.fail(errors.New("varint longer than 64 bits"))
+			return 0
+		}
+	}
+	return ret
+}
+
+// readVarInt reads a signed varint: the first byte carries 6 bits of value
+// and the sign, the following ones 7 bits each.
+func readVarInt(f *gdbFile) int64 {
+	b := readByte(f)
+	ret := int64(b & 0x3F)
+	sign := int64(1)
+	if (b & 0x40) != 0 {
+		sign = -1
+	}
+	shift := uint64(6)
+	for (b & 0x80) != 0 {
+		if shift >= 64 {
+			f.fail(errors.New("varint longer than 64 bits"))
+			return 0
+		}
+		b = readByte(f)
+		ret |= int64(b&0x7F) << shift
+		shift += 7
+	}
+	return sign * ret
+}
+
+// getString reads a UTF-16LE string of nb code units, or with nb == -1 one
+// prefixed by its length. That prefix is a single unsigned byte, as in GDAL's
+// reader, so names and aliases of 128 to 255 code units read fine; it is not
+// a varint, and reading it as one would misparse every such field.
+func getString(f *gdbFile, nb int) string {
+	var nbcar int
+	if nb == -1 {
+		nbcar = int(readByte(f))
+	} else {
+		nbcar = nb
+	}
+	// Names, aliases and WKT are UTF-16LE, nbcar code units long.
+	b := readBytes(f, 2*nbcar)
+	if f.Err() != nil {
+		return ""
+	}
+	return utf16String(b)
+}
+
+// utf16String decodes UTF-16LE b, dropping an odd last byte.
+func utf16String(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for j := range units {
+		units[j] = binary.LittleEndian.Uint16(b[2*j:])
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,123 @@
+package gdb
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+)
+
+// A .freelist lists the free blocks of a .gdbtable, the space left by rows
+// deleted or rewritten elsewhere, for the geodatabase to reuse. Until it is
+// reused, a free block still holds the row it held, only its size negated,
+// so deleted rows can be read back from the blocks the .freelist lists.
+//
+// The .freelist is pages of freelistPage bytes followed by a trailer of
+// freelistTrailer bytes chaining the pages into lists by block size. Each
+// page is the number of its entries as a uint32 and the index of the next
+// page of its list, or -1, as an int32, followed by its entries: the size of
+// a free block as a uint32, counting the int32 that starts it, and its
+// offset in 5 bytes. Every page is read, whether the trailer chains it or
+// not, as the rows found are checked anyway.
+const (
+	freelistPage    = 4096
+	freelistTrailer = 344
+	freelistEntry   = 9
+)
+
+// freeBlock is a free block listed in a .freelist.
+type freeBlock struct {
+	offset, size int64
+}
+
+// undeleteKey is the key of the context value asking for deleted rows.
+type undeleteKey struct{}
+
+// WithDeletedRows returns a copy of ctx under which tables are opened with
+// the rows deleted from them that their .freelist still lists and whose data
+// is whole, to undelete rows removed by accident. They follow the rows of
+// the table, in the order of the .gdbtable, and BaseTable.Deleted tells them
+// apart. The rows deleted from the master table are left out, the tables
+// they listed being gone, and so are those of tables read with WithoutTablx,
+// which reads them anyway.
+func WithDeletedRows(ctx context.Context) context.Context {
+	return context.WithValue(ctx, undeleteKey{}, true)
+}
+
+// deletedWanted reports whether ctx asks for deleted rows.
+func deletedWanted(ctx context.Context) bool {
+	v, _ := ctx.Value(undeleteKey{}).(bool)
+	return v
+}
+
+// readFreelist returns the free blocks listed in f.
+func readFreelist(f *gdbFile) ([]freeBlock, error) {
+	var blocks []freeBlock
+	for page := int64(0); (page+1)*freelistPage+freelistTrailer <= f.size; page++ {
+		f.Seek(page*freelistPage, 0)
+		n := int64(readU32(f))
+		readInt32(f) // next page
+		if n > (freelistPage-8)/freelistEntry {
+			return blocks, fmt.Errorf("%s: page %d has %d entries", f.Name(), page, n)
+		}
+		for i := int64(0); i < n; i++ {
+			b := f.next(freelistEntry)
+			var off [8]byte
+			copy(off[:], b[4:])
+			blocks = append(blocks, freeBlock{int64(binary.LittleEndian.Uint64(off[:])), int64(binary.LittleEndian.Uint32(b))})
+		}
+		if err := f.Err(); err != nil {
+			return blocks, err
+		}
+	}
+	return blocks, nil
+}
+
+// readDeleted returns the offsets of the deleted rows the .freelist of table
+// tableName lists, in the order of the .gdbtable, keeping those whose fields
+// take exactly their size. A table without a .freelist has none.
+func (bt *BaseTable) readDeleted(fsys fs.FS, gdbFilePath string, tableName string) []int64 {
+	f, err := openGDBFile(fsys, gdbFilePath, tableName+".freelist")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		slog.Warn("cannot read deleted rows", "table", bt.GdbTablePath, "err", err)
+		return nil
+	}
+	blocks, err := readFreelist(f)
+	f.Close()
+	if err != nil {
+		slog.Warn("skipping part of the free list", "table", bt.GdbTablePath, "err", err)
+	}
+	slices.SortFunc(blocks, func(a, b freeBlock) int { return cmp.Compare(a.offset, b.offset) })
+
+	var offsets []int64
+	t := bt.gdbTable
+	for i, b := range blocks {
+		if i > 0 && b.offset == blocks[i-1].offset {
+			continue
+		}
+		if b.size <= 4 || b.offset <= 0 || b.offset+b.size > t.size {
+			continue
+		}
+		// A block freed but reused starts with a positive size, and blocks
+		// freed next to each other may be listed as one.
+		t.clearErr()
+		t.Seek(b.offset, 0)
+		size := -int64(readInt32(t))
+		if size <= 0 || size+4 > b.size || !bt.rowFits(b.offset, size) {
+			continue
+		}
+		offsets = append(offsets, b.offset)
+	}
+	t.clearErr()
+	if len(offsets) > 0 {
+		slog.Info("recovered deleted rows", "table", bt.GdbTablePath, "rows", len(offsets))
+	}
+	return offsets
+}
@@ -0,0 +1,45 @@
+package gdb_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"testing/fstest"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/internal/gdbfixture"
+)
+
+// maxFuzzRows is how many rows of a fuzzed table are read, whatever its
+// .gdbtablx claims.
+const maxFuzzRows = 1000
+
+// FuzzNewBaseTable opens a table from the bytes of its .gdbtable and
+// .gdbtablx and reads its rows: whatever the bytes, it must fail or read,
+// never panic. The seeds are tables gdbfixture writes, in every version.
+func FuzzNewBaseTable(f *testing.F) {
+	for _, version := range []int32{gdbfixture.Version9, gdbfixture.Version10, gdbfixture.Version64Bit} {
+		gdbtable, gdbtablx, err := everyType(version).Files()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(gdbtable, gdbtablx)
+		f.Add(gdbtable, []byte(nil))
+	}
+	f.Fuzz(func(t *testing.T, gdbtable, gdbtablx []byte) {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+		fsys := fstest.MapFS{"a00000002.gdbtable": {Data: gdbtable}}
+		if gdbtablx != nil {
+			fsys["a00000002.gdbtablx"] = &fstest.MapFile{Data: gdbtablx}
+		}
+		bt, err := gdb.NewBaseTableFS(context.Background(), fsys, "fuzz.gdb", "a00000002")
+		if err != nil {
+			return
+		}
+		defer bt.Close()
+		for fid := range min(int(bt.NFeaturesX), maxFuzzRows) {
+			bt.ReadRow(fid)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package gdb
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Geodatabase is an open file geodatabase: a .gdb directory and the list of
+// tables its master table holds.
+type Geodatabase struct {
+	// Path is the .gdb directory, or the name given to OpenFS, ending in a
+	// path separator. File names are appended to it in errors.
+	Path string
+
+	ctx    context.Context
+	fsys   fs.FS
+	master MasterTable
+}
+
+// Open reads the master table of the file geodatabase in the .gdb directory
+// path. Rows of the master table that cannot be read are reported on stderr
+// and left out. Close releases the files it keeps open.
+func Open(path string) (*Geodatabase, error) {
+	return OpenContext(context.Background(), path)
+}
+
+// OpenContext is Open with a context bounding the life of the geodatabase:
+// the tables opened through it, rasters included, stop reading with
+// ctx.Err() once ctx is done.
+func OpenContext(ctx context.Context, path string) (*Geodatabase, error) {
+	path = cleanPath(path)
+	return openFS(ctx, sourceFS(ctx, path), path)
+}
+
+// OpenFS is Open reading the files of the geodatabase from fsys rather than
+// from a directory: an fstest.MapFS held in memory, a zip.Reader over an
+// archive of the .gdb directory, or any fs.FS whose files are fetched on
+// demand. Files that implement io.ReaderAt are read in place, others are
+// read into memory when their table is opened. name stands for the
+// geodatabase in errors and in Path.
+func OpenFS(fsys fs.FS, name string) (*Geodatabase, error) {
+	return openFS(context.Background(), fsys, name)
+}
+
+func openFS(ctx context.Context, fsys fs.FS, name string) (*Geodatabase, error) {
+	name = cleanPath(name)
+	mt, err := newMasterTable(ctx, fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return &Geodatabase{Path: name, ctx: ctx, fsys: fsys, master: mt}, nil
+}
+
+// cleanPath normalizes the path of a geodatabase as given by a user, so that
+// the names of its files are the path followed by theirs: a local path is
+// cleaned, its slashes made the separators of the system, and ends in a
+// separator; a URL ends in a slash.
+func cleanPath(path string) string {
+	if isRemote(path) {
+		if !strings.HasSuffix(path, "/") {
+			path += "/"
+		}
+		return path
+	}
+	path = filepath.Clean(path)
+	if !strings.HasSuffix(path, string(os.PathSeparator)) {
+		path += string(os.PathSeparator)
+	}
+	return path
+}
+
+// Context returns the context the geodatabase was opened with.
+func (db *Geodatabase) Context() context.Context {
+	return db.ctx
+}
+
+// Close closes the master table.
+func (db *Geodatabase) Close() {
+	db.master.BaseTab.Close()
+}
+
+// MasterTable returns the master table read by Open.
+func (db *Geodatabase) MasterTable() *MasterTable {
+	return &db.master
+}
+
+// Tables lists every table of the geodatabase, system and raster tables
+// included.
+func (db *Geodatabase) Tables() []TableInfo {
+	return db.master.Tables
+}
+
+// Rasters lists the raster datasets of the geodatabase.
+func (db *Geodatabase) Rasters() []RasterInfo {
+	return db.master.Rasters
+}
+
+// OpenTable opens the table called name. The caller closes it.
+func (db *Geodatabase) OpenTable(name string) (*BaseTable, error) {
+	id := db.master.TableID(name)
+	if id == 0 {
+		return nil, fmt.Errorf("no table called %q", name)
+	}
+	bt, err := newBaseTable(db.ctx, db.fsys, db.Path, TableFileName(id))
+	if err != nil {
+		return nil, err
+	}
+	return &bt, nil
+}
+
+// RawTable is the .gdbtable of a table read as bytes, whatever its header
+// says, for carving what is left of a table too damaged to open.
+type RawTable struct {
+	f *gdbFile
+}
+
+// OpenRawTable opens the .gdbtable of the table called name as bytes. The
+// caller closes it.
+func (db *Geodatabase) OpenRawTable(name string) (*RawTable, error) {
+	id := db.master.TableID(name)
+	if id == 0 {
+		return nil, fmt.Errorf("no table called %q", name)
+	}
+	f, err := openGDBFile(db.fsys, db.Path, TableFileName(id)+".gdbtable")
+	if err != nil {
+		return nil, err
+	}
+	return &RawTable{f}, nil
+}
+
+// ReadAt reads len(p) bytes of the file at offset off.
+func (t *RawTable) ReadAt(p []byte, off int64) (int, error) {
+	return t.f.r.ReadAt(p, off)
+}
+
+// Size returns the size of the file.
+func (t *RawTable) Size() int64 {
+	return t.f.size
+}
+
+// Name returns the path of the file, as used in errors.
+func (t *RawTable) Name() string {
+	return t.f.Name()
+}
+
+// Close closes the file.
+func (t *RawTable) Close() error {
+	return t.f.Close()
+}
+
+// WKT returns the coordinate system of table name, taken from its raster or
+// shape field, or "" if it has none or cannot be read.
+func (db *Geodatabase) WKT(name string) string {
+	bt, err := db.OpenTable(name)
+	if err != nil {
+		return ""
+	}
+	defer bt.Close()
+	for _, fld := range bt.Fields {
+		if fld.Type == 9 && fld.RasterFields.WKT != "" {
+			return fld.RasterFields.WKT
+		}
+		if fld.Type == 7 {
+			return fld.Shp.WKT
+		}
+	}
+	return ""
+}
@@ -0,0 +1,355 @@
+package gdb
+
+import (
+	"fmt"
+	"math"
+)
+
+// Geometry is a decoded shape: its type code and its parts as x/y pairs. Z
+// and M, when the shape has them, are laid out like Parts; unset M values are
+// NaN.
+type Geometry struct {
+	Type  uint64
+	Parts [][][2]float64
+	Z     [][]float64
+	M     [][]float64
+}
+
+// IsPolygonType reports whether shape type geomType is a polygon.
+func IsPolygonType(geomType uint64) bool {
+	switch geomType & 0xFF {
+	case 5, 15, 19, 25, 51:
+		return true
+	}
+	return false
+}
+
+// IsPolylineType reports whether shape type geomType is a polyline.
+func IsPolylineType(geomType uint64) bool {
+	switch geomType & 0xFF {
+	case 3, 10, 13, 23, 50:
+		return true
+	}
+	return false
+}
+
+// IsPointType reports whether shape type geomType is a single point.
+func IsPointType(geomType uint64) bool {
+	switch geomType & 0xFF {
+	case 1, 9, 11, 21:
+		return true
+	}
+	return false
+}
+
+// GeometryHasZ and GeometryHasM tell from the shape type whether a blob
+// carries Z and M values. The general types (50 and up) say so in flag bits.
+func GeometryHasZ(geomType uint64) bool {
+	switch geomType & 0xFF {
+	case 9, 11, 10, 13, 15, 18, 19, 20:
+		return true
+	case 50, 51, 52, 53, 54:
+		return geomType&0x80000000 != 0
+	}
+	return false
+}
+
+func GeometryHasM(geomType uint64) bool {
+	switch geomType & 0xFF {
+	case 11, 21, 13, 23, 15, 25, 18, 28:
+		return true
+	case 50, 51, 52, 53, 54:
+		return geomType&0x40000000 != 0
+	}
+	return false
+}
+
+// IsMultiPointType reports whether shape type geomType is a multipoint.
+func IsMultiPointType(geomType uint64) bool {
+	switch geomType & 0xFF {
+	case 8, 18, 20, 28:
+		return true
+	}
+	return false
+}
+
+// blobReader reads the varints of a geometry blob, remembering the first
+// overrun instead of panicking so a damaged shape can be reported.
+type blobReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *blobReader) varUint() uint64 {
+	var ret uint64
+	for shift := uint(0); ; shift += 7 {
+		if r.pos >= len(r.b) || shift > 63 {
+			if r.err == nil {
+				r.err = fmt.Errorf("geometry blob truncated at byte %d", r.pos)
+			}
+			return 0
+		}
+		b := r.b[r.pos]
+		r.pos++
+		ret |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return ret
+		}
+	}
+}
+
+// varInt reads a signed varint: the first byte carries 6 bits of value and
+// the sign, the following ones 7 bits each.
+func (r *blobReader) varInt() int64 {
+	if r.pos >= len(r.b) {
+		if r.err == nil {
+			r.err = fmt.Errorf("geometry blob truncated at byte %d", r.pos)
+		}
+		return 0
+	}
+	b := r.b[r.pos]
+	r.pos++
+	ret := int64(b & 0x3F)
+	sign := int64(1)
+	if b&0x40 != 0 {
+		sign = -1
+	}
+	for shift := uint(6); b&0x80 != 0; shift += 7 {
+		if r.pos >= len(r.b) || shift > 63 {
+			if r.err == nil {
+				r.err = fmt.Errorf("geometry blob truncated at byte %d", r.pos)
+			}
+			return 0
+		}
+		b = r.b[r.pos]
+		r.pos++
+		ret |= int64(b&0x7F) << shift
+	}
+	return sign * ret
+}
+
+// readGeometry decodes the shape blob of the current row, leaving the file
+// at the end of the blob.
+func readGeometry(f *gdbFile, shp *Shape) Geometry {
+	geomLen := readVarUint(f)
+	blob := readBytes(f, int(geomLen))
+	if f.Err() != nil {
+		return Geometry{}
+	}
+	g, err := decodeGeometry(blob, shp)
+	if err != nil {
+		f.fail(err)
+	}
+	return g
+}
+
+// decodeGeometry decodes a shape blob: the geometry type, then for points the
+// coordinates, and for other shapes the point and part counts, the bounding
+// box, the part sizes and the delta encoded coordinates, all as varints scaled
+// by the shape field's origin and scale, followed by the Z and M arrays when
+// the type has them. Shapes of unknown types and empty points come back
+// without parts.
+func decodeGeometry(blob []byte, shp *Shape) (Geometry, error) {
+	r := &blobReader{b: blob}
+	g := Geometry{Type: r.varUint()}
+
+	if IsPointType(g.Type) {
+		x := r.varUint()
+		y := r.varUint()
+		var z, m uint64
+		if GeometryHasZ(g.Type) {
+			z = r.varUint()
+		}
+		if GeometryHasM(g.Type) {
+			m = r.varUint()
+		}
+		if r.err != nil || x == 0 {
+			// A zero stands for NaN, the empty point.
+			return g, r.err
+		}
+		g.Parts = [][][2]float64{{{float64(x-1)/shp.XYScale + shp.XOrig, float64(y-1)/shp.XYScale + shp.YOrig}}}
+		if GeometryHasZ(g.Type) {
+			g.Z = [][]float64{{float64(z-1)/shp.ZScale + shp.ZOrig}}
+		}
+		if GeometryHasM(g.Type) {
+			g.M = [][]float64{{math.NaN()}}
+			if m != 0 {
+				g.M[0][0] = float64(m-1)/shp.MScale + shp.MOrig
+			}
+		}
+		return g, nil
+	}
+	if !IsPolygonType(g.Type) && !IsPolylineType(g.Type) && !IsMultiPointType(g.Type) {
+		return g, r.err
+	}
+
+	nPoints := int(r.varUint())
+	if nPoints == 0 || r.err != nil {
+		return g, r.err
+	}
+	nParts := 1
+	if !IsMultiPointType(g.Type) {
+		nParts = int(r.varUint())
+		if g.Type&0x20000000 != 0 {
+			r.varUint() // nCurves; the curve segments follow the points
+		}
+	}
+	// Every point takes at least two bytes, which bounds the counts before
+	// anything is allocated.
+	if nPoints < 0 || nPoints > len(blob)/2 || nParts < 1 || nParts > nPoints {
+		return g, fmt.Errorf("geometry blob claims %d points in %d parts in %d bytes", nPoints, nParts, len(blob))
+	}
+
+	// Bounding box, already known from the points themselves.
+	for i := 0; i < 4; i++ {
+		r.varUint()
+	}
+
+	partPoints := make([]int, nParts)
+	acc := 0
+	for i := 0; i < nParts-1; i++ {
+		partPoints[i] = int(r.varUint())
+		acc += partPoints[i]
+		if partPoints[i] < 0 || acc > nPoints {
+			return g, fmt.Errorf("geometry parts hold more than its %d points", nPoints)
+		}
+	}
+	partPoints[nParts-1] = nPoints - acc
+
+	var dx, dy int64
+	for _, n := range partPoints {
+		part := make([][2]float64, n)
+		for i := range part {
+			dx += r.varInt()
+			dy += r.varInt()
+			part[i] = [2]float64{float64(dx)/shp.XYScale + shp.XOrig, float64(dy)/shp.XYScale + shp.YOrig}
+		}
+		g.Parts = append(g.Parts, part)
+	}
+
+	// Z and M follow the x/y pairs as separate arrays, delta encoded across
+	// all parts like them.
+	if GeometryHasZ(g.Type) {
+		var dz int64
+		for _, n := range partPoints {
+			zs := make([]float64, n)
+			for i := range zs {
+				dz += r.varInt()
+				zs[i] = float64(dz)/shp.ZScale + shp.ZOrig
+			}
+			g.Z = append(g.Z, zs)
+		}
+	}
+	if GeometryHasM(g.Type) {
+		// A lone 0x42 in place of the array means no point has an M.
+		noM := r.pos < len(blob) && blob[r.pos] == 0x42
+		if noM {
+			r.pos++
+		}
+		var dm int64
+		for _, n := range partPoints {
+			ms := make([]float64, n)
+			for i := range ms {
+				if noM {
+					ms[i] = math.NaN()
+					continue
+				}
+				dm += r.varInt()
+				ms[i] = float64(dm)/shp.MScale + shp.MOrig
+			}
+			g.M = append(g.M, ms)
+		}
+	}
+	if r.err != nil {
+		return Geometry{Type: g.Type}, r.err
+	}
+
+	return g, nil
+}
+
+// ringIsClockwise uses the shoelace formula on a closed ring.
+func ringIsClockwise(ring [][2]float64) bool {
+	area := 0.0
+	for i := 1; i < len(ring); i++ {
+		area += (ring[i][0] - ring[i-1][0]) * (ring[i][1] + ring[i-1][1])
+	}
+	return area > 0
+}
+
+// Position returns point i of part p as x, y and, if the shape has it, z.
+func (g *Geometry) Position(p int, i int) []float64 {
+	pt := g.Parts[p][i]
+	if g.Z != nil {
+		return []float64{pt[0], pt[1], g.Z[p][i]}
+	}
+	return []float64{pt[0], pt[1]}
+}
+
+// Positions returns the points of part p like Position, reversed if asked.
+func (g *Geometry) Positions(p int, reverse bool) [][]float64 {
+	n := len(g.Parts[p])
+	pos := make([][]float64, n)
+	for i := range pos {
+		if reverse {
+			pos[n-1-i] = g.Position(p, i)
+		} else {
+			pos[i] = g.Position(p, i)
+		}
+	}
+	return pos
+}
+
+// PolygonRings groups the rings of a polygon shape into polygons, as indexes
+// into Parts. Esri outer rings are clockwise and start a new polygon; the
+// counter-clockwise rings after them are its holes.
+func PolygonRings(g Geometry) [][]int {
+	polygons := make([][]int, 0)
+	for p, ring := range g.Parts {
+		if ringIsClockwise(ring) || len(polygons) == 0 {
+			polygons = append(polygons, []int{p})
+			continue
+		}
+		last := len(polygons) - 1
+		polygons[last] = append(polygons[last], p)
+	}
+	return polygons
+}
+
+// GeometryTypeName names the layer geometry type of a table header.
+func GeometryTypeName(layerGeomType uint8) string {
+	switch layerGeomType {
+	case 1:
+		return "point"
+	case 2:
+		return "multipoint"
+	case 3:
+		return "polyline"
+	case 4:
+		return "polygon"
+	case 9:
+		return "multipatch"
+	default:
+		return "none"
+	}
+}
+
+// Bounds returns the envelope of the points of the shape, infinite and
+// inverted if it has none.
+func (g Geometry) Bounds() (float64, float64, float64, float64) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, part := range g.Parts {
+		for _, pt := range part {
+			minX = math.Min(minX, pt[0])
+			minY = math.Min(minY, pt[1])
+			maxX = math.Max(maxX, pt[0])
+			maxY = math.Max(maxY, pt[1])
+		}
+	}
+	return minX, minY, maxX, maxY
+}
+
+func (g Geometry) String() string {
+	return fmt.Sprintf("geometry type %d with %d parts", g.Type&0xFF, len(g.Parts))
+}
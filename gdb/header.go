@@ -0,0 +1,118 @@
+package gdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// A .gdbtable starts with a header of tableHeaderLen bytes: a magic number,
+// 3 for the tables of FileGDB 9.x and 10.x, then row counts and sizes, the
+// size of the file and, at 32, the offset of the field descriptions as an
+// int64. The field descriptions start with their size and a version,
+// tableVersion9 for 9.x and tableVersion10 for 10.x. The two lay the table
+// out alike, but 9.x stores the values of string fields as UTF-16LE where
+// 10.x stores UTF-8. A .gdbtablx starts with the same magic number. The
+// tables ArcGIS Pro 3.2 writes with 64-bit object IDs have magic number
+// table64Magic and version table64Version, and their .gdbtablx starts with
+// table64Magic too; both are otherwise laid out as in 10.x.
+const (
+	tableHeaderLen = 40
+	tableMagic     = 3
+	table64Magic   = 4
+	tableVersion9  = 3
+	tableVersion10 = 4
+	table64Version = 6
+)
+
+// Errors telling why the header of a .gdbtable or .gdbtablx cannot be read,
+// for errors.Is: the file is not a table at all, it is a table in a version
+// that cannot be read, or it is a table whose header is damaged.
+var (
+	ErrNotTable           = errors.New("not a FileGDB table")
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	ErrCorruptHeader      = errors.New("corrupted header")
+)
+
+// ErrNotAGeodatabase tells that what was opened as a geodatabase has no
+// master table, a00000001.gdbtable, to list its tables: it is not a .gdb
+// directory, or not one of a file geodatabase.
+var ErrNotAGeodatabase = errors.New("not a file geodatabase")
+
+// ErrPartialRecovery is not returned by the readers, which skip what they
+// cannot read and carry on. It is for their callers to tell, once done, that
+// some rows or blocks were left out or filled in: a recovery that finished
+// but is not whole.
+var ErrPartialRecovery = errors.New("partial recovery")
+
+// checkTableHeader checks the header of .gdbtable f and the start of its
+// field descriptions before anything else is read, and returns the offset
+// of the field descriptions and the version of the table.
+func checkTableHeader(f *gdbFile) (int64, int32, error) {
+	if f.size < tableHeaderLen {
+		return 0, 0, fmt.Errorf("%s: %w: %d bytes, too short for a header", f.Name(), ErrCorruptHeader, f.size)
+	}
+	f.Seek(0, 0)
+	b := f.next(tableHeaderLen)
+	if err := f.Err(); err != nil {
+		return 0, 0, err
+	}
+	magic := binary.LittleEndian.Uint32(b)
+	fieldsOff := int64(binary.LittleEndian.Uint64(b[32:]))
+
+	// Field descriptions that fit in the file say a table is there even if
+	// its magic number is damaged.
+	var fieldsLen int64
+	var version int32
+	fieldsFit := fieldsOff >= tableHeaderLen && fieldsOff <= f.size-8
+	if fieldsFit {
+		f.Seek(fieldsOff, 0)
+		fieldsLen = int64(readU32(f))
+		version = readInt32(f)
+		fieldsFit = f.Err() == nil && fieldsLen <= f.size-fieldsOff-4
+	}
+	f.clearErr()
+
+	switch {
+	case allZero(b):
+		return 0, 0, fmt.Errorf("%s: %w: the header is zeroed", f.Name(), ErrCorruptHeader)
+	case magic != tableMagic && magic != table64Magic && !fieldsFit:
+		return 0, 0, fmt.Errorf("%s: %w: magic number %d and no field descriptions", f.Name(), ErrNotTable, magic)
+	case magic != tableMagic && magic != table64Magic:
+		return 0, 0, fmt.Errorf("%s: %w: magic number %d, not %d or %d", f.Name(), ErrCorruptHeader, magic, tableMagic, table64Magic)
+	case !fieldsFit:
+		return 0, 0, fmt.Errorf("%s: %w: field descriptions at offset %d do not fit in its %d bytes", f.Name(), ErrCorruptHeader, fieldsOff, f.size)
+	case magic == tableMagic && (version == tableVersion9 || version == tableVersion10),
+		magic == table64Magic && version == table64Version:
+		return fieldsOff, version, nil
+	case magic == table64Magic || version == table64Version:
+		return 0, 0, fmt.Errorf("%s: %w: magic number %d with version %d", f.Name(), ErrCorruptHeader, magic, version)
+	case version > 0 && version < 16:
+		return 0, 0, fmt.Errorf("%s: %w: version %d, not 3 (9.x), 4 (10.x) or 6 (64-bit object IDs)", f.Name(), ErrUnsupportedVersion, version)
+	}
+	return 0, 0, fmt.Errorf("%s: %w: version %d", f.Name(), ErrCorruptHeader, version)
+}
+
+// checkTablxMagic checks the magic number at the start of .gdbtablx f, that
+// of the tables of 9.x and 10.x or that of the tables with 64-bit object
+// IDs, whose .gdbtablx is otherwise laid out alike.
+func checkTablxMagic(f *gdbFile) error {
+	f.Seek(0, 0)
+	magic := readU32(f)
+	switch {
+	case f.Err() != nil:
+		return f.Err()
+	case magic != tableMagic && magic != table64Magic:
+		return fmt.Errorf("%s: %w: magic number %d, not %d or %d", f.Name(), ErrCorruptHeader, magic, tableMagic, table64Magic)
+	}
+	return nil
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
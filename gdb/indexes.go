@@ -0,0 +1,91 @@
+package gdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// maxIndexName bounds the names and expressions of .gdbindexes entries, in
+// UTF-16 units, so that a damaged count is not taken for a huge string.
+const maxIndexName = 1024
+
+// Index is an index of a table as its .gdbindexes describes it.
+type Index struct {
+	Name       string
+	Kind       string   // objectid, spatial, attribute, or unknown
+	Expression string   // as stored, such as LOWER(Name)
+	Columns    []string // the fields it is on
+}
+
+// A .gdbindexes starts with the number of indexes. Each has its name, as a
+// uint32 count of UTF-16LE units and the units, a uint16, a uint32 kind, a
+// uint16 and a uint32 nobody has explained, its expression like its name,
+// and a closing uint16.
+var indexKinds = map[uint32]string{2: "attribute", 4: "spatial", 16: "objectid"}
+
+// ReadIndexes reads the definitions in the .gdbindexes of table tableName,
+// or returns none if the table has no such file. The indexes read before
+// damage are returned with the error.
+func ReadIndexes(ctx context.Context, gdbFilePath string, tableName string) ([]Index, error) {
+	f, err := openGDBFile(sourceFS(ctx, gdbFilePath), gdbFilePath, tableName+".gdbindexes")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	n := readU32(f)
+	if err := f.Err(); err != nil {
+		return nil, err
+	}
+	indexes := make([]Index, 0)
+	for i := uint32(0); i < n; i++ {
+		idx := Index{}
+		idx.Name = readIndexString(f)
+		f.Seek(2, 1)
+		kind := readU32(f)
+		f.Seek(6, 1)
+		idx.Expression = readIndexString(f)
+		f.Seek(2, 1)
+		if err := f.Err(); err != nil {
+			return indexes, fmt.Errorf("index %d of %d: %w", i+1, n, err)
+		}
+		idx.Kind = indexKinds[kind]
+		if idx.Kind == "" {
+			idx.Kind = "unknown"
+		}
+		idx.Columns = indexColumns(idx.Expression)
+		indexes = append(indexes, idx)
+	}
+	return indexes, nil
+}
+
+// readIndexString reads a name or expression of a .gdbindexes.
+func readIndexString(f *gdbFile) string {
+	n := readU32(f)
+	if n > maxIndexName {
+		f.fail(fmt.Errorf("%s: string of %d characters at offset %d", f.Name(), n, f.offset()-4))
+		return ""
+	}
+	return utf16String(readBytes(f, 2*int(n)))
+}
+
+// indexColumns returns the fields of index expression expr: the names it
+// lists, separated by commas, with any function such as LOWER taken off.
+func indexColumns(expr string) []string {
+	if open := strings.IndexByte(expr, '('); open >= 0 && strings.HasSuffix(expr, ")") {
+		expr = expr[open+1 : len(expr)-1]
+	}
+	columns := make([]string, 0)
+	for _, c := range strings.Split(expr, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
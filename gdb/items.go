@@ -0,0 +1,98 @@
+package gdb
+
+import (
+	"io"
+	"strings"
+)
+
+// itemsTable is the catalog of the datasets of a geodatabase from 10.x on,
+// with their definitions and the ArcGIS metadata written for them.
+const itemsTable = "GDB_Items"
+
+// itemTypes names the types of items of GDB_Items, which it gives as the
+// UUIDs of GDB_ItemTypes.
+var itemTypes = map[string]string{
+	"{F3783E6F-65CA-4514-8315-CE3985DAD3B1}": "Folder",
+	"{C673FE0F-7280-404F-8532-20755DD8FC06}": "Workspace",
+	"{74737149-DCB5-4257-8904-B9724E32A530}": "Feature Dataset",
+	"{70737809-852C-4A03-9E22-2CECEA5B9BFA}": "Feature Class",
+	"{CD06BC3B-789D-4C51-AAFA-A467912B8965}": "Table",
+	"{5ED667A3-9CA9-44A2-8029-D95BF23704B9}": "Raster Dataset",
+	"{B606A7E1-FA5B-439C-849C-6E9C2481537B}": "Relationship Class",
+	"{C29DA988-8C3E-45F7-8B5C-18E51EE7BEB4}": "Range Domain",
+	"{8C368B12-A12E-4C7E-9638-C9C64E69E98F}": "Coded Value Domain",
+}
+
+// Item is an entry of GDB_Items: a dataset, domain or the workspace itself.
+type Item struct {
+	Name          string // "" for the workspace
+	Path          string // as \FeatureDataset\Name
+	Type          string // as itemTypes names it, or the UUID of its type
+	Documentation string // its ArcGIS metadata XML, "" if it has none
+}
+
+// Items lists the items of GDB_Items, or none for a geodatabase without it,
+// those of 9.x among them. Rows that cannot be read are reported as the
+// context of the geodatabase asks and left out.
+func (db *Geodatabase) Items() ([]Item, error) {
+	if db.master.TableID(itemsTable) == 0 {
+		return nil, nil
+	}
+	bt, err := db.OpenTable(itemsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+	items := make([]Item, 0)
+	rows := bt.Rows()
+	for row := range rows.All() {
+		var it Item
+		it.Name, _ = valueOf(row, "Name").(string)
+		it.Path, _ = valueOf(row, "Path").(string)
+		if g, ok := valueOf(row, "Type").(GUID); ok {
+			it.Type = g.String()
+			if name, ok := itemTypes[it.Type]; ok {
+				it.Type = name
+			}
+		}
+		it.Documentation, _ = valueOf(row, "Documentation").(string)
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// Documentation returns the ArcGIS metadata of the item called name, "" if
+// it has none or the geodatabase has no GDB_Items. Unlike Items it passes
+// over the rows it cannot read without a word, for looking an item up to
+// leave reporting them to the reading of GDB_Items itself.
+func (db *Geodatabase) Documentation(name string) (string, error) {
+	if db.master.TableID(itemsTable) == 0 {
+		return "", nil
+	}
+	bt, err := db.OpenTable(itemsTable)
+	if err != nil {
+		return "", err
+	}
+	defer bt.Close()
+	rows := bt.Rows()
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			return "", nil
+		}
+		if err != nil {
+			continue
+		}
+		if n, _ := valueOf(row, "Name").(string); strings.EqualFold(n, name) {
+			doc, _ := valueOf(row, "Documentation").(string)
+			return doc, nil
+		}
+	}
+}
+
+// valueOf returns the value of the field called name of row, nil if it has
+// no such field or it is null.
+func valueOf(row *Row, name string) interface{} {
+	v, _ := row.Value(name)
+	return v
+}
@@ -0,0 +1,178 @@
+package gdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+)
+
+const masterTableFileName string = "a00000001"
+
+// RasterInfo is a raster dataset listed in the master table.
+type RasterInfo struct {
+	Name string
+	ID   int
+}
+
+// TableInfo is a table listed in the master table. ID names its files, see
+// TableFileName.
+type TableInfo struct {
+	Name       string
+	ID         int
+	FileFormat int32
+}
+
+// MasterTable is the catalog of a geodatabase, read from table a00000001.
+type MasterTable struct {
+	BaseTab BaseTable
+	Rasters []RasterInfo
+	Tables  []TableInfo
+}
+
+const rasterTablePrefix string = "fras_ras_"
+
+// TableFileName names the files of table id, without their extension.
+func TableFileName(id int) string {
+	return fmt.Sprintf("a%08x", id)
+}
+
+// TableExists reports whether the .gdbtable file of table id is in the
+// geodatabase; the master table also lists tables that were never written.
+func TableExists(gdbFilePath string, id int) bool {
+	_, err := fs.Stat(sourceFS(context.Background(), gdbFilePath), TableFileName(id)+".gdbtable")
+	return err == nil
+}
+
+// IsRaster reports whether name is a raster dataset.
+func (mt *MasterTable) IsRaster(name string) bool {
+	for _, r := range mt.Rasters {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TableID returns the id of the table called name, or 0 if there is none.
+func (mt *MasterTable) TableID(name string) int {
+	for _, t := range mt.Tables {
+		if t.Name == name {
+			return t.ID
+		}
+	}
+	return 0
+}
+
+// NewMasterTable reads the list of tables of the geodatabase. Rows of the
+// master table that cannot be read are reported on stderr and left out, so
+// the rest of the geodatabase stays reachable. The table stays open in
+// mt.BaseTab. A directory without a master table, or whose master table is
+// not a table at all, fails with ErrNotAGeodatabase.
+func NewMasterTable(gdbFilePath string) (MasterTable, error) {
+	ctx := context.Background()
+	return newMasterTable(ctx, sourceFS(ctx, gdbFilePath), gdbFilePath)
+}
+
+func newMasterTable(ctx context.Context, fsys fs.FS, gdbFilePath string) (MasterTable, error) {
+	var mt MasterTable
+	var err error
+	// The rows deleted from the catalog list tables whose files are gone,
+	// so they are never read back.
+	if indexModeOf(ctx) == indexNone {
+		ctx = WithRebuiltIndex(ctx)
+	}
+	ctx = context.WithValue(ctx, undeleteKey{}, false)
+	mt.BaseTab, err = newBaseTable(ctx, fsys, gdbFilePath, masterTableFileName)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrNotTable) {
+		return mt, fmt.Errorf("%s: %w: %w", gdbFilePath, ErrNotAGeodatabase, err)
+	}
+	if err != nil {
+		return mt, err
+	}
+	bt := &mt.BaseTab
+
+	for fid := 0; fid < int(bt.NFeaturesX); fid++ {
+		ok, err := bt.getRow(fid)
+		if err != nil {
+			slog.Warn("skipping master table row", "err", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		info := TableInfo{ID: fid + 1}
+		var iFieldForFlagTest int
+		for i := range bt.Fields {
+			fld := &bt.Fields[i]
+			if bt.skipField(fld, &iFieldForFlagTest) {
+				continue
+			}
+
+			switch {
+			case fld.Type == 4 && fld.Name == "Name":
+				info.Name = bt.readString()
+			case fld.Type == 1 && fld.Name == "FileFormat":
+				info.FileFormat = readInt32(bt.gdbTable)
+			default:
+				bt.skipValue(fld)
+			}
+		}
+		if err := bt.rowErr(fid); err != nil {
+			slog.Warn("skipping master table row", "err", err)
+			continue
+		}
+
+		mt.Tables = append(mt.Tables, info)
+	}
+	if bt.ReconstructedOIDs() {
+		mt.alignIDs(fsys)
+	}
+	for _, info := range mt.Tables {
+		if strings.HasPrefix(info.Name, rasterTablePrefix) {
+			mt.Rasters = append(mt.Rasters, RasterInfo{strings.TrimPrefix(info.Name, rasterTablePrefix), info.ID})
+		}
+	}
+
+	return mt, nil
+}
+
+// systemTables is the number of tables every geodatabase starts with, from
+// GDB_SystemCatalog, which is this one, to GDB_ReplicaLog.
+const systemTables = 8
+
+// maxIDGap is how far past the ID a rebuilt index gives a table alignIDs
+// looks for its files.
+const maxIDGap = 256
+
+// alignIDs corrects the IDs of the tables read through a rebuilt index of the
+// master table. They are off by one for each deleted row that left no free
+// block behind, so a table past the system tables whose files are not there
+// takes the next ID whose files are, and the tables after it shift with it.
+func (mt *MasterTable) alignIDs(fsys fs.FS) {
+	shift := 0
+	for i := range mt.Tables {
+		t := &mt.Tables[i]
+		id := t.ID + shift
+		if id > systemTables && !tableInFS(fsys, id) {
+			for next := id + 1; next <= id+maxIDGap; next++ {
+				if tableInFS(fsys, next) {
+					slog.Warn("guessed table id", "table", t.Name, "id", next, "rebuilt", id)
+					id = next
+					break
+				}
+			}
+		}
+		shift = id - t.ID
+		t.ID = id
+	}
+}
+
+// tableInFS reports whether the .gdbtable file of table id is in fsys.
+func tableInFS(fsys fs.FS, id int) bool {
+	_, err := fs.Stat(fsys, TableFileName(id)+".gdbtable")
+	return err == nil
+}
@@ -0,0 +1,31 @@
+package gdb
+
+import (
+	"io"
+	"os"
+)
+
+// mappedFile is a local file mapped into memory by mapFile. Reads copy out of
+// the mapping, so the bytes a table returns stay valid once it is closed.
+//
+// Random access into a large block table then costs no system call per
+// read, which is most of the time spent on a table already in the page
+// cache. A file truncated while mapped faults on the next read of what was
+// cut off; geodatabases are not written while they are being read.
+type mappedFile struct {
+	data []byte
+}
+
+func (m *mappedFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
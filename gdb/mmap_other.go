@@ -0,0 +1,15 @@
+//go:build !unix
+
+package gdb
+
+import "os"
+
+// mapFile never maps files on systems without mmap; they are read with
+// system calls instead.
+func mapFile(f *os.File, size int64) (*mappedFile, bool) {
+	return nil, false
+}
+
+func (m *mappedFile) Close() error {
+	return nil
+}
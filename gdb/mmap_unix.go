@@ -0,0 +1,35 @@
+//go:build unix
+
+package gdb
+
+import (
+	"math"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the local file f of size bytes into memory and closes it, so
+// that a read from the file is a copy out of the page cache rather than a
+// system call. It returns false, leaving f open, if the file cannot be
+// mapped.
+func mapFile(f *os.File, size int64) (*mappedFile, bool) {
+	if size <= 0 || size > math.MaxInt {
+		return nil, false
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, false
+	}
+	f.Close()
+	return &mappedFile{data}, true
+}
+
+// Close unmaps the file.
+func (m *mappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(m.data)
+	m.data = nil
+	return err
+}
@@ -0,0 +1,107 @@
+package gdb
+
+import (
+	"context"
+	"fmt"
+)
+
+// OnError is what reading does with a row of a table, or a block of a
+// raster, that cannot be read or decoded. It applies to the rows read
+// through RowIterator.All, which every export reads, and to the blocks of
+// the rasters read; diagnosis reads everything regardless.
+type OnError int
+
+const (
+	SkipBad    OnError = iota // leave it out, with a warning
+	FillBad                   // keep its place: a row of nulls, a block of nodata
+	AbortOnBad                // stop with its error
+)
+
+// ParseOnError reads an OnError written as skip, fill or abort.
+func ParseOnError(s string) (OnError, error) {
+	switch s {
+	case "skip":
+		return SkipBad, nil
+	case "fill":
+		return FillBad, nil
+	case "abort":
+		return AbortOnBad, nil
+	}
+	return SkipBad, fmt.Errorf("%q is not skip, fill or abort", s)
+}
+
+func (p OnError) String() string {
+	switch p {
+	case FillBad:
+		return "fill"
+	case AbortOnBad:
+		return "abort"
+	}
+	return "skip"
+}
+
+// RowError is a row of a table that could not be read or decoded.
+type RowError struct {
+	Table string // the .gdbtable
+	FID   int    // 1-based object id
+	Err   error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.FID, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// onErrorKey, rowReportKey and rowCountKey are the keys of the context
+// values holding the OnError, the function bad rows are reported to and the
+// one rows yielded are counted by.
+type (
+	onErrorKey   struct{}
+	rowReportKey struct{}
+	rowCountKey  struct{}
+)
+
+// WithOnError returns a copy of ctx under which the rows and blocks that
+// cannot be read or decoded are handled as p says. Without it they are
+// skipped.
+func WithOnError(ctx context.Context, p OnError) context.Context {
+	return context.WithValue(ctx, onErrorKey{}, p)
+}
+
+// OnErrorOf returns how ctx asks for rows and blocks that cannot be read or
+// decoded to be handled.
+func OnErrorOf(ctx context.Context) OnError {
+	p, _ := ctx.Value(onErrorKey{}).(OnError)
+	return p
+}
+
+// WithRowReport returns a copy of ctx under which report is called with
+// every row RowIterator.All meets that cannot be read or decoded, before it
+// is handled as OnErrorOf says, so that what was lost can be listed.
+func WithRowReport(ctx context.Context, report func(*RowError)) context.Context {
+	return context.WithValue(ctx, rowReportKey{}, report)
+}
+
+// reportRow hands e to the function ctx reports bad rows to, if any.
+func reportRow(ctx context.Context, e *RowError) {
+	if report, _ := ctx.Value(rowReportKey{}).(func(*RowError)); report != nil {
+		report(e)
+	}
+}
+
+// WithRowCount returns a copy of ctx under which count is called with the
+// .gdbtable of every row RowIterator.All yields, rows filled with nulls
+// included, so that what an export wrote can be told.
+func WithRowCount(ctx context.Context, count func(table string)) context.Context {
+	return context.WithValue(ctx, rowCountKey{}, count)
+}
+
+// countRow hands table to the function ctx counts rows yielded by, if any.
+func countRow(ctx context.Context, table string) {
+	if count, _ := ctx.Value(rowCountKey{}).(func(string)); count != nil {
+		count(table)
+	}
+}
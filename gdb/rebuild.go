@@ -0,0 +1,136 @@
+package gdb
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+)
+
+// The .gdbtablx of a table only holds the offset of each row in the
+// .gdbtable, so a table whose .gdbtablx is missing or damaged usually still
+// has all its rows. They are found again by walking the .gdbtable: after the
+// field descriptions, it is a run of rows, each its size as an int32 and that
+// many bytes, and of free space left by rows deleted or rewritten, whose size
+// is negated. A row is taken only if its fields take exactly its size; where
+// the walk meets anything else, it moves on a byte at a time until it finds
+// a row again.
+//
+// The .gdbtablx also gives each row its object ID. A rebuilt index numbers
+// the rows in the order they are found instead, counting each free block as a
+// deleted row. A deleted row leaves its block in place, so this gives the IDs
+// back; a row rewritten elsewhere, or a damaged stretch, shifts the IDs that
+// follow, which then no longer match the ones the geodatabase gave.
+//
+// Without its .gdbtablx at all, a table is read as the walk finds it: the
+// free blocks whose fields still take exactly their size are read as rows
+// too, so that rows deleted, and old versions of rows rewritten, come back.
+
+// indexMode is how the tables opened under a context find their rows.
+type indexMode int
+
+const (
+	indexTablx   indexMode = iota // through their .gdbtablx, rebuilt if missing or damaged
+	indexRebuilt                  // through an index rebuilt from the .gdbtable
+	indexNone                     // every row the walk of the .gdbtable finds
+)
+
+// indexKey is the key of the context value holding the indexMode.
+type indexKey struct{}
+
+// WithRebuiltIndex returns a copy of ctx under which tables are opened
+// without their .gdbtablx, their rows found by scanning the .gdbtable, as
+// they are anyway when the .gdbtablx is missing or damaged. It is for
+// geodatabases whose .gdbtablx files read fine but point at the wrong rows.
+func WithRebuiltIndex(ctx context.Context) context.Context {
+	return context.WithValue(ctx, indexKey{}, indexRebuilt)
+}
+
+// WithoutTablx returns a copy of ctx under which tables are read by walking
+// their .gdbtable from front to back, their .gdbtablx never opened, and
+// every row found is read, deleted rows whose data is still whole included.
+// It is for geodatabases whose .gdbtablx files lie about what the tables
+// hold. Rows come in the order of the .gdbtable, numbered as a rebuilt index
+// numbers them, and a rewritten row can come twice: its old version, then
+// its new one. The master table still leaves deleted rows out, the tables
+// they listed being gone.
+func WithoutTablx(ctx context.Context) context.Context {
+	return context.WithValue(ctx, indexKey{}, indexNone)
+}
+
+// indexModeOf returns how ctx asks for the rows of tables to be found.
+func indexModeOf(ctx context.Context) indexMode {
+	m, _ := ctx.Value(indexKey{}).(indexMode)
+	return m
+}
+
+// scanRows finds the rows of the table by walking its .gdbtable from start,
+// the offset past the field descriptions, and returns their offsets, 0 for a
+// deleted row. With deleted, deleted rows whose fields still take exactly
+// the size of their free block are returned as rows. It returns what it has
+// found so far once the context of the table is done.
+func (bt *BaseTable) scanRows(start int64, deleted bool) []int64 {
+	f := bt.gdbTable
+	var offsets []int64
+	var damaged, from int64
+	for off, n := start, 0; off+4 <= f.size; n++ {
+		if n%4096 == 0 && bt.Context().Err() != nil {
+			break
+		}
+		f.clearErr()
+		f.Seek(off, 0)
+		size := int64(int32(binary.LittleEndian.Uint32(f.next(4))))
+		switch {
+		case size > 0 && bt.rowFits(off, size):
+			if damaged > 0 {
+				slog.Warn("skipped damaged bytes", "table", bt.GdbTablePath, "offset", from, "bytes", damaged)
+				damaged = 0
+			}
+			offsets = append(offsets, off)
+			off += 4 + size
+		case size < 0 && damaged == 0 && off+4-size <= f.size:
+			if deleted && bt.rowFits(off, -size) {
+				offsets = append(offsets, off)
+			} else {
+				offsets = append(offsets, 0)
+			}
+			off += 4 - size
+		default:
+			if damaged == 0 {
+				from = off
+			}
+			damaged++
+			off++
+		}
+	}
+	if damaged > 0 {
+		slog.Warn("skipped damaged bytes", "table", bt.GdbTablePath, "offset", from, "bytes", damaged)
+	}
+	f.clearErr()
+	for len(offsets) > 0 && offsets[len(offsets)-1] == 0 {
+		offsets = offsets[:len(offsets)-1]
+	}
+	return offsets
+}
+
+// rowFits reports whether the fields of a row of size bytes at off take
+// exactly its size.
+func (bt *BaseTable) rowFits(off, size int64) bool {
+	f := bt.gdbTable
+	if off+4+size > f.size {
+		return false
+	}
+	f.Seek(off+4, 0)
+	bt.getFlags(f)
+	var iFieldForFlagTest int
+	for i := range bt.Fields {
+		fld := &bt.Fields[i]
+		if bt.skipField(fld, &iFieldForFlagTest) {
+			continue
+		}
+		bt.skipValue(fld)
+		if f.Err() != nil || f.offset() > off+4+size {
+			return false
+		}
+	}
+	return f.Err() == nil && f.offset() == off+4+size
+}
@@ -0,0 +1,338 @@
+package gdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteFS is a .gdb directory, or the zip archive holding one, behind an
+// http://, https://, s3:// or gs:// URL. Files are read with range requests,
+// so a table costs only the blocks that are actually read.
+//
+// s3:// URLs go to the S3 endpoint of AWS_REGION, or to AWS_ENDPOINT_URL for
+// other S3 compatible stores, and are signed when AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY are set. gs:// URLs go to storage.googleapis.com and
+// carry GOOGLE_OAUTH_ACCESS_TOKEN when it is set. Without credentials only
+// public objects can be read.
+//
+// Requests are made with ctx, so cancelling it aborts a read in progress.
+// Those failing with a 5xx or 429 status, timing out or cut short are made
+// again, up to remoteAttempts times, pausing longer after each.
+type remoteFS struct {
+	base string
+	ctx  context.Context
+}
+
+// remoteBlockSize is how much a range request fetches, and
+// remoteCachedBlocks how many fetched blocks each file keeps. The readers make
+// many small reads close together, which this turns into few requests.
+const (
+	remoteBlockSize    = 1 << 20
+	remoteCachedBlocks = 16
+)
+
+var remoteClient = &http.Client{Timeout: 2 * time.Minute}
+
+// remoteAttempts is how many times a request failing transiently is made,
+// and remoteBackoff the pause after the first attempt, doubled after each.
+const remoteAttempts = 4
+
+var remoteBackoff = 500 * time.Millisecond
+
+// forbiddenError is the error of an object S3 or Cloud Storage refuses with
+// a 403. Both answer so for an object that does not exist when the
+// credentials may not list the bucket, so it is taken for fs.ErrNotExist,
+// letting files that are optional, such as .gdbtablx and .spx, be missing,
+// as well as for fs.ErrPermission.
+type forbiddenError struct{}
+
+func (forbiddenError) Error() string {
+	return "403 Forbidden: missing, or not to be read with these credentials"
+}
+
+func (forbiddenError) Is(target error) bool {
+	return target == fs.ErrNotExist || target == fs.ErrPermission
+}
+
+// statusError is the error of a response of a status the reader does not
+// expect.
+type statusError struct {
+	name   string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return e.name + ": " + e.status }
+
+// transient reports whether a request that failed with err may succeed if
+// made again: one the server answered with a 5xx or 429 status, that timed
+// out, or whose response was cut short.
+func transient(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusTooManyRequests
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isRemote reports whether gdbFilePath is a URL rather than a local path.
+func isRemote(gdbFilePath string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://", "gs://"} {
+		if strings.HasPrefix(gdbFilePath, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r remoteFS) Open(name string) (fs.File, error) {
+	f, err := openRemote(r.ctx, r.base+name)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteMember{io.NewSectionReader(f, 0, f.size), f, remoteInfo{path.Base(name), f.size}}, nil
+}
+
+// remoteFile reads one object at arbitrary offsets, keeping the last
+// remoteCachedBlocks blocks it fetched.
+type remoteFile struct {
+	ctx  context.Context
+	name string // the URL as given, for errors
+	url  string // the http(s) URL requested
+	size int64
+
+	mu      sync.Mutex
+	blocks  map[int64][]byte
+	order   []int64                // cached block numbers, oldest first
+	pending map[int64]*remoteFetch // blocks being fetched, by block number
+}
+
+// remoteFetch is a block being fetched, which the readers that want it
+// while it is wait for rather than fetch it again: done is closed once b
+// or err is set.
+type remoteFetch struct {
+	done chan struct{}
+	b    []byte
+	err  error
+}
+
+// openRemote finds the size of the object at rawURL with a one byte range
+// request. Objects that do not exist fail with fs.ErrNotExist.
+func openRemote(ctx context.Context, rawURL string) (*remoteFile, error) {
+	u, err := httpURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	f := &remoteFile{ctx: ctx, name: rawURL, url: u, blocks: make(map[int64][]byte), pending: make(map[int64]*remoteFetch)}
+	var resp *http.Response
+	err = f.retry(func() (err error) {
+		resp, err = f.get(0, 1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Content-Range: bytes 0-0/<size>
+	_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	f.size, err = strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: no object size in the response to a range request", rawURL)
+	}
+	return f, nil
+}
+
+// httpURL turns s3:// and gs:// URLs into the https URLs of their objects.
+func httpURL(rawURL string) (string, error) {
+	scheme, rest, _ := strings.Cut(rawURL, "://")
+	switch scheme {
+	case "http", "https":
+		return rawURL, nil
+	case "gs":
+		return "https://storage.googleapis.com/" + rest, nil
+	case "s3":
+		if ep := os.Getenv("AWS_ENDPOINT_URL"); ep != "" {
+			return strings.TrimSuffix(ep, "/") + "/" + rest, nil
+		}
+		bucket, key, _ := strings.Cut(rest, "/")
+		return "https://" + bucket + ".s3." + awsRegion() + ".amazonaws.com/" + key, nil
+	}
+	return "", fmt.Errorf("%s: unsupported URL scheme", rawURL)
+}
+
+// get requests n bytes of the object from offset off, once.
+func (f *remoteFile) get(off int64, n int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	switch {
+	case strings.HasPrefix(f.name, "s3://"):
+		signS3(req, time.Now())
+	case strings.HasPrefix(f.name, "gs://"):
+		if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, &fs.PathError{Op: "open", Path: f.name, Err: fs.ErrNotExist}
+	case http.StatusForbidden:
+		if strings.HasPrefix(f.name, "s3://") || strings.HasPrefix(f.name, "gs://") {
+			resp.Body.Close()
+			return nil, &fs.PathError{Op: "open", Path: f.name, Err: forbiddenError{}}
+		}
+	case http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: the server does not support range requests", f.name)
+	}
+	resp.Body.Close()
+	return nil, &statusError{f.name, resp.StatusCode, resp.Status}
+}
+
+// retry calls do, which makes a request, until it succeeds, fails other
+// than transiently or has been called remoteAttempts times, pausing between
+// calls.
+func (f *remoteFile) retry(do func() error) error {
+	wait := remoteBackoff
+	for attempt := 1; ; attempt++ {
+		err := do()
+		if err == nil || attempt == remoteAttempts || !transient(err) {
+			return err
+		}
+		slog.Debug("retrying remote read", "url", f.name, "attempt", attempt, "err", err)
+		select {
+		case <-f.ctx.Done():
+			return f.ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// block returns block i of the object, fetching it unless it is cached or
+// being fetched already, for which it waits. The lock is not held while
+// fetching, so that readers of other blocks, or of cached ones, go on.
+func (f *remoteFile) block(i int64) ([]byte, error) {
+	f.mu.Lock()
+	if b, ok := f.blocks[i]; ok {
+		f.mu.Unlock()
+		return b, nil
+	}
+	if p, ok := f.pending[i]; ok {
+		f.mu.Unlock()
+		<-p.done
+		return p.b, p.err
+	}
+	p := &remoteFetch{done: make(chan struct{})}
+	f.pending[i] = p
+	f.mu.Unlock()
+
+	p.b, p.err = f.fetch(i)
+
+	f.mu.Lock()
+	delete(f.pending, i)
+	if _, ok := f.blocks[i]; p.err == nil && !ok {
+		if len(f.order) == remoteCachedBlocks {
+			delete(f.blocks, f.order[0])
+			f.order = f.order[1:]
+		}
+		f.blocks[i] = p.b
+		f.order = append(f.order, i)
+	}
+	f.mu.Unlock()
+	close(p.done)
+	return p.b, p.err
+}
+
+// fetch requests block i of the object, again while it fails transiently.
+func (f *remoteFile) fetch(i int64) ([]byte, error) {
+	off := i * remoteBlockSize
+	n := min(remoteBlockSize, f.size-off)
+	b := make([]byte, n)
+	err := f.retry(func() error {
+		resp, err := f.get(off, n)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if _, err := io.ReadFull(resp.Body, b); err != nil {
+			return fmt.Errorf("%s: bytes %d to %d: %w", f.name, off, off+n, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (f *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= f.size {
+			return n, io.EOF
+		}
+		b, err := f.block(off / remoteBlockSize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], b[off%remoteBlockSize:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// Close drops the cached blocks; there is no connection to close.
+func (f *remoteFile) Close() error {
+	f.mu.Lock()
+	clear(f.blocks)
+	f.order = nil
+	f.mu.Unlock()
+	return nil
+}
+
+// remoteMember is a remote file opened through remoteFS.
+type remoteMember struct {
+	*io.SectionReader
+	f    *remoteFile
+	info remoteInfo
+}
+
+func (m *remoteMember) Stat() (fs.FileInfo, error) { return m.info, nil }
+func (m *remoteMember) Close() error               { return m.f.Close() }
+
+// remoteInfo describes a remote file; only its name and size are known.
+type remoteInfo struct {
+	name string
+	size int64
+}
+
+func (fi remoteInfo) Name() string       { return fi.name }
+func (fi remoteInfo) Size() int64        { return fi.size }
+func (fi remoteInfo) Mode() fs.FileMode  { return 0444 }
+func (fi remoteInfo) ModTime() time.Time { return time.Time{} }
+func (fi remoteInfo) IsDir() bool        { return false }
+func (fi remoteInfo) Sys() any           { return nil }
@@ -0,0 +1,99 @@
+package gdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// objectServer serves object, with range requests, failing the first
+// failures requests for it with status 503, and answering 403 for any
+// other path. It counts the requests for the object, and the most it
+// answered at once.
+func objectServer(t *testing.T, object []byte, failures int32) (*atomic.Int32, *atomic.Int32) {
+	t.Helper()
+	var requests, serving, most atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/bucket/object" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if requests.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		n := serving.Add(1)
+		defer serving.Add(-1)
+		for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+		}
+		// The readers wanting a block at once all ask while it is fetched.
+		time.Sleep(50 * time.Millisecond)
+		http.ServeContent(w, req, "object", time.Time{}, bytes.NewReader(object))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	return &requests, &most
+}
+
+func TestRemoteRetry(t *testing.T) {
+	defer func(wait time.Duration) { remoteBackoff = wait }(remoteBackoff)
+	remoteBackoff = time.Millisecond
+	object := bytes.Repeat([]byte("gdb"), 1000)
+	requests, _ := objectServer(t, object, remoteAttempts-1)
+
+	f, err := openRemote(context.Background(), "s3://bucket/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.size != int64(len(object)) || requests.Load() != remoteAttempts {
+		t.Errorf("size %d after %d requests, want %d after %d", f.size, requests.Load(), len(object), remoteAttempts)
+	}
+}
+
+func TestRemoteForbidden(t *testing.T) {
+	objectServer(t, nil, 0)
+	_, err := openRemote(context.Background(), "s3://bucket/a00000002.gdbtablx")
+	if !errors.Is(err, fs.ErrNotExist) || !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("403: %v, want fs.ErrNotExist and fs.ErrPermission", err)
+	}
+}
+
+// TestRemoteSharedFetch reads two blocks from many goroutines at once: each
+// is fetched once, for all of them, and both at the same time.
+func TestRemoteSharedFetch(t *testing.T) {
+	object := bytes.Repeat([]byte("gdb"), remoteBlockSize)
+	requests, most := objectServer(t, object, 0)
+	f, err := openRemote(context.Background(), "s3://bucket/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			off := i%2*remoteBlockSize + i*100
+			p := make([]byte, 10)
+			if _, err := f.ReadAt(p, int64(off)); err != nil {
+				t.Error(err)
+			} else if !bytes.Equal(p, object[off:off+10]) {
+				t.Errorf("bytes at %d: %q", off, p)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3: one opening, one for each block", n)
+	}
+	if n := most.Load(); n != 2 {
+		t.Errorf("%d blocks fetched at once, want 2", n)
+	}
+}
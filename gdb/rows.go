@@ -0,0 +1,378 @@
+package gdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"math"
+	"slices"
+	"time"
+)
+
+// Row is one decoded record of a table.
+type Row struct {
+	FID    int           // 1-based object id
+	Fields []Field       // the fields of the table
+	Values []interface{} // one per field, as decoded by readValue; nil for nulls
+}
+
+// Value returns the value of the field called name.
+func (r *Row) Value(name string) (interface{}, bool) {
+	for i := range r.Fields {
+		if r.Fields[i].Name == name {
+			return r.Values[i], true
+		}
+	}
+	return nil, false
+}
+
+// GUID is the value of a GlobalID or GUID field, in the Windows byte order
+// it is stored in.
+type GUID [16]byte
+
+func (g GUID) String() string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%02X%02X-%X}",
+		binary.LittleEndian.Uint32(g[0:]), binary.LittleEndian.Uint16(g[4:]), binary.LittleEndian.Uint16(g[6:]),
+		g[8], g[9], g[10:])
+}
+
+// dateTimeEpoch is day zero of datetime fields, which store fractional days.
+var dateTimeEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func dateTimeValue(days float64) time.Time {
+	return dateTimeEpoch.Add(time.Duration(math.Round(days*86400*1000)) * time.Millisecond)
+}
+
+// dateTimeOffsetValue decodes a timestamp offset field: the local time in
+// fractional days, then its offset from UTC in minutes.
+func dateTimeOffsetValue(days float64, minutes int16) time.Time {
+	t := dateTimeValue(days)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+		time.FixedZone("", int(minutes)*60))
+}
+
+// Date is the value of a date only field, at midnight UTC.
+type Date struct{ time.Time }
+
+func (d Date) String() string {
+	return d.Format("2006-01-02")
+}
+
+// TimeOfDay is the value of a time only field, the time since midnight.
+type TimeOfDay time.Duration
+
+func (t TimeOfDay) String() string {
+	return time.Time{}.Add(time.Duration(t)).Format("15:04:05.000")
+}
+
+// readValue decodes the value of fld at the current position in the row:
+//
+//	int16, int32, float32, float64  int16, int32, float32, float64
+//	int64                           int64
+//	string, XML                     string
+//	datetime                        time.Time (UTC)
+//	date only                       Date
+//	time only                       TimeOfDay
+//	timestamp offset                time.Time in its offset from UTC
+//	shape                           Geometry
+//	binary                          []byte
+//	raster                          int32 raster id if managed, else the
+//	                                path (string) or the inline bytes
+//	GlobalID, GUID                  GUID
+func (bt *BaseTable) readValue(fld *Field) interface{} {
+	switch fld.Type {
+	case 0:
+		return readInt16(bt.gdbTable)
+	case 1:
+		return readInt32(bt.gdbTable)
+	case 2:
+		return readFloat32(bt.gdbTable)
+	case 3:
+		return readFloat64(bt.gdbTable)
+	case 4:
+		return bt.readString()
+	case 12:
+		length := readVarUint(bt.gdbTable)
+		return xmlText(readBytes(bt.gdbTable, int(length)))
+	case 5:
+		return dateTimeValue(readFloat64(bt.gdbTable))
+	case 7:
+		return readGeometry(bt.gdbTable, &fld.Shp)
+	case 8:
+		length := readVarUint(bt.gdbTable)
+		return readBytes(bt.gdbTable, int(length))
+	case 9:
+		switch fld.RasterFields.RasterType {
+		case 1:
+			return readInt32(bt.gdbTable)
+		case 0:
+			length := readVarUint(bt.gdbTable)
+			return string(readBytes(bt.gdbTable, int(length)))
+		default:
+			length := readVarUint(bt.gdbTable)
+			return readBytes(bt.gdbTable, int(length))
+		}
+	case 10, 11:
+		var g GUID
+		copy(g[:], readBytes(bt.gdbTable, 16))
+		return g
+	case 13:
+		return readInt64(bt.gdbTable)
+	case 14:
+		return Date{dateTimeValue(readFloat64(bt.gdbTable))}
+	case 15:
+		days := readFloat64(bt.gdbTable)
+		return TimeOfDay(time.Duration(math.Round(days*86400*1000)) * time.Millisecond)
+	case 16:
+		days := readFloat64(bt.gdbTable)
+		return dateTimeOffsetValue(days, readInt16(bt.gdbTable))
+	default:
+		bt.skipValue(fld)
+		return nil
+	}
+}
+
+// readString decodes the value of a string field at the current position in
+// the row: UTF-8, or UTF-16LE in 9.x tables.
+func (bt *BaseTable) readString() string {
+	length := readVarUint(bt.gdbTable)
+	b := readBytes(bt.gdbTable, int(length))
+	if bt.Version == tableVersion9 {
+		return utf16String(b)
+	}
+	return string(b)
+}
+
+// maxXMLText is the most an XML value is inflated to. Metadata documents run
+// to a few megabytes at most; a damaged value that would inflate past it is
+// returned as it is stored rather than filling memory.
+const maxXMLText = 64 << 20
+
+// xmlText decodes the stored value b of an XML field. It is UTF-8 text, but
+// some writers deflate it, with a zlib or gzip header, or store it as UTF-16LE
+// behind a byte order mark. A value that looks compressed but does not
+// inflate, or inflates past maxXMLText, is returned as it is stored.
+func xmlText(b []byte) string {
+	var r io.ReadCloser
+	var err error
+	switch {
+	case len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case len(b) >= 2 && b[0]&0x0f == 8 && binary.BigEndian.Uint16(b)%31 == 0:
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	case len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe:
+		return utf16String(b[2:])
+	default:
+		return string(b)
+	}
+	if err == nil {
+		var text []byte
+		text, err = io.ReadAll(io.LimitReader(r, maxXMLText+1))
+		r.Close()
+		if err == nil && len(text) <= maxXMLText {
+			return xmlText(text)
+		}
+	}
+	return string(b)
+}
+
+// ReadRow decodes row fid (0-based). It returns false for deleted or missing
+// rows, and the error of the context of the table once it is done.
+func (bt *BaseTable) ReadRow(fid int) (*Row, bool, error) {
+	if err := bt.Context().Err(); err != nil {
+		return nil, false, err
+	}
+	if ok, err := bt.getRow(fid); !ok || err != nil {
+		return nil, false, err
+	}
+
+	row := &Row{FID: fid + 1, Fields: bt.Fields, Values: make([]interface{}, len(bt.Fields))}
+	var iFieldForFlagTest int
+	for i := range bt.Fields {
+		fld := &bt.Fields[i]
+		if bt.skipField(fld, &iFieldForFlagTest) {
+			continue
+		}
+		row.Values[i] = bt.readValue(fld)
+	}
+	if err := bt.rowErr(fid); err != nil {
+		return nil, false, err
+	}
+	return row, true, nil
+}
+
+// RowIterator walks the rows of a table through its .gdbtablx offsets,
+// decoding one row at a time.
+type RowIterator struct {
+	bt      *BaseTable
+	fid     int         // the next row, or the next of fids
+	fids    []int       // the only rows to read, if known
+	filters []rowFilter // what the rows read must hold
+	last    int         // 1-based object id of the row read last
+	done    bool
+	err     error
+}
+
+// Rows returns an iterator over the rows of the table, in fid order, or
+// over those the Where and BBox of the context of the table select.
+func (bt *BaseTable) Rows() *RowIterator {
+	it := &RowIterator{bt: bt}
+	if w, ok := whereOf(bt.Context()); ok {
+		it.narrow(bt.where(w))
+	}
+	if b, ok := bboxOf(bt.Context()); ok && it.err == nil {
+		it.narrow(bt.bbox(b))
+	}
+	return it
+}
+
+// narrow keeps to the rows filter selects, among fids if they are known.
+func (it *RowIterator) narrow(filter rowFilter, fids []int, err error) {
+	if err != nil {
+		it.err = err
+		return
+	}
+	if filter != nil {
+		it.filters = append(it.filters, filter)
+	}
+	switch {
+	case fids == nil:
+	case it.fids == nil:
+		it.fids = fids
+	default:
+		both := make([]int, 0)
+		for _, fid := range fids {
+			if _, found := slices.BinarySearch(it.fids, fid); found {
+				both = append(both, fid)
+			}
+		}
+		it.fids = both
+	}
+}
+
+// selected reports whether row holds what every filter asks.
+func (it *RowIterator) selected(row *Row) bool {
+	for _, f := range it.filters {
+		if !f.match(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// nextFID returns the next row to read, if any.
+func (it *RowIterator) nextFID() (int, bool) {
+	if it.fids != nil {
+		if it.fid >= len(it.fids) {
+			return 0, false
+		}
+		it.fid++
+		return it.fids[it.fid-1], true
+	}
+	if it.fid >= int(it.bt.NFeaturesX) {
+		return 0, false
+	}
+	it.fid++
+	return it.fid - 1, true
+}
+
+// Next returns the next row, skipping deleted ones, or io.EOF once there are
+// no more. A row that cannot be decoded is reported as an error; calling Next
+// again carries on with the row after it. Once the context of the table is
+// done, Next returns its error and then io.EOF, so loops that skip bad rows
+// still end; so it does with a Where or BBox that cannot apply to the table.
+func (it *RowIterator) Next() (*Row, error) {
+	if it.done {
+		return nil, io.EOF
+	}
+	if it.err != nil {
+		it.done = true
+		return nil, it.err
+	}
+	for {
+		fid, ok := it.nextFID()
+		if !ok {
+			return nil, io.EOF
+		}
+		it.last = fid + 1
+		row, ok, err := it.bt.ReadRow(fid)
+		if err != nil && it.bt.Context().Err() != nil {
+			it.done = true
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok && it.selected(row) {
+			return row, nil
+		}
+	}
+}
+
+// All returns the rows left as a sequence for range, skipping deleted rows.
+// Rows that cannot be decoded are reported to the function WithRowReport
+// gave, then handled as the OnError of the context of the table says:
+// skipped with a warning, given as a row of nulls with a warning, or ending
+// the sequence, Err returning the *RowError.
+//
+//	rows := bt.Rows()
+//	for row := range rows.All() {
+//		...
+//	}
+//	if err := rows.Err(); err != nil {
+//		...
+//	}
+//
+// It also ends early once the context of the table is done, Err then
+// returning the context's error, and yields nothing under a Where or BBox
+// that cannot apply to the table, Err saying why.
+func (it *RowIterator) All() iter.Seq[*Row] {
+	return func(yield func(*Row) bool) {
+		if it.err != nil {
+			it.done = true
+			return
+		}
+		for {
+			row, err := it.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				if cerr := it.bt.Context().Err(); cerr != nil {
+					it.err = cerr
+					return
+				}
+				ctx := it.bt.Context()
+				re, ok := err.(*RowError)
+				if !ok {
+					re = &RowError{it.bt.GdbTablePath, it.last, err}
+				}
+				reportRow(ctx, re)
+				switch OnErrorOf(ctx) {
+				case FillBad:
+					slog.Warn("filling row with nulls", "err", err)
+					row = &Row{FID: re.FID, Fields: it.bt.Fields, Values: make([]interface{}, len(it.bt.Fields))}
+				case AbortOnBad:
+					it.err = re
+					return
+				default:
+					slog.Warn("skipping row", "err", err)
+					continue
+				}
+			}
+			countRow(it.bt.Context(), it.bt.GdbTablePath)
+			if !yield(row) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error that ended All early, if any.
+func (it *RowIterator) Err() error {
+	return it.err
+}
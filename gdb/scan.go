@@ -0,0 +1,55 @@
+package gdb
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FindGeodatabases walks the directory tree at root and returns the file
+// geodatabases in it, in lexical order: the directories holding a master
+// table, a00000001.gdbtable, whose header reads as a table's, whatever they
+// are called, as a disk image recovered without its names leaves them. A
+// directory named .gdb counts with a damaged master table header too. The
+// geodatabases found are not walked into; directories that cannot be read
+// are skipped with a warning.
+func FindGeodatabases(ctx context.Context, root string) ([]string, error) {
+	found := make([]string, 0)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if cerr := ctx.Err(); cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			if path == root {
+				return err
+			}
+			slog.Warn("skipping directory", "path", path, "err", err)
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if hasMasterTable(path) {
+			found = append(found, path)
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return found, err
+}
+
+// hasMasterTable reports whether directory dir holds the master table of a
+// geodatabase.
+func hasMasterTable(dir string) bool {
+	f, err := openGDBFile(os.DirFS(dir), dir, masterTableFileName+".gdbtable")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, _, err = checkTableHeader(f)
+	return err == nil || errors.Is(err, ErrCorruptHeader) && strings.EqualFold(filepath.Ext(dir), ".gdb")
+}
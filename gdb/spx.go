@@ -0,0 +1,93 @@
+package gdb
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"slices"
+)
+
+// An .spx is a B-tree laid out as an .atx, with int64 keys naming cells of
+// the first grid of the shape field. A feature is listed under every cell
+// its envelope covers: its column floor(x / size) and its row
+// floor(y / size), each plus spxCellBias, the column shifted left 31 bits.
+// The larger grids are thought to set the top two bits of the key; an index
+// holding such keys is not used.
+const spxCellBias = 1 << 29
+
+// spatialKey describes the keys of an .spx to the .atx reader.
+var spatialKey = Field{Name: "cell", Type: 13}
+
+// spatialLookup returns the 0-based fids of the rows the .spx of the table
+// lists in the cells b meets, or nil when there is no index to trust: none,
+// one that cannot be read, or a table whose rows are not numbered as its
+// .gdbtablx numbers them.
+func (bt *BaseTable) spatialLookup(fld *Field, b BBox) []int {
+	if bt.gdbTablx == nil || len(bt.deleted) > 0 {
+		return nil
+	}
+	gdbFilePath, tableName := bt.location()
+	fids, err := spatialCandidates(sourceFS(bt.Context(), gdbFilePath), gdbFilePath, tableName, fld, b)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		slog.Warn("cannot use spatial index, reading every row", "table", bt.GdbTablePath, "err", err)
+		return nil
+	}
+	slog.Debug("looked up rows", "table", bt.GdbTablePath, "index", tableName+".spx", "rows", len(fids))
+	return fids
+}
+
+// spatialCandidates reads the .spx of table tableName, whose shape field is
+// fld, for the rows in the cells b meets, in fid order.
+func spatialCandidates(fsys fs.FS, gdbFilePath string, tableName string, fld *Field, b BBox) ([]int, error) {
+	ix, err := openIndexFile(fsys, gdbFilePath, tableName+".spx", &spatialKey)
+	if err != nil {
+		return nil, err
+	}
+	defer ix.Close()
+	if len(fld.Shp.GridSizes) == 0 || !(fld.Shp.GridSizes[0] > 0) {
+		return nil, fmt.Errorf("%s: no grid to read it with", ix.f.Name())
+	}
+	size := fld.Shp.GridSizes[0]
+
+	first, err := ix.edge(false)
+	if err != nil {
+		return nil, err
+	}
+	last, err := ix.edge(true)
+	if err != nil || last == nil {
+		return []int{}, err
+	}
+	if first.(int64)>>62 != 0 || last.(int64)>>62 != 0 {
+		return nil, fmt.Errorf("%s: keys of a grid other than the first", ix.f.Name())
+	}
+
+	// One cell more on each side, in case the envelope of a feature was
+	// rounded the other way, and no column the index has no key in.
+	x0 := max(spxCell(b.MinX/size-1), first.(int64)>>31-spxCellBias)
+	x1 := min(spxCell(b.MaxX/size+1), last.(int64)>>31-spxCellBias)
+	y0, y1 := spxCell(b.MinY/size-1), spxCell(b.MaxY/size+1)
+	fids := make([]int, 0)
+	for x := x0; x <= x1; x++ {
+		column, err := ix.between(spxKey(x, y0), spxKey(x, y1))
+		if err != nil {
+			return nil, err
+		}
+		fids = append(fids, column...)
+	}
+	slices.Sort(fids)
+	return slices.Compact(fids), nil
+}
+
+// spxCell returns the cell holding v, in cells, within what a key holds.
+func spxCell(v float64) int64 {
+	return int64(max(min(math.Floor(v), spxCellBias-1), -spxCellBias))
+}
+
+func spxKey(x, y int64) int64 {
+	return (x+spxCellBias)<<31 | (y + spxCellBias)
+}
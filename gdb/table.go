@@ -0,0 +1,753 @@
+// Package gdb reads the tables of an Esri file geodatabase: their headers and
+// field descriptions from the .gdbtable files, their rows through the
+// .gdbtablx offsets, and the shapes stored in them.
+package gdb
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"log/slog"
+)
+
+// RasFields describes a raster field (type 9): its coordinate system, the
+// origins, scales and tolerances of its coordinates, and where its pixels are
+// kept.
+type RasFields struct {
+	MTolerance  float64
+	XYTolerance float64
+	ZOrig       float64
+	MOrig       float64
+	MScale      float64
+	ZScale      float64
+	XOrig       float64
+	YOrig       float64
+	XYScale     float64
+	ZTolerance  float64
+	HasM        bool
+	HasZ        bool
+	WKT         string
+	Column      string
+	RasterType  uint8 // 0 external, 1 managed, 2 inline
+}
+
+// Shape describes a geometry field (type 7): its coordinate system, the
+// origins, scales and tolerances of its coordinates, the layer extent and the
+// spatial index grid sizes.
+type Shape struct {
+	YMax        float64
+	XMax        float64
+	XMin        float64
+	YMin        float64
+	MOrig       float64
+	ZOrig       float64
+	ZScale      float64
+	MScale      float64
+	XYScale     float64
+	XOrig       float64
+	YOrig       float64
+	HasZ        bool
+	HasM        bool
+	MTolerance  float64
+	ZTolerance  float64
+	XYTolerance float64
+	WKT         string
+	ZMin        float64
+	ZMax        float64
+	MMin        float64
+	MMax        float64
+	GridSizes   []float64
+}
+
+// maxTrailerResync is how many unexpected float64s readShapeTrailer will step
+// over while looking for the spatial index grid before giving up.
+const maxTrailerResync = 4
+
+// readShapeTrailer reads what follows the XY extent of a shape field: the Z
+// and M ranges when the layer geometries have them, then a zero byte, a
+// uint32 count of 1 to 3 and that many spatial index grid sizes.
+//
+// Some writers store extra doubles before the grid, so when the grid marker is
+// not where the spec puts it the reader resyncs by skipping up to
+// maxTrailerResync doubles, and reports an error if it still cannot find it.
+func readShapeTrailer(f *gdbFile, shp *Shape, hasZ bool, hasM bool) error {
+	if hasZ {
+		shp.ZMin = readFloat64(f)
+		shp.ZMax = readFloat64(f)
+	}
+	if hasM {
+		shp.MMin = readFloat64(f)
+		shp.MMax = readFloat64(f)
+	}
+
+	start, _ := f.Seek(0, 1)
+	for skipped := 0; skipped <= maxTrailerResync; skipped++ {
+		f.Seek(start+int64(skipped)*8, 0)
+		marker := readBytes(f, 5)
+		nGrids := binary.LittleEndian.Uint32(marker[1:])
+		if marker[0] != 0 || nGrids < 1 || nGrids > 3 {
+			continue
+		}
+
+		shp.GridSizes = make([]float64, nGrids)
+		for i := range shp.GridSizes {
+			shp.GridSizes[i] = readFloat64(f)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("shape field trailer at offset %d: spatial index grid not found", start)
+}
+
+// Field is one column of a table as described in its .gdbtable header.
+// RasterFields is only set for raster fields and Shp for geometry fields.
+type Field struct {
+	Name         string
+	Alias        string
+	Type         uint8
+	Nullable     bool
+	Width        uint32
+	RasterFields RasFields
+	Shp          Shape
+}
+
+// BaseTable is an open table: its field descriptions, the geometry type of its
+// shape field if it has one, and the .gdbtable and .gdbtablx files its rows
+// are read from. It is created by NewBaseTable or Geodatabase.OpenTable and
+// must be closed by the caller. A table whose .gdbtablx is missing or damaged
+// is opened with an index of its rows rebuilt from the .gdbtable instead.
+//
+// Reading a row moves the offsets of the table, so a BaseTable reads from one
+// goroutine at a time. Goroutines reading the same table at once each take a
+// Reader of it.
+type BaseTable struct {
+	GdbTablePath, GdbTablxPath string
+	gdbTable, gdbTablx         *gdbFile
+	NFeaturesX                 uint32
+	sizeTablxOffsets           uint32
+	tablxBlocks                []int32 // of a sparse .gdbtablx, where each block of rows is in it
+	offsets                    []int64 // the rebuilt index, nil with a .gdbtablx
+	deleted                    []int64 // deleted rows read back, the last rows
+	Fields                     []Field
+	hasFlags                   bool
+	nullableFields             int
+	flags                      []uint8
+	LayerGeomType              uint8
+	LayerHasZ, LayerHasM       bool
+	OIDName                    string
+	Version                    int32 // 3 for FileGDB 9.x, 4 for 10.x, 6 for 64-bit object IDs
+	ctx                        context.Context
+}
+
+// NFeatures        uint32
+// HeaderOffset     uint32
+// HeaderLength     uint32
+
+func (bt *BaseTable) getFlags(f *gdbFile) {
+	bt.flags = bt.flags[:0]
+	if bt.hasFlags {
+		nRemainingFlags := bt.nullableFields
+		for nRemainingFlags > 0 {
+			temp := readByte(f)
+			bt.flags = append(bt.flags, temp)
+			nRemainingFlags -= 8
+		}
+	}
+}
+
+// skipField reports whether fld is null in the current row, by its bit in
+// the null flags; iFieldForFlagTest counts the nullable fields before it,
+// which a table may have hundreds of.
+func (bt *BaseTable) skipField(fld *Field, iFieldForFlagTest *int) bool {
+	if bt.hasFlags && fld.Nullable {
+		var test uint8 = (bt.flags[*iFieldForFlagTest>>3] & (1 << (*iFieldForFlagTest % 8)))
+		*iFieldForFlagTest++
+		return test != 0
+	}
+	return false
+}
+
+// getRow positions gdbTable at the start of the fields of row fid (0-based)
+// and reads its null flags. It returns false for deleted or missing rows.
+// Errors left from a previous row are cleared, so that reading carries on
+// past a damaged one; callers check bt.gdbTable.Err once they have read the
+// fields.
+func (bt *BaseTable) getRow(fid int) (bool, error) {
+	bt.gdbTable.clearErr()
+	offset, err := bt.rowOffset(fid)
+	if offset == 0 || err != nil {
+		return false, err
+	}
+	bt.seekRow(offset)
+	return true, nil
+}
+
+// rowOffset returns the offset of row fid (0-based) in the .gdbtable, as its
+// .gdbtablx, the rebuilt index or the .freelist gives it, or 0 if there is
+// no such row. A sparse .gdbtablx has the offsets of the blocks of 1024 rows
+// it keeps one after the other, so the offset of a row is found in the
+// place of its block among them.
+func (bt *BaseTable) rowOffset(fid int) (int64, error) {
+	if bt.Deleted(fid) {
+		return bt.deleted[fid-int(bt.NFeaturesX)+len(bt.deleted)], nil
+	}
+	if bt.offsets != nil {
+		if fid < 0 || fid >= len(bt.offsets) {
+			return 0, nil
+		}
+		return bt.offsets[fid], nil
+	}
+	slot := int64(fid)
+	if bt.tablxBlocks != nil {
+		if fid < 0 || fid/1024 >= len(bt.tablxBlocks) || bt.tablxBlocks[fid/1024] < 0 {
+			return 0, nil
+		}
+		slot = int64(bt.tablxBlocks[fid/1024])*1024 + int64(fid%1024)
+	}
+	bt.gdbTablx.clearErr()
+	bt.gdbTablx.Seek(16+slot*int64(bt.sizeTablxOffsets), 0)
+	b := readBytes(bt.gdbTablx, int(bt.sizeTablxOffsets))
+	if err := bt.gdbTablx.Err(); err != nil {
+		return 0, &RowError{bt.GdbTablePath, fid + 1, err}
+	}
+	var featureOffset uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		featureOffset = featureOffset<<8 | uint64(b[i])
+	}
+	return int64(featureOffset), nil
+}
+
+// Deleted reports whether row fid (0-based) is a deleted row read back from
+// the free space of the table, as opened with WithDeletedRows.
+func (bt *BaseTable) Deleted(fid int) bool {
+	return fid < int(bt.NFeaturesX) && fid >= int(bt.NFeaturesX)-len(bt.deleted)
+}
+
+// ReconstructedOIDs reports whether the object IDs of the rows of bt were
+// numbered in the order the rows are found in the .gdbtable, its index
+// rebuilt, rather than read from its .gdbtablx. They are those ArcGIS gives
+// only as long as no edit or compaction moved a row.
+func (bt *BaseTable) ReconstructedOIDs() bool {
+	return bt.offsets != nil
+}
+
+// seekRow positions gdbTable at the start of the fields of the row at offset
+// and reads its null flags.
+func (bt *BaseTable) seekRow(offset int64) {
+	bt.gdbTable.Seek(offset, 0)
+	readU32(bt.gdbTable) // blobLen
+	bt.getFlags(bt.gdbTable)
+}
+
+// rowErr wraps any error reading the fields of row fid.
+func (bt *BaseTable) rowErr(fid int) error {
+	if err := bt.gdbTable.Err(); err != nil {
+		return &RowError{bt.GdbTablePath, fid + 1, err}
+	}
+	return nil
+}
+
+// skipValue moves past the stored value of fld in the current row.
+func (bt *BaseTable) skipValue(fld *Field) {
+	switch fld.Type {
+	case 0: // Int16
+		bt.gdbTable.Seek(2, 1)
+	case 1, 2: // Int32, Float32
+		bt.gdbTable.Seek(4, 1)
+	case 3, 5, 13, 14, 15: // Float64, DateTime, Int64, DateOnly, TimeOnly
+		bt.gdbTable.Seek(8, 1)
+	case 16: // TimestampOffset: a DateTime and an int16
+		bt.gdbTable.Seek(10, 1)
+	case 4, 7, 8, 12: // String, Shape, Binary, XML
+		bt.gdbTable.skip(readVarUint(bt.gdbTable))
+	case 9: // Raster
+		if fld.RasterFields.RasterType == 1 {
+			bt.gdbTable.Seek(4, 1) // raster_id
+		} else {
+			bt.gdbTable.skip(readVarUint(bt.gdbTable))
+		}
+	case 10, 11: // UUID
+		bt.gdbTable.Seek(16, 1)
+	default:
+		bt.gdbTable.fail(fmt.Errorf("cannot skip value of field type %d", fld.Type))
+	}
+}
+
+// HasShape returns the shape field of the table, if it has one.
+func (bt *BaseTable) HasShape() (*Field, bool) {
+	for i := range bt.Fields {
+		if bt.Fields[i].Type == 7 {
+			return &bt.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// Context returns the context the table was opened with.
+func (bt *BaseTable) Context() context.Context {
+	if bt.ctx == nil {
+		return context.Background()
+	}
+	return bt.ctx
+}
+
+// Reader returns a view of the table for another goroutine, which can then
+// read rows at the same time as bt and its other readers. It shares the open
+// files and the fields of bt but keeps its own offsets. Closing it does
+// nothing, and it must not be used once bt is closed.
+func (bt *BaseTable) Reader() *BaseTable {
+	r := *bt
+	r.gdbTable = bt.gdbTable.reader()
+	if bt.gdbTablx != nil {
+		r.gdbTablx = bt.gdbTablx.reader()
+	}
+	r.flags = nil
+	return &r
+}
+
+// Close closes the .gdbtable and .gdbtablx files of the table.
+func (bt *BaseTable) Close() {
+	bt.gdbTable.Close()
+	if bt.gdbTablx != nil {
+		bt.gdbTablx.Close()
+	}
+}
+
+// NewBaseTable opens table tableName of the geodatabase and reads its
+// header and field descriptions. The files stay open until Close. A header
+// that cannot be read fails with ErrNotTable, ErrUnsupportedVersion or
+// ErrCorruptHeader; one of the .gdbtablx only has the index rebuilt.
+func NewBaseTable(gdbFilePath string, tableName string) (BaseTable, error) {
+	return NewBaseTableContext(context.Background(), gdbFilePath, tableName)
+}
+
+// NewBaseTableContext is NewBaseTable with a context that bounds the life of
+// the table: once ctx is done, reading rows fails with ctx.Err() and reads
+// over the network are aborted.
+func NewBaseTableContext(ctx context.Context, gdbFilePath string, tableName string) (BaseTable, error) {
+	return newBaseTable(ctx, sourceFS(ctx, gdbFilePath), gdbFilePath, tableName)
+}
+
+// NewBaseTableFS is NewBaseTableContext reading the files of the table from
+// fsys, with no master table to list it: a table copied out of a
+// geodatabase whose master table is lost, or the bytes of one held in an
+// fstest.MapFS, as a fuzzer feeds the header and row parsers. name stands
+// for the geodatabase in errors and in GdbTablePath.
+func NewBaseTableFS(ctx context.Context, fsys fs.FS, name string, tableName string) (BaseTable, error) {
+	return newBaseTable(ctx, fsys, name, tableName)
+}
+
+// newBaseTable is NewBaseTableContext reading the files of the table from
+// fsys; gdbFilePath only names them.
+func newBaseTable(ctx context.Context, fsys fs.FS, gdbFilePath string, tableName string) (BaseTable, error) {
+	if err := ctx.Err(); err != nil {
+		return BaseTable{}, err
+	}
+	tablePath := FilePath(gdbFilePath, tableName+".gdbtable")
+	tablxPath := FilePath(gdbFilePath, tableName+".gdbtablx")
+	mode := indexModeOf(ctx)
+	var gdbtablx *gdbFile
+	var numFeaturesX, sizeTablxOffsets uint32
+	var tablxBlocks []int32
+	var tablxErr error
+	if mode != indexNone {
+		gdbtablx, numFeaturesX, sizeTablxOffsets, tablxBlocks, tablxErr = openTablx(fsys, gdbFilePath, tableName)
+		if tablxErr == nil && mode == indexRebuilt {
+			gdbtablx.Close()
+			gdbtablx = nil
+		}
+	}
+
+	gdbtable, err := openGDBFile(fsys, gdbFilePath, tableName+".gdbtable")
+	if err != nil {
+		if gdbtablx != nil {
+			gdbtablx.Close()
+		}
+		return BaseTable{}, err
+	}
+
+	headerOff, version, err := checkTableHeader(gdbtable)
+	if err != nil {
+		gdbtable.Close()
+		if gdbtablx != nil {
+			gdbtablx.Close()
+		}
+		return BaseTable{}, err
+	}
+	gdbtable.Seek(headerOff, 0)
+	headerLen := readU32(gdbtable)
+	// The rows follow the field descriptions and whatever else the header
+	// holds, and the descriptions of a damaged header must not run past it.
+	rowsStart := headerOff + 4 + int64(headerLen)
+
+	gdbtable.Seek(4, 1)
+	// The low byte is the geometry type, the top bits say whether the
+	// geometries carry Z and M values.
+	geomTypeAndFlags := readU32(gdbtable)
+	layerGeomType := uint8(geomTypeAndFlags & 0xFF)
+	layerHasZ := geomTypeAndFlags&(1<<31) != 0
+	layerHasM := geomTypeAndFlags&(1<<30) != 0
+
+	numFields := int(readByte(gdbtable))
+	numFields += int(readByte(gdbtable)) * 256
+
+	hasFlags := false
+	nullableFields := 0
+	oidName := ""
+
+	flds := make([]Field, 0)
+	for i := 0; i < numFields; i++ {
+		// nbcar := -1
+		fld := Field{}
+
+		fld.Name = getString(gdbtable, -1)
+		fld.Alias = getString(gdbtable, -1)
+		fld.Type = readByte(gdbtable)
+		fld.Nullable = true
+		slog.Debug("field", "table", tableName, "name", fld.Name, "alias", fld.Alias, "type", fld.Type)
+
+		switch fld.Type {
+
+		case 6: // ObjecdID
+			readByte(gdbtable) // magic_byte1
+			readByte(gdbtable) // magic_byte2
+			fld.Nullable = false
+
+		case 7: // Shape
+			readByte(gdbtable) // magic_byte1 // 0
+			flag := readByte(gdbtable)
+			if (flag & 1) == 0 {
+				fld.Nullable = false
+			}
+			wktLen := int(readByte(gdbtable))
+			wktLen += int(readByte(gdbtable)) * 256
+			fld.Shp.WKT = getString(gdbtable, wktLen/2)
+
+			magicByte3 := readByte(gdbtable)
+
+			fld.Shp.HasM = false
+			fld.Shp.HasZ = false
+			if magicByte3 == 5 {
+				fld.Shp.HasZ = true
+			}
+			if magicByte3 == 7 {
+
+				fld.Shp.HasM = true
+				fld.Shp.HasZ = true
+			}
+
+			fld.Shp.XOrig = readFloat64(gdbtable)
+			fld.Shp.YOrig = readFloat64(gdbtable)
+			fld.Shp.XYScale = readFloat64(gdbtable)
+			if fld.Shp.HasM {
+				fld.Shp.MOrig = readFloat64(gdbtable)
+				fld.Shp.MScale = readFloat64(gdbtable)
+			}
+
+			if fld.Shp.HasZ {
+				fld.Shp.ZOrig = readFloat64(gdbtable)
+				fld.Shp.ZScale = readFloat64(gdbtable)
+			}
+			fld.Shp.XYTolerance = readFloat64(gdbtable)
+			if fld.Shp.HasM {
+				fld.Shp.MTolerance = readFloat64(gdbtable)
+			}
+			if fld.Shp.HasZ {
+				fld.Shp.ZTolerance = readFloat64(gdbtable)
+			}
+
+			fld.Shp.XMin = readFloat64(gdbtable)
+			fld.Shp.YMin = readFloat64(gdbtable)
+			fld.Shp.XMax = readFloat64(gdbtable)
+			fld.Shp.YMax = readFloat64(gdbtable)
+
+			if err := readShapeTrailer(gdbtable, &fld.Shp, layerHasZ, layerHasM); err != nil {
+				gdbtable.fail(err)
+			}
+
+		case 4: // String
+			fld.Width = readU32(gdbtable)
+			flag := readByte(gdbtable)
+
+			if (flag & 1) == 0 {
+				fld.Nullable = false
+			}
+
+			defaultValueLength := readVarUint(gdbtable)
+			if (flag&4) != 0 && defaultValueLength > 0 {
+				gdbtable.skip(defaultValueLength)
+			}
+
+		case 8: //TODO: What is this?
+			gdbtable.Seek(1, 1)
+			flag := readByte(gdbtable)
+			if (flag & 1) == 0 {
+				fld.Nullable = false
+			}
+
+		case 9: // Raster
+			gdbtable.Seek(1, 1)
+			flag := readByte(gdbtable)
+			if (flag & 1) == 0 {
+				fld.Nullable = false
+			}
+
+			fld.RasterFields.Column = getString(gdbtable, -1)
+
+			wktLen := int(readByte(gdbtable))
+			wktLen += int(readByte(gdbtable)) * 256
+			fld.RasterFields.WKT = getString(gdbtable, wktLen/2)
+
+			magicByte3 := readByte(gdbtable)
+			if magicByte3 > 0 {
+				fld.RasterFields.HasM = false
+				fld.RasterFields.HasZ = false
+
+				if magicByte3 == 5 {
+					fld.RasterFields.HasZ = true
+				} else if magicByte3 == 7 {
+					fld.RasterFields.HasM = true
+					fld.RasterFields.HasZ = true
+				}
+
+				fld.RasterFields.XOrig = readFloat64(gdbtable)
+				fld.RasterFields.YOrig = readFloat64(gdbtable)
+				fld.RasterFields.XYScale = readFloat64(gdbtable)
+
+				if fld.RasterFields.HasM {
+					fld.RasterFields.MOrig = readFloat64(gdbtable)
+					fld.RasterFields.MScale = readFloat64(gdbtable)
+				}
+
+				if fld.RasterFields.HasZ {
+					fld.RasterFields.ZOrig = readFloat64(gdbtable)
+					fld.RasterFields.ZScale = readFloat64(gdbtable)
+				}
+
+				fld.RasterFields.XYTolerance = readFloat64(gdbtable)
+				if fld.RasterFields.HasM {
+					fld.RasterFields.MTolerance = readFloat64(gdbtable)
+				}
+				if fld.RasterFields.HasZ {
+					fld.RasterFields.ZTolerance = readFloat64(gdbtable)
+				}
+			}
+
+			fld.RasterFields.RasterType = readByte(gdbtable)
+
+		case 10, 11, 12: //UUID or XML
+			readByte(gdbtable) // width
+			flag := readByte(gdbtable)
+			if (flag & 1) == 0 {
+				fld.Nullable = false
+			}
+
+		case 13, 14, 15, 16: // Int64, DateOnly, TimeOnly, TimestampOffset
+			fld.Width = uint32(readByte(gdbtable))
+			flag := readByte(gdbtable)
+			if (flag & 1) == 0 {
+				fld.Nullable = false
+			}
+
+			defaultValueLength := readByte(gdbtable)
+			if (flag & 4) != 0 {
+				gdbtable.Seek(int64(defaultValueLength), 1) // default_value
+			}
+
+		default:
+			readByte(gdbtable) // width
+			flag := readByte(gdbtable)
+			if (flag & 1) == 0 {
+				fld.Nullable = false
+			}
+
+			defaultValueLength := readByte(gdbtable)
+
+			//TODO: What is this?
+			if (flag & 4) != 0 {
+				if fld.Type == 0 && defaultValueLength == 2 {
+					readInt16(gdbtable) // default_value
+				} else if fld.Type == 1 && defaultValueLength == 4 {
+					readInt32(gdbtable) // default_value
+				} else if fld.Type == 2 && defaultValueLength == 4 {
+					readFloat32(gdbtable) // default_value
+				} else if fld.Type == 3 && defaultValueLength == 8 {
+					readFloat64(gdbtable) // default_value
+				} else if fld.Type == 5 && defaultValueLength == 8 {
+					readFloat64(gdbtable) // default_value
+				} else {
+					gdbtable.Seek(int64(defaultValueLength), 1)
+				}
+			}
+		}
+
+		if fld.Nullable {
+			hasFlags = true
+			nullableFields++
+		}
+
+		if fld.Type != 6 {
+			flds = append(flds, fld)
+		} else {
+			oidName = fld.Name
+		}
+
+		if gdbtable.Err() == nil && gdbtable.offset() > rowsStart {
+			gdbtable.fail(fmt.Errorf("%w: field %d of %d runs past the end of the header at offset %d", ErrCorruptHeader, i+1, numFields, rowsStart))
+		}
+		if gdbtable.Err() != nil {
+			break
+		}
+	}
+	if err := gdbtable.Err(); err != nil {
+		gdbtable.Close()
+		if gdbtablx != nil {
+			gdbtablx.Close()
+		}
+		return BaseTable{}, fmt.Errorf("reading the fields of %s: %w", tableName, err)
+	}
+	bt := BaseTable{
+		tablePath,
+		tablxPath,
+		gdbtable,
+		gdbtablx,
+		numFeaturesX,
+		sizeTablxOffsets,
+		tablxBlocks,
+		nil,
+		nil,
+		flds,
+		hasFlags,
+		nullableFields,
+		make([]uint8, 0),
+		layerGeomType,
+		layerHasZ,
+		layerHasM,
+		oidName,
+		version,
+		ctx}
+	if gdbtablx == nil {
+		if tablxErr != nil {
+			slog.Warn("rebuilding row index", "table", tablePath, "err", tablxErr)
+		}
+		bt.offsets = bt.scanRows(rowsStart, mode == indexNone)
+		if err := ctx.Err(); err != nil {
+			bt.Close()
+			return BaseTable{}, err
+		}
+		if bt.offsets == nil {
+			bt.offsets = []int64{}
+		}
+		bt.NFeaturesX = uint32(len(bt.offsets))
+		slog.Info("rebuilt row index", "table", tablePath, "rows", len(bt.offsets))
+	}
+	if deletedWanted(ctx) && mode != indexNone {
+		bt.deleted = bt.readDeleted(fsys, gdbFilePath, tableName)
+		bt.NFeaturesX += uint32(len(bt.deleted))
+	}
+	slog.Debug("opened table", "table", tableName, "rows", bt.NFeaturesX, "fields", len(flds))
+	return bt, nil
+}
+
+// openTablx opens the .gdbtablx of table tableName and reads its header: the
+// number of rows it has offsets for and the bytes each offset takes. The
+// offsets come in blocks of 1024 rows, followed by a trailer of four uint32s:
+// the number of 32-bit words of a bitmap of blocks, 0 if there is none, the
+// number of bits in it, the number of blocks in the file again, and one more
+// word. A sparse .gdbtablx, that of a table with whole blocks of rows
+// deleted, leaves those blocks out and has the bitmap after the trailer, a
+// bit set for each block it keeps; openTablx then returns where each block
+// is among those kept, -1 for those left out, as GDAL reads it.
+//
+// It fails if the header does not make sense, the bitmap does not tell the
+// blocks there are, or the file is too short for them. A trailer that cannot
+// be read is only taken for one without a bitmap when the blocks are enough
+// for every row.
+func openTablx(fsys fs.FS, gdbFilePath string, tableName string) (*gdbFile, uint32, uint32, []int32, error) {
+	tablxPath := FilePath(gdbFilePath, tableName+".gdbtablx")
+	gdbtablx, err := openGDBFile(fsys, gdbFilePath, tableName+".gdbtablx")
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+
+	err = checkTablxMagic(gdbtablx)
+	num1024Blocks := readU32(gdbtablx)
+	numFeaturesX := readU32(gdbtablx)
+	sizeTablxOffsets := readU32(gdbtablx)
+	if err == nil {
+		err = gdbtablx.Err()
+	}
+	trailerOff := 16 + int64(num1024Blocks)*1024*int64(sizeTablxOffsets)
+	switch {
+	case err != nil:
+	case num1024Blocks == 0 && numFeaturesX != 0:
+		err = fmt.Errorf("%s: %w: %d rows in no blocks", tablxPath, ErrCorruptHeader, numFeaturesX)
+	case sizeTablxOffsets < 4 || sizeTablxOffsets > 8:
+		err = fmt.Errorf("%s: %w: offsets of %d bytes", tablxPath, ErrCorruptHeader, sizeTablxOffsets)
+	}
+	var blocks []int32
+	if err == nil && num1024Blocks != 0 {
+		blocks, err = readTablxBitmap(gdbtablx, trailerOff, num1024Blocks, numFeaturesX)
+	}
+	if err == nil {
+		offsetsEnd := trailerOff
+		if blocks == nil {
+			offsetsEnd = 16 + int64(numFeaturesX)*int64(sizeTablxOffsets)
+		}
+		if offsetsEnd > gdbtablx.size {
+			err = fmt.Errorf("%s: %d bytes, too short for %d offsets", tablxPath, gdbtablx.size, (offsetsEnd-16)/int64(sizeTablxOffsets))
+		}
+	}
+	if err != nil {
+		gdbtablx.Close()
+		return nil, 0, 0, nil, err
+	}
+	return gdbtablx, numFeaturesX, sizeTablxOffsets, blocks, nil
+}
+
+// readTablxBitmap reads the trailer of .gdbtablx f at off, after its blocks
+// of offsets, and the bitmap of blocks that follows it if there is one. It
+// returns where each block of 1024 rows is among the blocks of f, -1 for
+// those it leaves out, or nil if f has every block.
+func readTablxBitmap(f *gdbFile, off int64, blocks uint32, rows uint32) ([]int32, error) {
+	f.Seek(off, 0)
+	words := readU32(f)
+	bits := readU32(f)
+	blocksAgain := readU32(f)
+	readU32(f)
+	if err := f.Err(); err != nil {
+		f.clearErr()
+		if uint64(blocks)*1024 >= uint64(rows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s: %d rows in %d blocks, and no bitmap of blocks: %w", f.Name(), rows, blocks, err)
+	}
+	switch {
+	case blocksAgain != blocks:
+		return nil, fmt.Errorf("%s: %w: %d blocks in the header, %d in the trailer", f.Name(), ErrCorruptHeader, blocks, blocksAgain)
+	case words == 0 && bits != blocks:
+		return nil, fmt.Errorf("%s: %w: no bitmap, for %d blocks of %d", f.Name(), ErrCorruptHeader, blocks, bits)
+	case words == 0:
+		return nil, nil
+	case uint64(rows) > uint64(bits)*1024 || int64(bits) > (f.size-off-16)*8:
+		return nil, fmt.Errorf("%s: %w: a bitmap of %d blocks, for %d rows in a file of %d bytes", f.Name(), ErrCorruptHeader, bits, rows, f.size)
+	}
+	bitmap := readBytes(f, int(bits+7)/8)
+	if err := f.Err(); err != nil {
+		return nil, err
+	}
+	where := make([]int32, bits)
+	kept := int32(0)
+	for i := range where {
+		where[i] = -1
+		if bitmap[i/8]&(1<<(i%8)) != 0 {
+			where[i] = kept
+			kept++
+		}
+	}
+	if uint32(kept) != blocks {
+		return nil, fmt.Errorf("%s: %w: a bitmap of %d blocks, for %d", f.Name(), ErrCorruptHeader, kept, blocks)
+	}
+	return where, nil
+}
@@ -0,0 +1,225 @@
+package gdb_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/internal/gdbfixture"
+)
+
+// everyType is a table of a field of each type gdb reads, in version
+// version, whose second row is deleted and third has every nullable field
+// null.
+func everyType(version int32) gdbfixture.Table {
+	wkt := `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+	return gdbfixture.Table{
+		Name:    "every_type",
+		Version: version,
+		Fields: []gdbfixture.Field{
+			{Name: "short", Alias: "Short", Type: gdbfixture.TypeInt16, Nullable: true},
+			{Name: "long", Type: gdbfixture.TypeInt32},
+			{Name: "single", Type: gdbfixture.TypeFloat32, Nullable: true},
+			{Name: "double", Type: gdbfixture.TypeFloat64, Nullable: true},
+			{Name: "text", Type: gdbfixture.TypeString, Nullable: true, Width: 50},
+			{Name: "stamp", Type: gdbfixture.TypeDateTime, Nullable: true},
+			{Name: "blob", Type: gdbfixture.TypeBinary, Nullable: true},
+			{Name: "image", Type: gdbfixture.TypeRaster, Nullable: true, WKT: wkt},
+			{Name: "guid", Type: gdbfixture.TypeGUID, Nullable: true},
+			{Name: "globalid", Type: gdbfixture.TypeGlobalID},
+			{Name: "doc", Type: gdbfixture.TypeXML, Nullable: true},
+			{Name: "big", Type: gdbfixture.TypeInt64, Nullable: true},
+			{Name: "day", Type: gdbfixture.TypeDateOnly, Nullable: true},
+			{Name: "clock", Type: gdbfixture.TypeTimeOnly, Nullable: true},
+			{Name: "local", Type: gdbfixture.TypeTimestampOffset, Nullable: true},
+			{Name: "SHAPE", Type: gdbfixture.TypeShape, Nullable: true, WKT: wkt, GeometryType: 4},
+		},
+		Rows: [][]any{
+			{
+				int16(-12), int32(70000), float32(1.5), 2.25, "Mapunit Ω ✓",
+				time.Date(2019, 10, 15, 20, 3, 11, 0, time.UTC), []byte{0, 1, 2, 255}, int32(1),
+				gdb.GUID{1, 2, 3}, gdb.GUID{4, 5, 6}, "<metadata/>", int64(1) << 40,
+				gdb.Date{Time: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+				gdb.TimeOfDay(13*time.Hour + 5*time.Minute + 7250*time.Millisecond),
+				time.Date(2024, 3, 5, 14, 30, 0, 0, time.FixedZone("", -5*3600)),
+				gdb.Geometry{Type: 5, Parts: [][][2]float64{{{-77.1, 38.8}, {-77.1, 39}, {-76.9, 39}, {-76.9, 38.8}, {-77.1, 38.8}}}},
+			},
+			nil,
+			{
+				nil, int32(-3), nil, nil, nil, nil, nil, nil, nil, gdb.GUID{7}, nil, nil, nil, nil, nil, nil,
+			},
+		},
+	}
+}
+
+// openFixture opens table t of a geodatabase holding only it, under ctx.
+func openFixture(t *testing.T, ctx context.Context, table gdbfixture.Table) *gdb.BaseTable {
+	t.Helper()
+	fsys, err := gdbfixture.Geodatabase{Tables: []gdbfixture.Table{table}}.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt, err := gdb.NewBaseTableFS(ctx, fsys, "fixture.gdb", gdb.TableFileName(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(bt.Close)
+	return &bt
+}
+
+func TestReadTable(t *testing.T) {
+	for _, version := range []int32{gdbfixture.Version9, gdbfixture.Version10, gdbfixture.Version64Bit} {
+		t.Run(fmt.Sprint("version ", version), func(t *testing.T) {
+			table := everyType(version)
+			bt := openFixture(t, context.Background(), table)
+			if bt.Version != version {
+				t.Errorf("version %d, want %d", bt.Version, version)
+			}
+			if bt.OIDName != "OBJECTID" || bt.LayerGeomType != 4 {
+				t.Errorf("object ID %q and geometry type %d, want OBJECTID and 4", bt.OIDName, bt.LayerGeomType)
+			}
+			if len(bt.Fields) != len(table.Fields) {
+				t.Fatalf("%d fields, want %d", len(bt.Fields), len(table.Fields))
+			}
+			for i, f := range bt.Fields {
+				want := table.Fields[i]
+				if f.Name != want.Name || f.Alias != want.Alias || f.Type != want.Type || f.Nullable != want.Nullable {
+					t.Errorf("field %d is %s %q type %d nullable %v, want %s %q type %d nullable %v",
+						i, f.Name, f.Alias, f.Type, f.Nullable, want.Name, want.Alias, want.Type, want.Nullable)
+				}
+			}
+			checkRows(t, bt, table)
+		})
+	}
+}
+
+// TestReadTableRebuiltIndex reads the rows of tables through an index
+// rebuilt from their .gdbtable: the free block a deleted row leaves keeps
+// the object IDs after it those the .gdbtablx gives.
+func TestReadTableRebuiltIndex(t *testing.T) {
+	table := everyType(gdbfixture.Version10)
+	checkRows(t, openFixture(t, gdb.WithRebuiltIndex(context.Background()), table), table)
+}
+
+// checkRows checks that the rows of bt are those of table.
+func checkRows(t *testing.T, bt *gdb.BaseTable, table gdbfixture.Table) {
+	t.Helper()
+	rows := bt.Rows()
+	var fids []int
+	for row := range rows.All() {
+		fids = append(fids, row.FID)
+		want := table.Rows[row.FID-1]
+		for i, v := range row.Values {
+			if !sameValue(v, want[i]) {
+				t.Errorf("row %d, field %s: %v (%T), want %v (%T)", row.FID, table.Fields[i].Name, v, v, want[i], want[i])
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fids, []int{1, 3}) {
+		t.Errorf("rows %v, want [1 3]", fids)
+	}
+}
+
+// sameValue reports whether the value read v is the value written want:
+// times at the same instant and in the same offset from UTC, shapes of the
+// same type and points.
+func sameValue(v, want any) bool {
+	switch want := want.(type) {
+	case time.Time:
+		got, ok := v.(time.Time)
+		_, gotOffset := got.Zone()
+		_, wantOffset := want.Zone()
+		return ok && got.Equal(want) && gotOffset == wantOffset
+	case gdb.Date:
+		got, ok := v.(gdb.Date)
+		return ok && got.Equal(want.Time)
+	case gdb.Geometry:
+		got, ok := v.(gdb.Geometry)
+		if !ok || got.Type != want.Type || len(got.Parts) != len(want.Parts) {
+			return false
+		}
+		for i, p := range got.Parts {
+			if !slices.Equal(p, want.Parts[i]) {
+				return false
+			}
+		}
+		return true
+	case []byte:
+		got, ok := v.([]byte)
+		return ok && slices.Equal(got, want)
+	}
+	return v == want
+}
+
+// TestReconstructedOIDs checks that the object IDs of a table are flagged
+// as reconstructed when its rows are indexed from the .gdbtable, and only
+// then, 64-bit object IDs or not.
+func TestReconstructedOIDs(t *testing.T) {
+	for _, tc := range []struct {
+		version int32
+		rebuilt bool
+		want    bool
+	}{
+		{gdbfixture.Version10, false, false},
+		{gdbfixture.Version10, true, true},
+		{gdbfixture.Version64Bit, false, false},
+		{gdbfixture.Version64Bit, true, true},
+	} {
+		ctx := context.Background()
+		if tc.rebuilt {
+			ctx = gdb.WithRebuiltIndex(ctx)
+		}
+		bt := openFixture(t, ctx, everyType(tc.version))
+		if got := bt.ReconstructedOIDs(); got != tc.want {
+			t.Errorf("version %d, rebuilt index %v: reconstructed %v, want %v", tc.version, tc.rebuilt, got, tc.want)
+		}
+	}
+}
+
+// TestSparseTablx reads tables, of 10.x and of 64-bit object IDs as ArcGIS
+// Pro 3.2 writes them, whose second block of 1024 rows was deleted whole,
+// which their .gdbtablx leaves out, and rows of the others too: the rows
+// after keep their object IDs, read through the bitmap of blocks rather than
+// rebuilt.
+func TestSparseTablx(t *testing.T) {
+	for _, version := range []int32{gdbfixture.Version10, gdbfixture.Version64Bit} {
+		table := gdbfixture.Table{
+			Name:    "sparse",
+			Version: version,
+			Fields:  []gdbfixture.Field{{Name: "fid", Type: gdbfixture.TypeInt32}},
+		}
+		var want []int
+		for i := range 3000 {
+			if i%3 != 0 || i >= 1024 && i < 2048 {
+				table.Rows = append(table.Rows, nil)
+				continue
+			}
+			table.Rows = append(table.Rows, []any{int32(i + 1)})
+			want = append(want, i+1)
+		}
+		bt := openFixture(t, context.Background(), table)
+		if bt.ReconstructedOIDs() {
+			t.Errorf("version %d: object IDs reconstructed, want those of the .gdbtablx", version)
+		}
+		rows := bt.Rows()
+		var fids []int
+		for row := range rows.All() {
+			fids = append(fids, row.FID)
+			if row.Values[0] != int32(row.FID) {
+				t.Errorf("version %d: row %d holds the row of %v", version, row.FID, row.Values[0])
+			}
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(fids, want) {
+			t.Errorf("version %d: %d rows from %v, want %d from %v", version, len(fids), fids[:min(3, len(fids))], len(want), want[:3])
+		}
+	}
+}
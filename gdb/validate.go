@@ -0,0 +1,49 @@
+package gdb
+
+import (
+	"context"
+	"fmt"
+)
+
+// CheckTablx checks the header of the .gdbtablx of table tableName, which
+// opening the table would replace with an index rebuilt from its rows if
+// it is missing or damaged. It returns nil if it reads fine.
+func CheckTablx(ctx context.Context, gdbFilePath string, tableName string) error {
+	f, _, _, _, err := openTablx(sourceFS(ctx, gdbFilePath), gdbFilePath, tableName)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return nil
+}
+
+// CheckRow reads row fid (0-based) checking what reading it takes on trust:
+// that its offset lies inside the .gdbtable, that the size at the start of
+// the row ends before the file does, and that its values decode without
+// running past that size. It returns the row if it reads, its offset, 0 if
+// there is no such row, and a *RowError saying what failed, if anything.
+func (bt *BaseTable) CheckRow(fid int) (*Row, int64, error) {
+	bt.gdbTable.clearErr()
+	offset, err := bt.rowOffset(fid)
+	if offset == 0 || err != nil {
+		return nil, 0, err
+	}
+	f := bt.gdbTable
+	if offset < tableHeaderLen || offset > f.size-4 {
+		return nil, offset, &RowError{bt.GdbTablePath, fid + 1, fmt.Errorf("offset %d is outside the %d bytes of the file", offset, f.size)}
+	}
+	f.Seek(offset, 0)
+	size := int64(readU32(f))
+	end := offset + 4 + size
+	if end > f.size {
+		return nil, offset, &RowError{bt.GdbTablePath, fid + 1, fmt.Errorf("%d bytes at offset %d run past the end of the file at %d", size, offset, f.size)}
+	}
+	row, ok, err := bt.ReadRow(fid)
+	if !ok || err != nil {
+		return nil, offset, err
+	}
+	if f.offset() > end {
+		return nil, offset, &RowError{bt.GdbTablePath, fid + 1, fmt.Errorf("values at offset %d run to %d, past the %d bytes of the row", offset, f.offset(), size)}
+	}
+	return row, offset, nil
+}
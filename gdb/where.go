@@ -0,0 +1,171 @@
+package gdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Where selects the rows of a table whose field Column holds Value.
+type Where struct {
+	Column string
+	Value  string
+}
+
+// ParseWhere reads a Where written as FIELD=value, the value quoted with '
+// or " if it has spaces at its ends.
+func ParseWhere(s string) (Where, error) {
+	col, val, ok := strings.Cut(s, "=")
+	col, val = strings.TrimSpace(col), strings.TrimSpace(val)
+	if !ok || col == "" {
+		return Where{}, fmt.Errorf("%q is not FIELD=value", s)
+	}
+	if n := len(val); n >= 2 && (val[0] == '\'' || val[0] == '"') && val[n-1] == val[0] {
+		val = val[1 : n-1]
+	}
+	return Where{col, val}, nil
+}
+
+func (w Where) String() string {
+	return w.Column + "=" + strconv.Quote(w.Value)
+}
+
+// whereKey is the key of the context value holding the Where.
+type whereKey struct{}
+
+// WithWhere returns a copy of ctx under which the rows of the tables read
+// through RowIterator are only those w selects. A table without the field
+// yields no rows, Err saying why. The rows are looked up in an attribute
+// index over the field when the table has one and is read through its
+// .gdbtablx, and found by reading every row otherwise.
+func WithWhere(ctx context.Context, w Where) context.Context {
+	return context.WithValue(ctx, whereKey{}, w)
+}
+
+func whereOf(ctx context.Context) (Where, bool) {
+	w, ok := ctx.Value(whereKey{}).(Where)
+	return w, ok
+}
+
+// rowFilter tells whether a row read is one of those selected.
+type rowFilter interface {
+	match(row *Row) bool
+}
+
+// whereFilter is a Where bound to a table: the index of the field and the
+// value it must hold, as readValue decodes it.
+type whereFilter struct {
+	field int
+	want  interface{}
+}
+
+func (f *whereFilter) match(row *Row) bool {
+	if g, ok := row.Values[f.field].(GUID); ok {
+		return g.String() == f.want
+	}
+	return row.Values[f.field] == f.want
+}
+
+// where binds w to the table. It returns the rows to read when it can tell
+// them without reading the table, through the object id or an index, and
+// nil otherwise; the filter is nil when the rows are exactly those.
+func (bt *BaseTable) where(w Where) (rowFilter, []int, error) {
+	if bt.OIDName != "" && strings.EqualFold(w.Column, bt.OIDName) {
+		oid, err := strconv.Atoi(w.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("where %s: not an object id", w)
+		}
+		if oid < 1 || oid > int(bt.NFeaturesX) {
+			return nil, []int{}, nil
+		}
+		return nil, []int{oid - 1}, nil
+	}
+	field := -1
+	for i := range bt.Fields {
+		if strings.EqualFold(bt.Fields[i].Name, w.Column) {
+			field = i
+		}
+	}
+	if field < 0 {
+		return nil, nil, fmt.Errorf("where %s: %s has no field %s", w, bt.GdbTablePath, w.Column)
+	}
+	fld := &bt.Fields[field]
+	want, err := whereValue(fld, w.Value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("where %s: %w", w, err)
+	}
+	return &whereFilter{field, want}, bt.lookup(fld, want), nil
+}
+
+// whereValue converts s into the value of fld as readValue decodes it.
+func whereValue(fld *Field, s string) (interface{}, error) {
+	switch fld.Type {
+	case 0:
+		n, err := strconv.ParseInt(s, 10, 16)
+		return int16(n), err
+	case 1:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return int32(n), err
+	case 13:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return n, err
+	case 2:
+		f, err := strconv.ParseFloat(s, 32)
+		return float32(f), err
+	case 3:
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err
+	case 4, 12:
+		return s, nil
+	case 10, 11:
+		return "{" + strings.ToUpper(strings.Trim(s, "{}")) + "}", nil
+	}
+	return nil, fmt.Errorf("cannot select on %s, a field of type %d", fld.Name, fld.Type)
+}
+
+// lookup returns the 0-based fids of the rows an attribute index over fld
+// lists under want, or nil when there is no index to trust: none over the
+// field, one that cannot be read, or a table whose rows are not numbered
+// as its .gdbtablx numbers them.
+func (bt *BaseTable) lookup(fld *Field, want interface{}) []int {
+	if bt.gdbTablx == nil || len(bt.deleted) > 0 || keySize(fld) == 0 {
+		return nil
+	}
+	gdbFilePath, tableName := bt.location()
+	indexes, err := ReadIndexes(bt.Context(), gdbFilePath, tableName)
+	if err != nil {
+		slog.Warn("cannot read all indexes", "table", bt.GdbTablePath, "err", err)
+	}
+	for _, idx := range indexes {
+		if idx.Kind != "attribute" || len(idx.Columns) != 1 || !strings.EqualFold(idx.Columns[0], fld.Name) {
+			continue
+		}
+		ix, err := openAttributeIndex(sourceFS(bt.Context(), gdbFilePath), gdbFilePath, tableName, idx, fld)
+		if err != nil {
+			slog.Warn("cannot use index, reading every row", "index", idx.Name, "err", err)
+			continue
+		}
+		fids, err := ix.lookup(want)
+		ix.Close()
+		if err != nil {
+			slog.Warn("cannot use index, reading every row", "index", idx.Name, "err", err)
+			continue
+		}
+		slog.Debug("looked up rows", "table", bt.GdbTablePath, "index", idx.Name, "rows", len(fids))
+		return fids
+	}
+	return nil
+}
+
+// location returns the geodatabase path and the table name the table was
+// opened with.
+func (bt *BaseTable) location() (string, string) {
+	base := len(TableFileName(0) + ".gdbtable")
+	if len(bt.GdbTablePath) < base {
+		return "", ""
+	}
+	dir := bt.GdbTablePath[:len(bt.GdbTablePath)-base]
+	return dir, bt.GdbTablePath[len(dir) : len(dir)+len(TableFileName(0))]
+}
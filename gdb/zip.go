@@ -0,0 +1,132 @@
+package gdb
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// zipFS is the .gdb directory inside the zip archive at this path or URL.
+// Every Open opens the archive afresh, so each table owns its file handle and
+// closing it leaves the other tables alone.
+//
+// Members stored without compression (zip -0) are read in place. Compressed
+// ones cannot be read at an offset, so openGDBFile reads them into memory:
+// the largest table opened at once, usually a raster's block table, then has
+// to fit in memory.
+type zipFS struct {
+	archive string
+	ctx     context.Context // for archives read over the network
+}
+
+// isZip reports whether gdbFilePath names a zip archive rather than a .gdb
+// directory.
+func isZip(gdbFilePath string) bool {
+	p := strings.TrimRight(gdbFilePath, "/"+string(os.PathSeparator))
+	return strings.HasSuffix(strings.ToLower(p), ".zip")
+}
+
+// archiveFile is an open zip archive, local or remote.
+type archiveFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// openArchive opens the archive at path, which may be a URL, and returns its
+// size.
+func openArchive(ctx context.Context, path string) (archiveFile, int64, error) {
+	if isRemote(path) {
+		f, err := openRemote(ctx, path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return f, f.size, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// zipMember is a stored member of an archive, read in place.
+type zipMember struct {
+	*io.SectionReader
+	f    archiveFile
+	info fs.FileInfo
+}
+
+func (m *zipMember) Stat() (fs.FileInfo, error) { return m.info, nil }
+func (m *zipMember) Close() error               { return m.f.Close() }
+
+// zipStream is a compressed member of an archive, which closes the archive
+// with it.
+type zipStream struct {
+	fs.File
+	f archiveFile
+}
+
+func (s *zipStream) Close() error {
+	s.File.Close()
+	return s.f.Close()
+}
+
+func (z zipFS) Open(name string) (fs.File, error) {
+	archive := strings.TrimRight(z.archive, "/"+string(os.PathSeparator))
+	f, size, err := openArchive(z.ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", archive, err)
+	}
+
+	// The .gdb directory is wherever the master table is, so archives of
+	// the directory itself and of its parent both work.
+	dir := ""
+	found := false
+	for _, zf := range zr.File {
+		if path.Base(zf.Name) == masterTableFileName+".gdbtable" {
+			dir, found = path.Dir(zf.Name), true
+			break
+		}
+	}
+	if !found {
+		f.Close()
+		return nil, fmt.Errorf("%s: no geodatabase in the archive", archive)
+	}
+
+	for _, zf := range zr.File {
+		if zf.Name != path.Join(dir, name) {
+			continue
+		}
+		if zf.Method == zip.Store {
+			off, err := zf.DataOffset()
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			return &zipMember{io.NewSectionReader(f, off, int64(zf.UncompressedSize64)), f, zf.FileInfo()}, nil
+		}
+		rc, err := zr.Open(zf.Name)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &zipStream{rc, f}, nil
+	}
+	f.Close()
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
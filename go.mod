@@ -0,0 +1,3 @@
+module github.com/albrazeau/goRasterRescue
+
+go 1.23
@@ -0,0 +1,84 @@
+// Package gdbfixture builds small file geodatabases from scratch: tables of
+// any of the field types gdb reads, as 9.x, 10.x or 64-bit object ID tables,
+// and rasters of any data type in blocks uncompressed or lz77 compressed,
+// with the master table listing them. It stands in for a real geodatabase,
+// tens or hundreds of megabytes of one, wherever the readers need one to
+// read, as gdb.OpenFS does the fstest.MapFS of Files, or as a directory
+// written by WriteDir.
+//
+// What it writes is laid out as simply as the format allows: rows one after
+// the other, free space only where a row was deleted, and no indexes or
+// GDB_Items table.
+package gdbfixture
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing/fstest"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// A Geodatabase lists what to write: its tables, and its rasters, which
+// each take four tables after them.
+type Geodatabase struct {
+	Tables  []Table
+	Rasters []Raster
+}
+
+// Files returns the files of g, by name: the .gdbtable and .gdbtablx of the
+// master table, a00000001, and of each table after it, numbered from 2 in
+// the order of Tables then Rasters. The first table the master table lists
+// is GDB_SystemCatalog, itself.
+func (g Geodatabase) Files() (fstest.MapFS, error) {
+	tables := slices.Clone(g.Tables)
+	for _, r := range g.Rasters {
+		rt, err := r.Tables()
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, rt...)
+	}
+	master := Table{
+		Name: "GDB_SystemCatalog",
+		Fields: []Field{
+			{Name: "Name", Type: TypeString, Width: 160},
+			{Name: "FileFormat", Type: TypeInt32},
+		},
+		Rows: [][]any{{"GDB_SystemCatalog", int32(0)}},
+	}
+	for _, t := range tables {
+		master.Rows = append(master.Rows, []any{t.Name, int32(0)})
+	}
+
+	fsys := fstest.MapFS{}
+	for i, t := range append([]Table{master}, tables...) {
+		table, tablx, err := t.Files()
+		if err != nil {
+			return nil, err
+		}
+		name := gdb.TableFileName(i + 1)
+		fsys[name+".gdbtable"] = &fstest.MapFile{Data: table, Mode: 0o644}
+		fsys[name+".gdbtablx"] = &fstest.MapFile{Data: tablx, Mode: 0o644}
+	}
+	return fsys, nil
+}
+
+// WriteDir writes the files of g to dir, a directory ending in .gdb for
+// ArcGIS and GDAL to take it for a geodatabase, creating it if need be.
+func (g Geodatabase) WriteDir(dir string) error {
+	fsys, err := g.Files()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, f := range fsys {
+		if err := os.WriteFile(filepath.Join(dir, name), f.Data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
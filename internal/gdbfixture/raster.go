@@ -0,0 +1,219 @@
+package gdbfixture
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// A Raster is a raster dataset to write, as the four tables ArcGIS keeps
+// one in: the raster table itself, with a RASTER field, and its fras_ras_,
+// fras_bnd_ and fras_blk_ tables. Only full resolution blocks are written,
+// no pyramid levels, aux table or value attribute table.
+type Raster struct {
+	Name string
+	// As the raster package names data types: 1bit, 4bit, int8, uint8,
+	// int16, uint16, int32, uint32, float32 or 64bit.
+	DataType string
+	// Of the blocks: uncompressed or lz77.
+	Compression             string
+	Width, Height           int
+	BlockWidth, BlockHeight int
+	// The top left corner of the raster and the size of its square cells.
+	MinX, MaxY, CellSize float64
+	WKT                  string
+	// The pixels of each band, row by row, Width*Height of them. A pixel
+	// equal to NoData, if given, is written as without data, and a block
+	// without any is left out of the block table.
+	Bands  [][]float64
+	NoData *float64
+}
+
+// dataTypeBytes gives bytes 2 and 3 of band_types, the data type of a band.
+var dataTypeBytes = map[string][2]byte{
+	"1bit": {0x08, 0x00}, "4bit": {0x20, 0x00}, "int8": {0x41, 0x00}, "uint8": {0x40, 0x00},
+	"int16": {0x81, 0x00}, "uint16": {0x80, 0x00}, "int32": {0x01, 0x01}, "uint32": {0x00, 0x01},
+	"float32": {0x02, 0x01}, "64bit": {0x00, 0x02},
+}
+
+// compressionByte gives byte 1 of band_types, the compression of the blocks.
+var compressionByte = map[string]byte{"uncompressed": 0x00, "lz77": 0x04}
+
+// Tables returns the tables of r: the raster table, fras_ras_, fras_bnd_
+// and fras_blk_, in that order.
+func (r Raster) Tables() ([]Table, error) {
+	dt, ok := dataTypeBytes[r.DataType]
+	if !ok {
+		return nil, fmt.Errorf("raster %s: data type %q is not one the generator writes", r.Name, r.DataType)
+	}
+	comp, ok := compressionByte[r.Compression]
+	if !ok {
+		return nil, fmt.Errorf("raster %s: compression %q is not one the generator writes", r.Name, r.Compression)
+	}
+	if r.Width <= 0 || r.Height <= 0 || r.BlockWidth <= 0 || r.BlockHeight <= 0 {
+		return nil, fmt.Errorf("raster %s: a size or block size of 0", r.Name)
+	}
+	for i, band := range r.Bands {
+		if len(band) != r.Width*r.Height {
+			return nil, fmt.Errorf("raster %s, band %d: %d pixels for %dx%d", r.Name, i+1, len(band), r.Width, r.Height)
+		}
+	}
+
+	raster := Table{
+		Name: r.Name,
+		Fields: []Field{
+			{Name: "RASTER", Type: TypeRaster, Nullable: true, WKT: r.WKT},
+		},
+		Rows: [][]any{{int32(1)}},
+	}
+	ras := Table{
+		Name: "fras_ras_" + r.Name,
+		Fields: []Field{
+			{Name: "raster_flags", Type: TypeInt32, Nullable: true},
+			{Name: "description", Type: TypeString, Nullable: true, Width: 65},
+			{Name: "storage_def", Type: TypeBinary, Nullable: true},
+		},
+		Rows: [][]any{{nil, nil, nil}},
+	}
+
+	bnd := Table{Name: "fras_bnd_" + r.Name}
+	for _, name := range []string{"sequence_nbr", "raster_id", "name", "band_flags", "band_width", "band_height",
+		"band_types", "block_width", "block_height", "block_origin_x", "block_origin_y",
+		"eminx", "eminy", "emaxx", "emaxy", "cdate", "mdate", "srid"} {
+		f := Field{Name: name, Type: TypeInt32, Nullable: true}
+		switch name {
+		case "name":
+			f.Type, f.Width = TypeString, 65
+		case "block_origin_x", "block_origin_y", "eminx", "eminy", "emaxx", "emaxy":
+			f.Type = TypeFloat64
+		}
+		bnd.Fields = append(bnd.Fields, f)
+	}
+	// The extent of a band is that of the centres of its corner pixels, and
+	// its blocks start at the top left one.
+	half := r.CellSize / 2
+	eminx, emaxy := r.MinX+half, r.MaxY-half
+	emaxx := r.MinX + (float64(r.Width)-0.5)*r.CellSize
+	eminy := r.MaxY - (float64(r.Height)-0.5)*r.CellSize
+	bandTypes := int32(binary.LittleEndian.Uint32([]byte{0, comp, dt[0], dt[1]}))
+	for i := range r.Bands {
+		bnd.Rows = append(bnd.Rows, []any{
+			int32(i + 1), int32(1), fmt.Sprintf("Band_%d", i+1), int32(0),
+			int32(r.Width), int32(r.Height), bandTypes, int32(r.BlockWidth), int32(r.BlockHeight),
+			eminx, emaxy, eminx, eminy, emaxx, emaxy, int32(0), int32(0), int32(0),
+		})
+	}
+
+	blk := Table{
+		Name: "fras_blk_" + r.Name,
+		Fields: []Field{
+			{Name: "rasterband_id", Type: TypeInt32, Nullable: true},
+			{Name: "rrd_factor", Type: TypeInt32, Nullable: true},
+			{Name: "row_nbr", Type: TypeInt32, Nullable: true},
+			{Name: "col_nbr", Type: TypeInt32, Nullable: true},
+			{Name: "block_data", Type: TypeBinary, Nullable: true},
+			{Name: "block_key", Type: TypeString, Nullable: true, Width: 22},
+		},
+	}
+	for i := range r.Bands {
+		for row := 0; row*r.BlockHeight < r.Height; row++ {
+			for col := 0; col*r.BlockWidth < r.Width; col++ {
+				data, ok, err := r.block(i, row, col)
+				if err != nil {
+					return nil, fmt.Errorf("raster %s, band %d, block %d,%d: %w", r.Name, i+1, row, col, err)
+				}
+				if !ok {
+					continue
+				}
+				key := fmt.Sprintf("%08X%02X%04X%04X", i+1, 0, row, col)
+				blk.Rows = append(blk.Rows, []any{int32(i + 1), int32(0), int32(row), int32(col), data, key})
+			}
+		}
+	}
+	return []Table{raster, ras, bnd, blk}, nil
+}
+
+// block returns the stored block at row and col of band i: its pixels,
+// big-endian, and the mask of those with data, compressed as the raster is.
+// It reports false for a block without any pixel with data.
+func (r Raster) block(i int, row, col int) ([]byte, bool, error) {
+	n := r.BlockWidth * r.BlockHeight
+	vals := make([]float64, n)
+	valid := make([]bool, n)
+	some := false
+	for y := range r.BlockHeight {
+		for x := range r.BlockWidth {
+			py, px := row*r.BlockHeight+y, col*r.BlockWidth+x
+			if py >= r.Height || px >= r.Width {
+				continue
+			}
+			v := r.Bands[i][py*r.Width+px]
+			vals[y*r.BlockWidth+x] = v
+			if r.NoData == nil || v != *r.NoData {
+				valid[y*r.BlockWidth+x] = true
+				some = true
+			}
+		}
+	}
+	if !some {
+		return nil, false, nil
+	}
+
+	var b []byte
+	be := binary.BigEndian
+	switch r.DataType {
+	case "1bit":
+		b = make([]byte, (n+7)/8)
+		for p, v := range vals {
+			b[p>>3] |= (uint8(v) & 1) << (7 - uint(p&7))
+		}
+	case "4bit":
+		b = make([]byte, (n+1)/2)
+		for p, v := range vals {
+			b[p>>1] |= (uint8(v) & 0x0F) << (4 * uint(1-p&1))
+		}
+	default:
+		for _, v := range vals {
+			switch r.DataType {
+			case "int8":
+				b = append(b, byte(int8(v)))
+			case "uint8":
+				b = append(b, uint8(v))
+			case "int16":
+				b = be.AppendUint16(b, uint16(int16(v)))
+			case "uint16":
+				b = be.AppendUint16(b, uint16(v))
+			case "int32":
+				b = be.AppendUint32(b, uint32(int32(v)))
+			case "uint32":
+				b = be.AppendUint32(b, uint32(v))
+			case "float32":
+				b = be.AppendUint32(b, math.Float32bits(float32(v)))
+			default:
+				b = be.AppendUint64(b, math.Float64bits(v))
+			}
+		}
+	}
+	mask := make([]byte, (n+7)/8)
+	for p, ok := range valid {
+		if ok {
+			mask[p>>3] |= 1 << (7 - uint(p&7))
+		}
+	}
+	b = append(b, mask...)
+
+	if r.Compression != "lz77" {
+		return b, true, nil
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	if _, err := zw.Write(b); err != nil {
+		return nil, false, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, false, err
+	}
+	return z.Bytes(), true, nil
+}
@@ -0,0 +1,494 @@
+package gdbfixture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+	"unicode/utf16"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// The field types a Table can have, as the header of a .gdbtable numbers
+// them. The object ID is not one: every table gets one, first.
+const (
+	TypeInt16    uint8 = 0
+	TypeInt32    uint8 = 1
+	TypeFloat32  uint8 = 2
+	TypeFloat64  uint8 = 3
+	TypeString   uint8 = 4
+	TypeDateTime uint8 = 5
+	TypeShape    uint8 = 7
+	TypeBinary   uint8 = 8
+	TypeRaster   uint8 = 9
+	TypeGUID     uint8 = 10
+	TypeGlobalID uint8 = 11
+	TypeXML      uint8 = 12
+	TypeInt64    uint8 = 13
+
+	TypeDateOnly        uint8 = 14
+	TypeTimeOnly        uint8 = 15
+	TypeTimestampOffset uint8 = 16
+)
+
+// The versions a Table can be written in, as the field descriptions of a
+// .gdbtable give them: 9.x tables store the values of string fields as
+// UTF-16LE rather than UTF-8, and the tables with 64-bit object IDs of
+// ArcGIS Pro 3.2 have magic number 4 rather than 3 in both their files.
+const (
+	Version9     int32 = 3
+	Version10    int32 = 4
+	Version64Bit int32 = 6
+)
+
+// A Field is a column of a Table. Its values in the rows are Go values of
+// the types gdb reads them as: int16, int32, float32, float64, string for
+// String and XML, time.Time for DateTime, in UTC, and for TimestampOffset,
+// in its own zone, gdb.Geometry, []byte, int32 raster IDs for Raster,
+// gdb.GUID, int64, gdb.Date and gdb.TimeOfDay; nil is null, for nullable
+// fields.
+type Field struct {
+	Name     string
+	Alias    string
+	Type     uint8
+	Nullable bool
+	Width    uint32 // of a String, in characters; 0 for 255
+
+	// Of Shape and Raster fields: the coordinate system.
+	WKT string
+	// Of a Shape field: the layer geometry type, 1 point, 2 multipoint, 3
+	// polyline or 4 polygon, and how coordinates are stored, as varints
+	// of (x-XOrig)*XYScale. An XYScale of 0 takes the smallest coordinates
+	// of the rows, less one, as the origin and 10000 as the scale.
+	GeometryType          uint8
+	XOrig, YOrig, XYScale float64
+}
+
+// freeBlock is the size of the free space written in place of a deleted
+// row, after its negated size.
+const freeBlock = 8
+
+// A Table is a table to write: its fields, after the object ID, and its
+// rows, one value per field. A nil row is a row deleted: its object ID is
+// left unused, and in its place in the .gdbtable is a block of free space,
+// as a deleted row leaves. Version is that of the table, Version10 if 0.
+type Table struct {
+	Name    string
+	Version int32
+	Fields  []Field
+	Rows    [][]any
+}
+
+// Files returns the .gdbtable and .gdbtablx of t: the 40 bytes of the
+// header, magic number 3, then the field descriptions, version 4, the rows
+// one after the other, and the offsets of the rows in 5 bytes each, in
+// blocks of 1024, followed by a trailer. Blocks whose rows were all deleted
+// are left out, making the .gdbtablx sparse, with a bitmap of the blocks
+// kept after the trailer. A table of another version only differs by its
+// magic number and version, and by its strings.
+func (t Table) Files() ([]byte, []byte, error) {
+	if t.Version == 0 {
+		t.Version = Version10
+	}
+	if t.Version != Version9 && t.Version != Version10 && t.Version != Version64Bit {
+		return nil, nil, fmt.Errorf("table %s: version %d is not one the generator writes", t.Name, t.Version)
+	}
+	magic := uint32(3)
+	if t.Version == Version64Bit {
+		magic = 4
+	}
+	t.Fields = slices.Clone(t.Fields)
+	for i := range t.Fields {
+		if f := &t.Fields[i]; f.Type == TypeShape && f.XYScale == 0 {
+			f.XOrig, f.YOrig, f.XYScale = shapeOrigin(t, i)
+		}
+	}
+	fields, err := t.fieldDescriptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	var table bytes.Buffer
+	table.Write(make([]byte, 40))
+	table.Write(fields)
+
+	offsets := make([]int64, len(t.Rows))
+	live, largest := 0, 0
+	for fid, row := range t.Rows {
+		if row == nil {
+			size := int32(-freeBlock)
+			table.Write(binary.LittleEndian.AppendUint32(nil, uint32(size)))
+			table.Write(make([]byte, freeBlock))
+			continue
+		}
+		b, err := t.row(row)
+		if err != nil {
+			return nil, nil, fmt.Errorf("table %s, row %d: %w", t.Name, fid+1, err)
+		}
+		offsets[fid] = int64(table.Len())
+		table.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(b))))
+		table.Write(b)
+		live, largest = live+1, max(largest, len(b))
+	}
+
+	header := table.Bytes()[:40]
+	le := binary.LittleEndian
+	le.PutUint32(header, magic)
+	le.PutUint32(header[4:], uint32(live))
+	le.PutUint32(header[8:], uint32(largest))
+	le.PutUint32(header[12:], 5)
+	le.PutUint64(header[24:], uint64(table.Len()))
+	le.PutUint64(header[32:], 40)
+
+	blocks := (len(offsets) + 1023) / 1024
+	var kept []int
+	for b := range blocks {
+		if slices.ContainsFunc(offsets[b*1024:min(b*1024+1024, len(offsets))], func(off int64) bool { return off != 0 }) {
+			kept = append(kept, b)
+		}
+	}
+	sparse := len(kept) < blocks
+	words := (blocks + 31) / 32
+	size := 16 + len(kept)*1024*5 + 16
+	if sparse {
+		size += words * 4
+	}
+	tablx := make([]byte, size)
+	le.PutUint32(tablx, magic)
+	le.PutUint32(tablx[4:], uint32(len(kept)))
+	le.PutUint32(tablx[8:], uint32(len(offsets)))
+	le.PutUint32(tablx[12:], 5)
+	for slot, b := range kept {
+		for i, off := range offsets[b*1024 : min(b*1024+1024, len(offsets))] {
+			var o [8]byte
+			le.PutUint64(o[:], uint64(off))
+			copy(tablx[16+5*(slot*1024+i):], o[:5])
+		}
+	}
+	trailer := tablx[16+len(kept)*1024*5:]
+	le.PutUint32(trailer[4:], uint32(blocks))
+	le.PutUint32(trailer[8:], uint32(len(kept)))
+	if sparse {
+		le.PutUint32(trailer, uint32(words))
+		for _, b := range kept {
+			trailer[16+b/8] |= 1 << (b % 8)
+		}
+	}
+	return table.Bytes(), tablx, nil
+}
+
+// fieldDescriptions returns the field descriptions of t: their size, the
+// version, the geometry type of the layer, the number of fields and each
+// field.
+func (t Table) fieldDescriptions() ([]byte, error) {
+	var geomType uint32
+	b := []byte{}
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(t.Fields)+1))
+	b = appendName(b, "OBJECTID")
+	b = appendName(b, "")
+	b = append(b, 6, 4, 2)
+	for _, f := range t.Fields {
+		b = appendName(b, f.Name)
+		b = appendName(b, f.Alias)
+		b = append(b, f.Type)
+		flag := uint8(2)
+		if f.Nullable {
+			flag |= 1
+		}
+		switch f.Type {
+		case TypeInt16, TypeInt32, TypeFloat32, TypeFloat64, TypeDateTime:
+			b = append(b, []uint8{2, 4, 4, 8, 0, 8}[f.Type], flag, 0)
+		case TypeString:
+			width := f.Width
+			if width == 0 {
+				width = 255
+			}
+			b = binary.LittleEndian.AppendUint32(b, width)
+			b = append(b, flag, 0)
+		case TypeShape:
+			geomType = uint32(f.GeometryType)
+			b = append(b, 0, flag|4)
+			b = appendWKT(b, f.WKT)
+			b = append(b, 1)
+			xmin, ymin, xmax, ymax := shapeExtent(t, f)
+			for _, v := range []float64{f.XOrig, f.YOrig, f.XYScale, 1 / f.XYScale, xmin, ymin, xmax, ymax} {
+				b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+			}
+			// The spatial index grid, which is not written: one level, as
+			// wide as the layer.
+			b = append(b, 0)
+			b = binary.LittleEndian.AppendUint32(b, 1)
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(max(xmax-xmin, ymax-ymin, 1)))
+		case TypeBinary:
+			b = append(b, 0, flag)
+		case TypeRaster:
+			b = append(b, 0, flag)
+			b = appendName(b, f.Name)
+			b = appendWKT(b, f.WKT)
+			b = append(b, 0, 1) // no origin and scale, a managed raster
+		case TypeGUID, TypeGlobalID:
+			b = append(b, 38, flag)
+		case TypeXML:
+			b = append(b, 0, flag)
+		case TypeInt64, TypeDateOnly, TypeTimeOnly:
+			b = append(b, 8, flag, 0)
+		case TypeTimestampOffset:
+			b = append(b, 10, flag, 0)
+		default:
+			return nil, fmt.Errorf("table %s, field %s: type %d is not one the generator writes", t.Name, f.Name, f.Type)
+		}
+	}
+	head := binary.LittleEndian.AppendUint32(nil, 0)
+	head = binary.LittleEndian.AppendUint32(head, uint32(t.Version))
+	head = binary.LittleEndian.AppendUint32(head, geomType)
+	head = append(head, b...)
+	binary.LittleEndian.PutUint32(head, uint32(len(head)-4))
+	return head, nil
+}
+
+// row returns the stored values of row: the null flags of the nullable
+// fields, then the value of each field not null.
+func (t Table) row(row []any) ([]byte, error) {
+	if len(row) != len(t.Fields) {
+		return nil, fmt.Errorf("%d values for %d fields", len(row), len(t.Fields))
+	}
+	nullable := 0
+	for _, f := range t.Fields {
+		if f.Nullable {
+			nullable++
+		}
+	}
+	flags := make([]byte, (nullable+7)/8)
+	var b []byte
+	i := 0
+	for j, f := range t.Fields {
+		v := row[j]
+		if f.Nullable {
+			if v == nil {
+				flags[i/8] |= 1 << (i % 8)
+			}
+			i++
+		}
+		if v == nil {
+			if !f.Nullable {
+				return nil, fmt.Errorf("field %s is not nullable", f.Name)
+			}
+			continue
+		}
+		var err error
+		if b, err = t.appendValue(b, &f, v); err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+	}
+	return append(flags, b...), nil
+}
+
+// appendValue appends the stored value v of field f of t to b.
+func (t Table) appendValue(b []byte, f *Field, v any) ([]byte, error) {
+	le := binary.LittleEndian
+	switch v := v.(type) {
+	case int16:
+		if f.Type == TypeInt16 {
+			return le.AppendUint16(b, uint16(v)), nil
+		}
+	case int32:
+		if f.Type == TypeInt32 || f.Type == TypeRaster {
+			return le.AppendUint32(b, uint32(v)), nil
+		}
+	case float32:
+		if f.Type == TypeFloat32 {
+			return le.AppendUint32(b, math.Float32bits(v)), nil
+		}
+	case float64:
+		if f.Type == TypeFloat64 {
+			return le.AppendUint64(b, math.Float64bits(v)), nil
+		}
+	case string:
+		if f.Type == TypeString && t.Version == Version9 {
+			var u []byte
+			for _, c := range utf16.Encode([]rune(v)) {
+				u = le.AppendUint16(u, c)
+			}
+			return append(appendVarUint(b, uint64(len(u))), u...), nil
+		}
+		if f.Type == TypeString || f.Type == TypeXML {
+			return append(appendVarUint(b, uint64(len(v))), v...), nil
+		}
+	case time.Time:
+		if f.Type == TypeDateTime {
+			return le.AppendUint64(b, math.Float64bits(days(v))), nil
+		}
+		if f.Type == TypeTimestampOffset {
+			// The clock time in the zone of v, then the zone in minutes
+			// east of UTC.
+			_, offset := v.Zone()
+			b = le.AppendUint64(b, math.Float64bits(days(v.Add(time.Duration(offset)*time.Second))))
+			return le.AppendUint16(b, uint16(int16(offset/60))), nil
+		}
+	case gdb.Date:
+		if f.Type == TypeDateOnly {
+			return le.AppendUint64(b, math.Float64bits(days(v.Time))), nil
+		}
+	case gdb.TimeOfDay:
+		if f.Type == TypeTimeOnly {
+			return le.AppendUint64(b, math.Float64bits(float64(v)/float64(24*time.Hour))), nil
+		}
+	case gdb.Geometry:
+		if f.Type == TypeShape {
+			blob, err := geometryBlob(f, v)
+			if err != nil {
+				return nil, err
+			}
+			return append(appendVarUint(b, uint64(len(blob))), blob...), nil
+		}
+	case []byte:
+		if f.Type == TypeBinary {
+			return append(appendVarUint(b, uint64(len(v))), v...), nil
+		}
+	case gdb.GUID:
+		if f.Type == TypeGUID || f.Type == TypeGlobalID {
+			return append(b, v[:]...), nil
+		}
+	case int64:
+		if f.Type == TypeInt64 {
+			return le.AppendUint64(b, uint64(v)), nil
+		}
+	}
+	return nil, fmt.Errorf("a %T is not a value of a field of type %d", v, f.Type)
+}
+
+// days returns t as dates and times are stored: in days since 1899-12-30,
+// in UTC.
+func days(t time.Time) float64 {
+	return float64(t.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC))) / float64(24*time.Hour)
+}
+
+// geometryBlob returns the shape blob of g, of a point, multipoint,
+// polyline or polygon: for a point its coordinates, else the number of
+// points, of parts but for a multipoint, the bounding box, the points of
+// each part but the last and the coordinates, delta encoded.
+func geometryBlob(f *Field, g gdb.Geometry) ([]byte, error) {
+	scaled := func(v, orig float64) int64 { return int64(math.Round((v - orig) * f.XYScale)) }
+	if gdb.GeometryHasZ(g.Type) || gdb.GeometryHasM(g.Type) {
+		return nil, fmt.Errorf("shapes of type %d, with Z or M values, are not ones the generator writes", g.Type)
+	}
+	b := appendVarUint(nil, g.Type)
+	if gdb.IsPointType(g.Type) {
+		if len(g.Parts) == 0 {
+			return append(b, 0, 0), nil
+		}
+		pt := g.Parts[0][0]
+		b = appendVarUint(b, uint64(scaled(pt[0], f.XOrig))+1)
+		return appendVarUint(b, uint64(scaled(pt[1], f.YOrig))+1), nil
+	}
+	if !gdb.IsPolylineType(g.Type) && !gdb.IsPolygonType(g.Type) && !gdb.IsMultiPointType(g.Type) {
+		return nil, fmt.Errorf("shapes of type %d are not ones the generator writes", g.Type)
+	}
+	n := 0
+	for _, p := range g.Parts {
+		n += len(p)
+	}
+	b = appendVarUint(b, uint64(n))
+	if n == 0 {
+		return b, nil
+	}
+	if !gdb.IsMultiPointType(g.Type) {
+		b = appendVarUint(b, uint64(len(g.Parts)))
+	} else if len(g.Parts) != 1 {
+		return nil, fmt.Errorf("a multipoint of %d parts", len(g.Parts))
+	}
+	xmin, ymin, xmax, ymax := g.Bounds()
+	b = appendVarUint(b, uint64(scaled(xmin, f.XOrig)))
+	b = appendVarUint(b, uint64(scaled(ymin, f.YOrig)))
+	b = appendVarUint(b, uint64(scaled(xmax, xmin)))
+	b = appendVarUint(b, uint64(scaled(ymax, ymin)))
+	if !gdb.IsMultiPointType(g.Type) {
+		for _, p := range g.Parts[:len(g.Parts)-1] {
+			b = appendVarUint(b, uint64(len(p)))
+		}
+	}
+	var x, y int64
+	for _, p := range g.Parts {
+		for _, pt := range p {
+			px, py := scaled(pt[0], f.XOrig), scaled(pt[1], f.YOrig)
+			b = appendVarInt(b, px-x)
+			b = appendVarInt(b, py-y)
+			x, y = px, py
+		}
+	}
+	return b, nil
+}
+
+// shapeOrigin returns the origin and scale of the coordinates of shape
+// field i of t when none are given: the smallest coordinates of its rows,
+// less one, and 10000.
+func shapeOrigin(t Table, i int) (float64, float64, float64) {
+	xmin, ymin, _, _ := shapeExtent(t, t.Fields[i])
+	if math.IsInf(xmin, 0) {
+		return 0, 0, 10000
+	}
+	return math.Floor(xmin) - 1, math.Floor(ymin) - 1, 10000
+}
+
+// shapeExtent returns the envelope of the shapes of field f in the rows of
+// t, infinite and inverted if there are none.
+func shapeExtent(t Table, f Field) (float64, float64, float64, float64) {
+	xmin, ymin, xmax, ymax := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, row := range t.Rows {
+		for j, v := range row {
+			if g, ok := v.(gdb.Geometry); ok && t.Fields[j].Name == f.Name {
+				x0, y0, x1, y1 := g.Bounds()
+				xmin, ymin, xmax, ymax = min(xmin, x0), min(ymin, y0), max(xmax, x1), max(ymax, y1)
+			}
+		}
+	}
+	return xmin, ymin, xmax, ymax
+}
+
+// appendName appends s as names and aliases are stored: its length in UTF-16
+// code units as a byte, then the code units.
+func appendName(b []byte, s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b = append(b, uint8(len(units)))
+	for _, u := range units {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// appendWKT appends wkt as coordinate systems are stored: its length in
+// bytes as a uint16, then its UTF-16 code units.
+func appendWKT(b []byte, wkt string) []byte {
+	units := utf16.Encode([]rune(wkt))
+	b = binary.LittleEndian.AppendUint16(b, uint16(2*len(units)))
+	for _, u := range units {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// appendVarUint appends v as an unsigned varint, 7 bits to a byte.
+func appendVarUint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// appendVarInt appends v as a signed varint: 6 bits and the sign in the
+// first byte, 7 bits in each after it.
+func appendVarInt(b []byte, v int64) []byte {
+	first := byte(0)
+	if v < 0 {
+		first, v = 0x40, -v
+	}
+	first |= byte(v & 0x3F)
+	v >>= 6
+	if v == 0 {
+		return append(b, first)
+	}
+	return appendVarUint(append(b, first|0x80), uint64(v))
+}
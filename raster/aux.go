@@ -0,0 +1,292 @@
+package raster
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// The auxiliary objects of the bands of raster name, their statistics among
+// them, are kept in fras_aux_<name>; the value attribute table of a raster
+// of integers, when it has one, is VAT_<name>.
+const (
+	AuxTablePrefix string = "fras_aux_"
+	VATTablePrefix string = "VAT_"
+)
+
+// The types of the rows of an aux table: those holding the statistics and
+// histogram of a band, and those holding its properties.
+const (
+	auxStatistics int32 = 2
+	auxProperties int32 = 9
+)
+
+// Statistics are the statistics ArcGIS computed for a band: its range, mean
+// and standard deviation over the pixels other than nodata, and a histogram
+// of them in buckets of equal width from Min to Max, nil if it has none.
+type Statistics struct {
+	Min, Max, Mean, StdDev float64
+	Histogram              []float64 // the pixels in each bucket
+}
+
+// Statistics returns the statistics of band, or nil if the raster has none
+// stored for it.
+func (r *Raster) Statistics(band RasterBand) (*Statistics, error) {
+	b, err := r.auxObject(band, auxStatistics)
+	if b == nil || err != nil {
+		return nil, err
+	}
+	return parseStatistics(b)
+}
+
+// auxObject returns the object of the row of type typ of the aux table for
+// band, or nil if there is none.
+func (r *Raster) auxObject(band RasterBand, typ int32) ([]byte, error) {
+	if r.db.MasterTable().TableID(AuxTablePrefix+r.Name) == 0 {
+		return nil, nil
+	}
+	bt, err := r.db.OpenTable(AuxTablePrefix + r.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+	rows := bt.Rows()
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if int(int32Value(row, "rasterband_id")) != band.ID || int32Value(row, "type") != typ {
+			continue
+		}
+		v, _ := row.Value("object")
+		b, _ := v.([]byte)
+		return b, nil
+	}
+}
+
+// parseStatistics decodes the statistics object of an aux table, big-endian
+// unlike the rest of a geodatabase: its size, the minimum, maximum, mean and
+// standard deviation as doubles, flags, the number of buckets of the
+// histogram and their counts as doubles.
+func parseStatistics(b []byte) (*Statistics, error) {
+	be := binary.BigEndian
+	if len(b) < 44 || int(be.Uint32(b)) != len(b) {
+		return nil, fmt.Errorf("statistics object of %d bytes is not one ArcGIS writes", len(b))
+	}
+	f := func(off int) float64 { return math.Float64frombits(be.Uint64(b[off:])) }
+	s := &Statistics{Min: f(4), Max: f(12), Mean: f(20), StdDev: f(28)}
+	if n := int(be.Uint32(b[40:])); n > 0 {
+		if 44+8*n > len(b) {
+			return nil, fmt.Errorf("statistics object of %d bytes is too short for a histogram of %d buckets", len(b), n)
+		}
+		s.Histogram = make([]float64, n)
+		for i := range s.Histogram {
+			s.Histogram[i] = f(44 + 8*i)
+		}
+	}
+	return s, nil
+}
+
+// The names ArcGIS and the formats it imports from give the scale and offset
+// of packed pixels and the unit of their values, upper case and without
+// underscores.
+var (
+	scaleNames  = []string{"SCALE", "SCALEFACTOR"}
+	offsetNames = []string{"OFFSET", "ADDOFFSET", "SCALEOFFSET"}
+	unitNames   = []string{"UNITS", "UNIT", "UNITTYPE"}
+)
+
+// bandProperties returns the properties of band, as parseProperties reads
+// them, or none if the raster has none stored for it.
+func (r *Raster) bandProperties(band RasterBand) (map[string]interface{}, error) {
+	b, err := r.auxObject(band, auxProperties)
+	if b == nil || err != nil {
+		return nil, err
+	}
+	return parseProperties(b), nil
+}
+
+// property returns the value of the first of props called one of names,
+// whatever the underscores in its name.
+func property(props map[string]interface{}, names []string) (interface{}, bool) {
+	for _, want := range names {
+		for name, v := range props {
+			if strings.ReplaceAll(name, "_", "") == want {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// numberProperty is property for a number, which may be written as text.
+func numberProperty(props map[string]interface{}, names []string) (float64, bool) {
+	switch v, _ := property(props, names); v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// scaleOffset returns the scale and offset the pixels of a band with the
+// properties props are packed by, or zeros if it is not packed. A band with
+// an offset and no scale has a scale of 1.
+func scaleOffset(props map[string]interface{}) (float64, float64) {
+	scale, hasScale := numberProperty(props, scaleNames)
+	offset, hasOffset := numberProperty(props, offsetNames)
+	switch {
+	case hasScale && scale != 0 && (scale != 1 || offset != 0):
+		return scale, offset
+	case !hasScale && hasOffset && offset != 0:
+		return 1, offset
+	}
+	return 0, 0
+}
+
+// parseProperties reads the properties of a band from its property set, a
+// COM PropertySet as ArcObjects persists one: after the class of the set,
+// each property is the length in bytes and UTF-16 of its name, a VARIANT
+// type and the value. Properties holding objects of their own, such as the
+// BandProperties of a raster, nest further sets whose layout is not known,
+// so rather than walk the set it picks out every property of a simple type
+// framed as one, by the name upper case: numbers as float64, strings and
+// booleans.
+func parseProperties(b []byte) map[string]interface{} {
+	props := make(map[string]interface{})
+	for off := 0; off+6 <= len(b); {
+		name, v, n := parseProperty(b[off:])
+		if n == 0 {
+			off++
+			continue
+		}
+		props[strings.ToUpper(name)] = v
+		off += n
+	}
+	return props
+}
+
+// parseProperty reads the property at the start of b, returning its name,
+// value and length, or a length of 0 if b does not start with a property
+// of a simple type.
+func parseProperty(b []byte) (string, interface{}, int) {
+	le := binary.LittleEndian
+	if len(b) < 4 {
+		return "", nil, 0
+	}
+	nameLen := int(le.Uint32(b))
+	if nameLen < 4 || nameLen > 256 || nameLen%2 != 0 || 4+nameLen+2 > len(b) {
+		return "", nil, 0
+	}
+	name := make([]uint16, nameLen/2-1)
+	for i := range name {
+		c := le.Uint16(b[4+2*i:])
+		if c < 0x20 || c > 0x7e {
+			return "", nil, 0
+		}
+		name[i] = c
+	}
+	if le.Uint16(b[4+nameLen-2:]) != 0 {
+		return "", nil, 0
+	}
+
+	p := 4 + nameLen + 2
+	v := b[p:]
+	switch vt := le.Uint16(b[p-2:]); {
+	case vt == 8 && len(v) >= 4: // VT_BSTR, its length in bytes with the null ending it
+		n := int(le.Uint32(v))
+		if n%2 != 0 || 4+n > len(v) {
+			return "", nil, 0
+		}
+		s := make([]uint16, n/2)
+		for i := range s {
+			s[i] = le.Uint16(v[4+2*i:])
+		}
+		return string(utf16.Decode(name)), strings.TrimRight(string(utf16.Decode(s)), "\x00"), p + 4 + n
+	case vt == 11 && len(v) >= 2: // VT_BOOL
+		return string(utf16.Decode(name)), le.Uint16(v) != 0, p + 2
+	}
+	f, size := variantNumber(le.Uint16(b[p-2:]), v)
+	if size == 0 {
+		return "", nil, 0
+	}
+	return string(utf16.Decode(name)), f, p + size
+}
+
+// variantSizes are the sizes of the numeric VARIANT types.
+var variantSizes = map[uint16]int{2: 2, 3: 4, 4: 4, 5: 8, 16: 1, 17: 1, 18: 2, 19: 4, 20: 8, 21: 8, 22: 4, 23: 4}
+
+// variantNumber reads the number of VARIANT type vt at the start of b,
+// returning its size too, or 0 for another type or too short a b.
+func variantNumber(vt uint16, b []byte) (float64, int) {
+	le := binary.LittleEndian
+	size := variantSizes[vt]
+	if size == 0 || len(b) < size {
+		return 0, 0
+	}
+	switch vt {
+	case 2: // VT_I2
+		return float64(int16(le.Uint16(b))), size
+	case 3, 22: // VT_I4, VT_INT
+		return float64(int32(le.Uint32(b))), size
+	case 4: // VT_R4
+		return float64(math.Float32frombits(le.Uint32(b))), size
+	case 5: // VT_R8
+		return math.Float64frombits(le.Uint64(b)), size
+	case 16: // VT_I1
+		return float64(int8(b[0])), size
+	case 17: // VT_UI1
+		return float64(b[0]), size
+	case 18: // VT_UI2
+		return float64(le.Uint16(b)), size
+	case 19, 23: // VT_UI4, VT_UINT
+		return float64(le.Uint32(b)), size
+	case 20: // VT_I8
+		return float64(int64(le.Uint64(b))), size
+	}
+	return float64(le.Uint64(b)), size // VT_UI8
+}
+
+// AttributeTable is the value attribute table of a raster: a row for each
+// value in its pixels, with their count and any attributes joined to them.
+type AttributeTable struct {
+	Fields []gdb.Field
+	Rows   [][]interface{} // one value per field, as gdb.Row has them
+}
+
+// AttributeTable returns the value attribute table of the raster, or nil if
+// it has none.
+func (r *Raster) AttributeTable() (*AttributeTable, error) {
+	if r.db.MasterTable().TableID(VATTablePrefix+r.Name) == 0 {
+		return nil, nil
+	}
+	bt, err := r.db.OpenTable(VATTablePrefix + r.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+	t := &AttributeTable{Fields: bt.Fields}
+	rows := bt.Rows()
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.Rows = append(t.Rows, row.Values)
+	}
+}
@@ -0,0 +1,299 @@
+// Package raster decodes the raster datasets of a file geodatabase: the band
+// descriptions of the fras_bnd_* tables and the compressed pixel blocks of the
+// fras_blk_* tables.
+package raster
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// A raster called name is stored in the tables fras_ras_<name> (the raster
+// itself), fras_bnd_<name> (its bands) and fras_blk_<name> (their blocks).
+const (
+	BndTablePrefix string = "fras_bnd_"
+	BlkTablePrefix string = "fras_blk_"
+)
+
+// TableIDs returns the ids of the band and block tables of raster name, or
+// zeros if the raster is incomplete.
+func TableIDs(mt *gdb.MasterTable, name string) (int, int) {
+	bndID := mt.TableID(BndTablePrefix + name)
+	blkID := mt.TableID(BlkTablePrefix + name)
+	if bndID == 0 || blkID == 0 {
+		return 0, 0
+	}
+	return bndID, blkID
+}
+
+// maxBlockPixels bounds the blocks of a band, far above the 128x128 blocks
+// ArcGIS writes by default, so that block sizes read from a damaged band
+// table fail rather than have gigabytes allocated for each block.
+const maxBlockPixels = 1 << 24
+
+// RasterBase describes one band: its size, block layout, extent, data type
+// and compression, from its row in the band table.
+type RasterBase struct {
+	FileName        string
+	BaseTab         gdb.BaseTable
+	BandID          int
+	RasterID        int32
+	BlockWidth      int32
+	BlockHeight     int32
+	BandWidth       int32
+	BandHeight      int32
+	EMinX           float64
+	EMinY           float64
+	EMaxX           float64
+	EMaxY           float64
+	BlockOriginX    float64
+	BlockOriginY    float64
+	DataType        string
+	CompressionType string
+	BandTypes       []uint8
+	GeoTransform    [6]float64
+	Name            string // as ArcGIS names the band, Band_1 or Red say, "" if it has none
+	Description     string
+	Scale           float64 // to unpack the pixels as value*Scale+Offset, 0 for a band not packed
+	Offset          float64
+	Unit            string               // of the values of the pixels, as NormalizeUnit names it, "" if unknown
+	ColorInterp     string               // as GDAL names it: Gray, Palette, Red, Green, Blue, Alpha or Undefined, "" for Gray
+	ColorMap        map[int64]color.RGBA // the colors of the values of a Palette band
+}
+
+// Unpack returns v, a pixel of the band, as the value it was packed from,
+// or v itself for a band not packed.
+func (rb *RasterBase) Unpack(v float64) float64 {
+	if rb.Scale == 0 {
+		return v
+	}
+	return v*rb.Scale + rb.Offset
+}
+
+func bandTypeToDataTypeString(bandTypes []byte) (string, error) {
+	switch {
+	case bandTypes[2] == 0x08 && bandTypes[3] == 0x00: //00000000 00000100 00001000 00000000
+		return "1bit", nil
+	case bandTypes[2] == 0x20 && bandTypes[3] == 0x00: //00000000 00000100 00100000 00000000
+		return "4bit", nil
+	case bandTypes[2] == 0x41 && bandTypes[3] == 0x00: //00000000 00000100 01000001 00000000
+		return "int8", nil
+	case bandTypes[2] == 0x40 && bandTypes[3] == 0x00: //00000000 00000100 01000000 0000000
+		return "uint8", nil
+	case bandTypes[2] == 0x81 && bandTypes[3] == 0x00: //00000000 00000100 10000001 00000000
+		return "int16", nil
+	case bandTypes[2] == 0x80 && bandTypes[3] == 0x00: //00000000 00000100 10000000 00000000
+		return "uint16", nil
+	case bandTypes[2] == 0x01 && bandTypes[3] == 0x01: //00000000 00000100 00000001 00000001
+		return "int32", nil
+	case bandTypes[2] == 0x02 && bandTypes[3] == 0x01: //00000000 00000100 00000010 00000001
+		return "float32", nil
+	case bandTypes[2] == 0x00 && bandTypes[3] == 0x01: //00000000 00000100 00000000 00000001
+		return "uint32", nil
+	case bandTypes[2] == 0x00 && bandTypes[3] == 0x02: //00000000 00000100 00000000 00000010
+		return "64bit", nil
+	default:
+		return "", fmt.Errorf("unrecognised band data type % x", bandTypes)
+	}
+}
+
+func bandTypeToCompressionTypeString(bandTypes []byte) (string, error) {
+	switch {
+	case bandTypes[1] == 0x00: //bandTypes = 0 0 2  1 00000000 00000000 00000010 00000001
+		return "uncompressed", nil
+	case bandTypes[1] == 0x04: //bandTypes = 0 4 2  1 00000000 00000100 00000010 00000001
+		return "lz77", nil
+	case bandTypes[1] == 0x08: //bandTypes = 0 8 40 0 00000000 00001000 01000000 00000000
+		return "jpeg", nil
+	case bandTypes[1] == 0x0C: //bandTypes = 0 c 81 0 00000000 00001100 10000001 00000000
+		return "jpeg2000", nil
+	default:
+		return "", fmt.Errorf("unrecognised band compression type % x", bandTypes)
+	}
+}
+
+// RasterBand identifies one row of a band table. ID is the row's object id,
+// which is what the block table refers to as rasterband_id.
+type RasterBand struct {
+	ID          int
+	RasterID    int32
+	SequenceNbr int32
+}
+
+// Bands lists the bands stored in a band table (fras_bnd_*).
+func Bands(gdbFilePath string, tableName string) ([]RasterBand, error) {
+	bt, err := gdb.NewBaseTable(gdbFilePath, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+	return readBands(&bt)
+}
+
+// readBands lists the bands of the open band table bt.
+func readBands(bt *gdb.BaseTable) ([]RasterBand, error) {
+	bands := make([]RasterBand, 0)
+	rows := bt.Rows()
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		bands = append(bands, RasterBand{
+			ID:          row.FID,
+			RasterID:    int32Value(row, "raster_id"),
+			SequenceNbr: int32Value(row, "sequence_nbr"),
+		})
+	}
+
+	return bands, nil
+}
+
+// int32Value returns the Int32 field called name of row, or 0 if it is null.
+func int32Value(row *gdb.Row, name string) int32 {
+	v, _ := row.Value(name)
+	i, _ := v.(int32)
+	return i
+}
+
+// stringValue returns the String field called name of row, or "" if it is
+// null.
+func stringValue(row *gdb.Row, name string) string {
+	v, _ := row.Value(name)
+	s, _ := v.(string)
+	return s
+}
+
+// float64Value returns the Float64 field called name of row, or 0 if it is
+// null.
+func float64Value(row *gdb.Row, name string) float64 {
+	v, _ := row.Value(name)
+	f, _ := v.(float64)
+	return f
+}
+
+// NewRasterBase reads one band of the band description table (fras_bnd_*).
+// The table stays open in rb.BaseTab.
+func NewRasterBase(gdbFilePath string, tableName string, bandID int) (RasterBase, error) {
+	tab, err := gdb.NewBaseTable(gdbFilePath, tableName)
+	if err != nil {
+		return RasterBase{FileName: tableName, BandID: bandID}, err
+	}
+	return readRasterBase(tab, tableName, bandID)
+}
+
+// readRasterBase is NewRasterBase on the open band table tab, which it keeps
+// in rb.BaseTab, or closes on error.
+func readRasterBase(tab gdb.BaseTable, tableName string, bandID int) (RasterBase, error) {
+	rb := RasterBase{FileName: tableName, BandID: bandID, BaseTab: tab}
+	bt := &rb.BaseTab
+
+	row, ok, err := bt.ReadRow(bandID - 1)
+	if err == nil && !ok {
+		err = fmt.Errorf("band %d not found in %s", bandID, tableName)
+	}
+	if err != nil {
+		bt.Close()
+		return rb, err
+	}
+
+	// band_types is an Int32 field holding four flag bytes.
+	rb.BandTypes = binary.LittleEndian.AppendUint32(nil, uint32(int32Value(row, "band_types")))
+	rb.RasterID = int32Value(row, "raster_id")
+	rb.BlockWidth = int32Value(row, "block_width")
+	rb.BlockHeight = int32Value(row, "block_height")
+	rb.BandWidth = int32Value(row, "band_width")
+	rb.BandHeight = int32Value(row, "band_height")
+	rb.EMinX = float64Value(row, "eminx")
+	rb.EMinY = float64Value(row, "eminy")
+	rb.EMaxX = float64Value(row, "emaxx")
+	rb.EMaxY = float64Value(row, "emaxy")
+	rb.BlockOriginX = float64Value(row, "block_origin_x")
+	rb.BlockOriginY = float64Value(row, "block_origin_y")
+	rb.Name = stringValue(row, "name")
+	rb.Description = stringValue(row, "description")
+
+	rb.DataType, err = bandTypeToDataTypeString(rb.BandTypes)
+	if err == nil {
+		rb.CompressionType, err = bandTypeToCompressionTypeString(rb.BandTypes)
+	}
+	switch {
+	case err != nil:
+	case rb.BandWidth < 1 || rb.BandHeight < 1:
+		err = fmt.Errorf("band of %dx%d pixels", rb.BandWidth, rb.BandHeight)
+	case rb.BlockWidth < 1 || rb.BlockHeight < 1 || int64(rb.BlockWidth)*int64(rb.BlockHeight) > maxBlockPixels:
+		err = fmt.Errorf("blocks of %dx%d pixels", rb.BlockWidth, rb.BlockHeight)
+	}
+	if err != nil {
+		bt.Close()
+		return rb, fmt.Errorf("%s band %d: %w", tableName, bandID, err)
+	}
+
+	// The e* extents are pixel centres, so widen them by half a pixel.
+	rb.GeoTransform[1] = (rb.EMaxX - rb.EMinX) / float64(rb.BandWidth-1)
+	rb.GeoTransform[5] = -(rb.EMaxY - rb.EMinY) / float64(rb.BandHeight-1)
+	rb.GeoTransform[0] = rb.EMinX - 0.5*rb.GeoTransform[1]
+	rb.GeoTransform[3] = rb.EMaxY - 0.5*rb.GeoTransform[5]
+
+	return rb, nil
+}
+
+// Extent returns the outer edges of the raster as minx, miny, maxx, maxy.
+func (rb *RasterBase) Extent() (float64, float64, float64, float64) {
+	gt := rb.GeoTransform
+	return gt[0], gt[3] + gt[5]*float64(rb.BandHeight), gt[0] + gt[1]*float64(rb.BandWidth), gt[3]
+}
+
+// PixelCenter returns the dataset coordinates of the center of the pixel
+// at column x, row y, which may fall between pixels.
+func (rb *RasterBase) PixelCenter(x, y float64) [2]float64 {
+	gt := rb.GeoTransform
+	return [2]float64{gt[0] + (x+0.5)*gt[1], gt[3] + (y+0.5)*gt[5]}
+}
+
+// PixelWindow converts a window in dataset coordinates into the columns
+// x0..x1 and rows y0..y1 (exclusive) of the band it touches, clipped to the
+// band.
+func (rb *RasterBase) PixelWindow(win []float64) (int, int, int, int) {
+	gt := rb.GeoTransform
+	// Allow for rounding when the window falls on pixel edges.
+	const eps = 1e-6
+	x0 := int(math.Floor((win[0]-gt[0])/gt[1] + eps))
+	x1 := int(math.Ceil((win[2]-gt[0])/gt[1] - eps))
+	y0 := int(math.Floor((win[3]-gt[3])/gt[5] + eps))
+	y1 := int(math.Ceil((win[1]-gt[3])/gt[5] - eps))
+	return max(x0, 0), max(y0, 0), min(x1, int(rb.BandWidth)), min(y1, int(rb.BandHeight))
+}
+
+// Crop returns the band cut down to window win, in dataset coordinates: the
+// band Read describes in RasterData.RasBase when given that Window. It fails
+// if win does not overlap the band.
+func (rb RasterBase) Crop(win []float64) (RasterBase, error) {
+	x0, y0, x1, y1 := rb.PixelWindow(win)
+	if x1 <= x0 || y1 <= y0 {
+		return rb, fmt.Errorf("window %v does not overlap band %d", win, rb.BandID)
+	}
+	rb.crop(x0, y0, x1-x0, y1-y0)
+	return rb, nil
+}
+
+// crop shrinks the band description to the width x height pixels starting at
+// column x0, row y0.
+func (rb *RasterBase) crop(x0 int, y0 int, width int, height int) {
+	gt := &rb.GeoTransform
+	gt[0] += float64(x0) * gt[1]
+	gt[3] += float64(y0) * gt[5]
+	rb.BandWidth, rb.BandHeight = int32(width), int32(height)
+	rb.EMinX = gt[0] + 0.5*gt[1]
+	rb.EMaxX = rb.EMinX + float64(width-1)*gt[1]
+	rb.EMaxY = gt[3] + 0.5*gt[5]
+	rb.EMinY = rb.EMaxY + float64(height-1)*gt[5]
+}
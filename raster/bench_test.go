@@ -0,0 +1,47 @@
+package raster_test
+
+import (
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/internal/gdbfixture"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// BenchmarkDecodeBlock inflates and decodes a block of 128 by 128 pixels,
+// as ArcGIS writes them, of each data type and compression. The bytes are
+// those of the block as stored.
+func BenchmarkDecodeBlock(b *testing.B) {
+	for _, dataType := range dataTypes {
+		for _, compression := range []string{"uncompressed", "lz77"} {
+			r := gdbfixture.Raster{
+				Name: "bench", DataType: dataType, Compression: compression,
+				Width: 128, Height: 128, BlockWidth: 128, BlockHeight: 128, CellSize: 1,
+				Bands: [][]float64{make([]float64, 128*128)},
+			}
+			for i := range r.Bands[0] {
+				r.Bands[0][i] = float64(i % 97 % 2)
+				if dataType != "1bit" {
+					r.Bands[0][i] = float64(i / 128 % 13)
+				}
+			}
+			tables, err := r.Tables()
+			if err != nil {
+				b.Fatal(err)
+			}
+			data := tables[3].Rows[0][4].([]byte)
+			b.Run(dataType+"/"+compression, func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for range b.N {
+					raw, err := raster.InflateBlock(data, compression)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, _, err := raster.DecodeBlock(raw, dataType, 128*128); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
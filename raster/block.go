@@ -0,0 +1,610 @@
+package raster
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// RasterProjection names the table holding the coordinate system of a
+// raster.
+type RasterProjection struct {
+	FileName string
+}
+
+// RasterData is one band read into memory, or opened by Raster.OpenBand to
+// read its pixels as they are asked for.
+type RasterData struct {
+	BaseTab gdb.BaseTable  // the block table, which NewRasterData and OpenBand leave open
+	GeoData Pixels         // pixels row by row, in a Buffer of the data type
+	MinPx   int            // first column holding data, or the width if none
+	MinPy   int            // first row holding data, or the height if none
+	MaxPx   int            // last column holding data, or -1 if none
+	MaxPy   int            // last row holding data, or -1 if none
+	RasBase RasterBase     // the band, cropped to the window read
+	NoData  float64        // the value of pixels without data
+	Suspect []SuspectBlock // blocks left as nodata or failing verification
+
+	lazy *lazyBand // for a band opened by OpenBand, where its pixels come from
+}
+
+// NoDataValue picks the value written for masked pixels of a data type.
+func NoDataValue(dataType string) float64 {
+	switch dataType {
+	case "1bit", "4bit", "uint8":
+		return math.MaxUint8
+	case "int8":
+		return math.MinInt8
+	case "int16":
+		return math.MinInt16
+	case "uint16":
+		return math.MaxUint16
+	case "int32":
+		return math.MinInt32
+	case "uint32":
+		return math.MaxUint32
+	case "float32":
+		return -math.MaxFloat32
+	default:
+		return -math.MaxFloat64
+	}
+}
+
+// TypedValue converts v to the Go type used for pixels of dataType.
+func TypedValue(dataType string, v float64) interface{} {
+	switch dataType {
+	case "1bit", "4bit", "uint8":
+		return uint8(v)
+	case "int8":
+		return int8(v)
+	case "int16":
+		return int16(v)
+	case "uint16":
+		return uint16(v)
+	case "int32":
+		return int32(v)
+	case "uint32":
+		return uint32(v)
+	case "float32":
+		return float32(v)
+	default:
+		return v
+	}
+}
+
+// DecodeBlock turns the decompressed contents of a block into pixel values.
+// Values are stored big-endian and are followed by a validity bitmask, one bit
+// per pixel; valid says which pixels have data, all of them if the mask is
+// missing.
+func DecodeBlock(raw []byte, dataType string, nPixels int) (vals Pixels, valid []bool, err error) {
+	if nPixels < 0 || nPixels > maxBlockPixels {
+		return nil, nil, fmt.Errorf("blocks of %d pixels are not ones ArcGIS writes", nPixels)
+	}
+	dataLen := blockDataLen(dataType, nPixels)
+	if len(raw) < dataLen {
+		return nil, nil, fmt.Errorf("block holds %d bytes, %d pixels of %s need %d", len(raw), nPixels, dataType, dataLen)
+	}
+
+	switch dataType {
+	case "1bit":
+		b := make(Buffer[uint8], nPixels)
+		for p := range b {
+			b[p] = (raw[p>>3] >> (7 - uint(p&7))) & 1
+		}
+		vals = b
+	case "4bit":
+		b := make(Buffer[uint8], nPixels)
+		for p := range b {
+			b[p] = (raw[p>>1] >> (4 * uint(1-p&1))) & 0x0F
+		}
+		vals = b
+	case "int8":
+		b := make(Buffer[int8], nPixels)
+		for p := range b {
+			b[p] = int8(raw[p])
+		}
+		vals = b
+	case "uint8":
+		vals = Buffer[uint8](bytes.Clone(raw[:nPixels]))
+	case "int16":
+		b := make(Buffer[int16], nPixels)
+		for p := range b {
+			b[p] = int16(binary.BigEndian.Uint16(raw[p*2:]))
+		}
+		vals = b
+	case "uint16":
+		b := make(Buffer[uint16], nPixels)
+		for p := range b {
+			b[p] = binary.BigEndian.Uint16(raw[p*2:])
+		}
+		vals = b
+	case "int32":
+		b := make(Buffer[int32], nPixels)
+		for p := range b {
+			b[p] = int32(binary.BigEndian.Uint32(raw[p*4:]))
+		}
+		vals = b
+	case "uint32":
+		b := make(Buffer[uint32], nPixels)
+		for p := range b {
+			b[p] = binary.BigEndian.Uint32(raw[p*4:])
+		}
+		vals = b
+	case "float32":
+		b := make(Buffer[float32], nPixels)
+		for p := range b {
+			b[p] = math.Float32frombits(binary.BigEndian.Uint32(raw[p*4:]))
+		}
+		vals = b
+	default:
+		b := make(Buffer[float64], nPixels)
+		for p := range b {
+			b[p] = math.Float64frombits(binary.BigEndian.Uint64(raw[p*8:]))
+		}
+		vals = b
+	}
+
+	valid = make([]bool, nPixels)
+	mask := raw[dataLen:]
+	hasMask := len(mask) >= (nPixels+7)/8
+	for p := range valid {
+		valid[p] = !hasMask || (mask[p>>3]>>(7-uint(p&7)))&1 != 0
+	}
+	return vals, valid, nil
+}
+
+// blockDataLen returns the bytes nPixels pixels of dataType take in a
+// decompressed block, before the validity mask.
+func blockDataLen(dataType string, nPixels int) int {
+	switch dataType {
+	case "1bit":
+		return (nPixels + 7) / 8
+	case "4bit":
+		return (nPixels + 1) / 2
+	default:
+		return nPixels * int(pixelSize(dataType))
+	}
+}
+
+// maxInflatedBlock is the most a block is inflated to: the pixels and
+// validity mask of a block of maxBlockPixels 64-bit pixels. A damaged block
+// that inflates past it fails rather than filling memory.
+const maxInflatedBlock = maxBlockPixels*8 + maxBlockPixels/8
+
+// InflateBlock undoes the block compression of the band.
+func InflateBlock(data []byte, compressionType string) ([]byte, error) {
+	switch compressionType {
+	case "uncompressed":
+		return data, nil
+	case "lz77":
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		raw, err := io.ReadAll(io.LimitReader(zr, maxInflatedBlock+1))
+		if err == nil && len(raw) > maxInflatedBlock {
+			err = fmt.Errorf("block inflates past %d bytes, more than any block holds", maxInflatedBlock)
+		}
+		return raw, err
+	default:
+		return nil, fmt.Errorf("%s compressed blocks are not supported", compressionType)
+	}
+}
+
+// BlockRow is one row of a block table (fras_blk_*): the still compressed
+// pixels of one block of one band at one pyramid level.
+type BlockRow struct {
+	BandID    int32
+	RRDFactor int32
+	RowNbr    int32
+	ColNbr    int32
+	Data      []byte
+	Deleted   bool // read back from the free space of the table, see gdb.WithDeletedRows
+}
+
+// ReadBlockRow reads row fid of a block table. It returns false for deleted
+// or missing rows.
+func ReadBlockRow(bt *gdb.BaseTable, fid int) (BlockRow, bool, error) {
+	row, ok, err := bt.ReadRow(fid)
+	if !ok || err != nil {
+		return BlockRow{}, false, err
+	}
+	return BlockRowOf(bt, row), true, nil
+}
+
+// BlockRowOf returns the block row of bt that row, already read, holds.
+func BlockRowOf(bt *gdb.BaseTable, row *gdb.Row) BlockRow {
+	blk := BlockRow{
+		BandID:    int32Value(row, "rasterband_id"),
+		RRDFactor: int32Value(row, "rrd_factor"),
+		RowNbr:    int32Value(row, "row_nbr"),
+		ColNbr:    int32Value(row, "col_nbr"),
+	}
+	data, _ := row.Value("block_data")
+	blk.Data, _ = data.([]byte)
+	blk.Deleted = bt.Deleted(row.FID - 1)
+	return blk
+}
+
+// ReadOptions tunes how the blocks of a band are read.
+type ReadOptions struct {
+	Band        int       // sequence number of the band Raster.Read reads; 0 reads the first
+	Verify      bool      // decode every block a second time and compare
+	VerifyCodec bool      // use the independent inflater for the second decode
+	Window      []float64 // minx, miny, maxx, maxy to read; nil reads the whole band
+
+	// Progress, if set, is called before each row of the block table is
+	// read and once more at the end.
+	Progress func(Progress)
+
+	// Start is the first row of the block table read. Resuming a read that
+	// was cut short starts from the Progress.Blocks it had reached.
+	Start int
+
+	// Workers is how many blocks are decoded at once; 0 means one per CPU.
+	// The result does not depend on it.
+	Workers int
+
+	// Block, if set, is handed every block decoded instead of it going into
+	// RasterData.GeoData, which then stays nil, so that bands larger than
+	// memory can be written out a block at a time. An error from Block ends
+	// the read.
+	Block func(Block) error
+
+	// Cache, if set, keeps the blocks decoded, and supplies those decoded
+	// by earlier reads. Reads that verify their blocks decode every one.
+	Cache *BlockCache
+
+	// MaxMemory, if positive, bounds the bytes of pixels the read holds at
+	// once. Fewer blocks are decoded at a time when Workers of them would not
+	// fit, and a band read into GeoData rather than handed to Block must fit
+	// on its own. The Cache has a bound of its own.
+	MaxMemory int64
+
+	// NoData, if set, is the value of the pixels without data in place of
+	// NoDataValue of the data type. It must fit the data type.
+	NoData *float64
+
+	// Cutline, if set, leaves the pixels whose centers fall outside it
+	// without data, and the blocks wholly outside it undecoded. It does not
+	// cut the band read down to it; Cutline.Window gives the Window for
+	// that.
+	Cutline *Cutline
+
+	// Mask, if set, leaves the pixels it does not keep without data, and
+	// the blocks it keeps none of undecoded.
+	Mask *Mask
+}
+
+// NoDataFor returns the value of the pixels without data of a band of
+// dataType read with opts, failing if opts.NoData does not fit the type.
+func (opts ReadOptions) NoDataFor(dataType string) (float64, error) {
+	if opts.NoData == nil {
+		return NoDataValue(dataType), nil
+	}
+	v := *opts.NoData
+	fits := true
+	switch dataType {
+	case "float32":
+		fits = math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) <= math.MaxFloat32
+	case "float64":
+	default:
+		p := NewPixels(dataType, 1)
+		p.Fill(v)
+		fits = p.Float64(0) == v
+	}
+	if !fits {
+		return 0, fmt.Errorf("nodata %g does not fit pixels of type %s", v, dataType)
+	}
+	return v, nil
+}
+
+// blockMemory is roughly what decoding a block of rb takes: its pixels and
+// their validity, once decoded and once cut out of it, and its data inflated.
+func blockMemory(rb *RasterBase) int64 {
+	pixels := int64(rb.BlockWidth) * int64(rb.BlockHeight)
+	return pixels * 3 * (pixelSize(rb.DataType) + 1)
+}
+
+// Block is the part of a decoded block that lies inside the band read, in
+// the pixels of the band read, or, from ReadBlock, the whole of a block.
+type Block struct {
+	X, Y          int    // column and row of the top left pixel
+	Width, Height int    // size in pixels
+	Pixels        Pixels // pixels row by row
+	Valid         []bool // whether each pixel has data
+}
+
+// Progress is how far reading a band has got. The block table holds the
+// blocks of every band and pyramid level, so the counts cover all of them,
+// not just those of the band read.
+type Progress struct {
+	Blocks int   // rows of the block table read so far
+	Total  int   // rows of the block table
+	Bytes  int64 // compressed block data in the rows read
+
+	// Suspect lists the suspect blocks found so far, as RasterData.Suspect
+	// will. It is shared with the read and must not be modified.
+	Suspect []SuspectBlock
+}
+
+// SuspectBlock records a block that could not be read or decoded, or whose
+// two decodes did not agree. Blocks whose row could not be read at all have
+// row and column -1.
+type SuspectBlock struct {
+	RowNbr  int32
+	ColNbr  int32
+	Reason  string
+	Skipped bool // left out, its pixels nodata, rather than only failing verification
+}
+
+// Error makes a skipped block the error that ends a read under
+// gdb.AbortOnBad.
+func (s SuspectBlock) Error() string {
+	if s.RowNbr < 0 {
+		return s.Reason
+	}
+	return fmt.Sprintf("block at row %d, column %d: %s", s.RowNbr, s.ColNbr, s.Reason)
+}
+
+// Damage is how much of a band read was left as nodata for blocks that could
+// not be read or decoded.
+type Damage struct {
+	Blocks   int     // blocks skipped that overlap the band read
+	Unplaced int     // rows skipped that could not be read, so whose block is unknown
+	Pixels   int64   // pixels of the band read inside the blocks skipped
+	Fraction float64 // Pixels over the pixels of the band read
+}
+
+// Damage returns how much of band rb, as read into RasterData.RasBase, the
+// blocks skipped among suspect cover. A block listed twice is counted once.
+func (rb *RasterBase) Damage(suspect []SuspectBlock) Damage {
+	var d Damage
+	width, height := int(rb.BandWidth), int(rb.BandHeight)
+	bw, bh := int(rb.BlockWidth), int(rb.BlockHeight)
+	colOffset := int(math.Round((rb.EMinX - rb.BlockOriginX) / rb.GeoTransform[1]))
+	rowOffset := int(math.Round((rb.BlockOriginY - rb.EMaxY) / -rb.GeoTransform[5]))
+	seen := make(map[image.Point]bool)
+	for _, s := range suspect {
+		if !s.Skipped {
+			continue
+		}
+		if s.RowNbr < 0 {
+			d.Unplaced++
+			continue
+		}
+		cell := image.Pt(int(s.ColNbr), int(s.RowNbr))
+		if seen[cell] {
+			continue
+		}
+		seen[cell] = true
+		x0, y0 := cell.X*bw-colOffset, cell.Y*bh-rowOffset
+		w := min(x0+bw, width) - max(x0, 0)
+		h := min(y0+bh, height) - max(y0, 0)
+		if w <= 0 || h <= 0 {
+			continue
+		}
+		d.Blocks++
+		d.Pixels += int64(w) * int64(h)
+	}
+	if width > 0 && height > 0 {
+		d.Fraction = float64(d.Pixels) / (float64(width) * float64(height))
+	}
+	return d
+}
+
+// Blocks returns the number of blocks of the grid of band rb that overlap
+// the band read, those Damage counts from.
+func (rb *RasterBase) Blocks() int {
+	bw, bh := int(rb.BlockWidth), int(rb.BlockHeight)
+	if bw <= 0 || bh <= 0 || rb.BandWidth <= 0 || rb.BandHeight <= 0 {
+		return 0
+	}
+	colOffset := int(math.Round((rb.EMinX - rb.BlockOriginX) / rb.GeoTransform[1]))
+	rowOffset := int(math.Round((rb.BlockOriginY - rb.EMaxY) / -rb.GeoTransform[5]))
+	cols := floorDiv(colOffset+int(rb.BandWidth)-1, bw) - floorDiv(colOffset, bw) + 1
+	rows := floorDiv(rowOffset+int(rb.BandHeight)-1, bh) - floorDiv(rowOffset, bh) + 1
+	return cols * rows
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// NewRasterData reads the full resolution blocks of band rb from the block
+// table (fras_blk_*) and assembles them into one image. The table stays open
+// in rd.BaseTab. Blocks that cannot be read or decoded are left as nodata and
+// listed in rd.Suspect, unless under gdb.AbortOnBad, as Raster.Read.
+func NewRasterData(gdbFilePath string, tableName string, rb RasterBase, opts ReadOptions) (RasterData, error) {
+	return NewRasterDataContext(context.Background(), gdbFilePath, tableName, rb, opts)
+}
+
+// NewRasterDataContext is NewRasterData stopping with ctx.Err() once ctx is
+// done, having closed the table.
+func NewRasterDataContext(ctx context.Context, gdbFilePath string, tableName string, rb RasterBase, opts ReadOptions) (RasterData, error) {
+	tab, err := gdb.NewBaseTableContext(ctx, gdbFilePath, tableName)
+	if err != nil {
+		return RasterData{RasBase: rb}, err
+	}
+	return readRasterData(tab, rb, opts)
+}
+
+// readRasterData is NewRasterData on the open block table tab, which it
+// keeps in rd.BaseTab, or closes on error. It stops once the context of tab
+// is done.
+func readRasterData(tab gdb.BaseTable, rb RasterBase, opts ReadOptions) (RasterData, error) {
+	rd := RasterData{RasBase: rb, BaseTab: tab}
+	bt := &rd.BaseTab
+	g, err := rd.prepare(bt.GdbTablePath, opts)
+	if err != nil {
+		bt.Close()
+		return rd, err
+	}
+
+	stop := make(chan struct{})
+	results, wait := decodeRows(bt, opts.Start, g, opts, stop)
+	// fail stops the decoders before the table they read is closed.
+	fail := func(err error) (RasterData, error) {
+		close(stop)
+		for range results {
+		}
+		wait()
+		bt.Close()
+		return rd, err
+	}
+
+	// Deleted blocks come after the live ones and only fill in where no
+	// live block, nor an earlier deleted one, was placed: covered holds the
+	// places of the blocks placed, by column and row of the block grid,
+	// once there are deleted rows to read. Resuming, the blocks before
+	// opts.Start are taken as placed.
+	var covered map[image.Point]bool
+	if bt.Deleted(int(bt.NFeaturesX) - 1) {
+		covered = make(map[image.Point]bool)
+		for fid := 0; fid < opts.Start && !bt.Deleted(fid); fid++ {
+			blk, ok, _ := ReadBlockRow(bt, fid)
+			if ok && int(blk.BandID) == rb.BandID && blk.RRDFactor == 0 && blk.Data != nil {
+				covered[image.Pt(int(blk.ColNbr), int(blk.RowNbr))] = true
+			}
+		}
+	}
+
+	abort := gdb.OnErrorOf(bt.Context()) == gdb.AbortOnBad
+	prog := Progress{Total: int(bt.NFeaturesX)}
+	fid := opts.Start
+	for res := range results {
+		if opts.Progress != nil {
+			prog.Blocks, prog.Suspect = fid, rd.Suspect
+			opts.Progress(prog)
+		}
+		r := <-res
+		fid++
+		if r.err != nil {
+			return fail(r.err)
+		}
+		prog.Bytes += r.bytes
+		rd.Suspect = append(rd.Suspect, r.suspect...)
+		for _, s := range r.suspect {
+			if s.Skipped && abort {
+				return fail(s)
+			}
+		}
+		b := r.block
+		if b == nil {
+			continue
+		}
+		if covered != nil {
+			if r.deleted && covered[r.cell] {
+				continue
+			}
+			covered[r.cell] = true
+		}
+
+		rd.place(b)
+		if opts.Block != nil {
+			if err := opts.Block(*b); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	wait()
+	if opts.Progress != nil {
+		prog.Blocks, prog.Suspect = prog.Total, rd.Suspect
+		opts.Progress(prog)
+	}
+
+	return rd, nil
+}
+
+// prepare sets rd up for the blocks of band rd.RasBase read with opts from
+// block table table: it crops the band to opts.Window, allocates the pixels
+// unless they go to opts.Block, and returns where the blocks go, and which
+// pixels of them opts.Cutline and opts.Mask keep.
+func (rd *RasterData) prepare(table string, opts ReadOptions) (bandGeometry, error) {
+	rb := rd.RasBase
+	width := int(rb.BandWidth)
+	height := int(rb.BandHeight)
+	bw := int(rb.BlockWidth)
+	bh := int(rb.BlockHeight)
+
+	// Pixel offset of the block grid relative to the top left of the band.
+	colOffset := int(math.Round((rb.EMinX - rb.BlockOriginX) / rb.GeoTransform[1]))
+	rowOffset := int(math.Round((rb.BlockOriginY - rb.EMaxY) / -rb.GeoTransform[5]))
+
+	if opts.Window != nil {
+		x0, y0, x1, y1 := rb.PixelWindow(opts.Window)
+		if x1 <= x0 || y1 <= y0 {
+			return bandGeometry{}, fmt.Errorf("window %v does not overlap band %d", opts.Window, rb.BandID)
+		}
+		width, height = x1-x0, y1-y0
+		colOffset += x0
+		rowOffset += y0
+		rd.RasBase.crop(x0, y0, width, height)
+	}
+
+	noData, err := opts.NoDataFor(rb.DataType)
+	if err != nil {
+		return bandGeometry{}, err
+	}
+	rd.NoData = noData
+	if need := int64(width) * int64(height) * pixelSize(rb.DataType); opts.Block == nil && opts.MaxMemory > 0 && need > opts.MaxMemory {
+		return bandGeometry{}, fmt.Errorf("band %d of %dx%d pixels needs about %d MiB in memory, more than the %d MiB allowed; hand its blocks to ReadOptions.Block instead",
+			rb.BandID, width, height, need>>20, opts.MaxMemory>>20)
+	}
+	if opts.Block == nil {
+		rd.GeoData = NewPixels(rb.DataType, width*height)
+		rd.GeoData.Fill(rd.NoData)
+	}
+	rd.MinPx, rd.MinPy = width, height
+	rd.MaxPx, rd.MaxPy = -1, -1
+	g := bandGeometry{table: table, rb: &rd.RasBase, width: width, height: height, bw: bw, bh: bh, colOffset: colOffset, rowOffset: rowOffset}
+	if opts.Cutline != nil {
+		g.spans = opts.Cutline.spans(&rd.RasBase)
+	}
+	if opts.Mask != nil {
+		g.mask = opts.Mask
+		g.maskCols, g.maskRows = opts.Mask.lookup(&rd.RasBase)
+	}
+	return g, nil
+}
+
+// place puts the pixels with data of block b into rd.GeoData, unless the
+// pixels go to ReadOptions.Block, and widens the extent of the data to them.
+func (rd *RasterData) place(b *Block) {
+	width := int(rd.RasBase.BandWidth)
+	// The pixels with data go in by runs along each row.
+	for y := 0; y < b.Height; y++ {
+		py := b.Y + y
+		row := b.Valid[y*b.Width : (y+1)*b.Width]
+		for x := 0; x < b.Width; {
+			if !row[x] {
+				x++
+				continue
+			}
+			end := x
+			for end < b.Width && row[end] {
+				end++
+			}
+			if rd.GeoData != nil {
+				rd.GeoData.copyFrom(py*width+b.X+x, b.Pixels, y*b.Width+x, y*b.Width+end)
+			}
+			rd.MinPx = min(rd.MinPx, b.X+x)
+			rd.MinPy = min(rd.MinPy, py)
+			rd.MaxPx = max(rd.MaxPx, b.X+end-1)
+			rd.MaxPy = max(rd.MaxPy, py)
+			x = end
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package raster
+
+import (
+	"container/list"
+	"sync"
+)
+
+// BlockCache keeps the most recently decoded blocks, so that reads of
+// overlapping windows of a band, such as the windows of a job, decode each
+// block once. The rows of the block table are still read, to find the
+// blocks, but a block found in the cache is neither inflated nor decoded
+// again. One cache may be shared by any number of reads, of any rasters, at
+// once.
+type BlockCache struct {
+	mu       sync.Mutex
+	size     int
+	maxBytes int64 // 0 for no bound
+	bytes    int64 // roughly taken by the pixels kept
+	entries  map[blockKey]*list.Element
+	lru      list.List // of *blockEntry, most recently used first
+}
+
+// blockKey names a block: the table holding it, its band and its place in
+// the block grid.
+type blockKey struct {
+	table    string
+	band     int
+	row, col int32
+}
+
+type blockEntry struct {
+	key   blockKey
+	vals  Pixels
+	valid []bool
+}
+
+// NewBlockCache returns a cache of at most size decoded blocks. A block
+// holds BlockWidth*BlockHeight pixels, each taking the size of its type and a
+// byte for its validity.
+func NewBlockCache(size int) *BlockCache {
+	return &BlockCache{size: size, entries: make(map[blockKey]*list.Element)}
+}
+
+// SetMaxBytes bounds the memory the pixels kept take as well, counted as
+// ReadOptions.MaxMemory counts them, so that the cache holds fewer blocks
+// when they are large.
+func (c *BlockCache) SetMaxBytes(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBytes = n
+	for n > 0 && c.bytes > n {
+		c.dropOldest()
+	}
+}
+
+// get returns the pixels of the block and their validity, which the caller
+// must not modify. A nil cache holds nothing.
+func (c *BlockCache) get(k blockKey) (Pixels, []bool, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[k]
+	if !ok {
+		return nil, nil, false
+	}
+	c.lru.MoveToFront(e)
+	be := e.Value.(*blockEntry)
+	return be.vals, be.valid, true
+}
+
+// put adds the pixels of a block, dropping the least recently used block if
+// the cache is full. A nil cache keeps nothing.
+func (c *BlockCache) put(k blockKey, vals Pixels, valid []bool) {
+	if c == nil || c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[k]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	n := entryMemory(vals, valid)
+	if c.maxBytes > 0 && n > c.maxBytes {
+		return
+	}
+	c.evict(n)
+	c.entries[k] = c.lru.PushFront(&blockEntry{k, vals, valid})
+	c.bytes += n
+}
+
+// evict drops the least recently used blocks until there is room for one
+// more of n bytes.
+func (c *BlockCache) evict(n int64) {
+	for c.lru.Len() > 0 && (c.lru.Len() >= c.size || c.maxBytes > 0 && c.bytes+n > c.maxBytes) {
+		c.dropOldest()
+	}
+}
+
+func (c *BlockCache) dropOldest() {
+	last := c.lru.Back()
+	e := last.Value.(*blockEntry)
+	delete(c.entries, e.key)
+	c.lru.Remove(last)
+	c.bytes -= entryMemory(e.vals, e.valid)
+}
+
+// entryMemory is roughly what the pixels of a block kept take.
+func entryMemory(vals Pixels, valid []bool) int64 {
+	return int64(vals.Len())*int64(vals.elemSize()) + int64(len(valid))
+}
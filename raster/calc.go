@@ -0,0 +1,206 @@
+package raster
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is band math, such as (B4 - B3) / (B4 + B3) for NDVI, parsed by
+// ParseExpr: numbers and the bands of a raster, B and their sequence
+// numbers, joined by + - * / and ^, with - before a term and parentheses.
+// ^ binds tightest, and to the right.
+type Expr struct {
+	src  string
+	root *exprNode
+}
+
+// exprNode is a number, a band, or an operator on the nodes below it: l
+// alone for negation.
+type exprNode struct {
+	op    byte // 0 for a number, 'B' for a band, else + - * / ^ or 'n' to negate
+	value float64
+	band  int
+	l, r  *exprNode
+}
+
+// ParseExpr parses band math s, as Expr describes it.
+func ParseExpr(s string) (*Expr, error) {
+	p := &exprParser{s: s}
+	root, err := p.sum()
+	if err == nil && p.skip() < len(s) {
+		err = fmt.Errorf("unexpected %q at %d", s[p.pos:], p.pos+1)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", s, err)
+	}
+	return &Expr{src: s, root: root}, nil
+}
+
+// String returns the expression as it was written.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Bands returns the sequence numbers of the bands e uses, in order.
+func (e *Expr) Bands() []int {
+	var bands []int
+	var walk func(n *exprNode)
+	walk = func(n *exprNode) {
+		if n == nil {
+			return
+		}
+		if n.op == 'B' && !slices.Contains(bands, n.band) {
+			bands = append(bands, n.band)
+		}
+		walk(n.l)
+		walk(n.r)
+	}
+	walk(e.root)
+	slices.Sort(bands)
+	return bands
+}
+
+// Eval returns the value of e for the values of the bands of a pixel, band
+// returning that of the band of a sequence number. Division by zero gives
+// an infinity, or NaN, as float64 has it, and a band of value NaN, for no
+// data, makes the value NaN.
+func (e *Expr) Eval(band func(seq int) float64) float64 {
+	return e.root.eval(band)
+}
+
+func (n *exprNode) eval(band func(seq int) float64) float64 {
+	switch n.op {
+	case 0:
+		return n.value
+	case 'B':
+		return band(n.band)
+	case 'n':
+		return -n.l.eval(band)
+	}
+	l, r := n.l.eval(band), n.r.eval(band)
+	switch n.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	case '/':
+		return l / r
+	}
+	// math.Pow has NaN^0 and 1^NaN be 1.
+	if math.IsNaN(l) || math.IsNaN(r) {
+		return math.NaN()
+	}
+	return math.Pow(l, r)
+}
+
+// exprParser parses an Expr by recursive descent, one level of precedence
+// to a method.
+type exprParser struct {
+	s   string
+	pos int
+}
+
+// skip moves past spaces, returning where the next token starts.
+func (p *exprParser) skip() int {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.pos
+}
+
+// next returns the next operator or parenthesis if it is one of ops, taking
+// it, or 0.
+func (p *exprParser) next(ops string) byte {
+	if p.skip() < len(p.s) && strings.IndexByte(ops, p.s[p.pos]) >= 0 {
+		p.pos++
+		return p.s[p.pos-1]
+	}
+	return 0
+}
+
+func (p *exprParser) sum() (*exprNode, error) {
+	l, err := p.product()
+	for err == nil {
+		op := p.next("+-")
+		if op == 0 {
+			break
+		}
+		var r *exprNode
+		r, err = p.product()
+		l = &exprNode{op: op, l: l, r: r}
+	}
+	return l, err
+}
+
+func (p *exprParser) product() (*exprNode, error) {
+	l, err := p.unary()
+	for err == nil {
+		op := p.next("*/")
+		if op == 0 {
+			break
+		}
+		var r *exprNode
+		r, err = p.unary()
+		l = &exprNode{op: op, l: l, r: r}
+	}
+	return l, err
+}
+
+func (p *exprParser) unary() (*exprNode, error) {
+	if p.next("-") != 0 {
+		l, err := p.unary()
+		return &exprNode{op: 'n', l: l}, err
+	}
+	return p.power()
+}
+
+func (p *exprParser) power() (*exprNode, error) {
+	l, err := p.operand()
+	if err != nil || p.next("^") == 0 {
+		return l, err
+	}
+	r, err := p.unary()
+	return &exprNode{op: '^', l: l, r: r}, err
+}
+
+func (p *exprParser) operand() (*exprNode, error) {
+	start := p.skip()
+	if p.next("(") != 0 {
+		n, err := p.sum()
+		if err == nil && p.next(")") == 0 {
+			err = fmt.Errorf("missing ) for ( at %d", start+1)
+		}
+		return n, err
+	}
+	end := start
+	for end < len(p.s) && (p.s[end] == '.' || unicode.IsLetter(rune(p.s[end])) || unicode.IsDigit(rune(p.s[end])) ||
+		(end > start && (p.s[end] == '+' || p.s[end] == '-') && (p.s[end-1] == 'e' || p.s[end-1] == 'E') && unicode.IsDigit(rune(p.s[start])))) {
+		end++
+	}
+	tok := p.s[start:end]
+	p.pos = end
+	switch {
+	case tok == "":
+		if start == len(p.s) {
+			return nil, fmt.Errorf("unexpected end")
+		}
+		return nil, fmt.Errorf("unexpected %q at %d", p.s[start:start+1], start+1)
+	case tok[0] == 'B' || tok[0] == 'b':
+		seq, err := strconv.Atoi(tok[1:])
+		if err != nil || seq < 1 {
+			return nil, fmt.Errorf("%q at %d is not a band such as B1", tok, start+1)
+		}
+		return &exprNode{op: 'B', band: seq}, nil
+	}
+	v, err := strconv.ParseFloat(tok, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("%q at %d is neither a number nor a band", tok, start+1)
+	}
+	return &exprNode{value: v}, nil
+}
@@ -0,0 +1,88 @@
+package raster_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// TestExpr evaluates band math for bands B1 to B4 of 1 to 4, but for
+// pixels without data, NaN, in bands noData.
+func TestExpr(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	for _, tc := range []struct {
+		expr   string
+		noData []int
+		want   float64
+		bands  []int
+	}{
+		// Precedence and associativity.
+		{expr: "1 + 2 * 3", want: 7},
+		{expr: "(1 + 2) * 3", want: 9},
+		{expr: "10 - 4 - 3", want: 3},
+		{expr: "8 / 4 / 2", want: 1},
+		{expr: "2 ^ 3 ^ 2", want: 512},
+		{expr: "2 * 3 ^ 2", want: 18},
+		{expr: "((2))", want: 2},
+		{expr: "1e3 + 2.5E-1", want: 1000.25},
+		{expr: "(B4 - B3) / (B4 + B3)", want: 1.0 / 7, bands: []int{3, 4}},
+		{expr: "b2 * B2 + B1", want: 5, bands: []int{1, 2}},
+
+		// Unary minus, tighter than * and / but looser than ^.
+		{expr: "-3", want: -3},
+		{expr: "--3", want: 3},
+		{expr: "2 * -3", want: -6},
+		{expr: "-2 ^ 2", want: -4},
+		{expr: "2 ^ -1", want: 0.5},
+		{expr: "-B1 - -B2", want: 1, bands: []int{1, 2}},
+
+		// Division by zero.
+		{expr: "1 / 0", want: inf},
+		{expr: "-1 / 0", want: -inf},
+		{expr: "0 / 0", want: nan},
+		{expr: "B1 / (B2 - B2)", want: inf, bands: []int{1, 2}},
+
+		// Pixels without data make pixels without data.
+		{expr: "B1 + 1", noData: []int{1}, want: nan, bands: []int{1}},
+		{expr: "0 * B1", noData: []int{1}, want: nan, bands: []int{1}},
+		{expr: "B1 ^ 0", noData: []int{1}, want: nan, bands: []int{1}},
+		{expr: "1 ^ B1", noData: []int{1}, want: nan, bands: []int{1}},
+		{expr: "B2 - B1 / B3", noData: []int{3}, want: nan, bands: []int{1, 2, 3}},
+		{expr: "B2 - B1", noData: []int{3}, want: 1, bands: []int{1, 2}},
+	} {
+		e, err := raster.ParseExpr(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		got := e.Eval(func(seq int) float64 {
+			if slices.Contains(tc.noData, seq) {
+				return nan
+			}
+			return float64(seq)
+		})
+		if got != tc.want && !(math.IsNaN(got) && math.IsNaN(tc.want)) {
+			t.Errorf("%s is %v, want %v", tc.expr, got, tc.want)
+		}
+		if bands := e.Bands(); !slices.Equal(bands, tc.bands) {
+			t.Errorf("%s uses bands %v, want %v", tc.expr, bands, tc.bands)
+		}
+		if e.String() != tc.expr {
+			t.Errorf("%s written as %s", tc.expr, e.String())
+		}
+	}
+}
+
+// TestParseExprErrors parses band math that is not.
+func TestParseExprErrors(t *testing.T) {
+	for _, expr := range []string{
+		"", " ", "1 +", "* 2", "(1 + 2", "1 + 2)", "1 2", "B0", "B", "Bx", "C1",
+		"1 $ 2", "1e400", "NaN", "Inf", "2 ^", "()",
+	} {
+		if e, err := raster.ParseExpr(expr); err == nil {
+			t.Errorf("%q parsed as %v", expr, e.Bands())
+		}
+	}
+}
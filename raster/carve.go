@@ -0,0 +1,281 @@
+package raster
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+)
+
+// Carving reads the blocks of a band out of bytes no table describes any
+// more: a block table whose header or index is destroyed, or an image of the
+// disk the geodatabase was on. The blocks are found by what they hold. A row
+// of a block table is its null flags, rasterband_id, rrd_factor, row_nbr and
+// col_nbr as int32, the length of block_data as a varint, and block_data. The
+// zlib stream of an lz77 block is spotted by its two header bytes, and the
+// fields before it place the block. Uncompressed blocks have no header, so
+// the fields are looked for at every byte, followed by a length that fits a
+// block of the band. A block is taken only if it decodes to a whole block.
+// JPEG and JPEG 2000 blocks are spotted by their markers but cannot be
+// decoded.
+
+// CarveReport counts what carving found.
+type CarveReport struct {
+	Placed      int   // blocks of the band decoded and put in place
+	Duplicates  int   // further copies of blocks already placed, left out
+	Unplaced    int   // lz77 blocks that decode but whose fields are lost
+	Unsupported int   // JPEG or JPEG 2000 blocks, which cannot be decoded
+	Scanned     int64 // bytes scanned
+}
+
+// carveChunk is how much of the source is scanned at once, and carveOverlap
+// how far a chunk reads into the next, for the markers across the boundary.
+const (
+	carveChunk   = 1 << 20
+	carveOverlap = 3
+)
+
+// carver scans a source for the blocks of one band.
+type carver struct {
+	src        io.ReaderAt
+	size       int64
+	g          bandGeometry
+	opts       ReadOptions
+	rd         *RasterData
+	cols, rows int   // of the block grid of the whole band
+	dataLen    int   // bytes of the pixels of a decoded block
+	rawLen     int   // bytes of a decoded block with its mask
+	maxLen     int64 // bytes block_data may take
+	placed     map[image.Point]bool
+	next       int64 // offset before which nothing more is looked for
+	buf        []byte
+	report     CarveReport
+}
+
+// carvedRow is the fields of a block row found carving.
+type carvedRow struct {
+	band, rrd, row, col int32
+	data, length        int64 // offset and length of block_data
+}
+
+// Carve reads band opts.Band like Read, but carves its blocks out of the
+// bytes of src, size bytes long, rather than reading the rows of the block
+// table; with a nil src it carves the .gdbtable of the block table, whose
+// header or index may be destroyed. Places no block is found for are left as
+// nodata, and of several copies of a block the first found is kept. The band
+// table must still be readable. opts.Start, Progress, Cache and Verify are
+// ignored, and the RasterData has no BaseTab.
+func (r *Raster) Carve(src io.ReaderAt, size int64, opts ReadOptions) (*RasterData, CarveReport, error) {
+	rb, err := r.Band(opts.Band)
+	if err != nil {
+		return nil, CarveReport{}, err
+	}
+	if src == nil {
+		f, err := r.db.OpenRawTable(BlkTablePrefix + r.Name)
+		if err != nil {
+			return nil, CarveReport{}, err
+		}
+		defer f.Close()
+		src, size = f, f.Size()
+	}
+	return carve(r.db.Context(), src, size, rb, opts)
+}
+
+func carve(ctx context.Context, src io.ReaderAt, size int64, rb RasterBase, opts ReadOptions) (*RasterData, CarveReport, error) {
+	opts.Cache, opts.Verify = nil, false
+	bw, bh := int(rb.BlockWidth), int(rb.BlockHeight)
+	colOffset := int(math.Round((rb.EMinX - rb.BlockOriginX) / rb.GeoTransform[1]))
+	rowOffset := int(math.Round((rb.BlockOriginY - rb.EMaxY) / -rb.GeoTransform[5]))
+
+	rd := &RasterData{RasBase: rb}
+	g, err := rd.prepare("", opts)
+	if err != nil {
+		return nil, CarveReport{}, err
+	}
+	c := &carver{
+		src:     src,
+		size:    size,
+		g:       g,
+		opts:    opts,
+		rd:      rd,
+		cols:    (colOffset + int(rb.BandWidth) + bw - 1) / bw,
+		rows:    (rowOffset + int(rb.BandHeight) + bh - 1) / bh,
+		dataLen: blockDataLen(rb.DataType, bw*bh),
+		placed:  make(map[image.Point]bool),
+	}
+	c.rawLen = c.dataLen + (bw*bh+7)/8
+	// Deflate can make a block a little larger than it was.
+	c.maxLen = int64(c.rawLen + c.rawLen/8 + 64)
+	if err := c.scan(ctx); err != nil {
+		return nil, c.report, err
+	}
+	return rd, c.report, nil
+}
+
+// scan goes through the source a chunk at a time, looking for blocks at
+// every byte.
+func (c *carver) scan(ctx context.Context) error {
+	chunk := make([]byte, carveChunk+carveOverlap)
+	compression := c.rd.RasBase.CompressionType
+	band := uint32(c.rd.RasBase.BandID)
+	for off := int64(0); off < c.size; off += carveChunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := c.src.ReadAt(chunk[:min(int64(len(chunk)), c.size-off)], off)
+		if n == 0 && err != nil && err != io.EOF {
+			return err
+		}
+		b := chunk[:n]
+		for i := 0; i < min(n, carveChunk); i++ {
+			at := off + int64(i)
+			if at < c.next {
+				continue
+			}
+			var err error
+			switch {
+			case compression == "lz77" && b[i] == 0x78 && i+1 < n && isZlibLevel(b[i+1]):
+				err = c.zlibAt(at)
+			case compression == "uncompressed" && i+4 <= n && binary.LittleEndian.Uint32(b[i:]) == band:
+				if f, ok := c.rowFields(at); ok && f.length >= int64(c.dataLen) && f.length <= int64(c.rawLen) {
+					err = c.take(f)
+				}
+			case compression == "jpeg" && i+2 < n && b[i] == 0xFF && b[i+1] == 0xD8 && b[i+2] == 0xFF:
+				c.report.Unsupported++
+			case compression == "jpeg2000" && i+3 < n && bytes.Equal(b[i:i+4], []byte{0xFF, 0x4F, 0xFF, 0x51}):
+				c.report.Unsupported++
+			}
+			if err != nil {
+				return err
+			}
+		}
+		c.report.Scanned = min(off+carveChunk, c.size)
+	}
+	return nil
+}
+
+// isZlibLevel reports whether b is the second byte of a zlib header with a
+// 32 KiB window, no dictionary and one of the four compression levels.
+func isZlibLevel(b byte) bool {
+	return b == 0x01 || b == 0x5E || b == 0x9C || b == 0xDA
+}
+
+// zlibAt looks at the zlib stream starting at offset at: the fields of the
+// row are read back from the varint before it, and if they cannot be, the
+// stream is only counted if it inflates to a block.
+func (c *carver) zlibAt(at int64) error {
+	for v := int64(1); v <= 5 && at-v-16 >= 0; v++ {
+		if f, ok := c.rowFields(at - v - 16); ok && f.data == at {
+			return c.take(f)
+		}
+	}
+	if n, ok := c.inflates(at); ok {
+		c.report.Unplaced++
+		c.next = at + n
+	}
+	return nil
+}
+
+// rowFields reads the fields of a block row whose rasterband_id is at offset
+// at. It returns false if they cannot be those of a block of the raster.
+func (c *carver) rowFields(at int64) (carvedRow, bool) {
+	var b [16 + binary.MaxVarintLen32]byte
+	n, _ := c.src.ReadAt(b[:], at)
+	if n < 17 {
+		return carvedRow{}, false
+	}
+	f := carvedRow{
+		band: int32(binary.LittleEndian.Uint32(b[0:])),
+		rrd:  int32(binary.LittleEndian.Uint32(b[4:])),
+		row:  int32(binary.LittleEndian.Uint32(b[8:])),
+		col:  int32(binary.LittleEndian.Uint32(b[12:])),
+	}
+	length, v := binary.Uvarint(b[16:n])
+	if v <= 0 {
+		return carvedRow{}, false
+	}
+	f.data, f.length = at+16+int64(v), int64(length)
+	if f.band <= 0 || f.rrd < 0 || f.rrd > 30 || f.row < 0 || f.col < 0 ||
+		int(f.row) >= (c.rows>>f.rrd)+1 || int(f.col) >= (c.cols>>f.rrd)+1 {
+		return carvedRow{}, false
+	}
+	if f.length == 0 || f.length > c.maxLen || f.data+f.length > c.size {
+		return carvedRow{}, false
+	}
+	if f.rrd == 0 && (int(f.row) >= c.rows || int(f.col) >= c.cols) {
+		return carvedRow{}, false
+	}
+	return f, true
+}
+
+// take decodes the block of row f and puts it in place, unless it is of
+// another band or pyramid level, or a copy of a block already placed. Once
+// it decodes, nothing more is looked for inside it.
+func (c *carver) take(f carvedRow) error {
+	if f.band != int32(c.rd.RasBase.BandID) || f.rrd != 0 {
+		if c.inflatesWhole(f) {
+			c.next = f.data + f.length
+		}
+		return nil
+	}
+	data := make([]byte, f.length)
+	if _, err := c.src.ReadAt(data, f.data); err != nil {
+		return nil
+	}
+	blk := BlockRow{BandID: f.band, RowNbr: f.row, ColNbr: f.col, Data: data}
+	res := c.g.decode(blk, nil, "", c.opts)
+	if len(res.suspect) > 0 {
+		return nil
+	}
+	c.next = f.data + f.length
+	cell := image.Pt(int(f.col), int(f.row))
+	if c.placed[cell] {
+		c.report.Duplicates++
+		return nil
+	}
+	c.placed[cell] = true
+	c.report.Placed++
+	if res.block == nil {
+		return nil
+	}
+	c.rd.place(res.block)
+	if c.opts.Block != nil {
+		return c.opts.Block(*res.block)
+	}
+	return nil
+}
+
+// inflatesWhole reports whether the block_data of row f, of another band or
+// pyramid level, is a block of the size of those of the band. Uncompressed
+// blocks are taken at their word.
+func (c *carver) inflatesWhole(f carvedRow) bool {
+	if c.rd.RasBase.CompressionType != "lz77" {
+		return true
+	}
+	n, ok := c.inflates(f.data)
+	return ok && n <= f.length
+}
+
+// inflates reports whether the zlib stream at offset at inflates, checksum
+// included, to a block of the band, and how many bytes it takes.
+func (c *carver) inflates(at int64) (int64, bool) {
+	if c.buf == nil {
+		c.buf = make([]byte, c.maxLen)
+	}
+	n, _ := c.src.ReadAt(c.buf[:min(c.maxLen, c.size-at)], at)
+	// A bytes.Reader is read by the inflater a byte at a time, so what is
+	// left of it tells where the stream ends.
+	br := bytes.NewReader(c.buf[:n])
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, false
+	}
+	raw, err := io.ReadAll(io.LimitReader(zr, int64(c.rawLen)+1))
+	if err != nil || len(raw) < c.dataLen || len(raw) > c.rawLen {
+		return 0, false
+	}
+	return int64(n - br.Len()), true
+}
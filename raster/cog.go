@@ -0,0 +1,116 @@
+package raster
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tagNewSubfileType marks the IFDs of overviews, with bit 0 set for reduced
+// resolution images.
+const tagNewSubfileType = 254
+
+// cogImage is what ValidateCOG reads of each image of a TIFF file.
+type cogImage struct {
+	ifd           uint64
+	width, height uint64
+	tiled         bool
+	tileW, tileH  uint64
+	reduced       bool
+	offsets       []uint64 // of its chunks
+}
+
+// ValidateCOG checks that the TIFF file at path is laid out as a cloud
+// optimized GeoTIFF, as GDAL's validate_cloud_optimized_geotiff.py checks
+// one: the IFD of the band straight after the header, those of its
+// overviews after it, all of them ahead of the chunks of pixels, which go
+// from the smallest overview up to the band, each image's in order. Bands
+// over 512 pixels across or down must be tiled, and have overviews unless
+// they fit in a tile. It returns every way the file falls short, joined.
+func ValidateCOG(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, off, big, err := openTIFF(f)
+	if err != nil {
+		return err
+	}
+	var images []cogImage
+	for off != 0 {
+		if len(images) > 0 && off <= images[len(images)-1].ifd {
+			return fmt.Errorf("the IFD at byte %d points back to byte %d", images[len(images)-1].ifd, off)
+		}
+		img := cogImage{ifd: off}
+		if off, err = r.readIFD(off, big); err != nil {
+			return err
+		}
+		img.width, img.height = r.uint(tagWidth, 0), r.uint(tagHeight, 0)
+		img.reduced = r.uint(tagNewSubfileType, 0)&1 == 1
+		img.offsets = r.uints(tagStripOffsets)
+		if _, img.tiled = r.fields[tagTileWidth]; img.tiled {
+			img.offsets = r.uints(tagTileOffsets)
+			img.tileW, img.tileH = r.uint(tagTileWidth, 0), r.uint(tagTileLength, 0)
+		}
+		images = append(images, img)
+	}
+
+	var errs []error
+	band := images[0]
+	headerSize := uint64(8)
+	if big {
+		headerSize = 16
+	}
+	if band.ifd != headerSize {
+		errs = append(errs, fmt.Errorf("the IFD of the band is at byte %d, not %d, right after the header", band.ifd, headerSize))
+	}
+	large := band.width > 512 || band.height > 512
+	if large && !band.tiled {
+		errs = append(errs, fmt.Errorf("the band is over 512 pixels across or down but not tiled"))
+	}
+	if large && len(images) == 1 && (!band.tiled || band.width > band.tileW || band.height > band.tileH) {
+		errs = append(errs, fmt.Errorf("the band is over 512 pixels across or down, in more than a tile, but has no overviews"))
+	}
+	for i, img := range images[1:] {
+		prev := images[i]
+		switch {
+		case !img.reduced:
+			errs = append(errs, fmt.Errorf("image %d is not an overview", i+1))
+		case img.width >= prev.width && img.height >= prev.height:
+			errs = append(errs, fmt.Errorf("overview %d, of %dx%d pixels, is no smaller than the image before it", i+1, img.width, img.height))
+		}
+		if (img.width > 512 || img.height > 512) && !img.tiled {
+			errs = append(errs, fmt.Errorf("overview %d is over 512 pixels across or down but not tiled", i+1))
+		}
+	}
+
+	// The chunks of each image follow the IFDs and those of the images after
+	// it, in order.
+	last := images[len(images)-1].ifd
+	for i := len(images) - 1; i >= 0; i-- {
+		name := "the band"
+		if i > 0 {
+			name = fmt.Sprintf("overview %d", i)
+		}
+		var prev uint64
+		for j, o := range images[i].offsets {
+			var err error
+			switch {
+			case o == 0:
+				continue // a chunk left out, all nodata
+			case o <= last:
+				err = fmt.Errorf("chunk %d of %s, at byte %d, is not after the IFDs and the chunks of the overviews smaller than it", j, name, o)
+			case o < prev:
+				err = fmt.Errorf("chunk %d of %s, at byte %d, is before the chunk ahead of it", j, name, o)
+			}
+			if err != nil {
+				errs = append(errs, err) // the first only, of the many that follow
+				break
+			}
+			prev = o
+		}
+		last = max(last, prev)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,134 @@
+package raster
+
+import (
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+// colorNames are the color interpretations, as GDAL names them, of bands
+// ArcGIS names for their colors, lower case.
+var colorNames = map[string]string{
+	"red": "Red", "green": "Green", "blue": "Blue", "alpha": "Alpha",
+	"nir": "Undefined", "near infrared": "Undefined", "infrared": "Undefined",
+}
+
+// colorInterp returns how the pixels of band rb, band seq of count, are to
+// be shown, as GDAL names color interpretations: by its name if that is a
+// color, or as red, green and blue, and alpha for a fourth band, if it is
+// one of the three or four bands of bytes or 16-bit integers of an image;
+// as Palette if it is a band with a color map, or else Gray.
+func colorInterp(rb *RasterBase, seq, count int) string {
+	if c, ok := colorNames[strings.ToLower(strings.TrimSpace(rb.Name))]; ok {
+		return c
+	}
+	if (count == 3 || count == 4) && seq >= 1 && seq <= count && (rb.DataType == "uint8" || rb.DataType == "uint16") {
+		return []string{"Red", "Green", "Blue", "Alpha"}[seq-1]
+	}
+	if rb.ColorMap != nil {
+		return "Palette"
+	}
+	return "Gray"
+}
+
+// colorFields are the fields of a value attribute table holding the color
+// of each value, as ArcGIS gives them for NLCD and other classified rasters.
+var colorFields = []string{"Red", "Green", "Blue"}
+
+// colorMap returns the colors the value attribute table of the raster gives
+// its values, for a single band of values a palette can hold, or nil if it
+// has none. The colors are from 0 to 255, or from 0 to 1 if none is above.
+func (r *Raster) colorMap(rb *RasterBase) (map[int64]color.RGBA, error) {
+	if len(r.Bands) != 1 {
+		return nil, nil
+	}
+	switch rb.DataType {
+	case "1bit", "4bit", "uint8", "uint16":
+	default:
+		return nil, nil
+	}
+	if r.db.MasterTable().TableID(VATTablePrefix+r.Name) == 0 {
+		return nil, nil
+	}
+	bt, err := r.db.OpenTable(VATTablePrefix + r.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+	value, rgb := -1, []int{-1, -1, -1}
+	for i, fld := range bt.Fields {
+		if strings.EqualFold(fld.Name, "Value") {
+			value = i
+		}
+		for c, name := range colorFields {
+			if strings.EqualFold(fld.Name, name) {
+				rgb[c] = i
+			}
+		}
+	}
+	if value < 0 || rgb[0] < 0 || rgb[1] < 0 || rgb[2] < 0 {
+		return nil, nil
+	}
+
+	colors := make(map[int64][3]float64)
+	top := 0.0
+	rows := bt.Rows()
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		v, ok := intValue(row.Values[value])
+		if !ok {
+			continue
+		}
+		var c [3]float64
+		for i, f := range rgb {
+			c[i], _ = numberValue(row.Values[f])
+			top = max(top, c[i])
+		}
+		colors[v] = c
+	}
+	if len(colors) == 0 {
+		return nil, nil
+	}
+	scale := 1.0
+	if top <= 1 {
+		scale = 255
+	}
+	cm := make(map[int64]color.RGBA, len(colors))
+	for v, c := range colors {
+		b := func(x float64) uint8 { return uint8(math.Round(min(max(x*scale, 0), 255))) }
+		cm[v] = color.RGBA{b(c[0]), b(c[1]), b(c[2]), 255}
+	}
+	return cm, nil
+}
+
+// intValue returns the value of an integer field.
+func intValue(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// numberValue returns the value of a numeric field as a float64.
+func numberValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	i, ok := intValue(v)
+	return float64(i), ok
+}
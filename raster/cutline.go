@@ -0,0 +1,176 @@
+package raster
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+)
+
+// Cutline is an area of interest, read by ReadCutline from the polygons of
+// a GeoJSON file. Its coordinates are taken to be those of the raster it
+// cuts, not longitude and latitude as GeoJSON would have them. A read with
+// it as ReadOptions.Cutline leaves the pixels whose centers fall outside it
+// as nodata, and decodes no block wholly outside it.
+type Cutline struct {
+	src                    string
+	rings                  [][][2]float64
+	minX, minY, maxX, maxY float64
+}
+
+// geoJSONObject is as much of a GeoJSON object as a Cutline needs.
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Geometries  []geoJSONObject `json:"geometries"`
+	Features    []geoJSONObject `json:"features"`
+}
+
+// ReadCutline reads the Cutline of the GeoJSON file at path: a Polygon or
+// MultiPolygon, or a Feature, FeatureCollection or GeometryCollection of
+// them, every ring of which bounds it. Where polygons overlap, the parts
+// covered twice are left out, as for a hole.
+func ReadCutline(path string) (*Cutline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCutline(path, data)
+}
+
+// ParseCutline reads the Cutline of GeoJSON data, as ReadCutline does,
+// naming it after src, as where it came from, in errors and String.
+func ParseCutline(src string, data []byte) (*Cutline, error) {
+	var obj geoJSONObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	c := &Cutline{src: src, minX: math.Inf(1), minY: math.Inf(1), maxX: math.Inf(-1), maxY: math.Inf(-1)}
+	if err := c.add(&obj); err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	if len(c.rings) == 0 {
+		return nil, fmt.Errorf("%s: no polygons", src)
+	}
+	return c, nil
+}
+
+// add adds the rings of the polygons of obj to c.
+func (c *Cutline) add(obj *geoJSONObject) error {
+	var polygons [][][][]float64
+	switch obj.Type {
+	case "FeatureCollection":
+		for i := range obj.Features {
+			if err := c.add(&obj.Features[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Feature":
+		if obj.Geometry == nil {
+			return nil
+		}
+		return c.add(obj.Geometry)
+	case "GeometryCollection":
+		for i := range obj.Geometries {
+			if err := c.add(&obj.Geometries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Polygon":
+		polygons = make([][][][]float64, 1)
+		if err := json.Unmarshal(obj.Coordinates, &polygons[0]); err != nil {
+			return fmt.Errorf("Polygon: %w", err)
+		}
+	case "MultiPolygon":
+		if err := json.Unmarshal(obj.Coordinates, &polygons); err != nil {
+			return fmt.Errorf("MultiPolygon: %w", err)
+		}
+	default:
+		return fmt.Errorf("a %s is not a polygon", obj.Type)
+	}
+	for _, polygon := range polygons {
+		for _, positions := range polygon {
+			if len(positions) < 4 {
+				return fmt.Errorf("a ring of %d positions, fewer than 4", len(positions))
+			}
+			ring := make([][2]float64, len(positions))
+			for i, p := range positions {
+				if len(p) < 2 {
+					return fmt.Errorf("a position of %d coordinates", len(p))
+				}
+				ring[i] = [2]float64{p[0], p[1]}
+				c.minX, c.minY = min(c.minX, p[0]), min(c.minY, p[1])
+				c.maxX, c.maxY = max(c.maxX, p[0]), max(c.maxY, p[1])
+			}
+			c.rings = append(c.rings, ring)
+		}
+	}
+	return nil
+}
+
+// String returns the path c was read from, or what ParseCutline named it,
+// or "" for a nil Cutline.
+func (c *Cutline) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.src
+}
+
+// Window returns window win, minx, miny, maxx, maxy, or the whole band for
+// nil, cut down to the bounds of c: the ReadOptions.Window to read c with,
+// for the band read to leave out what lies around it. Where win and c do
+// not meet, the window returned is empty, and no band overlaps it.
+func (c *Cutline) Window(win []float64) []float64 {
+	cut := []float64{c.minX, c.minY, c.maxX, c.maxY}
+	if win != nil {
+		cut = []float64{max(cut[0], win[0]), max(cut[1], win[1]), min(cut[2], win[2]), min(cut[3], win[3])}
+	}
+	return cut
+}
+
+// spans returns, for each row of band rb, the columns of the pixels whose
+// centers fall inside c, as pairs of the first column of a run and the one
+// after its last.
+func (c *Cutline) spans(rb *RasterBase) [][]int {
+	gt := rb.GeoTransform
+	w, h := int(rb.BandWidth), int(rb.BandHeight)
+	// Where the edges of c cross the line through the centers of each row,
+	// in columns, a pixel being inside if the crossings left of its center
+	// are odd in number.
+	crossings := make([][]float64, h)
+	for _, ring := range c.rings {
+		for i := 1; i < len(ring); i++ {
+			ax, ay := (ring[i-1][0]-gt[0])/gt[1], (ring[i-1][1]-gt[3])/gt[5]
+			bx, by := (ring[i][0]-gt[0])/gt[1], (ring[i][1]-gt[3])/gt[5]
+			if ay == by {
+				continue
+			}
+			// Rows whose centers lie from the lower end on, up to but not
+			// at the upper, so that a vertex on the line counts once.
+			lo, hi := min(ay, by), max(ay, by)
+			y0 := max(int(math.Ceil(lo-0.5)), 0)
+			y1 := min(int(math.Ceil(hi-0.5)), h)
+			for y := y0; y < y1; y++ {
+				cy := float64(y) + 0.5
+				crossings[y] = append(crossings[y], ax+(cy-ay)*(bx-ax)/(by-ay))
+			}
+		}
+	}
+	spans := make([][]int, h)
+	for y, xs := range crossings {
+		slices.Sort(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			x0 := max(int(math.Ceil(xs[i]-0.5)), 0)
+			x1 := min(int(math.Ceil(xs[i+1]-0.5)), w)
+			if x1 > x0 {
+				spans[y] = append(spans[y], x0, x1)
+			}
+		}
+	}
+	return spans
+}
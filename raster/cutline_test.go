@@ -0,0 +1,109 @@
+package raster_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/internal/gdbfixture"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// cutlineJSON is a square with a square hole, and a triangle over a corner
+// of it in a feature of its own, on a band of 8 by 6 pixels 10 units
+// square from 0, 60.
+const cutlineJSON = `{"type": "FeatureCollection", "features": [
+	{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [
+		[[10, 10], [70, 10], [70, 50], [10, 50], [10, 10]],
+		[[30, 20], [30, 40], [50, 40], [50, 20], [30, 20]]]}},
+	{"type": "Feature", "geometry": {"type": "MultiPolygon", "coordinates": [
+		[[[0, 0], [32, 0], [0, 32], [0, 0]]]]}},
+	{"type": "Feature", "geometry": null}]}`
+
+// cutlineKept are the pixels of that band whose centers fall inside the
+// cutline: those in the hole, and the one the square and the triangle both
+// cover, are left out.
+var cutlineKept = []string{
+	"........",
+	".######.",
+	".##..##.",
+	"###..##.",
+	"#.#####.",
+	"###.....",
+}
+
+// TestReadCutline reads a band through a cutline, whole and in the window
+// Cutline.Window gives, keeping only the pixels whose centers fall inside
+// it.
+func TestReadCutline(t *testing.T) {
+	r := gdbfixture.Raster{
+		Name: "cut", DataType: "uint8", Compression: "lz77",
+		Width: 8, Height: 6, BlockWidth: 4, BlockHeight: 4,
+		MinX: 0, MaxY: 60, CellSize: 10, WKT: albers, NoData: new(float64),
+	}
+	*r.NoData = noData
+	px := make([]float64, r.Width*r.Height)
+	for i := range px {
+		px[i] = float64(i%7 + 1)
+	}
+	r.Bands = [][]float64{px}
+	want := slices.Clone(px)
+	for y, row := range cutlineKept {
+		for x, c := range row {
+			if c == '.' {
+				want[y*r.Width+x] = noData
+			}
+		}
+	}
+
+	cut, err := raster.ParseCutline("cut.geojson", []byte(cutlineJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if win := cut.Window(nil); !slices.Equal(win, []float64{0, 0, 70, 50}) {
+		t.Errorf("window %v, want [0 0 70 50]", win)
+	}
+	if win := cut.Window([]float64{20, -10, 100, 30}); !slices.Equal(win, []float64{20, 0, 70, 30}) {
+		t.Errorf("window %v, want [20 0 70 30]", win)
+	}
+
+	ras, err := raster.Open(openRasters(t, r), r.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := ras.Read(raster.ReadOptions{Cutline: cut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, rd, want, r.Width, 0, 0)
+
+	rd, err = ras.Read(raster.ReadOptions{Cutline: cut, Window: cut.Window(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := rd.RasBase.BandWidth, rd.RasBase.BandHeight; w != 7 || h != 5 {
+		t.Fatalf("window is %dx%d, want 7x5", w, h)
+	}
+	checkPixels(t, rd, want, r.Width, 0, 1)
+}
+
+// TestParseCutlineErrors checks that GeoJSON of no polygons, or of rings or
+// positions too short, fails, naming what is wrong.
+func TestParseCutlineErrors(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		want string
+	}{
+		{`{"type": "Point", "coordinates": [1, 2]}`, "a Point is not a polygon"},
+		{`{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [0, 0]]]}`, "a ring of 3 positions"},
+		{`{"type": "Polygon", "coordinates": [[[0, 0], [1], [1, 1], [0, 0]]]}`, "a position of 1 coordinates"},
+		{`{"type": "FeatureCollection", "features": []}`, "no polygons"},
+		{`{"type": "Polygon", "coordinates": {}}`, "Polygon: json"},
+		{`[`, "cut.geojson: unexpected end"},
+	} {
+		_, err := raster.ParseCutline("cut.geojson", []byte(tc.json))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error %v, want %q", tc.json, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package raster
+
+import (
+	"image"
+	"runtime"
+	"sync"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// Reading the rows of a block table and inflating and decoding the blocks
+// they hold is independent from row to row. readRasterData therefore hands
+// the rows to a pool of workers, each reading its rows through a Reader of
+// the table and decoding their blocks, and takes the results back in row
+// order, so that the pixels, the suspect blocks and the progress come out
+// just as a single reader would produce them.
+
+// bandGeometry is where the blocks of a band go in the pixels read.
+type bandGeometry struct {
+	table                string // the block table, naming blocks in the cache
+	rb                   *RasterBase
+	width, height        int // of the band read
+	bw, bh               int // of a block
+	colOffset, rowOffset int // of the block grid from the band read
+
+	// spans, unless nil, are the pixels of each row of the band read inside
+	// the cutline, as Cutline.spans has them.
+	spans [][]int
+
+	// mask, unless nil, is the Mask of the read, and maskCols and maskRows
+	// the columns and rows of it of the band read, as Mask.lookup has them.
+	mask               *Mask
+	maskCols, maskRows []int
+}
+
+// decodeJob is a row of the block table waiting to be read and decoded.
+type decodeJob struct {
+	fid int
+	res chan decodeResult
+}
+
+// decodeResult is what a row of the block table gives: the part of its
+// block inside the band read, if any, with the column and row of the block
+// and whether it is a deleted row read back, the blocks found suspect, or
+// the error that ends the read.
+type decodeResult struct {
+	block   *Block
+	cell    image.Point
+	deleted bool
+	suspect []SuspectBlock
+	bytes   int64
+	err     error
+}
+
+// decodeRows reads the rows of bt from start on and decodes the blocks of
+// band g.rb with opts.Workers workers, 0 meaning one per CPU, or fewer to
+// keep within opts.MaxMemory. The results come out of the channel it returns
+// in row order, one per row, each once it is ready. Once stop is closed it
+// stops early, wait returning when it no longer uses bt.
+func decodeRows(bt *gdb.BaseTable, start int, g bandGeometry, opts ReadOptions, stop <-chan struct{}) (results <-chan chan decodeResult, wait func()) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if opts.MaxMemory > 0 {
+		// Each worker holds a block, and twice as many wait to be
+		// collected, beside the one being collected.
+		fit := (opts.MaxMemory/blockMemory(g.rb) - 1) / 3
+		workers = int(max(1, min(int64(workers), fit)))
+	}
+	jobs := make(chan decodeJob)
+	pending := make(chan chan decodeResult, 2*workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(r, again *gdb.BaseTable) {
+			defer wg.Done()
+			for j := range jobs {
+				j.res <- g.readRow(r, again, j.fid, opts)
+			}
+		}(bt.Reader(), bt.Reader())
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		defer close(jobs)
+		for fid := start; fid < int(bt.NFeaturesX); fid++ {
+			res := make(chan decodeResult, 1)
+			select {
+			case pending <- res:
+			case <-stop:
+				return
+			}
+			select {
+			case jobs <- decodeJob{fid, res}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return pending, wg.Wait
+}
+
+// readRow reads row fid through r and decodes its block, if it is one of
+// band g.rb with pixels in the band read. Verification reads the block again through again, whose offsets
+// and read-ahead are apart from those of r, so that the block is read twice.
+func (g bandGeometry) readRow(r, again *gdb.BaseTable, fid int, opts ReadOptions) decodeResult {
+	if err := r.Context().Err(); err != nil {
+		return decodeResult{err: err}
+	}
+	blk, ok, err := ReadBlockRow(r, fid)
+	if err != nil {
+		return decodeResult{suspect: []SuspectBlock{{-1, -1, err.Error(), true}}}
+	}
+	if !ok || int(blk.BandID) != g.rb.BandID || blk.RRDFactor != 0 || blk.Data == nil {
+		return decodeResult{bytes: int64(len(blk.Data))}
+	}
+	// A block outside the band read, the cutline or the mask is not
+	// decoded.
+	if _, _, _, _, ok := g.extent(blk); !ok {
+		return decodeResult{bytes: int64(len(blk.Data))}
+	}
+	var second []byte
+	var rereadFail string
+	if opts.Verify {
+		second, rereadFail = rereadBlock(again, fid)
+	}
+	return g.decode(blk, second, rereadFail, opts)
+}
+
+// decode inflates and decodes block blk and cuts out the part inside the
+// band read. With opts.Verify, second is the block read again, or rereadFail
+// why it could not be.
+func (g bandGeometry) decode(blk BlockRow, second []byte, rereadFail string, opts ReadOptions) decodeResult {
+	r := decodeResult{bytes: int64(len(blk.Data)), cell: image.Pt(int(blk.ColNbr), int(blk.RowNbr)), deleted: blk.Deleted}
+
+	// A deleted block is not the block the cache holds for its place.
+	cache := opts.Cache
+	if opts.Verify || blk.Deleted {
+		cache = nil
+	}
+	key := blockKey{g.table, g.rb.BandID, blk.RowNbr, blk.ColNbr}
+	if vals, valid, ok := cache.get(key); ok {
+		r.block = g.clip(blk, vals, valid)
+		return r
+	}
+
+	raw, err := InflateBlock(blk.Data, g.rb.CompressionType)
+	var vals Pixels
+	var valid []bool
+	if err == nil {
+		if opts.Verify {
+			reason := rereadFail
+			if reason == "" {
+				reason = verifyBlock(blk.Data, second, raw, g.rb.CompressionType, opts.VerifyCodec)
+			}
+			if reason != "" {
+				r.suspect = append(r.suspect, SuspectBlock{blk.RowNbr, blk.ColNbr, "failed verification: " + reason, false})
+			}
+		}
+		vals, valid, err = DecodeBlock(raw, g.rb.DataType, g.bw*g.bh)
+	}
+	if err != nil {
+		r.suspect = append(r.suspect, SuspectBlock{blk.RowNbr, blk.ColNbr, "skipped: " + err.Error(), true})
+		return r
+	}
+	cache.put(key, vals, valid)
+	r.block = g.clip(blk, vals, valid)
+	return r
+}
+
+// extent returns the columns cx0..cx1 and rows cy0..cy1 (exclusive) of the
+// band read that block blk covers, and false if it covers none, or none
+// inside the cutline that the mask keeps.
+func (g bandGeometry) extent(blk BlockRow) (cx0, cy0, cx1, cy1 int, ok bool) {
+	x0, y0 := int(blk.ColNbr)*g.bw-g.colOffset, int(blk.RowNbr)*g.bh-g.rowOffset
+	cx0, cy0 = max(x0, 0), max(y0, 0)
+	cx1, cy1 = min(x0+g.bw, g.width), min(y0+g.bh, g.height)
+	if cx1 <= cx0 || cy1 <= cy0 {
+		return cx0, cy0, cx1, cy1, false
+	}
+	if g.spans == nil && g.mask == nil {
+		return cx0, cy0, cx1, cy1, true
+	}
+	for y := cy0; y < cy1; y++ {
+		spans := []int{cx0, cx1}
+		if g.spans != nil {
+			spans = g.spans[y]
+		}
+		for i := 0; i < len(spans); i += 2 {
+			for x := max(spans[i], cx0); x < min(spans[i+1], cx1); x++ {
+				if g.mask == nil || g.keeps(x, y) {
+					return cx0, cy0, cx1, cy1, true
+				}
+			}
+		}
+	}
+	return cx0, cy0, cx1, cy1, false
+}
+
+// clip cuts out the part of block blk, decoded into vals and valid, inside
+// the band read, or returns nil if there is none. Pixels outside the
+// cutline, or that the mask does not keep, are left without data.
+func (g bandGeometry) clip(blk BlockRow, vals Pixels, valid []bool) *Block {
+	cx0, cy0, cx1, cy1, ok := g.extent(blk)
+	if !ok {
+		return nil
+	}
+	x0, y0 := int(blk.ColNbr)*g.bw-g.colOffset, int(blk.RowNbr)*g.bh-g.rowOffset
+	w, h := cx1-cx0, cy1-cy0
+	b := &Block{cx0, cy0, w, h, NewPixels(g.rb.DataType, w*h), make([]bool, 0, w*h)}
+	for py := cy0; py < cy1; py++ {
+		from, to := (py-y0)*g.bw+cx0-x0, (py-y0)*g.bw+cx1-x0
+		b.Pixels.copyFrom((py-cy0)*w, vals, from, to)
+		b.Valid = append(b.Valid, valid[from:to]...)
+		row := b.Valid[(py-cy0)*w : (py-cy0+1)*w]
+		if g.spans != nil {
+			g.cut(row, py, cx0, cx1)
+		}
+		if g.mask != nil {
+			for x := range row {
+				row[x] = row[x] && g.keeps(cx0+x, py)
+			}
+		}
+	}
+	return b
+}
+
+// cut clears valid, the pixels of row py of the band read from column cx0
+// to cx1, where they fall outside the cutline.
+func (g bandGeometry) cut(valid []bool, py, cx0, cx1 int) {
+	x := cx0
+	spans := g.spans[py]
+	for i := 0; i <= len(spans); i += 2 {
+		end := cx1
+		if i < len(spans) {
+			end = min(max(spans[i], cx0), cx1)
+		}
+		for ; x < end; x++ {
+			valid[x-cx0] = false
+		}
+		if i < len(spans) {
+			x = max(x, min(spans[i+1], cx1))
+		}
+	}
+}
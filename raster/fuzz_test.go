@@ -0,0 +1,34 @@
+package raster_test
+
+import (
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// FuzzDecodeBlock decodes the bytes of a block as nPixels pixels of
+// dataType: whatever the bytes, it must fail or give as many pixels and
+// validity flags as asked for, never panic. The seeds are the blocks of
+// uncompressed rasters gdbfixture writes, of every data type.
+func FuzzDecodeBlock(f *testing.F) {
+	for _, dataType := range dataTypes {
+		r := testRaster(dataType, "uncompressed")
+		tables, err := r.Tables()
+		if err != nil {
+			f.Fatal(err)
+		}
+		// The block table comes last, block_data fifth of its fields.
+		for _, row := range tables[3].Rows {
+			f.Add(row[4].([]byte), dataType, r.BlockWidth*r.BlockHeight)
+		}
+	}
+	f.Fuzz(func(t *testing.T, raw []byte, dataType string, nPixels int) {
+		vals, valid, err := raster.DecodeBlock(raw, dataType, nPixels)
+		if err != nil {
+			return
+		}
+		if vals.Len() != nPixels || len(valid) != nPixels {
+			t.Errorf("%d pixels and %d flags decoded, want %d", vals.Len(), len(valid), nPixels)
+		}
+	})
+}
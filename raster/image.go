@@ -0,0 +1,77 @@
+package raster
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Image returns the band read as an image.Image, for previews with
+// image/png and for the image processing packages: an *image.Gray sharing
+// the pixels of uint8, 1bit and 4bit bands, an *image.Gray16 of uint16 bands,
+// and a *FloatImage of the others. Nodata pixels keep their value in the
+// first two and are black in the last. It returns nil for a band handed to
+// ReadOptions.Block, whose pixels were never held in memory.
+func (rd *RasterData) Image() image.Image {
+	if rd.GeoData == nil {
+		return nil
+	}
+	w, h := int(rd.RasBase.BandWidth), int(rd.RasBase.BandHeight)
+	rect := image.Rect(0, 0, w, h)
+	switch pix := rd.GeoData.(type) {
+	case Buffer[uint8]:
+		return &image.Gray{Pix: pix, Stride: w, Rect: rect}
+	case Buffer[uint16]:
+		m := image.NewGray16(rect)
+		for i, v := range pix {
+			binary.BigEndian.PutUint16(m.Pix[2*i:], v)
+		}
+		return m
+	}
+	return NewFloatImage(rd.GeoData, w, h, rd.NoData)
+}
+
+// FloatImage shows pixels of any type as 16-bit gray, stretched linearly from
+// Min, which is black, to Max, which is white. Pixels equal to NoData, and
+// NaN, are black.
+type FloatImage struct {
+	Pix      Pixels
+	Rect     image.Rectangle
+	Min, Max float64
+	NoData   float64
+}
+
+// NewFloatImage returns pix, w by h pixels, as a FloatImage stretched over
+// the range of the pixels other than noData.
+func NewFloatImage(pix Pixels, w, h int, noData float64) *FloatImage {
+	m := &FloatImage{Pix: pix, Rect: image.Rect(0, 0, w, h), Min: math.Inf(1), Max: math.Inf(-1), NoData: noData}
+	for i := 0; i < pix.Len(); i++ {
+		v := pix.Float64(i)
+		if v == noData || math.IsNaN(v) {
+			continue
+		}
+		m.Min = min(m.Min, v)
+		m.Max = max(m.Max, v)
+	}
+	return m
+}
+
+func (m *FloatImage) ColorModel() color.Model { return color.Gray16Model }
+
+func (m *FloatImage) Bounds() image.Rectangle { return m.Rect }
+
+func (m *FloatImage) At(x, y int) color.Color { return m.Gray16At(x, y) }
+
+// Gray16At returns the gray the pixel at x, y is shown as.
+func (m *FloatImage) Gray16At(x, y int) color.Gray16 {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return color.Gray16{}
+	}
+	v := m.Pix.Float64((y-m.Rect.Min.Y)*m.Rect.Dx() + x - m.Rect.Min.X)
+	if v == m.NoData || math.IsNaN(v) || !(m.Max > m.Min) {
+		return color.Gray16{}
+	}
+	f := (v - m.Min) / (m.Max - m.Min)
+	return color.Gray16{uint16(math.Round(math.Max(0, math.Min(1, f)) * math.MaxUint16))}
+}
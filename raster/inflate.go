@@ -0,0 +1,305 @@
+package raster
+
+import (
+	"errors"
+	"fmt"
+)
+
+// inflate decodes the raw DEFLATE stream (RFC 1951) at the start of data
+// and returns what it holds and the bytes of data the stream takes, for
+// verifyBlock to check compress/flate against. It shares no code with it:
+// it is a plain reading of the RFC, in the manner of zlib's puff, decoding
+// Huffman codes a bit at a time, slow but simple enough to trust. It stops
+// with an error once the output would pass limit bytes.
+func inflate(data []byte, limit int) ([]byte, int, error) {
+	s := &inflater{in: data, limit: limit}
+	for last := false; !last; {
+		last = s.bits(1) == 1
+		var err error
+		switch s.bits(2) {
+		case 0:
+			err = s.stored()
+		case 1:
+			err = s.codes(&fixedLitLen, &fixedDist)
+		case 2:
+			err = s.dynamic()
+		default:
+			err = errors.New("invalid block type 3")
+		}
+		if s.err != nil {
+			return nil, 0, s.err
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	// The bits left over are those of the last byte taken, which the
+	// stream ends in.
+	return s.out, s.pos, nil
+}
+
+// inflater is the state of inflate: the input and the bits taken from it
+// but not used yet, least significant first, and the output so far.
+type inflater struct {
+	in    []byte
+	pos   int
+	buf   uint32
+	nbits int
+	err   error // the input ended
+
+	out   []byte
+	limit int
+}
+
+// bits returns the next n bits of the input, n at most 24, the first in
+// the lowest bit. Past the end of the input it sets s.err and returns 0.
+func (s *inflater) bits(n int) int {
+	for s.nbits < n {
+		if s.pos == len(s.in) {
+			if s.err == nil {
+				s.err = errors.New("deflate stream cut short")
+			}
+			return 0
+		}
+		s.buf |= uint32(s.in[s.pos]) << s.nbits
+		s.pos++
+		s.nbits += 8
+	}
+	v := int(s.buf & (1<<n - 1))
+	s.buf >>= n
+	s.nbits -= n
+	return v
+}
+
+// stored copies a stored block: from the next byte, its length, the one's
+// complement of it, and that many bytes.
+func (s *inflater) stored() error {
+	s.buf, s.nbits = 0, 0
+	if s.pos+4 > len(s.in) {
+		return errors.New("deflate stream cut short")
+	}
+	n := int(s.in[s.pos]) | int(s.in[s.pos+1])<<8
+	if nn := int(s.in[s.pos+2]) | int(s.in[s.pos+3])<<8; n != ^nn&0xFFFF {
+		return errors.New("stored block length does not match its complement")
+	}
+	s.pos += 4
+	if s.pos+n > len(s.in) {
+		return errors.New("deflate stream cut short")
+	}
+	if len(s.out)+n > s.limit {
+		return fmt.Errorf("stream inflates past %d bytes", s.limit)
+	}
+	s.out = append(s.out, s.in[s.pos:s.pos+n]...)
+	s.pos += n
+	return nil
+}
+
+// maxCodeBits is the longest a Huffman code of DEFLATE is.
+const maxCodeBits = 15
+
+// huffman is a canonical Huffman code: how many codes there are of each
+// length, and the symbols in the order of their codes.
+type huffman struct {
+	count  [maxCodeBits + 1]int
+	symbol []int
+}
+
+// newHuffman builds the code of symbols with lengths, 0 for symbols that
+// are not used. An over-subscribed set of lengths is an error; an
+// incomplete one is not, since a stream may leave codes unused, and
+// decoding one of those fails instead.
+func newHuffman(lengths []int) (*huffman, error) {
+	h := &huffman{symbol: make([]int, len(lengths))}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	left := 1
+	for l := 1; l <= maxCodeBits; l++ {
+		left = left<<1 - h.count[l]
+		if left < 0 {
+			return nil, errors.New("over-subscribed Huffman code")
+		}
+	}
+	var offs [maxCodeBits + 2]int
+	for l := 1; l <= maxCodeBits; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	for sym, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = sym
+			offs[l]++
+		}
+	}
+	return h, nil
+}
+
+// decode reads one symbol of code h, a bit at a time: codes are stored
+// most significant bit first, and those of each length follow the last of
+// the length before, doubled.
+func (s *inflater) decode(h *huffman) (int, error) {
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxCodeBits; l++ {
+		code |= s.bits(1)
+		if s.err != nil {
+			return 0, s.err
+		}
+		count := h.count[l]
+		if code-first < count {
+			return h.symbol[index+code-first], nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, errors.New("no such Huffman code")
+}
+
+// The base lengths and distances of the length and distance symbols, and
+// the extra bits that follow them.
+var (
+	lengthBase  = [29]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]int{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]int{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+)
+
+// codes decodes the literals and length and distance pairs of a block
+// compressed with codes litLen and dist, up to its end of block symbol.
+func (s *inflater) codes(litLen, dist *huffman) error {
+	for {
+		sym, err := s.decode(litLen)
+		if err != nil {
+			return err
+		}
+		switch {
+		case sym < 256:
+			if len(s.out) == s.limit {
+				return fmt.Errorf("stream inflates past %d bytes", s.limit)
+			}
+			s.out = append(s.out, byte(sym))
+			continue
+		case sym == 256:
+			return nil
+		case sym > 285:
+			return fmt.Errorf("invalid length symbol %d", sym)
+		}
+		sym -= 257
+		n := lengthBase[sym] + s.bits(lengthExtra[sym])
+		d, err := s.decode(dist)
+		if err != nil {
+			return err
+		}
+		if d > 29 {
+			return fmt.Errorf("invalid distance symbol %d", d)
+		}
+		back := distBase[d] + s.bits(distExtra[d])
+		if s.err != nil {
+			return s.err
+		}
+		if back > len(s.out) {
+			return fmt.Errorf("distance %d back from byte %d", back, len(s.out))
+		}
+		if len(s.out)+n > s.limit {
+			return fmt.Errorf("stream inflates past %d bytes", s.limit)
+		}
+		// Byte by byte, as the match may overlap what it copies.
+		for range n {
+			s.out = append(s.out, s.out[len(s.out)-back])
+		}
+	}
+}
+
+// The codes of blocks compressed with fixed codes.
+var fixedLitLen, fixedDist = func() (huffman, huffman) {
+	var lengths [288]int
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	litLen, _ := newHuffman(lengths[:])
+	var distLengths [30]int
+	for i := range distLengths {
+		distLengths[i] = 5
+	}
+	dist, _ := newHuffman(distLengths[:])
+	return *litLen, *dist
+}()
+
+// codeLengthOrder is the order the lengths of the code length code come in.
+var codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+// dynamic decodes a block compressed with codes of its own, described at
+// its start: how many literal and length codes and distance codes there
+// are, the code their lengths are compressed with, and the lengths.
+func (s *inflater) dynamic() error {
+	nLitLen, nDist, nCode := s.bits(5)+257, s.bits(5)+1, s.bits(4)+4
+	if nLitLen > 286 || nDist > 30 {
+		return errors.New("too many length or distance codes")
+	}
+	var codeLengths [19]int
+	for i := range nCode {
+		codeLengths[codeLengthOrder[i]] = s.bits(3)
+	}
+	if s.err != nil {
+		return s.err
+	}
+	lencode, err := newHuffman(codeLengths[:])
+	if err != nil {
+		return err
+	}
+
+	lengths := make([]int, nLitLen+nDist)
+	for i := 0; i < len(lengths); {
+		sym, err := s.decode(lencode)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = sym
+			i++
+			continue
+		}
+		l, n := 0, 0
+		switch sym {
+		case 16:
+			if i == 0 {
+				return errors.New("repeat of no previous length")
+			}
+			l, n = lengths[i-1], 3+s.bits(2)
+		case 17:
+			n = 3 + s.bits(3)
+		default:
+			n = 11 + s.bits(7)
+		}
+		if i+n > len(lengths) {
+			return errors.New("code lengths run past the codes")
+		}
+		for range n {
+			lengths[i] = l
+			i++
+		}
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if lengths[256] == 0 {
+		return errors.New("no end of block code")
+	}
+	litLen, err := newHuffman(lengths[:nLitLen])
+	if err != nil {
+		return err
+	}
+	dist, err := newHuffman(lengths[nLitLen:])
+	if err != nil {
+		return err
+	}
+	return s.codes(litLen, dist)
+}
@@ -0,0 +1,94 @@
+package raster
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// RasterMetadata describes a raster dataset as a whole, as Info reads it
+// from its band, block and aux tables. The size, block layout, data type,
+// compression and nodata of the raster are those of its first band; Bands
+// has each band's own.
+type RasterMetadata struct {
+	Name          string
+	Width, Height int // in pixels
+	BlockWidth    int
+	BlockHeight   int
+	BandCount     int
+	DataType      string  // as raster names data types: uint8, int16, float32...
+	Compression   string  // of the blocks: uncompressed, lz77, jpeg or jpeg2000
+	NoData        float64 // the value pixels without data are read as, NoDataValue of DataType
+	WKT           string  // coordinate system, "" if unknown
+	EPSG          int     // the EPSG code the AUTHORITY of WKT gives it, 0 if none
+	GeoTransform  [6]float64
+	PyramidLevels int // levels of reduced resolution above the band in the block table
+	Bands         []BandMetadata
+}
+
+// BandMetadata describes one band of a raster for RasterMetadata.
+type BandMetadata struct {
+	Band          int    // sequence number, 1 for the first
+	Name          string // as ArcGIS names the band, "" if it has none
+	Description   string
+	Width, Height int
+	BlockWidth    int
+	BlockHeight   int
+	DataType      string
+	Compression   string
+	NoData        float64
+	Scale, Offset float64 // the pixels are packed by, 0 and 0 for a band not packed
+	Unit          string  // of the values, as NormalizeUnit names it, "" if unknown
+	ColorInterp   string  // as GDAL names it: Gray, Palette, Red...
+	GeoTransform  [6]float64
+	PyramidLevels int
+	Statistics    *Statistics // as ArcGIS computed them, nil if none are stored
+}
+
+// Info describes r and each of its bands. It walks the block table for the
+// pyramid levels, as ReadBlock does the first time, and so costs a read of
+// every row of it, though not the inflating of a block.
+func (r *Raster) Info() (*RasterMetadata, error) {
+	ix, err := r.blocks()
+	if err != nil {
+		return nil, err
+	}
+	md := &RasterMetadata{Name: r.Name, WKT: r.WKT, EPSG: EPSGCode(r.WKT), BandCount: len(r.Bands)}
+	for i, band := range r.Bands {
+		rb, err := ix.band(r, int(band.SequenceNbr))
+		if err != nil {
+			return nil, err
+		}
+		stats, err := r.Statistics(band)
+		if err != nil {
+			return nil, err
+		}
+		bm := BandMetadata{
+			Band: int(band.SequenceNbr), Name: rb.Name, Description: rb.Description,
+			Width: int(rb.BandWidth), Height: int(rb.BandHeight),
+			BlockWidth: int(rb.BlockWidth), BlockHeight: int(rb.BlockHeight),
+			DataType: rb.DataType, Compression: rb.CompressionType, NoData: NoDataValue(rb.DataType),
+			Scale: rb.Scale, Offset: rb.Offset, Unit: rb.Unit, ColorInterp: rb.ColorInterp,
+			GeoTransform: rb.GeoTransform, PyramidLevels: ix.levels[rb.BandID], Statistics: stats,
+		}
+		if i == 0 {
+			md.Width, md.Height = bm.Width, bm.Height
+			md.BlockWidth, md.BlockHeight = bm.BlockWidth, bm.BlockHeight
+			md.DataType, md.Compression, md.NoData = bm.DataType, bm.Compression, bm.NoData
+			md.GeoTransform, md.PyramidLevels = bm.GeoTransform, bm.PyramidLevels
+		}
+		md.Bands = append(md.Bands, bm)
+	}
+	return md, nil
+}
+
+var epsgAuthority = regexp.MustCompile(`AUTHORITY\["EPSG",\s*"?(\d+)"?\]\]$`)
+
+// EPSGCode returns the EPSG code the AUTHORITY of wkt gives it, or 0.
+func EPSGCode(wkt string) int {
+	if m := epsgAuthority.FindStringSubmatch(wkt); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			return n
+		}
+	}
+	return 0
+}
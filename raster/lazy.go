@@ -0,0 +1,147 @@
+package raster
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// lazyBand is where the pixels of a RasterData opened by OpenBand come
+// from as they are asked for.
+type lazyBand struct {
+	r    *Raster
+	opts ReadOptions
+
+	mu   sync.Mutex
+	last *Block // the block At read last, for the pixels around it
+}
+
+// errNotOpened is the error of the methods reading pixels on demand called
+// on a RasterData that Raster.OpenBand did not return.
+var errNotOpened = errors.New("band not opened with OpenBand, its pixels not read on demand")
+
+// OpenBand returns band opts.Band of r as a RasterData holding only its
+// description, whole, and where its blocks are in the block table, GeoData
+// left nil: its pixels are read as they are asked for, a block at a time,
+// by At, Block and Window, or all at once into GeoData by Load, so that a
+// band need never fit in memory. The block table stays open in BaseTab,
+// for the caller to close once done with the band, and may be read from
+// several goroutines at once. The blocks read are kept in opts.Cache, if
+// set, for those asked for again, as Read keeps them, and pixels without
+// data are opts.NoData, if set; the other options are not used. Image needs
+// the band loaded.
+func (r *Raster) OpenBand(opts ReadOptions) (*RasterData, error) {
+	ix, err := r.blocks()
+	if err != nil {
+		return nil, err
+	}
+	rb, err := ix.band(r, opts.Band)
+	if err != nil {
+		return nil, err
+	}
+	noData, err := opts.NoDataFor(rb.DataType)
+	if err != nil {
+		return nil, err
+	}
+	bt, err := r.db.OpenTable(BlkTablePrefix + r.Name)
+	if err != nil {
+		return nil, err
+	}
+	rd := &RasterData{BaseTab: *bt, RasBase: rb, NoData: noData, lazy: &lazyBand{r: r, opts: opts}}
+	rd.MinPx, rd.MinPy, rd.MaxPx, rd.MaxPy = int(rb.BandWidth), int(rb.BandHeight), -1, -1
+	return rd, nil
+}
+
+// Block returns the block at column col, row row of the block grid of a
+// band opened by OpenBand, as Raster.ReadBlock does.
+func (rd *RasterData) Block(col, row int) (Block, error) {
+	if rd.lazy == nil {
+		return Block{}, errNotOpened
+	}
+	l := rd.lazy
+	ix, err := l.r.blocks()
+	if err != nil {
+		return Block{}, err
+	}
+	var b Block
+	if _, ok := ix.fids[blockCell{rd.RasBase.BandID, col, row}]; !ok {
+		err = ErrNoBlock
+	} else {
+		b, err = l.r.readBlock(rd.BaseTab.Reader(), ix, &rd.RasBase, col, row, l.opts.Cache)
+	}
+	if err != nil {
+		return b, fmt.Errorf("raster %s band %d, block at row %d, column %d: %w", l.r.Name, l.opts.Band, row, col, err)
+	}
+	return b, nil
+}
+
+// At returns the pixel at column x, row y of the band, and false if it has
+// no data there: from GeoData if it holds the pixels, else, for a band
+// opened by OpenBand, from the block it falls in, read for it. A block the
+// block table does not hold has no data.
+func (rd *RasterData) At(x, y int) (float64, bool, error) {
+	rb := &rd.RasBase
+	if x < 0 || y < 0 || x >= int(rb.BandWidth) || y >= int(rb.BandHeight) {
+		return rd.NoData, false, fmt.Errorf("pixel %d, %d is outside the band of %dx%d pixels", x, y, rb.BandWidth, rb.BandHeight)
+	}
+	if rd.GeoData != nil {
+		v := rd.GeoData.Float64(y*int(rb.BandWidth) + x)
+		return v, v != rd.NoData, nil
+	}
+	if rd.lazy == nil {
+		return rd.NoData, false, errNotOpened
+	}
+	l := rd.lazy
+	l.mu.Lock()
+	b := l.last
+	l.mu.Unlock()
+	if b == nil || x < b.X || y < b.Y || x >= b.X+b.Width || y >= b.Y+b.Height {
+		bw, bh := int(rb.BlockWidth), int(rb.BlockHeight)
+		colOffset, rowOffset := rb.gridOffset()
+		blk, err := rd.Block(floorDiv(colOffset+x, bw), floorDiv(rowOffset+y, bh))
+		if errors.Is(err, ErrNoBlock) {
+			return rd.NoData, false, nil
+		}
+		if err != nil {
+			return rd.NoData, false, err
+		}
+		b = &blk
+		l.mu.Lock()
+		l.last = b
+		l.mu.Unlock()
+	}
+	i := (y-b.Y)*b.Width + x - b.X
+	if !b.Valid[i] {
+		return rd.NoData, false, nil
+	}
+	return b.Pixels.Float64(i), true, nil
+}
+
+// Window reads the pixels of a band opened by OpenBand inside win, as
+// Raster.ReadWindow does, the blocks going through the cache of the band.
+func (rd *RasterData) Window(win Window) (*RasterData, error) {
+	if rd.lazy == nil {
+		return nil, errNotOpened
+	}
+	return rd.lazy.r.readWindow(rd.BaseTab.Reader(), win, rd.lazy.opts)
+}
+
+// Load reads every pixel of a band opened by OpenBand into GeoData, as Read
+// would, the blocks that cannot be read going into Suspect. Once loaded, At
+// takes the pixels from GeoData.
+func (rd *RasterData) Load() error {
+	if rd.lazy == nil {
+		return errNotOpened
+	}
+	if rd.GeoData != nil {
+		return nil
+	}
+	rb := &rd.RasBase
+	all, err := rd.Window(Window{MaxX: float64(rb.BandWidth), MaxY: float64(rb.BandHeight), Pixels: true})
+	if err != nil {
+		return err
+	}
+	rd.GeoData, rd.Suspect = all.GeoData, all.Suspect
+	rd.MinPx, rd.MinPy, rd.MaxPx, rd.MaxPy = all.MinPx, all.MinPy, all.MaxPx, all.MaxPy
+	return nil
+}
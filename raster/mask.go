@@ -0,0 +1,74 @@
+package raster
+
+import (
+	"fmt"
+	"math"
+)
+
+// Mask is a validity mask, read by ReadMask from the first band of a
+// GeoTIFF in the coordinates of the rasters it masks. A read with it as
+// ReadOptions.Mask leaves a pixel without data where the pixel of the mask
+// its center falls in is zero or nodata, or where it falls outside the
+// mask.
+type Mask struct {
+	src  string
+	w, h int
+	gt   [6]float64
+	keep []bool
+}
+
+// ReadMask reads the Mask of the GeoTIFF at path.
+func ReadMask(path string) (*Mask, error) {
+	t, err := ReadTIFF(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.PixelScale) < 2 || len(t.Tiepoint) < 5 || t.PixelScale[0] == 0 || t.PixelScale[1] == 0 {
+		return nil, fmt.Errorf("%s: no georeferencing", path)
+	}
+	m := &Mask{src: path, w: t.Width, h: t.Height, keep: make([]bool, t.Width*t.Height)}
+	m.gt = [6]float64{t.Tiepoint[3] - t.Tiepoint[0]*t.PixelScale[0], t.PixelScale[0], 0,
+		t.Tiepoint[4] + t.Tiepoint[1]*t.PixelScale[1], 0, -t.PixelScale[1]}
+	band := t.Bands[0]
+	for i := range m.keep {
+		v := band.Float64(i)
+		m.keep[i] = v != 0 && !math.IsNaN(v) && (t.NoData == nil || v != *t.NoData)
+	}
+	return m, nil
+}
+
+// String returns the path m was read from, or "" for a nil Mask.
+func (m *Mask) String() string {
+	if m == nil {
+		return ""
+	}
+	return m.src
+}
+
+// lookup returns, for each column and each row of band rb, the column and
+// row of m its pixel centers fall in, -1 for those outside it.
+func (m *Mask) lookup(rb *RasterBase) (cols, rows []int) {
+	cell := func(v, origin, size float64, n int) int {
+		i := math.Floor((v - origin) / size)
+		if i < 0 || i >= float64(n) {
+			return -1
+		}
+		return int(i)
+	}
+	cols = make([]int, rb.BandWidth)
+	for x := range cols {
+		cols[x] = cell(rb.PixelCenter(float64(x), 0)[0], m.gt[0], m.gt[1], m.w)
+	}
+	rows = make([]int, rb.BandHeight)
+	for y := range rows {
+		rows[y] = cell(rb.PixelCenter(0, float64(y))[1], m.gt[3], m.gt[5], m.h)
+	}
+	return cols, rows
+}
+
+// keeps reports whether the mask keeps the pixel of the band read at column
+// x, row y.
+func (g bandGeometry) keeps(x, y int) bool {
+	mx, my := g.maskCols[x], g.maskRows[y]
+	return mx >= 0 && my >= 0 && g.mask.keep[my*g.mask.w+mx]
+}
@@ -0,0 +1,122 @@
+package raster
+
+import (
+	"encoding/binary"
+	"math"
+	"unsafe"
+)
+
+// PixelType lists the Go types pixels are held in: uint8 for the 1bit, 4bit
+// and uint8 data types, float64 for 64bit, and the type of the same name for
+// the others.
+type PixelType interface {
+	uint8 | int8 | int16 | uint16 | int32 | uint32 | float32 | float64
+}
+
+// Pixels is a run of pixels of one band, row by row, held in a Buffer of the
+// Go type of its data type rather than one interface value each. Float64
+// reads any of them without knowing the type; code wanting the values as
+// stored asserts the Buffer, such as rd.GeoData.(raster.Buffer[int16]).
+type Pixels interface {
+	// Len returns the number of pixels.
+	Len() int
+	// Float64 returns pixel i converted to float64.
+	Float64(i int) float64
+	// AppendLittleEndian appends pixels i to j-1 to b, each little-endian in
+	// the width of its type, as raster files store them.
+	AppendLittleEndian(b []byte, i, j int) []byte
+	// Fill sets every pixel to v converted to the type of the pixels.
+	Fill(v float64)
+
+	// copyFrom copies pixels i to j-1 of src, a Buffer of the same type, to
+	// pixel at on.
+	copyFrom(at int, src Pixels, i, j int)
+	// elemSize returns the bytes a pixel takes.
+	elemSize() int
+}
+
+// Buffer holds pixels of type T.
+type Buffer[T PixelType] []T
+
+func (b Buffer[T]) Len() int { return len(b) }
+
+func (b Buffer[T]) Float64(i int) float64 { return float64(b[i]) }
+
+func (b Buffer[T]) AppendLittleEndian(out []byte, i, j int) []byte {
+	switch s := any(b[i:j]).(type) {
+	case Buffer[uint8]:
+		return append(out, s...)
+	case Buffer[int8]:
+		for _, v := range s {
+			out = append(out, uint8(v))
+		}
+	case Buffer[int16]:
+		for _, v := range s {
+			out = binary.LittleEndian.AppendUint16(out, uint16(v))
+		}
+	case Buffer[uint16]:
+		for _, v := range s {
+			out = binary.LittleEndian.AppendUint16(out, v)
+		}
+	case Buffer[int32]:
+		for _, v := range s {
+			out = binary.LittleEndian.AppendUint32(out, uint32(v))
+		}
+	case Buffer[uint32]:
+		for _, v := range s {
+			out = binary.LittleEndian.AppendUint32(out, v)
+		}
+	case Buffer[float32]:
+		for _, v := range s {
+			out = binary.LittleEndian.AppendUint32(out, math.Float32bits(v))
+		}
+	case Buffer[float64]:
+		for _, v := range s {
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(v))
+		}
+	}
+	return out
+}
+
+func (b Buffer[T]) copyFrom(at int, src Pixels, i, j int) {
+	copy(b[at:], src.(Buffer[T])[i:j])
+}
+
+func (b Buffer[T]) elemSize() int {
+	var t T
+	return int(unsafe.Sizeof(t))
+}
+
+func (b Buffer[T]) Fill(v float64) {
+	t := T(v)
+	for i := range b {
+		b[i] = t
+	}
+}
+
+// NewPixels returns n pixels of dataType, all zero.
+func NewPixels(dataType string, n int) Pixels {
+	switch dataType {
+	case "1bit", "4bit", "uint8":
+		return make(Buffer[uint8], n)
+	case "int8":
+		return make(Buffer[int8], n)
+	case "int16":
+		return make(Buffer[int16], n)
+	case "uint16":
+		return make(Buffer[uint16], n)
+	case "int32":
+		return make(Buffer[int32], n)
+	case "uint32":
+		return make(Buffer[uint32], n)
+	case "float32":
+		return make(Buffer[float32], n)
+	default:
+		return make(Buffer[float64], n)
+	}
+}
+
+// pixelSize returns the bytes a pixel of dataType takes in a Buffer.
+func pixelSize(dataType string) int64 {
+	return int64(NewPixels(dataType, 0).elemSize())
+}
@@ -0,0 +1,157 @@
+package raster
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// Raster is a raster dataset of an open geodatabase.
+type Raster struct {
+	Name  string
+	WKT   string // coordinate system, "" if unknown
+	Bands []RasterBand
+
+	db     *gdb.Geodatabase
+	bndID  int
+	blocks func() (*blockIndex, error) // indexBlocks, run once for ReadBlock
+}
+
+// Open looks up the raster called name in db and lists its bands.
+func Open(db *gdb.Geodatabase, name string) (*Raster, error) {
+	bndID, _ := TableIDs(db.MasterTable(), name)
+	if bndID == 0 {
+		return nil, fmt.Errorf("raster %s is missing its band or block table", name)
+	}
+	bt, err := db.OpenTable(BndTablePrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+	bands, err := readBands(bt)
+	if err != nil {
+		return nil, err
+	}
+	r := &Raster{Name: name, WKT: db.WKT(name), Bands: bands, db: db, bndID: bndID}
+	r.blocks = sync.OnceValues(r.indexBlocks)
+	return r, nil
+}
+
+// Band returns the description of the band with sequence number seq, or of
+// the first band if seq is 0, with the scale and offset its pixels are
+// packed by, if any, from the aux table of the raster, the unit of their
+// values, as unit finds it, and how they are to be shown, with the colors
+// its value attribute table gives them.
+func (r *Raster) Band(seq int) (RasterBase, error) {
+	for _, band := range r.Bands {
+		if seq == 0 || int(band.SequenceNbr) == seq {
+			bt, err := r.db.OpenTable(BndTablePrefix + r.Name)
+			if err != nil {
+				return RasterBase{}, err
+			}
+			rb, err := readRasterBase(*bt, gdb.TableFileName(r.bndID), band.ID)
+			if err != nil {
+				return rb, err
+			}
+			rb.BaseTab.Close()
+			// A band whose properties cannot be read is taken as not packed.
+			props, _ := r.bandProperties(band)
+			rb.Scale, rb.Offset = scaleOffset(props)
+			rb.Unit = r.unit(band, props)
+			// A band whose color map cannot be read is shown without one.
+			rb.ColorMap, _ = r.colorMap(&rb)
+			rb.ColorInterp = colorInterp(&rb, int(band.SequenceNbr), len(r.Bands))
+			return rb, nil
+		}
+	}
+	return RasterBase{}, fmt.Errorf("raster %s has no band %d", r.Name, seq)
+}
+
+// Read reads band opts.Band into memory, or hands its blocks to opts.Block.
+// Blocks that cannot be read or decoded are left as nodata and listed in the
+// Suspect blocks of the result, or, if the context the geodatabase was opened
+// with asks for gdb.AbortOnBad, the first ends the read, the SuspectBlock
+// returned as the error. Any other error means the band could not be read at
+// all, or that the context is done.
+func (r *Raster) Read(opts ReadOptions) (*RasterData, error) {
+	rb, err := r.Band(opts.Band)
+	if err != nil {
+		return nil, err
+	}
+	bt, err := r.db.OpenTable(BlkTablePrefix + r.Name)
+	if err != nil {
+		return nil, err
+	}
+	rd, err := readRasterData(*bt, rb, opts)
+	if err != nil {
+		return nil, err
+	}
+	rd.BaseTab.Close()
+	return &rd, nil
+}
+
+// BlockIterator hands out the blocks of a band as they are decoded, for
+// range.
+type BlockIterator struct {
+	r       *Raster
+	opts    ReadOptions
+	suspect []SuspectBlock
+	err     error
+}
+
+// errStopped ends a read whose blocks are no longer wanted.
+var errStopped = errors.New("stopped")
+
+// Blocks returns an iterator over the blocks of band opts.Band, as Read
+// hands them to opts.Block, which Blocks sets:
+//
+//	blocks := r.Blocks(raster.ReadOptions{Band: 1})
+//	for blk := range blocks.All() {
+//		...
+//	}
+//	if err := blocks.Err(); err != nil {
+//		...
+//	}
+//
+// The band is read while All is ranged over, so the blocks are never all
+// held in memory at once.
+func (r *Raster) Blocks(opts ReadOptions) *BlockIterator {
+	return &BlockIterator{r: r, opts: opts}
+}
+
+// All returns the blocks of the band as a sequence for range. Blocks that
+// cannot be read or decoded are left out, and listed by Suspect once the
+// sequence has ended.
+func (it *BlockIterator) All() iter.Seq[Block] {
+	return func(yield func(Block) bool) {
+		opts := it.opts
+		opts.Block = func(b Block) error {
+			if !yield(b) {
+				return errStopped
+			}
+			return nil
+		}
+		rd, err := it.r.Read(opts)
+		if errors.Is(err, errStopped) {
+			return
+		}
+		it.err = err
+		if rd != nil {
+			it.suspect = rd.Suspect
+		}
+	}
+}
+
+// Err returns the error that ended All early, if any: the band could not be
+// read, or the context the geodatabase was opened with is done.
+func (it *BlockIterator) Err() error {
+	return it.err
+}
+
+// Suspect returns the blocks left out of a sequence ranged to its end.
+func (it *BlockIterator) Suspect() []SuspectBlock {
+	return it.suspect
+}
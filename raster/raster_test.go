@@ -0,0 +1,224 @@
+package raster_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/internal/gdbfixture"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+var dataTypes = []string{"1bit", "4bit", "int8", "uint8", "int16", "uint16", "int32", "uint32", "float32", "64bit"}
+
+const albers = `PROJCS["NAD_1983_Contiguous_USA_Albers",GEOGCS["GCS_North_American_1983",DATUM["D_North_American_1983",SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],PROJECTION["Albers"],UNIT["Meter",1.0],AUTHORITY["EPSG",5070]]`
+
+// noData is the pixel value the test rasters leave without data.
+const noData = 15
+
+// testRaster is a raster of two bands of 37 by 21 pixels of dataType, in
+// blocks of 16 by 8 that do not fit it, so that the last column and row of
+// blocks reach past it. Every eleventh pixel has no data, nor has any of the
+// first block, which is left out of the block table.
+func testRaster(dataType, compression string) gdbfixture.Raster {
+	r := gdbfixture.Raster{
+		Name: "r_" + dataType + "_" + compression, DataType: dataType, Compression: compression,
+		Width: 37, Height: 21, BlockWidth: 16, BlockHeight: 8,
+		MinX: 1000, MaxY: 5000, CellSize: 10, WKT: albers, NoData: new(float64),
+	}
+	*r.NoData = noData
+	for b := range 2 {
+		px := make([]float64, r.Width*r.Height)
+		for i := range px {
+			x, y := i%r.Width, i/r.Width
+			switch {
+			case i%11 == 0 || (x < 16 && y < 8):
+				px[i] = noData
+			case dataType == "1bit":
+				px[i] = float64((i + b) % 2)
+			case dataType == "4bit" || dataType[0] == 'u':
+				px[i] = float64((i*7 + b*3) % 13)
+			default:
+				px[i] = float64((i*7+b*3)%13 - 6)
+			}
+			if px[i] != noData && (dataType == "float32" || dataType == "64bit") {
+				px[i] += 0.5
+			}
+		}
+		r.Bands = append(r.Bands, px)
+	}
+	return r
+}
+
+// openRasters opens the rasters of a geodatabase holding only them.
+func openRasters(t *testing.T, rasters ...gdbfixture.Raster) *gdb.Geodatabase {
+	t.Helper()
+	fsys, err := gdbfixture.Geodatabase{Rasters: rasters}.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gdb.OpenFS(fsys, "fixture.gdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestReadRaster(t *testing.T) {
+	for _, dataType := range dataTypes {
+		for _, compression := range []string{"uncompressed", "lz77"} {
+			t.Run(dataType+" "+compression, func(t *testing.T) {
+				want := testRaster(dataType, compression)
+				db := openRasters(t, want)
+				r, err := raster.Open(db, want.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for b := range want.Bands {
+					rd, err := r.Read(raster.ReadOptions{Band: b + 1})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(rd.Suspect) > 0 {
+						t.Errorf("band %d: suspect blocks %v", b+1, rd.Suspect)
+					}
+					if w, h := int(rd.RasBase.BandWidth), int(rd.RasBase.BandHeight); w != want.Width || h != want.Height {
+						t.Fatalf("band %d is %dx%d, want %dx%d", b+1, w, h, want.Width, want.Height)
+					}
+					checkPixels(t, rd, want.Bands[b], want.Width, 0, 0)
+				}
+			})
+		}
+	}
+}
+
+// checkPixels checks the pixels of rd against those of the band want of
+// width pixels a row, from column x0 and row y0, its pixels without data
+// against rd.NoData.
+func checkPixels(t *testing.T, rd *raster.RasterData, want []float64, width, x0, y0 int) {
+	t.Helper()
+	w, h := int(rd.RasBase.BandWidth), int(rd.RasBase.BandHeight)
+	bad := 0
+	for y := range h {
+		for x := range w {
+			got, exp := rd.GeoData.Float64(y*w+x), want[(y0+y)*width+x0+x]
+			if exp == noData {
+				exp = rd.NoData
+			}
+			if got != exp {
+				if bad < 5 {
+					t.Errorf("pixel %d,%d is %v, want %v", x0+x, y0+y, got, exp)
+				}
+				bad++
+			}
+		}
+	}
+	if bad > 0 {
+		t.Errorf("%d of %d pixels differ", bad, w*h)
+	}
+}
+
+func TestReadWindow(t *testing.T) {
+	want := testRaster("int16", "lz77")
+	r, err := raster.Open(openRasters(t, want), want.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := r.ReadWindow(2, raster.Window{MinX: 10, MinY: 3, MaxX: 30, MaxY: 21, Pixels: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := rd.RasBase.BandWidth, rd.RasBase.BandHeight; w != 20 || h != 18 {
+		t.Fatalf("window is %dx%d, want 20x18", w, h)
+	}
+	checkPixels(t, rd, want.Bands[1], want.Width, 10, 3)
+	if gt := rd.RasBase.GeoTransform; gt[0] != 1100 || gt[3] != 4970 {
+		t.Errorf("window starts at %v,%v, want 1100,4970", gt[0], gt[3])
+	}
+}
+
+// TestOpenBand reads the pixels of a band opened by OpenBand from several
+// goroutines at once, through At and Window, from the block table it keeps
+// open.
+func TestOpenBand(t *testing.T) {
+	want := testRaster("int16", "lz77")
+	r, err := raster.Open(openRasters(t, want), want.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := r.OpenBand(raster.ReadOptions{Band: 2, Cache: raster.NewBlockCache(1 << 20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.BaseTab.Close()
+	var wg sync.WaitGroup
+	for g := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := g; i < want.Width*want.Height; i += 4 {
+				x, y := i%want.Width, i/want.Width
+				v, ok, err := rd.At(x, y)
+				if exp := want.Bands[1][i]; err != nil || ok != (exp != noData) || ok && v != exp {
+					t.Errorf("pixel %d,%d is %v, %v, %v, want %v", x, y, v, ok, err, exp)
+					return
+				}
+			}
+			win, err := rd.Window(raster.Window{MinX: 10, MinY: 3, MaxX: 30, MaxY: 21, Pixels: true})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			checkPixels(t, win, want.Bands[1], want.Width, 10, 3)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReadBlock(t *testing.T) {
+	want := testRaster("uint8", "uncompressed")
+	r, err := raster.Open(openRasters(t, want), want.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadBlock(1, 0, 0); !errors.Is(err, raster.ErrNoBlock) {
+		t.Errorf("block without data: %v, want ErrNoBlock", err)
+	}
+	b, err := r.ReadBlock(1, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.X != 32 || b.Y != 16 || b.Width != 16 || b.Height != 8 {
+		t.Errorf("block at %d,%d of %dx%d, want at 32,16 of 16x8", b.X, b.Y, b.Width, b.Height)
+	}
+	// Only 5 columns and 5 rows of the last block are in the raster.
+	for i, valid := range b.Valid {
+		x, y := b.X+i%b.Width, b.Y+i/b.Width
+		inside := x < want.Width && y < want.Height
+		if inside && valid != (want.Bands[0][y*want.Width+x] != noData) || !inside && valid {
+			t.Errorf("pixel %d,%d valid %v", x, y, valid)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	want := testRaster("float32", "lz77")
+	r, err := raster.Open(openRasters(t, want), want.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := r.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprintf("%d %d %d %d %d %s %s %d %v %d", md.Width, md.Height, md.BlockWidth, md.BlockHeight, md.BandCount, md.DataType, md.Compression, md.EPSG, md.GeoTransform, md.PyramidLevels)
+	if exp := "37 21 16 8 2 float32 lz77 5070 [1000 10 0 5000 0 -10] 0"; got != exp {
+		t.Errorf("info %s, want %s", got, exp)
+	}
+	if len(md.Bands) != 2 || md.Bands[1].Band != 2 || md.Bands[1].Name != "Band_2" {
+		t.Errorf("bands %+v", md.Bands)
+	}
+}
@@ -0,0 +1,259 @@
+package raster
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// RasterReader reads the blocks of the bands of a raster one at a time, in
+// any order, for programs that want only some of them rather than whole
+// bands. *Raster is one.
+type RasterReader interface {
+	// ReadBlock reads the block at column col, row row of the block grid
+	// of band band, by sequence number, 0 for the first.
+	ReadBlock(band, col, row int) (Block, error)
+}
+
+var _ RasterReader = (*Raster)(nil)
+
+// ErrNoBlock is the error of ReadBlock for a block the block table does not
+// hold, as ArcGIS leaves out blocks without data: its pixels are nodata.
+var ErrNoBlock = errors.New("no such block")
+
+// blockCell is the place of a full resolution block of a band: the band by
+// ID and the column and row of the block grid.
+type blockCell struct {
+	band, col, row int
+}
+
+// blockIndex is where the full resolution blocks of a raster are in its
+// block table, how many pyramid levels each band has, and the bands
+// ReadBlock has read them for.
+type blockIndex struct {
+	fids   map[blockCell]int
+	levels map[int]int // by band ID, the highest rrd_factor of its blocks
+
+	mu    sync.Mutex
+	bands map[int]RasterBase // by sequence number
+}
+
+// indexBlocks walks the block table of r for the rows of its full
+// resolution blocks, and the pyramid levels above them. A live block wins
+// over a deleted one read back for the same place, as for Read; rows that
+// cannot be read are left out.
+func (r *Raster) indexBlocks() (*blockIndex, error) {
+	bt, err := r.db.OpenTable(BlkTablePrefix + r.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+	ix := &blockIndex{fids: make(map[blockCell]int), levels: make(map[int]int), bands: make(map[int]RasterBase)}
+	for fid := 0; fid < int(bt.NFeaturesX); fid++ {
+		if err := bt.Context().Err(); err != nil {
+			return nil, err
+		}
+		blk, ok, err := ReadBlockRow(bt, fid)
+		if err != nil || !ok || blk.Data == nil {
+			continue
+		}
+		if blk.RRDFactor != 0 {
+			ix.levels[int(blk.BandID)] = max(ix.levels[int(blk.BandID)], int(blk.RRDFactor))
+			continue
+		}
+		cell := blockCell{int(blk.BandID), int(blk.ColNbr), int(blk.RowNbr)}
+		if _, ok := ix.fids[cell]; !ok {
+			ix.fids[cell] = fid
+		}
+	}
+	return ix, nil
+}
+
+// band returns the description of band seq of r, read once.
+func (ix *blockIndex) band(r *Raster, seq int) (RasterBase, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if rb, ok := ix.bands[seq]; ok {
+		return rb, nil
+	}
+	rb, err := r.Band(seq)
+	if err != nil {
+		return rb, err
+	}
+	ix.bands[seq] = rb
+	return rb, nil
+}
+
+// ReadBlock reads, inflates and decodes the block at column col, row row of
+// the block grid of band band, by sequence number, 0 for the first, at full
+// resolution. The block comes whole, its X and Y those of its top left pixel
+// in the band, so that the blocks along the edges reach outside it. The
+// first call walks the block table for where each block is; later ones seek
+// to the row of the block. A block the table does not hold is ErrNoBlock.
+func (r *Raster) ReadBlock(band, col, row int) (Block, error) {
+	ix, err := r.blocks()
+	if err != nil {
+		return Block{}, err
+	}
+	rb, err := ix.band(r, band)
+	if err != nil {
+		return Block{}, err
+	}
+	bt, err := r.db.OpenTable(BlkTablePrefix + r.Name)
+	if err != nil {
+		return Block{}, err
+	}
+	defer bt.Close()
+	b, err := r.readBlock(bt, ix, &rb, col, row, nil)
+	if err != nil {
+		return b, fmt.Errorf("raster %s band %d, block at row %d, column %d: %w", r.Name, band, row, col, err)
+	}
+	return b, nil
+}
+
+// readBlock is ReadBlock on block table bt for band rb, failing with
+// ErrNoBlock, or why the block could not be read or decoded. The block is
+// taken from cache, if it holds it, or kept in it, as Read does, unless it
+// is a deleted row read back; the pixels of a block from the cache must not
+// be modified.
+func (r *Raster) readBlock(bt *gdb.BaseTable, ix *blockIndex, rb *RasterBase, col, row int, cache *BlockCache) (Block, error) {
+	fid, ok := ix.fids[blockCell{rb.BandID, col, row}]
+	if !ok {
+		return Block{}, ErrNoBlock
+	}
+	if bt.Deleted(fid) {
+		cache = nil
+	}
+	bw, bh := int(rb.BlockWidth), int(rb.BlockHeight)
+	b := Block{Width: bw, Height: bh}
+	key := blockKey{bt.GdbTablePath, rb.BandID, int32(row), int32(col)}
+	if vals, valid, ok := cache.get(key); ok {
+		b.Pixels, b.Valid = vals, valid
+	} else {
+		blk, ok, err := ReadBlockRow(bt, fid)
+		if err == nil && !ok {
+			err = fmt.Errorf("row %d is gone", fid+1)
+		}
+		var raw []byte
+		if err == nil {
+			raw, err = InflateBlock(blk.Data, rb.CompressionType)
+		}
+		if err == nil {
+			b.Pixels, b.Valid, err = DecodeBlock(raw, rb.DataType, bw*bh)
+		}
+		if err != nil {
+			return Block{}, err
+		}
+		cache.put(key, b.Pixels, b.Valid)
+	}
+	colOffset, rowOffset := rb.gridOffset()
+	b.X, b.Y = col*bw-colOffset, row*bh-rowOffset
+	return b, nil
+}
+
+// gridOffset returns the column and row of the band at which the block
+// grid starts.
+func (rb *RasterBase) gridOffset() (int, int) {
+	return int(math.Round((rb.EMinX - rb.BlockOriginX) / rb.GeoTransform[1])),
+		int(math.Round((rb.BlockOriginY - rb.EMaxY) / -rb.GeoTransform[5]))
+}
+
+// A Window is a rectangle of a band for ReadWindow: MinX, MinY, MaxX and
+// MaxY in the coordinates of the raster or, with Pixels, the columns MinX
+// up to MaxX and the rows MinY up to MaxY of the band, rows counting down
+// from the top.
+type Window struct {
+	MinX, MinY, MaxX, MaxY float64
+	Pixels                 bool
+}
+
+// ReadWindow reads the pixels of band band, by sequence number, 0 for the
+// first, inside win, clipped to the band, into one buffer: rd.GeoData, row
+// by row, and rd.RasBase the band cut down to it, with its geotransform.
+// Only the blocks win overlaps are read, through ReadBlock, rather than the
+// whole block table as for Read. Pixels of blocks the table does not hold
+// are nodata, as are those of blocks that cannot be read or decoded, listed
+// in rd.Suspect, or if the context the geodatabase was opened with asks for
+// gdb.AbortOnBad, returned as the error. It fails if win does not overlap
+// the band.
+func (r *Raster) ReadWindow(band int, win Window) (*RasterData, error) {
+	bt, err := r.db.OpenTable(BlkTablePrefix + r.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer bt.Close()
+	return r.readWindow(bt, win, ReadOptions{Band: band})
+}
+
+// readWindow is ReadWindow on block table bt for band opts.Band, its pixels
+// without data opts.NoData, if set, and its blocks read through opts.Cache.
+func (r *Raster) readWindow(bt *gdb.BaseTable, win Window, opts ReadOptions) (*RasterData, error) {
+	ix, err := r.blocks()
+	if err != nil {
+		return nil, err
+	}
+	rb, err := ix.band(r, opts.Band)
+	if err != nil {
+		return nil, err
+	}
+	noData, err := opts.NoDataFor(rb.DataType)
+	if err != nil {
+		return nil, err
+	}
+	var x0, y0, x1, y1 int
+	if win.Pixels {
+		x0, y0 = max(int(math.Floor(win.MinX)), 0), max(int(math.Floor(win.MinY)), 0)
+		x1, y1 = min(int(math.Ceil(win.MaxX)), int(rb.BandWidth)), min(int(math.Ceil(win.MaxY)), int(rb.BandHeight))
+	} else {
+		x0, y0, x1, y1 = rb.PixelWindow([]float64{win.MinX, win.MinY, win.MaxX, win.MaxY})
+	}
+	if x1 <= x0 || y1 <= y0 {
+		return nil, fmt.Errorf("window %v does not overlap band %d", win, rb.BandID)
+	}
+
+	rd := &RasterData{RasBase: rb, NoData: noData}
+	rd.RasBase.crop(x0, y0, x1-x0, y1-y0)
+	width, height := x1-x0, y1-y0
+	rd.GeoData = NewPixels(rb.DataType, width*height)
+	rd.GeoData.Fill(rd.NoData)
+	rd.MinPx, rd.MinPy, rd.MaxPx, rd.MaxPy = width, height, -1, -1
+
+	abort := gdb.OnErrorOf(bt.Context()) == gdb.AbortOnBad
+	bw, bh := int(rb.BlockWidth), int(rb.BlockHeight)
+	colOffset, rowOffset := rb.gridOffset()
+	for row := floorDiv(rowOffset+y0, bh); row <= floorDiv(rowOffset+y1-1, bh); row++ {
+		for col := floorDiv(colOffset+x0, bw); col <= floorDiv(colOffset+x1-1, bw); col++ {
+			if err := bt.Context().Err(); err != nil {
+				return nil, err
+			}
+			b, err := r.readBlock(bt, ix, &rb, col, row, opts.Cache)
+			if errors.Is(err, ErrNoBlock) {
+				continue
+			}
+			if err != nil {
+				s := SuspectBlock{int32(row), int32(col), "skipped: " + err.Error(), true}
+				if abort {
+					return nil, s
+				}
+				rd.Suspect = append(rd.Suspect, s)
+				continue
+			}
+			// The part of the block inside the window, in its pixels.
+			bx0, by0 := max(x0-b.X, 0), max(y0-b.Y, 0)
+			bx1, by1 := min(x1-b.X, b.Width), min(y1-b.Y, b.Height)
+			cut := Block{X: b.X + bx0 - x0, Y: b.Y + by0 - y0, Width: bx1 - bx0, Height: by1 - by0}
+			cut.Pixels = NewPixels(rb.DataType, cut.Width*cut.Height)
+			cut.Valid = make([]bool, cut.Width*cut.Height)
+			for y := by0; y < by1; y++ {
+				i, j := y*b.Width+bx0, y*b.Width+bx1
+				cut.Pixels.copyFrom((y-by0)*cut.Width, b.Pixels, i, j)
+				copy(cut.Valid[(y-by0)*cut.Width:], b.Valid[i:j])
+			}
+			rd.place(&cut)
+		}
+	}
+	return rd, nil
+}
@@ -0,0 +1,135 @@
+package raster
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Reclass remaps the values of pixels, as read by ReadReclass from a CSV
+// file of rows of a value and what it becomes, or of the least and greatest
+// of a range of values, both in it, and what they become:
+//
+//	from,to,class
+//	1,100,1
+//	250,2
+//	*,nodata
+//
+// The first row that matches a value wins. A value of * matches every
+// value, for what no row before it matches; without one those values stay
+// as they are. What a value becomes may be nodata, or empty for the same.
+// A first row that is not numbers is a header, and rows starting # are left
+// out.
+type Reclass struct {
+	rules []reclassRule
+}
+
+type reclassRule struct {
+	min, max float64
+	to       float64 // NaN for nodata
+}
+
+// ReadReclass reads the Reclass of the CSV file at path.
+func ReadReclass(path string) (*Reclass, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord, r.Comment, r.TrimLeadingSpace = -1, '#', true
+	rc := &Reclass{}
+	for n := 0; ; n++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rule, err := parseReclassRule(rec)
+		if err != nil {
+			if n == 0 {
+				continue
+			}
+			l, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: %w", path, l, err)
+		}
+		rc.rules = append(rc.rules, rule)
+	}
+	if len(rc.rules) == 0 {
+		return nil, fmt.Errorf("%s: no values to remap", path)
+	}
+	return rc, nil
+}
+
+// parseReclassRule reads a row of a reclass file: a value, or the bounds of
+// a range, and what it becomes.
+func parseReclassRule(rec []string) (reclassRule, error) {
+	number := func(s string) (float64, error) {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsNaN(v) {
+			return 0, fmt.Errorf("%q is not a number", s)
+		}
+		return v, nil
+	}
+	var rule reclassRule
+	var err error
+	switch {
+	case len(rec) == 2 && strings.TrimSpace(rec[0]) == "*":
+		rule.min, rule.max = math.Inf(-1), math.Inf(1)
+	case len(rec) == 2:
+		if rule.min, err = number(rec[0]); err != nil {
+			return rule, err
+		}
+		rule.max = rule.min
+	case len(rec) == 3:
+		if rule.min, err = number(rec[0]); err != nil {
+			return rule, err
+		}
+		if rule.max, err = number(rec[1]); err != nil {
+			return rule, err
+		}
+		if rule.max < rule.min {
+			return rule, fmt.Errorf("range %v to %v is empty", rule.min, rule.max)
+		}
+	default:
+		return rule, fmt.Errorf("%d fields, not a value and what it becomes or a range and what it becomes", len(rec))
+	}
+	to := strings.TrimSpace(rec[len(rec)-1])
+	if to == "" || strings.EqualFold(to, "nodata") {
+		rule.to = math.NaN()
+		return rule, nil
+	}
+	rule.to, err = number(to)
+	return rule, err
+}
+
+// Map returns what v becomes, and false if it becomes nodata.
+func (rc *Reclass) Map(v float64) (float64, bool) {
+	for _, rule := range rc.rules {
+		if v >= rule.min && v <= rule.max {
+			return rule.to, !math.IsNaN(rule.to)
+		}
+	}
+	return v, true
+}
+
+// Fits reports whether a band of dataType holds every value rc remaps
+// values to.
+func (rc *Reclass) Fits(dataType string) bool {
+	p := NewPixels(dataType, 1)
+	for _, rule := range rc.rules {
+		if math.IsNaN(rule.to) {
+			continue
+		}
+		if p.Fill(rule.to); p.Float64(0) != rule.to {
+			return false
+		}
+	}
+	return true
+}
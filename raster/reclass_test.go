@@ -0,0 +1,116 @@
+package raster_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// writeReclass writes csv to a file for ReadReclass, returning its path.
+func writeReclass(t *testing.T, csv string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "reclass.csv")
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestReadReclass remaps values through ranges, single values, overlaps in
+// which the first row wins, nodata and *.
+func TestReadReclass(t *testing.T) {
+	nan := math.NaN()
+	for _, tc := range []struct {
+		name string
+		csv  string
+		maps [][2]float64 // a value and what it becomes, NaN for nodata
+		fits []string
+		not  []string
+	}{
+		{
+			name: "ranges",
+			csv:  "from,to,class\n1,100,1\n100.5, 200 ,2\n250,3\n",
+			maps: [][2]float64{{1, 1}, {50, 1}, {100, 1}, {100.25, 100.25}, {100.5, 2}, {200, 2}, {250, 3}, {0, 0}, {-5, -5}},
+			fits: []string{"uint8", "int8", "int16", "float32"},
+		},
+		{
+			name: "overlaps",
+			csv:  "0,10,1\n5,20,2\n7,3\n*,nodata\n",
+			maps: [][2]float64{{5, 1}, {7, 1}, {10, 1}, {10.5, 2}, {20, 2}, {21, nan}, {-1, nan}},
+		},
+		{
+			name: "nodata",
+			csv:  "# codes\n-9999,nodata\n0,\n1,2,-1.5\n",
+			maps: [][2]float64{{-9999, nan}, {0, nan}, {1.5, -1.5}, {3, 3}},
+			fits: []string{"float32", "float64"},
+			not:  []string{"uint8", "int16", "int32"},
+		},
+		{
+			name: "no header",
+			csv:  "*,300\n",
+			maps: [][2]float64{{1, 300}, {-1e9, 300}},
+			fits: []string{"int16", "uint16"},
+			not:  []string{"uint8", "int8"},
+		},
+		{
+			// A first row that is not numbers is a header, even one meant
+			// to remap.
+			name: "bad first row",
+			csv:  "1,x\n2,4\n",
+			maps: [][2]float64{{1, 1}, {2, 4}},
+		},
+	} {
+		rc, err := raster.ReadReclass(writeReclass(t, tc.csv))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		for _, m := range tc.maps {
+			got, ok := rc.Map(m[0])
+			if math.IsNaN(m[1]) && ok || !math.IsNaN(m[1]) && (!ok || got != m[1]) {
+				t.Errorf("%s: %v becomes %v, %v, want %v", tc.name, m[0], got, ok, m[1])
+			}
+		}
+		for _, dataType := range tc.fits {
+			if !rc.Fits(dataType) {
+				t.Errorf("%s: does not fit %s", tc.name, dataType)
+			}
+		}
+		for _, dataType := range tc.not {
+			if rc.Fits(dataType) {
+				t.Errorf("%s: fits %s", tc.name, dataType)
+			}
+		}
+	}
+}
+
+// TestReadReclassErrors reads files with rows that cannot be read, and
+// none, failing at the line of the row.
+func TestReadReclassErrors(t *testing.T) {
+	for _, tc := range []struct {
+		csv, want string
+	}{
+		{"from,to,class\n1,100,1\n200,100,2\n", ":3: range 200 to 100 is empty"},
+		{"1,2\nx,3\n", `:2: "x" is not a number`},
+		{"1,2\n3,y\n", `:2: "y" is not a number`},
+		{"1,2\n3,4,5,6\n", ":2: 4 fields"},
+		{"1,2\n3\n", ":2: 1 fields"},
+		{"1,2\n*,1,2\n", `:2: "*" is not a number`},
+		{"1,2\nNaN,3\n", `:2: "NaN" is not a number`},
+		{"1,2\n\"3,4\n", "extraneous or missing"},
+		{"from,to\n", "no values to remap"},
+		{"# nothing\n", "no values to remap"},
+	} {
+		_, err := raster.ReadReclass(writeReclass(t, tc.csv))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: %v, want an error with %q", tc.csv, err, tc.want)
+		}
+	}
+	if _, err := raster.ReadReclass(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Errorf("missing file read")
+	}
+}
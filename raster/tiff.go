@@ -0,0 +1,512 @@
+package raster
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TIFF is the first image of a TIFF file, band by band, as ReadTIFF reads
+// it: a GeoTIFF written by this module or by GDAL, to compare extractions
+// with.
+type TIFF struct {
+	Width, Height int
+	DataType      string   // as that of a RasterBase: uint8, int16, float32, ...
+	Bands         []Pixels // the pixels of each band, row by row
+	NoData        *float64 // the GDAL_NODATA tag, if there is one
+	PixelScale    []float64
+	Tiepoint      []float64
+}
+
+// TIFF tags ReadTIFF reads.
+const (
+	tagWidth           = 256
+	tagHeight          = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagPlanarConfig    = 284
+	tagPredictor       = 317
+	tagTileWidth       = 322
+	tagTileLength      = 323
+	tagTileOffsets     = 324
+	tagTileByteCounts  = 325
+	tagSampleFormat    = 339
+	tagPixelScale      = 33550
+	tagTiepoint        = 33922
+	tagGDALNoData      = 42113
+)
+
+// tiffTypeSizes gives the bytes a value of each TIFF field type takes.
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 16: 8, 17: 8, 18: 8}
+
+// tiffField is a field of the IFD read, its values still in the byte order
+// of the file.
+type tiffField struct {
+	typ   uint16
+	count int
+	data  []byte
+}
+
+// tiffReader reads the IFD and chunks of a TIFF file.
+type tiffReader struct {
+	f      io.ReaderAt
+	order  binary.ByteOrder
+	fields map[uint16]tiffField
+}
+
+// ReadTIFF reads the first image of the TIFF file at path: classic TIFF or
+// BigTIFF, in either byte order, in strips or tiles, its samples interleaved
+// by pixel or held in a plane each, uncompressed or compressed with deflate,
+// LZW, PackBits or ZSTD, with or without a horizontal or floating point
+// predictor. Samples must be 8, 16, 32 or 64 bits, as the data types of
+// raster bands.
+func ReadTIFF(path string) (*TIFF, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := readTIFF(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+func readTIFF(f io.ReaderAt) (*TIFF, error) {
+	r, ifd, big, err := openTIFF(f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.readIFD(ifd, big); err != nil {
+		return nil, err
+	}
+	return r.image()
+}
+
+// openTIFF reads the header of the TIFF file f, returning a reader of it,
+// the offset of its first IFD and whether it is a BigTIFF.
+func openTIFF(f io.ReaderAt) (*tiffReader, uint64, bool, error) {
+	r := &tiffReader{f: f}
+	header := make([]byte, 16)
+	if _, err := f.ReadAt(header[:8], 0); err != nil {
+		return nil, 0, false, fmt.Errorf("reading the header: %w", err)
+	}
+	switch string(header[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil, 0, false, errors.New("not a TIFF file")
+	}
+	switch r.order.Uint16(header[2:]) {
+	case 42:
+		return r, uint64(r.order.Uint32(header[4:])), false, nil
+	case 43:
+		if _, err := f.ReadAt(header[8:16], 8); err != nil {
+			return nil, 0, false, fmt.Errorf("reading the header: %w", err)
+		}
+		return r, r.order.Uint64(header[8:]), true, nil
+	}
+	return nil, 0, false, errors.New("not a TIFF file")
+}
+
+// readIFD reads the fields of the IFD at off, returning the offset of the
+// next IFD, 0 if it is the last.
+func (r *tiffReader) readIFD(off uint64, big bool) (uint64, error) {
+	r.fields = make(map[uint16]tiffField)
+	countSize, entrySize, inline := 2, 12, 4
+	if big {
+		countSize, entrySize, inline = 8, 20, 8
+	}
+	b := make([]byte, countSize)
+	if _, err := r.f.ReadAt(b, int64(off)); err != nil {
+		return 0, fmt.Errorf("reading the IFD: %w", err)
+	}
+	n := uint64(r.order.Uint16(b))
+	if big {
+		n = r.order.Uint64(b)
+	}
+	if n > 4096 {
+		return 0, fmt.Errorf("IFD of %d fields", n)
+	}
+	entries := make([]byte, int(n)*entrySize+inline)
+	if _, err := r.f.ReadAt(entries, int64(off)+int64(countSize)); err != nil {
+		return 0, fmt.Errorf("reading the IFD: %w", err)
+	}
+	for i := 0; i < int(n); i++ {
+		e := entries[i*entrySize : (i+1)*entrySize]
+		tag, typ := r.order.Uint16(e), r.order.Uint16(e[2:])
+		var count uint64
+		var value []byte
+		if big {
+			count, value = r.order.Uint64(e[4:]), e[12:20]
+		} else {
+			count, value = uint64(r.order.Uint32(e[4:])), e[8:12]
+		}
+		size, ok := tiffTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		if count > 1<<28 {
+			return 0, fmt.Errorf("field %d of %d values", tag, count)
+		}
+		data := make([]byte, int(count)*size)
+		if len(data) <= inline {
+			copy(data, value)
+		} else {
+			valueOff := uint64(r.order.Uint32(value))
+			if big {
+				valueOff = r.order.Uint64(value)
+			}
+			if _, err := r.f.ReadAt(data, int64(valueOff)); err != nil {
+				return 0, fmt.Errorf("reading field %d: %w", tag, err)
+			}
+		}
+		r.fields[tag] = tiffField{typ, int(count), data}
+	}
+	next := entries[int(n)*entrySize:]
+	if big {
+		return r.order.Uint64(next), nil
+	}
+	return uint64(r.order.Uint32(next)), nil
+}
+
+// uints returns the values of an integer field, or nil if there is none.
+func (r *tiffReader) uints(tag uint16) []uint64 {
+	fld, ok := r.fields[tag]
+	if !ok {
+		return nil
+	}
+	vals := make([]uint64, fld.count)
+	for i := range vals {
+		switch fld.typ {
+		case 1, 6, 7:
+			vals[i] = uint64(fld.data[i])
+		case 3, 8:
+			vals[i] = uint64(r.order.Uint16(fld.data[2*i:]))
+		case 4, 9:
+			vals[i] = uint64(r.order.Uint32(fld.data[4*i:]))
+		case 16, 17, 18:
+			vals[i] = r.order.Uint64(fld.data[8*i:])
+		default:
+			return nil
+		}
+	}
+	return vals
+}
+
+// uint returns the first value of an integer field, or def if there is none.
+func (r *tiffReader) uint(tag uint16, def uint64) uint64 {
+	if vals := r.uints(tag); len(vals) > 0 {
+		return vals[0]
+	}
+	return def
+}
+
+// doubles returns the values of a DOUBLE field, or nil.
+func (r *tiffReader) doubles(tag uint16) []float64 {
+	fld, ok := r.fields[tag]
+	if !ok || fld.typ != 12 {
+		return nil
+	}
+	vals := make([]float64, fld.count)
+	for i := range vals {
+		vals[i] = math.Float64frombits(r.order.Uint64(fld.data[8*i:]))
+	}
+	return vals
+}
+
+// tiffDataType returns the data type of samples of bits bits and TIFF
+// SampleFormat format.
+func tiffDataType(bits uint64, format uint64) (string, error) {
+	switch {
+	case bits == 8 && format == 1:
+		return "uint8", nil
+	case bits == 8 && format == 2:
+		return "int8", nil
+	case bits == 16 && format == 1:
+		return "uint16", nil
+	case bits == 16 && format == 2:
+		return "int16", nil
+	case bits == 32 && format == 1:
+		return "uint32", nil
+	case bits == 32 && format == 2:
+		return "int32", nil
+	case bits == 32 && format == 3:
+		return "float32", nil
+	case bits == 64 && format == 3:
+		return "float64", nil
+	}
+	return "", fmt.Errorf("samples of %d bits in sample format %d are not supported", bits, format)
+}
+
+// image reads the pixels of the image the IFD describes.
+func (r *tiffReader) image() (*TIFF, error) {
+	t := &TIFF{
+		Width:      int(r.uint(tagWidth, 0)),
+		Height:     int(r.uint(tagHeight, 0)),
+		PixelScale: r.doubles(tagPixelScale),
+		Tiepoint:   r.doubles(tagTiepoint),
+	}
+	if t.Width <= 0 || t.Height <= 0 || int64(t.Width)*int64(t.Height) > 1<<34 {
+		return nil, fmt.Errorf("image of %dx%d pixels", t.Width, t.Height)
+	}
+	spp := int(r.uint(tagSamplesPerPixel, 1))
+	bits := r.uint(tagBitsPerSample, 1)
+	dataType, err := tiffDataType(bits, r.uint(tagSampleFormat, 1))
+	if err != nil {
+		return nil, err
+	}
+	t.DataType = dataType
+	if fld, ok := r.fields[tagGDALNoData]; ok {
+		s := strings.TrimSpace(strings.TrimRight(string(fld.data), "\x00"))
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			t.NoData = &v
+		}
+	}
+
+	// Strips are tiles as wide as the image.
+	tw, th := t.Width, int(r.uint(tagRowsPerStrip, uint64(t.Height)))
+	offsets, counts := r.uints(tagStripOffsets), r.uints(tagStripByteCounts)
+	if _, tiled := r.fields[tagTileWidth]; tiled {
+		tw, th = int(r.uint(tagTileWidth, 0)), int(r.uint(tagTileLength, 0))
+		offsets, counts = r.uints(tagTileOffsets), r.uints(tagTileByteCounts)
+	}
+	th = min(th, t.Height)
+	if tw <= 0 || th <= 0 || spp <= 0 || spp > 1<<16 {
+		return nil, fmt.Errorf("chunks of %dx%d pixels of %d samples", tw, th, spp)
+	}
+	planes, chunkSpp := 1, spp
+	if r.uint(tagPlanarConfig, 1) == 2 {
+		planes, chunkSpp = spp, 1
+	}
+	across, down := (t.Width+tw-1)/tw, (t.Height+th-1)/th
+	if len(offsets) < planes*across*down || len(counts) < len(offsets) {
+		return nil, fmt.Errorf("%d chunks where %d are needed", len(offsets), planes*across*down)
+	}
+	compression, predictor := r.uint(tagCompression, 1), r.uint(tagPredictor, 1)
+
+	// Chunks GDAL left out, being all nodata, are nodata.
+	t.Bands = make([]Pixels, spp)
+	for i := range t.Bands {
+		t.Bands[i] = NewPixels(dataType, t.Width*t.Height)
+		if t.NoData != nil {
+			t.Bands[i].Fill(*t.NoData)
+		}
+	}
+	size := int(bits / 8)
+	for i := 0; i < planes*across*down; i++ {
+		plane, c := i/(across*down), i%(across*down)
+		x0, y0 := c%across*tw, c/across*th
+		if counts[i] == 0 {
+			continue
+		}
+		raw := make([]byte, counts[i])
+		if _, err := r.f.ReadAt(raw, int64(offsets[i])); err != nil {
+			return nil, fmt.Errorf("reading chunk %d: %w", i, err)
+		}
+		data, err := decompressChunk(raw, compression)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		rowBytes := tw * chunkSpp * size
+		rows := min(th, len(data)/rowBytes)
+		order := r.order
+		for y := 0; y < rows; y++ {
+			row := data[y*rowBytes : (y+1)*rowBytes]
+			switch predictor {
+			case 1:
+			case 2:
+				undoHorizontalPredictor(row, chunkSpp, size, r.order)
+			case 3:
+				undoFloatPredictor(row, chunkSpp, size)
+				order = binary.BigEndian
+			default:
+				return nil, fmt.Errorf("predictor %d is not supported", predictor)
+			}
+		}
+		for y := 0; y < rows && y0+y < t.Height; y++ {
+			for x := 0; x < tw && x0+x < t.Width; x++ {
+				for s := 0; s < chunkSpp; s++ {
+					at := ((y*tw+x)*chunkSpp + s) * size
+					setSample(t.Bands[plane+s], (y0+y)*t.Width+x0+x, data[at:at+size], order)
+				}
+			}
+		}
+	}
+	return t, nil
+}
+
+// decompressChunk returns the bytes of a strip or tile compressed with the
+// TIFF compression scheme compression.
+func decompressChunk(raw []byte, compression uint64) ([]byte, error) {
+	switch compression {
+	case 1:
+		return raw, nil
+	case 8, 32946:
+		zr, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	case 5:
+		return decodeLZW(raw)
+	case 32773:
+		return decodePackBits(raw)
+	case 50000:
+		return zstdDecompress(raw)
+	}
+	return nil, fmt.Errorf("compression %d is not supported", compression)
+}
+
+// decodeLZW decodes the TIFF variant of LZW: codes of 9 to 12 bits, most
+// significant bit first, widening one code early.
+func decodeLZW(src []byte) ([]byte, error) {
+	const clear, eoi = 256, 257
+	var table [4096][]byte
+	for i := 0; i < 256; i++ {
+		table[i] = []byte{byte(i)}
+	}
+	out := make([]byte, 0, 4*len(src))
+	next, width := 258, 9
+	var prev []byte
+	var buf uint64
+	var nBits, pos int
+	for {
+		for nBits < width && pos < len(src) {
+			buf = buf<<8 | uint64(src[pos])
+			pos++
+			nBits += 8
+		}
+		if nBits < width {
+			return out, nil
+		}
+		code := int(buf>>(nBits-width)) & (1<<width - 1)
+		nBits -= width
+		switch {
+		case code == eoi:
+			return out, nil
+		case code == clear:
+			next, width, prev = 258, 9, nil
+			continue
+		}
+		var entry []byte
+		switch {
+		case code < next && table[code] != nil:
+			entry = table[code]
+		case code == next && prev != nil:
+			entry = append(append(make([]byte, 0, len(prev)+1), prev...), prev[0])
+		default:
+			return nil, fmt.Errorf("bad LZW code %d", code)
+		}
+		out = append(out, entry...)
+		if prev != nil && next < len(table) {
+			table[next] = append(append(make([]byte, 0, len(prev)+1), prev...), entry[0])
+			next++
+		}
+		prev = entry
+		switch next {
+		case 511:
+			width = 10
+		case 1023:
+			width = 11
+		case 2047:
+			width = 12
+		}
+	}
+}
+
+// decodePackBits decodes the PackBits run-length encoding.
+func decodePackBits(src []byte) ([]byte, error) {
+	out := make([]byte, 0, 2*len(src))
+	for i := 0; i < len(src); {
+		n := int(int8(src[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(src) {
+				return nil, errors.New("truncated PackBits run")
+			}
+			out = append(out, src[i:i+n+1]...)
+			i += n + 1
+		case n != -128:
+			if i >= len(src) {
+				return nil, errors.New("truncated PackBits run")
+			}
+			out = append(out, bytes.Repeat(src[i:i+1], 1-n)...)
+			i++
+		}
+	}
+	return out, nil
+}
+
+// undoHorizontalPredictor turns the differences between each sample of row
+// and the one before it in the same band back into the samples.
+func undoHorizontalPredictor(row []byte, spp int, size int, order binary.ByteOrder) {
+	stride := spp * size
+	for i := stride; i+size <= len(row); i += size {
+		switch size {
+		case 1:
+			row[i] += row[i-stride]
+		case 2:
+			order.PutUint16(row[i:], order.Uint16(row[i:])+order.Uint16(row[i-stride:]))
+		case 4:
+			order.PutUint32(row[i:], order.Uint32(row[i:])+order.Uint32(row[i-stride:]))
+		case 8:
+			order.PutUint64(row[i:], order.Uint64(row[i:])+order.Uint64(row[i-stride:]))
+		}
+	}
+}
+
+// undoFloatPredictor undoes the floating point predictor on row: the bytes
+// of its samples, most significant first, are laid out one byte of every
+// sample after another and differenced byte by byte. The samples come back
+// big-endian whatever the byte order of the file.
+func undoFloatPredictor(row []byte, spp int, size int) {
+	for i := spp; i < len(row); i++ {
+		row[i] += row[i-spp]
+	}
+	n := len(row) / size
+	tmp := make([]byte, len(row))
+	for i := 0; i < n; i++ {
+		for b := 0; b < size; b++ {
+			tmp[i*size+b] = row[b*n+i]
+		}
+	}
+	copy(row, tmp)
+}
+
+// setSample sets pixel i of p to the sample b, in byte order order.
+func setSample(p Pixels, i int, b []byte, order binary.ByteOrder) {
+	switch p := p.(type) {
+	case Buffer[uint8]:
+		p[i] = b[0]
+	case Buffer[int8]:
+		p[i] = int8(b[0])
+	case Buffer[uint16]:
+		p[i] = order.Uint16(b)
+	case Buffer[int16]:
+		p[i] = int16(order.Uint16(b))
+	case Buffer[uint32]:
+		p[i] = order.Uint32(b)
+	case Buffer[int32]:
+		p[i] = int32(order.Uint32(b))
+	case Buffer[float32]:
+		p[i] = math.Float32frombits(order.Uint32(b))
+	case Buffer[float64]:
+		p[i] = math.Float64frombits(order.Uint64(b))
+	}
+}
@@ -0,0 +1,121 @@
+package raster
+
+import (
+	"cmp"
+	"encoding/xml"
+	"regexp"
+	"strings"
+)
+
+// linearUnits are the lengths in metres of the units of length NormalizeUnit
+// knows, by the names PROJ gives them.
+var linearUnits = map[string]float64{
+	"m":     1,
+	"km":    1000,
+	"cm":    0.01,
+	"mm":    0.001,
+	"ft":    0.3048,
+	"us-ft": 1200.0 / 3937,
+	"in":    0.0254,
+	"yd":    0.9144,
+	"mi":    1609.344,
+}
+
+// unitAliases are the other names of the units of linearUnits, lower case
+// and without spaces, underscores or hyphens, as ArcGIS, netCDF and people
+// write them.
+var unitAliases = map[string]string{
+	"meter": "m", "meters": "m", "metre": "m", "metres": "m",
+	"kilometer": "km", "kilometers": "km", "kilometre": "km", "kilometres": "km",
+	"centimeter": "cm", "centimeters": "cm", "centimetre": "cm", "centimetres": "cm",
+	"millimeter": "mm", "millimeters": "mm", "millimetre": "mm", "millimetres": "mm",
+	"foot": "ft", "feet": "ft", "intlfoot": "ft", "footintl": "ft", "internationalfoot": "ft",
+	"usft": "us-ft", "footus": "us-ft", "usfoot": "us-ft", "usfeet": "us-ft", "ussurveyfoot": "us-ft", "ussurveyfeet": "us-ft", "surveyfoot": "us-ft",
+	"inch": "in", "inches": "in",
+	"yard": "yd", "yards": "yd",
+	"mile": "mi", "miles": "mi", "statutemile": "mi",
+}
+
+// NormalizeUnit returns the name PROJ gives the unit of length called unit,
+// such as Foot_US or metres, or unit itself, trimmed, for units that are
+// not lengths or that it does not know.
+func NormalizeUnit(unit string) string {
+	unit = strings.TrimSpace(unit)
+	if _, ok := linearUnits[unit]; ok {
+		return unit
+	}
+	key := strings.ToLower(strings.NewReplacer(" ", "", "_", "", "-", "", ".", "").Replace(unit))
+	if _, ok := linearUnits[key]; ok {
+		return key
+	}
+	if u, ok := unitAliases[key]; ok {
+		return u
+	}
+	return unit
+}
+
+// UnitFactor returns what a value in unit is multiplied by to convert it to
+// one in to, both units of length NormalizeUnit knows, or false if either
+// is not.
+func UnitFactor(unit, to string) (float64, bool) {
+	from, ok := linearUnits[NormalizeUnit(unit)]
+	if !ok {
+		return 0, false
+	}
+	m, ok := linearUnits[NormalizeUnit(to)]
+	if !ok {
+		return 0, false
+	}
+	return from / m, true
+}
+
+// verticalUnit picks the unit out of the vertical coordinate system ESRI
+// appends to the WKT of a raster of heights.
+var verticalUnit = regexp.MustCompile(`VERTCS\[.*UNIT\["([^"]+)"`)
+
+// unit finds the unit of the values of band, whose properties are props:
+// the unit they give, or that of its band in the contents of the ArcGIS
+// metadata of the raster, or for a raster of heights with a vertical
+// coordinate system, the unit of that. It returns "" for a band with
+// none of these.
+func (r *Raster) unit(band RasterBand, props map[string]interface{}) string {
+	if v, ok := property(props, unitNames); ok {
+		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+			return NormalizeUnit(s)
+		}
+	}
+	if u := r.metadataUnit(band); u != "" {
+		return NormalizeUnit(u)
+	}
+	if m := verticalUnit.FindStringSubmatch(r.WKT); m != nil {
+		return NormalizeUnit(m[1])
+	}
+	return ""
+}
+
+// metadataUnit returns the unit of the values of band in the ArcGIS metadata
+// of the raster: the valUnit of its Band in contInfo, or of the first if
+// there is no Band for each. It returns "" if the metadata gives none or
+// cannot be read.
+func (r *Raster) metadataUnit(band RasterBand) string {
+	doc, _ := r.db.Documentation(r.Name)
+	if doc == "" {
+		return ""
+	}
+	var md struct {
+		Bands []struct {
+			Unit struct {
+				Text   string `xml:",chardata"`
+				Symbol string `xml:"UOM>unitSymbol"`
+			} `xml:"valUnit"`
+		} `xml:"contInfo>ImgDesc>covDim>Band"`
+	}
+	if xml.Unmarshal([]byte(doc), &md) != nil || len(md.Bands) == 0 {
+		return ""
+	}
+	u := md.Bands[0].Unit
+	if i := int(band.SequenceNbr) - 1; i > 0 && i < len(md.Bands) {
+		u = md.Bands[i].Unit
+	}
+	return strings.TrimSpace(cmp.Or(u.Symbol, u.Text))
+}
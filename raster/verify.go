@@ -0,0 +1,83 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// rereadBlock reads row fid from disk a second time, for verifyBlock, and
+// returns its block, or why it could not be read again. On degrading media
+// two reads of the same sectors can differ, and a block that decodes
+// differently twice cannot be trusted.
+//
+// Note that the second read normally comes from the operating system's page
+// cache, so it only catches media faults when the cache has been dropped.
+func rereadBlock(bt *gdb.BaseTable, fid int) ([]byte, string) {
+	blk, ok, err := ReadBlockRow(bt, fid)
+	if err != nil {
+		return nil, fmt.Sprintf("re-read failed: %v", err)
+	}
+	if !ok {
+		return nil, "row vanished on re-read"
+	}
+	return blk.Data, ""
+}
+
+// verifyBlock compares the block first, which decoded to firstRaw, with
+// second, the same block as rereadBlock read it, decoding it again. It
+// returns a description of the disagreement, or "" if both passes match.
+func verifyBlock(first []byte, second []byte, firstRaw []byte, compressionType string, independentCodec bool) string {
+	if !bytes.Equal(first, second) {
+		return "compressed bytes differ between reads"
+	}
+
+	var raw []byte
+	var err error
+	if independentCodec && compressionType == "lz77" {
+		raw, err = inflateZlibManually(second)
+	} else {
+		raw, err = InflateBlock(second, compressionType)
+	}
+	if err != nil {
+		return fmt.Sprintf("second decode failed: %v", err)
+	}
+	if !bytes.Equal(firstRaw, raw) {
+		return "decoded pixels differ between decodes"
+	}
+	return ""
+}
+
+// inflateZlibManually is a second zlib implementation for verification: it
+// parses the stream header itself, inflates the body with inflate rather
+// than compress/flate and checks the Adler-32 trailer, so that a fault in
+// either inflater shows as decodes that disagree.
+func inflateZlibManually(data []byte) ([]byte, error) {
+	if len(data) < 6 {
+		return nil, fmt.Errorf("zlib stream too short")
+	}
+	cmf, flg := data[0], data[1]
+	if cmf&0x0F != 8 || (uint16(cmf)<<8|uint16(flg))%31 != 0 {
+		return nil, fmt.Errorf("bad zlib header %02x %02x", cmf, flg)
+	}
+	if flg&0x20 != 0 {
+		return nil, fmt.Errorf("preset dictionaries are not supported")
+	}
+
+	raw, n, err := inflate(data[2:], maxInflatedBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	trailer := data[2+n:]
+	if len(trailer) < 4 {
+		return nil, fmt.Errorf("missing adler-32 trailer")
+	}
+	if adler32.Checksum(raw) != binary.BigEndian.Uint32(trailer) {
+		return nil, fmt.Errorf("adler-32 mismatch")
+	}
+	return raw, nil
+}
@@ -0,0 +1,92 @@
+package raster
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// TestInflate checks inflate against compress/flate, on data compressed at
+// every level, from stored blocks to those of dynamic codes, and followed
+// by bytes that are not part of the stream.
+func TestInflate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 100_000)
+	rng.Read(random)
+	runs := make([]byte, 300_000)
+	for i := range runs {
+		runs[i] = byte(i / 1000 % 7)
+	}
+	inputs := map[string][]byte{"empty": nil, "byte": {42}, "random": random, "runs": runs, "text": bytes.Repeat([]byte("MapunitRaster_10m "), 5000)}
+	for name, in := range inputs {
+		for level := flate.HuffmanOnly; level <= flate.BestCompression; level++ {
+			var z bytes.Buffer
+			zw, err := flate.NewWriter(&z, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw.Write(in)
+			zw.Close()
+			stream := z.Len()
+			z.WriteString("trailer")
+
+			out, n, err := inflate(z.Bytes(), len(in))
+			if err != nil {
+				t.Errorf("%s at level %d: %v", name, level, err)
+				continue
+			}
+			if !bytes.Equal(out, in) || n != stream {
+				t.Errorf("%s at level %d: %d bytes from %d, want %d from %d", name, level, len(out), n, len(in), stream)
+			}
+		}
+	}
+}
+
+func TestInflateZlibManually(t *testing.T) {
+	in := bytes.Repeat([]byte{0, 1, 2, 3, 250}, 20000)
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(in)
+	zw.Close()
+	data := z.Bytes()
+	if out, err := inflateZlibManually(data); err != nil || !bytes.Equal(out, in) {
+		t.Errorf("%d bytes, %v; want %d", len(out), err, len(in))
+	}
+	for i, corrupt := range [][]byte{data[:len(data)-2], data[:len(data)/2], append(bytes.Clone(data[:len(data)-1]), data[len(data)-1]^1)} {
+		if _, err := inflateZlibManually(corrupt); err == nil {
+			t.Errorf("corrupt stream %d inflated", i)
+		}
+	}
+	if _, _, err := inflate(data[2:], len(in)-1); err == nil {
+		t.Errorf("inflated past the limit")
+	}
+}
+
+// FuzzInflate checks inflate against compress/flate on any input: a stream
+// flate inflates, inflate must inflate to the same bytes.
+func FuzzInflate(f *testing.F) {
+	for level := flate.HuffmanOnly; level <= flate.BestCompression; level++ {
+		var z bytes.Buffer
+		zw, _ := flate.NewWriter(&z, level)
+		zw.Write(bytes.Repeat([]byte("block_data "), 50))
+		zw.Close()
+		f.Add(z.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		const limit = 1 << 20
+		want, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), limit+1))
+		if err != nil || len(want) > limit {
+			return
+		}
+		got, _, err := inflate(data, limit)
+		if err != nil {
+			t.Fatalf("flate inflates %d bytes, inflate fails: %v", len(want), err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("inflate gives %d bytes, flate %d", len(got), len(want))
+		}
+	})
+}
@@ -0,0 +1,787 @@
+package raster
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// zstdDecompress decodes the Zstandard frames (RFC 8878) of src, as TIFF's
+// ZSTD compression holds a strip or tile, the way GDAL and writer write
+// them. Like inflate, it is a plain reading of the RFC: every block type,
+// Huffman and FSE coded literals and sequences, predefined, RLE, fitted and
+// repeated tables, and the repeated offsets. Frames needing a dictionary
+// fail, and checksums are skipped, not checked.
+func zstdDecompress(src []byte) ([]byte, error) {
+	var out []byte
+	for len(src) > 0 {
+		if len(src) < 8 {
+			return nil, errors.New("zstd: frame cut short")
+		}
+		magic := binary.LittleEndian.Uint32(src)
+		if magic&0xFFFFFFF0 == 0x184D2A50 {
+			// A skippable frame: its size, and that much of anything.
+			n := int64(binary.LittleEndian.Uint32(src[4:]))
+			if n > int64(len(src)-8) {
+				return nil, errors.New("zstd: skippable frame cut short")
+			}
+			src = src[8+n:]
+			continue
+		}
+		if magic != 0xFD2FB528 {
+			return nil, fmt.Errorf("zstd: magic number %#x", magic)
+		}
+		d := &zstdDecoder{in: src[4:], out: out, start: len(out), rep: [3]int{1, 4, 8}}
+		if err := d.frame(); err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		out, src = d.out, d.in
+	}
+	return out, nil
+}
+
+// zstdMaxBlock is the most a block may hold, compressed or not.
+const zstdMaxBlock = 128 << 10
+
+// zstdDecoder is the state of a frame being decoded: what is left of the
+// input, the output, from start for this frame, the offsets repeated and
+// the tables later blocks may repeat.
+type zstdDecoder struct {
+	in         []byte
+	out        []byte
+	start      int
+	rep        [3]int
+	huff       *zstdHuffman
+	ll, of, ml *fseTable
+}
+
+// frame decodes the header of a frame and its blocks.
+func (d *zstdDecoder) frame() error {
+	fhd := d.in[0]
+	fcsFlag, single, checksum, dictFlag := fhd>>6, fhd>>5&1 == 1, fhd>>2&1 == 1, fhd&3
+	if fhd&8 != 0 {
+		return errors.New("reserved bit of the frame header set")
+	}
+	n := 1
+	if !single {
+		n++ // the window descriptor: with the whole frame kept, not needed
+	}
+	dictSize := [4]int{0, 1, 2, 4}[dictFlag]
+	fcsSize := [4]int{0, 2, 4, 8}[fcsFlag]
+	if fcsFlag == 0 && single {
+		fcsSize = 1
+	}
+	if len(d.in) < n+dictSize+fcsSize {
+		return errors.New("frame header cut short")
+	}
+	for _, c := range d.in[n : n+dictSize] {
+		if c != 0 {
+			return errors.New("the frame needs a dictionary")
+		}
+	}
+	n += dictSize
+	size := int64(-1)
+	switch fcsSize {
+	case 1:
+		size = int64(d.in[n])
+	case 2:
+		size = int64(binary.LittleEndian.Uint16(d.in[n:])) + 256
+	case 4:
+		size = int64(binary.LittleEndian.Uint32(d.in[n:]))
+	case 8:
+		size = int64(binary.LittleEndian.Uint64(d.in[n:]))
+	}
+	d.in = d.in[n+fcsSize:]
+
+	for last := false; !last; {
+		if len(d.in) < 3 {
+			return errors.New("block header cut short")
+		}
+		h := int(d.in[0]) | int(d.in[1])<<8 | int(d.in[2])<<16
+		last = h&1 == 1
+		blockSize := h >> 3
+		d.in = d.in[3:]
+		if blockSize > zstdMaxBlock {
+			return fmt.Errorf("block of %d bytes", blockSize)
+		}
+		switch h >> 1 & 3 {
+		case 0:
+			if blockSize > len(d.in) {
+				return errors.New("raw block cut short")
+			}
+			d.out = append(d.out, d.in[:blockSize]...)
+			d.in = d.in[blockSize:]
+		case 1:
+			if len(d.in) < 1 {
+				return errors.New("RLE block cut short")
+			}
+			for range blockSize {
+				d.out = append(d.out, d.in[0])
+			}
+			d.in = d.in[1:]
+		case 2:
+			if blockSize > len(d.in) {
+				return errors.New("compressed block cut short")
+			}
+			if err := d.block(d.in[:blockSize]); err != nil {
+				return err
+			}
+			d.in = d.in[blockSize:]
+		default:
+			return errors.New("reserved block type")
+		}
+		if size >= 0 && int64(len(d.out)-d.start) > size {
+			return fmt.Errorf("frame holds more than its %d bytes", size)
+		}
+	}
+	if checksum {
+		if len(d.in) < 4 {
+			return errors.New("checksum cut short")
+		}
+		d.in = d.in[4:]
+	}
+	if size >= 0 && int64(len(d.out)-d.start) != size {
+		return fmt.Errorf("frame of %d bytes holds %d", size, len(d.out)-d.start)
+	}
+	return nil
+}
+
+// block decodes a compressed block: its literals, then the sequences
+// copying them and matches into the output.
+func (d *zstdDecoder) block(b []byte) error {
+	lits, n, err := d.literals(b)
+	if err != nil {
+		return err
+	}
+	before := len(d.out)
+	if err := d.sequences(b[n:], lits); err != nil {
+		return err
+	}
+	if len(d.out)-before > zstdMaxBlock {
+		return fmt.Errorf("block holds %d bytes", len(d.out)-before)
+	}
+	return nil
+}
+
+// literals decodes the literals section at the start of b, returning the
+// literals and the bytes the section takes.
+func (d *zstdDecoder) literals(b []byte) ([]byte, int, error) {
+	if len(b) < 1 {
+		return nil, 0, errors.New("literals section cut short")
+	}
+	typ, sizeFormat := b[0]&3, b[0]>>2&3
+	if typ < 2 {
+		// Raw and RLE literals: the size in 5, 12 or 20 bits.
+		var regen, n int
+		switch sizeFormat {
+		case 0, 2:
+			regen, n = int(b[0]>>3), 1
+		case 1:
+			if len(b) < 2 {
+				return nil, 0, errors.New("literals header cut short")
+			}
+			regen, n = int(b[0]>>4)|int(b[1])<<4, 2
+		case 3:
+			if len(b) < 3 {
+				return nil, 0, errors.New("literals header cut short")
+			}
+			regen, n = int(b[0]>>4)|int(b[1])<<4|int(b[2])<<12, 3
+		}
+		if regen > zstdMaxBlock {
+			return nil, 0, fmt.Errorf("%d literals", regen)
+		}
+		if typ == 0 {
+			if n+regen > len(b) {
+				return nil, 0, errors.New("raw literals cut short")
+			}
+			return b[n : n+regen], n + regen, nil
+		}
+		if n >= len(b) {
+			return nil, 0, errors.New("RLE literals cut short")
+		}
+		lits := make([]byte, regen)
+		for i := range lits {
+			lits[i] = b[n]
+		}
+		return lits, n + 1, nil
+	}
+
+	// Huffman coded literals, in one stream or four: the sizes before and
+	// after coding in 10, 14 or 18 bits each.
+	var regen, size, n int
+	streams := 4
+	switch sizeFormat {
+	case 0, 1:
+		if len(b) < 3 {
+			return nil, 0, errors.New("literals header cut short")
+		}
+		h := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		regen, size, n = h>>4&0x3FF, h>>14&0x3FF, 3
+		if sizeFormat == 0 {
+			streams = 1
+		}
+	case 2:
+		if len(b) < 4 {
+			return nil, 0, errors.New("literals header cut short")
+		}
+		h := int(binary.LittleEndian.Uint32(b))
+		regen, size, n = h>>4&0x3FFF, h>>18&0x3FFF, 4
+	case 3:
+		if len(b) < 5 {
+			return nil, 0, errors.New("literals header cut short")
+		}
+		h := int(binary.LittleEndian.Uint32(b)) | int(b[4])<<32
+		regen, size, n = h>>4&0x3FFFF, h>>22&0x3FFFF, 5
+	}
+	if regen > zstdMaxBlock {
+		return nil, 0, fmt.Errorf("%d literals", regen)
+	}
+	if n+size > len(b) {
+		return nil, 0, errors.New("compressed literals cut short")
+	}
+	body := b[n : n+size]
+	if typ == 2 {
+		h, used, err := readZstdHuffman(body)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.huff, body = h, body[used:]
+	} else if d.huff == nil {
+		return nil, 0, errors.New("literals repeat a Huffman table there is none of")
+	}
+	lits := make([]byte, 0, regen)
+	if streams == 1 {
+		lits, err := d.huff.decode(lits, body, regen)
+		return lits, n + size, err
+	}
+	if len(body) < 6 {
+		return nil, 0, errors.New("jump table cut short")
+	}
+	seg := (regen + 3) / 4
+	if 3*seg > regen {
+		return nil, 0, fmt.Errorf("%d literals in four streams", regen)
+	}
+	sizes := [4]int{int(binary.LittleEndian.Uint16(body)), int(binary.LittleEndian.Uint16(body[2:])), int(binary.LittleEndian.Uint16(body[4:]))}
+	body = body[6:]
+	sizes[3] = len(body) - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return nil, 0, errors.New("Huffman streams run past the literals")
+	}
+	for i, s := range sizes {
+		want := seg
+		if i == 3 {
+			want = regen - 3*seg
+		}
+		var err error
+		if lits, err = d.huff.decode(lits, body[:s], want); err != nil {
+			return nil, 0, err
+		}
+		body = body[s:]
+	}
+	return lits, n + size, nil
+}
+
+// zstdHuffman is the Huffman code of literals, as a table indexed by the
+// next maxBits bits of a stream.
+type zstdHuffman struct {
+	maxBits int
+	symbol  []byte
+	length  []uint8
+}
+
+// readZstdHuffman reads the description of a Huffman code at the start of
+// b: the weights of every symbol but the last, FSE coded or as 4-bit
+// values, from which the last weight, and the code, follow. It returns the
+// code and the bytes the description takes.
+func readZstdHuffman(b []byte) (*zstdHuffman, int, error) {
+	if len(b) < 1 {
+		return nil, 0, errors.New("Huffman table cut short")
+	}
+	var weights []byte
+	var used int
+	if hb := int(b[0]); hb < 128 {
+		if 1+hb > len(b) {
+			return nil, 0, errors.New("Huffman weights cut short")
+		}
+		var err error
+		if weights, err = fseWeights(b[1 : 1+hb]); err != nil {
+			return nil, 0, err
+		}
+		used = 1 + hb
+	} else {
+		n := hb - 127
+		used = 1 + (n+1)/2
+		if used > len(b) {
+			return nil, 0, errors.New("Huffman weights cut short")
+		}
+		weights = make([]byte, n)
+		for i := range weights {
+			weights[i] = b[1+i/2] >> (4 * (1 - i%2)) & 15
+		}
+	}
+	if len(weights) > 255 {
+		return nil, 0, fmt.Errorf("Huffman weights of %d symbols", len(weights)+1)
+	}
+
+	total := 0
+	for _, w := range weights {
+		if w > 11 {
+			return nil, 0, fmt.Errorf("Huffman weight %d", w)
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, 0, errors.New("Huffman code of no symbols")
+	}
+	maxBits := bits.Len(uint(total))
+	rest := 1<<maxBits - total
+	if rest&(rest-1) != 0 || maxBits > 11 {
+		return nil, 0, errors.New("Huffman weights that do not add up")
+	}
+	weights = append(weights, byte(bits.Len(uint(rest))))
+
+	// Codes go to the symbols of weight 1, the longest, first, in order of
+	// symbol, each taking as many entries as its weight says.
+	h := &zstdHuffman{maxBits: maxBits, symbol: make([]byte, 1<<maxBits), length: make([]uint8, 1<<maxBits)}
+	pos := 0
+	for w := 1; w <= maxBits; w++ {
+		for s, sw := range weights {
+			if int(sw) != w {
+				continue
+			}
+			for range 1 << (w - 1) {
+				h.symbol[pos], h.length[pos] = byte(s), uint8(maxBits+1-w)
+				pos++
+			}
+		}
+	}
+	return h, used, nil
+}
+
+// decode appends the n literals of the backward bit stream b to lits. The
+// stream must end with the last of them.
+func (h *zstdHuffman) decode(lits []byte, b []byte, n int) ([]byte, error) {
+	r, err := newBackReader(b)
+	if err != nil {
+		return nil, err
+	}
+	for range n {
+		i := r.peek(h.maxBits)
+		lits = append(lits, h.symbol[i])
+		r.skip(int(h.length[i]))
+	}
+	if r.n != 0 {
+		return nil, errors.New("Huffman stream does not end with its literals")
+	}
+	return lits, nil
+}
+
+// fseWeights decodes the Huffman weights FSE coded in b: the description of
+// the table, then a backward bit stream two states take turns decoding.
+func fseWeights(b []byte) ([]byte, error) {
+	norm, used, err := readNCount(b, 6, 255)
+	if err != nil {
+		return nil, err
+	}
+	t := newFSETable(norm.counts, norm.log)
+	r, err := newBackReader(b[used:])
+	if err != nil {
+		return nil, err
+	}
+	s1, s2 := r.bits(int(t.log)), r.bits(int(t.log))
+	var weights []byte
+	for {
+		if len(weights) > 255 {
+			return nil, errors.New("too many Huffman weights")
+		}
+		weights = append(weights, t.symbol[s1])
+		s1 = t.next(s1, r)
+		if r.n < 0 {
+			return append(weights, t.symbol[s2]), nil
+		}
+		weights = append(weights, t.symbol[s2])
+		s2 = t.next(s2, r)
+		if r.n < 0 {
+			return append(weights, t.symbol[s1]), nil
+		}
+	}
+}
+
+// ncount is a distribution of FSE, as a table description gives it: how
+// many states of the 1<<log each symbol has, -1 for a probability below
+// one, which takes one.
+type ncount struct {
+	counts []int16
+	log    uint
+}
+
+// readNCount reads the description of an FSE table at the start of b, of
+// no more than 1<<maxLog states and symbols up to maxSymbol, returning it
+// and the bytes it takes.
+func readNCount(b []byte, maxLog uint, maxSymbol int) (ncount, int, error) {
+	r := &fwdReader{in: b}
+	log := uint(r.bits(4)) + 5
+	if log > maxLog {
+		return ncount{}, 0, fmt.Errorf("FSE table of %d states", 1<<log)
+	}
+	size := 1 << log
+	remaining, threshold, nbBits := size+1, size, log+1
+	var counts []int16
+	for remaining > 1 {
+		if len(counts) > maxSymbol {
+			return ncount{}, 0, fmt.Errorf("FSE table of more than %d symbols", maxSymbol+1)
+		}
+		limit := 2*threshold - 1 - remaining
+		var count int
+		if v := int(r.peek(nbBits - 1)); v < limit {
+			count = v
+			r.skip(nbBits - 1)
+		} else {
+			count = int(r.peek(nbBits))
+			if count >= threshold {
+				count -= limit
+			}
+			r.skip(nbBits)
+		}
+		count--
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		counts = append(counts, int16(count))
+		if count == 0 {
+			// Zeros follow in runs of 0 to 3, a run of 3 telling that
+			// another follows.
+			for {
+				run := int(r.bits(2))
+				for range run {
+					counts = append(counts, 0)
+				}
+				if run != 3 {
+					break
+				}
+				if len(counts) > maxSymbol+1 {
+					break
+				}
+			}
+		}
+		for remaining < threshold && threshold > 1 {
+			nbBits--
+			threshold >>= 1
+		}
+		if r.err != nil {
+			return ncount{}, 0, r.err
+		}
+	}
+	if remaining != 1 || len(counts) > maxSymbol+1 {
+		return ncount{}, 0, errors.New("FSE table description that does not add up")
+	}
+	return ncount{counts, log}, (r.pos + 7) / 8, nil
+}
+
+// fseTable decodes FSE: for each state, the symbol it stands for and how
+// the next state is found, baseline plus the next nbBits bits.
+type fseTable struct {
+	log      uint
+	symbol   []uint8
+	nbBits   []uint8
+	baseline []uint16
+}
+
+// newFSETable builds the decoding table of distribution counts, spreading
+// the symbols over the states as the encoder does.
+func newFSETable(counts []int16, log uint) *fseTable {
+	size := 1 << log
+	t := &fseTable{log: log, symbol: make([]uint8, size), nbBits: make([]uint8, size), baseline: make([]uint16, size)}
+	next := make([]int, len(counts))
+	high := size - 1
+	for s, c := range counts {
+		if c == -1 {
+			t.symbol[high] = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int(c)
+		}
+	}
+	pos, step := 0, size>>1+size>>3+3
+	for s, c := range counts {
+		for range max(int(c), 0) {
+			t.symbol[pos] = uint8(s)
+			pos = (pos + step) & (size - 1)
+			for pos > high {
+				pos = (pos + step) & (size - 1)
+			}
+		}
+	}
+	for u := range size {
+		s := t.symbol[u]
+		n := next[s]
+		next[s]++
+		t.nbBits[u] = uint8(int(log) - (bits.Len(uint(n)) - 1))
+		t.baseline[u] = uint16(n<<t.nbBits[u] - size)
+	}
+	return t
+}
+
+// rleTable is the table of a single symbol, which every state stands for,
+// read in no bits.
+func rleTable(s uint8) *fseTable {
+	return &fseTable{symbol: []uint8{s}, nbBits: []uint8{0}, baseline: []uint16{0}}
+}
+
+// next returns the state after state, reading the bits it takes from r.
+func (t *fseTable) next(state uint64, r *backReader) uint64 {
+	return uint64(t.baseline[state]) + r.bits(int(t.nbBits[state]))
+}
+
+// The predefined distributions of the literal length, offset and match
+// length codes, and the baselines and extra bits of the length codes.
+var (
+	zstdLLPredefined = newFSETable([]int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1}, 6)
+	zstdOFPredefined = newFSETable([]int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}, 5)
+	zstdMLPredefined = newFSETable([]int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1, -1, -1}, 6)
+
+	zstdLLBase = [36]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	zstdLLExtra = [36]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	zstdMLBase = [53]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	zstdMLExtra = [53]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// sequences decodes the sequences section b, each sequence copying some of
+// lits then a match from the output, and the literals left after them.
+func (d *zstdDecoder) sequences(b []byte, lits []byte) error {
+	if len(b) < 1 {
+		return errors.New("sequences section cut short")
+	}
+	var nSeq, n int
+	switch {
+	case b[0] < 128:
+		nSeq, n = int(b[0]), 1
+	case b[0] < 255:
+		if len(b) < 2 {
+			return errors.New("sequences header cut short")
+		}
+		nSeq, n = int(b[0]-128)<<8|int(b[1]), 2
+	default:
+		if len(b) < 3 {
+			return errors.New("sequences header cut short")
+		}
+		nSeq, n = int(b[1])|int(b[2])<<8+0x7F00, 3
+	}
+	if nSeq == 0 {
+		if n != len(b) {
+			return errors.New("bytes after a section of no sequences")
+		}
+		d.out = append(d.out, lits...)
+		return nil
+	}
+	if n >= len(b) {
+		return errors.New("sequences header cut short")
+	}
+	modes := b[n]
+	n++
+	if modes&3 != 0 {
+		return errors.New("reserved bits of the sequences header set")
+	}
+	tables := []struct {
+		t          **fseTable
+		mode       byte
+		predefined *fseTable
+		maxLog     uint
+		maxSymbol  int
+	}{
+		{&d.ll, modes >> 6, zstdLLPredefined, 9, 35},
+		{&d.of, modes >> 4 & 3, zstdOFPredefined, 8, 31},
+		{&d.ml, modes >> 2 & 3, zstdMLPredefined, 9, 52},
+	}
+	for _, tb := range tables {
+		switch tb.mode {
+		case 0:
+			*tb.t = tb.predefined
+		case 1:
+			if n >= len(b) {
+				return errors.New("RLE table cut short")
+			}
+			if int(b[n]) > tb.maxSymbol {
+				return fmt.Errorf("RLE table of code %d", b[n])
+			}
+			*tb.t = rleTable(b[n])
+			n++
+		case 2:
+			norm, used, err := readNCount(b[n:], tb.maxLog, tb.maxSymbol)
+			if err != nil {
+				return err
+			}
+			*tb.t = newFSETable(norm.counts, norm.log)
+			n += used
+		case 3:
+			if *tb.t == nil {
+				return errors.New("sequences repeat a table there is none of")
+			}
+		}
+	}
+
+	r, err := newBackReader(b[n:])
+	if err != nil {
+		return err
+	}
+	ll, of, ml := r.bits(int(d.ll.log)), r.bits(int(d.of.log)), r.bits(int(d.ml.log))
+	for i := range nSeq {
+		llCode, ofCode, mlCode := d.ll.symbol[ll], d.of.symbol[of], d.ml.symbol[ml]
+		if ofCode > 31 {
+			return fmt.Errorf("offset code %d", ofCode)
+		}
+		ofValue := 1<<ofCode + int(r.bits(int(ofCode)))
+		matchLen := zstdMLBase[mlCode] + int(r.bits(zstdMLExtra[mlCode]))
+		litLen := zstdLLBase[llCode] + int(r.bits(zstdLLExtra[llCode]))
+		if i < nSeq-1 {
+			ll = d.ll.next(ll, r)
+			ml = d.ml.next(ml, r)
+			of = d.of.next(of, r)
+		}
+		if r.n < 0 {
+			return errors.New("sequences stream cut short")
+		}
+
+		offset := d.offset(ofValue, litLen)
+		if litLen > len(lits) {
+			return fmt.Errorf("sequence of %d literals, %d left", litLen, len(lits))
+		}
+		d.out = append(d.out, lits[:litLen]...)
+		lits = lits[litLen:]
+		if offset <= 0 || offset > len(d.out)-d.start {
+			return fmt.Errorf("match %d back from byte %d", offset, len(d.out)-d.start)
+		}
+		if matchLen > zstdMaxBlock {
+			return fmt.Errorf("match of %d bytes", matchLen)
+		}
+		// Byte by byte, as the match may overlap what it copies.
+		for range matchLen {
+			d.out = append(d.out, d.out[len(d.out)-offset])
+		}
+	}
+	if r.n != 0 {
+		return errors.New("sequences stream does not end with its sequences")
+	}
+	d.out = append(d.out, lits...)
+	return nil
+}
+
+// offset returns the offset a sequence of offset value v after litLen
+// literals matches at, and updates the offsets repeated: values 1 to 3 pick
+// one of those, the next one along if there are no literals, 4 of them
+// standing for the first less one.
+func (d *zstdDecoder) offset(v int, litLen int) int {
+	if v > 3 {
+		d.rep = [3]int{v - 3, d.rep[0], d.rep[1]}
+		return d.rep[0]
+	}
+	k := v - 1
+	if litLen == 0 {
+		k++
+	}
+	var offset int
+	switch k {
+	case 0:
+		return d.rep[0]
+	case 3:
+		offset = d.rep[0] - 1
+	default:
+		offset = d.rep[k]
+	}
+	if k != 1 {
+		d.rep[2] = d.rep[1]
+	}
+	d.rep[1], d.rep[0] = d.rep[0], offset
+	return offset
+}
+
+// fwdReader reads bits from the start of in, from the least significant of
+// each byte, as table descriptions are written. Past the end it reads zeros
+// and sets err.
+type fwdReader struct {
+	in  []byte
+	pos int // in bits
+	err error
+}
+
+func (r *fwdReader) peek(n uint) uint64 {
+	var v uint64
+	for i := range n {
+		p := r.pos + int(i)
+		if p/8 >= len(r.in) {
+			r.err = errors.New("FSE table description cut short")
+			break
+		}
+		v |= uint64(r.in[p/8]>>(p%8)&1) << i
+	}
+	return v
+}
+
+func (r *fwdReader) skip(n uint) { r.pos += int(n) }
+
+func (r *fwdReader) bits(n uint) uint64 {
+	v := r.peek(n)
+	r.skip(n)
+	return v
+}
+
+// backReader reads a backward bit stream: written from the least significant
+// bit of its first byte on and ended with a 1 bit, it is read from that bit
+// back to the start, each value read having its most significant bit last
+// written. n is the number of bits left, negative once more were read than
+// there are, those read as zeros.
+type backReader struct {
+	in []byte
+	n  int
+}
+
+func newBackReader(b []byte) (*backReader, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return nil, errors.New("bit stream without its end marker")
+	}
+	return &backReader{b, (len(b)-1)*8 + bits.Len8(b[len(b)-1]) - 1}, nil
+}
+
+// peek returns the next k bits, k at most 56, without reading them.
+func (r *backReader) peek(k int) uint64 {
+	start := r.n - k
+	shift := 0
+	if start < 0 {
+		shift, start = -start, 0
+	}
+	want := k - shift
+	if want <= 0 {
+		return 0
+	}
+	var w uint64
+	for i := range 8 {
+		if start/8+i < len(r.in) {
+			w |= uint64(r.in[start/8+i]) << (8 * i)
+		}
+	}
+	return (w >> (start % 8) & (1<<want - 1)) << shift
+}
+
+func (r *backReader) skip(k int) { r.n -= k }
+
+func (r *backReader) bits(k int) uint64 {
+	v := r.peek(k)
+	r.skip(k)
+	return v
+}
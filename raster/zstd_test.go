@@ -0,0 +1,92 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+// zstdFrames are frames the zstd command line tool wrote of zstdInput, at
+// several levels: Huffman coded literals and fitted FSE tables at higher
+// levels, raw literals at the fastest, two blocks each.
+var zstdFrames = map[string]string{
+	"level 1": "KLUv/QRI9A0A5BpNYXB1bml0UmFzdGVyXzEwbSBiYW5kXzEgAAEBAQECAgICAwMEBAQFBQYG" +
+		"BwgICQkKCwsMDQ4ODxAREhMTFBUWFxgZGhscHh8gISIjJSYnKCorLC4vMTIzNTY4OTs9PkBB" +
+		"Q0VHSEpMTk9RU1VXWVtdX2FjZWdpa21vcXN2eHp8f4GDhYiKjY+RlJaZm56ho6aoq66ws7a5" +
+		"u77BxMfKzM/S1djb3uHk5+vu8fT3+gMGCQwQExYaHSEkJysuMjU5PUBER0tPU1ZaXmJlaW1x" +
+		"dXl9gYWJjZGVmZ2hpamtsra6vsPHy8/U2N3h5eru8/cBBgoPExgdISYrMDQ5PkNITVFWW2Bl" +
+		"am90eX6EiY6TmJ2jqK2yuL3CyM3T2N3j6O7z+QQJDxQaICYrMTc9QkhOVFpgZmxyeH6EipCW" +
+		"nKKorrW7wcfO1Nrg5+30+gUMEhkfJi0zOkBHTlRbYmlvdn2Ei5KYn6attLvCydDX3+bt9AAH" +
+		"DxYdJCwzOkJJUVhfZ252fYWNlJyjq7O7wsrS2uHp8fkGDhYeJi42PkZOVl5mbnZ/hwABAgME" +
+		"BQYAAQIDBAUGAwCUa/wx8+A0FjarEw9FAAAIAAEAcM0OhFV3vkY=",
+	"level 19, checksum": "KLUv/QRorA0AFBpNYXB1bml0UmFzdGVyXzEwbSBiYW5kXzEgAAECAwMEBAQFBQYGBwgICQkK" +
+		"CwsMDQ4ODxAREhMTFBUWFxgZGhscHh8gISIjJSYnKCorLC4vMTIzNTY4OTs9PkBBQ0VHSEpM" +
+		"Tk9RU1VXWVtdX2FjZWdpa21vcXN2eHp8f4GDhYiKjY+RlJaZm56ho6aoq66ws7a5u77BxMfK" +
+		"zM/S1djb3uHk5+vu8fT3+gMGCQwQExYaHSEkJysuMjU5PUBER0tPU1ZaXmJlaW1xdXl9gYWJ" +
+		"jZGVmZ2hpamtsra6vsPHy8/U2N3h5eru8/cBBgoPExgdISYrMDQ5PkNITVFWW2Blam90eX6E" +
+		"iY6TmJ2jqK2yuL3CyM3T2N3j6O7z+QQJDxQaICYrMTc9QkhOVFpgZmxyeH6EipCWnKKorrW7" +
+		"wcfO1Nrg5+30+gUMEhkfJi0zOkBHTlRbYmlvdn2Ei5KYn6attLvCydDX3+bt9AAHDxYdJCwz" +
+		"OkJJUVhfZ252fYWNlJyjq7O7wsrS2uHp8fkGDhYeJi42PkZOVl5mbnZ/hwABAgMEBQYFAIVy" +
+		"/Cr5AIABIG5xw2Z1jgFFAAAAAQBxjfYCBFV3vkY=",
+	"fast 5": "KLUv/QBIZA4AxBtNYXB1bml0UmFzdGVyXzEwbSBiYW5kXzEgAAEBAQECAgICAwMEBAQFBQYG" +
+		"BwgICQkKCwsMDQ4ODxAREhMTFBUWFxgZGhscHh8gISIjJSYnKCorLC4vMTIzNTY4OTs9PkBB" +
+		"Q0VHSEpMTk9RU1VXWVtdX2FjZWdpa21vcXN2eHp8f4GDhYiKjY+RlJaZm56ho6aoq66ws7a5" +
+		"u77BxMfKzM/S1djb3uHk5+vu8fT3+gMGCQwQExYaHSEkJysuMjU5PUBER0tPU1ZaXmJlaW1x" +
+		"dXl9gYWJjZGVmZ2hpamtsra6vsPHy8/U2N3h5eru8/cBBgoPExgdISYrMDQ5PkNITVFWW2Bl" +
+		"am90eX6EiY6TmJ2jqK2yuL3CyM3T2N3j6O7z+QQJDxQaICYrMTc9QkhOVFpgZmxyeH6EipCW" +
+		"nKKorrW7wcfO1Nrg5+30+gUMEhkfJi0zOkBHTlRbYmlvdn2Ei5KYn6attLvCydDX3+bt9AAH" +
+		"DxYdJCwzOkJJUVhfZ252fYWNlJyjq7O7wsrS2uHp8fkGDhYeJi42PkZOVl5mbnZ/hwABAgME" +
+		"BQYAAQIDBAUGAAECAwQFBgABAgMEBQYDAKJd/D/z4DQWNqsTD2UAACgAAQIDBAEAbM0OtA==",
+}
+
+// zstdInput is what zstdFrames hold: text, bytes of little repetition and a
+// run longer than a block.
+func zstdInput() []byte {
+	in := bytes.Repeat([]byte("MapunitRaster_10m band_1 "), 20)
+	for i := range 400 {
+		in = append(in, byte(i*i/97%251))
+	}
+	for i := range 150000 {
+		in = append(in, byte(i%7))
+	}
+	return in
+}
+
+// TestZstdDecompress decodes frames zstd wrote, one after another with a
+// skippable frame between, and fails those cut short or damaged.
+func TestZstdDecompress(t *testing.T) {
+	in := zstdInput()
+	for name, b64 := range zstdFrames {
+		frame, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := zstdDecompress(frame); err != nil || !bytes.Equal(out, in) {
+			t.Errorf("%s: %d bytes, %v; want %d", name, len(out), err, len(in))
+		}
+
+		skippable := []byte{0x50, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 1, 2, 3}
+		two := append(append(bytes.Clone(frame), skippable...), frame...)
+		if out, err := zstdDecompress(two); err != nil || !bytes.Equal(out, append(bytes.Clone(in), in...)) {
+			t.Errorf("%s, twice: %d bytes, %v; want %d", name, len(out), err, 2*len(in))
+		}
+
+		for i, corrupt := range [][]byte{frame[:len(frame)/2], frame[:len(frame)-1], frame[4:]} {
+			if _, err := zstdDecompress(corrupt); err == nil {
+				t.Errorf("%s: corrupt frame %d decoded", name, i)
+			}
+		}
+	}
+}
+
+// FuzzZstdDecompress decodes any input: it must fail or decode, never
+// panic. The seeds are zstdFrames.
+func FuzzZstdDecompress(f *testing.F) {
+	for _, b64 := range zstdFrames {
+		frame, _ := base64.StdEncoding.DecodeString(b64)
+		f.Add(frame)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		zstdDecompress(data)
+	})
+}
@@ -0,0 +1,122 @@
+package writer
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// outputTypes are the data types bands can be cast to, by the names
+// gdal_translate -ot takes and those of raster, lowercase.
+var outputTypes = map[string]string{
+	"byte": "uint8", "uint8": "uint8", "int8": "int8",
+	"uint16": "uint16", "int16": "int16", "uint32": "uint32", "int32": "int32",
+	"float32": "float32", "float64": "64bit", "64bit": "64bit",
+}
+
+// roundings are how values are rounded when cast to integers: to the
+// nearest, halves away from zero, down, up or toward zero.
+var roundings = []string{"NEAREST", "FLOOR", "CEIL", "TRUNC"}
+
+// overflows are what becomes of values outside the range of the type they
+// are cast to: they are clipped to the nearest value it holds, or nodata.
+var overflows = []string{"CLIP", "NODATA"}
+
+// ParseOutputType reads the data type bands are cast to, as gdal_translate
+// -ot names it, Byte, Int16 or Float32 say, returning it as raster names
+// data types.
+func ParseOutputType(s string) (string, error) {
+	if t, ok := outputTypes[strings.ToLower(s)]; ok {
+		return t, nil
+	}
+	return "", fmt.Errorf("%q is not a type: byte, int8, uint16, int16, uint32, int32, float32 or float64", s)
+}
+
+// ParseRounding reads how values cast to integers are rounded, nearest,
+// floor, ceil or trunc.
+func ParseRounding(s string) (string, error) {
+	if r := strings.ToUpper(s); slices.Contains(roundings, r) {
+		return r, nil
+	}
+	return "", fmt.Errorf("values are rounded with nearest, floor, ceil or trunc")
+}
+
+// ParseOverflow reads what becomes of values out of the range of the type
+// they are cast to, clip or nodata.
+func ParseOverflow(s string) (string, error) {
+	if o := strings.ToUpper(s); slices.Contains(overflows, o) {
+		return o, nil
+	}
+	return "", fmt.Errorf("values out of range are made clip or nodata")
+}
+
+// castNoData returns the nodata of a band cast to dataType from one of
+// nodata noData: noData itself if dataType holds it, else the default of
+// dataType.
+func castNoData(dataType string, noData float64) float64 {
+	if v, err := (raster.ReadOptions{NoData: &noData}).NoDataFor(dataType); err == nil {
+		return v
+	}
+	return raster.NoDataValue(dataType)
+}
+
+// cast returns v, a value with data, as a pixel of out, of opts.OutputType:
+// rounded as opts.Rounding asks if out holds integers, and clipped to its
+// range, short of noData, or made noData, as opts.Overflow asks, if outside
+// it.
+func (opts GeoTIFFOptions) cast(v float64, out *raster.RasterBase, noData float64) float64 {
+	if math.IsNaN(v) {
+		return noData
+	}
+	bits, format := sampleFormat(out.DataType)
+	var lo, hi float64
+	switch format {
+	case 3:
+		if bits == 64 {
+			return v
+		}
+		lo, hi = -math.MaxFloat32, math.MaxFloat32
+		if math.IsInf(v, 0) {
+			return v
+		}
+	case 2:
+		lo, hi = -math.Ldexp(1, int(bits)-1), math.Ldexp(1, int(bits)-1)-1
+	default:
+		lo, hi = 0, math.Ldexp(1, int(bits))-1
+	}
+	if format != 3 {
+		switch opts.Rounding {
+		case "FLOOR":
+			v = math.Floor(v)
+		case "CEIL":
+			v = math.Ceil(v)
+		case "TRUNC":
+			v = math.Trunc(v)
+		default:
+			v = math.Round(v)
+		}
+	}
+	if v >= lo && v <= hi {
+		return v
+	}
+	if opts.Overflow == "NODATA" {
+		return noData
+	}
+	// Clipped to the end of the range that is nodata, as the greatest of an
+	// unsigned type is by default, a value goes one short of it.
+	c := math.Max(lo, math.Min(hi, v))
+	if c == noData {
+		switch {
+		case format == 3:
+			c = float64(math.Nextafter32(float32(c), 0))
+		case c == hi:
+			c--
+		default:
+			c++
+		}
+	}
+	return c
+}
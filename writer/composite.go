@@ -0,0 +1,226 @@
+package writer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// stretchSample is the most pixels of a band Stretch sorts to find the
+// values its stretch runs between, taken evenly across the band.
+const stretchSample = 1 << 20
+
+// A Scale is how Scale.Stretch spreads the values of a band over bytes:
+// from Min to Max, or, with Percentiles, from the Min-th to the Max-th
+// percentile of them, 0 and 100 being the least and the greatest.
+type Scale struct {
+	Min, Max    float64
+	Percentiles bool
+}
+
+// ParseScale reads a Scale: minmax for the least to the greatest value, p
+// and two percentiles, such as p2,98, or two values, such as 0,3000.
+func ParseScale(s string) (Scale, error) {
+	if strings.EqualFold(s, "minmax") {
+		return Scale{0, 100, true}, nil
+	}
+	sc := Scale{}
+	bounds := s
+	if rest, ok := strings.CutPrefix(strings.ToLower(s), "p"); ok {
+		sc.Percentiles, bounds = true, rest
+	}
+	lo, hi, ok := strings.Cut(bounds, ",")
+	var err error
+	if ok {
+		if sc.Min, err = strconv.ParseFloat(strings.TrimSpace(lo), 64); err == nil {
+			sc.Max, err = strconv.ParseFloat(strings.TrimSpace(hi), 64)
+		}
+	}
+	switch {
+	case !ok || err != nil || math.IsNaN(sc.Min) || math.IsNaN(sc.Max) || math.IsInf(sc.Min, 0) || math.IsInf(sc.Max, 0):
+		return sc, fmt.Errorf("%q is not minmax, two percentiles such as p2,98, or two values such as 0,3000", s)
+	case sc.Percentiles && (sc.Min < 0 || sc.Max > 100 || sc.Min >= sc.Max):
+		return sc, fmt.Errorf("%q is not two percentiles from 0 to 100, the lower first", s)
+	case sc.Min == sc.Max:
+		return sc, fmt.Errorf("%q stretches no values", s)
+	}
+	return sc, nil
+}
+
+// Stretch returns band rd as bytes: its unpacked values from the ends sc
+// sets spread over 1 to 255, those beyond clipped to either end, and nodata
+// 0. Percentiles are of the values other than nodata. A Min above Max
+// inverts the stretch.
+func (sc Scale) Stretch(rd *raster.RasterData) []uint8 {
+	return sc.stretch(rd.GeoData, rd.NoData, rd.RasBase.Unpack)
+}
+
+// Stretch returns the pixels p of a band whose nodata is noData as bytes of
+// a composite: the values from the 2nd to the 98th percentile of those other
+// than nodata spread over 1 to 255, those beyond clipped to either end, so
+// that a few outliers do not leave the rest dark, and nodata 0.
+func Stretch(p raster.Pixels, noData float64) []uint8 {
+	return Scale{2, 98, true}.stretch(p, noData, func(v float64) float64 { return v })
+}
+
+func (sc Scale) stretch(p raster.Pixels, noData float64, unpack func(float64) float64) []uint8 {
+	valid := func(v float64) bool { return v != noData && !math.IsNaN(v) }
+	out := make([]uint8, p.Len())
+	lo, hi := sc.Min, sc.Max
+	if sc.Percentiles {
+		var ok bool
+		if lo, hi, ok = sc.percentiles(p, valid, unpack); !ok {
+			return out
+		}
+	}
+	for i := range out {
+		v := p.Float64(i)
+		switch {
+		case !valid(v):
+			continue
+		case hi == lo:
+			out[i] = 128
+		default:
+			out[i] = uint8(1 + math.Round(254*min(max((unpack(v)-lo)/(hi-lo), 0), 1)))
+		}
+	}
+	return out
+}
+
+// percentiles returns the values at the percentiles of sc among the valid
+// pixels of p, unpacked: found among a sample of at most stretchSample of
+// them, taken evenly across the band, but for the least and the greatest,
+// found among all. It returns false if no pixel is valid.
+func (sc Scale) percentiles(p raster.Pixels, valid func(float64) bool, unpack func(float64) float64) (float64, float64, bool) {
+	step := max(1, p.Len()/stretchSample)
+	sample := make([]float64, 0, min(p.Len(), stretchSample+1))
+	least, greatest := math.Inf(1), math.Inf(-1)
+	for i := 0; i < p.Len(); i++ {
+		v := p.Float64(i)
+		if !valid(v) {
+			continue
+		}
+		v = unpack(v)
+		least, greatest = min(least, v), max(greatest, v)
+		if i%step == 0 {
+			sample = append(sample, v)
+		}
+	}
+	if len(sample) == 0 {
+		return 0, 0, false
+	}
+	slices.Sort(sample)
+	at := func(pct float64) float64 {
+		switch pct {
+		case 0:
+			return least
+		case 100:
+			return greatest
+		}
+		return sample[int(float64(len(sample)-1)*pct/100)]
+	}
+	return at(sc.Min), at(sc.Max), true
+}
+
+// CompositePath returns where the composite of a raster extracted to out
+// goes: beside it, _composite before the extension.
+func CompositePath(out string) string {
+	return strings.TrimSuffix(out, filepath.Ext(out)) + "_composite.tif"
+}
+
+// ScaledPath returns where the 8-bit rendition of a band extracted to path
+// goes: beside it, _8bit before the extension.
+func ScaledPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_8bit.tif"
+}
+
+// WriteComposite writes an RGB GeoTIFF at path of the bytes red, green and
+// blue, such as Stretch returns, each of a band of the grid of rb: samples
+// interleaved by pixel in uncompressed strips of a row, georeferenced with
+// wkt as the bands are. A pixel that is nodata, 0, in any of them is 0 in
+// all, for GDAL to leave it out as nodata. Once ctx is done it removes path
+// and returns ctx.Err().
+func WriteComposite(ctx context.Context, path string, rb *raster.RasterBase, red, green, blue []uint8, wkt string) error {
+	w, h := int(rb.BandWidth), int(rb.BandHeight)
+	l := geoTIFFLayout{width: w, height: h, bits: 8, format: 1, rowBytes: 3 * w, dataStart: 8}
+	data := uint64(l.rowBytes) * uint64(h)
+	if l.big = 8+data+8*uint64(h) > tiffLimit; l.big {
+		l.dataStart = 16
+	}
+	l.ifdOffset = l.dataStart + data
+	if l.ifdOffset%2 == 1 {
+		l.ifdOffset++
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	bw.Write(l.header())
+	offsets, counts := make([]uint64, h), make([]uint32, h)
+	row := make([]byte, l.rowBytes)
+	for y := 0; y < h; y++ {
+		if err := ctx.Err(); err != nil {
+			return abandon(f, err)
+		}
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			r, g, b := red[i], green[i], blue[i]
+			if r == 0 || g == 0 || b == 0 {
+				r, g, b = 0, 0, 0
+			}
+			row[3*x], row[3*x+1], row[3*x+2] = r, g, b
+		}
+		bw.Write(row)
+		offsets[y], counts[y] = l.dataStart+uint64(y*l.rowBytes), uint32(l.rowBytes)
+	}
+	if data%2 == 1 {
+		bw.WriteByte(0)
+	}
+
+	stripOffsets := long8Entry(273, offsets...)
+	if !l.big {
+		offsets32 := make([]uint32, h)
+		for i, off := range offsets {
+			offsets32[i] = uint32(off)
+		}
+		stripOffsets = longEntry(273, offsets32...)
+	}
+	gt := rb.GeoTransform
+	keyDir, keyParams := geoKeys(wkt)
+	entries := []tiffEntry{
+		longEntry(256, uint32(w)),
+		longEntry(257, uint32(h)),
+		shortEntry(258, 8, 8, 8),
+		shortEntry(259, 1),
+		shortEntry(262, 2), // RGB
+		stripOffsets,
+		shortEntry(277, 3),
+		longEntry(278, 1),
+		longEntry(279, counts...),
+		shortEntry(284, 1),
+		shortEntry(339, 1, 1, 1),
+		doubleEntry(33550, gt[1], -gt[5], 0),
+		doubleEntry(33922, 0, 0, 0, gt[0], gt[3], 0),
+		keyDir,
+		asciiEntry(42113, "0"),
+	}
+	if wkt != "" {
+		entries = append(entries, keyParams)
+	}
+	bw.Write(ifdBytes(entries, l.ifdOffset, l.big, 0))
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
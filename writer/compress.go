@@ -0,0 +1,302 @@
+package writer
+
+import (
+	"bytes"
+	"cmp"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// GeoTIFFOptions are the creation options of the GeoTIFFs written, named as
+// those of GDAL's GTiff driver.
+type GeoTIFFOptions struct {
+	Compress   string // NONE, LZW, DEFLATE or ZSTD
+	Predictor  int    // 1 for none, 2 for horizontal differencing, 3 for floating point, 0 for either by band
+	ZLevel     int    // the level of DEFLATE, 1 to 9
+	Tiled      bool
+	BlockXSize int // the width of tiles, 256 by default
+	BlockYSize int // the height of tiles, 256 by default, or the rows of strips
+
+	Overviews         []int  // the factors of the overviews to build, none by default
+	Resampling        string // of overviews, NEAREST or AVERAGE
+	ExternalOverviews bool   // for overviews in a .ovr file beside the GeoTIFF rather than in it
+
+	COG bool // for cloud optimized GeoTIFFs, as COGOptions sets them up
+
+	Unscale bool            // for packed bands to be written unpacked, as gdal_translate -unscale does
+	Units   string          // to convert bands of lengths to, as raster.NormalizeUnit names units, "" for none
+	Reclass *raster.Reclass // to remap the values of bands with, once unpacked and converted, nil for none
+
+	OutputType string // to cast bands to once remapped, as raster names data types, "" for none
+	Rounding   string // of values cast to integers, NEAREST, FLOOR, CEIL or TRUNC; "" for NEAREST
+	Overflow   string // for values cast out of the range of OutputType, CLIP or NODATA; "" for CLIP
+}
+
+// tiffCompressions are the TIFF compression schemes of the compressions.
+var tiffCompressions = map[string]uint16{"NONE": 1, "LZW": 5, "DEFLATE": 8, "ZSTD": 50000}
+
+// predictors are the values PREDICTOR takes, by their numbers or names as
+// GDAL's COG driver has them, YES picking one for each band.
+var predictors = map[string]int{"1": 1, "NO": 1, "2": 2, "STANDARD": 2, "3": 3, "FLOATING_POINT": 3, "YES": 0}
+
+// ParseGeoTIFFOptions reads creation options given as NAME=VALUE, as
+// gdal_translate -co takes them: COMPRESS, PREDICTOR, ZLEVEL, TILED,
+// BLOCKXSIZE and BLOCKYSIZE. Names and values are case insensitive.
+func ParseGeoTIFFOptions(co []string) (GeoTIFFOptions, error) {
+	opts := GeoTIFFOptions{Compress: "NONE", Predictor: 1, ZLevel: 6}
+	for _, o := range co {
+		name, val, ok := strings.Cut(o, "=")
+		if !ok {
+			return opts, fmt.Errorf("creation option %q is not NAME=VALUE", o)
+		}
+		switch strings.ToUpper(name) {
+		case "COMPRESS":
+			opts.Compress = strings.ToUpper(val)
+			if _, ok := tiffCompressions[opts.Compress]; !ok {
+				return opts, fmt.Errorf("COMPRESS takes one of NONE, LZW, DEFLATE, ZSTD")
+			}
+		case "PREDICTOR":
+			n, ok := predictors[strings.ToUpper(val)]
+			if !ok {
+				return opts, fmt.Errorf("PREDICTOR takes 1 (NO), 2 (STANDARD), 3 (FLOATING_POINT) or YES, for 3 on floating point bands and 2 on others")
+			}
+			opts.Predictor = n
+		case "ZLEVEL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 || n > 9 {
+				return opts, fmt.Errorf("ZLEVEL takes a level from 1 to 9")
+			}
+			opts.ZLevel = n
+		case "TILED":
+			switch strings.ToUpper(val) {
+			case "YES", "TRUE", "ON", "1":
+				opts.Tiled = true
+			case "NO", "FALSE", "OFF", "0":
+				opts.Tiled = false
+			default:
+				return opts, fmt.Errorf("TILED takes YES or NO")
+			}
+		case "BLOCKXSIZE", "BLOCKYSIZE":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("%s takes a number of pixels", strings.ToUpper(name))
+			}
+			if strings.EqualFold(name, "BLOCKXSIZE") {
+				opts.BlockXSize = n
+			} else {
+				opts.BlockYSize = n
+			}
+		default:
+			return opts, fmt.Errorf("unknown creation option %s; there are COMPRESS, PREDICTOR, ZLEVEL, TILED, BLOCKXSIZE and BLOCKYSIZE", name)
+		}
+	}
+	// TIFF has tiles a multiple of 16 pixels across and down; strips, which
+	// span the band, are only given a height.
+	switch {
+	case opts.Tiled && (opts.BlockXSize%16 != 0 || opts.BlockYSize%16 != 0):
+		return opts, fmt.Errorf("BLOCKXSIZE and BLOCKYSIZE of tiles take multiples of 16")
+	case !opts.Tiled && opts.BlockXSize > 0:
+		return opts, fmt.Errorf("BLOCKXSIZE is for tiles, with TILED=YES")
+	}
+	return opts, nil
+}
+
+// COGOptions returns opts for cloud optimized GeoTIFFs, as GDAL's COG driver
+// writes them: in tiles, 512 pixels by 512 unless BLOCKXSIZE and BLOCKYSIZE
+// say otherwise, with their IFDs and overviews ahead of the band. The
+// overviews, unless given, go on halving the band until it fits in a tile.
+func (opts GeoTIFFOptions) COGOptions() (GeoTIFFOptions, error) {
+	switch {
+	case !opts.Tiled:
+		return opts, fmt.Errorf("cloud optimized GeoTIFFs are tiled")
+	case opts.ExternalOverviews:
+		return opts, fmt.Errorf("cloud optimized GeoTIFFs have their overviews inside")
+	}
+	opts.COG = true
+	opts.BlockXSize, opts.BlockYSize = cmp.Or(opts.BlockXSize, 512), cmp.Or(opts.BlockYSize, 512)
+	return opts, nil
+}
+
+// compressed reports whether opts asks for any compression.
+func (opts GeoTIFFOptions) compressed() bool {
+	return opts.Compress != "" && opts.Compress != "NONE"
+}
+
+// plain reports whether opts leave GeoTIFFs as CreateGeoTIFF lays them out:
+// uncompressed, in strips of a row, with no overviews inside.
+func (opts GeoTIFFOptions) plain() bool {
+	return !opts.compressed() && !opts.Tiled && opts.BlockYSize <= 1 && len(opts.internalOverviews()) == 0 && !opts.COG
+}
+
+// Check fails for options that do not suit a band of dataType: the floating
+// point predictor is only for floating point bands.
+func (opts GeoTIFFOptions) Check(dataType string) error {
+	if _, format := sampleFormat(dataType); opts.Predictor == 3 && format != 3 {
+		return fmt.Errorf("PREDICTOR=3 is for floating point bands, not %s", dataType)
+	}
+	return nil
+}
+
+// predictor returns the predictor opts asks for on pixels of format, a TIFF
+// SampleFormat, the floating point one on floating point pixels and
+// horizontal differencing on others for PREDICTOR=YES.
+func (opts GeoTIFFOptions) predictor(format uint16) int {
+	switch {
+	case opts.Predictor != 0:
+		return opts.Predictor
+	case format == 3:
+		return 3
+	}
+	return 2
+}
+
+// compressStrip appends the strip b, of rows of rowBytes of samples of size
+// bytes, compressed as opts asks. The predictor works on b in place.
+func (opts GeoTIFFOptions) compressStrip(dst, b []byte, rowBytes, size int) ([]byte, error) {
+	for row := 0; row < len(b); row += rowBytes {
+		switch opts.Predictor {
+		case 2:
+			horizontalDifference(b[row:row+rowBytes], size)
+		case 3:
+			floatDifference(b[row:row+rowBytes], size)
+		}
+	}
+	switch opts.Compress {
+	case "LZW":
+		return lzwCompress(dst, b), nil
+	case "ZSTD":
+		return zstdCompress(dst, b), nil
+	}
+	buf := bytes.NewBuffer(dst)
+	zw, err := zlib.NewWriterLevel(buf, opts.ZLevel)
+	if err != nil {
+		return nil, err
+	}
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// horizontalDifference applies TIFF predictor 2 to row, of little-endian
+// samples of size bytes: each sample less the one before it.
+func horizontalDifference(row []byte, size int) {
+	le := binary.LittleEndian
+	for i := len(row) - size; i >= size; i -= size {
+		switch size {
+		case 1:
+			row[i] -= row[i-1]
+		case 2:
+			le.PutUint16(row[i:], le.Uint16(row[i:])-le.Uint16(row[i-2:]))
+		case 4:
+			le.PutUint32(row[i:], le.Uint32(row[i:])-le.Uint32(row[i-4:]))
+		case 8:
+			le.PutUint64(row[i:], le.Uint64(row[i:])-le.Uint64(row[i-8:]))
+		}
+	}
+}
+
+// floatDifference applies TIFF predictor 3 to row, of little-endian
+// floating point samples of size bytes: their bytes, most significant
+// first, laid out one byte of every sample after another, each byte less the
+// one before it.
+func floatDifference(row []byte, size int) {
+	n := len(row) / size
+	tmp := make([]byte, len(row))
+	for i := 0; i < n; i++ {
+		for b := 0; b < size; b++ {
+			tmp[b*n+i] = row[i*size+size-1-b]
+		}
+	}
+	for i := len(tmp) - 1; i > 0; i-- {
+		tmp[i] -= tmp[i-1]
+	}
+	copy(row, tmp)
+}
+
+// lzwCompress appends src compressed with the LZW of TIFF: codes written
+// most significant bit first, from 9 bits wide up to 12, widened as libtiff
+// widens them, one code early, with the table cleared when it fills up.
+func lzwCompress(dst, src []byte) []byte {
+	const (
+		clearCode = 256
+		eoiCode   = 257
+		firstCode = 258
+		maxCode   = 4094
+		hashSize  = 1 << 13
+	)
+	var keys [hashSize]uint32 // 1 + the code of the prefix << 8 | the byte added
+	var codes [hashSize]uint16
+	w := msbWriter{b: dst}
+	width, next := uint(9), firstCode
+	w.add(clearCode, width)
+	if len(src) == 0 {
+		w.add(eoiCode, width)
+		return w.flush()
+	}
+
+	prefix := uint32(src[0])
+	for _, c := range src[1:] {
+		key := (prefix<<8 | uint32(c)) + 1
+		h := (key * 2654435761) >> (32 - 13)
+		for keys[h] != 0 && keys[h] != key {
+			h = (h + 1) & (hashSize - 1)
+		}
+		if keys[h] == key {
+			prefix = uint32(codes[h])
+			continue
+		}
+		w.add(prefix, width)
+		keys[h], codes[h] = key, uint16(next)
+		next++
+		prefix = uint32(c)
+		if next == maxCode {
+			w.add(clearCode, width)
+			keys = [hashSize]uint32{}
+			width, next = 9, firstCode
+		} else if next > 1<<width-1 {
+			width++
+		}
+	}
+	// The last code counts as adding to the table, as libtiff has it, for
+	// the end of information code to be as wide as readers expect.
+	w.add(prefix, width)
+	next++
+	if next == maxCode {
+		w.add(clearCode, width)
+		width = 9
+	} else if next > 1<<width-1 {
+		width++
+	}
+	w.add(eoiCode, width)
+	return w.flush()
+}
+
+// An msbWriter appends bits to b, from the most significant of each byte.
+type msbWriter struct {
+	b     []byte
+	acc   uint32
+	nbits uint
+}
+
+func (w *msbWriter) add(v uint32, n uint) {
+	w.acc = w.acc<<n | v
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.b = append(w.b, byte(w.acc>>(w.nbits-8)))
+		w.nbits -= 8
+	}
+}
+
+func (w *msbWriter) flush() []byte {
+	if w.nbits > 0 {
+		w.b = append(w.b, byte(w.acc<<(8-w.nbits)))
+	}
+	return w.b
+}
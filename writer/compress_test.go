@@ -0,0 +1,119 @@
+package writer_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// testBand returns a band of width by height pixels of dataType: noise in
+// the first rows, which LZW codes a byte or two at a time and so clears its
+// table of 4094 codes in every strip of them, and a gradient with runs
+// below, which it codes in codes up to 12 bits wide.
+func testBand(dataType string, width, height int) *raster.RasterData {
+	rng := rand.New(rand.NewSource(1))
+	pixels := raster.NewPixels(dataType, width*height)
+	vals := make([]float64, width*height)
+	for i := range vals {
+		switch x, y := i%width, i/width; {
+		case y < height/2 && dataType == "uint8":
+			vals[i] = float64(rng.Intn(256))
+		case y < height/2:
+			vals[i] = float64(rng.Intn(30000))
+		default:
+			vals[i] = float64(x/7*3 + y)
+		}
+		if dataType == "float32" || dataType == "float64" {
+			vals[i] /= 8
+		}
+	}
+	switch p := pixels.(type) {
+	case raster.Buffer[uint8]:
+		fill(p, vals)
+	case raster.Buffer[int16]:
+		fill(p, vals)
+	case raster.Buffer[uint16]:
+		fill(p, vals)
+	case raster.Buffer[int32]:
+		fill(p, vals)
+	case raster.Buffer[float32]:
+		fill(p, vals)
+	case raster.Buffer[float64]:
+		fill(p, vals)
+	}
+	return &raster.RasterData{
+		GeoData: pixels,
+		RasBase: raster.RasterBase{
+			DataType: dataType, BandWidth: int32(width), BandHeight: int32(height),
+			GeoTransform: [6]float64{500000, 10, 0, 4200000, 0, -10},
+		},
+		NoData: raster.NoDataValue(dataType),
+	}
+}
+
+// fill sets the pixels of p to vals, which are whole and in the range of T
+// but for floats.
+func fill[T raster.PixelType](p raster.Buffer[T], vals []float64) {
+	for i, v := range vals {
+		p[i] = T(v)
+	}
+}
+
+// TestCompressRoundTrip writes bands compressed with LZW, DEFLATE and ZSTD,
+// with and without predictors 2 and 3, in strips and tiles, and reads them
+// back through raster.ReadTIFF, which decodes each on its own: the pixels
+// must come back as they were.
+func TestCompressRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		dataType string
+		co       []string
+	}{
+		{"uint8", []string{"BLOCKYSIZE=16"}},
+		{"uint8", []string{"PREDICTOR=2", "BLOCKYSIZE=16"}},
+		{"int16", []string{"PREDICTOR=2", "TILED=YES", "BLOCKXSIZE=128", "BLOCKYSIZE=64"}},
+		{"uint16", []string{"PREDICTOR=YES"}},
+		{"int32", []string{"PREDICTOR=2", "BLOCKYSIZE=300"}},
+		{"float32", []string{"PREDICTOR=3", "BLOCKYSIZE=300"}},
+		{"float64", []string{"PREDICTOR=FLOATING_POINT", "TILED=YES", "BLOCKXSIZE=256", "BLOCKYSIZE=256"}},
+	} {
+		for _, compress := range []string{"LZW", "DEFLATE", "ZSTD"} {
+			name := fmt.Sprint(tc.dataType, " ", compress, " ", tc.co)
+			co := append([]string{"COMPRESS=" + compress}, tc.co...)
+			opts, err := writer.ParseGeoTIFFOptions(co)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rd := testBand(tc.dataType, 500, 300)
+			path := filepath.Join(t.TempDir(), "band.tif")
+			if err := writer.WriteGeoTIFF(ctx, path, rd, ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.RewriteGeoTIFF(ctx, path, &rd.RasBase, rd.NoData, "", opts); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			tif, err := raster.ReadTIFF(path)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if tif.DataType != tc.dataType || len(tif.Bands) != 1 || tif.Bands[0].Len() != rd.GeoData.Len() {
+				t.Errorf("%s: read %s, %d bands", name, tif.DataType, len(tif.Bands))
+				continue
+			}
+			for i := range rd.GeoData.Len() {
+				got, want := tif.Bands[0].Float64(i), rd.GeoData.Float64(i)
+				if got != want && !(math.IsNaN(got) && math.IsNaN(want)) {
+					t.Errorf("%s: pixel %d is %v, want %v", name, i, got, want)
+					break
+				}
+			}
+		}
+	}
+}
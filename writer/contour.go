@@ -0,0 +1,229 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// ContourOptions are the levels Contours traces lines at.
+type ContourOptions struct {
+	Interval float64 // between levels
+	Base     float64 // a level the others are whole intervals from, 0 by default
+	Units    string  // converts the values of a band of lengths to these first, as GeoTIFFOptions.Units
+}
+
+// maxContourLevels bounds the levels traced, so that an interval far too
+// small for the band is an error rather than a run that never ends.
+const maxContourLevels = 1 << 16
+
+// ContourAttribute is the field of the features of Contours holding the
+// level of their line.
+const ContourAttribute = "ELEV"
+
+// Contours traces the contour lines of band rd at the levels of opts by
+// marching squares, for a DEM to be rescued straight to lines rather than
+// through a GeoTIFF and GDAL. Values are unpacked first, and those of cells
+// with a corner of nodata traced through by no line. The corners are the
+// centers of the pixels, and a cell on a saddle is split as the mean of its
+// corners falls. Each line is a feature of a single part, closed if it
+// rings a hill or hollow, with its level as its ContourAttribute; its field
+// is returned too, for WriteGeoPackage. It stops with ctx.Err() once ctx is
+// done.
+func Contours(ctx context.Context, rd *raster.RasterData, opts ContourOptions) ([]gdb.Field, []Feature, error) {
+	fields := []gdb.Field{{Name: ContourAttribute, Type: 3, Nullable: true}}
+	if !(opts.Interval > 0) || math.IsInf(opts.Interval, 0) {
+		return nil, nil, fmt.Errorf("contour interval %v is not above 0", opts.Interval)
+	}
+	rb := &rd.RasBase
+	w, h := int(rb.BandWidth), int(rb.BandHeight)
+	if rd.GeoData == nil || rd.GeoData.Len() < w*h {
+		return fields, nil, nil
+	}
+	factor, _ := GeoTIFFOptions{Units: opts.Units}.unitFactor(rb)
+	values := make([]float64, w*h)
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i := range values {
+		v := rd.GeoData.Float64(i)
+		if v == rd.NoData || math.IsNaN(v) {
+			values[i] = math.NaN()
+			continue
+		}
+		values[i] = rb.Unpack(v) * factor
+		lo, hi = min(lo, values[i]), max(hi, values[i])
+	}
+	if lo > hi {
+		return fields, nil, nil
+	}
+	first := math.Ceil((lo - opts.Base) / opts.Interval)
+	last := math.Floor((hi - opts.Base) / opts.Interval)
+	if last-first >= maxContourLevels {
+		return nil, nil, fmt.Errorf("contour interval %v gives more than %d levels from %v to %v", opts.Interval, maxContourLevels, lo, hi)
+	}
+
+	features := make([]Feature, 0)
+	for k := first; k <= last; k++ {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		level := opts.Base + k*opts.Interval
+		for _, line := range traceLevel(values, w, h, level) {
+			// A level a peak or pit only just reaches gives a line no
+			// longer than a point.
+			if !slices.ContainsFunc(line, func(p [2]float64) bool { return p != line[0] }) {
+				continue
+			}
+			g := gdb.Geometry{Type: 3, Parts: [][][2]float64{make([][2]float64, len(line))}}
+			for i, p := range line {
+				g.Parts[0][i] = rb.PixelCenter(p[0], p[1])
+			}
+			features = append(features, Feature{ID: len(features) + 1, Geom: g, Attrs: map[string]interface{}{ContourAttribute: level}})
+		}
+	}
+	return fields, features, nil
+}
+
+// contourSegment is the piece of a line crossing one cell, between points
+// on two of its edges, each named by contourEdge.
+type contourSegment struct {
+	from, to   int
+	fromP, toP [2]float64 // in pixels, from the center of the first
+	used       bool
+}
+
+// contourEdge names the edge from the corner at x, y of a grid w wide to
+// the next corner right of it, or, if down, below it. Cells either side of
+// an edge name it alike, which is how their segments are joined.
+func contourEdge(x, y, w int, down bool) int {
+	e := (y*w + x) * 2
+	if down {
+		e++
+	}
+	return e
+}
+
+// contourCases lists the edges of a cell joined by the segments of each of
+// the 16 cases of marching squares: the bits of a case are the corners at
+// or above the level, top left 8, top right 4, bottom right 2 and bottom
+// left 1, and its edges top 0, right 1, bottom 2 and left 3. The saddles,
+// 5 and 10, are those of a cell whose mean is below the level, leaving its
+// corners above apart; a cell whose mean is not joins them, the case of the
+// other saddle.
+var contourCases = [16][][2]int{
+	1: {{3, 2}}, 2: {{2, 1}}, 3: {{3, 1}}, 4: {{0, 1}},
+	5: {{3, 2}, {0, 1}}, 6: {{0, 2}}, 7: {{3, 0}},
+	8: {{3, 0}}, 9: {{0, 2}}, 10: {{3, 0}, {2, 1}},
+	11: {{0, 1}}, 12: {{3, 1}}, 13: {{2, 1}}, 14: {{3, 2}},
+}
+
+// traceLevel returns the lines at level through the w by h values, NaN for
+// nodata, as the points they pass through in pixels.
+func traceLevel(values []float64, w, h int, level float64) [][][2]float64 {
+	segments := make([]contourSegment, 0)
+	at := make(map[int][]int)
+	for y := 0; y+1 < h; y++ {
+		for x := 0; x+1 < w; x++ {
+			tl, tr := values[y*w+x], values[y*w+x+1]
+			bl, br := values[(y+1)*w+x], values[(y+1)*w+x+1]
+			if math.IsNaN(tl) || math.IsNaN(tr) || math.IsNaN(bl) || math.IsNaN(br) {
+				continue
+			}
+			c := 0
+			for i, v := range []float64{bl, br, tr, tl} {
+				if v >= level {
+					c |= 1 << i
+				}
+			}
+			if (c == 5 || c == 10) && (tl+tr+bl+br)/4 >= level {
+				c = 15 - c
+			}
+			// edge returns the name of edge e of the cell and where the
+			// line crosses it.
+			edge := func(e int) (int, [2]float64) {
+				fx, fy := float64(x), float64(y)
+				switch e {
+				case 0:
+					return contourEdge(x, y, w, false), [2]float64{fx + crossing(tl, tr, level), fy}
+				case 1:
+					return contourEdge(x+1, y, w, true), [2]float64{fx + 1, fy + crossing(tr, br, level)}
+				case 2:
+					return contourEdge(x, y+1, w, false), [2]float64{fx + crossing(bl, br, level), fy + 1}
+				}
+				return contourEdge(x, y, w, true), [2]float64{fx, fy + crossing(tl, bl, level)}
+			}
+			for _, s := range contourCases[c] {
+				var seg contourSegment
+				seg.from, seg.fromP = edge(s[0])
+				seg.to, seg.toP = edge(s[1])
+				at[seg.from] = append(at[seg.from], len(segments))
+				at[seg.to] = append(at[seg.to], len(segments))
+				segments = append(segments, seg)
+			}
+		}
+	}
+
+	lines := make([][][2]float64, 0)
+	for i := range segments {
+		if segments[i].used {
+			continue
+		}
+		segments[i].used = true
+		line := [][2]float64{segments[i].fromP, segments[i].toP}
+		head, tail := segments[i].from, segments[i].to
+		// Follow the line on from its tail, then back from its head, until
+		// it ends at nodata or the edge of the band, or comes round to
+		// where it started.
+		for tail != head {
+			next, p, ok := nextSegment(segments, at, tail)
+			if !ok {
+				break
+			}
+			line, tail = append(line, p), next
+		}
+		if tail == head {
+			line[len(line)-1] = line[0]
+		} else {
+			var back [][2]float64
+			for {
+				next, p, ok := nextSegment(segments, at, head)
+				if !ok {
+					break
+				}
+				back, head = append(back, p), next
+			}
+			for l, r := 0, len(back)-1; l < r; l, r = l+1, r-1 {
+				back[l], back[r] = back[r], back[l]
+			}
+			line = append(back, line...)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// nextSegment takes the segment not yet used that meets edge, returning
+// the edge at its other end and the point where the line crosses it.
+func nextSegment(segments []contourSegment, at map[int][]int, edge int) (int, [2]float64, bool) {
+	for _, j := range at[edge] {
+		s := &segments[j]
+		if s.used {
+			continue
+		}
+		s.used = true
+		if s.from == edge {
+			return s.to, s.toP, true
+		}
+		return s.from, s.fromP, true
+	}
+	return 0, [2]float64{}, false
+}
+
+// crossing returns how far from a to b, of values either side of level,
+// the line at level crosses.
+func crossing(a, b, level float64) float64 {
+	return (level - a) / (b - a)
+}
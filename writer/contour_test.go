@@ -0,0 +1,127 @@
+package writer_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// grid returns a float64 band of w by h pixels of vals, of pixels one unit
+// square with the top left corner of the band at 0, h, and -9999 for
+// nodata.
+func grid(w, h int, vals ...float64) *raster.RasterData {
+	return &raster.RasterData{
+		GeoData: raster.Buffer[float64](vals),
+		RasBase: raster.RasterBase{
+			DataType: "float64", BandWidth: int32(w), BandHeight: int32(h),
+			GeoTransform: [6]float64{0, 1, 0, float64(h), 0, -1},
+		},
+		NoData: -9999,
+	}
+}
+
+// sameParts reports whether the parts of a geometry are want, to within
+// rounding.
+func sameParts(got, want [][][2]float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			return false
+		}
+		for j, p := range want[i] {
+			if math.Abs(got[i][j][0]-p[0]) > 1e-9 || math.Abs(got[i][j][1]-p[1]) > 1e-9 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TestContours traces small grids whose lines are worked out by hand: a
+// ring round a peak, whose top gives only a point, lines across a slope cut
+// short by nodata, and both ways of splitting a saddle.
+func TestContours(t *testing.T) {
+	type line struct {
+		level  float64
+		points [][2]float64
+	}
+	for _, tc := range []struct {
+		name string
+		rd   *raster.RasterData
+		opts writer.ContourOptions
+		want []line
+	}{
+		{
+			name: "peak",
+			rd: grid(3, 3,
+				0, 0, 0,
+				0, 2, 0,
+				0, 0, 0),
+			opts: writer.ContourOptions{Interval: 1},
+			want: []line{{1, [][2]float64{{1, 1.5}, {1.5, 2}, {2, 1.5}, {1.5, 1}, {1, 1.5}}}},
+		},
+		{
+			name: "slope",
+			rd: grid(4, 3,
+				0, 10, 20, 30,
+				0, 10, 20, 30,
+				0, 10, 20, -9999),
+			opts: writer.ContourOptions{Interval: 10, Base: 5},
+			want: []line{
+				{5, [][2]float64{{1, 2.5}, {1, 1.5}, {1, 0.5}}},
+				{15, [][2]float64{{2, 2.5}, {2, 1.5}, {2, 0.5}}},
+				{25, [][2]float64{{3, 2.5}, {3, 1.5}}},
+			},
+		},
+		{
+			name: "saddle",
+			rd: grid(2, 2,
+				1, 0,
+				0, 1),
+			opts: writer.ContourOptions{Interval: 0.4},
+			want: []line{
+				// At 0.4 the mean, 0.5, is above the level: the corners
+				// below are kept apart.
+				{0.4, [][2]float64{{0.5, 0.9}, {0.9, 0.5}}},
+				{0.4, [][2]float64{{1.1, 1.5}, {1.5, 1.1}}},
+				// At 0.8 it is below: the corners above are.
+				{0.8, [][2]float64{{0.5, 1.3}, {0.7, 1.5}}},
+				{0.8, [][2]float64{{1.3, 0.5}, {1.5, 0.7}}},
+			},
+		},
+		{
+			name: "nodata",
+			rd:   grid(2, 2, -9999, -9999, -9999, -9999),
+			opts: writer.ContourOptions{Interval: 1},
+		},
+	} {
+		fields, features, err := writer.Contours(context.Background(), tc.rd, tc.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(fields) != 1 || fields[0].Name != writer.ContourAttribute {
+			t.Errorf("%s: fields %v", tc.name, fields)
+		}
+		if len(features) != len(tc.want) {
+			t.Errorf("%s: %d lines, want %d", tc.name, len(features), len(tc.want))
+			continue
+		}
+		for i, want := range tc.want {
+			f := features[i]
+			level, _ := f.Attrs[writer.ContourAttribute].(float64)
+			if f.ID != i+1 || f.Geom.Type != 3 || math.Abs(level-want.level) > 1e-9 || !sameParts(f.Geom.Parts, [][][2]float64{want.points}) {
+				t.Errorf("%s: line %d is %d at %v: %v, want %v: %v", tc.name, i, f.ID, f.Attrs[writer.ContourAttribute], f.Geom.Parts, want.level, want.points)
+			}
+		}
+	}
+
+	if _, _, err := writer.Contours(context.Background(), grid(1, 1, 0), writer.ContourOptions{}); err == nil {
+		t.Error("no error for an interval of 0")
+	}
+}
@@ -0,0 +1,86 @@
+// Package writer writes what is rescued from a geodatabase in open formats,
+// with no dependencies outside the standard library: GeoTIFF for rasters,
+// GeoJSON, Shapefile and GeoPackage for feature classes, and CSV, Parquet and
+// SQLite for tables.
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// Feature is one decoded row of a feature class.
+type Feature struct {
+	ID    int
+	Geom  gdb.Geometry
+	Attrs map[string]interface{}
+}
+
+// isPlainAttribute reports whether values of fld are decoded into Feature
+// attributes. Other types have no plain equivalent in the vector formats.
+// XML comes out as text, whole, in the formats that do not limit its length.
+func isPlainAttribute(fld *gdb.Field) bool {
+	return fld.Type <= 4 || fld.Type == 12 || fld.Type == 13
+}
+
+// ReadFeatures decodes every row of a feature class. It also returns the
+// attribute fields, in table order, that the features carry values for. Rows
+// that cannot be decoded are reported on stderr and left out. It stops with
+// ctx.Err() once ctx is done.
+func ReadFeatures(ctx context.Context, gdbFilePath string, tableName string) ([]gdb.Field, []Feature, error) {
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer bt.Close()
+
+	attrs := make([]gdb.Field, 0)
+	for _, fld := range bt.Fields {
+		if isPlainAttribute(&fld) {
+			attrs = append(attrs, fld)
+		}
+	}
+
+	features := make([]Feature, 0)
+	rows := bt.Rows()
+	for row := range rows.All() {
+		feat := Feature{ID: row.FID, Attrs: make(map[string]interface{})}
+		for i, fld := range row.Fields {
+			switch {
+			case isPlainAttribute(&fld):
+				feat.Attrs[fld.Name] = row.Values[i]
+			case fld.Type == 7 && row.Values[i] != nil:
+				feat.Geom = row.Values[i].(gdb.Geometry)
+			}
+		}
+		features = append(features, feat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return attrs, features, nil
+}
+
+// WriteGeoJSON writes features as a FeatureCollection.
+func WriteGeoJSON(path string, features []Feature) error {
+	collection := make([]map[string]interface{}, 0, len(features))
+	for _, feat := range features {
+		f := GeoJSONFeature(feat.Geom, feat.Attrs)
+		f["id"] = feat.ID
+		collection = append(collection, f)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(GeoJSONFeatureCollection(collection)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,62 @@
+package writer
+
+import "github.com/albrazeau/goRasterRescue/gdb"
+
+// geoJSONGeometry converts a shape into a GeoJSON geometry object, or nil for
+// empty and unsupported shapes. Z values are kept as third coordinates; M
+// values have no place in GeoJSON.
+func geoJSONGeometry(g gdb.Geometry) map[string]interface{} {
+	if len(g.Parts) == 0 {
+		return nil
+	}
+
+	switch {
+	case gdb.IsPointType(g.Type):
+		return map[string]interface{}{"type": "Point", "coordinates": g.Position(0, 0)}
+	case gdb.IsMultiPointType(g.Type):
+		return map[string]interface{}{"type": "MultiPoint", "coordinates": g.Positions(0, false)}
+	case gdb.IsPolylineType(g.Type) && len(g.Parts) == 1:
+		return map[string]interface{}{"type": "LineString", "coordinates": g.Positions(0, false)}
+	case gdb.IsPolylineType(g.Type):
+		lines := make([][][]float64, len(g.Parts))
+		for p := range g.Parts {
+			lines[p] = g.Positions(p, false)
+		}
+		return map[string]interface{}{"type": "MultiLineString", "coordinates": lines}
+	case gdb.IsPolygonType(g.Type):
+		return geoJSONPolygon(g)
+	}
+	return nil
+}
+
+// geoJSONPolygon converts a polygon into a GeoJSON geometry object, reversing
+// the rings to follow RFC 7946.
+func geoJSONPolygon(g gdb.Geometry) map[string]interface{} {
+	polygons := make([][][][]float64, 0)
+	for _, rings := range gdb.PolygonRings(g) {
+		polygon := make([][][]float64, len(rings))
+		for i, p := range rings {
+			polygon[i] = g.Positions(p, true)
+		}
+		polygons = append(polygons, polygon)
+	}
+
+	if len(polygons) == 1 {
+		return map[string]interface{}{"type": "Polygon", "coordinates": polygons[0]}
+	}
+	return map[string]interface{}{"type": "MultiPolygon", "coordinates": polygons}
+}
+
+// GeoJSONFeature makes a GeoJSON feature of a shape and its properties.
+func GeoJSONFeature(g gdb.Geometry, props map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "Feature",
+		"geometry":   geoJSONGeometry(g),
+		"properties": props,
+	}
+}
+
+// GeoJSONFeatureCollection wraps features made by GeoJSONFeature.
+func GeoJSONFeatureCollection(features []map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "FeatureCollection", "features": features}
+}
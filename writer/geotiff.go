@@ -0,0 +1,922 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// TIFF field types.
+const (
+	tiffASCII  uint16 = 2
+	tiffShort  uint16 = 3
+	tiffLong   uint16 = 4
+	tiffDouble uint16 = 12
+	tiffLong8  uint16 = 16 // BigTIFF only
+)
+
+type tiffEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Data  []byte
+}
+
+func shortEntry(tag uint16, vals ...uint16) tiffEntry {
+	b := make([]byte, 2*len(vals))
+	for i, v := range vals {
+		binary.LittleEndian.PutUint16(b[2*i:], v)
+	}
+	return tiffEntry{tag, tiffShort, uint32(len(vals)), b}
+}
+
+func longEntry(tag uint16, vals ...uint32) tiffEntry {
+	b := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return tiffEntry{tag, tiffLong, uint32(len(vals)), b}
+}
+
+func long8Entry(tag uint16, vals ...uint64) tiffEntry {
+	b := make([]byte, 8*len(vals))
+	for i, v := range vals {
+		binary.LittleEndian.PutUint64(b[8*i:], v)
+	}
+	return tiffEntry{tag, tiffLong8, uint32(len(vals)), b}
+}
+
+func doubleEntry(tag uint16, vals ...float64) tiffEntry {
+	b := make([]byte, 8*len(vals))
+	for i, v := range vals {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(v))
+	}
+	return tiffEntry{tag, tiffDouble, uint32(len(vals)), b}
+}
+
+func asciiEntry(tag uint16, s string) tiffEntry {
+	b := append([]byte(s), 0)
+	return tiffEntry{tag, tiffASCII, uint32(len(b)), b}
+}
+
+// sampleFormat returns the TIFF BitsPerSample and SampleFormat used to store
+// pixels of dataType. Sub-byte types are widened to bytes.
+func sampleFormat(dataType string) (uint16, uint16) {
+	switch dataType {
+	case "1bit", "4bit", "uint8":
+		return 8, 1
+	case "int8":
+		return 8, 2
+	case "int16":
+		return 16, 2
+	case "uint16":
+		return 16, 1
+	case "int32":
+		return 32, 2
+	case "uint32":
+		return 32, 1
+	case "float32":
+		return 32, 3
+	default:
+		return 64, 3
+	}
+}
+
+// geoKeys builds the GeoKeyDirectory and GeoAsciiParams for wkt. Well known
+// EPSG codes are referenced directly; anything else is carried as an ESRI PE
+// string citation, which GDAL knows how to read back.
+func geoKeys(wkt string) (tiffEntry, tiffEntry) {
+	geographic := strings.HasPrefix(wkt, "GEOGCS")
+	modelType := uint16(1)
+	if geographic {
+		modelType = 2
+	}
+
+	code := uint16(32767) // user defined
+	if n := raster.EPSGCode(wkt); n > 0 && n < 32767 {
+		code = uint16(n)
+	}
+
+	citation := "ESRI PE String = " + wkt + "|"
+	keys := [][4]uint16{
+		{1024, 0, 1, modelType},                 // GTModelTypeGeoKey
+		{1025, 0, 1, 1},                         // GTRasterTypeGeoKey, PixelIsArea
+		{1026, 34737, uint16(len(citation)), 0}, // GTCitationGeoKey
+		{3072, 0, 1, code},                      // ProjectedCSTypeGeoKey
+	}
+	if geographic {
+		keys[3][0] = 2048 // GeographicTypeGeoKey
+	}
+	if wkt == "" {
+		keys = keys[:2]
+		citation = ""
+	}
+
+	dir := []uint16{1, 1, 0, uint16(len(keys))}
+	for _, k := range keys {
+		dir = append(dir, k[:]...)
+	}
+	return shortEntry(34735, dir...), asciiEntry(34737, citation)
+}
+
+// gdalMetadata returns the GDAL_METADATA tag of band rb: its name, as the
+// description GDAL gives the band, its description, as the DESCRIPTION
+// metadata item of the band, the scale and offset of packed pixels, the
+// unit of their values and a color interpretation PhotometricInterpretation
+// cannot give a band of its own, such as Red, as GDAL reads them back for
+// GetScale, GetOffset, GetUnitType and GetColorInterpretation. It returns
+// false for a band with none of them.
+func gdalMetadata(rb *raster.RasterBase) (tiffEntry, bool) {
+	colorInterp := rb.ColorInterp
+	if colorInterp == "Gray" || colorInterp == "Palette" {
+		colorInterp = "" // as PhotometricInterpretation has it
+	}
+	if rb.Name == "" && rb.Description == "" && rb.Scale == 0 && rb.Unit == "" && colorInterp == "" {
+		return tiffEntry{}, false
+	}
+	var b bytes.Buffer
+	b.WriteString("<GDALMetadata>\n")
+	item := func(name, role, value string) {
+		fmt.Fprintf(&b, `  <Item name="%s" sample="0"%s>`, name, role)
+		xml.EscapeText(&b, []byte(value))
+		b.WriteString("</Item>\n")
+	}
+	if rb.Name != "" {
+		item("DESCRIPTION", ` role="description"`, rb.Name)
+	}
+	if rb.Description != "" {
+		item("DESCRIPTION", "", rb.Description)
+	}
+	if rb.Scale != 0 {
+		item("SCALE", ` role="scale"`, strconv.FormatFloat(rb.Scale, 'g', -1, 64))
+		item("OFFSET", ` role="offset"`, strconv.FormatFloat(rb.Offset, 'g', -1, 64))
+	}
+	if rb.Unit != "" {
+		item("UNITTYPE", ` role="unittype"`, rb.Unit)
+	}
+	if colorInterp != "" {
+		item("COLORINTERP", ` role="colorinterp"`, colorInterp)
+	}
+	b.WriteString("</GDALMetadata>")
+	return asciiEntry(42112, b.String()), true
+}
+
+// photometric returns the PhotometricInterpretation of band rb, in samples
+// of bits: BlackIsZero, or for a Palette band of no more than 16 bits, a
+// palette, with the ColorMap of its 2^bits values, black for those rb has no
+// color for.
+func photometric(rb *raster.RasterBase, bits uint16) []tiffEntry {
+	if rb.ColorInterp != "Palette" || rb.ColorMap == nil || bits > 16 {
+		return []tiffEntry{shortEntry(262, 1)} // BlackIsZero
+	}
+	n := int64(1) << bits
+	cm := make([]uint16, 3*n)
+	for v, c := range rb.ColorMap {
+		if v >= 0 && v < n {
+			// The colors of a ColorMap go from 0 to 65535.
+			cm[v], cm[n+v], cm[2*n+v] = uint16(c.R)*257, uint16(c.G)*257, uint16(c.B)*257
+		}
+	}
+	return []tiffEntry{shortEntry(262, 3), shortEntry(320, cm...)}
+}
+
+// geoTIFFLayout is where the GeoTIFFs written here put things: the header,
+// one uncompressed strip per row, then the IFD. GeoTIFFs compressed, laid
+// out otherwise or given overviews by their GeoTIFFOptions have their IFDs
+// after their chunks. Bands whose file would outgrow the 32-bit offsets of
+// TIFF are written as BigTIFF, which GDAL and most other readers open just
+// the same.
+type geoTIFFLayout struct {
+	width, height int
+	bits, format  uint16
+	rowBytes      int
+	big           bool
+	dataStart     uint64
+	ifdOffset     uint64
+}
+
+// tiffLimit is the size from which a GeoTIFF is written as BigTIFF: 4 GiB
+// less room for the IFD, whose strip offsets and counts grow with the rows.
+const tiffLimit = math.MaxUint32 - 1<<24
+
+func newGeoTIFFLayout(rb *raster.RasterBase) geoTIFFLayout {
+	l := geoTIFFLayout{width: int(rb.BandWidth), height: int(rb.BandHeight)}
+	l.bits, l.format = sampleFormat(rb.DataType)
+	l.rowBytes = l.width * int(l.bits) / 8
+	data := uint64(l.rowBytes) * uint64(l.height)
+	l.big = 8+data+8*uint64(l.height) > tiffLimit
+	l.dataStart = 8
+	if l.big {
+		l.dataStart = 16
+	}
+	l.ifdOffset = l.dataStart + data
+	if l.ifdOffset%2 == 1 {
+		l.ifdOffset++
+	}
+	return l
+}
+
+// stripBytes is about the size of the strips of a compressed GeoTIFF, of
+// as many rows as fit, unless BLOCKYSIZE sets their rows.
+const stripBytes = 256 << 10
+
+// chunkSize is the size in pixels of the chunks, tiles or strips, a GeoTIFF
+// is written in.
+type chunkSize struct {
+	width, height int
+}
+
+// chunks returns the size of the chunks of a GeoTIFF laid out as l, with
+// the options opts.
+func (opts GeoTIFFOptions) chunks(l geoTIFFLayout) chunkSize {
+	if opts.Tiled {
+		return chunkSize{cmp.Or(opts.BlockXSize, 256), cmp.Or(opts.BlockYSize, 256)}
+	}
+	rows := 1
+	switch {
+	case opts.BlockYSize > 0:
+		rows = opts.BlockYSize
+	case opts.compressed():
+		rows = stripBytes / l.rowBytes
+	}
+	return chunkSize{l.width, max(1, min(rows, l.height))}
+}
+
+func (l geoTIFFLayout) header() []byte {
+	if l.big {
+		header := []byte{'I', 'I', 43, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint64(header[8:], l.ifdOffset)
+		return header
+	}
+	header := []byte{'I', 'I', 42, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(header[4:], uint32(l.ifdOffset))
+	return header
+}
+
+// reduced returns the layout of the overview of the band laid out as l that
+// shrinks it by factor.
+func (l geoTIFFLayout) reduced(factor int) geoTIFFLayout {
+	r := l
+	r.width, r.height = (l.width+factor-1)/factor, (l.height+factor-1)/factor
+	r.rowBytes = r.width * int(l.bits) / 8
+	return r
+}
+
+// A tiffImage is an image of a GeoTIFF being written, the band or one of its
+// overviews, whose rows come one after another and go out a row of chunks
+// at a time.
+type tiffImage struct {
+	l       geoTIFFLayout
+	c       chunkSize
+	rows    []byte // the rows of the row of chunks being filled
+	y       int    // the rows taken so far
+	out     *chunkStream
+	offsets []uint64
+	counts  []uint32
+}
+
+// A chunkStream is where the chunks of images go, pos bytes into the file.
+// Those of a cloud optimized GeoTIFF go into a file of each image's, moved
+// into the GeoTIFF once all are written.
+type chunkStream struct {
+	w    *bufio.Writer
+	pos  uint64
+	file *os.File // of the image's chunks alone, or nil
+}
+
+// A tiffWriter writes the chunks of the images of a GeoTIFF, compressed as
+// opts asks.
+type tiffWriter struct {
+	opts       GeoTIFFOptions
+	pixelBytes int
+	color      []tiffEntry // the PhotometricInterpretation of every image, and ColorMap of a palette
+	pad        []byte      // nodata, a tile across
+	chunk, out []byte
+}
+
+// add adds row to img, writing out its row of chunks once it is complete.
+func (t *tiffWriter) add(img *tiffImage, row []byte) error {
+	img.rows = append(img.rows, row...)
+	img.y++
+	if img.y%img.c.height != 0 && img.y != img.l.height {
+		return nil
+	}
+	c, rowBytes, n := img.c, img.l.rowBytes, len(img.rows)/img.l.rowBytes
+	for x := 0; x < img.l.width; x += c.width {
+		data := img.rows
+		if t.opts.Tiled {
+			// Pixels past the edges of the band, in the tiles along them,
+			// are nodata.
+			t.chunk = t.chunk[:0]
+			x0, x1 := x*t.pixelBytes, min(x+c.width, img.l.width)*t.pixelBytes
+			for y := 0; y < c.height; y++ {
+				if y >= n {
+					t.chunk = append(t.chunk, t.pad...)
+					continue
+				}
+				t.chunk = append(t.chunk, img.rows[y*rowBytes+x0:y*rowBytes+x1]...)
+				t.chunk = append(t.chunk, t.pad[:c.width*t.pixelBytes-(x1-x0)]...)
+			}
+			data = t.chunk
+		}
+		if t.opts.compressed() {
+			var err error
+			t.out, err = t.opts.compressStrip(t.out[:0], data, c.width*t.pixelBytes, t.pixelBytes)
+			if err != nil {
+				return err
+			}
+			data = t.out
+		}
+		img.offsets = append(img.offsets, img.out.pos)
+		img.counts = append(img.counts, uint32(len(data)))
+		img.out.w.Write(data)
+		img.out.pos += uint64(len(data))
+	}
+	img.rows = img.rows[:0]
+	return nil
+}
+
+// entries returns the tags of img, but for those georeferencing it, with
+// offsets as BigTIFF has them if big is set.
+func (t *tiffWriter) entries(img *tiffImage, big bool, noData float64) []tiffEntry {
+	offsetsTag, countsTag := uint16(273), uint16(279)
+	if t.opts.Tiled {
+		offsetsTag, countsTag = 324, 325
+	}
+	chunkOffsets := long8Entry(offsetsTag, img.offsets...)
+	if !big {
+		offsets32 := make([]uint32, len(img.offsets))
+		for i, off := range img.offsets {
+			offsets32[i] = uint32(off)
+		}
+		chunkOffsets = longEntry(offsetsTag, offsets32...)
+	}
+	compression := uint16(1) // no compression
+	if t.opts.compressed() {
+		compression = tiffCompressions[t.opts.Compress]
+	}
+	entries := []tiffEntry{
+		longEntry(256, uint32(img.l.width)),
+		longEntry(257, uint32(img.l.height)),
+		shortEntry(258, img.l.bits),
+		shortEntry(259, compression),
+		chunkOffsets,
+		shortEntry(277, 1),
+		longEntry(countsTag, img.counts...),
+		shortEntry(284, 1),
+		shortEntry(339, img.l.format),
+		asciiEntry(42113, formatNoData(noData)),
+	}
+	entries = append(entries, t.color...)
+	if t.opts.Tiled {
+		entries = append(entries, longEntry(322, uint32(img.c.width)), longEntry(323, uint32(img.c.height)))
+	} else {
+		entries = append(entries, longEntry(278, uint32(img.c.height)))
+	}
+	if t.opts.compressed() && t.opts.Predictor > 1 {
+		entries = append(entries, shortEntry(317, uint16(t.opts.Predictor)))
+	}
+	return entries
+}
+
+// writeGeoTIFF writes the band rb to f, taking each row from row, which
+// appends the pixels of row y to b as AppendLittleEndian does. Laid out as
+// opts asks, in tiles or in strips of more than a row, the band is written
+// chunk after chunk and the IFD after them, followed by the IFDs of any
+// overviews built into it. Once ctx is done it stops with ctx.Err().
+func writeGeoTIFF(ctx context.Context, f *os.File, rb *raster.RasterBase, noData float64, wkt string, opts GeoTIFFOptions, row func(b []byte, y int) []byte) error {
+	return writeTIFF(ctx, f, rb, noData, wkt, opts, true, opts.internalOverviews(), row)
+}
+
+// writeTIFF writes to f the band rb if band is set and its overviews of
+// levels, as writeGeoTIFF does. The overviews are made from the rows of the
+// band as they come, so that neither is ever held whole in memory; a cloud
+// optimized GeoTIFF, whose chunks go after all of its IFDs, needs room for
+// them twice over.
+func writeTIFF(ctx context.Context, f *os.File, rb *raster.RasterBase, noData float64, wkt string, opts GeoTIFFOptions, band bool, levels []int, row func(b []byte, y int) []byte) error {
+	l := newGeoTIFFLayout(rb)
+	if opts.COG && band && len(levels) == 0 {
+		// Each overview halves the one before it, until one fits in a tile.
+		c := opts.chunks(l)
+		for f := 2; (l.width+f/2-1)/(f/2) > c.width || (l.height+f/2-1)/(f/2) > c.height; f *= 2 {
+			levels = append(levels, f)
+		}
+	}
+	var images []*tiffImage
+	if band {
+		images = append(images, &tiffImage{l: l, c: opts.chunks(l)})
+	}
+	overviews := make([]*overview, len(levels))
+	for i, factor := range levels {
+		img := &tiffImage{l: l.reduced(factor)}
+		img.c = opts.chunks(img.l)
+		images = append(images, img)
+		overviews[i] = newOverview(img, factor, l, rb.DataType, noData, opts.Resampling)
+	}
+	laidOut := band && opts.plain()
+	if !laidOut {
+		if err := opts.Check(rb.DataType); err != nil {
+			return err
+		}
+		opts.Predictor = opts.predictor(l.format)
+		// Data that does not compress can come out larger than it went in,
+		// and tiles are padded out to their whole size.
+		var data, chunks uint64
+		for _, img := range images {
+			n := uint64((img.l.width+img.c.width-1)/img.c.width) * uint64((img.l.height+img.c.height-1)/img.c.height)
+			data += n * uint64(img.c.width*img.c.height*int(l.bits)/8)
+			chunks += n
+		}
+		if opts.compressed() {
+			data += data / 2
+		}
+		if 8+data+16*chunks > tiffLimit {
+			l.big, l.dataStart = true, 16
+		}
+	}
+	w := bufio.NewWriter(f)
+	stream := &chunkStream{w: w, pos: l.dataStart}
+	for _, img := range images {
+		img.out = stream
+		if opts.COG {
+			chunks, err := os.CreateTemp(filepath.Dir(f.Name()), filepath.Base(f.Name())+".*.chunks")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(chunks.Name())
+			defer chunks.Close()
+			img.out = &chunkStream{w: bufio.NewWriter(chunks), file: chunks}
+		}
+	}
+	if !opts.COG {
+		w.Write(l.header())
+	}
+
+	t := &tiffWriter{opts: opts, pixelBytes: int(l.bits) / 8, color: photometric(rb, l.bits)}
+	if opts.Tiled {
+		p := raster.NewPixels(rb.DataType, opts.chunks(l).width)
+		p.Fill(noData)
+		t.pad = p.AppendLittleEndian(nil, 0, p.Len())
+	}
+	src := make([]byte, 0, l.rowBytes)
+	for y := 0; y < l.height; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		src = row(src[:0], y)
+		if band {
+			if err := t.add(images[0], src); err != nil {
+				return err
+			}
+		}
+		for _, o := range overviews {
+			if r := o.take(src, y); r != nil {
+				if err := t.add(o.img, r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// The IFD of the band comes first, georeferenced, then one of a reduced
+	// resolution image for each overview, each pointing on to the next.
+	gt := rb.GeoTransform
+	keyDir, keyParams := geoKeys(wkt)
+	ifd := func(i int) []tiffEntry {
+		entries := t.entries(images[i], l.big, noData)
+		if band && i == 0 {
+			entries = append(entries,
+				doubleEntry(33550, gt[1], -gt[5], 0),
+				doubleEntry(33922, 0, 0, 0, gt[0], gt[3], 0),
+				keyDir)
+			if wkt != "" {
+				entries = append(entries, keyParams)
+			}
+			if md, ok := gdalMetadata(rb); ok {
+				entries = append(entries, md)
+			}
+			return entries
+		}
+		return append(entries, longEntry(254, 1)) // NewSubfileType: reduced resolution
+	}
+	writeIFDs := func(off uint64) {
+		for i := range images {
+			next := uint64(0)
+			if i+1 < len(images) {
+				next = off + uint64(len(ifdBytes(ifd(i), off, l.big, 0)))
+			}
+			b := ifdBytes(ifd(i), off, l.big, next)
+			w.Write(b)
+			off += uint64(len(b))
+		}
+	}
+	if opts.COG {
+		return writeCOG(f, w, l, images, ifd, writeIFDs)
+	}
+	if stream.pos%2 == 1 {
+		w.WriteByte(0)
+		stream.pos++
+	}
+	l.ifdOffset = stream.pos
+	writeIFDs(l.ifdOffset)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	// Where the IFD goes is only known once the chunks are written, unless
+	// they are the uncompressed rows of the layout.
+	if !laidOut {
+		_, err := f.WriteAt(l.header(), 0)
+		return err
+	}
+	return nil
+}
+
+// writeCOG lays out the images of a cloud optimized GeoTIFF in f once their
+// chunks are written: the header, the IFDs, which writeIFDs writes from the
+// offset given, then the chunks of the smallest overview on up to those of
+// the band, as GDAL's COG driver has them, for readers fetching a part of the
+// file at a time to find all they need in its first bytes.
+func writeCOG(f *os.File, w *bufio.Writer, l geoTIFFLayout, images []*tiffImage, ifd func(i int) []tiffEntry, writeIFDs func(off uint64)) error {
+	off := l.dataStart
+	for i := range images {
+		off += uint64(len(ifdBytes(ifd(i), off, l.big, 0)))
+	}
+	for i := len(images) - 1; i >= 0; i-- {
+		img := images[i]
+		if err := img.out.w.Flush(); err != nil {
+			return err
+		}
+		for k := range img.offsets {
+			img.offsets[k] += off
+		}
+		off += img.out.pos
+	}
+	l.ifdOffset = l.dataStart
+	w.Write(l.header())
+	writeIFDs(l.dataStart)
+	for i := len(images) - 1; i >= 0; i-- {
+		if _, err := images[i].out.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := w.ReadFrom(images[i].out.file); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// WriteGeoTIFF writes rd as a single band, uncompressed, striped GeoTIFF
+// using wkt for the coordinate system. Once ctx is done it removes path and
+// returns ctx.Err().
+func WriteGeoTIFF(ctx context.Context, path string, rd *raster.RasterData, wkt string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	width := int(rd.RasBase.BandWidth)
+	err = writeGeoTIFF(ctx, f, &rd.RasBase, rd.NoData, wkt, GeoTIFFOptions{}, func(b []byte, y int) []byte {
+		return rd.GeoData.AppendLittleEndian(b, y*width, (y+1)*width)
+	})
+	if ctx.Err() != nil {
+		return abandon(f, ctx.Err())
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// GeoTIFFWriter writes a band into a GeoTIFF laid out as WriteGeoTIFF lays
+// it out, a block at a time, so that the band never has to be held in memory
+// and an extraction that was cut short can carry on into the same file.
+type GeoTIFFWriter struct {
+	f   *os.File
+	l   geoTIFFLayout
+	row []byte
+}
+
+// CreateGeoTIFF writes the GeoTIFF of band rb, rb cropped to any window that
+// is read, with every pixel noData, ready for WriteBlock. Once ctx is done it
+// removes path and returns ctx.Err().
+func CreateGeoTIFF(ctx context.Context, path string, rb *raster.RasterBase, noData float64, wkt string) (*GeoTIFFWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	row := raster.NewPixels(rb.DataType, int(rb.BandWidth))
+	row.Fill(noData)
+	err = writeGeoTIFF(ctx, f, rb, noData, wkt, GeoTIFFOptions{}, func(b []byte, _ int) []byte {
+		return row.AppendLittleEndian(b, 0, row.Len())
+	})
+	if ctx.Err() != nil {
+		return nil, abandon(f, ctx.Err())
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &GeoTIFFWriter{f: f, l: newGeoTIFFLayout(rb)}, nil
+}
+
+// OpenGeoTIFF opens the GeoTIFF that CreateGeoTIFF wrote at path for band
+// rb, to write more blocks into it. It fails if the file was not laid out
+// for a band of the size and data type of rb.
+func OpenGeoTIFF(path string, rb *raster.RasterBase) (*GeoTIFFWriter, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	l := newGeoTIFFLayout(rb)
+	header := make([]byte, len(l.header()))
+	if _, err := f.ReadAt(header, 0); err != nil || !bytes.Equal(header, l.header()) {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a GeoTIFF of this band", path)
+	}
+	return &GeoTIFFWriter{f: f, l: l}, nil
+}
+
+// WriteBlock writes the pixels of b that have data in place, leaving the
+// others as they were: nodata, or what an overlapping block wrote, as Read
+// does in memory.
+func (g *GeoTIFFWriter) WriteBlock(b raster.Block) error {
+	pixelBytes := int(g.l.bits) / 8
+	for y := 0; y < b.Height; y++ {
+		valid := b.Valid[y*b.Width : (y+1)*b.Width]
+		for x := 0; x < len(valid); {
+			if !valid[x] {
+				x++
+				continue
+			}
+			end := x
+			for end < len(valid) && valid[end] {
+				end++
+			}
+			g.row = b.Pixels.AppendLittleEndian(g.row[:0], y*b.Width+x, y*b.Width+end)
+			off := int64(g.l.dataStart) + int64(b.Y+y)*int64(g.l.rowBytes) + int64((b.X+x)*pixelBytes)
+			if _, err := g.f.WriteAt(g.row, off); err != nil {
+				return err
+			}
+			x = end
+		}
+	}
+	return nil
+}
+
+// Sync commits the blocks written so far to disk.
+func (g *GeoTIFFWriter) Sync() error {
+	return g.f.Sync()
+}
+
+// Close closes the file.
+func (g *GeoTIFFWriter) Close() error {
+	return g.f.Close()
+}
+
+// RewriteGeoTIFF rewrites the GeoTIFF that CreateGeoTIFF wrote at path for
+// band rb, once it is complete, compressed and laid out as opts asks, with
+// the overviews it asks for built into it or into path.ovr beside it, and
+// its pixels unpacked, converted, remapped and cast as Written has them;
+// with the options of CreateGeoTIFF it stays as it is. Each new file is
+// written next to where it goes and renamed over it, so that a run cut short leaves the first one whole. A cloud
+// optimized GeoTIFF is then checked as GDAL's
+// validate_cloud_optimized_geotiff.py checks one, failing if it falls
+// short. Once ctx is done it returns ctx.Err().
+func RewriteGeoTIFF(ctx context.Context, path string, rb *raster.RasterBase, noData float64, wkt string, opts GeoTIFFOptions) error {
+	external := opts.ExternalOverviews && len(opts.Overviews) > 0
+	out, outNoData, rewritten := opts.Written(rb, noData)
+	if opts.plain() && !external && !rewritten {
+		return nil
+	}
+	src, err := OpenGeoTIFF(path, rb)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	rows := func(row func(b []byte, y int) []byte) func(b []byte, y int) []byte {
+		if rewritten {
+			return opts.rewriteRows(rb, noData, out, outNoData, row)
+		}
+		return row
+	}
+
+	// The overviews beside it are made first, from the rows as they are.
+	if external {
+		err := replaceFile(path+".ovr", func(f *os.File, row func(b []byte, y int) []byte) error {
+			return writeTIFF(ctx, f, out, outNoData, "", opts, false, opts.Overviews, rows(row))
+		}, src)
+		if err != nil || (opts.plain() && !rewritten) {
+			return err
+		}
+	}
+	err = replaceFile(path, func(f *os.File, row func(b []byte, y int) []byte) error {
+		return writeGeoTIFF(ctx, f, out, outNoData, wkt, opts, rows(row))
+	}, src)
+	if err == nil && opts.COG {
+		if err := raster.ValidateCOG(path); err != nil {
+			return fmt.Errorf("%s is not a valid cloud optimized GeoTIFF: %w", path, err)
+		}
+	}
+	return err
+}
+
+// Written returns band rb as RewriteGeoTIFF writes it with opts, and its
+// nodata value, reporting whether its pixels are rewritten: unpacked, if
+// opts ask for it and rb is packed, converted to opts.Units, if rb is of
+// lengths in another unit, remapped by opts.Reclass, and cast to
+// opts.OutputType. Those unpacked or converted become pixels of float32, or
+// of float64 for pixels of 32 bits or more, which float32 cannot hold every
+// value of; those only remapped keep their type if it holds every value they
+// are remapped to, else become float32, or float64 if that is what holds
+// them, and bits of 1 or 4 become bytes. Pixels that become floats take the
+// nodata of their type, but for those cast, which keep theirs if the type
+// holds it. Rewritten pixels are shown in gray rather than the colors of the
+// values they were. Other bands are returned as they are.
+func (opts GeoTIFFOptions) Written(rb *raster.RasterBase, noData float64) (*raster.RasterBase, float64, bool) {
+	_, convert := opts.unitFactor(rb)
+	unpack := convert || (opts.Unscale && rb.Scale != 0)
+	cast := opts.OutputType != "" && opts.OutputType != rb.DataType
+	if !unpack && opts.Reclass == nil && !cast {
+		return rb, noData, false
+	}
+	out := *rb
+	switch bits, _ := sampleFormat(rb.DataType); {
+	case opts.OutputType != "":
+		out.DataType, noData = opts.OutputType, castNoData(opts.OutputType, noData)
+	case unpack && bits >= 32, !unpack && !opts.Reclass.Fits(rb.DataType) && !opts.Reclass.Fits("float32"):
+		out.DataType, noData = "64bit", raster.NoDataValue("64bit")
+	case unpack || !opts.Reclass.Fits(rb.DataType):
+		out.DataType, noData = "float32", raster.NoDataValue("float32")
+	case rb.DataType == "1bit" || rb.DataType == "4bit":
+		out.DataType = "uint8"
+	}
+	out.Scale, out.Offset = 0, 0
+	if out.ColorInterp == "Palette" {
+		out.ColorInterp, out.ColorMap = "Gray", nil
+	}
+	if convert {
+		out.Unit = raster.NormalizeUnit(opts.Units)
+	}
+	return &out, noData, true
+}
+
+// unitFactor returns what the values of rb are multiplied by to convert them
+// to opts.Units, or 1 and false if they are not to be converted: opts asks
+// for no units, or rb is in them already, or either is not a unit of length.
+func (opts GeoTIFFOptions) unitFactor(rb *raster.RasterBase) (float64, bool) {
+	if opts.Units == "" || raster.NormalizeUnit(rb.Unit) == raster.NormalizeUnit(opts.Units) {
+		return 1, false
+	}
+	if f, ok := raster.UnitFactor(rb.Unit, opts.Units); ok {
+		return f, true
+	}
+	return 1, false
+}
+
+// rewriteRows returns the rows of row, of band rb, as the pixels of out
+// Written returns: unpacked, converted, remapped and cast as opts ask,
+// nodata staying nodata.
+func (opts GeoTIFFOptions) rewriteRows(rb *raster.RasterBase, noData float64, out *raster.RasterBase, outNoData float64, row func(b []byte, y int) []byte) func(b []byte, y int) []byte {
+	bits, format := sampleFormat(rb.DataType)
+	size := int(bits) / 8
+	factor, _ := opts.unitFactor(rb)
+	pixel := raster.NewPixels(out.DataType, 1)
+	// The few values of a categorical band are each remapped once.
+	remapped := make(map[float64]float64)
+	var src []byte
+	return func(b []byte, y int) []byte {
+		src = row(src[:0], y)
+		for x := 0; x+size <= len(src); x += size {
+			v := sampleAt(src[x:], bits, format)
+			switch to, ok := remapped[v]; {
+			case v == noData:
+				v = outNoData
+			case ok:
+				v = to
+			default:
+				to = rb.Unpack(v) * factor
+				if opts.Reclass != nil {
+					if r, ok := opts.Reclass.Map(to); ok {
+						to = r
+					} else {
+						to = outNoData
+					}
+				}
+				if opts.OutputType != "" && to != outNoData {
+					to = opts.cast(to, out, outNoData)
+				}
+				if len(remapped) < 1<<16 {
+					remapped[v] = to
+				}
+				v = to
+			}
+			pixel.Fill(v)
+			b = pixel.AppendLittleEndian(b, 0, 1)
+		}
+		return b
+	}
+}
+
+// replaceFile writes path with write, taking the rows of src, into a file
+// beside it renamed over it once complete.
+func replaceFile(path string, write func(f *os.File, row func(b []byte, y int) []byte) error, src *GeoTIFFWriter) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var readErr error
+	err = write(f, func(b []byte, y int) []byte {
+		start := len(b)
+		b = slices.Grow(b, src.l.rowBytes)[:start+src.l.rowBytes]
+		off := int64(src.l.dataStart) + int64(y)*int64(src.l.rowBytes)
+		if _, err := src.f.ReadAt(b[start:], off); err != nil && readErr == nil {
+			readErr = err
+		}
+		return b
+	})
+	if err == nil {
+		err = readErr
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// formatNoData prints whole numbers without an exponent so the GDAL_NODATA
+// tag matches the integer pixel values exactly.
+func formatNoData(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ifdBytes returns an IFD to go at ifdOffset followed by the values that do
+// not fit in the entries themselves, in the layout of BigTIFF if big is set,
+// pointing to the IFD at next, if any.
+func ifdBytes(entries []tiffEntry, ifdOffset uint64, big bool, next uint64) []byte {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+
+	// Classic TIFF has 2 byte entry counts, 12 byte entries with 4 bytes for
+	// the value and 4 byte offsets; BigTIFF 8, 20, 8 and 8.
+	countSize, entrySize, valueSize := uint64(2), uint64(12), 4
+	if big {
+		countSize, entrySize, valueSize = 8, 20, 8
+	}
+	putOffset := func(b []byte, v uint64) []byte {
+		if big {
+			return binary.LittleEndian.AppendUint64(b, v)
+		}
+		return binary.LittleEndian.AppendUint32(b, uint32(v))
+	}
+
+	extra := ifdOffset + countSize + uint64(len(entries))*entrySize + uint64(valueSize)
+	overflow := make([]byte, 0)
+
+	var b []byte
+	if big {
+		b = binary.LittleEndian.AppendUint64(nil, uint64(len(entries)))
+	} else {
+		b = binary.LittleEndian.AppendUint16(nil, uint16(len(entries)))
+	}
+	for _, e := range entries {
+		b = binary.LittleEndian.AppendUint16(b, e.Tag)
+		b = binary.LittleEndian.AppendUint16(b, e.Type)
+		b = putOffset(b, uint64(e.Count))
+		if len(e.Data) <= valueSize {
+			v := make([]byte, valueSize)
+			copy(v, e.Data)
+			b = append(b, v...)
+			continue
+		}
+		b = putOffset(b, extra+uint64(len(overflow)))
+		overflow = append(overflow, e.Data...)
+		if len(overflow)%2 == 1 {
+			overflow = append(overflow, 0)
+		}
+	}
+	b = putOffset(b, next)
+	return append(b, overflow...)
+}
@@ -0,0 +1,52 @@
+package writer_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/internal/gdbfixture"
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// BenchmarkWriteGeoTIFF writes a float32 band of 1024 by 1024 pixels, read
+// from a raster gdbfixture builds, as a GeoTIFF.
+func BenchmarkWriteGeoTIFF(b *testing.B) {
+	r := gdbfixture.Raster{
+		Name: "bench", DataType: "float32", Compression: "lz77",
+		Width: 1024, Height: 1024, BlockWidth: 128, BlockHeight: 128,
+		MinX: 0, MaxY: 1024, CellSize: 1,
+		Bands: [][]float64{make([]float64, 1024*1024)},
+	}
+	for i := range r.Bands[0] {
+		r.Bands[0][i] = float64(i%1024) * 0.25
+	}
+	fsys, err := gdbfixture.Geodatabase{Rasters: []gdbfixture.Raster{r}}.Files()
+	if err != nil {
+		b.Fatal(err)
+	}
+	db, err := gdb.OpenFS(fsys, "bench.gdb")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	ras, err := raster.Open(db, r.Name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	rd, err := ras.Read(raster.ReadOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(b.TempDir(), "bench.tif")
+	ctx := context.Background()
+	b.SetBytes(int64(rd.GeoData.Len()) * 4)
+	b.ResetTimer()
+	for range b.N {
+		if err := writer.WriteGeoTIFF(ctx, path, rd, ras.WKT); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
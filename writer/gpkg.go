@@ -0,0 +1,354 @@
+package writer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// GeoPackage 1.3: a SQLite database with application id "GPKG".
+const (
+	gpkgApplicationID uint32 = 0x47504B47
+	gpkgUserVersion   uint32 = 10300
+)
+
+// GpkgLayer is one feature class to write into a GeoPackage.
+type GpkgLayer struct {
+	Name          string
+	IDColumn      string
+	GeomColumn    string
+	LayerGeomType uint8
+	HasZ, HasM    bool
+	WKT           string
+	Fields        []gdb.Field
+	Features      []Feature
+}
+
+// NewGpkgLayer reads feature class fc for WriteGeoPackage.
+func NewGpkgLayer(ctx context.Context, gdbFilePath string, fc gdb.TableInfo) (GpkgLayer, error) {
+	l := GpkgLayer{Name: fc.Name, IDColumn: "fid", GeomColumn: "geom"}
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, gdb.TableFileName(fc.ID))
+	if err != nil {
+		return l, err
+	}
+	l.LayerGeomType = bt.LayerGeomType
+	l.HasZ, l.HasM = bt.LayerHasZ, bt.LayerHasM
+	for _, fld := range bt.Fields {
+		if fld.Type == 7 {
+			l.GeomColumn = fld.Name
+			l.WKT = fld.Shp.WKT
+		}
+	}
+	if bt.OIDName != "" {
+		l.IDColumn = bt.OIDName
+	}
+	bt.Close()
+
+	l.Fields, l.Features, err = ReadFeatures(ctx, gdbFilePath, gdb.TableFileName(fc.ID))
+	return l, err
+}
+
+// gpkgGeometryTypeName maps a layer geometry type onto the GeoPackage one.
+// Lines and polygons are always written as their multi types, since Esri
+// shapes may have several parts.
+func gpkgGeometryTypeName(layerGeomType uint8) string {
+	switch layerGeomType {
+	case 1:
+		return "POINT"
+	case 2:
+		return "MULTIPOINT"
+	case 3:
+		return "MULTILINESTRING"
+	case 4:
+		return "MULTIPOLYGON"
+	default:
+		return "GEOMETRY"
+	}
+}
+
+func gpkgColumnType(fld *gdb.Field) string {
+	switch fld.Type {
+	case 0:
+		return "SMALLINT"
+	case 1:
+		return "MEDIUMINT"
+	case 12:
+		return "TEXT"
+	case 13:
+		return "INTEGER"
+	case 2:
+		return "FLOAT"
+	case 3:
+		return "DOUBLE"
+	case 4:
+		if fld.Width > 0 {
+			return fmt.Sprintf("TEXT(%d)", fld.Width)
+		}
+		return "TEXT"
+	}
+	return "BLOB"
+}
+
+func sqlIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// wkbDims says which of Z and M a layer's WKB carries.
+type wkbDims struct {
+	Z, M bool
+}
+
+// wkbType adds the ISO offset for the dimensions to a WKB geometry type.
+func (d wkbDims) wkbType(t uint32) uint32 {
+	if d.Z {
+		t += 1000
+	}
+	if d.M {
+		t += 2000
+	}
+	return t
+}
+
+// appendWKBCoord appends point i of part p of g. Layers with Z or M write 0
+// for a missing Z and NaN for a missing M.
+func appendWKBCoord(b []byte, g gdb.Geometry, p int, i int, d wkbDims) []byte {
+	pt := g.Parts[p][i]
+	b = appendFloat64(b, pt[0])
+	b = appendFloat64(b, pt[1])
+	if d.Z {
+		z := 0.0
+		if g.Z != nil {
+			z = g.Z[p][i]
+		}
+		b = appendFloat64(b, z)
+	}
+	if d.M {
+		m := math.NaN()
+		if g.M != nil {
+			m = g.M[p][i]
+		}
+		b = appendFloat64(b, m)
+	}
+	return b
+}
+
+func appendWKBPoint(b []byte, g gdb.Geometry, p int, i int, d wkbDims) []byte {
+	b = append(b, 1)
+	b = binary.LittleEndian.AppendUint32(b, d.wkbType(1))
+	return appendWKBCoord(b, g, p, i, d)
+}
+
+func appendWKBPoints(b []byte, g gdb.Geometry, p int, d wkbDims) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(g.Parts[p])))
+	for i := range g.Parts[p] {
+		b = appendWKBCoord(b, g, p, i, d)
+	}
+	return b
+}
+
+// appendWKB encodes g as little-endian WKB of the multi type matching the
+// layer, with the layer's dimensions.
+func appendWKB(b []byte, g gdb.Geometry, d wkbDims) []byte {
+	switch {
+	case gdb.IsPointType(g.Type):
+		return appendWKBPoint(b, g, 0, 0, d)
+	case gdb.IsMultiPointType(g.Type):
+		b = append(b, 1)
+		b = binary.LittleEndian.AppendUint32(b, d.wkbType(4))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(g.Parts[0])))
+		for i := range g.Parts[0] {
+			b = appendWKBPoint(b, g, 0, i, d)
+		}
+	case gdb.IsPolylineType(g.Type):
+		b = append(b, 1)
+		b = binary.LittleEndian.AppendUint32(b, d.wkbType(5))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(g.Parts)))
+		for p := range g.Parts {
+			b = append(b, 1)
+			b = binary.LittleEndian.AppendUint32(b, d.wkbType(2))
+			b = appendWKBPoints(b, g, p, d)
+		}
+	case gdb.IsPolygonType(g.Type):
+		polygons := gdb.PolygonRings(g)
+		b = append(b, 1)
+		b = binary.LittleEndian.AppendUint32(b, d.wkbType(6))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(polygons)))
+		for _, rings := range polygons {
+			b = append(b, 1)
+			b = binary.LittleEndian.AppendUint32(b, d.wkbType(3))
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(rings)))
+			for _, p := range rings {
+				b = appendWKBPoints(b, g, p, d)
+			}
+		}
+	}
+	return b
+}
+
+// hasWKB reports whether g can be written as WKB: it is neither empty nor of
+// an unsupported type.
+func hasWKB(g gdb.Geometry) bool {
+	return len(g.Parts) > 0 && (gdb.IsPointType(g.Type) || gdb.IsMultiPointType(g.Type) || gdb.IsPolylineType(g.Type) || gdb.IsPolygonType(g.Type))
+}
+
+// gpkgGeometry wraps g in the GeoPackage binary header, or returns nil for
+// empty and unsupported shapes.
+func gpkgGeometry(g gdb.Geometry, srsID int, d wkbDims) interface{} {
+	if !hasWKB(g) {
+		return nil
+	}
+
+	b := []byte{'G', 'P', 0, 0x01} // version 1, little-endian, no envelope
+	if !gdb.IsPointType(g.Type) {
+		b[3] |= 1 << 1 // xy envelope
+	}
+	b = binary.LittleEndian.AppendUint32(b, uint32(int32(srsID)))
+	if !gdb.IsPointType(g.Type) {
+		minX, minY, maxX, maxY := g.Bounds()
+		for _, v := range []float64{minX, maxX, minY, maxY} {
+			b = appendFloat64(b, v)
+		}
+	}
+	return appendWKB(b, g, d)
+}
+
+// gpkgSRS is one row of gpkg_spatial_ref_sys.
+type gpkgSRS struct {
+	ID           int
+	Name         string
+	Organization string
+	OrgID        int
+	Definition   string
+}
+
+// gpkgSRSFor finds or adds the spatial reference system of wkt. WKT carrying
+// an EPSG authority keeps its code; anything else gets a private id.
+func gpkgSRSFor(srs *[]gpkgSRS, wkt string) int {
+	if wkt == "" {
+		return -1
+	}
+	for _, s := range *srs {
+		if s.Definition == wkt {
+			return s.ID
+		}
+	}
+
+	name := "unnamed"
+	if open := strings.Index(wkt, `["`); open >= 0 {
+		if end := strings.Index(wkt[open+2:], `"`); end >= 0 {
+			name = wkt[open+2 : open+2+end]
+		}
+	}
+	s := gpkgSRS{Name: name, Organization: "NONE", Definition: wkt}
+	if code := raster.EPSGCode(wkt); code > 0 {
+		s.ID = code
+		for _, other := range *srs {
+			if other.Organization == "EPSG" && other.OrgID == s.ID {
+				return s.ID
+			}
+		}
+		s.Organization, s.OrgID = "EPSG", s.ID
+	} else {
+		s.ID = 100000
+		for _, other := range *srs {
+			s.ID = max(s.ID, other.ID+1)
+		}
+		s.OrgID = s.ID
+	}
+	*srs = append(*srs, s)
+	return s.ID
+}
+
+// WriteGeoPackage writes layers as the feature tables of a new GeoPackage.
+func WriteGeoPackage(ctx context.Context, path string, layers []GpkgLayer) error {
+	srs := []gpkgSRS{
+		{-1, "Undefined cartesian SRS", "NONE", -1, "undefined"},
+		{0, "Undefined geographic SRS", "NONE", 0, "undefined"},
+		{4326, "WGS 84 geodetic", "EPSG", 4326, `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]`},
+	}
+
+	contents := SQLiteTable{
+		Name: "gpkg_contents",
+		SQL: "CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL, identifier TEXT UNIQUE, " +
+			"description TEXT DEFAULT '', last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')), " +
+			"min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE, srs_id INTEGER, " +
+			"CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id))",
+		Indexes: []sqliteIndex{
+			{Name: "sqlite_autoindex_gpkg_contents_1", Columns: []int{0}},
+			{Name: "sqlite_autoindex_gpkg_contents_2", Columns: []int{2}},
+		},
+	}
+	geomColumns := SQLiteTable{
+		Name: "gpkg_geometry_columns",
+		SQL: "CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL, " +
+			"geometry_type_name TEXT NOT NULL, srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL, " +
+			"CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name), " +
+			"CONSTRAINT uk_gc_table_name UNIQUE (table_name), " +
+			"CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name), " +
+			"CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id))",
+		Indexes: []sqliteIndex{
+			{Name: "sqlite_autoindex_gpkg_geometry_columns_1", Columns: []int{0, 1}},
+			{Name: "sqlite_autoindex_gpkg_geometry_columns_2", Columns: []int{0}},
+		},
+	}
+
+	now := Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	features := make([]SQLiteTable, 0, len(layers))
+	for i, l := range layers {
+		srsID := gpkgSRSFor(&srs, l.WKT)
+		dims := wkbDims{l.HasZ, l.HasM}
+
+		cols := []string{sqlIdent(l.IDColumn) + " INTEGER PRIMARY KEY", sqlIdent(l.GeomColumn) + " " + gpkgGeometryTypeName(l.LayerGeomType)}
+		for j := range l.Fields {
+			cols = append(cols, sqlIdent(l.Fields[j].Name)+" "+gpkgColumnType(&l.Fields[j]))
+		}
+		t := SQLiteTable{Name: l.Name, SQL: "CREATE TABLE " + sqlIdent(l.Name) + " (" + strings.Join(cols, ", ") + ")"}
+
+		bounds := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		for _, feat := range l.Features {
+			// The id column is the rowid, so the record holds NULL for it.
+			vals := []interface{}{nil, gpkgGeometry(feat.Geom, srsID, dims)}
+			for _, fld := range l.Fields {
+				vals = append(vals, feat.Attrs[fld.Name])
+			}
+			t.Rows = append(t.Rows, sqliteRow{int64(feat.ID), vals})
+
+			if vals[1] != nil {
+				minX, minY, maxX, maxY := feat.Geom.Bounds()
+				bounds = [4]float64{math.Min(bounds[0], minX), math.Min(bounds[1], minY), math.Max(bounds[2], maxX), math.Max(bounds[3], maxY)}
+			}
+		}
+		features = append(features, t)
+
+		var minX, minY, maxX, maxY interface{}
+		if !math.IsInf(bounds[0], 0) {
+			minX, minY, maxX, maxY = bounds[0], bounds[1], bounds[2], bounds[3]
+		}
+		contents.Rows = append(contents.Rows, sqliteRow{int64(i + 1), []interface{}{l.Name, "features", l.Name, "", now, minX, minY, maxX, maxY, srsID}})
+		z, m := 0, 0
+		if l.HasZ {
+			z = 1
+		}
+		if l.HasM {
+			m = 1
+		}
+		geomColumns.Rows = append(geomColumns.Rows, sqliteRow{int64(i + 1), []interface{}{l.Name, l.GeomColumn, gpkgGeometryTypeName(l.LayerGeomType), srsID, z, m}})
+	}
+
+	refSys := SQLiteTable{
+		Name: "gpkg_spatial_ref_sys",
+		SQL: "CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER NOT NULL PRIMARY KEY, " +
+			"organization TEXT NOT NULL, organization_coordsys_id INTEGER NOT NULL, definition TEXT NOT NULL, description TEXT)",
+	}
+	for _, s := range srs {
+		refSys.Rows = append(refSys.Rows, sqliteRow{int64(s.ID), []interface{}{s.Name, nil, s.Organization, s.OrgID, s.Definition, nil}})
+	}
+
+	tables := append([]SQLiteTable{refSys, contents, geomColumns}, features...)
+	return WriteSQLite(ctx, path, tables, gpkgApplicationID, gpkgUserVersion)
+}
@@ -0,0 +1,207 @@
+package writer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// wkbReader decodes the little-endian WKB appendWKB writes: geometries as
+// their type followed by their coordinates, or their parts, nested.
+type wkbReader struct {
+	b []byte
+	i int
+}
+
+func (r *wkbReader) uint32() uint32 {
+	r.i += 4
+	return binary.LittleEndian.Uint32(r.b[r.i-4:])
+}
+
+func (r *wkbReader) float64() float64 {
+	r.i += 8
+	return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.i-8:]))
+}
+
+func (r *wkbReader) geometry() []any {
+	if r.b[r.i] != 1 {
+		panic(fmt.Sprintf("byte order %d", r.b[r.i]))
+	}
+	r.i++
+	typ := r.uint32()
+	dims := 2
+	if typ >= 1000 {
+		dims++
+	}
+	g := []any{typ}
+	coords := func() []float64 {
+		pt := make([]float64, dims)
+		for j := range pt {
+			pt[j] = r.float64()
+		}
+		return pt
+	}
+	points := func() []any {
+		pts := make([]any, r.uint32())
+		for i := range pts {
+			pts[i] = coords()
+		}
+		return pts
+	}
+	switch typ % 1000 {
+	case 1:
+		g = append(g, coords())
+	case 2:
+		g = append(g, points())
+	case 3:
+		for range r.uint32() {
+			g = append(g, points())
+		}
+	default:
+		for range r.uint32() {
+			g = append(g, r.geometry())
+		}
+	}
+	return g
+}
+
+// TestWriteGeoPackage writes a layer of polygons in an EPSG projection and
+// one of points with Z and no projection, and reads back the tables a
+// GeoPackage must have, the layers and the binary header and WKB of their
+// geometries.
+func TestWriteGeoPackage(t *testing.T) {
+	defer func(now func() time.Time) { Now = now }(Now)
+	Now = func() time.Time { return time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC) }
+
+	const albers = `PROJCS["NAD_1983_Contiguous_USA_Albers",GEOGCS["GCS_North_American_1983"],AUTHORITY["EPSG","5070"]]`
+	holed := gdb.Geometry{Type: 5, Parts: [][][2]float64{
+		{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}},
+		{{2, 2}, {4, 2}, {4, 4}, {2, 4}, {2, 2}},
+		{{20, -5}, {20, 1}, {30, 1}, {20, -5}},
+	}}
+	soils := GpkgLayer{
+		Name: "soils", IDColumn: "OBJECTID", GeomColumn: "SHAPE", LayerGeomType: 4, WKT: albers,
+		Fields: []gdb.Field{{Name: "muname", Type: 4, Width: 20}, {Name: "acres", Type: 3}},
+		Features: []Feature{
+			{ID: 7, Geom: holed, Attrs: map[string]any{"muname": "Mapunit Ω", "acres": 1.5}},
+			{ID: 9, Attrs: map[string]any{"muname": nil, "acres": 2.0}},
+		},
+	}
+	wells := GpkgLayer{
+		Name: "wells", IDColumn: "fid", GeomColumn: "geom", LayerGeomType: 1, HasZ: true,
+		Fields:   []gdb.Field{{Name: "depth", Type: 0}},
+		Features: []Feature{{ID: 1, Geom: gdb.Geometry{Type: 9, Parts: [][][2]float64{{{3, 4}}}, Z: [][]float64{{12.5}}}, Attrs: map[string]any{"depth": int16(40)}}},
+	}
+	path := filepath.Join(t.TempDir(), "test.gpkg")
+	if err := WriteGeoPackage(context.Background(), path, []GpkgLayer{soils, wells}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := sqliteFile{t, b}
+	if be := binary.BigEndian; be.Uint32(b[68:]) != gpkgApplicationID || be.Uint32(b[60:]) != gpkgUserVersion {
+		t.Errorf("application id %#x, user version %d", be.Uint32(b[68:]), be.Uint32(b[60:]))
+	}
+
+	_, schema := f.table(1)
+	roots := map[string]int{}
+	var names []string
+	for _, row := range schema {
+		names = append(names, fmt.Sprint(row[0], " ", row[1]))
+		roots[row[1].(string)] = int(row[3].(int64))
+	}
+	if want := "[table gpkg_spatial_ref_sys table gpkg_contents index sqlite_autoindex_gpkg_contents_1 index sqlite_autoindex_gpkg_contents_2 " +
+		"table gpkg_geometry_columns index sqlite_autoindex_gpkg_geometry_columns_1 index sqlite_autoindex_gpkg_geometry_columns_2 table soils table wells]"; fmt.Sprint(names) != want {
+		t.Errorf("schema %v, want %s", names, want)
+	}
+	if sql := schema[len(schema)-2][4]; sql != `CREATE TABLE "soils" ("OBJECTID" INTEGER PRIMARY KEY, "SHAPE" MULTIPOLYGON, "muname" TEXT(20), "acres" DOUBLE)` {
+		t.Errorf("soils created by %v", sql)
+	}
+
+	// rows returns the rows of a table as text, integers and reals alike
+	// printed by %v, blobs as the geometries they hold.
+	rows := func(table string) []string {
+		ids, records := f.table(roots[table])
+		var out []string
+		for i, rec := range records {
+			for j, v := range rec {
+				if blob, ok := v.([]byte); ok {
+					rec[j] = gpkgBlob(t, blob)
+				}
+			}
+			out = append(out, fmt.Sprint(ids[i], rec))
+		}
+		return out
+	}
+	// A row wanted ending in ... is matched to there.
+	for _, tc := range []struct {
+		table string
+		want  []string
+	}{
+		{"gpkg_spatial_ref_sys", []string{
+			"-1 [Undefined cartesian SRS <nil> NONE -1 undefined <nil>]",
+			"0 [Undefined geographic SRS <nil> NONE 0 undefined <nil>]",
+			`4326 [WGS 84 geodetic <nil> EPSG 4326 GEOGCS["WGS 84",...`,
+			"5070 [NAD_1983_Contiguous_USA_Albers <nil> EPSG 5070 " + albers + " <nil>]",
+		}},
+		{"gpkg_contents", []string{
+			"1 [soils features soils  2024-03-05T00:00:00.000Z 0 -5 30 10 5070]",
+			"2 [wells features wells  2024-03-05T00:00:00.000Z 3 4 3 4 -1]",
+		}},
+		{"gpkg_geometry_columns", []string{
+			"1 [soils SHAPE MULTIPOLYGON 5070 0 0]",
+			"2 [wells geom POINT -1 1 0]",
+		}},
+		{"soils", []string{
+			"7 [<nil> GP 5070 [0 30 -5 10] [6 [3 [[0 0] [0 10] [10 10] [10 0] [0 0]] [[2 2] [4 2] [4 4] [2 4] [2 2]]] [3 [[20 -5] [20 1] [30 1] [20 -5]]]] Mapunit Ω 1.5]",
+			"9 [<nil> <nil> <nil> 2]",
+		}},
+		{"wells", []string{
+			"1 [<nil> GP -1 [] [1001 [3 4 12.5]] 40]",
+		}},
+	} {
+		got := rows(tc.table)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s holds %q, want %q", tc.table, got, tc.want)
+			continue
+		}
+		for i, want := range tc.want {
+			if prefix, ok := strings.CutSuffix(want, "..."); ok && strings.HasPrefix(got[i], prefix) || got[i] == want {
+				continue
+			}
+			t.Errorf("%s holds %q, want %q", tc.table, got[i], want)
+		}
+	}
+}
+
+// gpkgBlob decodes a GeoPackage geometry: its magic, its srs_id, its
+// envelope, minx, maxx, miny, maxy, and its WKB.
+func gpkgBlob(t *testing.T, b []byte) string {
+	t.Helper()
+	if b[0] != 'G' || b[1] != 'P' || b[2] != 0 || b[3]&1 != 1 {
+		t.Fatalf("geometry header % x", b[:min(len(b), 8)])
+	}
+	r := &wkbReader{b: b, i: 4}
+	srsID := int32(r.uint32())
+	var envelope []float64
+	if b[3]>>1&7 == 1 {
+		for range 4 {
+			envelope = append(envelope, r.float64())
+		}
+	}
+	g := r.geometry()
+	if r.i != len(b) {
+		t.Errorf("%d bytes left after a geometry", len(b)-r.i)
+	}
+	return fmt.Sprint("GP ", srsID, " ", envelope, " ", g)
+}
@@ -0,0 +1,466 @@
+package writer
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MetadataFormats are the formats WriteMetadata writes: the ArcGIS metadata
+// as the geodatabase keeps it, or converted to ISO 19139.
+var MetadataFormats = []string{"esri", "iso19139"}
+
+// xmlDeclaration heads the XML files written.
+const xmlDeclaration = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+
+// WriteMetadata writes doc, the ArcGIS metadata XML of a dataset, to path in
+// format: as it is for esri, or as the gmd:MD_Metadata of ISO 19139 for
+// iso19139, with what of it ISO 19139 has room for.
+func WriteMetadata(path string, doc string, format string) error {
+	// The declaration of doc, if it has one, may name the encoding it had
+	// before the geodatabase was read, UTF-16 say, rather than UTF-8.
+	doc = strings.TrimSpace(doc)
+	if strings.HasPrefix(doc, "<?xml") {
+		if _, rest, ok := strings.Cut(doc, "?>"); ok {
+			doc = strings.TrimSpace(rest)
+		}
+	}
+	var b []byte
+	switch format {
+	case "esri":
+		b = []byte(xmlDeclaration + doc + "\n")
+	case "iso19139":
+		var err error
+		if b, err = esriToISO19139(doc); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown metadata format %q", format)
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// An xmlNode is an element of an XML document, read or written whole.
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []*xmlNode `xml:",any"`
+}
+
+// find returns the first element down path, of names separated by /, or
+// nil.
+func (n *xmlNode) find(path string) *xmlNode {
+	if nodes := n.findAll(path); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return nil
+}
+
+// findAll returns every element down path.
+func (n *xmlNode) findAll(path string) []*xmlNode {
+	if n == nil {
+		return nil
+	}
+	name, rest, more := strings.Cut(path, "/")
+	var found []*xmlNode
+	for _, c := range n.Nodes {
+		if c.XMLName.Local != name {
+			continue
+		}
+		if !more {
+			found = append(found, c)
+		} else {
+			found = append(found, c.findAll(rest)...)
+		}
+	}
+	return found
+}
+
+// text returns the text of the element down path, trimmed, or "".
+func (n *xmlNode) text(path string) string {
+	if c := n.find(path); c != nil {
+		return strings.TrimSpace(c.Text)
+	}
+	return ""
+}
+
+// value returns the value attribute of the element down path, which ArcGIS
+// gives its codes in, or "".
+func (n *xmlNode) value(path string) string {
+	if c := n.find(path); c != nil {
+		for _, a := range c.Attrs {
+			if a.Name.Local == "value" {
+				return a.Value
+			}
+		}
+	}
+	return ""
+}
+
+// element returns the element name, its namespace prefix and all, of the
+// children given, leaving out nils.
+func element(name string, children ...*xmlNode) *xmlNode {
+	n := &xmlNode{XMLName: xml.Name{Local: name}}
+	for _, c := range children {
+		if c != nil {
+			n.Nodes = append(n.Nodes, c)
+		}
+	}
+	return n
+}
+
+// optional returns the element name of children, or nil if they are all
+// nil, for elements with nothing to say to be left out.
+func optional(name string, children ...*xmlNode) *xmlNode {
+	if n := element(name, children...); len(n.Nodes) > 0 {
+		return n
+	}
+	return nil
+}
+
+// charString returns name holding text as a gco:CharacterString, or nil if
+// text is "".
+func charString(name string, text string) *xmlNode {
+	if text == "" {
+		return nil
+	}
+	return element(name, &xmlNode{XMLName: xml.Name{Local: "gco:CharacterString"}, Text: text})
+}
+
+// typed returns name holding text in an element kind, such as gco:Date, or
+// nil if text is "".
+func typed(name string, kind string, text string) *xmlNode {
+	if text == "" {
+		return nil
+	}
+	return element(name, &xmlNode{XMLName: xml.Name{Local: kind}, Text: text})
+}
+
+// codeList returns name holding value of the ISO code list list, or nil if
+// value is "".
+func codeList(name string, list string, value string) *xmlNode {
+	if value == "" {
+		return nil
+	}
+	return element(name, &xmlNode{
+		XMLName: xml.Name{Local: "gmd:" + list},
+		Attrs: []xml.Attr{
+			{Name: xml.Name{Local: "codeList"}, Value: "http://standards.iso.org/iso/19139/resources/gmxCodelists.xml#" + list},
+			{Name: xml.Name{Local: "codeListValue"}, Value: value},
+		},
+		Text: value,
+	})
+}
+
+// The ISO code lists ArcGIS numbers, in their order.
+var (
+	isoRoles = []string{"resourceProvider", "custodian", "owner", "user", "distributor", "originator",
+		"pointOfContact", "principalInvestigator", "processor", "publisher", "author"}
+	isoScopes = []string{"attribute", "attributeType", "collectionHardware", "collectionSession", "dataset",
+		"series", "nonGeographicDataset", "dimensionGroup", "feature", "featureType", "propertyType",
+		"fieldSession", "software", "service", "model", "tile"}
+	isoCharSets = []string{"ucs2", "ucs4", "utf7", "utf8", "utf16", "8859part1", "8859part2", "8859part3",
+		"8859part4", "8859part5", "8859part6", "8859part7", "8859part8", "8859part9", "8859part10",
+		"8859part11", "8859part12", "8859part13", "8859part14", "8859part15", "8859part16", "jis",
+		"shiftJIS", "eucJP", "usAscii", "ebcdic", "eucKR", "big5", "GB2312"}
+	isoTopics = []string{"farming", "biota", "boundaries", "climatologyMeteorologyAtmosphere", "economy",
+		"elevation", "environment", "geoscientificInformation", "health", "imageryBaseMapsEarthCover",
+		"intelligenceMilitary", "inlandWaters", "location", "oceans", "planningCadastre", "society",
+		"structure", "transportation", "utilitiesCommunication"}
+	isoMaintenance = []string{"continual", "daily", "weekly", "fortnightly", "monthly", "quarterly",
+		"biannually", "annually", "asNeeded", "irregular", "notPlanned", "unknown"}
+)
+
+// isoCode returns the name in list of the code ArcGIS numbers from 001, or
+// "" for none.
+func isoCode(list []string, code string) string {
+	var i int
+	if _, err := fmt.Sscanf(code, "%d", &i); err != nil || i < 1 || i > len(list) {
+		return ""
+	}
+	return list[i-1]
+}
+
+// isoDate turns the dates of ArcGIS metadata, as 20191015 or
+// 2019-10-15T00:00:00, into the dates of ISO 19139, as 2019-10-15.
+func isoDate(s string) string {
+	s, _, _ = strings.Cut(s, "T")
+	if len(s) == 8 && !strings.Contains(s, "-") {
+		return s[:4] + "-" + s[4:6] + "-" + s[6:]
+	}
+	return s
+}
+
+// isoNamespaces are the namespaces of the ISO 19139 documents written.
+var isoNamespaces = []xml.Attr{
+	{Name: xml.Name{Local: "xmlns:gmd"}, Value: "http://www.isotc211.org/2005/gmd"},
+	{Name: xml.Name{Local: "xmlns:gco"}, Value: "http://www.isotc211.org/2005/gco"},
+	{Name: xml.Name{Local: "xmlns:gml"}, Value: "http://www.opengis.net/gml"},
+	{Name: xml.Name{Local: "xmlns:xlink"}, Value: "http://www.w3.org/1999/xlink"},
+}
+
+// esriToISO19139 converts the ArcGIS metadata doc to ISO 19139, as ArcGIS
+// exports it through its ISO 19139 translator, for what the two have in
+// common: the contact and dates of the metadata, the citation, abstract,
+// purpose, credits, contacts, keywords, constraints, topics and extent of
+// the dataset, its reference system, its distribution and its lineage.
+func esriToISO19139(doc string) ([]byte, error) {
+	var md xmlNode
+	if err := xml.Unmarshal([]byte(doc), &md); err != nil {
+		return nil, fmt.Errorf("metadata is not XML: %w", err)
+	}
+	id := md.find("dataIdInfo")
+
+	dateStamp := cmp.Or(isoDate(md.text("mdDateSt")), isoDate(md.text("Esri/ModDate")))
+
+	iso := element("gmd:MD_Metadata",
+		charString("gmd:fileIdentifier", md.text("mdFileID")),
+		charString("gmd:language", md.value("mdLang/languageCode")),
+		codeList("gmd:characterSet", "MD_CharacterSetCode", isoCode(isoCharSets, md.value("mdChar/CharSetCd"))),
+		codeList("gmd:hierarchyLevel", "MD_ScopeCode", isoCode(isoScopes, md.value("mdHrLv/ScopeCd"))),
+	)
+	iso.Attrs = isoNamespaces
+	for _, c := range md.findAll("mdContact") {
+		iso.Nodes = append(iso.Nodes, element("gmd:contact", isoParty(c)))
+	}
+	iso.Nodes = append(iso.Nodes, typed("gmd:dateStamp", "gco:Date", dateStamp))
+	iso.Nodes = append(iso.Nodes,
+		charString("gmd:metadataStandardName", "ISO 19139 Geographic Information - Metadata - Implementation Specification"),
+		charString("gmd:metadataStandardVersion", "2007"))
+	if code := md.find("refSysInfo/RefSystem/refSysID/identCode"); code != nil {
+		var c string
+		for _, a := range code.Attrs {
+			if a.Name.Local == "code" {
+				c = a.Value
+			}
+		}
+		iso.Nodes = append(iso.Nodes, element("gmd:referenceSystemInfo", element("gmd:MD_ReferenceSystem",
+			element("gmd:referenceSystemIdentifier", element("gmd:RS_Identifier",
+				charString("gmd:code", c),
+				charString("gmd:codeSpace", md.text("refSysInfo/RefSystem/refSysID/idCodeSpace")),
+				charString("gmd:version", md.text("refSysInfo/RefSystem/refSysID/idVersion")))))))
+	}
+
+	if id != nil {
+		iso.Nodes = append(iso.Nodes, element("gmd:identificationInfo", isoIdentification(id, md.text("Esri/CreaDate"))))
+	}
+	if dist := isoDistribution(&md); dist != nil {
+		iso.Nodes = append(iso.Nodes, element("gmd:distributionInfo", dist))
+	}
+	if dq := md.find("dqInfo"); dq != nil {
+		iso.Nodes = append(iso.Nodes, element("gmd:dataQualityInfo", isoQuality(dq)))
+	}
+
+	b, err := xml.MarshalIndent(iso, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xmlDeclaration)
+	buf.Write(b)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// isoParty converts a contact of ArcGIS metadata, as mdContact or idPoC, to
+// a gmd:CI_ResponsibleParty.
+func isoParty(c *xmlNode) *xmlNode {
+	addr := c.find("rpCntInfo/cntAddress")
+	var address *xmlNode
+	if addr != nil {
+		address = optional("gmd:CI_Address",
+			charString("gmd:deliveryPoint", addr.text("delPoint")),
+			charString("gmd:city", addr.text("city")),
+			charString("gmd:administrativeArea", addr.text("adminArea")),
+			charString("gmd:postalCode", addr.text("postCode")),
+			charString("gmd:country", addr.text("country")),
+			charString("gmd:electronicMailAddress", addr.text("eMailAdd")))
+	}
+	phone := optional("gmd:CI_Telephone",
+		charString("gmd:voice", c.text("rpCntInfo/cntPhone/voiceNum")),
+		charString("gmd:facsimile", c.text("rpCntInfo/cntPhone/faxNum")))
+	var online *xmlNode
+	if link := c.text("rpCntInfo/cntOnlineRes/linkage"); link != "" {
+		online = element("gmd:onlineResource", element("gmd:CI_OnlineResource", typed("gmd:linkage", "gmd:URL", link)))
+	}
+	var contact *xmlNode
+	if info := optional("gmd:CI_Contact", optional("gmd:phone", phone), optional("gmd:address", address), online); info != nil {
+		contact = element("gmd:contactInfo", info)
+	}
+	return element("gmd:CI_ResponsibleParty",
+		charString("gmd:individualName", c.text("rpIndName")),
+		charString("gmd:organisationName", c.text("rpOrgName")),
+		charString("gmd:positionName", c.text("rpPosName")),
+		contact,
+		codeList("gmd:role", "CI_RoleCode", isoCode(isoRoles, c.value("role/RoleCd"))))
+}
+
+// isoIdentification converts dataIdInfo, the description of the dataset, to
+// a gmd:MD_DataIdentification, dated created if its citation has no dates.
+func isoIdentification(id *xmlNode, created string) *xmlNode {
+	cite := id.find("idCitation")
+	citation := element("gmd:CI_Citation", charString("gmd:title", cite.text("resTitle")))
+	if citation.Nodes == nil {
+		citation.Nodes = append(citation.Nodes, charString("gmd:title", "Untitled"))
+	}
+	dates := []struct{ elem, kind string }{{"createDate", "creation"}, {"pubDate", "publication"}, {"reviseDate", "revision"}}
+	for _, d := range dates {
+		if v := isoDate(cite.text("date/" + d.elem)); v != "" {
+			citation.Nodes = append(citation.Nodes, isoCitationDate(v, d.kind))
+		}
+	}
+	if len(citation.Nodes) == 1 && created != "" {
+		citation.Nodes = append(citation.Nodes, isoCitationDate(isoDate(created), "creation"))
+	}
+	for _, p := range cite.findAll("citRespParty") {
+		citation.Nodes = append(citation.Nodes, element("gmd:citedResponsibleParty", isoParty(p)))
+	}
+
+	di := element("gmd:MD_DataIdentification",
+		element("gmd:citation", citation),
+		charString("gmd:abstract", id.text("idAbs")),
+		charString("gmd:purpose", id.text("idPurp")),
+		charString("gmd:credit", id.text("idCredit")))
+	for _, p := range id.findAll("idPoC") {
+		di.Nodes = append(di.Nodes, element("gmd:pointOfContact", isoParty(p)))
+	}
+	if freq := isoCode(isoMaintenance, id.value("resMaint/maintFreq/MaintFreqCd")); freq != "" {
+		di.Nodes = append(di.Nodes, element("gmd:resourceMaintenance", element("gmd:MD_MaintenanceInformation",
+			codeList("gmd:maintenanceAndUpdateFrequency", "MD_MaintenanceFrequencyCode", freq))))
+	}
+	keys := []struct{ elem, kind string }{{"themeKeys", "theme"}, {"placeKeys", "place"}, {"searchKeys", ""}}
+	for _, k := range keys {
+		for _, group := range id.findAll(k.elem) {
+			kw := element("gmd:MD_Keywords")
+			for _, w := range group.findAll("keyword") {
+				kw.Nodes = append(kw.Nodes, charString("gmd:keyword", strings.TrimSpace(w.Text)))
+			}
+			if len(kw.Nodes) == 0 {
+				continue
+			}
+			if k.kind != "" {
+				kw.Nodes = append(kw.Nodes, codeList("gmd:type", "MD_KeywordTypeCode", k.kind))
+			}
+			if t := group.text("thesaName/resTitle"); t != "" {
+				kw.Nodes = append(kw.Nodes, element("gmd:thesaurusName", element("gmd:CI_Citation", charString("gmd:title", t))))
+			}
+			di.Nodes = append(di.Nodes, element("gmd:descriptiveKeywords", kw))
+		}
+	}
+	for _, c := range id.findAll("resConst") {
+		var uses []*xmlNode
+		for _, u := range c.findAll("Consts/useLimit") {
+			uses = append(uses, charString("gmd:useLimitation", strings.TrimSpace(u.Text)))
+		}
+		if legal := c.find("LegConsts"); legal != nil {
+			for _, u := range legal.findAll("useLimit") {
+				uses = append(uses, charString("gmd:useLimitation", strings.TrimSpace(u.Text)))
+			}
+			for _, o := range legal.findAll("othConsts") {
+				uses = append(uses, charString("gmd:otherConstraints", strings.TrimSpace(o.Text)))
+			}
+			if rc := optional("gmd:MD_LegalConstraints", uses...); rc != nil {
+				di.Nodes = append(di.Nodes, element("gmd:resourceConstraints", rc))
+			}
+			continue
+		}
+		if rc := optional("gmd:MD_Constraints", uses...); rc != nil {
+			di.Nodes = append(di.Nodes, element("gmd:resourceConstraints", rc))
+		}
+	}
+	for _, l := range id.findAll("dataLang/languageCode") {
+		for _, a := range l.Attrs {
+			if a.Name.Local == "value" {
+				di.Nodes = append(di.Nodes, charString("gmd:language", a.Value))
+			}
+		}
+	}
+	for _, t := range id.findAll("tpCat/TopicCatCd") {
+		for _, a := range t.Attrs {
+			if topic := isoCode(isoTopics, a.Value); a.Name.Local == "value" && topic != "" {
+				di.Nodes = append(di.Nodes, element("gmd:topicCategory", &xmlNode{XMLName: xml.Name{Local: "gmd:MD_TopicCategoryCode"}, Text: topic}))
+			}
+		}
+	}
+	di.Nodes = append(di.Nodes, charString("gmd:environmentDescription", id.text("envirDesc")))
+	for _, box := range id.findAll("dataExt/geoEle/GeoBndBox") {
+		di.Nodes = append(di.Nodes, element("gmd:extent", element("gmd:EX_Extent",
+			element("gmd:geographicElement", element("gmd:EX_GeographicBoundingBox",
+				typed("gmd:westBoundLongitude", "gco:Decimal", box.text("westBL")),
+				typed("gmd:eastBoundLongitude", "gco:Decimal", box.text("eastBL")),
+				typed("gmd:southBoundLatitude", "gco:Decimal", box.text("southBL")),
+				typed("gmd:northBoundLatitude", "gco:Decimal", box.text("northBL")))))))
+	}
+	return di
+}
+
+// isoCitationDate returns a gmd:date of a citation, of date and kind.
+func isoCitationDate(date string, kind string) *xmlNode {
+	return element("gmd:date", element("gmd:CI_Date",
+		typed("gmd:date", "gco:Date", date),
+		codeList("gmd:dateType", "CI_DateTypeCode", kind)))
+}
+
+// isoDistribution converts the formats, distributors and online links of
+// distInfo to a gmd:MD_Distribution, or nil if it has none.
+func isoDistribution(md *xmlNode) *xmlNode {
+	dist := element("gmd:MD_Distribution")
+	for _, f := range md.findAll("distInfo/distFormat") {
+		dist.Nodes = append(dist.Nodes, element("gmd:distributionFormat", element("gmd:MD_Format",
+			charString("gmd:name", f.text("formatName")),
+			charString("gmd:version", cmp.Or(f.text("formatVer"), "unknown")))))
+	}
+	for _, d := range md.findAll("distInfo/distributor") {
+		if c := d.find("distorCont"); c != nil {
+			dist.Nodes = append(dist.Nodes, element("gmd:distributor", element("gmd:MD_Distributor",
+				element("gmd:distributorContact", isoParty(c)))))
+		}
+	}
+	for _, l := range md.findAll("distInfo/distributor/distorTran/onLineSrc/linkage") {
+		dist.Nodes = append(dist.Nodes, element("gmd:transferOptions", element("gmd:MD_DigitalTransferOptions",
+			element("gmd:onLine", element("gmd:CI_OnlineResource", typed("gmd:linkage", "gmd:URL", strings.TrimSpace(l.Text)))))))
+	}
+	if len(dist.Nodes) == 0 {
+		return nil
+	}
+	return dist
+}
+
+// isoQuality converts dqInfo, the scope, reports and lineage of the data, to
+// a gmd:DQ_DataQuality.
+func isoQuality(dq *xmlNode) *xmlNode {
+	scope := cmp.Or(isoCode(isoScopes, dq.value("dqScope/scpLvl/ScopeCd")), "dataset")
+	q := element("gmd:DQ_DataQuality", element("gmd:scope", element("gmd:DQ_Scope",
+		codeList("gmd:level", "MD_ScopeCode", scope))))
+	lineage := element("gmd:LI_Lineage", charString("gmd:statement", dq.text("dataLineage/statement")))
+	for _, step := range dq.findAll("dataLineage/prcStep") {
+		ps := element("gmd:LI_ProcessStep",
+			charString("gmd:description", cmp.Or(step.text("stepDesc"), "unknown")),
+			typed("gmd:dateTime", "gco:DateTime", step.text("stepDateTm")))
+		for _, p := range step.findAll("stepProc") {
+			ps.Nodes = append(ps.Nodes, element("gmd:processor", isoParty(p)))
+		}
+		for _, src := range step.findAll("stepSrc") {
+			ps.Nodes = append(ps.Nodes, element("gmd:source", isoSource(src)))
+		}
+		lineage.Nodes = append(lineage.Nodes, element("gmd:processStep", ps))
+	}
+	for _, src := range dq.findAll("dataLineage/dataSource") {
+		lineage.Nodes = append(lineage.Nodes, element("gmd:source", isoSource(src)))
+	}
+	if len(lineage.Nodes) > 0 {
+		q.Nodes = append(q.Nodes, element("gmd:lineage", lineage))
+	}
+	return q
+}
+
+// isoSource converts a source of the lineage, dataSource or the stepSrc of a
+// step, to a gmd:LI_Source described by its description or title.
+func isoSource(src *xmlNode) *xmlNode {
+	desc := cmp.Or(src.text("srcDesc"), src.text("srcCitatn/resTitle"), src.text("srcCitatn/resAltTitle"), "unknown")
+	return element("gmd:LI_Source", charString("gmd:description", desc))
+}
@@ -0,0 +1,20 @@
+package writer
+
+import (
+	"os"
+	"time"
+)
+
+// Now gives the time stamped into the files written where their formats
+// hold one: the last change of the layers of a GeoPackage and the date of
+// the .dbf of a Shapefile. A fixed time makes every file written the same,
+// byte for byte, from one run to the next.
+var Now = time.Now
+
+// abandon closes and removes f, an output cut short by err, so that a
+// cancelled export does not leave a file behind that looks complete.
+func abandon(f *os.File, err error) error {
+	f.Close()
+	os.Remove(f.Name())
+	return err
+}
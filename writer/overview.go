@@ -0,0 +1,145 @@
+package writer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// ParseOverviews reads the overview levels gdaladdo takes, the factors by
+// which each shrinks the band, as a list such as 2,4,8,16.
+func ParseOverviews(levels string) ([]int, error) {
+	var factors []int
+	for _, s := range strings.Split(levels, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 2 {
+			return nil, fmt.Errorf("overview levels are factors of 2 or more, such as 2,4,8,16")
+		}
+		factors = append(factors, n)
+	}
+	slices.Sort(factors)
+	return slices.Compact(factors), nil
+}
+
+// resamplings are the ways an overview pixel is made from the pixels of the
+// band it covers: the one at their middle, which keeps the classes of
+// categorical bands, or the mean of those with data.
+var resamplings = []string{"NEAREST", "AVERAGE"}
+
+// ParseResampling reads the resampling of overviews, nearest or average.
+func ParseResampling(s string) (string, error) {
+	if r := strings.ToUpper(s); slices.Contains(resamplings, r) {
+		return r, nil
+	}
+	return "", fmt.Errorf("overviews are resampled with nearest or average")
+}
+
+// internalOverviews returns the levels of the overviews written into the
+// GeoTIFF itself rather than beside it.
+func (opts GeoTIFFOptions) internalOverviews() []int {
+	if opts.ExternalOverviews {
+		return nil
+	}
+	return opts.Overviews
+}
+
+// An overview shrinks the rows of a band, as they come, into the rows of a
+// reduced resolution image of it, each pixel from the factor by factor
+// pixels of the band it covers, the last ones along the edges fewer.
+type overview struct {
+	img           *tiffImage
+	factor        int
+	average       bool
+	width, height int // of the band
+	bits, format  uint16
+	noData        float64
+	pixel         raster.Pixels // a pixel of the band's type, to convert means
+	row           []byte        // the row being made
+	sum           []float64
+	n             []int
+}
+
+func newOverview(img *tiffImage, factor int, band geoTIFFLayout, dataType string, noData float64, resampling string) *overview {
+	o := &overview{
+		img: img, factor: factor, average: resampling == "AVERAGE",
+		width: band.width, height: band.height, bits: band.bits, format: band.format,
+		noData: noData, pixel: raster.NewPixels(dataType, 1),
+	}
+	if o.average {
+		o.sum, o.n = make([]float64, img.l.width), make([]int, img.l.width)
+	}
+	return o
+}
+
+// take takes row y of the band, src, returning the row of the overview it
+// completes, or nil.
+func (o *overview) take(src []byte, y int) []byte {
+	f := o.factor
+	pixelBytes := int(o.bits) / 8
+	first, last := y/f*f, min(y/f*f+f, o.height)-1
+	if !o.average {
+		if y != min(first+f/2, last) {
+			return nil
+		}
+		o.row = o.row[:0]
+		for x := 0; x < o.img.l.width; x++ {
+			sx := min(x*f+f/2, o.width-1) * pixelBytes
+			o.row = append(o.row, src[sx:sx+pixelBytes]...)
+		}
+		return o.row
+	}
+
+	for x := 0; x < o.width; x++ {
+		v := sampleAt(src[x*pixelBytes:], o.bits, o.format)
+		if v == o.noData || math.IsNaN(v) {
+			continue
+		}
+		o.sum[x/f] += v
+		o.n[x/f]++
+	}
+	if y != last {
+		return nil
+	}
+	o.row = o.row[:0]
+	for x := range o.sum {
+		v := o.noData
+		if o.n[x] > 0 {
+			v = o.sum[x] / float64(o.n[x])
+			if o.format != 3 {
+				v = math.Round(v)
+			}
+		}
+		o.pixel.Fill(v)
+		o.row = o.pixel.AppendLittleEndian(o.row, 0, 1)
+		o.sum[x], o.n[x] = 0, 0
+	}
+	return o.row
+}
+
+// sampleAt reads the little-endian sample at the start of b, of bits and
+// TIFF SampleFormat format.
+func sampleAt(b []byte, bits, format uint16) float64 {
+	le := binary.LittleEndian
+	switch {
+	case format == 3 && bits == 32:
+		return float64(math.Float32frombits(le.Uint32(b)))
+	case format == 3:
+		return math.Float64frombits(le.Uint64(b))
+	case bits == 8 && format == 2:
+		return float64(int8(b[0]))
+	case bits == 8:
+		return float64(b[0])
+	case bits == 16 && format == 2:
+		return float64(int16(le.Uint16(b)))
+	case bits == 16:
+		return float64(le.Uint16(b))
+	case format == 2:
+		return float64(int32(le.Uint32(b)))
+	}
+	return float64(le.Uint32(b))
+}
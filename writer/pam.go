@@ -0,0 +1,181 @@
+package writer
+
+import (
+	"encoding/xml"
+	"os"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// pamDataset is the .aux.xml GDAL keeps beside a raster for what its format
+// has no room for, its Persistent Auxiliary Metadata, of a single band.
+type pamDataset struct {
+	XMLName xml.Name      `xml:"PAMDataset"`
+	Band    pamRasterBand `xml:"PAMRasterBand"`
+}
+
+// pamRasterBand has pointers to its lists, for those it has none of to be
+// left out rather than written empty.
+type pamRasterBand struct {
+	Band          int             `xml:"band,attr"`
+	CategoryNames *[]string       `xml:"CategoryNames>Category"`
+	Histograms    *[]pamHistogram `xml:"Histograms>HistItem"`
+	Metadata      *[]pamItem      `xml:"Metadata>MDI"`
+	RAT           *pamRAT         `xml:"GDALRasterAttributeTable"`
+}
+
+type pamHistogram struct {
+	HistMin           string
+	HistMax           string
+	BucketCount       int
+	IncludeOutOfRange int
+	Approximate       int
+	HistCounts        string // the count of each bucket, separated by |
+}
+
+type pamItem struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type pamRAT struct {
+	TableType string         `xml:"tableType,attr"`
+	Fields    []pamFieldDefn `xml:"FieldDefn"`
+	Rows      []pamRow       `xml:"Row"`
+}
+
+type pamFieldDefn struct {
+	Index int `xml:"index,attr"`
+	Name  string
+	Type  int // 0 for integers, 1 for reals, 2 for strings
+	Usage int // 0 for other fields, 1 for the pixel count, 2 for names, 5 for the value
+}
+
+type pamRow struct {
+	Index  int      `xml:"index,attr"`
+	Fields []string `xml:"F"`
+}
+
+// maxCategories bounds the values of a raster given category names: GDAL
+// has a name for every value from 0 up to the largest.
+const maxCategories = 1 << 16
+
+// PAMPath returns where WritePAM puts the .aux.xml of the GeoTIFF at path,
+// beside it as GDAL looks for it.
+func PAMPath(path string) string {
+	return path + ".aux.xml"
+}
+
+// WritePAM writes the .aux.xml of the GeoTIFF at path, for GDAL and ArcGIS
+// to find in it what the geodatabase kept beside the band: its statistics
+// and histogram, from stats, and its value attribute table, rat, as a
+// raster attribute table. The first text field of rat names the categories
+// of a band of small values. Either may be nil; with neither, nothing is
+// written.
+func WritePAM(path string, stats *raster.Statistics, rat *raster.AttributeTable) error {
+	if stats == nil && rat == nil {
+		return nil
+	}
+	band := pamRasterBand{Band: 1}
+	if stats != nil {
+		band.Metadata = &[]pamItem{
+			{"STATISTICS_MAXIMUM", csvValue(stats.Max)},
+			{"STATISTICS_MEAN", csvValue(stats.Mean)},
+			{"STATISTICS_MINIMUM", csvValue(stats.Min)},
+			{"STATISTICS_STDDEV", csvValue(stats.StdDev)},
+		}
+		if len(stats.Histogram) > 0 {
+			counts := make([]string, len(stats.Histogram))
+			for i, c := range stats.Histogram {
+				counts[i] = csvValue(c)
+			}
+			band.Histograms = &[]pamHistogram{{
+				HistMin:     csvValue(stats.Min),
+				HistMax:     csvValue(stats.Max),
+				BucketCount: len(counts),
+				HistCounts:  strings.Join(counts, "|"),
+			}}
+		}
+	}
+	if rat != nil {
+		band.RAT, band.CategoryNames = pamAttributeTable(rat)
+	}
+
+	b, err := xml.MarshalIndent(pamDataset{Band: band}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(PAMPath(path), append(b, '\n'), 0644)
+}
+
+// pamAttributeTable converts rat to a raster attribute table, leaving out
+// the fields it has no type for: shapes, binary and raster fields. It
+// returns the category names of the values too, if they are few enough.
+func pamAttributeTable(rat *raster.AttributeTable) (*pamRAT, *[]string) {
+	t := &pamRAT{TableType: "thematic"}
+	value, name := -1, -1
+	var keep []int
+	for i, fld := range rat.Fields {
+		defn := pamFieldDefn{Index: len(t.Fields), Name: fld.Name}
+		switch fld.Type {
+		case 0, 1, 13:
+			defn.Type = 0
+		case 2, 3:
+			defn.Type = 1
+		case 7, 8, 9:
+			continue
+		default:
+			defn.Type = 2
+		}
+		switch {
+		case strings.EqualFold(fld.Name, "Value") && defn.Type == 0:
+			defn.Usage, value = 5, i
+		case strings.EqualFold(fld.Name, "Count"):
+			defn.Usage = 1
+		case defn.Type == 2 && name < 0:
+			defn.Usage, name = 2, i
+		}
+		t.Fields = append(t.Fields, defn)
+		keep = append(keep, i)
+	}
+	for i, row := range rat.Rows {
+		r := pamRow{Index: i, Fields: make([]string, len(keep))}
+		for j, k := range keep {
+			r.Fields[j] = csvValue(row[k])
+		}
+		t.Rows = append(t.Rows, r)
+	}
+
+	if value < 0 || name < 0 {
+		return t, nil
+	}
+	names := make(map[int64]string)
+	var largest int64 = -1
+	for _, row := range rat.Rows {
+		v, ok := toInt64(row[value])
+		if !ok || v < 0 || v >= maxCategories {
+			return t, nil
+		}
+		names[v] = csvValue(row[name])
+		largest = max(largest, v)
+	}
+	categories := make([]string, largest+1)
+	for v, n := range names {
+		categories[v] = n
+	}
+	return t, &categories
+}
+
+// toInt64 returns the integer value of an integer field.
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
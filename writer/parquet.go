@@ -0,0 +1,491 @@
+package writer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// A minimal writer for Apache Parquet files, enough to hand rescued tables to
+// analytics tools without a dependency. Every column is written PLAIN and
+// uncompressed, one data page per column chunk, in row groups of
+// parquetRowGroupRows rows. Metadata is Thrift compact protocol. See
+// https://parquet.apache.org/docs/file-format/.
+
+const parquetRowGroupRows = 64 * 1024
+
+// CreatedBy names the program in the metadata of the files written, for the
+// formats that record it.
+var CreatedBy = "goRasterRescue"
+
+// Parquet physical types, converted types and enums used here.
+const (
+	parquetInt32     int32 = 1
+	parquetInt64     int32 = 2
+	parquetFloat     int32 = 4
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+
+	parquetUTF8            int32 = 0
+	parquetDate            int32 = 6
+	parquetTimeMillis      int32 = 7
+	parquetTimestampMillis int32 = 9
+	parquetInt16           int32 = 16
+
+	parquetRequired int32 = 0
+	parquetOptional int32 = 1
+
+	parquetPlain int32 = 0
+	parquetRLE   int32 = 3
+)
+
+// Thrift compact protocol type ids.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes Thrift structs in the compact protocol. Fields must be
+// written in increasing id order within a struct.
+type thriftWriter struct {
+	b    []byte
+	last []int16 // id of the last field written, per open struct
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	t.b = binary.AppendUvarint(t.b, v)
+}
+
+func (t *thriftWriter) varint(v int64) {
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	top := len(t.last) - 1
+	if delta := id - t.last[top]; delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.varint(int64(id))
+	}
+	t.last[top] = id
+}
+
+func (t *thriftWriter) begin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) end() {
+	t.b = append(t.b, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.uvarint(uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+// list starts a list field of n elements; the elements follow, each struct
+// between begin and end.
+func (t *thriftWriter) list(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elemType)
+	} else {
+		t.b = append(t.b, 0xF0|elemType)
+		t.uvarint(uint64(n))
+	}
+}
+
+// parquetColumn is one column of a table being written, with the values of
+// the current row group.
+type parquetColumn struct {
+	Name          string
+	Type          int32
+	ConvertedType int32 // -1 for none
+	Optional      bool
+
+	defs   []bool // per row, whether the value is set
+	values []byte // PLAIN encoded values that are set
+
+	// offsets of the column chunks, per row group
+	chunks []parquetChunk
+}
+
+type parquetChunk struct {
+	Offset int64
+	Size   int64
+	Values int64
+}
+
+// add appends one value: nil, int16, int32, int64, float32, float64, string
+// or []byte as the column's type expects. It fails for a null in a required
+// column and for values of other types, appending nothing.
+func (c *parquetColumn) add(v interface{}) error {
+	if v == nil {
+		if !c.Optional {
+			return fmt.Errorf("null in required parquet column %s", c.Name)
+		}
+		c.defs = append(c.defs, false)
+		return nil
+	}
+	switch v := v.(type) {
+	case int16:
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(int32(v)))
+	case int32:
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(v))
+	case int64:
+		c.values = binary.LittleEndian.AppendUint64(c.values, uint64(v))
+	case float32:
+		c.values = binary.LittleEndian.AppendUint32(c.values, math.Float32bits(v))
+	case float64:
+		c.values = binary.LittleEndian.AppendUint64(c.values, math.Float64bits(v))
+	case string:
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(len(v)))
+		c.values = append(c.values, v...)
+	case []byte:
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(len(v)))
+		c.values = append(c.values, v...)
+	default:
+		return fmt.Errorf("unexpected %T in parquet column %s", v, c.Name)
+	}
+	c.defs = append(c.defs, true)
+	return nil
+}
+
+// page encodes the buffered values as a data page: definition levels as
+// RLE runs of bit width 1 for optional columns, then the values.
+func (c *parquetColumn) page() []byte {
+	data := make([]byte, 0, len(c.values)+16)
+	if c.Optional {
+		levels := make([]byte, 0)
+		for i := 0; i < len(c.defs); {
+			j := i
+			for j < len(c.defs) && c.defs[j] == c.defs[i] {
+				j++
+			}
+			levels = binary.AppendUvarint(levels, uint64(j-i)<<1)
+			if c.defs[i] {
+				levels = append(levels, 1)
+			} else {
+				levels = append(levels, 0)
+			}
+			i = j
+		}
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(levels)))
+		data = append(data, levels...)
+	}
+	data = append(data, c.values...)
+
+	var t thriftWriter
+	t.begin()
+	t.i32(1, 0) // DATA_PAGE
+	t.i32(2, int32(len(data)))
+	t.i32(3, int32(len(data)))
+	t.field(5, thriftStruct)
+	t.begin()
+	t.i32(1, int32(len(c.defs)))
+	t.i32(2, parquetPlain)
+	t.i32(3, parquetRLE)
+	t.i32(4, parquetRLE)
+	t.end()
+	t.end()
+	return append(t.b, data...)
+}
+
+// parquetWriter streams rows into a Parquet file. The first write error is
+// kept and returned by Close.
+type parquetWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	err     error
+	offset  int64
+	columns []*parquetColumn
+	rows    int   // rows in the current row group
+	groups  []int // rows per row group
+	meta    map[string]string
+}
+
+func newParquetWriter(path string, columns []*parquetColumn) (*parquetWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	pw := &parquetWriter{f: f, w: bufio.NewWriter(f), columns: columns, meta: make(map[string]string)}
+	pw.write([]byte("PAR1"))
+	return pw, nil
+}
+
+func (pw *parquetWriter) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = pw.w.Write(b)
+	pw.offset += int64(len(b))
+}
+
+// addRow appends one value per column. A row failing leaves the columns
+// before the failing one a value longer, and the file to be abandoned.
+func (pw *parquetWriter) addRow(values []interface{}) error {
+	for i, c := range pw.columns {
+		if err := c.add(values[i]); err != nil {
+			return err
+		}
+	}
+	pw.rows++
+	if pw.rows == parquetRowGroupRows {
+		pw.flushRowGroup()
+	}
+	return nil
+}
+
+func (pw *parquetWriter) flushRowGroup() {
+	if pw.rows == 0 {
+		return
+	}
+	for _, c := range pw.columns {
+		chunk := parquetChunk{Offset: pw.offset, Values: int64(len(c.defs))}
+		page := c.page()
+		pw.write(page)
+		chunk.Size = int64(len(page))
+		c.chunks = append(c.chunks, chunk)
+		c.defs, c.values = c.defs[:0], c.values[:0]
+	}
+	pw.groups = append(pw.groups, pw.rows)
+	pw.rows = 0
+}
+
+// Close writes the last row group and the file metadata.
+func (pw *parquetWriter) Close() error {
+	pw.flushRowGroup()
+
+	total := 0
+	for _, n := range pw.groups {
+		total += n
+	}
+
+	var t thriftWriter
+	t.begin()
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(pw.columns)+1)
+	t.begin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(pw.columns)))
+	t.end()
+	for _, c := range pw.columns {
+		t.begin()
+		t.i32(1, c.Type)
+		if c.Optional {
+			t.i32(3, parquetOptional)
+		} else {
+			t.i32(3, parquetRequired)
+		}
+		t.binary(4, c.Name)
+		if c.ConvertedType >= 0 {
+			t.i32(6, c.ConvertedType)
+		}
+		t.end()
+	}
+	t.i64(3, int64(total))
+	t.list(4, thriftStruct, len(pw.groups))
+	for g, n := range pw.groups {
+		var size int64
+		t.begin()
+		t.list(1, thriftStruct, len(pw.columns))
+		for _, c := range pw.columns {
+			chunk := c.chunks[g]
+			size += chunk.Size
+			t.begin()
+			t.i64(2, chunk.Offset)
+			t.field(3, thriftStruct)
+			t.begin()
+			t.i32(1, c.Type)
+			t.list(2, thriftI32, 2)
+			t.varint(int64(parquetPlain))
+			t.varint(int64(parquetRLE))
+			t.list(3, thriftBinary, 1)
+			t.uvarint(uint64(len(c.Name)))
+			t.b = append(t.b, c.Name...)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, chunk.Values)
+			t.i64(6, chunk.Size)
+			t.i64(7, chunk.Size)
+			t.i64(9, chunk.Offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, size)
+		t.i64(3, int64(n))
+		t.end()
+	}
+	if len(pw.meta) > 0 {
+		t.list(5, thriftStruct, len(pw.meta))
+		for k, v := range pw.meta {
+			t.begin()
+			t.binary(1, k)
+			t.binary(2, v)
+			t.end()
+		}
+	}
+	t.binary(6, CreatedBy)
+	t.end()
+
+	pw.write(t.b)
+	pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(t.b))))
+	pw.write([]byte("PAR1"))
+	if pw.err == nil {
+		pw.err = pw.w.Flush()
+	}
+	if err := pw.f.Close(); pw.err == nil {
+		pw.err = err
+	}
+	return pw.err
+}
+
+// parquetValue converts a value of a gdb.Row into what its column
+// holds: datetimes as milliseconds since the Unix epoch, dates as days since
+// it, times as milliseconds since midnight, GUIDs as text and shapes as WKB.
+func parquetValue(v interface{}, dims wkbDims) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UnixMilli()
+	case gdb.Date:
+		return int32(v.Unix() / 86400)
+	case gdb.TimeOfDay:
+		return int32(time.Duration(v) / time.Millisecond)
+	case gdb.GUID:
+		return v.String()
+	case gdb.Geometry:
+		if !hasWKB(v) {
+			return nil
+		}
+		return appendWKB(nil, v, dims)
+	}
+	return v
+}
+
+// parquetColumnFor picks the column type of fld, or returns nil for fields
+// that have no column: raster fields.
+func parquetColumnFor(fld *gdb.Field) *parquetColumn {
+	c := &parquetColumn{Name: fld.Name, ConvertedType: -1, Optional: true}
+	switch fld.Type {
+	case 0:
+		c.Type, c.ConvertedType = parquetInt32, parquetInt16
+	case 1:
+		c.Type = parquetInt32
+	case 2:
+		c.Type = parquetFloat
+	case 3:
+		c.Type = parquetDouble
+	case 4, 10, 11, 12:
+		c.Type, c.ConvertedType = parquetByteArray, parquetUTF8
+	case 5, 16:
+		c.Type, c.ConvertedType = parquetInt64, parquetTimestampMillis
+	case 13:
+		c.Type = parquetInt64
+	case 14:
+		c.Type, c.ConvertedType = parquetInt32, parquetDate
+	case 15:
+		c.Type, c.ConvertedType = parquetInt32, parquetTimeMillis
+	case 7, 8:
+		c.Type = parquetByteArray
+	default:
+		return nil
+	}
+	return c
+}
+
+// WriteTableParquet writes every row of a table to path. Shapes become a WKB
+// column described by GeoParquet metadata. Rows that cannot be decoded are
+// reported on stderr and left out. Once ctx is done it removes path and
+// returns ctx.Err().
+func WriteTableParquet(ctx context.Context, gdbFilePath string, tableName string, path string) error {
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, tableName)
+	if err != nil {
+		return err
+	}
+	defer bt.Close()
+
+	oidName := bt.OIDName
+	if oidName == "" {
+		oidName = "OBJECTID"
+	}
+	// Object IDs are 64-bit in the tables ArcGIS Pro 3.2 writes with them.
+	oid := &parquetColumn{Name: oidName, Type: parquetInt32, ConvertedType: -1}
+	if bt.Version == 6 {
+		oid.Type = parquetInt64
+	}
+	columns := []*parquetColumn{oid}
+	fieldIndexes := make([]int, 0)
+	geoColumns := make(map[string]interface{})
+	primary := ""
+	for i := range bt.Fields {
+		c := parquetColumnFor(&bt.Fields[i])
+		if c == nil {
+			continue
+		}
+		columns = append(columns, c)
+		fieldIndexes = append(fieldIndexes, i)
+		if bt.Fields[i].Type == 7 {
+			// The crs is left unknown rather than converting the WKT to
+			// PROJJSON.
+			geoColumns[c.Name] = map[string]interface{}{"encoding": "WKB", "geometry_types": []string{}, "crs": nil}
+			primary = c.Name
+		}
+	}
+
+	pw, err := newParquetWriter(path, columns)
+	if err != nil {
+		return err
+	}
+	if primary != "" {
+		geo, err := json.Marshal(map[string]interface{}{"version": "1.0.0", "primary_column": primary, "columns": geoColumns})
+		if err != nil {
+			pw.Close()
+			return err
+		}
+		pw.meta["geo"] = string(geo)
+	}
+
+	dims := wkbDims{bt.LayerHasZ, bt.LayerHasM}
+	rows := bt.Rows()
+	values := make([]interface{}, len(columns))
+	for row := range rows.All() {
+		if oid.Type == parquetInt64 {
+			values[0] = int64(row.FID)
+		} else {
+			values[0] = int32(row.FID)
+		}
+		for c, i := range fieldIndexes {
+			values[c+1] = parquetValue(row.Values[i], dims)
+		}
+		if err := pw.addRow(values); err != nil {
+			return abandon(pw.f, fmt.Errorf("row %d: %w", row.FID, err))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return abandon(pw.f, err)
+	}
+	return pw.Close()
+}
@@ -0,0 +1,285 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/internal/gdbfixture"
+)
+
+// thriftReader decodes Thrift compact protocol: structs as maps by field
+// id, lists as slices, integers as int64 and binaries as strings.
+type thriftReader struct {
+	b []byte
+	i int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.i:])
+	r.i += n
+	return v
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case 3:
+		r.i++
+		return int64(int8(r.b[r.i-1]))
+	case 4, 5, 6:
+		u := r.uvarint()
+		return int64(u>>1) ^ -int64(u&1)
+	case 7:
+		r.i += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.i-8:]))
+	case 8:
+		n := int(r.uvarint())
+		r.i += n
+		return string(r.b[r.i-n : r.i])
+	case 9:
+		h := r.b[r.i]
+		r.i++
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(h & 0x0F)
+		}
+		return list
+	case 12:
+		m := map[int16]any{}
+		var id int16
+		for {
+			h := r.b[r.i]
+			r.i++
+			if h == 0 {
+				return m
+			}
+			if h>>4 != 0 {
+				id += int16(h >> 4)
+			} else {
+				id = int16(r.value(4).(int64))
+			}
+			switch h & 0x0F {
+			case 1:
+				m[id] = true
+			case 2:
+				m[id] = false
+			default:
+				m[id] = r.value(h & 0x0F)
+			}
+		}
+	}
+	panic(fmt.Sprintf("thrift type %d", typ))
+}
+
+// parquetSchemaColumn is a column as the schema of a file describes it.
+type parquetSchemaColumn struct {
+	Name          string
+	Type          int64
+	Repetition    int64
+	ConvertedType int64 // -1 for none
+}
+
+// readParquet reads back what a parquetWriter wrote: the columns of its
+// schema, and their values, nil for nulls, row group after row group.
+func readParquet(t *testing.T, path string) ([]parquetSchemaColumn, [][]any) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("PAR1")) || !bytes.HasSuffix(b, []byte("PAR1")) {
+		t.Fatalf("no PAR1 at the start and end of %s", path)
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	r := &thriftReader{b: b, i: len(b) - 8 - n}
+	meta := r.value(12).(map[int16]any)
+	if r.i != len(b)-8 {
+		t.Fatalf("metadata of %d bytes read as %d", n, r.i-(len(b)-8-n))
+	}
+
+	var schema []parquetSchemaColumn
+	for _, e := range meta[2].([]any)[1:] {
+		e := e.(map[int16]any)
+		c := parquetSchemaColumn{Name: e[4].(string), Type: e[1].(int64), Repetition: e[3].(int64), ConvertedType: -1}
+		if ct, ok := e[6]; ok {
+			c.ConvertedType = ct.(int64)
+		}
+		schema = append(schema, c)
+	}
+
+	values := make([][]any, len(schema))
+	for _, rg := range meta[4].([]any) {
+		for j, cc := range rg.(map[int16]any)[1].([]any) {
+			md := cc.(map[int16]any)[3].(map[int16]any)
+			r := &thriftReader{b: b, i: int(md[9].(int64))}
+			header := r.value(12).(map[int16]any)
+			data := b[r.i : r.i+int(header[3].(int64))]
+			count := int(header[5].(map[int16]any)[1].(int64))
+			if count != int(md[5].(int64)) {
+				t.Fatalf("column %s: %d values in the page, %d in the chunk", schema[j].Name, count, md[5])
+			}
+
+			defined := make([]bool, 0, count)
+			if schema[j].Repetition == int64(parquetOptional) {
+				n := int(binary.LittleEndian.Uint32(data))
+				levels := &thriftReader{b: data[4 : 4+n]}
+				for levels.i < n {
+					run := levels.uvarint()
+					if run&1 != 0 {
+						t.Fatalf("column %s: bit-packed definition levels", schema[j].Name)
+					}
+					for range run >> 1 {
+						defined = append(defined, data[4+levels.i] == 1)
+					}
+					levels.i++
+				}
+				data = data[4+n:]
+			} else {
+				for range count {
+					defined = append(defined, true)
+				}
+			}
+
+			le := binary.LittleEndian
+			for _, d := range defined {
+				if !d {
+					values[j] = append(values[j], nil)
+					continue
+				}
+				var v any
+				switch int32(schema[j].Type) {
+				case parquetInt32:
+					v, data = int32(le.Uint32(data)), data[4:]
+				case parquetInt64:
+					v, data = int64(le.Uint64(data)), data[8:]
+				case parquetFloat:
+					v, data = math.Float32frombits(le.Uint32(data)), data[4:]
+				case parquetDouble:
+					v, data = math.Float64frombits(le.Uint64(data)), data[8:]
+				case parquetByteArray:
+					n := le.Uint32(data)
+					v, data = bytes.Clone(data[4:4+n]), data[4+n:]
+					if schema[j].ConvertedType == int64(parquetUTF8) {
+						v = string(v.([]byte))
+					}
+				}
+				values[j] = append(values[j], v)
+			}
+			if len(data) != 0 {
+				t.Errorf("column %s: %d bytes left in the page", schema[j].Name, len(data))
+			}
+		}
+	}
+	return schema, values
+}
+
+// TestWriteTableParquet writes tables of 10.x and of the 64-bit object IDs
+// of ArcGIS Pro 3.2, with fields of the types Parquet gives a converted
+// type, and reads back their schema and values.
+func TestWriteTableParquet(t *testing.T) {
+	stamp := time.Date(2019, 10, 15, 20, 3, 11, 0, time.UTC)
+	local := time.Date(2024, 3, 5, 14, 30, 0, 0, time.FixedZone("", -5*3600))
+	for _, version := range []int32{gdbfixture.Version10, gdbfixture.Version64Bit} {
+		table := gdbfixture.Table{
+			Name:    "types",
+			Version: version,
+			Fields: []gdbfixture.Field{
+				{Name: "short", Type: gdbfixture.TypeInt16, Nullable: true},
+				{Name: "long", Type: gdbfixture.TypeInt32},
+				{Name: "day", Type: gdbfixture.TypeDateOnly, Nullable: true},
+				{Name: "clock", Type: gdbfixture.TypeTimeOnly, Nullable: true},
+				{Name: "stamp", Type: gdbfixture.TypeDateTime, Nullable: true},
+				{Name: "local", Type: gdbfixture.TypeTimestampOffset, Nullable: true},
+				{Name: "text", Type: gdbfixture.TypeString, Nullable: true},
+				{Name: "big", Type: gdbfixture.TypeInt64, Nullable: true},
+			},
+			Rows: [][]any{
+				{
+					int16(-12), int32(70000), gdb.Date{Time: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+					gdb.TimeOfDay(13*time.Hour + 5*time.Minute + 7250*time.Millisecond),
+					stamp, local, "Mapunit Ω", int64(1) << 40,
+				},
+				nil,
+				{nil, int32(-3), nil, nil, nil, nil, nil, nil},
+			},
+		}
+		dir := filepath.Join(t.TempDir(), "fixture.gdb")
+		if err := (gdbfixture.Geodatabase{Tables: []gdbfixture.Table{table}}).WriteDir(dir); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "types.parquet")
+		if err := WriteTableParquet(context.Background(), dir, gdb.TableFileName(2), path); err != nil {
+			t.Fatal(err)
+		}
+		schema, values := readParquet(t, path)
+
+		oidType := parquetInt32
+		if version == gdbfixture.Version64Bit {
+			oidType = parquetInt64
+		}
+		wantSchema := []parquetSchemaColumn{
+			{"OBJECTID", int64(oidType), int64(parquetRequired), -1},
+			{"short", int64(parquetInt32), int64(parquetOptional), int64(parquetInt16)},
+			{"long", int64(parquetInt32), int64(parquetOptional), -1},
+			{"day", int64(parquetInt32), int64(parquetOptional), int64(parquetDate)},
+			{"clock", int64(parquetInt32), int64(parquetOptional), int64(parquetTimeMillis)},
+			{"stamp", int64(parquetInt64), int64(parquetOptional), int64(parquetTimestampMillis)},
+			{"local", int64(parquetInt64), int64(parquetOptional), int64(parquetTimestampMillis)},
+			{"text", int64(parquetByteArray), int64(parquetOptional), int64(parquetUTF8)},
+			{"big", int64(parquetInt64), int64(parquetOptional), -1},
+		}
+		if fmt.Sprint(schema) != fmt.Sprint(wantSchema) {
+			t.Errorf("version %d: schema %v, want %v", version, schema, wantSchema)
+		}
+
+		oids := []any{int32(1), int32(3)}
+		if version == gdbfixture.Version64Bit {
+			oids = []any{int64(1), int64(3)}
+		}
+		want := [][]any{
+			oids,
+			{int32(-12), nil},
+			{int32(70000), int32(-3)},
+			{int32(19782), nil},
+			{int32(47107250), nil},
+			{stamp.UnixMilli(), nil},
+			{local.UnixMilli(), nil},
+			{"Mapunit Ω", nil},
+			{int64(1) << 40, nil},
+		}
+		for i, col := range want {
+			if i >= len(values) || fmt.Sprintf("%#v", values[i]) != fmt.Sprintf("%#v", col) {
+				t.Errorf("version %d: column %s holds %#v, want %#v", version, wantSchema[i].Name, values[i], col)
+			}
+		}
+	}
+}
+
+// TestParquetColumnAdd checks that a null in a required column and a value
+// of no Parquet type fail, adding nothing.
+func TestParquetColumnAdd(t *testing.T) {
+	c := &parquetColumn{Name: "OBJECTID", Type: parquetInt32, ConvertedType: -1}
+	for _, v := range []any{nil, uint64(1), time.Now()} {
+		if err := c.add(v); err == nil {
+			t.Errorf("%T added to a required INT32 column", v)
+		}
+	}
+	if len(c.defs) != 0 || len(c.values) != 0 {
+		t.Errorf("%d values added, %d bytes", len(c.defs), len(c.values))
+	}
+	c.Optional = true
+	if err := c.add(nil); err != nil || len(c.defs) != 1 {
+		t.Errorf("null in an optional column: %v", err)
+	}
+}
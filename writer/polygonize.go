@@ -0,0 +1,265 @@
+package writer
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// PolygonizeAttribute is the field of the features of Polygonize holding
+// the value of the pixels of their region, named as in a value attribute
+// table.
+const PolygonizeAttribute = "Value"
+
+// Polygonize returns the regions of pixels of band rd of equal value, those
+// meeting side to side, as polygons: land cover classes, say, back as the
+// vectors they were drawn from. Nodata is left out. Each region is a
+// feature of one outer ring, clockwise as Esri has it, and the rings of the
+// holes in it, along the edges of its pixels, with its value, unpacked, as
+// its PolygonizeAttribute. If rat, the value attribute table of the band,
+// is not nil, each feature carries the fields of the row of its value too,
+// but for the count of its pixels, which is of the whole band. The fields
+// are returned as well, for WriteGeoPackage. It stops with ctx.Err() once
+// ctx is done.
+func Polygonize(ctx context.Context, rd *raster.RasterData, rat *raster.AttributeTable) ([]gdb.Field, []Feature, error) {
+	rb := &rd.RasBase
+	w, h := int(rb.BandWidth), int(rb.BandHeight)
+	value := gdb.Field{Name: PolygonizeAttribute, Type: 1, Nullable: true}
+	switch _, format := sampleFormat(rb.DataType); {
+	case format == 3 || rb.Scale != 0:
+		value.Type = 3
+	case rb.DataType == "uint32":
+		value.Type = 13
+	}
+	fields := []gdb.Field{value}
+	ratValue, joined := -1, make([]int, 0)
+	if rat != nil {
+		for i, fld := range rat.Fields {
+			switch {
+			case strings.EqualFold(fld.Name, PolygonizeAttribute):
+				ratValue = i
+			case !strings.EqualFold(fld.Name, "Count") && isPlainAttribute(&fld):
+				fields, joined = append(fields, fld), append(joined, i)
+			}
+		}
+	}
+	if rd.GeoData == nil || rd.GeoData.Len() < w*h {
+		return fields, nil, nil
+	}
+
+	labels, values, err := regions(ctx, rd, w, h)
+	if err != nil {
+		return nil, nil, err
+	}
+	rings, err := traceRegions(ctx, labels, w, h, len(values))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := make(map[float64][]interface{})
+	if ratValue >= 0 {
+		for _, row := range rat.Rows {
+			if v, ok := toInt64(row[ratValue]); ok {
+				rows[float64(v)] = row
+			}
+		}
+	}
+	features := make([]Feature, 0, len(values))
+	for label, v := range values {
+		g := gdb.Geometry{Type: 5, Parts: make([][][2]float64, 0, len(rings[label]))}
+		for _, ring := range rings[label] {
+			part := make([][2]float64, len(ring))
+			for i, p := range ring {
+				part[i] = rb.PixelCenter(p[0]-0.5, p[1]-0.5)
+			}
+			g.Parts = append(g.Parts, part)
+		}
+		attrs := map[string]interface{}{PolygonizeAttribute: rb.Unpack(v)}
+		switch value.Type {
+		case 1:
+			attrs[PolygonizeAttribute] = int32(v)
+		case 13:
+			attrs[PolygonizeAttribute] = int64(v)
+		}
+		if row, ok := rows[v]; ok {
+			for j, i := range joined {
+				attrs[fields[j+1].Name] = row[i]
+			}
+		}
+		features = append(features, Feature{ID: label + 1, Geom: g, Attrs: attrs})
+	}
+	return fields, features, nil
+}
+
+// regions labels the pixels of rd, w by h, by the region of equal value
+// they belong to, -1 for nodata, returning the value of each region too.
+// Regions are numbered in the order their first pixel comes, row by row.
+func regions(ctx context.Context, rd *raster.RasterData, w, h int) ([]int32, []float64, error) {
+	labels := make([]int32, w*h)
+	for i := range labels {
+		labels[i] = -1
+	}
+	values := make([]float64, 0)
+	var stack []int
+	for start := range labels {
+		if start%w == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+		}
+		v := rd.GeoData.Float64(start)
+		if labels[start] >= 0 || v == rd.NoData || math.IsNaN(v) {
+			continue
+		}
+		label := int32(len(values))
+		values = append(values, v)
+		labels[start] = label
+		stack = append(stack[:0], start)
+		visit := func(n int) {
+			if labels[n] < 0 && rd.GeoData.Float64(n) == v {
+				labels[n] = label
+				stack = append(stack, n)
+			}
+		}
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%w, i/w
+			if y > 0 {
+				visit(i - w)
+			}
+			if x < w-1 {
+				visit(i + 1)
+			}
+			if y < h-1 {
+				visit(i + w)
+			}
+			if x > 0 {
+				visit(i - 1)
+			}
+		}
+	}
+	return labels, values, nil
+}
+
+// The directions of the edges between pixels, y down, each a right turn
+// from the one before.
+const (
+	edgeRight = iota
+	edgeDown
+	edgeLeft
+	edgeUp
+)
+
+// edgePixel returns the pixel on the right of the edge from the corner x, y
+// of a band w wide in direction dir, the one whose side it is.
+func edgePixel(x, y, w, dir int) int {
+	switch dir {
+	case edgeRight:
+		return y*w + x
+	case edgeDown:
+		return y*w + x - 1
+	case edgeLeft:
+		return (y-1)*w + x - 1
+	}
+	return (y-1)*w + x
+}
+
+// traceRegions returns the rings of each of the n regions of labels, w by
+// h, as the corners of pixels they turn at, from and back to the same
+// corner, y down, clockwise for its outer ring, which comes first, and
+// anticlockwise for its holes. Where a region meets itself only corner to
+// corner its rings turn across the corner, so that the outer ring never
+// touches itself, what it closes in there being a hole that touches it, as
+// a simple feature polygon must have it.
+func traceRegions(ctx context.Context, labels []int32, w, h, n int) ([][][][2]float64, error) {
+	// The sides of the pixels of each region that border another or
+	// nodata, as a bit for each direction from each corner.
+	corners := make([]uint8, (w+1)*(h+1))
+	at := func(i int) int32 {
+		if i < 0 || i >= len(labels) {
+			return -1
+		}
+		return labels[i]
+	}
+	for i, l := range labels {
+		if l < 0 {
+			continue
+		}
+		x, y := i%w, i/w
+		c := y*(w+1) + x
+		if y == 0 || at(i-w) != l {
+			corners[c] |= 1 << edgeRight
+		}
+		if x == w-1 || at(i+1) != l {
+			corners[c+1] |= 1 << edgeDown
+		}
+		if y == h-1 || at(i+w) != l {
+			corners[c+w+2] |= 1 << edgeLeft
+		}
+		if x == 0 || at(i-1) != l {
+			corners[c+w+1] |= 1 << edgeUp
+		}
+	}
+
+	steps := [4][2]int{edgeRight: {1, 0}, edgeDown: {0, 1}, edgeLeft: {-1, 0}, edgeUp: {0, -1}}
+	rings := make([][][][2]float64, n)
+	for c := range corners {
+		if c%(w+1) == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if corners[c]&(1<<edgeRight) == 0 {
+			continue
+		}
+		// The first corner of a ring met row by row is always one it turns
+		// at, being at its top left.
+		x0, y0 := c%(w+1), c/(w+1)
+		label := labels[edgePixel(x0, y0, w, edgeRight)]
+		ring := [][2]float64{{float64(x0), float64(y0)}}
+		x, y, dir := x0, y0, edgeRight
+		var area float64
+		for {
+			nx, ny := x+steps[dir][0], y+steps[dir][1]
+			area += float64(x*ny - nx*y)
+			x, y = nx, ny
+			// Back at the start the ring is closed, unless the way on is
+			// another ring of the region touching this one there.
+			next := nextEdge(corners, labels, x, y, w, dir, label)
+			if x == x0 && y == y0 && next == edgeRight {
+				break
+			}
+			corners[y*(w+1)+x] &^= 1 << next
+			if next != dir {
+				ring = append(ring, [2]float64{float64(x), float64(y)})
+			}
+			dir = next
+		}
+		corners[c] &^= 1 << edgeRight
+		ring = append(ring, ring[0])
+		if area > 0 {
+			rings[label] = append([][][2]float64{ring}, rings[label]...)
+		} else {
+			rings[label] = append(rings[label], ring)
+		}
+	}
+	return rings, nil
+}
+
+// nextEdge returns the direction a ring of region label arriving at corner
+// x, y in direction dir goes on in. Only where the region meets itself
+// corner to corner is there a choice, and the ring turns left, across the
+// corner, rather than right, around the pixel it went along.
+func nextEdge(corners []uint8, labels []int32, x, y, w, dir int, label int32) int {
+	for _, turn := range [3]int{3, 0, 1} {
+		next := (dir + turn) % 4
+		if corners[y*(w+1)+x]&(1<<next) != 0 && labels[edgePixel(x, y, w, next)] == label {
+			return next
+		}
+	}
+	return -1
+}
@@ -0,0 +1,84 @@
+package writer_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// TestPolygonize turns small grids into polygons worked out by hand: a
+// region with a hole holding another, one meeting itself corner to corner
+// round a hole that touches its outer ring there, and nodata left out.
+func TestPolygonize(t *testing.T) {
+	byteBand := &raster.RasterData{
+		GeoData: raster.Buffer[uint8]{7, 7, 0},
+		RasBase: raster.RasterBase{DataType: "uint8", BandWidth: 3, BandHeight: 1, GeoTransform: [6]float64{10, 2, 0, 20, 0, -2}},
+		NoData:  0,
+	}
+	type polygon struct {
+		value any
+		parts [][][2]float64
+	}
+	for _, tc := range []struct {
+		name string
+		rd   *raster.RasterData
+		want []polygon
+	}{
+		{
+			name: "hole",
+			rd: grid(4, 4,
+				1, 1, 1, 1,
+				1, 2, 2, 1,
+				1, 2, 2, 1,
+				1, 1, 1, 1),
+			want: []polygon{
+				{1.0, [][][2]float64{
+					{{0, 4}, {4, 4}, {4, 0}, {0, 0}, {0, 4}},
+					{{1, 1}, {3, 1}, {3, 3}, {1, 3}, {1, 1}},
+				}},
+				{2.0, [][][2]float64{{{1, 3}, {3, 3}, {3, 1}, {1, 1}, {1, 3}}}},
+			},
+		},
+		{
+			name: "corner",
+			rd: grid(3, 3,
+				1, 1, 1,
+				1, 2, 1,
+				1, 1, 2),
+			want: []polygon{
+				{1.0, [][][2]float64{
+					{{0, 3}, {3, 3}, {3, 1}, {2, 1}, {2, 0}, {0, 0}, {0, 3}},
+					{{1, 1}, {2, 1}, {2, 2}, {1, 2}, {1, 1}},
+				}},
+				{2.0, [][][2]float64{{{1, 2}, {2, 2}, {2, 1}, {1, 1}, {1, 2}}}},
+				{2.0, [][][2]float64{{{2, 1}, {3, 1}, {3, 0}, {2, 0}, {2, 1}}}},
+			},
+		},
+		{
+			name: "nodata",
+			rd:   byteBand,
+			want: []polygon{{int32(7), [][][2]float64{{{10, 20}, {14, 20}, {14, 18}, {10, 18}, {10, 20}}}}},
+		},
+	} {
+		fields, features, err := writer.Polygonize(context.Background(), tc.rd, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(fields) != 1 || fields[0].Name != writer.PolygonizeAttribute {
+			t.Errorf("%s: fields %v", tc.name, fields)
+		}
+		if len(features) != len(tc.want) {
+			t.Errorf("%s: %d polygons, want %d", tc.name, len(features), len(tc.want))
+			continue
+		}
+		for i, want := range tc.want {
+			f := features[i]
+			if f.ID != i+1 || f.Geom.Type != 5 || f.Attrs[writer.PolygonizeAttribute] != want.value || !sameParts(f.Geom.Parts, want.parts) {
+				t.Errorf("%s: polygon %d is %d of %#v: %v, want %#v: %v", tc.name, i, f.ID, f.Attrs[writer.PolygonizeAttribute], f.Geom.Parts, want.value, want.parts)
+			}
+		}
+	}
+}
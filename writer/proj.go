@@ -0,0 +1,313 @@
+package writer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A wktNode is a keyword of a coordinate system's WKT, such as PARAMETER,
+// with its values and the keywords nested in it.
+type wktNode struct {
+	name   string
+	values []string // quoted strings unquoted, and numbers, in order
+	nodes  []*wktNode
+}
+
+// child returns the first keyword named name nested in n, or nil.
+func (n *wktNode) child(name string) *wktNode {
+	for _, c := range n.nodes {
+		if strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// number returns value i of n as a number, or def.
+func (n *wktNode) number(i int, def float64) float64 {
+	if n == nil || i >= len(n.values) {
+		return def
+	}
+	v, err := strconv.ParseFloat(n.values[i], 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// parseWKT parses the WKT of a coordinate system, as ESRI and OGC write it,
+// with brackets or parentheses.
+func parseWKT(wkt string) (*wktNode, error) {
+	s := strings.TrimSpace(wkt)
+	var parse func() (*wktNode, error)
+	parse = func() (*wktNode, error) {
+		i := strings.IndexAny(s, "[(")
+		if i < 0 {
+			return nil, fmt.Errorf("WKT keyword %q has no values", s)
+		}
+		n := &wktNode{name: strings.TrimSpace(s[:i])}
+		s = s[i+1:]
+		for {
+			s = strings.TrimLeft(s, " \t\r\n")
+			switch {
+			case s == "":
+				return nil, fmt.Errorf("WKT ends inside %s", n.name)
+			case s[0] == ']' || s[0] == ')':
+				s = s[1:]
+				return n, nil
+			case s[0] == ',':
+				s = s[1:]
+			case s[0] == '"':
+				end := strings.IndexByte(s[1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("WKT ends inside a string of %s", n.name)
+				}
+				n.values = append(n.values, s[1:end+1])
+				s = s[end+2:]
+			default:
+				end := strings.IndexAny(s, ",[]()")
+				if end < 0 {
+					return nil, fmt.Errorf("WKT ends inside %s", n.name)
+				}
+				if s[end] == '[' || s[end] == '(' {
+					c, err := parse()
+					if err != nil {
+						return nil, err
+					}
+					n.nodes = append(n.nodes, c)
+					continue
+				}
+				n.values = append(n.values, strings.TrimSpace(s[:end]))
+				s = s[end:]
+			}
+		}
+	}
+	return parse()
+}
+
+// An inverse projection turns the coordinates of a coordinate system into
+// longitude and latitude, in degrees.
+type inverse func(x, y float64) (lon, lat float64)
+
+// toLonLat returns the inverse projection of the coordinate system of wkt:
+// geographic, or projected with Albers, Transverse Mercator, Lambert
+// Conformal Conic or Mercator, the projections of most rasters in
+// geodatabases. Datums are taken to be WGS 84, as near NAD83 and ETRS89 are
+// for a footprint. Other projections are an error.
+func toLonLat(wkt string) (inverse, error) {
+	root, err := parseWKT(wkt)
+	if err != nil {
+		return nil, err
+	}
+	geog := root
+	if strings.EqualFold(root.name, "PROJCS") {
+		geog = root.child("GEOGCS")
+	}
+	if geog == nil || !strings.EqualFold(geog.name, "GEOGCS") {
+		return nil, fmt.Errorf("no geographic coordinate system in %s", root.name)
+	}
+	var a, invF float64 = 6378137, 298.257223563
+	if d := geog.child("DATUM"); d != nil {
+		if sp := d.child("SPHEROID"); sp != nil {
+			a, invF = sp.number(1, a), sp.number(2, invF)
+		}
+	}
+	primeMeridian := geog.child("PRIMEM").number(1, 0)
+	angular := geog.child("UNIT").number(1, math.Pi/180)
+
+	if root == geog {
+		return func(x, y float64) (float64, float64) {
+			return x*angular*180/math.Pi + primeMeridian, y * angular * 180 / math.Pi
+		}, nil
+	}
+
+	e2 := 0.0
+	if invF != 0 {
+		f := 1 / invF
+		e2 = 2*f - f*f
+	}
+	params := make(map[string]float64)
+	for _, c := range root.nodes {
+		if strings.EqualFold(c.name, "PARAMETER") && len(c.values) == 2 {
+			params[strings.ToLower(c.values[0])] = c.number(1, 0)
+		}
+	}
+	param := func(def float64, names ...string) float64 {
+		for _, name := range names {
+			if v, ok := params[name]; ok {
+				return v
+			}
+		}
+		return def
+	}
+	// Angles are in the unit of the geographic system, distances in that of
+	// the projected one.
+	angle := func(v float64) float64 { return v * angular }
+	linear := root.child("UNIT").number(1, 1)
+	fe, fn := param(0, "false_easting")*linear, param(0, "false_northing")*linear
+	lon0 := angle(param(0, "central_meridian", "longitude_of_center", "longitude_of_origin"))
+	lat0 := angle(param(0, "latitude_of_origin", "latitude_of_center"))
+	k0 := param(1, "scale_factor")
+	lat1 := angle(param(param(0, "latitude_of_origin", "latitude_of_center"), "standard_parallel_1"))
+	lat2 := angle(param(param(0, "latitude_of_origin", "latitude_of_center", "standard_parallel_1"), "standard_parallel_2"))
+
+	var inv func(x, y float64) (float64, float64) // in radians, of metres from the false origin
+	projection := root.child("PROJECTION")
+	if projection == nil || len(projection.values) == 0 {
+		return nil, fmt.Errorf("no projection in PROJCS")
+	}
+	switch name := strings.ToLower(projection.values[0]); {
+	case strings.HasPrefix(name, "albers"):
+		inv = albersInverse(a, e2, lat0, lat1, lat2)
+	case name == "transverse_mercator" || name == "gauss_kruger":
+		inv = transverseMercatorInverse(a, e2, lat0, k0)
+	case strings.HasPrefix(name, "lambert_conformal_conic"):
+		inv = lambertInverse(a, e2, lat0, lat1, lat2, k0)
+	case strings.Contains(name, "auxiliary_sphere") || strings.Contains(name, "pseudo_mercator"):
+		inv = mercatorInverse(a, 0, k0)
+	case strings.HasPrefix(name, "mercator"):
+		if _, ok := params["standard_parallel_1"]; ok {
+			k0 = math.Cos(lat1) / math.Sqrt(1-e2*math.Pow(math.Sin(lat1), 2))
+		}
+		inv = mercatorInverse(a, e2, k0)
+	default:
+		return nil, fmt.Errorf("projection %s is not supported", projection.values[0])
+	}
+	return func(x, y float64) (float64, float64) {
+		lon, lat := inv(x*linear-fe, y*linear-fn)
+		lon = math.Remainder(lon+lon0, 2*math.Pi)
+		return lon*180/math.Pi + primeMeridian, lat * 180 / math.Pi
+	}, nil
+}
+
+// Inverses of the projections, after Snyder, Map Projections: A Working
+// Manual (1987), taking metres from the false origin to the latitude and
+// the longitude from the central meridian, in radians.
+
+// authalicQ is q of Snyder's (3-12).
+func authalicQ(e2, phi float64) float64 {
+	if e2 == 0 {
+		return 2 * math.Sin(phi)
+	}
+	e, s := math.Sqrt(e2), math.Sin(phi)
+	return (1 - e2) * (s/(1-e2*s*s) - math.Log((1-e*s)/(1+e*s))/(2*e))
+}
+
+// conformalM is m of Snyder's (14-15).
+func conformalM(e2, phi float64) float64 {
+	s := math.Sin(phi)
+	return math.Cos(phi) / math.Sqrt(1-e2*s*s)
+}
+
+// conformalT is t of Snyder's (15-9).
+func conformalT(e2, phi float64) float64 {
+	e, s := math.Sqrt(e2), math.Sin(phi)
+	return math.Tan(math.Pi/4-phi/2) / math.Pow((1-e*s)/(1+e*s), e/2)
+}
+
+// latitudeFromT solves (7-9) for the latitude of t.
+func latitudeFromT(e2, t float64) float64 {
+	e := math.Sqrt(e2)
+	phi := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		s := math.Sin(phi)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-e*s)/(1+e*s), e/2))
+		if math.Abs(next-phi) < 1e-12 {
+			return next
+		}
+		phi = next
+	}
+	return phi
+}
+
+func albersInverse(a, e2, lat0, lat1, lat2 float64) func(x, y float64) (float64, float64) {
+	m1, m2 := conformalM(e2, lat1), conformalM(e2, lat2)
+	q0, q1, q2 := authalicQ(e2, lat0), authalicQ(e2, lat1), authalicQ(e2, lat2)
+	n := math.Sin(lat1)
+	if math.Abs(lat1-lat2) > 1e-10 {
+		n = (m1*m1 - m2*m2) / (q2 - q1)
+	}
+	c := m1*m1 + n*q1
+	rho0 := a * math.Sqrt(c-n*q0) / n
+	return func(x, y float64) (float64, float64) {
+		rho := math.Copysign(math.Hypot(x, rho0-y), n)
+		theta := math.Atan2(x, rho0-y)
+		if n < 0 {
+			theta = math.Atan2(-x, y-rho0)
+		}
+		q := (c - rho*rho*n*n/(a*a)) / n
+		phi := math.Asin(math.Max(-1, math.Min(1, q/2)))
+		if e2 > 0 {
+			e := math.Sqrt(e2)
+			for i := 0; i < 15; i++ {
+				s := math.Sin(phi)
+				d := math.Pow(1-e2*s*s, 2) / (2 * math.Cos(phi)) *
+					(q/(1-e2) - s/(1-e2*s*s) + math.Log((1-e*s)/(1+e*s))/(2*e))
+				phi += d
+				if math.Abs(d) < 1e-12 {
+					break
+				}
+			}
+		}
+		return theta / n, phi
+	}
+}
+
+func transverseMercatorInverse(a, e2, lat0, k0 float64) func(x, y float64) (float64, float64) {
+	e4, e6 := e2*e2, e2*e2*e2
+	meridian := func(phi float64) float64 { // M of (3-21)
+		return a * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+			(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+			(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+			35*e6/3072*math.Sin(6*phi))
+	}
+	ep2 := e2 / (1 - e2)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	m0 := meridian(lat0)
+	return func(x, y float64) (float64, float64) {
+		mu := (m0 + y/k0) / (a * (1 - e2/4 - 3*e4/64 - 5*e6/256))
+		phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+			(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+			151*math.Pow(e1, 3)/96*math.Sin(6*mu) +
+			1097*math.Pow(e1, 4)/512*math.Sin(8*mu)
+		s, c, t := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+		c1, t1 := ep2*c*c, t*t
+		n1 := a / math.Sqrt(1-e2*s*s)
+		r1 := a * (1 - e2) / math.Pow(1-e2*s*s, 1.5)
+		d := x / (n1 * k0)
+		phi := phi1 - n1*t/r1*(d*d/2-
+			(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+			(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+		lon := (d - (1+2*t1+c1)*math.Pow(d, 3)/6 +
+			(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / c
+		return lon, phi
+	}
+}
+
+func lambertInverse(a, e2, lat0, lat1, lat2, k0 float64) func(x, y float64) (float64, float64) {
+	m1, m2 := conformalM(e2, lat1), conformalM(e2, lat2)
+	t0, t1, t2 := conformalT(e2, lat0), conformalT(e2, lat1), conformalT(e2, lat2)
+	n := math.Sin(lat1)
+	if math.Abs(lat1-lat2) > 1e-10 {
+		n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	}
+	f := m1 / (n * math.Pow(t1, n))
+	rho0 := a * f * k0 * math.Pow(t0, n)
+	return func(x, y float64) (float64, float64) {
+		rho := math.Copysign(math.Hypot(x, rho0-y), n)
+		theta := math.Atan2(x, rho0-y)
+		if n < 0 {
+			theta = math.Atan2(-x, y-rho0)
+		}
+		t := math.Pow(rho/(a*f*k0), 1/n)
+		return theta / n, latitudeFromT(e2, t)
+	}
+}
+
+func mercatorInverse(a, e2, k0 float64) func(x, y float64) (float64, float64) {
+	return func(x, y float64) (float64, float64) {
+		return x / (a * k0), latitudeFromT(e2, math.Exp(-y/(a*k0)))
+	}
+}
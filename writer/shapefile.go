@@ -0,0 +1,291 @@
+package writer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// shapeType maps a layer geometry type onto the shapefile shape type.
+func shapeType(layerGeomType uint8) int32 {
+	switch layerGeomType {
+	case 1:
+		return 1 // Point
+	case 2:
+		return 8 // MultiPoint
+	case 3:
+		return 3 // PolyLine
+	case 4:
+		return 5 // Polygon
+	default:
+		return 0 // Null
+	}
+}
+
+// shapeRecord encodes the content of one .shp record. Esri rings are already
+// in shapefile orientation, so parts are written as decoded.
+func shapeRecord(st int32, g gdb.Geometry) []byte {
+	b := make([]byte, 0, 64)
+	if len(g.Parts) == 0 {
+		return binary.LittleEndian.AppendUint32(b, 0)
+	}
+
+	b = binary.LittleEndian.AppendUint32(b, uint32(st))
+	if st == 1 {
+		b = appendFloat64(b, g.Parts[0][0][0])
+		return appendFloat64(b, g.Parts[0][0][1])
+	}
+
+	minX, minY, maxX, maxY := g.Bounds()
+	for _, v := range []float64{minX, minY, maxX, maxY} {
+		b = appendFloat64(b, v)
+	}
+
+	nPoints := 0
+	for _, part := range g.Parts {
+		nPoints += len(part)
+	}
+	if st != 8 {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(g.Parts)))
+	}
+	b = binary.LittleEndian.AppendUint32(b, uint32(nPoints))
+	if st != 8 {
+		start := 0
+		for _, part := range g.Parts {
+			b = binary.LittleEndian.AppendUint32(b, uint32(start))
+			start += len(part)
+		}
+	}
+	for _, part := range g.Parts {
+		for _, pt := range part {
+			b = appendFloat64(b, pt[0])
+			b = appendFloat64(b, pt[1])
+		}
+	}
+	return b
+}
+
+func appendFloat64(b []byte, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+// shpHeader builds the 100 byte header shared by .shp and .shx.
+func shpHeader(fileBytes int, st int32, bounds [4]float64) []byte {
+	h := make([]byte, 100)
+	binary.BigEndian.PutUint32(h[0:], 9994)
+	binary.BigEndian.PutUint32(h[24:], uint32(fileBytes/2))
+	binary.LittleEndian.PutUint32(h[28:], 1000)
+	binary.LittleEndian.PutUint32(h[32:], uint32(st))
+	for i, v := range bounds {
+		binary.LittleEndian.PutUint64(h[36+8*i:], math.Float64bits(v))
+	}
+	return h
+}
+
+// dbfField describes one .dbf column.
+type dbfField struct {
+	Source   string
+	Name     string
+	Type     byte
+	Length   int
+	Decimals int
+}
+
+// dbfFields maps the attribute fields onto dBase columns, truncating names to
+// the ten characters dBase allows and keeping them unique.
+func dbfFields(fields []gdb.Field) []dbfField {
+	cols := make([]dbfField, 0, len(fields))
+	used := make(map[string]bool)
+	for _, fld := range fields {
+		col := dbfField{Source: fld.Name, Type: 'N'}
+		switch fld.Type {
+		case 0:
+			col.Length = 6
+		case 1:
+			col.Length = 11
+		case 13:
+			col.Length = 20
+		case 2:
+			col.Length, col.Decimals = 19, 7
+		case 3:
+			col.Length, col.Decimals = 24, 15
+		case 4:
+			col.Type = 'C'
+			col.Length = int(min(max(fld.Width, 1), 254))
+		default:
+			continue
+		}
+
+		name := fld.Name
+		if len(name) > 10 {
+			name = name[:10]
+		}
+		for i := 1; used[strings.ToUpper(name)]; i++ {
+			suffix := strconv.Itoa(i)
+			name = fld.Name[:min(len(fld.Name), 10-len(suffix))] + suffix
+		}
+		used[strings.ToUpper(name)] = true
+		col.Name = name
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+// dbfValue formats v into exactly col.Length bytes.
+func dbfValue(col dbfField, v interface{}) []byte {
+	var s string
+	switch t := v.(type) {
+	case nil:
+		return []byte(strings.Repeat(" ", col.Length))
+	case string:
+		s = t
+		for len(s) > col.Length {
+			_, size := utf8.DecodeLastRuneInString(s)
+			s = s[:len(s)-size]
+		}
+		return []byte(s + strings.Repeat(" ", col.Length-len(s)))
+	case int16, int32, int64:
+		s = fmt.Sprintf("%d", t)
+	case float32:
+		s = fitFloat(float64(t), col, 32)
+	case float64:
+		s = fitFloat(t, col, 64)
+	}
+	if len(s) > col.Length {
+		s = strings.Repeat("*", col.Length)
+	}
+	return []byte(strings.Repeat(" ", col.Length-len(s)) + s)
+}
+
+// fitFloat gives up decimals until v fits in the column.
+func fitFloat(v float64, col dbfField, bitSize int) string {
+	s := strconv.FormatFloat(v, 'f', col.Decimals, bitSize)
+	for d := col.Decimals - 1; len(s) > col.Length && d >= 0; d-- {
+		s = strconv.FormatFloat(v, 'f', d, bitSize)
+	}
+	return s
+}
+
+// writeDBF writes the attribute table of a shapefile.
+func writeDBF(path string, cols []dbfField, features []Feature) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	recordLen := 1
+	for _, col := range cols {
+		recordLen += col.Length
+	}
+	headerLen := 32 + 32*len(cols) + 1
+
+	now := Now()
+	h := make([]byte, 32)
+	h[0] = 0x03
+	h[1], h[2], h[3] = byte(now.Year()-1900), byte(now.Month()), byte(now.Day())
+	binary.LittleEndian.PutUint32(h[4:], uint32(len(features)))
+	binary.LittleEndian.PutUint16(h[8:], uint16(headerLen))
+	binary.LittleEndian.PutUint16(h[10:], uint16(recordLen))
+	w.Write(h)
+
+	for _, col := range cols {
+		d := make([]byte, 32)
+		copy(d, col.Name)
+		d[11] = col.Type
+		d[16] = byte(col.Length)
+		d[17] = byte(col.Decimals)
+		w.Write(d)
+	}
+	w.WriteByte(0x0D)
+
+	for _, feat := range features {
+		w.WriteByte(' ')
+		for _, col := range cols {
+			w.Write(dbfValue(col, feat.Attrs[col.Source]))
+		}
+	}
+	w.WriteByte(0x1A)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// WriteShapefile writes features as base.shp/.shx/.dbf/.prj/.cpg.
+func WriteShapefile(base string, layerGeomType uint8, fields []gdb.Field, features []Feature, wkt string) error {
+	st := shapeType(layerGeomType)
+
+	records := make([][]byte, len(features))
+	bounds := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	fileBytes := 100
+	for i, feat := range features {
+		records[i] = shapeRecord(st, feat.Geom)
+		fileBytes += 8 + len(records[i])
+		if len(feat.Geom.Parts) > 0 {
+			minX, minY, maxX, maxY := feat.Geom.Bounds()
+			bounds = [4]float64{math.Min(bounds[0], minX), math.Min(bounds[1], minY), math.Max(bounds[2], maxX), math.Max(bounds[3], maxY)}
+		}
+	}
+	if math.IsInf(bounds[0], 0) {
+		bounds = [4]float64{}
+	}
+
+	shp, err := os.Create(base + ".shp")
+	if err != nil {
+		return err
+	}
+	defer shp.Close()
+	shx, err := os.Create(base + ".shx")
+	if err != nil {
+		return err
+	}
+	defer shx.Close()
+	sw := bufio.NewWriter(shp)
+	xw := bufio.NewWriter(shx)
+
+	sw.Write(shpHeader(fileBytes, st, bounds))
+	xw.Write(shpHeader(100+8*len(records), st, bounds))
+	offset := 100
+	for i, rec := range records {
+		rh := make([]byte, 8)
+		binary.BigEndian.PutUint32(rh[0:], uint32(i+1))
+		binary.BigEndian.PutUint32(rh[4:], uint32(len(rec)/2))
+		sw.Write(rh)
+		sw.Write(rec)
+
+		binary.BigEndian.PutUint32(rh[0:], uint32(offset/2))
+		xw.Write(rh)
+		offset += 8 + len(rec)
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	if err := xw.Flush(); err != nil {
+		return err
+	}
+	if err := shp.Close(); err != nil {
+		return err
+	}
+	if err := shx.Close(); err != nil {
+		return err
+	}
+
+	if err := writeDBF(base+".dbf", dbfFields(fields), features); err != nil {
+		return err
+	}
+	if wkt != "" {
+		if err := os.WriteFile(base+".prj", []byte(wkt), 0644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(base+".cpg", []byte("UTF-8"), 0644)
+}
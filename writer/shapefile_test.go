@@ -0,0 +1,155 @@
+package writer_test
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+	"github.com/albrazeau/goRasterRescue/writer"
+)
+
+// TestWriteShapefile writes a polygon layer of a shape with a hole, one
+// without a shape and another beyond it, and reads back the headers and
+// records of the .shp, .shx and .dbf, the column names cut to ten
+// characters and kept apart.
+func TestWriteShapefile(t *testing.T) {
+	defer func(now func() time.Time) { writer.Now = now }(writer.Now)
+	writer.Now = func() time.Time { return time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC) }
+
+	holed := gdb.Geometry{Type: 5, Parts: [][][2]float64{
+		{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}},
+		{{2, 2}, {4, 2}, {4, 4}, {2, 4}, {2, 2}},
+	}}
+	beyond := gdb.Geometry{Type: 5, Parts: [][][2]float64{{{20, -5}, {20, 1}, {30, 1}, {20, -5}}}}
+	fields := []gdb.Field{
+		{Name: "short", Type: 0},
+		{Name: "area_acres", Type: 3},
+		{Name: "muname", Type: 4, Width: 5},
+		{Name: "shape", Type: 7},
+		{Name: "area_acres_2", Type: 3},
+	}
+	features := []writer.Feature{
+		{ID: 1, Geom: holed, Attrs: map[string]interface{}{"short": int16(-12), "area_acres": 1.5, "muname": "Mapunit", "area_acres_2": nil}},
+		{ID: 2, Attrs: map[string]interface{}{"short": int16(3), "muname": "ab"}},
+		{ID: 3, Geom: beyond, Attrs: map[string]interface{}{"area_acres_2": 1e30}},
+	}
+	base := filepath.Join(t.TempDir(), "soils")
+	if err := writer.WriteShapefile(base, 4, fields, features, "PROJCS[]"); err != nil {
+		t.Fatal(err)
+	}
+	read := func(ext string) []byte {
+		b, err := os.ReadFile(base + ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	be, le := binary.BigEndian, binary.LittleEndian
+	f64 := func(b []byte) float64 { return math.Float64frombits(le.Uint64(b)) }
+
+	// The records of the .shp, as the .shx places them.
+	shp, shx := read(".shp"), read(".shx")
+	for name, b := range map[string][]byte{".shp": shp, ".shx": shx} {
+		bounds := []float64{f64(b[36:]), f64(b[44:]), f64(b[52:]), f64(b[60:])}
+		if be.Uint32(b) != 9994 || int(be.Uint32(b[24:]))*2 != len(b) || le.Uint32(b[28:]) != 1000 || le.Uint32(b[32:]) != 5 ||
+			!slices.Equal(bounds, []float64{0, -5, 30, 10}) {
+			t.Errorf("%s header % x", name, b[:68])
+		}
+	}
+	if len(shx) != 100+8*len(features) {
+		t.Fatalf(".shx of %d bytes", len(shx))
+	}
+	var records [][]byte
+	for i := range features {
+		off, words := int(be.Uint32(shx[100+8*i:]))*2, int(be.Uint32(shx[104+8*i:]))
+		if int(be.Uint32(shp[off:])) != i+1 || int(be.Uint32(shp[off+4:])) != words {
+			t.Fatalf("record %d at %d is % x, want number %d of %d words", i+1, off, shp[off:off+8], i+1, words)
+		}
+		records = append(records, shp[off+8:off+8+2*words])
+	}
+	if end := int(be.Uint32(shx[100+8*2:]))*2 + 8 + len(records[2]); end != len(shp) {
+		t.Errorf("last record ends at %d of %d bytes", end, len(shp))
+	}
+	for i, g := range []gdb.Geometry{holed, {}, beyond} {
+		rec := records[i]
+		if len(g.Parts) == 0 {
+			if len(rec) != 4 || le.Uint32(rec) != 0 {
+				t.Errorf("record %d is % x, want a null shape", i+1, rec)
+			}
+			continue
+		}
+		minX, minY, maxX, maxY := g.Bounds()
+		parts, points := int(le.Uint32(rec[36:])), int(le.Uint32(rec[40:]))
+		if le.Uint32(rec) != 5 || !slices.Equal([]float64{f64(rec[4:]), f64(rec[12:]), f64(rec[20:]), f64(rec[28:])}, []float64{minX, minY, maxX, maxY}) ||
+			parts != len(g.Parts) || len(rec) != 44+4*parts+16*points {
+			t.Errorf("record %d is % x", i+1, rec)
+			continue
+		}
+		var got [][][2]float64
+		xy := rec[44+4*parts:]
+		for p := range parts {
+			end := points
+			if p+1 < parts {
+				end = int(le.Uint32(rec[48+4*p:]))
+			}
+			var part [][2]float64
+			for j := int(le.Uint32(rec[44+4*p:])); j < end; j++ {
+				part = append(part, [2]float64{f64(xy[16*j:]), f64(xy[16*j+8:])})
+			}
+			got = append(got, part)
+		}
+		if !sameParts(got, g.Parts) {
+			t.Errorf("record %d holds %v, want %v", i+1, got, g.Parts)
+		}
+	}
+
+	dbf := read(".dbf")
+	cols := []struct {
+		name     string
+		typ      byte
+		length   int
+		decimals int
+	}{
+		{"short", 'N', 6, 0},
+		{"area_acres", 'N', 24, 15},
+		{"muname", 'C', 5, 0},
+		{"area_acre1", 'N', 24, 15},
+	}
+	headerLen, recordLen := 32+32*len(cols)+1, 1+6+24+5+24
+	if dbf[0] != 0x03 || dbf[1] != 124 || dbf[2] != 3 || dbf[3] != 5 || le.Uint32(dbf[4:]) != 3 ||
+		int(le.Uint16(dbf[8:])) != headerLen || int(le.Uint16(dbf[10:])) != recordLen {
+		t.Fatalf(".dbf header % x", dbf[:32])
+	}
+	for i, col := range cols {
+		d := dbf[32+32*i : 64+32*i]
+		name := string(d[:11])
+		if want := col.name + string(make([]byte, 11-len(col.name))); name != want || d[11] != col.typ || int(d[16]) != col.length || int(d[17]) != col.decimals {
+			t.Errorf("column %d is % x, want %s", i, d, col.name)
+		}
+	}
+	if dbf[headerLen-1] != 0x0D || len(dbf) != headerLen+3*recordLen+1 || dbf[len(dbf)-1] != 0x1A {
+		t.Fatalf(".dbf of %d bytes, want %d", len(dbf), headerLen+3*recordLen+1)
+	}
+	// Each record starts with a blank, for not deleted.
+	for i, want := range []string{
+		" " + "   -12" + "       1.500000000000000" + "Mapun" + "                        ",
+		" " + "     3" + "                        " + "ab   " + "                        ",
+		" " + "      " + "                        " + "     " + "************************",
+	} {
+		if got := string(dbf[headerLen+i*recordLen : headerLen+(i+1)*recordLen]); got != want {
+			t.Errorf("record %d is %q, want %q", i+1, got, want)
+		}
+	}
+
+	if prj := read(".prj"); string(prj) != "PROJCS[]" {
+		t.Errorf(".prj holds %q", prj)
+	}
+	if cpg := read(".cpg"); string(cpg) != "UTF-8" {
+		t.Errorf(".cpg holds %q", cpg)
+	}
+}
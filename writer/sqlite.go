@@ -0,0 +1,538 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// A minimal writer for SQLite 3 database files, enough to produce GeoPackages
+// without cgo. The whole database is laid out in memory and written in one go:
+// tables are rowid b-trees, indexes are index b-trees, large payloads spill to
+// overflow pages, and there is never a free page. See
+// https://www.sqlite.org/fileformat2.html.
+
+const sqlitePageSize = 4096
+
+// SQLiteTable is a table to write together with its rows, which must have
+// distinct rowids.
+type SQLiteTable struct {
+	Name    string
+	SQL     string
+	Rows    []sqliteRow
+	Indexes []sqliteIndex
+}
+
+type sqliteRow struct {
+	RowID  int64
+	Values []interface{}
+}
+
+// sqliteIndex is an index over some columns of its table. Indexes SQLite
+// creates itself for PRIMARY KEY and UNIQUE constraints have no SQL and must
+// be listed in the order of the constraints, named sqlite_autoindex_<table>_<n>.
+type sqliteIndex struct {
+	Name    string
+	SQL     string
+	Columns []int
+}
+
+type sqliteWriter struct {
+	pages [][]byte
+}
+
+// newPage allocates a page and returns its 1-based number.
+func (w *sqliteWriter) newPage() int {
+	w.pages = append(w.pages, make([]byte, sqlitePageSize))
+	return len(w.pages)
+}
+
+func appendSQLiteVarint(b []byte, v uint64) []byte {
+	if v > 0x00FFFFFFFFFFFFFF {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7F) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	n := len(buf)
+	for {
+		n--
+		buf[n] = byte(v & 0x7F)
+		if n != len(buf)-1 {
+			buf[n] |= 0x80
+		}
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	return append(b, buf[n:]...)
+}
+
+func sqliteInt(v interface{}) (int64, bool) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), true
+	case int8:
+		return int64(t), true
+	case int16:
+		return int64(t), true
+	case int32:
+		return int64(t), true
+	case int64:
+		return t, true
+	case uint8:
+		return int64(t), true
+	case uint16:
+		return int64(t), true
+	case uint32:
+		return int64(t), true
+	case bool:
+		if t {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sqliteRecord encodes values in the record format. It fails for values of
+// a type SQLite has no storage class for.
+func sqliteRecord(values []interface{}) ([]byte, error) {
+	header := make([]byte, 0, len(values)+1)
+	body := make([]byte, 0, 8*len(values))
+	for _, v := range values {
+		if i, ok := sqliteInt(v); ok {
+			switch {
+			case i == 0:
+				header = append(header, 8)
+			case i == 1:
+				header = append(header, 9)
+			case i >= math.MinInt8 && i <= math.MaxInt8:
+				header = append(header, 1)
+				body = append(body, byte(i))
+			case i >= math.MinInt16 && i <= math.MaxInt16:
+				header = append(header, 2)
+				body = binary.BigEndian.AppendUint16(body, uint16(i))
+			case i >= -1<<23 && i < 1<<23:
+				header = append(header, 3)
+				body = append(body, byte(i>>16), byte(i>>8), byte(i))
+			case i >= math.MinInt32 && i <= math.MaxInt32:
+				header = append(header, 4)
+				body = binary.BigEndian.AppendUint32(body, uint32(i))
+			case i >= -1<<47 && i < 1<<47:
+				header = append(header, 5)
+				body = append(body, byte(i>>40), byte(i>>32), byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+			default:
+				header = append(header, 6)
+				body = binary.BigEndian.AppendUint64(body, uint64(i))
+			}
+			continue
+		}
+
+		switch t := v.(type) {
+		case nil:
+			header = append(header, 0)
+		case float32:
+			header = append(header, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(float64(t)))
+		case float64:
+			header = append(header, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(t))
+		case string:
+			header = appendSQLiteVarint(header, uint64(2*len(t)+13))
+			body = append(body, t...)
+		case []byte:
+			header = appendSQLiteVarint(header, uint64(2*len(t)+12))
+			body = append(body, t...)
+		default:
+			return nil, fmt.Errorf("sqlite: unsupported value type %T", v)
+		}
+	}
+
+	// The header size counts itself.
+	size := len(header) + 1
+	if len(appendSQLiteVarint(nil, uint64(size))) > 1 {
+		size = len(header) + len(appendSQLiteVarint(nil, uint64(size+1)))
+	}
+	rec := appendSQLiteVarint(make([]byte, 0, size+len(body)), uint64(size))
+	rec = append(rec, header...)
+	return append(rec, body...), nil
+}
+
+// sqliteCompare orders two values the way the BINARY collation does: NULLs,
+// then numbers, then text, then blobs.
+func sqliteCompare(a interface{}, b interface{}) int {
+	class := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case string:
+			return 2
+		case []byte:
+			return 3
+		}
+		return 1
+	}
+	ca, cb := class(a), class(b)
+	if ca != cb {
+		return ca - cb
+	}
+	switch ca {
+	case 1:
+		fa, fb := sqliteNumber(a), sqliteNumber(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case 2:
+		return bytes.Compare([]byte(a.(string)), []byte(b.(string)))
+	case 3:
+		return bytes.Compare(a.([]byte), b.([]byte))
+	}
+	return 0
+}
+
+func sqliteNumber(v interface{}) float64 {
+	if i, ok := sqliteInt(v); ok {
+		return float64(i)
+	}
+	switch t := v.(type) {
+	case float32:
+		return float64(t)
+	case float64:
+		return t
+	}
+	return 0
+}
+
+// cellPayload splits payload into the part kept on the b-tree page and the
+// rest, which goes to a chain of overflow pages. The returned bytes are the
+// local part followed by the first overflow page number, if any.
+func (w *sqliteWriter) cellPayload(payload []byte, index bool) []byte {
+	u := sqlitePageSize
+	x := u - 35
+	if index {
+		x = (u-12)*64/255 - 23
+	}
+	if len(payload) <= x {
+		return payload
+	}
+
+	m := (u-12)*32/255 - 23
+	k := m + (len(payload)-m)%(u-4)
+	local := m
+	if k <= x {
+		local = k
+	}
+
+	cell := append([]byte{}, payload[:local]...)
+	rest := payload[local:]
+	first := 0
+	var prev []byte
+	for len(rest) > 0 {
+		pg := w.newPage()
+		page := w.pages[pg-1]
+		if prev == nil {
+			first = pg
+		} else {
+			binary.BigEndian.PutUint32(prev, uint32(pg))
+		}
+		n := copy(page[4:], rest)
+		rest = rest[n:]
+		prev = page[:4]
+	}
+	return binary.BigEndian.AppendUint32(cell, uint32(first))
+}
+
+// nodeFits reports whether cells fit on one page of the given kind.
+func nodeFits(cells [][]byte, interior bool, pgno int) bool {
+	size := 8
+	if interior {
+		size = 12
+	}
+	if pgno == 1 {
+		size += 100
+	}
+	for _, c := range cells {
+		size += len(c) + 2
+	}
+	return size <= sqlitePageSize
+}
+
+// writeNode lays out one b-tree page. Page 1 starts after the file header.
+func (w *sqliteWriter) writeNode(pgno int, kind byte, cells [][]byte, rightChild int) {
+	page := w.pages[pgno-1]
+	off := 0
+	if pgno == 1 {
+		off = 100
+	}
+	hdrLen := 8
+	if kind == 0x02 || kind == 0x05 {
+		hdrLen = 12
+		binary.BigEndian.PutUint32(page[off+8:], uint32(rightChild))
+	}
+
+	content := len(page)
+	for i, c := range cells {
+		content -= len(c)
+		copy(page[content:], c)
+		binary.BigEndian.PutUint16(page[off+hdrLen+2*i:], uint16(content))
+	}
+	page[off] = kind
+	binary.BigEndian.PutUint16(page[off+3:], uint16(len(cells)))
+	binary.BigEndian.PutUint16(page[off+5:], uint16(content%65536))
+}
+
+// buildTableTree writes the rows of a table b-tree, sorted by rowid, and
+// returns its root page. A root of 1 is used as is; 0 allocates one.
+func (w *sqliteWriter) buildTableTree(rows []sqliteRow, root int) (int, error) {
+	sort.Slice(rows, func(i, j int) bool { return rows[i].RowID < rows[j].RowID })
+
+	cells := make([][]byte, len(rows))
+	for i, row := range rows {
+		payload, err := sqliteRecord(row.Values)
+		if err != nil {
+			return 0, err
+		}
+		c := appendSQLiteVarint(nil, uint64(len(payload)))
+		c = appendSQLiteVarint(c, uint64(row.RowID))
+		cells[i] = append(c, w.cellPayload(payload, false)...)
+	}
+
+	type child struct {
+		page   int
+		maxKey int64
+	}
+
+	if nodeFits(cells, false, root) {
+		if root == 0 {
+			root = w.newPage()
+		}
+		w.writeNode(root, 0x0D, cells, 0)
+		return root, nil
+	}
+
+	// Leaves, filled greedily.
+	children := make([]child, 0)
+	start := 0
+	for start < len(cells) {
+		end := start + 1
+		for end < len(cells) && nodeFits(cells[start:end+1], false, 0) {
+			end++
+		}
+		pg := w.newPage()
+		w.writeNode(pg, 0x0D, cells[start:end], 0)
+		children = append(children, child{pg, rows[end-1].RowID})
+		start = end
+	}
+
+	// Interior levels until one node holds them all.
+	for {
+		cells := make([][]byte, len(children))
+		for i, c := range children {
+			cells[i] = appendSQLiteVarint(binary.BigEndian.AppendUint32(nil, uint32(c.page)), uint64(c.maxKey))
+		}
+		last := len(children) - 1
+		if nodeFits(cells[:last], true, root) {
+			if root == 0 {
+				root = w.newPage()
+			}
+			w.writeNode(root, 0x05, cells[:last], children[last].page)
+			return root, nil
+		}
+
+		// Interior cells are at most 13 bytes; spread the children evenly so
+		// that no node is left with a single child.
+		perNode := (sqlitePageSize-12)/(13+2) + 1
+		nNodes := (len(children) + perNode - 1) / perNode
+		parents := make([]child, 0, nNodes)
+		for n := 0; n < nNodes; n++ {
+			lo := n * len(children) / nNodes
+			hi := (n + 1) * len(children) / nNodes
+			pg := w.newPage()
+			w.writeNode(pg, 0x05, cells[lo:hi-1], children[hi-1].page)
+			parents = append(parents, child{pg, children[hi-1].maxKey})
+		}
+		children = parents
+	}
+}
+
+// buildIndexTree writes the keys of an index b-tree, already sorted, and
+// returns its root page.
+func (w *sqliteWriter) buildIndexTree(keys [][]byte) int {
+	// Each level is a list of entries; interior levels also have one more
+	// child page than entries, the child left of each entry.
+	entries := make([][]byte, len(keys))
+	for i, k := range keys {
+		entries[i] = append(appendSQLiteVarint(nil, uint64(len(k))), w.cellPayload(k, true)...)
+	}
+	var children []int
+
+	for {
+		interior := children != nil
+		kind := byte(0x0A)
+		if interior {
+			kind = 0x02
+		}
+		cells := make([][]byte, len(entries))
+		for i, e := range entries {
+			if interior {
+				cells[i] = append(binary.BigEndian.AppendUint32(nil, uint32(children[i])), e...)
+			} else {
+				cells[i] = e
+			}
+		}
+		right := 0
+		if interior {
+			right = children[len(children)-1]
+		}
+
+		if nodeFits(cells, interior, 0) {
+			root := w.newPage()
+			w.writeNode(root, kind, cells, right)
+			return root
+		}
+
+		// Fill nodes greedily. The entry after a full node moves up a level
+		// to separate it from the next one.
+		upEntries := make([][]byte, 0)
+		upChildren := make([]int, 0)
+		start := 0
+		for start <= len(cells) {
+			end := start
+			for end < len(cells) && nodeFits(cells[start:end+1], interior, 0) {
+				end++
+			}
+			if end < len(cells) && end == len(cells)-1 {
+				// Leave something for the last node.
+				end--
+			}
+
+			pg := w.newPage()
+			nodeRight := 0
+			if interior {
+				nodeRight = children[end]
+			}
+			w.writeNode(pg, kind, cells[start:end], nodeRight)
+			upChildren = append(upChildren, pg)
+			if end >= len(cells) {
+				break
+			}
+			upEntries = append(upEntries, entries[end])
+			start = end + 1
+		}
+		entries, children = upEntries, upChildren
+	}
+}
+
+// indexKeys builds the sorted keys of idx over the rows of t: the indexed
+// columns followed by the rowid.
+func indexKeys(t *SQLiteTable, idx *sqliteIndex) ([][]byte, error) {
+	type key struct {
+		vals []interface{}
+	}
+	keys := make([]key, len(t.Rows))
+	for i, row := range t.Rows {
+		vals := make([]interface{}, 0, len(idx.Columns)+1)
+		for _, c := range idx.Columns {
+			vals = append(vals, row.Values[c])
+		}
+		keys[i] = key{append(vals, row.RowID)}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		for c := range keys[i].vals {
+			if d := sqliteCompare(keys[i].vals[c], keys[j].vals[c]); d != 0 {
+				return d < 0
+			}
+		}
+		return false
+	})
+
+	out := make([][]byte, len(keys))
+	for i, k := range keys {
+		rec, err := sqliteRecord(k.vals)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = rec
+	}
+	return out, nil
+}
+
+// WriteSQLite writes tables into a new database file at path, tagging it with
+// the given application id and user version. It fails before creating path
+// for values of a type SQLite has no storage class for. Once ctx is done it
+// removes path and returns ctx.Err().
+func WriteSQLite(ctx context.Context, path string, tables []SQLiteTable, applicationID uint32, userVersion uint32) error {
+	w := &sqliteWriter{}
+	w.newPage() // page 1 holds the schema
+
+	schema := make([]sqliteRow, 0)
+	for i := range tables {
+		t := &tables[i]
+		root, err := w.buildTableTree(t.Rows, 0)
+		if err != nil {
+			return fmt.Errorf("table %s: %w", t.Name, err)
+		}
+		schema = append(schema, sqliteRow{int64(len(schema) + 1), []interface{}{"table", t.Name, t.Name, root, t.SQL}})
+		for j := range t.Indexes {
+			idx := &t.Indexes[j]
+			keys, err := indexKeys(t, idx)
+			if err != nil {
+				return fmt.Errorf("index %s: %w", idx.Name, err)
+			}
+			root := w.buildIndexTree(keys)
+			var sql interface{}
+			if idx.SQL != "" {
+				sql = idx.SQL
+			}
+			schema = append(schema, sqliteRow{int64(len(schema) + 1), []interface{}{"index", idx.Name, t.Name, root, sql}})
+		}
+	}
+	if _, err := w.buildTableTree(schema, 1); err != nil {
+		return err
+	}
+
+	h := w.pages[0][:100]
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], sqlitePageSize)
+	h[18], h[19] = 1, 1 // legacy journal mode
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(h[28:], uint32(len(w.pages)))
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[60:], userVersion)
+	binary.BigEndian.PutUint32(h[68:], applicationID)
+	binary.BigEndian.PutUint32(h[92:], 1)       // version-valid-for
+	binary.BigEndian.PutUint32(h[96:], 3040000) // SQLite version the format follows
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, page := range w.pages {
+		if err := ctx.Err(); err != nil {
+			return abandon(f, err)
+		}
+		if _, err := f.Write(page); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
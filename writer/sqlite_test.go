@@ -0,0 +1,321 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sqliteFile reads back what WriteSQLite wrote, as little of the file
+// format as that takes: the header, table and index b-trees, overflow
+// pages and records.
+type sqliteFile struct {
+	t *testing.T
+	b []byte
+}
+
+func (f sqliteFile) page(n int) []byte {
+	if n < 1 || n*sqlitePageSize > len(f.b) {
+		f.t.Fatalf("page %d of %d", n, len(f.b)/sqlitePageSize)
+	}
+	return f.b[(n-1)*sqlitePageSize : n*sqlitePageSize]
+}
+
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		v = v<<7 | uint64(b[i]&0x7F)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+// payload returns the payload of n bytes starting at b, following its
+// overflow pages, as a cell of a table or an index holds it.
+func (f sqliteFile) payload(b []byte, n int, index bool) []byte {
+	u := sqlitePageSize
+	x := u - 35
+	if index {
+		x = (u-12)*64/255 - 23
+	}
+	if n <= x {
+		return b[:n]
+	}
+	m := (u-12)*32/255 - 23
+	local := m + (n-m)%(u-4)
+	if local > x {
+		local = m
+	}
+	out := slices.Clone(b[:local])
+	for next := int(binary.BigEndian.Uint32(b[local:])); len(out) < n; {
+		pg := f.page(next)
+		out = append(out, pg[4:4+min(n-len(out), u-4)]...)
+		next = int(binary.BigEndian.Uint32(pg))
+	}
+	return out
+}
+
+// cells returns the kind of page pgno and its cells, and its right child.
+func (f sqliteFile) cells(pgno int) (byte, [][]byte, int) {
+	pg := f.page(pgno)
+	off := 0
+	if pgno == 1 {
+		off = 100
+	}
+	kind, hdr, right := pg[off], 8, 0
+	if kind == 0x02 || kind == 0x05 {
+		hdr, right = 12, int(binary.BigEndian.Uint32(pg[off+8:]))
+	}
+	n := int(binary.BigEndian.Uint16(pg[off+3:]))
+	cells := make([][]byte, n)
+	for i := range cells {
+		cells[i] = pg[binary.BigEndian.Uint16(pg[off+hdr+2*i:]):]
+	}
+	return kind, cells, right
+}
+
+// table returns the rowids and records of the table b-tree at root, in the
+// order of its pages.
+func (f sqliteFile) table(root int) ([]int64, [][]any) {
+	var ids []int64
+	var rows [][]any
+	var walk func(pgno int)
+	walk = func(pgno int) {
+		kind, cells, right := f.cells(pgno)
+		for _, c := range cells {
+			switch kind {
+			case 0x0D:
+				n, k := sqliteVarint(c)
+				id, l := sqliteVarint(c[k:])
+				ids = append(ids, int64(id))
+				rows = append(rows, f.record(f.payload(c[k+l:], int(n), false)))
+			case 0x05:
+				walk(int(binary.BigEndian.Uint32(c)))
+			default:
+				f.t.Fatalf("page %d of a table is of kind %#x", pgno, kind)
+			}
+		}
+		if kind == 0x05 {
+			walk(right)
+		}
+	}
+	walk(root)
+	return ids, rows
+}
+
+// index returns the keys of the index b-tree at root, in order.
+func (f sqliteFile) index(root int) [][]any {
+	var keys [][]any
+	var walk func(pgno int)
+	walk = func(pgno int) {
+		kind, cells, right := f.cells(pgno)
+		for _, c := range cells {
+			switch kind {
+			case 0x0A:
+			case 0x02:
+				walk(int(binary.BigEndian.Uint32(c)))
+				c = c[4:]
+			default:
+				f.t.Fatalf("page %d of an index is of kind %#x", pgno, kind)
+			}
+			n, k := sqliteVarint(c)
+			keys = append(keys, f.record(f.payload(c[k:], int(n), true)))
+		}
+		if kind == 0x02 {
+			walk(right)
+		}
+	}
+	walk(root)
+	return keys
+}
+
+// record decodes a record: integers as int64, reals as float64, text as
+// string and blobs as []byte.
+func (f sqliteFile) record(rec []byte) []any {
+	size, n := sqliteVarint(rec)
+	header, body := rec[n:size], rec[size:]
+	var vals []any
+	for len(header) > 0 {
+		typ, n := sqliteVarint(header)
+		header = header[n:]
+		var width int
+		switch {
+		case typ == 0:
+			vals = append(vals, nil)
+		case typ >= 1 && typ <= 6:
+			width = []int{1, 2, 3, 4, 6, 8}[typ-1]
+			v := int64(int8(body[0]))
+			for _, b := range body[1:width] {
+				v = v<<8 | int64(b)
+			}
+			vals = append(vals, v)
+		case typ == 7:
+			width = 8
+			vals = append(vals, math.Float64frombits(binary.BigEndian.Uint64(body)))
+		case typ == 8 || typ == 9:
+			vals = append(vals, int64(typ-8))
+		case typ >= 12 && typ%2 == 0:
+			width = int(typ-12) / 2
+			vals = append(vals, slices.Clone(body[:width]))
+		case typ >= 13:
+			width = int(typ-13) / 2
+			vals = append(vals, string(body[:width]))
+		default:
+			f.t.Fatalf("serial type %d", typ)
+		}
+		body = body[width:]
+	}
+	if len(body) != 0 {
+		f.t.Errorf("%d bytes left after a record", len(body))
+	}
+	return vals
+}
+
+// stored returns v as sqliteFile reads it back.
+func stored(v any) any {
+	if i, ok := sqliteInt(v); ok {
+		return i
+	}
+	if f, ok := v.(float32); ok {
+		return float64(f)
+	}
+	return v
+}
+
+func sameRecord(got, want []any) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i, v := range want {
+		switch v := stored(v).(type) {
+		case []byte:
+			if b, ok := got[i].([]byte); !ok || !bytes.Equal(b, v) {
+				return false
+			}
+		default:
+			if got[i] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TestWriteSQLite reads back a database of a table of every type of value,
+// some spilling to overflow pages, and one of rows enough for a b-tree of
+// interior pages and an index over it, given out of order.
+func TestWriteSQLite(t *testing.T) {
+	values := []any{nil, 0, 1, int8(-100), int16(30000), int32(-8_000_000), int64(2_000_000_000), int64(1) << 40,
+		int64(math.MinInt64), uint16(65535), true, false, float32(1.5), -2.25, "Mapunit Ω", []byte{0, 1, 2, 255}, ""}
+	every := SQLiteTable{Name: "every_type", SQL: "CREATE TABLE every_type (a, b)"}
+	for i, v := range values {
+		every.Rows = append(every.Rows, sqliteRow{int64(i + 1), []any{v, i}})
+	}
+	every.Rows = append(every.Rows,
+		sqliteRow{100, []any{bytes.Repeat([]byte{7, 8, 9}, 5000), "after"}},
+		sqliteRow{101, []any{strings.Repeat("long text ", 500), nil}})
+
+	many := SQLiteTable{Name: "many", SQL: "CREATE TABLE many (fid INTEGER PRIMARY KEY, name TEXT)",
+		Indexes: []sqliteIndex{{Name: "many_name", SQL: "CREATE INDEX many_name ON many (name)", Columns: []int{1}}}}
+	const nMany = 20000
+	for i := nMany - 1; i >= 0; i-- {
+		many.Rows = append(many.Rows, sqliteRow{int64(3*i + 1), []any{nil, fmt.Sprint("row ", i%5000)}})
+	}
+
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	if err := WriteSQLite(context.Background(), path, []SQLiteTable{every, many}, 0x47504B47, 10300); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := sqliteFile{t, b}
+
+	h := b[:100]
+	be := binary.BigEndian
+	if string(h[:16]) != "SQLite format 3\x00" || be.Uint16(h[16:]) != sqlitePageSize ||
+		int(be.Uint32(h[28:]))*sqlitePageSize != len(b) || be.Uint32(h[56:]) != 1 ||
+		be.Uint32(h[60:]) != 10300 || be.Uint32(h[68:]) != 0x47504B47 {
+		t.Fatalf("header % x", h)
+	}
+
+	ids, schema := f.table(1)
+	if !slices.Equal(ids, []int64{1, 2, 3}) {
+		t.Fatalf("schema rows %v", ids)
+	}
+	for i, want := range [][]any{
+		{"table", "every_type", "every_type", nil, every.SQL},
+		{"table", "many", "many", nil, many.SQL},
+		{"index", "many_name", "many", nil, many.Indexes[0].SQL},
+	} {
+		want[3] = schema[i][3]
+		if !sameRecord(schema[i], want) {
+			t.Errorf("schema row %d is %v, want %v", i+1, schema[i], want)
+		}
+	}
+
+	ids, rows := f.table(int(schema[0][3].(int64)))
+	if len(rows) != len(every.Rows) {
+		t.Fatalf("%d rows of every_type, want %d", len(rows), len(every.Rows))
+	}
+	for i, row := range every.Rows {
+		if ids[i] != row.RowID || !sameRecord(rows[i], row.Values) {
+			t.Errorf("row %d is %v, want %d: %v", ids[i], rows[i], row.RowID, row.Values)
+		}
+	}
+
+	ids, rows = f.table(int(schema[1][3].(int64)))
+	if len(ids) != nMany || !slices.IsSorted(ids) {
+		t.Fatalf("%d rows of many, sorted %v, want %d sorted", len(ids), slices.IsSorted(ids), nMany)
+	}
+	for i, id := range ids {
+		if want := []any{nil, fmt.Sprint("row ", i%5000)}; id != int64(3*i+1) || !sameRecord(rows[i], want) {
+			t.Fatalf("row %d is %v, want %d: %v", id, rows[i], 3*i+1, want)
+		}
+	}
+
+	keys := f.index(int(schema[2][3].(int64)))
+	want := make([][]any, nMany)
+	for i := range want {
+		want[i] = []any{fmt.Sprint("row ", i%5000), int64(3*i + 1)}
+	}
+	sort.Slice(want, func(i, j int) bool {
+		if want[i][0] != want[j][0] {
+			return want[i][0].(string) < want[j][0].(string)
+		}
+		return want[i][1].(int64) < want[j][1].(int64)
+	})
+	if len(keys) != nMany {
+		t.Fatalf("%d keys of many_name, want %d", len(keys), nMany)
+	}
+	for i := range keys {
+		if !sameRecord(keys[i], want[i]) {
+			t.Fatalf("key %d is %v, want %v", i, keys[i], want[i])
+		}
+	}
+}
+
+// TestWriteSQLiteUnsupported checks that a value SQLite has no storage class
+// for fails the write, leaving no file behind.
+func TestWriteSQLiteUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	tables := []SQLiteTable{{Name: "t", SQL: "CREATE TABLE t (a)", Rows: []sqliteRow{{1, []any{uint64(1)}}}}}
+	err := WriteSQLite(context.Background(), path, tables, 0, 0)
+	if err == nil || !strings.Contains(err.Error(), "uint64") {
+		t.Errorf("error %v, want one naming uint64", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s written: %v", path, err)
+	}
+}
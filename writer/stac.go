@@ -0,0 +1,169 @@
+package writer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// stacExtensions are the extensions of the STAC Items written: projection,
+// for the coordinate system and grid of the raster, and raster, for its
+// band.
+var stacExtensions = []string{
+	"https://stac-extensions.github.io/projection/v1.1.0/schema.json",
+	"https://stac-extensions.github.io/raster/v1.1.0/schema.json",
+}
+
+// stacItem is a STAC Item, of the STAC 1.0 specification.
+type stacItem struct {
+	Type           string                 `json:"type"`
+	STACVersion    string                 `json:"stac_version"`
+	STACExtensions []string               `json:"stac_extensions"`
+	ID             string                 `json:"id"`
+	Geometry       map[string]interface{} `json:"geometry"`
+	BBox           []float64              `json:"bbox,omitempty"`
+	Properties     stacProperties         `json:"properties"`
+	Links          []interface{}          `json:"links"`
+	Assets         map[string]stacAsset   `json:"assets"`
+}
+
+type stacProperties struct {
+	Datetime  string     `json:"datetime"`
+	EPSG      *int       `json:"proj:epsg"` // null for a coordinate system with no EPSG code
+	Shape     [2]int     `json:"proj:shape"`
+	Transform [6]float64 `json:"proj:transform"`
+	ProjBBox  [4]float64 `json:"proj:bbox"`
+}
+
+type stacAsset struct {
+	Href  string       `json:"href"`
+	Type  string       `json:"type"`
+	Roles []string     `json:"roles"`
+	Bands []stacRaster `json:"raster:bands"`
+}
+
+type stacRaster struct {
+	DataType   string      `json:"data_type"`
+	NoData     interface{} `json:"nodata"` // a number, or "nan"
+	Resolution float64     `json:"spatial_resolution"`
+	Scale      *float64    `json:"scale,omitempty"`
+	Offset     *float64    `json:"offset,omitempty"`
+	Unit       string      `json:"unit,omitempty"`
+}
+
+// ErrNoFootprint is the error of WriteSTACItem for an Item written without a
+// footprint, its coordinate system not being one it can project from.
+var ErrNoFootprint = errors.New("no footprint")
+
+// footprintPoints is the points along each edge of the footprint of a
+// raster, for its outline to follow the curve projected edges take.
+const footprintPoints = 16
+
+// STACItemPath returns where WriteSTACItem puts the Item of the GeoTIFF at
+// path: beside it, its extension .json.
+func STACItemPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+}
+
+// WriteSTACItem writes the STAC Item of the GeoTIFF at path, of band rb with
+// the coordinate system wkt, for the raster to be added to a STAC catalog as
+// it is: its footprint and bounding box in longitude and latitude, its grid
+// in the projection extension, its data type, nodata, any scale and offset
+// its pixels are packed by and the unit of their values in the raster
+// extension and the GeoTIFF as its asset, cog if it is cloud optimized. Its
+// datetime is that of the rescue, Now, as the date the data was taken is
+// not kept in a geodatabase. A coordinate system with a projection toLonLat
+// does not know of leaves out the footprint and bounding box: the Item is
+// written and an error wrapping ErrNoFootprint returned.
+func WriteSTACItem(path string, rb *raster.RasterBase, noData float64, wkt string, cog bool) error {
+	gt := rb.GeoTransform
+	w, h := int(rb.BandWidth), int(rb.BandHeight)
+	minX, maxY := gt[0], gt[3]
+	maxX, minY := gt[0]+float64(w)*gt[1], gt[3]+float64(h)*gt[5]
+
+	item := stacItem{
+		Type:           "Feature",
+		STACVersion:    "1.0.0",
+		STACExtensions: stacExtensions,
+		ID:             strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Properties: stacProperties{
+			Datetime:  Now().UTC().Format(time.RFC3339),
+			Shape:     [2]int{h, w},
+			Transform: [6]float64{gt[1], gt[2], gt[0], gt[4], gt[5], gt[3]},
+			ProjBBox:  [4]float64{minX, minY, maxX, maxY},
+		},
+		Links: make([]interface{}, 0),
+	}
+	if code := raster.EPSGCode(wkt); code > 0 {
+		item.Properties.EPSG = &code
+	}
+
+	mediaType := "image/tiff; application=geotiff"
+	if cog {
+		mediaType += "; profile=cloud-optimized"
+	}
+	band := stacRaster{DataType: rb.DataType, NoData: noData, Resolution: gt[1], Unit: rb.Unit}
+	if math.IsNaN(noData) {
+		band.NoData = "nan"
+	}
+	if rb.DataType == "1bit" || rb.DataType == "4bit" {
+		band.DataType = "uint8"
+	}
+	if rb.Scale != 0 {
+		band.Scale, band.Offset = &rb.Scale, &rb.Offset
+	}
+	item.Assets = map[string]stacAsset{"data": {
+		Href:  filepath.Base(path),
+		Type:  mediaType,
+		Roles: []string{"data"},
+		Bands: []stacRaster{band},
+	}}
+
+	inv, projErr := toLonLat(wkt)
+	if wkt == "" {
+		projErr = nil // an unknown coordinate system has no footprint to give
+	} else if projErr == nil {
+		// The outline of the raster, counterclockwise as GeoJSON has rings,
+		// each edge in footprintPoints steps.
+		corners := [][2]float64{{minX, minY}, {maxX, minY}, {maxX, maxY}, {minX, maxY}, {minX, minY}}
+		ring := make([][]float64, 0, 4*footprintPoints+1)
+		bbox := []float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		for i := 0; i < 4; i++ {
+			a, b := corners[i], corners[i+1]
+			for j := 0; j < footprintPoints; j++ {
+				t := float64(j) / footprintPoints
+				lon, lat := inv(a[0]+t*(b[0]-a[0]), a[1]+t*(b[1]-a[1]))
+				lon, lat = roundDegrees(lon), roundDegrees(lat)
+				ring = append(ring, []float64{lon, lat})
+				bbox = []float64{min(bbox[0], lon), min(bbox[1], lat), max(bbox[2], lon), max(bbox[3], lat)}
+			}
+		}
+		ring = append(ring, ring[0])
+		item.Geometry = map[string]interface{}{"type": "Polygon", "coordinates": [][][]float64{ring}}
+		item.BBox = bbox
+	}
+
+	b, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(STACItemPath(path), append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	if projErr != nil {
+		return fmt.Errorf("%w: %v", ErrNoFootprint, projErr)
+	}
+	return nil
+}
+
+// roundDegrees rounds v to 7 decimals, about a centimetre on the ground.
+func roundDegrees(v float64) float64 {
+	return math.Round(v*1e7) / 1e7
+}
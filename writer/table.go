@@ -0,0 +1,175 @@
+package writer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// csvValue formats a value of a gdb.Row for a CSV cell. Shapes are
+// written as WKT, binary values as hex and nulls as empty cells.
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case gdb.Geometry:
+		return WKT(v)
+	case []byte:
+		return hex.EncodeToString(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// WriteTableCSV writes every row of a table to path, with a header of the
+// object id column and the field names. Rows that cannot be decoded are
+// reported on stderr and left out. Once ctx is done it removes path and
+// returns ctx.Err().
+func WriteTableCSV(ctx context.Context, gdbFilePath string, tableName string, path string) error {
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, tableName)
+	if err != nil {
+		return err
+	}
+	defer bt.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := writeCSV(f, &bt); err != nil {
+		return abandon(f, err)
+	}
+	return f.Close()
+}
+
+// WriteCSV writes every row of a table to w as WriteTableCSV does, for a
+// pipe such as stdout.
+func WriteCSV(ctx context.Context, w io.Writer, gdbFilePath string, tableName string) error {
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, tableName)
+	if err != nil {
+		return err
+	}
+	defer bt.Close()
+	return writeCSV(w, &bt)
+}
+
+func writeCSV(out io.Writer, bt *gdb.BaseTable) error {
+	// The csv writer keeps the first error, which Error returns at the end.
+	w := csv.NewWriter(out)
+
+	oidName := bt.OIDName
+	if oidName == "" {
+		oidName = "OBJECTID"
+	}
+	header := []string{oidName}
+	for _, fld := range bt.Fields {
+		header = append(header, fld.Name)
+	}
+	w.Write(header)
+
+	rows := bt.Rows()
+	for row := range rows.All() {
+		record := []string{strconv.Itoa(row.FID)}
+		for _, v := range row.Values {
+			record = append(record, csvValue(v))
+		}
+		w.Write(record)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// sqliteColumnType picks the declared type of the column for fld, or returns
+// "" for fields that have no column: raster fields.
+func sqliteColumnType(fld *gdb.Field) string {
+	switch fld.Type {
+	case 0, 1, 13:
+		return "INTEGER"
+	case 2, 3:
+		return "REAL"
+	case 4, 5, 7, 10, 11, 12, 14, 15, 16:
+		return "TEXT"
+	case 8:
+		return "BLOB"
+	}
+	return ""
+}
+
+// sqliteValue converts a value of a gdb.Row for a SQLite column:
+// datetimes, dates and times as ISO 8601 text, GUIDs as text and shapes as
+// WKT, so that the database needs no extension to read.
+func sqliteValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Format("2006-01-02T15:04:05.000Z")
+	case gdb.Date:
+		return v.String()
+	case gdb.TimeOfDay:
+		return v.String()
+	case gdb.GUID:
+		return v.String()
+	case gdb.Geometry:
+		if wkt := WKT(v); wkt != "" {
+			return wkt
+		}
+		return nil
+	}
+	return v
+}
+
+// NewSQLiteTable reads the rows of a table into a SQLite table called name,
+// with the object id as its rowid. Rows that cannot be decoded are reported on
+// stderr and left out. It stops with ctx.Err() once ctx is done.
+func NewSQLiteTable(ctx context.Context, gdbFilePath string, tableName string, name string) (SQLiteTable, error) {
+	bt, err := gdb.NewBaseTableContext(ctx, gdbFilePath, tableName)
+	if err != nil {
+		return SQLiteTable{}, err
+	}
+	defer bt.Close()
+
+	oidName := bt.OIDName
+	if oidName == "" {
+		oidName = "OBJECTID"
+	}
+	cols := []string{sqlIdent(oidName) + " INTEGER PRIMARY KEY"}
+	fieldIndexes := make([]int, 0)
+	for i := range bt.Fields {
+		if typ := sqliteColumnType(&bt.Fields[i]); typ != "" {
+			cols = append(cols, sqlIdent(bt.Fields[i].Name)+" "+typ)
+			fieldIndexes = append(fieldIndexes, i)
+		}
+	}
+	t := SQLiteTable{Name: name, SQL: "CREATE TABLE " + sqlIdent(name) + " (" + strings.Join(cols, ", ") + ")"}
+
+	rows := bt.Rows()
+	for row := range rows.All() {
+		// The object id column is the rowid, so the record holds NULL for it.
+		vals := []interface{}{nil}
+		for _, i := range fieldIndexes {
+			vals = append(vals, sqliteValue(row.Values[i]))
+		}
+		t.Rows = append(t.Rows, sqliteRow{int64(row.FID), vals})
+	}
+	if err := rows.Err(); err != nil {
+		return SQLiteTable{}, err
+	}
+	return t, nil
+}
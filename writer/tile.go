@@ -0,0 +1,183 @@
+package writer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// TileSize is the width and height of the tiles a Tiler renders, in pixels.
+const TileSize = 256
+
+// tileStep is how far apart, in pixels of a tile, are the points a Tiler
+// projects exactly; the pixels between them are placed by interpolating, as
+// gdalwarp's approximate transformer places them.
+const tileStep = 16
+
+// A Tiler renders a band as the tiles of web maps: the XYZ tiles of Web
+// Mercator, TileSize pixels a side, zoom level z having 2^z by 2^z of them,
+// numbered from the north-west. Each pixel of a tile takes the pixel of the
+// band its center falls in, the band being in a coordinate system toLonLat
+// knows.
+type Tiler struct {
+	rb  raster.RasterBase
+	inv inverse
+}
+
+// NewTiler returns the Tiler of band rb, whose coordinate system is wkt.
+func NewTiler(rb *raster.RasterBase, wkt string) (*Tiler, error) {
+	if wkt == "" {
+		return nil, fmt.Errorf("no coordinate system to place the tiles by")
+	}
+	inv, err := toLonLat(wkt)
+	if err != nil {
+		return nil, err
+	}
+	return &Tiler{rb: *rb, inv: inv}, nil
+}
+
+// A Tile is tile z/x/y of a Tiler, with where in the band the points of the
+// grid tileStep apart across it fall.
+type Tile struct {
+	t   *Tiler
+	pts [][2]float64 // row by row, NaN where the projection has no answer
+}
+
+// Tile returns tile z/x/y of t, an error if there is none such.
+func (t *Tiler) Tile(z, x, y int) (*Tile, error) {
+	n := 1 << min(max(z, 0), 30)
+	if z < 0 || z > 30 || x < 0 || x >= n || y < 0 || y >= n {
+		return nil, fmt.Errorf("no tile %d/%d/%d", z, x, y)
+	}
+	const side = TileSize/tileStep + 1
+	tl := &Tile{t: t, pts: make([][2]float64, side*side)}
+	gt := t.rb.GeoTransform
+	// Newton's method starts from the middle of the band, then from the
+	// point found before.
+	px, py := gt[0]+gt[1]*float64(t.rb.BandWidth)/2, gt[3]+gt[5]*float64(t.rb.BandHeight)/2
+	for j := range side {
+		for i := range side {
+			lon := (float64(x)+float64(i*tileStep)/TileSize)/float64(n)*360 - 180
+			lat := math.Atan(math.Sinh(math.Pi*(1-2*(float64(y)+float64(j*tileStep)/TileSize)/float64(n)))) * 180 / math.Pi
+			bx, by, ok := t.project(lon, lat, px, py)
+			if !ok {
+				tl.pts[j*side+i] = [2]float64{math.NaN(), math.NaN()}
+				continue
+			}
+			tl.pts[j*side+i] = [2]float64{bx, by}
+			px, py = bx, by
+		}
+	}
+	return tl, nil
+}
+
+// project returns the point of the coordinate system of the band at lon,
+// lat, found by Newton's method on the inverse projection from x, y, and
+// false if it is not found.
+func (t *Tiler) project(lon, lat, x, y float64) (float64, float64, bool) {
+	h := math.Abs(t.rb.GeoTransform[1])
+	for range 30 {
+		lon0, lat0 := t.inv(x, y)
+		lonX, latX := t.inv(x+h, y)
+		lonY, latY := t.inv(x, y+h)
+		// The partial derivatives of longitude and latitude in x and y.
+		a, c := math.Remainder(lonX-lon0, 360)/h, (latX-lat0)/h
+		b, d := math.Remainder(lonY-lon0, 360)/h, (latY-lat0)/h
+		det := a*d - b*c
+		if det == 0 || math.IsNaN(det) {
+			return x, y, false
+		}
+		dlon, dlat := math.Remainder(lon-lon0, 360), lat-lat0
+		dx, dy := (d*dlon-b*dlat)/det, (a*dlat-c*dlon)/det
+		x, y = x+dx, y+dy
+		if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+			return x, y, false
+		}
+		if math.Abs(dx) < h/1000 && math.Abs(dy) < h/1000 {
+			return x, y, true
+		}
+	}
+	return x, y, false
+}
+
+// Window returns the window of the band, minx, miny, maxx, maxy, to read for
+// tl, nil if tl does not overlap the band.
+func (tl *Tile) Window() []float64 {
+	gt := tl.t.rb.GeoTransform
+	win := []float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range tl.pts {
+		if !math.IsNaN(p[0]) {
+			win[0], win[1] = min(win[0], p[0]), min(win[1], p[1])
+			win[2], win[3] = max(win[2], p[0]), max(win[3], p[1])
+		}
+	}
+	// A pixel more all round, for those the interpolated points fall in
+	// beyond the points projected.
+	px, py := math.Abs(gt[1]), math.Abs(gt[5])
+	x0, x1 := gt[0], gt[0]+gt[1]*float64(tl.t.rb.BandWidth)
+	y0, y1 := gt[3]+gt[5]*float64(tl.t.rb.BandHeight), gt[3]
+	win = []float64{max(win[0]-px, min(x0, x1)), max(win[1]-py, min(y0, y1)), min(win[2]+px, max(x0, x1)), min(win[3]+py, max(y0, y1))}
+	if win[0] >= win[2] || win[1] >= win[3] {
+		return nil
+	}
+	return win
+}
+
+// WritePNG writes tl as a PNG to w, its pixels taken from rd, the band of
+// the Tiler read over Window, or left transparent for nil. The unpacked
+// values are stretched over shades of gray as sc says, percentiles being of
+// the values in the tile, unless the band has a palette; nodata is
+// transparent.
+func (tl *Tile) WritePNG(w io.Writer, rd *raster.RasterData, sc Scale) error {
+	img := image.NewNRGBA(image.Rect(0, 0, TileSize, TileSize))
+	if rd != nil {
+		tl.render(img, rd, sc)
+	}
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	return enc.Encode(w, img)
+}
+
+func (tl *Tile) render(img *image.NRGBA, rd *raster.RasterData, sc Scale) {
+	const side = TileSize/tileStep + 1
+	rb := &rd.RasBase
+	gt := rb.GeoTransform
+	w, h := int(rb.BandWidth), int(rb.BandHeight)
+	raw := make([]float64, TileSize*TileSize)
+	vals := make(raster.Buffer[float64], TileSize*TileSize)
+	for v := range TileSize {
+		j, fv := v/tileStep, (float64(v%tileStep)+0.5)/tileStep
+		for u := range TileSize {
+			i, fu := u/tileStep, (float64(u%tileStep)+0.5)/tileStep
+			k := v*TileSize + u
+			raw[k], vals[k] = math.NaN(), math.NaN()
+			p00, p10 := tl.pts[j*side+i], tl.pts[j*side+i+1]
+			p01, p11 := tl.pts[(j+1)*side+i], tl.pts[(j+1)*side+i+1]
+			bx := (p00[0]*(1-fu)+p10[0]*fu)*(1-fv) + (p01[0]*(1-fu)+p11[0]*fu)*fv
+			by := (p00[1]*(1-fu)+p10[1]*fu)*(1-fv) + (p01[1]*(1-fu)+p11[1]*fu)*fv
+			col, row := math.Floor((bx-gt[0])/gt[1]), math.Floor((by-gt[3])/gt[5])
+			if !(col >= 0 && col < float64(w) && row >= 0 && row < float64(h)) {
+				continue
+			}
+			if p := rd.GeoData.Float64(int(row)*w + int(col)); p != rd.NoData && !math.IsNaN(p) {
+				raw[k], vals[k] = p, rb.Unpack(p)
+			}
+		}
+	}
+
+	gray := sc.stretch(vals, math.NaN(), func(v float64) float64 { return v })
+	for k, g := range gray {
+		if g == 0 {
+			continue
+		}
+		c := color.NRGBA{g, g, g, 255}
+		if pc, ok := rb.ColorMap[int64(raw[k])]; ok {
+			c = color.NRGBA{pc.R, pc.G, pc.B, 255}
+		}
+		img.SetNRGBA(k%TileSize, k/TileSize, c)
+	}
+}
@@ -0,0 +1,105 @@
+package writer
+
+import (
+	"cmp"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/raster"
+)
+
+// vrtDataset is the GDAL virtual raster WriteVRT writes, stacking bands kept
+// in files of their own.
+type vrtDataset struct {
+	XMLName      xml.Name  `xml:"VRTDataset"`
+	Width        int       `xml:"rasterXSize,attr"`
+	Height       int       `xml:"rasterYSize,attr"`
+	SRS          *vrtSRS   `xml:"SRS"`
+	GeoTransform string    `xml:"GeoTransform"`
+	Bands        []vrtBand `xml:"VRTRasterBand"`
+}
+
+type vrtSRS struct {
+	Mapping string `xml:"dataAxisToSRSAxisMapping,attr"`
+	WKT     string `xml:",chardata"`
+}
+
+type vrtBand struct {
+	DataType    string    `xml:"dataType,attr"`
+	Band        int       `xml:"band,attr"`
+	NoData      string    `xml:"NoDataValue"`
+	ColorInterp string    `xml:"ColorInterp"`
+	Source      vrtSource `xml:"SimpleSource"`
+}
+
+type vrtSource struct {
+	Filename vrtFilename `xml:"SourceFilename"`
+	Band     int         `xml:"SourceBand"`
+}
+
+type vrtFilename struct {
+	Relative int    `xml:"relativeToVRT,attr"`
+	Path     string `xml:",chardata"`
+}
+
+// vrtDataTypes are the names VRT gives the data types of bands.
+var vrtDataTypes = map[string]string{
+	"1bit": "Byte", "4bit": "Byte", "uint8": "Byte", "int8": "Int8", "int16": "Int16", "uint16": "UInt16",
+	"int32": "Int32", "uint32": "UInt32", "float32": "Float32", "64bit": "Float64",
+}
+
+// VRTBand is a band WriteVRT stacks: the GeoTIFF at Path, of the band RB
+// with the nodata value NoData.
+type VRTBand struct {
+	Path   string
+	RB     *raster.RasterBase
+	NoData float64
+}
+
+// VRTPath returns where WriteVRT puts the virtual raster of the bands of a
+// raster extracted to out: beside them, its extension .vrt.
+func VRTPath(out string) string {
+	return strings.TrimSuffix(out, filepath.Ext(out)) + ".vrt"
+}
+
+// WriteVRT writes a GDAL virtual raster at path stacking bands, which share
+// the grid of the first, in the coordinate system wkt, each shown as its
+// color interpretation says: the three or four bands of an RGB image open
+// in GDAL and QGIS as the image they were, rather than as a GeoTIFF each.
+// The bands are referred to relative to path, for it to move with them.
+func WriteVRT(path string, bands []VRTBand, wkt string) error {
+	if len(bands) == 0 {
+		return nil
+	}
+	rb := bands[0].RB
+	gt := make([]string, 6)
+	for i, v := range rb.GeoTransform {
+		gt[i] = csvValue(v)
+	}
+	ds := vrtDataset{Width: int(rb.BandWidth), Height: int(rb.BandHeight), GeoTransform: strings.Join(gt, ", ")}
+	if wkt != "" {
+		ds.SRS = &vrtSRS{Mapping: "1,2", WKT: wkt}
+	}
+	dir := filepath.Dir(path)
+	for i, b := range bands {
+		rel, err := filepath.Rel(dir, b.Path)
+		if err != nil {
+			return err
+		}
+		ds.Bands = append(ds.Bands, vrtBand{
+			DataType:    vrtDataTypes[b.RB.DataType],
+			Band:        i + 1,
+			NoData:      formatNoData(b.NoData),
+			ColorInterp: cmp.Or(b.RB.ColorInterp, "Gray"),
+			Source:      vrtSource{Filename: vrtFilename{Relative: 1, Path: filepath.ToSlash(rel)}, Band: 1},
+		})
+	}
+
+	out, err := xml.MarshalIndent(ds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), 0644)
+}
@@ -0,0 +1,77 @@
+package writer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/albrazeau/goRasterRescue/gdb"
+)
+
+// WKT converts a shape into well-known text, or "" for empty and
+// unsupported shapes. Lines and polygons come out as their single types when
+// they have one part, like geoJSONGeometry.
+func WKT(g gdb.Geometry) string {
+	if len(g.Parts) == 0 {
+		return ""
+	}
+
+	dims := ""
+	switch {
+	case g.Z != nil && g.M != nil:
+		dims = " ZM"
+	case g.Z != nil:
+		dims = " Z"
+	case g.M != nil:
+		dims = " M"
+	}
+	coords := func(p int, i int) string {
+		s := strconv.FormatFloat(g.Parts[p][i][0], 'f', -1, 64) + " " + strconv.FormatFloat(g.Parts[p][i][1], 'f', -1, 64)
+		if g.Z != nil {
+			s += " " + strconv.FormatFloat(g.Z[p][i], 'f', -1, 64)
+		}
+		if g.M != nil {
+			s += " " + strconv.FormatFloat(g.M[p][i], 'f', -1, 64)
+		}
+		return s
+	}
+	part := func(p int) string {
+		pts := make([]string, len(g.Parts[p]))
+		for i := range pts {
+			pts[i] = coords(p, i)
+		}
+		return "(" + strings.Join(pts, ", ") + ")"
+	}
+
+	switch {
+	case gdb.IsPointType(g.Type):
+		return "POINT" + dims + " (" + coords(0, 0) + ")"
+	case gdb.IsMultiPointType(g.Type):
+		pts := make([]string, len(g.Parts[0]))
+		for i := range pts {
+			pts[i] = "(" + coords(0, i) + ")"
+		}
+		return "MULTIPOINT" + dims + " (" + strings.Join(pts, ", ") + ")"
+	case gdb.IsPolylineType(g.Type) && len(g.Parts) == 1:
+		return "LINESTRING" + dims + " " + part(0)
+	case gdb.IsPolylineType(g.Type):
+		lines := make([]string, len(g.Parts))
+		for p := range lines {
+			lines[p] = part(p)
+		}
+		return "MULTILINESTRING" + dims + " (" + strings.Join(lines, ", ") + ")"
+	case gdb.IsPolygonType(g.Type):
+		polygons := make([]string, 0)
+		for _, rings := range gdb.PolygonRings(g) {
+			rs := make([]string, len(rings))
+			for i, p := range rings {
+				rs[i] = part(p)
+			}
+			polygons = append(polygons, "("+strings.Join(rs, ", ")+")")
+		}
+		if len(polygons) == 1 {
+			return "POLYGON" + dims + " " + polygons[0]
+		}
+		return "MULTIPOLYGON" + dims + " (" + strings.Join(polygons, ", ") + ")"
+	}
+	return ""
+}
@@ -0,0 +1,693 @@
+package writer
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// A Zstandard compressor (RFC 8878), enough for the strips of GeoTIFFs.
+// Matches are found greedily through a hash of the 4 bytes at each
+// position; the literals are Huffman coded and the sequences FSE coded with
+// the predefined tables or tables fitted to the block, whichever comes out
+// smaller. Blocks that do not shrink are stored raw.
+
+const (
+	zstdMagic     = 0xFD2FB528
+	zstdBlockSize = 128 << 10
+	zstdMinMatch  = 4
+	zstdHashLog   = 17
+	huffMaxBits   = 11
+)
+
+// Baselines and extra bits of the literal length and match length codes.
+var (
+	zstdLLBase = [...]uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	zstdLLBits = [...]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	zstdMLBase = [...]uint32{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	zstdMLBits = [...]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// The predefined distributions of the literal length, match length and
+// offset codes, with -1 for a probability below one.
+var (
+	zstdLLDefault = []int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1}
+	zstdMLDefault = []int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1, -1, -1}
+	zstdOFDefault = []int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
+
+	zstdLLPredefined = newFSEEncoder(zstdLLDefault, 6)
+	zstdMLPredefined = newFSEEncoder(zstdMLDefault, 6)
+	zstdOFPredefined = newFSEEncoder(zstdOFDefault, 5)
+)
+
+// zstdCompress appends src to dst as a single Zstandard frame, of one
+// segment holding its size, as TIFF's ZSTD compression has each strip.
+func zstdCompress(dst, src []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, zstdMagic)
+	dst = append(dst, 0xA0) // single segment, 4 byte content size
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(src)))
+
+	e := zstdEncoder{table: make([]int32, 1<<zstdHashLog)}
+	for start := 0; ; start += zstdBlockSize {
+		end := min(start+zstdBlockSize, len(src))
+		last := uint32(0)
+		if end == len(src) {
+			last = 1
+		}
+		at := len(dst)
+		dst = append(dst, 0, 0, 0)
+		dst = e.block(dst, src, start, end)
+		if size := len(dst) - at - 3; size < end-start {
+			putUint24(dst[at:], last|2<<1|uint32(size)<<3)
+		} else {
+			dst = append(dst[:at+3], src[start:end]...)
+			putUint24(dst[at:], last|uint32(end-start)<<3)
+		}
+		if last == 1 {
+			return dst
+		}
+	}
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+type zstdSequence struct {
+	litLen, matchLen, offset uint32
+}
+
+type zstdEncoder struct {
+	table []int32 // 1 + the last position with the 4 bytes hashing to each entry
+	seqs  []zstdSequence
+	lits  []byte
+}
+
+func (e *zstdEncoder) insert(src []byte, i int) (int, uint32) {
+	v := binary.LittleEndian.Uint32(src[i:])
+	h := (v * 2654435761) >> (32 - zstdHashLog)
+	cand := int(e.table[h]) - 1
+	e.table[h] = int32(i + 1)
+	return cand, v
+}
+
+// block appends the compressed block of src[start:end], matched against
+// all of src before end.
+func (e *zstdEncoder) block(dst []byte, src []byte, start, end int) []byte {
+	e.seqs, e.lits = e.seqs[:0], e.lits[:0]
+	anchor := start
+	for i := start; i+zstdMinMatch <= end; {
+		cand, v := e.insert(src, i)
+		if cand < 0 || binary.LittleEndian.Uint32(src[cand:]) != v {
+			i++
+			continue
+		}
+		n := zstdMinMatch
+		for i+n < end && src[cand+n] == src[i+n] {
+			n++
+		}
+		for i > anchor && cand > 0 && src[i-1] == src[cand-1] {
+			i, cand, n = i-1, cand-1, n+1
+		}
+		e.lits = append(e.lits, src[anchor:i]...)
+		e.seqs = append(e.seqs, zstdSequence{uint32(i - anchor), uint32(n), uint32(i - cand)})
+		for j := i + 1; j < i+n && j+zstdMinMatch <= end; j++ {
+			e.insert(src, j)
+		}
+		i += n
+		anchor = i
+	}
+	e.lits = append(e.lits, src[anchor:end]...)
+	dst = zstdLiterals(dst, e.lits)
+	return e.sequences(dst)
+}
+
+// zstdLiteralsHeader appends the header of a literals section of type typ
+// regenerating n bytes, for raw and RLE literals.
+func zstdLiteralsHeader(dst []byte, typ byte, n int) []byte {
+	switch {
+	case n < 1<<5:
+		return append(dst, typ|byte(n)<<3)
+	case n < 1<<12:
+		return append(dst, typ|1<<2|byte(n)<<4, byte(n>>4))
+	}
+	return append(dst, typ|3<<2|byte(n)<<4, byte(n>>4), byte(n>>12))
+}
+
+// zstdLiterals appends the literals section of lits: Huffman coded, in one
+// stream or four, unless they are all the same byte or do not shrink.
+func zstdLiterals(dst, lits []byte) []byte {
+	n := len(lits)
+	raw := func() []byte {
+		return append(zstdLiteralsHeader(dst, 0, n), lits...)
+	}
+	if n < 64 {
+		return raw()
+	}
+	counts := make([]int, 256)
+	for _, b := range lits {
+		counts[b]++
+	}
+	maxSymbol, distinct := 0, 0
+	for s, c := range counts {
+		if c > 0 {
+			maxSymbol, distinct = s, distinct+1
+		}
+	}
+	if distinct == 1 {
+		return append(zstdLiteralsHeader(dst, 1, n), lits[0])
+	}
+	counts = counts[:maxSymbol+1]
+
+	lengths := huffmanLengths(counts, huffMaxBits)
+	desc := huffmanDescription(lengths)
+	if desc == nil {
+		return raw()
+	}
+	codes := huffmanCodes(lengths)
+	body := desc
+	if n < 1<<10 {
+		body = huffmanStream(body, lits, codes, lengths)
+	} else {
+		seg := (n + 3) / 4
+		jump := len(body)
+		body = append(body, 0, 0, 0, 0, 0, 0)
+		for i := 0; i < 4; i++ {
+			at := len(body)
+			body = huffmanStream(body, lits[min(i*seg, n):min((i+1)*seg, n)], codes, lengths)
+			if i < 3 {
+				binary.LittleEndian.PutUint16(body[jump+2*i:], uint16(len(body)-at))
+			}
+		}
+	}
+	size := len(body)
+	if size >= n {
+		return raw()
+	}
+	switch {
+	case n < 1<<10:
+		h := 2 | uint32(n)<<4 | uint32(size)<<14
+		dst = append(dst, byte(h), byte(h>>8), byte(h>>16))
+	case n < 1<<14 && size < 1<<14:
+		dst = binary.LittleEndian.AppendUint32(dst, 2|2<<2|uint32(n)<<4|uint32(size)<<18)
+	default:
+		h := 2 | 3<<2 | uint64(n)<<4 | uint64(size)<<22
+		dst = append(dst, byte(h), byte(h>>8), byte(h>>16), byte(h>>24), byte(h>>32))
+	}
+	return append(dst, body...)
+}
+
+// huffmanLengths returns the lengths of the codes of a Huffman code for
+// symbols occurring counts times, none longer than limit bits, flattening
+// the counts until none is.
+func huffmanLengths(counts []int, limit int) []uint8 {
+	counts = slices.Clone(counts)
+	for {
+		syms := make([]int, 0, len(counts))
+		for s, c := range counts {
+			if c > 0 {
+				syms = append(syms, s)
+			}
+		}
+		slices.SortStableFunc(syms, func(a, b int) int { return counts[a] - counts[b] })
+
+		// The leaves in order of count, then the nodes joining them, which
+		// come out in order of count too.
+		n := len(syms)
+		weight := make([]int, 2*n-1)
+		parent := make([]int, 2*n-1)
+		for i, s := range syms {
+			weight[i] = counts[s]
+		}
+		leaf, node := 0, n
+		pick := func(next int) int {
+			if leaf < n && (node >= next || weight[leaf] <= weight[node]) {
+				leaf++
+				return leaf - 1
+			}
+			node++
+			return node - 1
+		}
+		for next := n; next < 2*n-1; next++ {
+			a := pick(next)
+			b := pick(next)
+			weight[next] = weight[a] + weight[b]
+			parent[a], parent[b] = next, next
+		}
+		depth := make([]int, 2*n-1)
+		for i := 2*n - 3; i >= 0; i-- {
+			depth[i] = depth[parent[i]] + 1
+		}
+
+		lengths := make([]uint8, len(counts))
+		longest := 0
+		for i, s := range syms {
+			lengths[s] = uint8(depth[i])
+			longest = max(longest, depth[i])
+		}
+		if longest <= limit {
+			return lengths
+		}
+		for s, c := range counts {
+			if c > 0 {
+				counts[s] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanCodes assigns the codes of the lengths as Zstandard does: the
+// longest first, in order of symbol within a length.
+func huffmanCodes(lengths []uint8) []uint16 {
+	longest := slices.Max(lengths)
+	codes := make([]uint16, len(lengths))
+	code := uint16(0)
+	for l := longest; l > 0; l-- {
+		for s, sl := range lengths {
+			if sl == l {
+				codes[s] = code
+				code++
+			}
+		}
+		code >>= 1
+	}
+	return codes
+}
+
+// huffmanDescription returns the description of the code with lengths as
+// the weights of every symbol but the last, FSE compressed or as 4-bit
+// values, or nil if they fit neither way.
+func huffmanDescription(lengths []uint8) []byte {
+	longest := slices.Max(lengths)
+	weights := make([]byte, len(lengths)-1)
+	for s := range weights {
+		if lengths[s] > 0 {
+			weights[s] = longest + 1 - lengths[s]
+		}
+	}
+	if desc := fseWeights(weights); desc != nil {
+		return desc
+	}
+	if len(weights) > 128 {
+		return nil
+	}
+	desc := []byte{byte(127 + len(weights))}
+	for i := 0; i < len(weights); i += 2 {
+		b := weights[i] << 4
+		if i+1 < len(weights) {
+			b |= weights[i+1]
+		}
+		desc = append(desc, b)
+	}
+	return desc
+}
+
+// fseWeights returns the weights of a Huffman code FSE compressed, behind
+// their size, or nil if they cannot be or come to 128 bytes or more.
+func fseWeights(weights []byte) []byte {
+	if len(weights) < 2 {
+		return nil
+	}
+	counts := make([]int, huffMaxBits+1)
+	for _, w := range weights {
+		counts[w]++
+	}
+	if slices.Max(counts) == len(weights) {
+		return nil
+	}
+	const tableLog = 6
+	norm := fseNormalize(counts, len(weights), tableLog)
+	enc := newFSEEncoder(norm, tableLog)
+
+	w := &bitWriter{b: []byte{0}}
+	writeNCount(w, norm, tableLog)
+	w.flush()
+
+	// Two states take turns, the first holding the first weight.
+	var s1, s2 uint32
+	i := len(weights)
+	if i%2 == 1 {
+		s1 = enc.init(weights[i-1])
+		s2 = enc.init(weights[i-2])
+		s1 = enc.encode(w, s1, weights[i-3])
+		i -= 3
+	} else {
+		s2 = enc.init(weights[i-1])
+		s1 = enc.init(weights[i-2])
+		i -= 2
+	}
+	for ; i > 0; i -= 2 {
+		s2 = enc.encode(w, s2, weights[i-1])
+		s1 = enc.encode(w, s1, weights[i-2])
+	}
+	enc.flush(w, s2)
+	enc.flush(w, s1)
+	w.close()
+	if len(w.b)-1 >= 128 {
+		return nil
+	}
+	w.b[0] = byte(len(w.b) - 1)
+	return w.b
+}
+
+// huffmanStream appends lits Huffman coded as a backward bit stream, read
+// from its end, so that the first literal is written last.
+func huffmanStream(dst []byte, lits []byte, codes []uint16, lengths []uint8) []byte {
+	w := &bitWriter{b: dst}
+	for i := len(lits) - 1; i >= 0; i-- {
+		w.add(uint64(codes[lits[i]]), uint(lengths[lits[i]]))
+	}
+	w.close()
+	return w.b
+}
+
+// sequences appends the sequences section of the block.
+func (e *zstdEncoder) sequences(dst []byte) []byte {
+	n := len(e.seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8)+0x80, byte(n))
+	default:
+		dst = binary.LittleEndian.AppendUint16(append(dst, 0xFF), uint16(n-0x7F00))
+	}
+	if n == 0 {
+		return dst
+	}
+
+	llCodes := make([]uint8, n)
+	mlCodes := make([]uint8, n)
+	ofCodes := make([]uint8, n)
+	for i, s := range e.seqs {
+		llCodes[i] = zstdCode(zstdLLBase[:], s.litLen)
+		mlCodes[i] = zstdCode(zstdMLBase[:], s.matchLen)
+		ofCodes[i] = uint8(bits.Len32(s.offset+3) - 1)
+	}
+	llMode, llEnc, llDesc := zstdTable(llCodes, len(zstdLLBase), zstdLLDefault, zstdLLPredefined, 9)
+	ofMode, ofEnc, ofDesc := zstdTable(ofCodes, 32, zstdOFDefault, zstdOFPredefined, 8)
+	mlMode, mlEnc, mlDesc := zstdTable(mlCodes, len(zstdMLBase), zstdMLDefault, zstdMLPredefined, 9)
+	dst = append(dst, llMode<<6|ofMode<<4|mlMode<<2)
+	dst = append(append(append(dst, llDesc...), ofDesc...), mlDesc...)
+
+	// The sequences go last to first, each with the extra bits of its
+	// codes, the decoder reading them back first to last.
+	w := &bitWriter{b: dst}
+	extra := func(i int) {
+		s := e.seqs[i]
+		w.add(uint64(s.litLen-zstdLLBase[llCodes[i]]), uint(zstdLLBits[llCodes[i]]))
+		w.add(uint64(s.matchLen-zstdMLBase[mlCodes[i]]), uint(zstdMLBits[mlCodes[i]]))
+		w.add(uint64(s.offset+3), uint(ofCodes[i]))
+	}
+	ml := mlEnc.init(mlCodes[n-1])
+	of := ofEnc.init(ofCodes[n-1])
+	ll := llEnc.init(llCodes[n-1])
+	extra(n - 1)
+	for i := n - 2; i >= 0; i-- {
+		of = ofEnc.encode(w, of, ofCodes[i])
+		ml = mlEnc.encode(w, ml, mlCodes[i])
+		ll = llEnc.encode(w, ll, llCodes[i])
+		extra(i)
+	}
+	mlEnc.flush(w, ml)
+	ofEnc.flush(w, of)
+	llEnc.flush(w, ll)
+	w.close()
+	return w.b
+}
+
+// zstdCode returns the code of v, a literal or match length, given the
+// baselines of the codes.
+func zstdCode(base []uint32, v uint32) uint8 {
+	c := len(base) - 1
+	for base[c] > v {
+		c--
+	}
+	return uint8(c)
+}
+
+// zstdTable picks how to code the symbols of codes, from an alphabet of
+// size symbols: as the one symbol they all are, with the predefined
+// distribution, or with one fitted to them, whichever takes fewer bits. It
+// returns the mode, the encoder and the description of the table.
+func zstdTable(codes []uint8, size int, predefined []int16, enc *fseEncoder, maxLog uint) (byte, *fseEncoder, []byte) {
+	counts := make([]int, size)
+	for _, c := range codes {
+		counts[c]++
+	}
+	distinct, last := 0, 0
+	for s, c := range counts {
+		if c > 0 {
+			distinct, last = distinct+1, s
+		}
+	}
+	if distinct == 1 {
+		return 1, &fseEncoder{}, []byte{byte(last)}
+	}
+	counts = counts[:last+1]
+
+	cost := func(norm []int16, tableLog uint) float64 {
+		bits := 0.0
+		for s, c := range counts {
+			if c == 0 {
+				continue
+			}
+			if s >= len(norm) || norm[s] == 0 {
+				return math.Inf(1)
+			}
+			bits += float64(c) * (float64(tableLog) - math.Log2(float64(max(norm[s], 1))))
+		}
+		return bits
+	}
+	tableLog := min(maxLog, max(5, uint(bits.Len(uint(len(codes)-1)))+1))
+	norm := fseNormalize(counts, len(codes), tableLog)
+	w := &bitWriter{}
+	writeNCount(w, norm, tableLog)
+	w.flush()
+	if cost(predefined, enc.tableLog) <= cost(norm, tableLog)+float64(8*len(w.b)) {
+		return 0, enc, nil
+	}
+	return 2, newFSEEncoder(norm, tableLog), w.b
+}
+
+// fseNormalize scales counts, totalling total, to a distribution over a
+// table of 1<<tableLog states, leaving no symbol that occurs without one.
+func fseNormalize(counts []int, total int, tableLog uint) []int16 {
+	size := 1 << tableLog
+	norm := make([]int16, len(counts))
+	sum := 0
+	largest := 0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		norm[s] = int16(max(1, c*size/total))
+		sum += int(norm[s])
+		if c > counts[largest] {
+			largest = s
+		}
+	}
+	if sum < size {
+		norm[largest] += int16(size - sum)
+		return norm
+	}
+	// Rounding the rare symbols up can overshoot; the surplus comes off the
+	// most likely symbols.
+	order := make([]int, 0, len(counts))
+	for s := range counts {
+		order = append(order, s)
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return int(norm[b]) - int(norm[a]) })
+	for sum > size {
+		for _, s := range order {
+			if sum == size || norm[s] <= 1 {
+				break
+			}
+			norm[s]--
+			sum--
+		}
+	}
+	return norm
+}
+
+// writeNCount writes the description of the FSE table of distribution norm,
+// as FSE_writeNCount does.
+func writeNCount(w *bitWriter, norm []int16, tableLog uint) {
+	size := 1 << tableLog
+	nbBits := tableLog + 1
+	remaining := size + 1
+	threshold := size
+	w.add(uint64(tableLog-5), 4)
+	previous0 := false
+	for s := 0; s < len(norm) && remaining > 1; {
+		if previous0 {
+			start := s
+			for s < len(norm) && norm[s] == 0 {
+				s++
+			}
+			for s >= start+24 {
+				start += 24
+				w.add(0xFFFF, 16)
+			}
+			for s >= start+3 {
+				start += 3
+				w.add(3, 2)
+			}
+			w.add(uint64(s-start), 2)
+		}
+		count := int(norm[s])
+		s++
+		limit := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++
+		if count >= threshold {
+			count += limit
+		}
+		n := nbBits
+		if count < limit {
+			n--
+		}
+		w.add(uint64(count), n)
+		previous0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+}
+
+// An fseEncoder codes symbols with an FSE table, as FSE_buildCTable builds
+// it. The zero value codes the one symbol of an RLE table, in no bits.
+type fseEncoder struct {
+	tableLog uint
+	states   []uint16
+	symbols  []fseSymbol
+}
+
+type fseSymbol struct {
+	deltaFindState int32
+	deltaNbBits    uint32
+}
+
+func newFSEEncoder(norm []int16, tableLog uint) *fseEncoder {
+	size := 1 << tableLog
+	mask := size - 1
+	step := size>>1 + size>>3 + 3
+	high := size - 1
+	symbolAt := make([]uint8, size)
+	cumul := make([]int, len(norm)+1)
+	for s, n := range norm {
+		if n == -1 {
+			cumul[s+1] = cumul[s] + 1
+			symbolAt[high] = uint8(s)
+			high--
+		} else {
+			cumul[s+1] = cumul[s] + int(n)
+		}
+	}
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			symbolAt[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	e := &fseEncoder{tableLog: tableLog, states: make([]uint16, size), symbols: make([]fseSymbol, len(norm))}
+	for u, s := range symbolAt {
+		e.states[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+	total := 0
+	for s, n := range norm {
+		switch n {
+		case 0:
+			e.symbols[s].deltaNbBits = uint32((tableLog+1)<<16 - uint(size))
+		case -1, 1:
+			e.symbols[s] = fseSymbol{int32(total - 1), uint32(tableLog<<16 - uint(size))}
+			total++
+		default:
+			maxBitsOut := tableLog - uint(bits.Len(uint(n-1))-1)
+			minStatePlus := uint(n) << maxBitsOut
+			e.symbols[s] = fseSymbol{int32(total - int(n)), uint32(maxBitsOut<<16 - minStatePlus)}
+			total += int(n)
+		}
+	}
+	return e
+}
+
+// init returns the state the last symbol coded, s, is decoded from.
+func (e *fseEncoder) init(s uint8) uint32 {
+	if e.states == nil {
+		return 0
+	}
+	sym := e.symbols[s]
+	nbBitsOut := (sym.deltaNbBits + 1<<15) >> 16
+	v := nbBitsOut<<16 - sym.deltaNbBits
+	return uint32(e.states[int32(v>>nbBitsOut)+sym.deltaFindState])
+}
+
+// encode writes the bits taking state to the state s is decoded from.
+func (e *fseEncoder) encode(w *bitWriter, state uint32, s uint8) uint32 {
+	if e.states == nil {
+		return 0
+	}
+	sym := e.symbols[s]
+	nbBitsOut := (state + sym.deltaNbBits) >> 16
+	w.add(uint64(state), uint(nbBitsOut))
+	return uint32(e.states[int32(state>>nbBitsOut)+sym.deltaFindState])
+}
+
+// flush writes state, for the decoder to start from.
+func (e *fseEncoder) flush(w *bitWriter, state uint32) {
+	w.add(uint64(state), e.tableLog)
+}
+
+// A bitWriter appends bits to b, from the least significant of each byte.
+type bitWriter struct {
+	b     []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) add(v uint64, n uint) {
+	w.acc |= (v & (1<<n - 1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.b = append(w.b, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// flush pads the bits written to a whole byte with zeros.
+func (w *bitWriter) flush() {
+	if w.nbits > 0 {
+		w.b = append(w.b, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+}
+
+// close ends a backward bit stream with the 1 bit its reader starts after.
+func (w *bitWriter) close() {
+	w.add(1, 1)
+	w.flush()
+}